@@ -7,148 +7,758 @@
 // 4. IR Generation (intermediate representation)
 // 5. Optimization (constant folding, dead code elimination)
 //
-// Future versions will add code generation for target architectures.
+// The pipeline itself lives in pkg/compiler; this driver only handles flags,
+// file I/O and presenting the result.
+//
+// "compiler run <files>" compiles the program and then executes its main
+// function with the IR interpreter instead of printing the IR.
+//
+// "compiler -o out.ir <files>" writes the optimized IR to a file; the
+// extension picks another format (.c, .wat, .ll, .s). "compiler build" is the
+// same with C as the default, writing to stdout without -o: it compiles the
+// program to C source, which any C compiler turns into an executable.
+// "compiler build -S" writes x86-64 assembly instead.
+//
+// "compiler --format <files>" prints the source in canonical layout;
+// --check lists the files that are not formatted instead.
+//
+// "compiler --cache dir ..." keeps the compiled IR of each program in dir
+// and, compiling the same program with the same flags again, uses it
+// instead of compiling (see internal/cache).
+//
+// "compiler repl" reads lines from stdin and evaluates them as they come
+// (see internal/repl).
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/binary"
+	"flag"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 
+	"github.com/hassan/compiler/internal/cache"
+	"github.com/hassan/compiler/internal/codegen/amd64"
+	cgen "github.com/hassan/compiler/internal/codegen/c"
+	"github.com/hassan/compiler/internal/codegen/llvm"
+	"github.com/hassan/compiler/internal/codegen/wasm"
+	"github.com/hassan/compiler/internal/errors"
+	"github.com/hassan/compiler/internal/formatter"
+	"github.com/hassan/compiler/internal/interp"
 	"github.com/hassan/compiler/internal/ir"
 	"github.com/hassan/compiler/internal/lexer"
 	"github.com/hassan/compiler/internal/optimizer"
 	"github.com/hassan/compiler/internal/parser"
 	"github.com/hassan/compiler/internal/parser/ast"
-	"github.com/hassan/compiler/internal/semantic"
+	"github.com/hassan/compiler/internal/repl"
+	"github.com/hassan/compiler/pkg/compiler"
 )
 
-func main() {
-	// Check command line arguments
-	if len(os.Args) < 2 {
-		fmt.Fprintf(os.Stderr, "Usage: %s <source-file>\n", os.Args[0])
-		os.Exit(1)
+// driver is one invocation of the compiler: the flags it was given and
+// where its output goes.
+//
+// DESIGN CHOICE: The flags are fields of a driver rather than package
+// variables registered on flag.CommandLine, and everything that prints is
+// a method writing to the driver's stdout and stderr, so run can be called
+// again and again in one process - once per test - with nothing left over
+// from the last call, and its output captured. Only main touches the real
+// standard streams, and only main exits.
+type driver struct {
+	stdout, stderr io.Writer
+
+	// jsonErrors switches diagnostics to a machine-readable JSON array on stdout.
+	jsonErrors       bool
+	noWarnings       bool
+	warningsAsErrors bool
+	strictShadow     bool
+	emitTokens       bool
+	emitAST          bool
+	emitIR           irStages
+	output           string
+	target           string
+	assembly         bool
+	format           bool
+	check            bool
+	dumpCFG          string
+	optStats         bool
+	verbose          bool
+	optLevel         int
+	optPasses        passList
+	disabledPasses   passList
+	cacheDir         string
+}
+
+// flagSet returns the command-line flags, bound to d's fields.
+func (d *driver) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("compiler", flag.ContinueOnError)
+	fs.SetOutput(d.stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(d.stderr, "Usage: %s [run|build] [flags] <source-file|directory>...\n", fs.Name())
+		fmt.Fprintf(d.stderr, "       %s repl\n", fs.Name())
+		fs.PrintDefaults()
 	}
 
-	filename := os.Args[1]
+	fs.BoolVar(&d.jsonErrors, "json-errors", false, "write errors to stdout as a JSON array")
+	fs.BoolVar(&d.noWarnings, "no-warnings", false, "do not report warnings")
+	fs.BoolVar(&d.warningsAsErrors, "warnings-as-errors", false, "fail compilation if there are any warnings")
+	fs.BoolVar(&d.strictShadow, "strict-shadow", false, "report a declaration that shadows an enclosing local as an error")
+	fs.BoolVar(&d.emitTokens, "emit-tokens", false, "print the token stream and exit without parsing")
+	fs.BoolVar(&d.emitAST, "emit-ast", false, "print the syntax tree (and stop after parsing, unless --emit-ir is set)")
+	fs.Var(&d.emitIR, "emit-ir", "print the IR: `stage` is unoptimized, optimized, or both when omitted")
+	fs.StringVar(&d.output, "o", "", "write the compiled program to `file` (- for stdout)")
+	fs.StringVar(&d.target, "target", "", "the `format` to write: ir, c, wasm (WebAssembly text), wasm-binary, amd64 (x86-64 assembly, with -S) or llvm (LLVM IR text); by default chosen by the -o extension")
+	fs.BoolVar(&d.assembly, "S", false, "write assembly text (the amd64 target)")
+	fs.BoolVar(&d.format, "format", false, "print the source in canonical layout instead of compiling it")
+	fs.BoolVar(&d.check, "check", false, "with --format, print nothing but the names of files that are not formatted, and exit 1 if there are any")
+	fs.StringVar(&d.dumpCFG, "dump-cfg", "", "write each function's control-flow graph as Graphviz dot files in `dir`, before and after optimization")
+	fs.BoolVar(&d.optStats, "opt-stats", false, "print what each optimization pass changed to stderr")
+	fs.BoolVar(&d.verbose, "verbose", false, "report what the compiler did to stderr: each optimization pass and iteration as it runs, then the --opt-stats totals")
+	fs.IntVar(&d.optLevel, "O", optimizer.O1, "optimization `level`: 0 (none), 1 (default passes) or 2 (adds algebraic simplification and value numbering)")
+	fs.Var(&d.optPasses, "opt", "run exactly these optimization `passes`, comma-separated and in order, instead of the -O level's")
+	fs.Var(&d.disabledPasses, "disable-pass", "do not run the optimization `pass`es named (comma-separated, or repeat the flag)")
+	fs.StringVar(&d.cacheDir, "cache", "", "keep compiled IR in `dir`, and reuse it to run or build a program that hasn't changed")
 
-	// Read the source file
-	source, err := os.ReadFile(filename)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error reading file: %v\n", err)
-		os.Exit(1)
+	// -O takes its level as a value (-O=2, -O 2), but the usual spelling
+	// runs the two together, which the flag package would read as a flag
+	// named "O2". Define those spellings as flags of their own.
+	for level := optimizer.O0; level <= optimizer.O2; level++ {
+		level := level
+		fs.BoolFunc(fmt.Sprintf("O%d", level), fmt.Sprintf("same as -O=%d", level), func(string) error {
+			d.optLevel = level
+			return nil
+		})
 	}
+	return fs
+}
+
+// irStages is the value of --emit-ir: which IR dumps to print.
+//
+// DESIGN CHOICE: A bare --emit-ir asks for both dumps and --emit-ir=optimized
+// for one, which is what a boolean flag with an optional value looks like in
+// Go's flag package: IsBoolFlag lets the value be omitted, in which case Set
+// receives "true".
+type irStages struct {
+	unoptimized, optimized bool
+}
 
-	// Create lexer
-	lex := lexer.New(string(source), filename)
+func (s *irStages) String() string {
+	switch {
+	case s.unoptimized && s.optimized:
+		return "both"
+	case s.unoptimized:
+		return "unoptimized"
+	case s.optimized:
+		return "optimized"
+	default:
+		return ""
+	}
+}
 
-	// Create parser
-	p := parser.New(lex)
+func (s *irStages) Set(value string) error {
+	switch value {
+	case "true", "both":
+		*s = irStages{unoptimized: true, optimized: true}
+	case "false":
+		*s = irStages{}
+	case "unoptimized":
+		*s = irStages{unoptimized: true}
+	case "optimized":
+		*s = irStages{optimized: true}
+	default:
+		return fmt.Errorf("must be unoptimized, optimized or both")
+	}
+	return nil
+}
 
-	// Parse the file
-	file, errors := p.ParseFile(filename)
+func (s *irStages) IsBoolFlag() bool { return true }
 
-	// Report parsing errors
-	if len(errors) > 0 {
-		fmt.Fprintf(os.Stderr, "Parsing errors:\n")
-		for _, err := range errors {
-			fmt.Fprintf(os.Stderr, "  %v\n", err)
+// any reports whether any IR dump was requested.
+func (s *irStages) any() bool { return s.unoptimized || s.optimized }
+
+// passList is the value of --opt and --disable-pass: optimization pass
+// names, comma-separated, accumulated over repeated flags.
+//
+// DESIGN CHOICE: Set checks the names against the optimizer's registry, so a
+// misspelled pass stops the compiler while it parses its flags, with the
+// list of passes it does know. Checking later would compile the program
+// first; not checking would silently run (or not run) the wrong passes.
+type passList []string
+
+func (l *passList) String() string { return strings.Join(*l, ",") }
+
+func (l *passList) Set(value string) error {
+	if *l == nil {
+		*l = passList{} // --opt= names no passes, which is not the same as no --opt
+	}
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
 		}
-		os.Exit(1)
+		if err := optimizer.DefaultPassManager().Check(name); err != nil {
+			return err
+		}
+		*l = append(*l, name)
 	}
+	return nil
+}
 
-	fmt.Printf("✓ Parsing successful\n")
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
 
-	// Perform semantic analysis
-	analyzer := semantic.New()
-	semanticErrors := analyzer.Analyze(file)
+// run carries out the command line args (without the program name),
+// writing to stdout and stderr, and returns the exit status: 0 for
+// success, 1 for a failed compilation, 2 for bad flags or - with "run" -
+// a runtime error, unless the program's main returned a status of its own.
+func run(args []string, stdout, stderr io.Writer) int {
+	d := &driver{stdout: stdout, stderr: stderr}
+	fs := d.flagSet()
 
-	// Report semantic errors
-	if len(semanticErrors) > 0 {
-		fmt.Fprintf(os.Stderr, "\nSemantic errors:\n")
-		for _, err := range semanticErrors {
-			fmt.Fprintf(os.Stderr, "  %v\n", err)
+	// The REPL reads its program from stdin, so none of the flags about
+	// files and output formats apply to it
+	if len(args) > 0 && args[0] == "repl" {
+		if err := repl.Run(os.Stdin, stdout); err != nil {
+			fmt.Fprintf(stderr, "Error reading input: %v\n", err)
+			return 1
 		}
-		os.Exit(1)
+		return 0
 	}
 
-	fmt.Printf("✓ Semantic analysis successful\n")
+	// "run" and "build" are subcommands rather than flags because they
+	// change what the whole invocation is for; the flags after them mean the
+	// same as without them.
+	command := ""
+	if len(args) > 0 && (args[0] == "run" || args[0] == "build") {
+		command = args[0]
+		args = args[1:]
+	}
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
 
-	// Generate IR
-	builder := ir.NewBuilder(analyzer)
-	module, irErrors := builder.Build(file)
+	// Check command line arguments
+	if fs.NArg() < 1 {
+		fs.Usage()
+		return 1
+	}
+	return d.compile(command, fs.Args())
+}
 
-	// Report IR generation errors
-	if len(irErrors) > 0 {
-		fmt.Fprintf(os.Stderr, "\nIR generation errors:\n")
-		for _, err := range irErrors {
-			fmt.Fprintf(os.Stderr, "  %v\n", err)
+// compile compiles the files and directories in paths and presents the
+// result as the flags ask, or runs or builds it for command ("run" or
+// "build", or "" for neither). Returns the exit status.
+func (d *driver) compile(command string, paths []string) int {
+	// In JSON mode stdout must contain nothing but the diagnostics array, a
+	// dump must contain nothing but the dump, a run must show nothing but
+	// the program's own output, and the compiled program may be going to
+	// stdout, so the human-oriented progress report is discarded. Like cc,
+	// a compilation with -o is silent when it succeeds.
+	var out = d.stdout
+	if d.jsonErrors || d.emitAST || command != "" || d.output != "" {
+		out = io.Discard
+	}
+
+	// Read the source files. All files named on the command line (or found
+	// in a named directory) form one package.
+	sources, err := readSources(paths)
+	if err != nil {
+		fmt.Fprintf(d.stderr, "Error reading file: %v\n", err)
+		return 1
+	}
+
+	// Token dumps stop before the parser ever runs
+	if d.emitTokens {
+		ok := true
+		for _, src := range sources {
+			ok = writeTokens(d.stdout, src) && ok
+		}
+		if !ok {
+			return 1
+		}
+		return 0
+	}
+
+	// Formatting needs only the syntax tree, so a program with type errors
+	// can still be formatted
+	if d.format {
+		result, err := compiler.CompilePackage(sources, compiler.Options{StopAfter: compiler.PhaseParse})
+		if err != nil {
+			return d.reportErrors(err.(*compiler.Error), nil, sources)
 		}
-		os.Exit(1)
+		return d.formatSources(result.Files, sources)
+	}
+	if d.check {
+		fmt.Fprintln(d.stderr, "--check needs --format")
+		return 1
+	}
+
+	// Dumping the AST needs nothing past the parser, and stopping there
+	// means the dump works for programs that don't type-check. An IR dump
+	// as well needs the whole pipeline.
+	opts := compiler.Options{
+		OptLevel:         d.optLevel,
+		Passes:           d.optPasses,
+		DisabledPasses:   d.disabledPasses,
+		WarningsAsErrors: d.warningsAsErrors,
+		StrictShadowing:  d.strictShadow,
+	}
+	if d.verbose {
+		opts.Verbose = d.stderr
+	}
+	if d.emitAST && !d.emitIR.any() {
+		opts.StopAfter = compiler.PhaseParse
+	}
+
+	var irCache *cache.Cache
+	var key [32]byte
+	if d.cacheDir != "" && d.cacheable(command) {
+		irCache = &cache.Cache{Dir: d.cacheDir}
+		key = cacheKey(sources, opts)
+		if module, ok := irCache.Get(key); ok {
+			return d.deliver(command, &compiler.Result{Module: module}, nil, out)
+		}
+	}
+	result, err := compiler.CompilePackage(sources, opts)
+
+	// The AST is printed as soon as it exists, so it appears even when a
+	// later phase fails
+	if d.emitAST && result.Completed >= compiler.PhaseParse {
+		for _, file := range result.Files {
+			if err := ast.Print(file, d.stdout); err != nil {
+				fmt.Fprintf(d.stderr, "Error writing AST: %v\n", err)
+				return 1
+			}
+		}
+	}
+
+	// Warnings never stop compilation (unless promoted, in which case they
+	// arrive as errors), so they're reported up front by whatever path
+	// follows.
+	var warnings []errors.CompileError
+	if !d.noWarnings {
+		warnings = result.Warnings
 	}
 
-	fmt.Printf("✓ IR generation successful\n")
+	// Report progress for every phase that completed, in pipeline order, so
+	// the output reads the same whether or not a later phase failed.
+	if result.Completed >= compiler.PhaseParse {
+		fmt.Fprintf(out, "✓ Parsing successful\n")
+	}
+	if result.Completed >= compiler.PhaseSemantic {
+		fmt.Fprintf(out, "✓ Semantic analysis successful\n")
+		if !d.jsonErrors && len(warnings) > 0 {
+			d.printDiagnostics("\nWarnings:", warnings, sources)
+		}
+	}
+	if result.Completed >= compiler.PhaseIR {
+		fmt.Fprintf(out, "✓ IR generation successful\n")
 
-	// Verify IR before optimization
-	verifyErrors := module.Verify()
-	if len(verifyErrors) > 0 {
-		fmt.Fprintf(os.Stderr, "\nIR verification errors:\n")
-		for _, err := range verifyErrors {
-			fmt.Fprintf(os.Stderr, "  %v\n", err)
+		// IR dumps go to stdout even when the progress report is
+		// discarded, so they combine with --emit-ast
+		if d.emitIR.unoptimized {
+			fmt.Fprintf(d.stdout, "\n=== Unoptimized IR ===\n\n")
+			fmt.Fprintln(d.stdout, result.UnoptimizedIR)
+		}
+		if d.dumpCFG != "" {
+			for _, fn := range result.Module.Functions {
+				if !d.writeCFG(fn.Name, "unoptimized", result.UnoptimizedCFG[fn.Name]) {
+					return 1
+				}
+			}
 		}
-		os.Exit(1)
 	}
 
-	// Show unoptimized IR
-	fmt.Printf("\n=== Unoptimized IR ===\n\n")
-	fmt.Println(module.String())
+	if err != nil {
+		return d.reportErrors(err.(*compiler.Error), warnings, sources)
+	}
 
-	// Optimize the IR
-	opt := optimizer.NewOptimizer()
-	opt.SetVerbose(false) // Set to true to see optimization details
+	if d.emitAST && !d.emitIR.any() {
+		return 0
+	}
 
-	if err := opt.Optimize(module); err != nil {
-		fmt.Fprintf(os.Stderr, "\nOptimization error: %v\n", err)
-		os.Exit(1)
+	fmt.Fprintf(out, "✓ Optimization successful\n")
+	// Stats go to stderr so they don't mix with a program or its output on
+	// stdout; there are none at -O0
+	if (d.optStats || d.verbose) && result.OptStats != nil {
+		fmt.Fprint(d.stderr, result.OptStats)
+	}
+	if d.emitIR.optimized {
+		fmt.Fprintf(d.stdout, "\n=== Optimized IR ===\n\n")
+		fmt.Fprintln(d.stdout, result.Module.String())
+	}
+	if d.dumpCFG != "" {
+		for _, fn := range result.Module.Functions {
+			if !d.writeCFG(fn.Name, "optimized", fn.ToDOT()) {
+				return 1
+			}
+		}
 	}
 
-	fmt.Printf("✓ Optimization successful\n")
+	// Only a compilation with nothing to report but its module is stored:
+	// a hit reports nothing else. An imported package is compiled from
+	// files the key doesn't cover, so a program with imports isn't stored
+	// either
+	if irCache != nil && len(result.Warnings) == 0 && len(result.Packages) == 0 {
+		if err := irCache.Put(key, result.Module); err != nil {
+			fmt.Fprintf(d.stderr, "Error writing cache: %v\n", err)
+		}
+	}
+	return d.deliver(command, result, warnings, out)
+}
 
-	// Verify IR after optimization
-	verifyErrors = module.Verify()
-	if len(verifyErrors) > 0 {
-		fmt.Fprintf(os.Stderr, "\nIR verification errors after optimization:\n")
-		for _, err := range verifyErrors {
-			fmt.Fprintf(os.Stderr, "  %v\n", err)
+// deliver does what the command line asks with a successful compilation:
+// runs or builds it, writes the -o file, or prints the JSON diagnostics or
+// the summary to out. Returns the exit status.
+//
+// result may come from the cache, and then has only a Module.
+func (d *driver) deliver(command string, result *compiler.Result, warnings []errors.CompileError, out io.Writer) int {
+	switch command {
+	case "run":
+		return d.runProgram(result)
+	case "build":
+		return d.writeOutput(result, "c")
+	}
+	if d.output != "" {
+		if code := d.writeOutput(result, "ir"); code != 0 {
+			return code
 		}
-		os.Exit(1)
+	}
+
+	// A successful compilation still produces an (empty) array in JSON mode,
+	// so consumers can always decode stdout.
+	if d.jsonErrors {
+		return d.printJSON(warnings)
 	}
 
 	// Success!
-	fmt.Printf("\n=== Compilation Summary ===\n")
-	fmt.Printf("File: %s\n", filename)
-	fmt.Printf("Package: %s\n", file.Package.Name.Name)
-	fmt.Printf("Imports: %d\n", len(file.Imports))
-	fmt.Printf("Declarations: %d\n", len(file.Decls))
-	fmt.Printf("Comments: %d\n", len(file.Comments))
-	fmt.Printf("\n=== Optimized IR ===\n\n")
-	fmt.Println(module.String())
+	pkgSet := parser.NewPackageSet(result.Files)
+	decls := pkgSet.Decls()
+	var filenames []string
+	comments := 0
+	for _, file := range result.Files {
+		filenames = append(filenames, file.Filename)
+		comments += len(file.Comments)
+	}
+	fmt.Fprintf(out, "\n=== Compilation Summary ===\n")
+	if len(filenames) == 1 {
+		fmt.Fprintf(out, "File: %s\n", filenames[0])
+	} else {
+		fmt.Fprintf(out, "Files: %s\n", strings.Join(filenames, ", "))
+	}
+	fmt.Fprintf(out, "Package: %s\n", pkgSet.Name())
+	fmt.Fprintf(out, "Imports: %d\n", len(pkgSet.Imports()))
+	fmt.Fprintf(out, "Declarations: %d\n", len(decls))
+	fmt.Fprintf(out, "Comments: %d\n", comments)
 
 	// Print summary of declarations
-	fmt.Println("\nDeclarations:")
-	for i, decl := range file.Decls {
+	fmt.Fprintln(out, "\nDeclarations:")
+	for i, decl := range decls {
 		switch d := decl.(type) {
 		case *ast.FuncDecl:
-			fmt.Printf("  %d. Function: %s\n", i+1, d.Name.Name)
+			fmt.Fprintf(out, "  %d. Function: %s\n", i+1, d.Name.Name)
 		case *ast.VarDecl:
 			names := make([]string, len(d.Names))
 			for j, name := range d.Names {
 				names[j] = name.Name
 			}
-			fmt.Printf("  %d. Variable(s): %v\n", i+1, names)
+			fmt.Fprintf(out, "  %d. Variable(s): %v\n", i+1, names)
 		case *ast.StructDecl:
-			fmt.Printf("  %d. Struct: %s (%d fields)\n", i+1, d.Name.Name, len(d.Fields))
+			fmt.Fprintf(out, "  %d. Struct: %s (%d fields)\n", i+1, d.Name.Name, len(d.Fields))
 		case *ast.TypeDecl:
-			fmt.Printf("  %d. Type alias: %s\n", i+1, d.Name.Name)
+			fmt.Fprintf(out, "  %d. Type alias: %s\n", i+1, d.Name.Name)
+		}
+	}
+	return 0
+}
+
+// cacheable reports whether a compilation for command can come from the
+// cache: whether all its output is made from the optimized module. A dump
+// of the tokens, syntax tree, IR or CFG, or the optimizer's statistics,
+// needs the compilation itself, and so does the summary printed when there
+// is neither a command nor an -o file.
+func (d *driver) cacheable(command string) bool {
+	if d.emitTokens || d.emitAST || d.emitIR.any() || d.dumpCFG != "" || d.optStats || d.verbose {
+		return false
+	}
+	return command != "" || d.output != ""
+}
+
+// cacheKey returns the hash a compilation of sources with opts is cached
+// under. It covers the sources' text, the options that change what is
+// compiled, and the compiler itself - the size and modification time of
+// its executable, which every rebuild changes - since a different compiler
+// may compile the same program differently.
+func cacheKey(sources []compiler.Source, opts compiler.Options) [32]byte {
+	var buf []byte
+	// A string is written with its length, so the parts can't run together
+	str := func(s string) {
+		buf = binary.AppendUvarint(buf, uint64(len(s)))
+		buf = append(buf, s...)
+	}
+	if exe, err := os.Executable(); err == nil {
+		if info, err := os.Stat(exe); err == nil {
+			str(fmt.Sprintf("%s %d %d", exe, info.Size(), info.ModTime().UnixNano()))
+		}
+	}
+	str(fmt.Sprintf("O%d passes=%q disabled=%q werror=%t shadow=%t", opts.OptLevel, opts.Passes, opts.DisabledPasses, opts.WarningsAsErrors, opts.StrictShadowing))
+	for _, src := range sources {
+		str(string(src.Text))
+	}
+	return sha256.Sum256(buf)
+}
+
+// runProgram executes the compiled program's main function and returns the
+// process exit code: main's return value if it returns an int, otherwise 0.
+// A runtime error is reported on stderr with exit code 2, which keeps it
+// distinct from a compile failure (1).
+func (d *driver) runProgram(result *compiler.Result) int {
+	machine := interp.New(result.Module)
+	machine.Stdout = d.stdout
+	value, err := machine.Run("main", nil)
+	if err != nil {
+		fmt.Fprintln(d.stderr, err)
+		if rtErr, ok := err.(*interp.RuntimeError); ok && len(rtErr.Stack) > 1 {
+			fmt.Fprintf(d.stderr, "  call stack: %s\n", strings.Join(rtErr.Stack, " <- "))
+		}
+		return 2
+	}
+	if code, ok := value.(int64); ok {
+		return int(code)
+	}
+	return 0
+}
+
+// formatSources prints each parsed file in canonical layout, or with
+// --check lists the files whose source differs from it. It returns the exit
+// code: 1 when --check found unformatted files.
+func (d *driver) formatSources(files []*ast.File, sources []compiler.Source) int {
+	f := formatter.New()
+	code := 0
+	for i, file := range files {
+		formatted := f.Format(file)
+		if !d.check {
+			fmt.Fprint(d.stdout, formatted)
+			continue
+		}
+		if formatted != string(sources[i].Text) {
+			fmt.Fprintln(d.stdout, sources[i].Filename)
+			code = 1
+		}
+	}
+	return code
+}
+
+// writeCFG writes one function's dot graph to --dump-cfg's directory as
+// <function>.<stage>.dot, creating the directory if needed. Qualified names
+// of imported functions can contain slashes, which become underscores.
+func (d *driver) writeCFG(function, stage, dot string) bool {
+	if err := os.MkdirAll(d.dumpCFG, 0o755); err != nil {
+		fmt.Fprintf(d.stderr, "Error writing CFG: %v\n", err)
+		return false
+	}
+	name := strings.ReplaceAll(function, "/", "_") + "." + stage + ".dot"
+	if err := os.WriteFile(filepath.Join(d.dumpCFG, name), []byte(dot), 0o644); err != nil {
+		fmt.Fprintf(d.stderr, "Error writing CFG: %v\n", err)
+		return false
+	}
+	return true
+}
+
+// formats generate each kind of output, by --target name.
+//
+// DESIGN CHOICE: The textual IR is a format like the backends' rather than
+// a special case, so -o works the same for every one of them.
+var formats = map[string]func(*ir.Module) (string, []error){
+	"ir":    func(module *ir.Module) (string, []error) { return module.String(), nil },
+	"c":     cgen.Generate,
+	"wasm":  wasm.Generate,
+	"amd64": amd64.Generate,
+	"llvm":  llvm.Generate,
+	"wasm-binary": func(module *ir.Module) (string, []error) {
+		code, errs := wasm.Encode(module)
+		return string(code), errs
+	},
+}
+
+// extensions choose the format of an -o file when --target isn't given.
+var extensions = map[string]string{
+	".ir":   "ir",
+	".c":    "c",
+	".wat":  "wasm",
+	".wasm": "wasm-binary",
+	".s":    "amd64",
+	".ll":   "llvm",
+}
+
+// writeOutput writes the compiled program to the -o file, or stdout if it
+// is "-" or absent, and returns the process exit code. The format is
+// --target, else the one the -o extension names, else amd64 with -S, else
+// fallback. A construct
+// the backend can't translate is a compile failure (1), reported like any
+// other.
+func (d *driver) writeOutput(result *compiler.Result, fallback string) int {
+	format := d.target
+	if format == "" {
+		format = fallback
+		if byExtension, ok := extensions[filepath.Ext(d.output)]; ok {
+			format = byExtension
+		} else if d.assembly {
+			format = "amd64"
+		}
+	}
+	// Like cc, -S means "stop at assembly". The compiler can't assemble or
+	// link yet, so the amd64 target is only available as text.
+	if format == "amd64" && !d.assembly && filepath.Ext(d.output) != ".s" {
+		fmt.Fprintln(d.stderr, "The amd64 target writes assembly text only: pass -S")
+		return 1
+	}
+	generate, ok := formats[format]
+	if !ok {
+		fmt.Fprintf(d.stderr, "Unknown target %q: must be ir, c, wasm, wasm-binary, amd64 or llvm\n", format)
+		return 1
+	}
+	source, errs := generate(result.Module)
+	if len(errs) > 0 {
+		fmt.Fprintln(d.stderr, "Code generation errors:")
+		for _, err := range errs {
+			fmt.Fprintf(d.stderr, "  %v\n", err)
 		}
+		return 1
+	}
+
+	if d.output == "" || d.output == "-" {
+		fmt.Fprint(d.stdout, source)
+		return 0
+	}
+	if err := os.WriteFile(d.output, []byte(source), 0o644); err != nil {
+		fmt.Fprintf(d.stderr, "Error writing output: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// readSources loads the files named by args. A directory contributes every
+// .src file directly inside it, in name order so builds are reproducible.
+func readSources(args []string) ([]compiler.Source, error) {
+	var paths []string
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			paths = append(paths, arg)
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(arg, "*.src"))
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("%s: no .src files in directory", arg)
+		}
+		sort.Strings(matches)
+		paths = append(paths, matches...)
+	}
+
+	sources := make([]compiler.Source, len(paths))
+	for i, path := range paths {
+		text, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		sources[i] = compiler.Source{Filename: path, Text: text}
+	}
+	return sources, nil
+}
+
+// tokenEscaper keeps every token on one output line: a block comment or a
+// string with an escaped newline would otherwise split it.
+var tokenEscaper = strings.NewReplacer("\\", `\\`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+
+// writeTokens lexes src and writes one line per token, EOF included:
+//
+//	IDENTIFIER(main) main.src:1:9
+//
+// Lexer errors appear in the stream where they occur, as
+// "ERROR(message) file:line:col", and lexing continues after them. Returns
+// false if there were any.
+func writeTokens(w io.Writer, src compiler.Source) bool {
+	lex := lexer.New(string(src.Text), src.Filename)
+	ok := true
+	for {
+		token, err := lex.NextToken()
+		if err != nil {
+			ok = false
+			if lexErr, isLexErr := err.(*lexer.Error); isLexErr {
+				fmt.Fprintf(w, "ERROR(%s) %s\n", tokenEscaper.Replace(lexErr.Message), lexErr.Pos)
+			} else {
+				fmt.Fprintf(w, "ERROR(%s) %s\n", tokenEscaper.Replace(err.Error()), token.Position)
+			}
+		} else {
+			fmt.Fprintf(w, "%s(%s) %s\n", token.Type, tokenEscaper.Replace(token.Lexeme), token.Position)
+		}
+		if token.Type == lexer.TokenEOF {
+			return ok
+		}
+	}
+}
+
+// phaseHeadings are the section titles printed above a failing phase's errors.
+var phaseHeadings = map[compiler.Phase]string{
+	compiler.PhaseParse:    "Parsing errors:",
+	compiler.PhaseSemantic: "\nSemantic errors:",
+	compiler.PhaseIR:       "\nIR generation errors:",
+	compiler.PhaseOptimize: "\nOptimization errors:",
+}
+
+// reportErrors prints the diagnostics of a failed phase and returns the
+// exit status, 1.
+//
+// By default the errors go to stderr under the phase heading, each followed
+// by the source line it points at. With --json-errors they are written to
+// stdout as a single JSON array instead (after any warnings), so editors and
+// CI tools can pipe them.
+func (d *driver) reportErrors(failure *compiler.Error, warnings []errors.CompileError, sources []compiler.Source) int {
+	if d.jsonErrors {
+		d.printJSON(append(append([]errors.CompileError{}, warnings...), failure.Diagnostics...))
+		return 1
+	}
+
+	d.printDiagnostics(phaseHeadings[failure.Phase], failure.Diagnostics, sources)
+	return 1
+}
+
+// printDiagnostics renders diagnostics to stderr under a heading, each
+// against the text of its own file.
+func (d *driver) printDiagnostics(heading string, diags []errors.CompileError, sources []compiler.Source) {
+	texts := make(map[string]string, len(sources))
+	for _, src := range sources {
+		texts[src.Filename] = string(src.Text)
+	}
+
+	fmt.Fprintln(d.stderr, heading)
+	for _, diag := range diags {
+		rendered := diag.Render(texts[diag.Pos.Filename])
+		fmt.Fprintf(d.stderr, "  %s\n", strings.ReplaceAll(rendered, "\n", "\n  "))
+	}
+}
+
+// printJSON writes diagnostics to stdout as a JSON array, and returns the
+// exit status: 0, unless they could not be encoded.
+func (d *driver) printJSON(diags []errors.CompileError) int {
+	data, err := errors.MarshalJSON(diags)
+	if err != nil {
+		fmt.Fprintf(d.stderr, "Error encoding diagnostics: %v\n", err)
+		return 1
 	}
+	fmt.Fprintln(d.stdout, string(data))
+	return 0
 }
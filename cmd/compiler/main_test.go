@@ -0,0 +1,1053 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hassan/compiler/internal/optimizer"
+	"github.com/hassan/compiler/internal/parser/ast"
+	"github.com/hassan/compiler/internal/testutil"
+	"github.com/hassan/compiler/pkg/compiler"
+)
+
+// The compiler's main calls os.Exit, so tests run it in a child process: the
+// test binary re-executes itself with COMPILER_TEST_MAIN set and TestMain
+// hands control to main with the requested arguments.
+func TestMain(m *testing.M) {
+	if args := os.Getenv("COMPILER_TEST_MAIN"); args != "" {
+		os.Args = append([]string{"compiler"}, strings.Split(args, "\n")...)
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// update rewrites golden files with the current output instead of comparing.
+var update = flag.Bool("update", false, "update golden files")
+
+// runCompiler runs main with args and returns stdout and the exit code.
+func runCompiler(t *testing.T, args ...string) (string, int) {
+	t.Helper()
+	return runCompilerIn(t, "", args...)
+}
+
+// runCompilerIn is runCompiler with the child's working directory set to dir,
+// so paths in the output match the paths passed in.
+func runCompilerIn(t *testing.T, dir string, args ...string) (string, int) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0])
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "COMPILER_TEST_MAIN="+strings.Join(args, "\n"))
+	out, err := cmd.Output()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return string(out), exitErr.ExitCode()
+	}
+	if err != nil {
+		t.Fatalf("running compiler: %v", err)
+	}
+	return string(out), 0
+}
+
+// runCompilerStderr is runCompiler that also returns what main wrote to
+// stderr.
+func runCompilerStderr(t *testing.T, args ...string) (string, string, int) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(os.Environ(), "COMPILER_TEST_MAIN="+strings.Join(args, "\n"))
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return string(out), stderr.String(), exitErr.ExitCode()
+	}
+	if err != nil {
+		t.Fatalf("running compiler: %v", err)
+	}
+	return string(out), stderr.String(), 0
+}
+
+func writeSource(t *testing.T, source string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "input.src")
+	if err := os.WriteFile(path, []byte(source), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// diagnostic mirrors the scalar fields of the --json-errors wire format so
+// expectations can be compared with ==.
+type diagnostic struct {
+	File      string `json:"file"`
+	Line      int    `json:"line"`
+	Column    int    `json:"column"`
+	EndLine   int    `json:"end_line"`
+	EndColumn int    `json:"end_column"`
+	Severity  string `json:"severity"`
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+}
+
+type jsonDiagnostic struct {
+	diagnostic
+	Notes []string `json:"notes"`
+}
+
+func TestJSONErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   diagnostic
+	}{
+		{
+			name:   "semantic",
+			source: "package main\n\nfunc f() {\n    var y int = missing;\n}\n",
+			want:   diagnostic{Line: 4, Column: 17, EndLine: 4, EndColumn: 17, Severity: "error", Code: "E003", Message: "undefined: missing"},
+		},
+		{
+			name:   "syntax",
+			source: "package main\n\nvar x int = ;\n",
+			want:   diagnostic{Line: 3, Column: 13, EndLine: 3, EndColumn: 14, Severity: "error", Code: "E002", Message: "expected expression, got SEMICOLON"},
+		},
+		{
+			name:   "lexical",
+			source: "package main\n\nvar s string = \"open;\n",
+			want:   diagnostic{Line: 3, Column: 16, EndLine: 3, EndColumn: 16, Severity: "error", Code: "E001", Message: "unterminated string literal"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeSource(t, tt.source)
+			stdout, code := runCompiler(t, "--json-errors", "--no-warnings", path)
+			if code != 1 {
+				t.Fatalf("expected exit code 1, got %d", code)
+			}
+
+			var diags []jsonDiagnostic
+			if err := json.Unmarshal([]byte(stdout), &diags); err != nil {
+				t.Fatalf("stdout is not a JSON array: %v\n%s", err, stdout)
+			}
+			if len(diags) == 0 {
+				t.Fatalf("expected at least one diagnostic")
+			}
+
+			got := diags[0]
+			if got.File != path {
+				t.Errorf("file: expected %q, got %q", path, got.File)
+			}
+			if got.Notes == nil {
+				t.Errorf("notes: expected an array, got null")
+			}
+			got.File = ""
+			if got.diagnostic != tt.want {
+				t.Errorf("expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestJSONErrors_Success(t *testing.T) {
+	path := writeSource(t, "package main\n\nfunc main() {\n    var x int = 1;\n    x = x + 1;\n}\n")
+	stdout, code := runCompiler(t, "--json-errors", path)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d", code)
+	}
+	if strings.TrimSpace(stdout) != "[]" {
+		t.Errorf("expected empty JSON array, got %q", stdout)
+	}
+}
+
+func TestMultipleFiles(t *testing.T) {
+	dir := filepath.Join("..", "..", "testdata", "multifile")
+	files := []string{filepath.Join(dir, "main.src"), filepath.Join(dir, "math.src")}
+
+	// A directory and the list of its files are the same package.
+	for _, args := range [][]string{{dir}, files} {
+		stdout, code := runCompiler(t, append([]string{"--json-errors", "--no-warnings"}, args...)...)
+		if code != 0 || strings.TrimSpace(stdout) != "[]" {
+			t.Errorf("%v: expected success, got exit %d with %q", args, code, stdout)
+		}
+	}
+
+	// Either file alone is missing the other's declarations.
+	if _, code := runCompiler(t, "--json-errors", files[0]); code != 1 {
+		t.Errorf("expected compiling main.src alone to fail, got exit %d", code)
+	}
+}
+
+func TestWarnings(t *testing.T) {
+	// Nothing wrong here except a variable that is never read.
+	path := writeSource(t, "package main\n\nfunc main() {\n    var unused int = 1;\n}\n")
+
+	stdout, code := runCompiler(t, "--json-errors", path)
+	if code != 0 {
+		t.Fatalf("expected warnings alone not to fail compilation, got exit %d", code)
+	}
+	var diags []jsonDiagnostic
+	if err := json.Unmarshal([]byte(stdout), &diags); err != nil {
+		t.Fatalf("stdout is not a JSON array: %v\n%s", err, stdout)
+	}
+	if len(diags) != 1 || diags[0].Severity != "warning" || diags[0].Code != "W001" {
+		t.Fatalf("expected one unused-variable warning, got %+v", diags)
+	}
+
+	if stdout, _ := runCompiler(t, "--json-errors", "--no-warnings", path); strings.TrimSpace(stdout) != "[]" {
+		t.Errorf("expected --no-warnings to suppress the warning, got %q", stdout)
+	}
+
+	stdout, code = runCompiler(t, "--json-errors", "--warnings-as-errors", path)
+	if code != 1 {
+		t.Fatalf("expected --warnings-as-errors to fail compilation, got exit %d", code)
+	}
+	diags = nil
+	if err := json.Unmarshal([]byte(stdout), &diags); err != nil {
+		t.Fatalf("stdout is not a JSON array: %v\n%s", err, stdout)
+	}
+	if len(diags) != 1 || diags[0].Severity != "error" || diags[0].Message != "variable unused declared but not used" {
+		t.Errorf("expected the warning promoted to an error, got %+v", diags)
+	}
+}
+
+func TestEmitTokens_Golden(t *testing.T) {
+	// Run from the repository root so the filenames in the output are the
+	// same short relative paths stored in the golden file.
+	root := filepath.Join("..", "..")
+	stdout, code := runCompilerIn(t, root, "--emit-tokens", "testdata/tokens/basic.src")
+
+	// The fixture contains a stray '@', so the dump includes an ERROR token
+	// and the exit status reports the failure.
+	if code != 1 {
+		t.Errorf("expected exit code 1 for a lexer error, got %d", code)
+	}
+
+	golden := filepath.Join(root, "testdata", "tokens", "basic.tokens")
+	if *update {
+		if err := os.WriteFile(golden, []byte(stdout), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stdout != string(want) {
+		t.Errorf("token stream differs from %s (run with -update to accept):\ngot:\n%s\nwant:\n%s", golden, stdout, want)
+	}
+}
+
+func TestEmitAST(t *testing.T) {
+	// The undefined name would fail semantic analysis, which --emit-ast
+	// never reaches.
+	path := writeSource(t, "package main\n\nfunc f() int {\n    return missing;\n}\n")
+	stdout, code := runCompiler(t, "--emit-ast", path)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d", code)
+	}
+	want := "File\n  Package: main\n  FuncDecl: f\n    Returns: int\n    Body:\n      ReturnStmt\n        IdentifierExpr: missing\n"
+	if stdout != want {
+		t.Errorf("expected:\n%s\ngot:\n%s", want, stdout)
+	}
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name     string
+		source   string
+		wantCode int
+	}{
+		{
+			name:     "exit code is main's result",
+			source:   "package main\n\nfunc square(n int) int {\n    return n * n;\n}\n\nfunc main() int {\n    return square(7);\n}\n",
+			wantCode: 49,
+		},
+		{
+			name:     "void main exits zero",
+			source:   "package main\n\nfunc main() {\n    var x int = 1;\n}\n",
+			wantCode: 0,
+		},
+		{
+			name: "increment and decrement",
+			source: "package main\n\nfunc main() int {\n    var n int = 0;\n" +
+				"    for (var i int = 0; i < 5; i++) {\n        n += i;\n    }\n" +
+				"    var a = [1, 2, 3];\n    a[2]--;\n    n = n + a[2];\n    --n;\n    return n++;\n}\n",
+			wantCode: 11,
+		},
+		{
+			name:     "runtime error",
+			source:   "package main\n\nfunc divide(a int, b int) int {\n    return a / b;\n}\n\nfunc main() int {\n    return divide(1, 0);\n}\n",
+			wantCode: 2,
+		},
+		{
+			name:     "compile error",
+			source:   "package main\n\nfunc main() int {\n    return missing;\n}\n",
+			wantCode: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, code := runCompiler(t, "run", "--no-warnings", writeSource(t, tt.source))
+			if code != tt.wantCode {
+				t.Errorf("expected exit code %d, got %d", tt.wantCode, code)
+			}
+			// The progress report is suppressed, and these programs print
+			// nothing themselves
+			if stdout != "" {
+				t.Errorf("expected no output, got:\n%s", stdout)
+			}
+		})
+	}
+}
+
+func TestRun_HelloWorld(t *testing.T) {
+	path := writeSource(t, "package main\n\nfunc main() {\n    println(\"hello, world\");\n}\n")
+	stdout, code := runCompiler(t, "run", path)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d", code)
+	}
+	if stdout != "hello, world\n" {
+		t.Errorf("expected %q, got %q", "hello, world\n", stdout)
+	}
+}
+
+func TestREPL(t *testing.T) {
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(os.Environ(), "COMPILER_TEST_MAIN=repl")
+	cmd.Stdin = strings.NewReader("var x = 3;\nx * 7\n:quit\n")
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("running the REPL: %v", err)
+	}
+	if want := "> > 21 : int\n> "; string(out) != want {
+		t.Errorf("expected %q, got %q", want, out)
+	}
+}
+
+func TestOutputFile(t *testing.T) {
+	path := writeSource(t, "package main\n\nfunc main() int {\n    var x int = 2 + 3;\n    return x;\n}\n")
+	dump, _ := runCompiler(t, "--emit-ir=optimized", "--no-warnings", path)
+
+	t.Run("-o /dev/null", func(t *testing.T) {
+		stdout, code := runCompiler(t, "--no-warnings", "-o", os.DevNull, path)
+		if code != 0 {
+			t.Fatalf("expected exit code 0, got %d", code)
+		}
+		if stdout != "" {
+			t.Errorf("expected no output, got:\n%s", stdout)
+		}
+	})
+
+	t.Run("-o out.ir", func(t *testing.T) {
+		out := filepath.Join(t.TempDir(), "out.ir")
+		stdout, code := runCompiler(t, "--no-warnings", "-o", out, path)
+		if code != 0 {
+			t.Fatalf("expected exit code 0, got %d", code)
+		}
+		if stdout != "" {
+			t.Errorf("expected no output, got:\n%s", stdout)
+		}
+		text, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The file holds the optimized IR, exactly as --emit-ir prints it
+		if !strings.HasPrefix(string(text), "; Module: main") || !strings.Contains(dump, string(text)) {
+			t.Errorf("expected the optimized IR, got:\n%s\nIR dump:\n%s", text, dump)
+		}
+	})
+
+	t.Run("-o -", func(t *testing.T) {
+		stdout, code := runCompiler(t, "--no-warnings", "-o", "-", path)
+		if code != 0 {
+			t.Fatalf("expected exit code 0, got %d", code)
+		}
+		if !strings.HasPrefix(stdout, "; Module: main") || strings.Contains(stdout, "✓") {
+			t.Errorf("expected only the IR on stdout, got:\n%s", stdout)
+		}
+	})
+
+	t.Run("no -o", func(t *testing.T) {
+		stdout, code := runCompiler(t, "--no-warnings", path)
+		if code != 0 {
+			t.Fatalf("expected exit code 0, got %d", code)
+		}
+		if !strings.Contains(stdout, "=== Compilation Summary ===") {
+			t.Errorf("expected the summary on stdout, got:\n%s", stdout)
+		}
+	})
+
+	// The extension picks the format
+	for ext, want := range map[string]string{
+		".c":    "int main(void) {",
+		".wat":  "(export \"main\" (func $main))",
+		".wasm": "\x00asm\x01\x00\x00\x00",
+		".ll":   "define i64 @fn.main()",
+	} {
+		t.Run("-o out"+ext, func(t *testing.T) {
+			out := filepath.Join(t.TempDir(), "out"+ext)
+			if _, code := runCompiler(t, "--no-warnings", "-o", out, path); code != 0 {
+				t.Fatalf("expected exit code 0, got %d", code)
+			}
+			text, err := os.ReadFile(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(text), want) {
+				t.Errorf("expected %q in the output, got:\n%s", want, text)
+			}
+		})
+	}
+}
+
+func TestBuild(t *testing.T) {
+	path := writeSource(t, "package main\n\nfunc main() int {\n    println(\"hi\");\n    return 0;\n}\n")
+
+	t.Run("writes the -o file", func(t *testing.T) {
+		out := filepath.Join(t.TempDir(), "out.c")
+		stdout, code := runCompiler(t, "build", "--no-warnings", "-o", out, path)
+		if code != 0 {
+			t.Fatalf("expected exit code 0, got %d", code)
+		}
+		if stdout != "" {
+			t.Errorf("expected no output, got:\n%s", stdout)
+		}
+		text, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range []string{"static int64_t fn_main(void) {", "rt_print_string(\"hi\");", "int main(void) {"} {
+			if !strings.Contains(string(text), want) {
+				t.Errorf("expected C to contain %q, got:\n%s", want, text)
+			}
+		}
+	})
+
+	t.Run("writes stdout without -o", func(t *testing.T) {
+		stdout, code := runCompiler(t, "build", "--no-warnings", path)
+		if code != 0 {
+			t.Fatalf("expected exit code 0, got %d", code)
+		}
+		if !strings.HasPrefix(stdout, "/* Generated from module main. */") {
+			t.Errorf("expected C on stdout, got:\n%s", stdout)
+		}
+	})
+
+	t.Run("wasm target", func(t *testing.T) {
+		source := writeSource(t, "package main\n\nfunc main() int {\n    return 6 * 7;\n}\n")
+		stdout, code := runCompiler(t, "build", "--target=wasm", source)
+		if code != 0 {
+			t.Fatalf("expected exit code 0, got %d", code)
+		}
+		for _, want := range []string{"(module", "(func $main (result i64)", "(export \"main\" (func $main))"} {
+			if !strings.Contains(stdout, want) {
+				t.Errorf("expected WAT to contain %q, got:\n%s", want, stdout)
+			}
+		}
+	})
+
+	t.Run("wasm target rejects what it can't translate", func(t *testing.T) {
+		if _, code := runCompiler(t, "build", "--target=wasm", "--no-warnings", path); code != 1 {
+			t.Errorf("expected exit code 1 for println, got %d", code)
+		}
+	})
+
+	t.Run("amd64 assembly", func(t *testing.T) {
+		source := writeSource(t, "package main\n\nfunc main() int {\n    return 6 * 7;\n}\n")
+		stdout, code := runCompiler(t, "build", "-S", source)
+		if code != 0 {
+			t.Fatalf("expected exit code 0, got %d", code)
+		}
+		for _, want := range []string{"fn_main:", ".globl main"} {
+			if !strings.Contains(stdout, want) {
+				t.Errorf("expected assembly to contain %q, got:\n%s", want, stdout)
+			}
+		}
+	})
+
+	t.Run("amd64 target needs -S", func(t *testing.T) {
+		if _, code := runCompiler(t, "build", "--target=amd64", "--no-warnings", path); code != 1 {
+			t.Errorf("expected exit code 1 without -S, got %d", code)
+		}
+	})
+
+	t.Run("unknown target", func(t *testing.T) {
+		if _, code := runCompiler(t, "build", "--target=spirv", "--no-warnings", path); code != 1 {
+			t.Errorf("expected exit code 1, got %d", code)
+		}
+	})
+
+	t.Run("compile error writes nothing", func(t *testing.T) {
+		out := filepath.Join(t.TempDir(), "out.c")
+		broken := writeSource(t, "package main\n\nfunc main() int {\n    return missing;\n}\n")
+		if _, code := runCompiler(t, "build", "-o", out, broken); code != 1 {
+			t.Errorf("expected exit code 1, got %d", code)
+		}
+		if _, err := os.Stat(out); !os.IsNotExist(err) {
+			t.Errorf("expected no output file, got err = %v", err)
+		}
+	})
+}
+
+func TestEmitIR(t *testing.T) {
+	path := writeSource(t, "package main\n\nfunc main() int {\n    var x int = 2 + 3;\n    return x;\n}\n")
+	const addition = "t1 = const(2) + const(3)"
+
+	tests := []struct {
+		arg           string
+		want, notWant []string
+	}{
+		{
+			arg:     "--emit-ir=unoptimized",
+			want:    []string{"=== Unoptimized IR ===", addition},
+			notWant: []string{"=== Optimized IR ==="},
+		},
+		{
+			// Constant folding replaces the addition with its result
+			arg:     "--emit-ir=optimized",
+			want:    []string{"=== Optimized IR ===", "t1 = const(5)"},
+			notWant: []string{"=== Unoptimized IR ===", addition},
+		},
+		{
+			arg:  "--emit-ir",
+			want: []string{"=== Unoptimized IR ===", addition, "=== Optimized IR ===", "t1 = const(5)"},
+		},
+		{
+			arg:     "--no-warnings",
+			want:    []string{"=== Compilation Summary ==="},
+			notWant: []string{"IR ===", "const("},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.arg, func(t *testing.T) {
+			stdout, code := runCompiler(t, tt.arg, path)
+			if code != 0 {
+				t.Fatalf("expected exit code 0, got %d", code)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(stdout, want) {
+					t.Errorf("expected output to contain %q, got:\n%s", want, stdout)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(stdout, notWant) {
+					t.Errorf("expected output not to contain %q, got:\n%s", notWant, stdout)
+				}
+			}
+		})
+	}
+}
+
+func TestOptLevel(t *testing.T) {
+	// The addition is unused, so -O1 and up fold it and then delete it
+	path := writeSource(t, "package main\n\nfunc main() int {\n    var x int = 2 + 3;\n    return 0;\n}\n")
+	tests := []struct {
+		args     []string
+		keepsAdd bool
+	}{
+		{[]string{"-O0"}, true},
+		{[]string{"-O=0"}, true},
+		{[]string{"-O2"}, false},
+		{[]string{"-O", "2"}, false},
+		{nil, false}, // -O1 is the default
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			args := append(append([]string{"--no-warnings", "--emit-ir=optimized"}, tt.args...), path)
+			stdout, code := runCompiler(t, args...)
+			if code != 0 {
+				t.Fatalf("expected exit code 0, got %d", code)
+			}
+			if kept := strings.Contains(stdout, "const(2) + const(3)"); kept != tt.keepsAdd {
+				t.Errorf("expected 2 + 3 kept = %v, got:\n%s", tt.keepsAdd, stdout)
+			}
+		})
+	}
+}
+
+func TestEmitASTAndIR(t *testing.T) {
+	// Together the flags run the whole pipeline, printing the AST first
+	path := writeSource(t, "package main\n\nfunc main() int {\n    return 1;\n}\n")
+	stdout, code := runCompiler(t, "--emit-ast", "--emit-ir=optimized", path)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d", code)
+	}
+	if !strings.HasPrefix(stdout, "File\n") || !strings.Contains(stdout, "=== Optimized IR ===") {
+		t.Errorf("expected the AST followed by the optimized IR, got:\n%s", stdout)
+	}
+	if strings.Contains(stdout, "✓") {
+		t.Errorf("expected no progress report, got:\n%s", stdout)
+	}
+}
+
+func TestDumpCFG(t *testing.T) {
+	// The folded addition disappears from the optimized graph only
+	path := writeSource(t, "package main\n\nfunc main() int {\n    var x int = 2 + 3;\n    if (x > 4) {\n        return 1;\n    }\n    return 0;\n}\n")
+	dir := filepath.Join(t.TempDir(), "cfg")
+	if _, code := runCompiler(t, "--no-warnings", "--dump-cfg="+dir+"/", path); code != 0 {
+		t.Fatalf("expected exit code 0, got %d", code)
+	}
+
+	before, err := os.ReadFile(filepath.Join(dir, "main.unoptimized.dot"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := os.ReadFile(filepath.Join(dir, "main.optimized.dot"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, dot := range map[string]string{"unoptimized": string(before), "optimized": string(after)} {
+		if !strings.HasPrefix(dot, "digraph \"main\" {") {
+			t.Errorf("expected a dot graph for %s main, got:\n%s", name, dot)
+		}
+	}
+	if !strings.Contains(string(before), "const(2) + const(3)") {
+		t.Errorf("expected the addition before optimization, got:\n%s", before)
+	}
+	if strings.Contains(string(after), "const(2) + const(3)") {
+		t.Errorf("expected the addition folded after optimization, got:\n%s", after)
+	}
+}
+
+func TestOptStats(t *testing.T) {
+	// 2 + 3 folds, then it and the unused x it is copied to are deleted
+	path := writeSource(t, "package main\n\nfunc main() int {\n    var x int = 2 + 3;\n    return 0;\n}\n")
+
+	stdout, stderr, code := runCompilerStderr(t, "--no-warnings", "--opt-stats", path)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d:\n%s", code, stderr)
+	}
+	for _, want := range []string{
+		"Instructions: 3 before, 1 after\n",
+		"    ConstantFolding (2 runs): 1 constant folded\n",
+		"    DeadCodeElimination (2 runs): 2 instructions removed\n",
+		"    main: 3 -> 1 instructions\n",
+	} {
+		if !strings.Contains(stderr, want) {
+			t.Errorf("expected stderr to contain %q, got:\n%s", want, stderr)
+		}
+	}
+	if strings.Contains(stdout, "Optimization Stats") {
+		t.Errorf("expected the stats on stderr only, got stdout:\n%s", stdout)
+	}
+
+	if _, stderr, _ := runCompilerStderr(t, "--no-warnings", "--opt-stats", "-O0", path); stderr != "" {
+		t.Errorf("expected no stats when the optimizer does not run, got:\n%s", stderr)
+	}
+
+	if _, verbose, _ := runCompilerStderr(t, "--no-warnings", "--verbose", path); !strings.HasSuffix(verbose, stderr) {
+		t.Errorf("expected --verbose to end with the stats, got:\n%s", verbose)
+	}
+}
+
+func TestVerbose(t *testing.T) {
+	// The first iteration folds 2 + 3 and deletes it; the second finds
+	// nothing left to do
+	path := writeSource(t, "package main\n\nfunc main() int {\n    var x int = 2 + 3;\n    return 0;\n}\n")
+
+	stdout, stderr, code := runCompilerStderr(t, "--no-warnings", "--verbose", "--emit-ir=optimized", path)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d:\n%s", code, stderr)
+	}
+	for _, want := range []string{
+		"Optimizing main:\n",
+		"  Running ConstantFolding...\n",
+		"  Iteration 1: 3 -> 1 instructions\n",
+		"  Iteration 2: 1 -> 1 instructions\n",
+		"  Fixed point reached after 2 iterations\n",
+	} {
+		if !strings.Contains(stderr, want) {
+			t.Errorf("expected stderr to contain %q, got:\n%s", want, stderr)
+		}
+	}
+	if strings.Contains(stdout, "Iteration") || strings.Contains(stdout, "Running") {
+		t.Errorf("expected the optimizer's log on stderr only, got stdout:\n%s", stdout)
+	}
+
+	if _, stderr, _ := runCompilerStderr(t, "--no-warnings", "--opt-stats", path); strings.Contains(stderr, "Iteration") {
+		t.Errorf("expected no optimizer log without --verbose, got:\n%s", stderr)
+	}
+}
+
+func TestPassFlags(t *testing.T) {
+	// 2 + 3 folds to 5 either way; only DCE deletes it
+	path := writeSource(t, "package main\n\nfunc main() int {\n    var x int = 2 + 3;\n    return 0;\n}\n")
+	tests := []struct {
+		name  string
+		args  []string
+		keeps string // what must survive in the optimized IR, "" for nothing
+		drops string // what must be gone
+	}{
+		{"default", nil, "", "const(5)"},
+		{"dce disabled", []string{"--disable-pass=DeadCodeElimination"}, "t1 = const(5)", ""},
+		{"folding only", []string{"--opt=ConstantFolding"}, "t1 = const(5)", ""},
+		{"explicit list at -O0", []string{"-O0", "--opt=ConstantFolding,DeadCodeElimination"}, "", "const(5)"},
+		{"no passes", []string{"--opt="}, "const(2) + const(3)", ""},
+		{"disabled wins", []string{"--opt=ConstantFolding", "--disable-pass=ConstantFolding"}, "const(2) + const(3)", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := append(append([]string{"--no-warnings", "--emit-ir=optimized"}, tt.args...), path)
+			stdout, code := runCompiler(t, args...)
+			if code != 0 {
+				t.Fatalf("expected exit code 0, got %d", code)
+			}
+			if tt.keeps != "" && !strings.Contains(stdout, tt.keeps) {
+				t.Errorf("expected %q to survive, got:\n%s", tt.keeps, stdout)
+			}
+			if tt.drops != "" && strings.Contains(stdout, tt.drops) {
+				t.Errorf("expected %q to be removed, got:\n%s", tt.drops, stdout)
+			}
+		})
+	}
+
+	// A misspelled pass stops the compiler before it reads any source
+	for _, flag := range []string{"--opt=ConstantFolding,Inlining", "--disable-pass=Inlining"} {
+		t.Run(flag, func(t *testing.T) {
+			stdout, stderr, code := runCompilerStderr(t, flag, "missing.src")
+			if code != 2 {
+				t.Errorf("expected exit code 2, got %d", code)
+			}
+			if !strings.Contains(stderr, `unknown optimization pass "Inlining" (available: AlgebraicSimplification, ConstantFolding, DeadCodeElimination, ValueNumbering)`) {
+				t.Errorf("expected the error to list the passes, got:\n%s", stderr)
+			}
+			if strings.Contains(stderr, "Error reading file") || stdout != "" {
+				t.Errorf("expected nothing to be compiled, got:\n%s%s", stdout, stderr)
+			}
+		})
+	}
+}
+
+func TestFormat(t *testing.T) {
+	formatted := "package main\n\nfunc main() int {\n    return 1 + 2;\n}\n"
+	messy := writeSource(t, "package main\nfunc main() int {\n  return 1+2;\n}\n")
+
+	t.Run("prints the formatted source", func(t *testing.T) {
+		stdout, code := runCompiler(t, "--format", messy)
+		if code != 0 {
+			t.Fatalf("expected exit code 0, got %d", code)
+		}
+		if stdout != formatted {
+			t.Errorf("got:\n%s\nwant:\n%s", stdout, formatted)
+		}
+	})
+
+	t.Run("check fails for unformatted source", func(t *testing.T) {
+		stdout, code := runCompiler(t, "--format", "--check", messy)
+		if code != 1 {
+			t.Errorf("expected exit code 1, got %d", code)
+		}
+		if strings.TrimSpace(stdout) != messy {
+			t.Errorf("expected the file name on stdout, got %q", stdout)
+		}
+	})
+
+	t.Run("check passes for formatted source", func(t *testing.T) {
+		stdout, code := runCompiler(t, "--format", "--check", writeSource(t, formatted))
+		if code != 0 || stdout != "" {
+			t.Errorf("expected exit code 0 and no output, got %d and %q", code, stdout)
+		}
+	})
+
+	t.Run("type errors don't matter", func(t *testing.T) {
+		source := writeSource(t, "package main\n\nfunc main() int {\n    return missing;\n}\n")
+		if _, code := runCompiler(t, "--format", "--check", source); code != 0 {
+			t.Errorf("expected exit code 0, got %d", code)
+		}
+	})
+
+	t.Run("syntax errors do", func(t *testing.T) {
+		source := writeSource(t, "package main\n\nfunc main() int {\n    return 1 +\n}\n")
+		if _, code := runCompiler(t, "--format", source); code != 1 {
+			t.Errorf("expected exit code 1, got %d", code)
+		}
+	})
+}
+
+// TestGolden compiles each program in testdata and compares what every
+// stage of the pipeline makes of it - tokens, syntax tree, IR before and
+// after optimization - with testdata/golden/<program>/<stage>.txt, so that
+// a change to any stage shows up as a diff of its output. An optimizer
+// change that was meant to be a refactoring should leave the optimized IR
+// as it was. Run with UPDATE_GOLDEN=1 to accept the new output.
+func TestGolden(t *testing.T) {
+	programs, err := filepath.Glob(filepath.Join("testdata", "*.src"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(programs) == 0 {
+		t.Fatal("no programs in testdata")
+	}
+
+	for _, path := range programs {
+		name := strings.TrimSuffix(filepath.Base(path), ".src")
+		t.Run(name, func(t *testing.T) {
+			sources, err := readSources([]string{path})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var tokens strings.Builder
+			if !writeTokens(&tokens, sources[0]) {
+				t.Errorf("lexer errors:\n%s", tokens.String())
+			}
+			testutil.CheckGolden(t, name+"/tokens", tokens.String())
+
+			result, cerr := compiler.CompilePackage(sources, compiler.Options{OptLevel: optimizer.O1})
+			if cerr != nil {
+				t.Fatalf("compiling %s: %v", path, cerr)
+			}
+
+			var tree strings.Builder
+			if err := ast.Print(result.File, &tree); err != nil {
+				t.Fatal(err)
+			}
+			testutil.CheckGolden(t, name+"/ast", tree.String())
+			testutil.CheckGolden(t, name+"/ir-unoptimized", result.UnoptimizedIR)
+			testutil.CheckGolden(t, name+"/ir-optimized", result.Module.String())
+		})
+	}
+}
+
+// compileInProcess calls run with args, and returns what it wrote to stdout
+// and stderr and the exit status. Unlike runCompiler it needs no child
+// process, since run neither exits nor touches the real standard streams.
+func compileInProcess(args ...string) (stdout, stderr string, code int) {
+	var out, errOut strings.Builder
+	code = run(args, &out, &errOut)
+	return out.String(), errOut.String(), code
+}
+
+// section returns the part of out after heading, up to the next "=== "
+// heading or the end.
+func section(out, heading string) string {
+	_, after, found := strings.Cut(out, heading)
+	if !found {
+		return ""
+	}
+	if next := strings.Index(after, "=== "); next >= 0 {
+		after = after[:next]
+	}
+	return after
+}
+
+func TestPipeline(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		code   int
+		// stdout and stderr must contain these, in this order
+		stdout []string
+		stderr []string
+		// and stdout must not contain these
+		absent []string
+	}{
+		{
+			name:   "syntax error",
+			source: "package main\n\nfunc main() {\n    var x int = ;\n}\n",
+			code:   1,
+			stderr: []string{"Parsing errors:", "4:17: expected expression, got SEMICOLON"},
+			absent: []string{"✓", "=== "},
+		},
+		{
+			name:   "missing package clause",
+			source: "func main() {\n}\n",
+			code:   1,
+			stderr: []string{"Parsing errors:", "1:1: expected 'package' declaration at start of file"},
+			absent: []string{"✓", "=== "},
+		},
+		{
+			name:   "unnamed package clause",
+			source: "package\n\nfunc main() {\n}\n",
+			code:   1,
+			stderr: []string{"Parsing errors:", "3:1: expected package name"},
+			absent: []string{"✓", "=== "},
+		},
+		{
+			// What the phases before the failure did is still reported
+			name:   "type error",
+			source: "package main\n\nfunc main() {\n    var x int = \"one\";\n    println(x);\n}\n",
+			code:   1,
+			stdout: []string{"✓ Parsing successful\n"},
+			stderr: []string{"Semantic errors:", "4:17: cannot assign string to int"},
+			absent: []string{"✓ Semantic", "=== "},
+		},
+		{
+			name:   "valid program",
+			source: "package main\n\nfunc main() int {\n    return 40 + 2;\n}\n",
+			code:   0,
+			stdout: []string{
+				"✓ Parsing successful\n",
+				"✓ Semantic analysis successful\n",
+				"✓ IR generation successful\n",
+				"=== Unoptimized IR ===", "t0 = const(40) + const(2)",
+				"✓ Optimization successful\n",
+				"=== Optimized IR ===", "t0 = const(42)",
+				"=== Compilation Summary ===", "Package: main\n", "1. Function: main\n",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, stderr, code := compileInProcess("--emit-ir", writeSource(t, tt.source))
+			if code != tt.code {
+				t.Errorf("expected exit code %d, got %d\nstderr:\n%s", tt.code, code, stderr)
+			}
+			for _, stream := range []struct {
+				name, text string
+				want       []string
+			}{{"stdout", stdout, tt.stdout}, {"stderr", stderr, tt.stderr}} {
+				rest := stream.text
+				for _, want := range stream.want {
+					i := strings.Index(rest, want)
+					if i < 0 {
+						t.Errorf("expected %q in %s (in this order), got:\n%s", want, stream.name, stream.text)
+						break
+					}
+					rest = rest[i+len(want):]
+				}
+			}
+			for _, unwanted := range tt.absent {
+				if strings.Contains(stdout, unwanted) {
+					t.Errorf("expected no %q in stdout, got:\n%s", unwanted, stdout)
+				}
+			}
+			if tt.code == 0 && stderr != "" {
+				t.Errorf("expected nothing on stderr, got:\n%s", stderr)
+			}
+		})
+	}
+}
+
+func TestPipeline_OptimizationChangesIR(t *testing.T) {
+	path := filepath.Join("..", "..", "testdata", "valid", "constant_folding.src")
+	stdout, stderr, code := compileInProcess("--emit-ir", path)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d\nstderr:\n%s", code, stderr)
+	}
+
+	unoptimized := section(stdout, "=== Unoptimized IR ===")
+	optimized := section(stdout, "=== Optimized IR ===")
+	if unoptimized == "" || optimized == "" {
+		t.Fatalf("expected both IR sections, got:\n%s", stdout)
+	}
+	if unoptimized == optimized {
+		t.Errorf("expected optimization to change the IR of %s, got the same IR twice:\n%s", path, optimized)
+	}
+	// Folding leaves fewer instructions, not just different ones
+	if strings.Count(optimized, "\n") >= strings.Count(unoptimized, "\n") {
+		t.Errorf("expected the optimized IR to be shorter:\nunoptimized:\n%s\noptimized:\n%s", unoptimized, optimized)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		code int
+		want string
+	}{
+		{"no files", nil, 1, "Usage: compiler [run|build] [flags]"},
+		{"no files to run", []string{"run"}, 1, "Usage: compiler [run|build] [flags]"},
+		{"unknown flag", []string{"--no-such-flag", "main.src"}, 2, "flag provided but not defined: -no-such-flag"},
+		{"help", []string{"-h"}, 0, "-emit-ir stage"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, stderr, code := compileInProcess(tt.args...)
+			if code != tt.code {
+				t.Errorf("expected exit code %d, got %d", tt.code, code)
+			}
+			if !strings.Contains(stderr, tt.want) {
+				t.Errorf("expected %q in stderr, got:\n%s", tt.want, stderr)
+			}
+			if stdout != "" {
+				t.Errorf("expected nothing on stdout, got:\n%s", stdout)
+			}
+		})
+	}
+}
+
+func TestPipeline_Repeatable(t *testing.T) {
+	// Flags are parsed afresh on every call: one run's flags don't leak into
+	// the next
+	path := writeSource(t, "package main\n\nfunc main() int {\n    return 7;\n}\n")
+	if _, _, code := compileInProcess("run", path); code != 7 {
+		t.Fatalf("expected the program's exit code 7, got %d", code)
+	}
+	stdout, _, code := compileInProcess("--emit-ast", path)
+	if code != 0 || !strings.HasPrefix(stdout, "File\n") {
+		t.Fatalf("expected just the AST, got exit code %d and:\n%s", code, stdout)
+	}
+	stdout, _, code = compileInProcess(path)
+	if code != 0 || !strings.Contains(stdout, "=== Compilation Summary ===") || strings.Contains(stdout, "File\n") {
+		t.Errorf("expected a plain compilation, got exit code %d and:\n%s", code, stdout)
+	}
+}
+
+// TestCache compiles a program twice with --cache and checks the second
+// run comes from the cache, by swapping the stored module for another
+// program's before it: the second run runs that one. A changed source, or
+// a program with warnings, is compiled.
+func TestCache(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "cache")
+	path := writeSource(t, "package main\n\nfunc main() int {\n    return 3;\n}\n")
+	entries := func() []string {
+		files, _ := filepath.Glob(filepath.Join(dir, "*.ir"))
+		return files
+	}
+
+	if _, stderr, code := compileInProcess("run", "--cache", dir, path); code != 3 {
+		t.Fatalf("expected exit code 3, got %d: %s", code, stderr)
+	}
+	stored := entries()
+	if len(stored) != 1 {
+		t.Fatalf("expected one cache entry, got %v", stored)
+	}
+
+	other, err := compiler.Compile([]byte("package main\n\nfunc main() int {\n    return 5;\n}\n"), "other.src", compiler.Options{OptLevel: optimizer.O1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	text, err := other.Module.MarshalText()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(stored[0], text, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, code := compileInProcess("run", "--cache", dir, path); code != 5 {
+		t.Errorf("expected the cached module's exit code 5, got %d", code)
+	}
+	// Other flags are another entry
+	if _, _, code := compileInProcess("run", "--cache", dir, "-O0", path); code != 3 {
+		t.Errorf("expected -O0 to compile (exit code 3), got %d", code)
+	}
+
+	if err := os.WriteFile(path, []byte("package main\n\nfunc main() int {\n    return 4;\n}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, code := compileInProcess("run", "--cache", dir, path); code != 4 {
+		t.Errorf("expected the changed program's exit code 4, got %d", code)
+	}
+	if got := entries(); len(got) != 3 {
+		t.Errorf("expected three cache entries, got %v", got)
+	}
+
+	// A warning, reported on every compilation, keeps a program out
+	warned := writeSource(t, "package main\n\nfunc main() int {\n    var unused int = 1;\n    return 6;\n}\n")
+	for i := 0; i < 2; i++ {
+		if _, stderr, code := compileInProcess("run", "--cache", dir, warned); code != 6 || !strings.Contains(stderr, "unused") {
+			t.Errorf("run %d: expected exit code 6 and the warning, got %d and %q", i+1, code, stderr)
+		}
+	}
+	if got := entries(); len(got) != 3 {
+		t.Errorf("expected no entry for the program with a warning, got %v", got)
+	}
+}
@@ -0,0 +1,50 @@
+// Package main provides a Language Server Protocol server for the language,
+// so that an editor can show the compiler's errors as the program is typed
+// and answer hover and go-to-definition.
+//
+// The server speaks LSP over stdin and stdout, the way editors start
+// language servers:
+//
+//	"command": ["lsp"]
+//
+// It implements the requests an editor needs for the basics:
+//   - textDocument/didOpen, didChange and didClose keep the text of each open
+//     file, sent in full on every change
+//   - after every change the file is parsed and analyzed again, and
+//     textDocument/publishDiagnostics sends its syntax and semantic errors,
+//     and its warnings
+//   - textDocument/hover returns the type of the expression at the cursor
+//     (see semantic.Analyzer.TypeAt)
+//   - textDocument/definition returns where the name at the cursor is
+//     declared (see semantic.Analyzer.DefinitionOf)
+//
+// DESIGN CHOICE: The protocol is written out here, in jsonrpc.go and
+// protocol.go, rather than taken from an LSP library. The compiler has no
+// dependencies outside the standard library, and the part of LSP this server
+// speaks - Content-Length framing, a handful of JSON shapes - is a few pages
+// of encoding/json.
+//
+// DESIGN CHOICE: Full-text synchronization, and the whole file compiled
+// again on every change, rather than the edits applied to the previous
+// text and AST (compiler.Reparse). A file is compiled to the end of
+// semantic analysis in well under a millisecond, and the full text spares
+// the server keeping its copy of the file in step with the editor's by
+// replaying edits.
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func main() {
+	s := newServer(os.Stdin, os.Stdout)
+	if err := s.serve(); err != nil {
+		fmt.Fprintf(os.Stderr, "lsp: %v\n", err)
+		os.Exit(1)
+	}
+	if !s.shutdown {
+		// An exit without a shutdown request first, as the protocol asks
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"unicode/utf8"
+
+	"github.com/hassan/compiler/internal/lexer"
+)
+
+// The LSP structures the server reads and writes, with only the fields it
+// uses. Names follow the specification's.
+
+type initializeResult struct {
+	Capabilities serverCapabilities `json:"capabilities"`
+	ServerInfo   serverInfo         `json:"serverInfo"`
+}
+
+type serverCapabilities struct {
+	TextDocumentSync   int  `json:"textDocumentSync"` // syncFull
+	HoverProvider      bool `json:"hoverProvider"`
+	DefinitionProvider bool `json:"definitionProvider"`
+}
+
+type serverInfo struct {
+	Name string `json:"name"`
+}
+
+// syncFull is TextDocumentSyncKind.Full: every change sends the whole text.
+const syncFull = 1
+
+type textDocumentItem struct {
+	URI  string `json:"uri"`
+	Text string `json:"text"`
+}
+
+type textDocumentIdentifier struct {
+	URI string `json:"uri"`
+}
+
+type didOpenParams struct {
+	TextDocument textDocumentItem `json:"textDocument"`
+}
+
+type didChangeParams struct {
+	TextDocument   textDocumentIdentifier `json:"textDocument"`
+	ContentChanges []struct {
+		Text string `json:"text"`
+	} `json:"contentChanges"`
+}
+
+type didCloseParams struct {
+	TextDocument textDocumentIdentifier `json:"textDocument"`
+}
+
+// textDocumentPositionParams are the parameters of hover and definition.
+type textDocumentPositionParams struct {
+	TextDocument textDocumentIdentifier `json:"textDocument"`
+	Position     position               `json:"position"`
+}
+
+// position is a place in a document: a zero-based line, and a character
+// offset into it counted in UTF-16 code units, as LSP counts by default.
+type position struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start position `json:"start"`
+	End   position `json:"end"`
+}
+
+type location struct {
+	URI   string   `json:"uri"`
+	Range lspRange `json:"range"`
+}
+
+// Diagnostic severities
+const (
+	severityError       = 1
+	severityWarning     = 2
+	severityInformation = 3
+)
+
+type diagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"`
+	Code     string   `json:"code,omitempty"`
+	Source   string   `json:"source"`
+	Message  string   `json:"message"`
+}
+
+type publishDiagnosticsParams struct {
+	URI         string       `json:"uri"`
+	Diagnostics []diagnostic `json:"diagnostics"`
+}
+
+type hover struct {
+	Contents markupContent `json:"contents"`
+	Range    *lspRange     `json:"range,omitempty"`
+}
+
+type markupContent struct {
+	Kind  string `json:"kind"`
+	Value string `json:"value"`
+}
+
+// Converting positions.
+//
+// The compiler locates things by byte offset, and LSP by line and UTF-16
+// character, so the two conversions walk the text. Files are small enough
+// that walking them for each position costs nothing an editor notices.
+
+// toPosition returns the LSP position of the byte offset in text.
+func toPosition(text string, offset int) position {
+	if offset > len(text) {
+		offset = len(text)
+	}
+	var pos position
+	for i, r := range text[:offset] {
+		if r == '\n' {
+			pos.Line++
+			pos.Character = 0
+			continue
+		}
+		if i+utf8.RuneLen(r) > offset {
+			break // offset is inside a character
+		}
+		pos.Character += utf16Len(r)
+	}
+	return pos
+}
+
+// toOffset returns the compiler position of an LSP position in text, in the
+// file filename. A character past the end of its line is the end of the line.
+func toOffset(text, filename string, pos position) lexer.Position {
+	result := lexer.Position{Filename: filename, Line: 1, Column: 1}
+	for result.Offset < len(text) && result.Line-1 < pos.Line {
+		if text[result.Offset] == '\n' {
+			result.Line++
+		}
+		result.Offset++
+	}
+	for units := 0; result.Offset < len(text) && units < pos.Character; {
+		r, size := utf8.DecodeRuneInString(text[result.Offset:])
+		if r == '\n' {
+			break
+		}
+		units += utf16Len(r)
+		result.Offset += size
+		result.Column++
+	}
+	return result
+}
+
+// utf16Len is the number of UTF-16 code units encoding r.
+func utf16Len(r rune) int {
+	if r >= 0x10000 {
+		return 2
+	}
+	return 1
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hassan/compiler/internal/errors"
+	"github.com/hassan/compiler/internal/parser/ast"
+	"github.com/hassan/compiler/internal/symtab"
+	"github.com/hassan/compiler/pkg/compiler"
+)
+
+// server holds the open documents and answers the editor's messages one at
+// a time, in the order they arrive.
+type server struct {
+	in  *bufio.Reader
+	out io.Writer
+
+	// documents are the open files, by URI
+	documents map[string]*document
+
+	// initialized is set by the initialize request, shutdown by the
+	// shutdown request; after it, only exit is expected
+	initialized bool
+	shutdown    bool
+}
+
+// document is an open file: its text as the editor has it, and the result
+// of compiling that text as far as semantic analysis.
+type document struct {
+	uri      string
+	filename string
+	text     string
+	result   *compiler.Result
+}
+
+func newServer(in io.Reader, out io.Writer) *server {
+	return &server{
+		in:        bufio.NewReader(in),
+		out:       out,
+		documents: make(map[string]*document),
+	}
+}
+
+// serve handles messages until the exit notification or the end of the
+// input. The error is for a stream that can't be read or written, after
+// which there is no way to go on.
+func (s *server) serve() error {
+	for {
+		content, err := readMessage(s.in)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var req request
+		if err := json.Unmarshal(content, &req); err != nil {
+			if err := s.respondError(json.RawMessage("null"), codeParseError, err.Error()); err != nil {
+				return err
+			}
+			continue
+		}
+		if req.Method == "exit" {
+			return nil
+		}
+		if err := s.handle(&req); err != nil {
+			return err
+		}
+	}
+}
+
+// handle dispatches one message. Requests are answered, with a
+// MethodNotFound error for those the server doesn't implement;
+// notifications it doesn't implement are ignored, as the protocol asks.
+func (s *server) handle(req *request) error {
+	if !s.initialized && req.Method != "initialize" {
+		if req.isNotification() {
+			return nil
+		}
+		return s.respondError(req.ID, codeNotInitialized, "the server is not initialized")
+	}
+
+	switch req.Method {
+	case "initialize":
+		s.initialized = true
+		return s.respond(req.ID, initializeResult{
+			Capabilities: serverCapabilities{
+				TextDocumentSync:   syncFull,
+				HoverProvider:      true,
+				DefinitionProvider: true,
+			},
+			ServerInfo: serverInfo{Name: "compiler-lsp"},
+		})
+
+	case "shutdown":
+		s.shutdown = true
+		return s.respond(req.ID, nil)
+
+	case "textDocument/didOpen":
+		var params didOpenParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil // A notification has no way to report bad params
+		}
+		doc := &document{uri: params.TextDocument.URI, filename: uriToFilename(params.TextDocument.URI)}
+		s.documents[doc.uri] = doc
+		return s.update(doc, params.TextDocument.Text)
+
+	case "textDocument/didChange":
+		var params didChangeParams
+		if err := json.Unmarshal(req.Params, &params); err != nil || len(params.ContentChanges) == 0 {
+			return nil
+		}
+		doc := s.documents[params.TextDocument.URI]
+		if doc == nil {
+			return nil
+		}
+		// With full synchronization each change is the whole text, so the
+		// last one is all that counts
+		return s.update(doc, params.ContentChanges[len(params.ContentChanges)-1].Text)
+
+	case "textDocument/didClose":
+		var params didCloseParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil
+		}
+		delete(s.documents, params.TextDocument.URI)
+		// Diagnostics for a closed file are the editor's to forget, but
+		// clearing them is what every server does
+		return s.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{
+			URI:         params.TextDocument.URI,
+			Diagnostics: []diagnostic{},
+		})
+
+	case "textDocument/hover":
+		var params textDocumentPositionParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return s.respondError(req.ID, codeInvalidParams, err.Error())
+		}
+		return s.respond(req.ID, s.hover(params))
+
+	case "textDocument/definition":
+		var params textDocumentPositionParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return s.respondError(req.ID, codeInvalidParams, err.Error())
+		}
+		return s.respond(req.ID, s.definition(params))
+	}
+
+	if req.isNotification() {
+		return nil
+	}
+	return s.respondError(req.ID, codeMethodNotFound, "method not supported: "+req.Method)
+}
+
+// update compiles doc with its new text and publishes its diagnostics.
+func (s *server) update(doc *document, text string) error {
+	doc.text = text
+	doc.result, _ = compiler.Compile([]byte(text), doc.filename, compiler.Options{StopAfter: compiler.PhaseSemantic})
+
+	diagnostics := []diagnostic{} // An empty array clears the previous ones
+	for _, diag := range append(append([]errors.CompileError{}, doc.result.Diagnostics...), doc.result.Warnings...) {
+		if diag.Pos.IsValid() && diag.Pos.Filename != doc.filename {
+			continue // In an imported package
+		}
+		diagnostics = append(diagnostics, diagnostic{
+			Range:    doc.diagnosticRange(diag),
+			Severity: severity(diag.Severity),
+			Code:     diag.Code,
+			Source:   "compiler",
+			Message:  diag.Message,
+		})
+	}
+	return s.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{
+		URI:         doc.uri,
+		Diagnostics: diagnostics,
+	})
+}
+
+// diagnosticRange returns the range a diagnostic underlines. Most
+// diagnostics have only a start; they cover the innermost syntax node
+// starting there - the name that is undefined, the expression of the wrong
+// type - or else the word there.
+func (doc *document) diagnosticRange(diag errors.CompileError) lspRange {
+	start := diag.Pos.Offset
+	if !diag.Pos.IsValid() {
+		start = 0
+	}
+	end := start
+	switch {
+	case diag.End.IsValid() && diag.End.Offset > start:
+		end = diag.End.Offset
+	case doc.result.File != nil && diag.Pos.IsValid():
+		if node := ast.FindNodeAt(doc.result.File, diag.Pos); node != nil && node.Pos().Offset == start && node.End().Offset > start {
+			end = node.End().Offset
+		}
+	}
+	if end == start {
+		end = wordEnd(doc.text, start)
+	}
+	return lspRange{Start: toPosition(doc.text, start), End: toPosition(doc.text, end)}
+}
+
+// wordEnd returns the end of the identifier or number at offset in text, or
+// of the single character there if it is neither.
+func wordEnd(text string, offset int) int {
+	end := offset
+	for end < len(text) && isWordByte(text[end]) {
+		end++
+	}
+	if end == offset && end < len(text) && text[end] != '\n' {
+		end++
+	}
+	return end
+}
+
+func isWordByte(b byte) bool {
+	return b == '_' || b >= '0' && b <= '9' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= 0x80
+}
+
+func severity(s errors.DiagnosticSeverity) int {
+	switch s {
+	case errors.SeverityWarning:
+		return severityWarning
+	case errors.SeverityInfo:
+		return severityInformation
+	default:
+		return severityError
+	}
+}
+
+// hover answers a hover request: the type of what is under the cursor, and
+// what it is if it's a name ("parameter p int"), or nil (null) for nothing,
+// including in a document that didn't parse.
+func (s *server) hover(params textDocumentPositionParams) *hover {
+	doc := s.documents[params.TextDocument.URI]
+	if doc == nil || doc.result.Analyzer == nil {
+		return nil
+	}
+	pos := toOffset(doc.text, doc.filename, params.Position)
+	typ, symbol := doc.result.Analyzer.TypeAt(doc.result.File, pos)
+	if typ == nil {
+		return nil
+	}
+	value := typ.String()
+	switch {
+	case symbol == nil:
+	case symbol.Kind == symtab.SymbolStruct:
+		// The type says it all: "struct Point"
+	case symbol.Kind == symtab.SymbolType:
+		value = "type " + symbol.Name
+	default:
+		value = fmt.Sprintf("%s %s %s", symbol.Kind, symbol.Name, typ)
+	}
+	return &hover{Contents: markupContent{Kind: "plaintext", Value: value}}
+}
+
+// definition answers a definition request: where the name under the
+// cursor is declared, or nil (null) if it isn't a declared name.
+func (s *server) definition(params textDocumentPositionParams) *location {
+	doc := s.documents[params.TextDocument.URI]
+	if doc == nil || doc.result.Analyzer == nil {
+		return nil
+	}
+	decl, ok := doc.result.Analyzer.DefinitionOf(toOffset(doc.text, doc.filename, params.Position))
+	if !ok {
+		return nil
+	}
+
+	// A declaration in an imported package is in a file the editor may not
+	// have open
+	uri, text := doc.uri, doc.text
+	if decl.Filename != doc.filename {
+		uri = filenameToURI(decl.Filename)
+		if open := s.documents[uri]; open != nil {
+			text = open.text
+		} else if data, err := os.ReadFile(decl.Filename); err == nil {
+			text = string(data)
+		} else {
+			return nil
+		}
+	}
+	start := toPosition(text, decl.Offset)
+	return &location{URI: uri, Range: lspRange{Start: start, End: start}}
+}
+
+// uriToFilename returns the path of a file: URI, or the URI itself for any
+// other scheme (an unsaved buffer, say), which is still a name to put in
+// positions.
+func uriToFilename(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	return filepath.FromSlash(u.Path)
+}
+
+// filenameToURI is the inverse of uriToFilename, for a path.
+func filenameToURI(filename string) string {
+	if strings.Contains(filename, ":") && !filepath.IsAbs(filename) {
+		return filename // Already a URI
+	}
+	if abs, err := filepath.Abs(filename); err == nil {
+		filename = abs
+	}
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(filename)}).String()
+}
+
+// respond sends the result of a request.
+func (s *server) respond(id json.RawMessage, result interface{}) error {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	raw := json.RawMessage(data)
+	return writeMessage(s.out, response{JSONRPC: "2.0", ID: id, Result: &raw})
+}
+
+// respondError sends the error a request failed with.
+func (s *server) respondError(id json.RawMessage, code int, message string) error {
+	return writeMessage(s.out, response{JSONRPC: "2.0", ID: id, Error: &responseError{Code: code, Message: message}})
+}
+
+// notify sends a notification.
+func (s *server) notify(method string, params interface{}) error {
+	return writeMessage(s.out, notification{JSONRPC: "2.0", Method: method, Params: params})
+}
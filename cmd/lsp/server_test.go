@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hassan/compiler/internal/lexer"
+)
+
+// client drives a server over a pair of pipes, as an editor would over the
+// server's stdin and stdout.
+type client struct {
+	t      *testing.T
+	in     io.WriteCloser
+	out    *bufio.Reader
+	done   chan error
+	nextID int
+}
+
+// message is any message from the server, decoded loosely.
+type message struct {
+	ID     *int            `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  *responseError  `json:"error"`
+}
+
+func startServer(t *testing.T) *client {
+	t.Helper()
+	clientToServer, serverIn := io.Pipe()
+	serverOut, serverToClient := io.Pipe()
+	c := &client{t: t, in: serverIn, out: bufio.NewReader(serverOut), done: make(chan error, 1)}
+	go func() {
+		err := newServer(clientToServer, serverToClient).serve()
+		serverToClient.Close()
+		c.done <- err
+	}()
+	t.Cleanup(func() { c.in.Close() })
+	return c
+}
+
+// send writes a message to the server.
+func (c *client) send(msg map[string]interface{}) {
+	c.t.Helper()
+	msg["jsonrpc"] = "2.0"
+	if err := writeMessage(c.in, msg); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// notify sends a notification.
+func (c *client) notify(method string, params interface{}) {
+	c.t.Helper()
+	c.send(map[string]interface{}{"method": method, "params": params})
+}
+
+// request sends a request and returns its response.
+func (c *client) request(method string, params interface{}) message {
+	c.t.Helper()
+	c.nextID++
+	c.send(map[string]interface{}{"id": c.nextID, "method": method, "params": params})
+	msg := c.read()
+	if msg.ID == nil || *msg.ID != c.nextID {
+		c.t.Fatalf("expected the response to request %d, got %+v", c.nextID, msg)
+	}
+	return msg
+}
+
+// read returns the next message from the server.
+func (c *client) read() message {
+	c.t.Helper()
+	content, err := readMessage(c.out)
+	if err != nil {
+		c.t.Fatalf("reading from the server: %v", err)
+	}
+	var msg message
+	if err := json.Unmarshal(content, &msg); err != nil {
+		c.t.Fatalf("decoding %s: %v", content, err)
+	}
+	return msg
+}
+
+// diagnostics reads the next message, which must publish diagnostics.
+func (c *client) diagnostics() publishDiagnosticsParams {
+	c.t.Helper()
+	msg := c.read()
+	if msg.Method != "textDocument/publishDiagnostics" {
+		c.t.Fatalf("expected diagnostics, got %+v", msg)
+	}
+	var params publishDiagnosticsParams
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		c.t.Fatal(err)
+	}
+	return params
+}
+
+func (c *client) initialize() {
+	c.t.Helper()
+	if msg := c.request("initialize", map[string]interface{}{"capabilities": map[string]interface{}{}}); msg.Error != nil {
+		c.t.Fatalf("initialize failed: %+v", msg.Error)
+	}
+	c.notify("initialized", map[string]interface{}{})
+}
+
+func (c *client) open(uri, text string) publishDiagnosticsParams {
+	c.t.Helper()
+	c.notify("textDocument/didOpen", map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": uri, "languageId": "compiler", "version": 1, "text": text},
+	})
+	return c.diagnostics()
+}
+
+const uri = "file:///work/main.src"
+
+const badSource = `package main
+
+func main() {
+    var count int = "three";
+    println(count);
+}
+`
+
+func TestServer_Diagnostics(t *testing.T) {
+	c := startServer(t)
+	c.initialize()
+
+	published := c.open(uri, badSource)
+	if published.URI != uri {
+		t.Errorf("diagnostics for %q, want %q", published.URI, uri)
+	}
+	want := []diagnostic{{
+		// The string literal on line 4 (0-based 3)
+		Range:    lspRange{Start: position{Line: 3, Character: 20}, End: position{Line: 3, Character: 27}},
+		Severity: severityError,
+		Code:     "E003",
+		Source:   "compiler",
+		Message:  "cannot assign string to int",
+	}}
+	if !reflect.DeepEqual(published.Diagnostics, want) {
+		t.Errorf("diagnostics = %+v, want %+v", published.Diagnostics, want)
+	}
+
+	// Fixing the error clears it
+	c.notify("textDocument/didChange", map[string]interface{}{
+		"textDocument":   map[string]interface{}{"uri": uri, "version": 2},
+		"contentChanges": []interface{}{map[string]interface{}{"text": strings.Replace(badSource, `"three"`, "3", 1)}},
+	})
+	if published := c.diagnostics(); len(published.Diagnostics) != 0 {
+		t.Errorf("expected no diagnostics after the fix, got %+v", published.Diagnostics)
+	}
+
+	// A syntax error is reported too, and a warning as a warning
+	c.notify("textDocument/didChange", map[string]interface{}{
+		"textDocument":   map[string]interface{}{"uri": uri, "version": 3},
+		"contentChanges": []interface{}{map[string]interface{}{"text": "package main\n\nfunc main() {\n    var unused int = 1;\n}\n"}},
+	})
+	if published := c.diagnostics(); len(published.Diagnostics) != 1 || published.Diagnostics[0].Severity != severityWarning {
+		t.Errorf("expected one warning, got %+v", published.Diagnostics)
+	}
+	c.notify("textDocument/didChange", map[string]interface{}{
+		"textDocument":   map[string]interface{}{"uri": uri, "version": 4},
+		"contentChanges": []interface{}{map[string]interface{}{"text": "package main\n\nfunc main( {\n}\n"}},
+	})
+	if published := c.diagnostics(); len(published.Diagnostics) == 0 || published.Diagnostics[0].Code != "E002" {
+		t.Errorf("expected a syntax error, got %+v", published.Diagnostics)
+	}
+}
+
+const querySource = `package main
+
+struct Point {
+    x int;
+}
+
+func twice(p Point) int {
+    return p.x * 2;
+}
+
+func größe(p Point) int {
+    var é int = twice(p) + p.x;
+    return é;
+}
+`
+
+func TestServer_HoverAndDefinition(t *testing.T) {
+	c := startServer(t)
+	c.initialize()
+	c.open(uri, querySource)
+
+	at := func(line, character int) map[string]interface{} {
+		return map[string]interface{}{
+			"textDocument": map[string]interface{}{"uri": uri},
+			"position":     map[string]interface{}{"line": line, "character": character},
+		}
+	}
+
+	tests := []struct {
+		name      string
+		line, col int
+		wantHover string // "" for null
+		wantDecl  *position
+	}{
+		{"a parameter", 7, 11, "parameter p struct Point", &position{Line: 6, Character: 11}},
+		{"a field", 7, 13, "field x int", &position{Line: 3, Character: 4}},
+		{"a type name", 6, 13, "struct Point", &position{Line: 2, Character: 0}},
+		{"an expression", 7, 15, "int", nil},
+		{"a keyword", 7, 5, "", nil},
+		// Characters and columns count "é" once, where its bytes are two
+		{"after a multi-byte name", 11, 22, "parameter p struct Point", &position{Line: 10, Character: 11}},
+		{"a field after a multi-byte name", 11, 29, "field x int", &position{Line: 3, Character: 4}},
+		{"a multi-byte name", 12, 11, "variable é int", &position{Line: 11, Character: 8}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := c.request("textDocument/hover", at(tt.line, tt.col))
+			var got *hover
+			if err := json.Unmarshal(msg.Result, &got); err != nil {
+				t.Fatal(err)
+			}
+			switch {
+			case tt.wantHover == "" && got != nil:
+				t.Errorf("expected no hover, got %+v", got)
+			case tt.wantHover != "" && (got == nil || got.Contents.Value != tt.wantHover):
+				t.Errorf("expected hover %q, got %+v", tt.wantHover, got)
+			}
+
+			msg = c.request("textDocument/definition", at(tt.line, tt.col))
+			var loc *location
+			if err := json.Unmarshal(msg.Result, &loc); err != nil {
+				t.Fatal(err)
+			}
+			switch {
+			case tt.wantDecl == nil && loc != nil:
+				t.Errorf("expected no definition, got %+v", loc)
+			case tt.wantDecl != nil && (loc == nil || loc.URI != uri || loc.Range.Start != *tt.wantDecl):
+				t.Errorf("expected the definition at %+v, got %+v", *tt.wantDecl, loc)
+			}
+		})
+	}
+}
+
+func TestServer_Lifecycle(t *testing.T) {
+	c := startServer(t)
+
+	if msg := c.request("textDocument/hover", at0()); msg.Error == nil || msg.Error.Code != codeNotInitialized {
+		t.Errorf("expected a not-initialized error, got %+v", msg)
+	}
+	c.initialize()
+	if msg := c.request("textDocument/completion", at0()); msg.Error == nil || msg.Error.Code != codeMethodNotFound {
+		t.Errorf("expected a method-not-found error, got %+v", msg)
+	}
+	if msg := c.request("shutdown", nil); msg.Error != nil || string(msg.Result) != "null" {
+		t.Errorf("expected a null result for shutdown, got %+v", msg)
+	}
+	c.notify("exit", nil)
+	if err := <-c.done; err != nil {
+		t.Errorf("serve returned %v", err)
+	}
+}
+
+func at0() map[string]interface{} {
+	return map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": uri},
+		"position":     map[string]interface{}{"line": 0, "character": 0},
+	}
+}
+
+func TestReadMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		stream  string
+		want    string
+		wantErr bool
+	}{
+		{"one message", "Content-Length: 2\r\n\r\n{}", "{}", false},
+		{"other headers", "Content-Type: application/vscode-jsonrpc; charset=utf-8\r\ncontent-length: 4\r\n\r\nnull", "null", false},
+		{"no length", "Content-Type: x\r\n\r\n{}", "", true},
+		{"short content", "Content-Length: 10\r\n\r\n{}", "", true},
+		{"bad header", "Content-Length 2\r\n\r\n{}", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readMessage(bufio.NewReader(strings.NewReader(tt.stream)))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("content = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := readMessage(bufio.NewReader(strings.NewReader(""))); err != io.EOF {
+		t.Errorf("an empty stream gave %v, want io.EOF", err)
+	}
+}
+
+func TestPositions(t *testing.T) {
+	// "é" is two bytes and one UTF-16 unit; "𝄞" four bytes and two units
+	text := "ab\né x\n𝄞 y\n"
+	// The column counts runes, as the lexer's do
+	tests := []struct {
+		offset int
+		pos    position
+		column int
+	}{
+		{0, position{0, 0}, 1},
+		{2, position{0, 2}, 3},
+		{3, position{1, 0}, 1},
+		{6, position{1, 2}, 3},  // x
+		{8, position{2, 0}, 1},  // 𝄞
+		{13, position{2, 3}, 3}, // y
+	}
+	for _, tt := range tests {
+		if got := toPosition(text, tt.offset); got != tt.pos {
+			t.Errorf("toPosition(%d) = %+v, want %+v", tt.offset, got, tt.pos)
+		}
+		if got := toOffset(text, "f", tt.pos); got.Offset != tt.offset || got.Column != tt.column {
+			t.Errorf("toOffset(%+v) = offset %d column %d, want offset %d column %d", tt.pos, got.Offset, got.Column, tt.offset, tt.column)
+		}
+	}
+
+	// Every token's LSP position converts back to the position the lexer
+	// gave it
+	source := "var 名前 string = \"héllo\" + s;\n  x = \"𝄞\" + é;\n"
+	lex := lexer.New(source, "f")
+	for {
+		tok, err := lex.NextToken()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tok.Type == lexer.TokenEOF {
+			break
+		}
+		if got := toOffset(source, "f", toPosition(source, tok.Position.Offset)); got != tok.Position {
+			t.Errorf("%s: toOffset gave %+v, the lexer %+v", tok.Lexeme, got, tok.Position)
+		}
+	}
+	if got := toOffset(text, "f", position{0, 50}); got.Offset != 2 {
+		t.Errorf("a character past the end of the line gave offset %d, want 2", got.Offset)
+	}
+}
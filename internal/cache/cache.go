@@ -0,0 +1,74 @@
+// Package cache keeps compiled IR on disk, so compiling a program that
+// hasn't changed since the last time can skip straight to the result.
+//
+// An entry is keyed by a hash of everything the module was compiled from
+// (see cmd/compiler's cacheKey) and holds the module in the IR text format
+// (see ir.Module.MarshalText), one file per entry:
+//
+//	<Dir>/<hex of the hash>.ir
+//
+// DESIGN CHOICE: A plain directory of files named by hash, with nothing to
+// index or lock. Two compilations of the same program write the same
+// bytes, so the only thing to guard against is a reader seeing half a
+// file: Put writes to a temporary file and renames it into place, which is
+// atomic. An entry is never invalidated either - a changed source has a
+// different hash - so clearing the cache is deleting the directory.
+package cache
+
+import (
+	"encoding/hex"
+	"os"
+	"path/filepath"
+
+	"github.com/hassan/compiler/internal/ir"
+)
+
+// Cache is a directory of compiled modules.
+type Cache struct {
+	// Dir is the directory the entries are in. Put creates it if needed.
+	Dir string
+}
+
+// path returns the file the entry for hash is kept in.
+func (c *Cache) path(hash [32]byte) string {
+	return filepath.Join(c.Dir, hex.EncodeToString(hash[:])+".ir")
+}
+
+// Get returns the module stored for hash, and whether there was one. An
+// entry that can't be read - missing, or written in an older format - is a
+// miss, to be compiled and stored again.
+func (c *Cache) Get(hash [32]byte) (*ir.Module, bool) {
+	text, err := os.ReadFile(c.path(hash))
+	if err != nil {
+		return nil, false
+	}
+	module := &ir.Module{}
+	if err := module.UnmarshalText(text); err != nil {
+		return nil, false
+	}
+	return module, true
+}
+
+// Put stores m as the module for hash, replacing any entry there was.
+func (c *Cache) Put(hash [32]byte, m *ir.Module) error {
+	text, err := m.MarshalText()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(c.Dir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(c.Dir, "*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(text); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), c.path(hash))
+}
@@ -0,0 +1,109 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"testing"
+
+	cgen "github.com/hassan/compiler/internal/codegen/c"
+	"github.com/hassan/compiler/internal/ir"
+	"github.com/hassan/compiler/pkg/compiler"
+)
+
+// compile returns the optimized module of source.
+func compile(t *testing.T, source string) *ir.Module {
+	t.Helper()
+	result, err := compiler.Compile([]byte(source), "test.src", compiler.Options{OptLevel: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result.Module
+}
+
+func TestCache_PutGet(t *testing.T) {
+	c := &Cache{Dir: filepath.Join(t.TempDir(), "cache")}
+	source := "package main\nvar n int = 4;\nfunc main() int { return n * 2; }\n"
+	hash := sha256.Sum256([]byte(source))
+	if _, ok := c.Get(hash); ok {
+		t.Fatal("expected a miss in an empty cache")
+	}
+
+	module := compile(t, source)
+	if err := c.Put(hash, module); err != nil {
+		t.Fatal(err)
+	}
+	got, ok := c.Get(hash)
+	if !ok {
+		t.Fatal("expected a hit after Put")
+	}
+	if got.String() != module.String() {
+		t.Errorf("expected\n%s\ngot\n%s", module, got)
+	}
+
+	// Only the entry is left in the directory, under its hash
+	entries, err := os.ReadDir(c.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != filepath.Base(c.path(hash)) {
+		t.Errorf("expected only %s in the cache, got %v", filepath.Base(c.path(hash)), entries)
+	}
+}
+
+// TestCache_Unreadable checks that an entry that doesn't read back - here
+// one in some other format - is a miss rather than an error or a module
+// with a part missing.
+func TestCache_Unreadable(t *testing.T) {
+	c := &Cache{Dir: t.TempDir()}
+	hash := sha256.Sum256([]byte("package main\n"))
+	if err := os.WriteFile(c.path(hash), []byte("; Module: main\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if module, ok := c.Get(hash); ok {
+		t.Errorf("expected a miss, got\n%s", module)
+	}
+}
+
+// TestCache_Programs stores the optimized module of every program the
+// backends are tested with and checks what comes back generates the same
+// C, which depends on every value's type being read back right, not just
+// on the module printing the same.
+func TestCache_Programs(t *testing.T) {
+	programs, err := filepath.Glob(filepath.Join("..", "codegen", "c", "testdata", "*.src"))
+	if err != nil || len(programs) == 0 {
+		t.Fatalf("no programs found: %v", err)
+	}
+	c := &Cache{Dir: t.TempDir()}
+	for _, path := range programs {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			source, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, err := compiler.Compile(source, path, compiler.Options{OptLevel: 2})
+			if err != nil {
+				t.Fatal(err)
+			}
+			hash := sha256.Sum256(source)
+			if err := c.Put(hash, result.Module); err != nil {
+				t.Fatal(err)
+			}
+			module, ok := c.Get(hash)
+			if !ok {
+				t.Fatal("expected a hit after Put")
+			}
+			if got, want := module.String(), result.Module.String(); got != want {
+				t.Errorf("expected\n%s\ngot\n%s", want, got)
+			}
+			want, errs := cgen.Generate(result.Module)
+			if len(errs) > 0 {
+				t.Fatal(errs)
+			}
+			got, errs := cgen.Generate(module)
+			if len(errs) > 0 || got != want {
+				t.Errorf("expected the same C, got errors %v and\n%s", errs, got)
+			}
+		})
+	}
+}
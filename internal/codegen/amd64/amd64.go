@@ -0,0 +1,719 @@
+// Package amd64 translates an IR module into x86-64 assembly (AT&T syntax,
+// as the GNU assembler reads it) for System V systems such as Linux.
+//
+// WHY ASSEMBLY:
+// The C and WebAssembly backends hand the last step to another compiler;
+// this one goes all the way to machine instructions, which is where the
+// register allocation, calling convention and stack frame questions that
+// the IR abstracts away finally have to be answered.
+//
+// DESIGN CHOICE: Spill everything. Every IR value - parameter, variable or
+// temporary - lives in its own 8-byte stack slot, and each instruction loads
+// its operands into %rax and %rcx, computes, and stores the result back.
+// This is the slowest correct strategy, but it needs no liveness analysis
+// and no spill code, so every instruction lowers on its own and the code is
+// easy to check against the IR. A linear-scan allocator can replace it
+// without changing the lowering of individual instructions.
+//
+// CALLING CONVENTION (System V AMD64):
+//   - The first six arguments go in %rdi, %rsi, %rdx, %rcx, %r8 and %r9; the
+//     rest are pushed right to left. The result comes back in %rax
+//   - %rsp is 16-byte aligned at every call, so functions can call libc
+//   - Functions are local symbols named fn_<name>; a global main calls
+//     fn_main and exits with its result
+//
+// SUPPORTED: int and bool values (bools are 0 or 1 in a full 8-byte slot),
+// and strings as pointers to constants, enough for print and println, which
+// call printf. Any other type or instruction is reported as "not yet
+// supported in amd64 backend".
+//
+// Division, remainder and shifts follow the language, not the hardware:
+// idiv traps on MinInt / -1 and shifts use the count modulo 64, so both are
+// guarded inline. Runtime errors print to stderr and exit with status 2,
+// like `compiler run`.
+package amd64
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/hassan/compiler/internal/ir"
+	"github.com/hassan/compiler/internal/semantic/types"
+)
+
+// argRegisters are the System V integer argument registers, in order.
+var argRegisters = []string{"%rdi", "%rsi", "%rdx", "%rcx", "%r8", "%r9"}
+
+// generator holds the state of one translation.
+type generator struct {
+	module *ir.Module
+	text   strings.Builder
+
+	// errors accumulates constructs the backend can't translate
+	errors []error
+
+	// globals is the set of module-level values
+	globals map[*ir.Value]bool
+
+	// strings pools the string constants, by value, as .rodata labels
+	strings     map[string]string
+	stringOrder []string
+
+	// runtime records which runtime pieces the code uses
+	runtime map[string]bool
+
+	// labels numbers the internal labels of guarded operations
+	labels int
+
+	// Per-function state
+
+	fn    *ir.Function
+	slots map[*ir.Value]string
+
+	// blockIndex is each block's position in the function
+	blockIndex map[*ir.BasicBlock]int
+
+	// flags is the comparison whose result the flags register still holds,
+	// if the instruction just emitted was one
+	flags comparison
+}
+
+// comparison is a value computed by a comparison, and the condition code
+// ("le") under which it is true.
+type comparison struct {
+	value *ir.Value
+	cond  string
+}
+
+// Generate translates module into an assembly file for the GNU assembler.
+//
+// The returned errors name each construct that could not be translated; the
+// text is only usable when there are none.
+func Generate(module *ir.Module) (string, []error) {
+	g := &generator{
+		module:  module,
+		globals: make(map[*ir.Value]bool),
+		strings: make(map[string]string),
+		runtime: make(map[string]bool),
+	}
+	for _, global := range module.Globals {
+		g.globals[global] = true
+	}
+
+	for _, fn := range module.Functions {
+		g.function(fn)
+	}
+	g.mainWrapper()
+	g.runtimeFunctions()
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("# Generated from module %s.\n", module.Name))
+	sb.WriteString("\t.text\n")
+	sb.WriteString(g.text.String())
+
+	if len(module.Globals) > 0 {
+		sb.WriteString("\n\t.data\n")
+		for _, global := range module.Globals {
+			g.checkType(global.Type, "global "+global.Name)
+			sb.WriteString(fmt.Sprintf("%s:\n\t.quad %s\n", globalName(global), g.initialValue(global)))
+		}
+	}
+
+	data := g.runtimeData()
+	if len(g.stringOrder) > 0 || data != "" {
+		sb.WriteString("\n\t.section .rodata\n")
+		for _, s := range g.stringOrder {
+			sb.WriteString(fmt.Sprintf("%s:\n\t.string %s\n", g.strings[s], quote(s)))
+		}
+		sb.WriteString(data)
+	}
+
+	// The stack need not be executable; without this note the linker warns
+	sb.WriteString("\n\t.section .note.GNU-stack,\"\",@progbits\n")
+
+	return sb.String(), g.errors
+}
+
+// unsupported records a construct the backend can't translate yet.
+func (g *generator) unsupported(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	if g.fn != nil {
+		msg = fmt.Sprintf("in function %s: %s", g.fn.Name, msg)
+	}
+	g.errors = append(g.errors, fmt.Errorf("%s not yet supported in amd64 backend", msg))
+}
+
+// checkType reports a value whose type has no representation yet. what
+// describes the value for the error message.
+func (g *generator) checkType(t types.Type, what string) {
+	switch t.(type) {
+	case *types.IntType, *types.BoolType, *types.StringType, *types.VoidType:
+	default:
+		g.unsupported("%s of type %s is", what, t)
+	}
+}
+
+// emit writes one instruction.
+func (g *generator) emit(format string, args ...interface{}) {
+	g.text.WriteString("\t")
+	g.text.WriteString(fmt.Sprintf(format, args...))
+	g.text.WriteString("\n")
+}
+
+// label writes a label definition.
+func (g *generator) label(name string) {
+	g.text.WriteString(name + ":\n")
+}
+
+// newLabel returns a fresh internal label.
+func (g *generator) newLabel() string {
+	g.labels++
+	return fmt.Sprintf(".Lrt%d", g.labels)
+}
+
+// Names
+
+// mangle turns an IR name into an assembler symbol. Qualified names
+// ("shapes/circle.Area") have their separators replaced.
+func mangle(name string) string {
+	return strings.NewReplacer(".", "__", "/", "__").Replace(name)
+}
+
+func functionName(name string) string {
+	return "fn_" + mangle(name)
+}
+
+func globalName(v *ir.Value) string {
+	return "g_" + mangle(v.Name)
+}
+
+// blockLabel names a block. IR labels repeat within a function, so the
+// block's position makes the assembler label unique.
+func (g *generator) blockLabel(block *ir.BasicBlock) string {
+	return fmt.Sprintf(".L%s_%d_%s", mangle(g.fn.Name), g.blockIndex[block], mangle(block.Label))
+}
+
+// Functions
+
+// function translates one function.
+//
+// FRAME LAYOUT:
+//
+//	16(%rbp)...   stack arguments 7, 8, ... (pushed by the caller)
+//	 8(%rbp)      return address
+//	 0(%rbp)      caller's %rbp
+//	-8(%rbp)...   one slot per value: register parameters first, then
+//	              every value an instruction defines
+func (g *generator) function(fn *ir.Function) {
+	g.fn = fn
+	g.slots = make(map[*ir.Value]string)
+	g.blockIndex = make(map[*ir.BasicBlock]int)
+	for i, block := range fn.Blocks {
+		g.blockIndex[block] = i
+	}
+	defer func() { g.fn = nil }()
+
+	g.checkType(fn.ReturnType, "result")
+	offset := 0
+	newSlot := func(v *ir.Value) {
+		offset -= 8
+		g.slots[v] = fmt.Sprintf("%d(%%rbp)", offset)
+	}
+	for i, param := range fn.Parameters {
+		g.checkType(param.Type, "parameter "+param.Name)
+		if i < len(argRegisters) {
+			newSlot(param)
+		} else {
+			g.slots[param] = fmt.Sprintf("%d(%%rbp)", 16+8*(i-len(argRegisters)))
+		}
+	}
+	for _, block := range fn.Blocks {
+		for _, instr := range block.Instructions {
+			dest := instr.Result()
+			if dest == nil || g.globals[dest] || g.slots[dest] != "" {
+				continue
+			}
+			g.checkType(dest.Type, "value "+dest.String())
+			newSlot(dest)
+		}
+	}
+	frame := -offset
+	if frame%16 != 0 {
+		frame += 8
+	}
+
+	name := functionName(fn.Name)
+	g.text.WriteString(fmt.Sprintf("\n\t.type %s, @function\n", name))
+	g.label(name)
+	g.emit("pushq %%rbp")
+	g.emit("movq %%rsp, %%rbp")
+	if frame > 0 {
+		g.emit("subq $%d, %%rsp", frame)
+	}
+	for i, param := range fn.Parameters {
+		if i < len(argRegisters) {
+			g.emit("movq %s, %s", argRegisters[i], g.slots[param])
+		}
+	}
+
+	for _, block := range fn.Blocks {
+		g.label(g.blockLabel(block))
+		for _, instr := range block.Instructions {
+			g.instruction(instr)
+		}
+	}
+	g.text.WriteString(fmt.Sprintf("\t.size %s, .-%s\n", name, name))
+}
+
+// mainWrapper defines the process entry point called by the C runtime. It
+// is omitted for a module without main (a library package).
+func (g *generator) mainWrapper() {
+	for _, fn := range g.module.Functions {
+		if fn.Name != "main" {
+			continue
+		}
+		if len(fn.Parameters) > 0 {
+			g.unsupported("main with parameters is")
+		}
+		g.text.WriteString("\n\t.globl main\n\t.type main, @function\n")
+		g.label("main")
+		g.emit("pushq %%rbp")
+		g.emit("movq %%rsp, %%rbp")
+		g.emit("call fn_main")
+		if !types.IsIntegerType(fn.ReturnType) {
+			g.emit("xorl %%eax, %%eax")
+		}
+		g.emit("popq %%rbp")
+		g.emit("ret")
+		g.text.WriteString("\t.size main, .-main\n")
+		return
+	}
+}
+
+// Operands
+
+// load puts a value in a register.
+func (g *generator) load(v *ir.Value, reg string) {
+	switch {
+	case v.IsConstant():
+		switch c := v.Constant.(type) {
+		case int64:
+			if c >= math.MinInt32 && c <= math.MaxInt32 {
+				g.emit("movq $%d, %s", c, reg)
+			} else {
+				g.emit("movabsq $%d, %s", c, reg)
+			}
+		case bool:
+			if c {
+				g.emit("movq $1, %s", reg)
+			} else {
+				g.emit("movq $0, %s", reg)
+			}
+		case string:
+			g.emit("leaq %s(%%rip), %s", g.stringLabel(c), reg)
+		default:
+			g.unsupported("constant %v of type %s is", c, v.Type)
+		}
+	case g.globals[v]:
+		g.emit("movq %s(%%rip), %s", globalName(v), reg)
+	case g.slots[v] != "":
+		g.emit("movq %s, %s", g.slots[v], reg)
+	case v.ID == -1 && v.Kind == ir.ValueVariable:
+		g.unsupported("function value %s is", v.Name)
+	default:
+		// Read before any assignment; the zero value, as in the interpreter
+		g.emit("movq $0, %s", reg)
+	}
+}
+
+// initialValue returns the .quad operand a global starts with: its
+// initializer as load would put it in a register, or 0.
+func (g *generator) initialValue(global *ir.Value) string {
+	if global.Initializer == nil {
+		return "0"
+	}
+	switch c := global.Initializer.Constant.(type) {
+	case int64:
+		return fmt.Sprint(c)
+	case bool:
+		if c {
+			return "1"
+		}
+		return "0"
+	case string:
+		return g.stringLabel(c)
+	default:
+		g.unsupported("constant %v of type %s is", c, global.Type)
+		return "0"
+	}
+}
+
+// store writes a register to a value's home.
+func (g *generator) store(reg string, v *ir.Value) {
+	if g.globals[v] {
+		g.emit("movq %s, %s(%%rip)", reg, globalName(v))
+	} else {
+		g.emit("movq %s, %s", reg, g.slots[v])
+	}
+}
+
+// stringLabel returns the .rodata label of a string constant.
+func (g *generator) stringLabel(s string) string {
+	if label, ok := g.strings[s]; ok {
+		return label
+	}
+	label := fmt.Sprintf(".Lstr%d", len(g.stringOrder))
+	g.strings[s] = label
+	g.stringOrder = append(g.stringOrder, s)
+	return label
+}
+
+// quote returns s as a .string operand. Bytes outside printable ASCII are
+// written as three-digit octal escapes.
+func quote(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch b := s[i]; {
+		case b == '"' || b == '\\':
+			sb.WriteByte('\\')
+			sb.WriteByte(b)
+		case b < 0x20 || b >= 0x7f:
+			sb.WriteString(fmt.Sprintf("\\%03o", b))
+		default:
+			sb.WriteByte(b)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+// Instructions
+
+// instruction emits the code for one instruction.
+func (g *generator) instruction(instr ir.Instruction) {
+	flags := g.flags
+	g.flags = comparison{}
+
+	switch i := instr.(type) {
+	case *ir.BinaryOp:
+		g.binary(i)
+
+	case *ir.UnaryOp:
+		g.load(i.Operand, "%rax")
+		switch {
+		case i.Op == ir.OpNeg && types.IsIntegerType(i.Operand.Type):
+			g.emit("negq %%rax")
+		case i.Op == ir.OpBitNot && types.IsIntegerType(i.Operand.Type):
+			g.emit("notq %%rax")
+		case i.Op == ir.OpNot && types.IsBooleanType(i.Operand.Type):
+			g.emit("xorq $1, %%rax")
+		default:
+			g.unsupported("operator %s on %s is", i.Op, i.Operand.Type)
+		}
+		g.store("%rax", i.Dest)
+
+	case *ir.Copy:
+		g.load(i.Value, "%rax")
+		g.store("%rax", i.Dest)
+
+	case *ir.Call:
+		g.call(i)
+
+	case *ir.Jump:
+		g.emit("jmp %s", g.blockLabel(i.Target))
+
+	case *ir.Branch:
+		// A branch on the comparison just made jumps on the flags it left,
+		// as cmp + jle; any other condition is tested for being nonzero
+		if flags.value != nil && flags.value == i.Condition {
+			g.emit("j%s %s", flags.cond, g.blockLabel(i.TrueBlock))
+		} else {
+			g.load(i.Condition, "%rax")
+			g.emit("testq %%rax, %%rax")
+			g.emit("jnz %s", g.blockLabel(i.TrueBlock))
+		}
+		g.emit("jmp %s", g.blockLabel(i.FalseBlock))
+
+	case *ir.Return:
+		if i.Value != nil {
+			g.load(i.Value, "%rax")
+		}
+		g.emit("leave")
+		g.emit("ret")
+
+	case *ir.Phi:
+		g.unsupported("phi node %s is", i.Dest)
+	case *ir.Alloca, *ir.Load, *ir.Store, *ir.GetElementPtr, *ir.GetFieldPtr:
+		g.unsupported("memory access (%s) is", instr)
+	default:
+		g.unsupported("instruction %s is", instr)
+	}
+}
+
+// arithmetic are the operators computed by a single instruction on
+// %rax and %rcx.
+var arithmetic = map[ir.BinaryOperator]string{
+	ir.OpAdd: "addq", ir.OpSub: "subq", ir.OpMul: "imulq",
+	ir.OpBitAnd: "andq", ir.OpBitOr: "orq", ir.OpBitXor: "xorq",
+}
+
+// conditions are the setcc suffixes of the signed comparisons, and
+// unsignedConditions those of the unsigned ones, for uint64.
+var conditions = map[ir.BinaryOperator]string{
+	ir.OpEq: "e", ir.OpNeq: "ne", ir.OpLt: "l", ir.OpLe: "le", ir.OpGt: "g", ir.OpGe: "ge",
+}
+
+var unsignedConditions = map[ir.BinaryOperator]string{
+	ir.OpEq: "e", ir.OpNeq: "ne", ir.OpLt: "b", ir.OpLe: "be", ir.OpGt: "a", ir.OpGe: "ae",
+}
+
+// binary emits a binary operation: the left operand in %rax, the right in
+// %rcx, the result from %rax.
+func (g *generator) binary(b *ir.BinaryOp) {
+	switch b.Left.Type.(type) {
+	case *types.IntType:
+	case *types.BoolType:
+		switch b.Op {
+		case ir.OpAnd, ir.OpOr, ir.OpEq, ir.OpNeq:
+		default:
+			g.unsupported("operator %s on bool is", b.Op)
+			return
+		}
+	default:
+		g.unsupported("operator %s on %s is", b.Op, b.Left.Type)
+		return
+	}
+
+	unsigned := types.IsUnsigned64(b.Left.Type)
+	g.load(b.Left, "%rax")
+	g.load(b.Right, "%rcx")
+	switch b.Op {
+	case ir.OpAnd:
+		g.emit("andq %%rcx, %%rax")
+	case ir.OpOr:
+		g.emit("orq %%rcx, %%rax")
+	case ir.OpDiv, ir.OpMod:
+		g.divide(b.Op == ir.OpMod, unsigned)
+	case ir.OpShl, ir.OpShr:
+		g.shift(b.Op == ir.OpShl, unsigned)
+	default:
+		if op, ok := arithmetic[b.Op]; ok {
+			g.emit("%s %%rcx, %%rax", op)
+			break
+		}
+		table := conditions
+		if unsigned {
+			table = unsignedConditions
+		}
+		cond, ok := table[b.Op]
+		if !ok {
+			g.unsupported("operator %s on %s is", b.Op, b.Left.Type)
+			return
+		}
+		g.emit("cmpq %%rcx, %%rax")
+		g.emit("set%s %%al", cond)
+		g.emit("movzbq %%al, %%rax")
+		// Neither the two instructions above nor the store below change
+		// the flags, so a branch right after can still use them
+		g.store("%rax", b.Dest)
+		g.flags = comparison{b.Dest, cond}
+		return
+	}
+	g.store("%rax", b.Dest)
+}
+
+// divide emits %rax / %rcx (or %rax % %rcx) into %rax, failing on a zero
+// divisor and handling -1 without idiv, which traps on MinInt / -1. An
+// unsigned division uses div, which can't overflow.
+func (g *generator) divide(remainder, unsigned bool) {
+	g.runtime["divzero"] = true
+	nonzero, general, done := g.newLabel(), g.newLabel(), g.newLabel()
+	g.emit("testq %%rcx, %%rcx")
+	g.emit("jne %s", nonzero)
+	g.emit("leaq .Lrt_divzero(%%rip), %%rsi")
+	g.emit("call rt_fail")
+	g.label(nonzero)
+	if unsigned {
+		g.emit("xorl %%edx, %%edx")
+		g.emit("divq %%rcx")
+		if remainder {
+			g.emit("movq %%rdx, %%rax")
+		}
+		return
+	}
+	g.emit("cmpq $-1, %%rcx")
+	g.emit("jne %s", general)
+	if remainder {
+		g.emit("xorl %%eax, %%eax")
+	} else {
+		g.emit("negq %%rax")
+	}
+	g.emit("jmp %s", done)
+	g.label(general)
+	g.emit("cqto")
+	g.emit("idivq %%rcx")
+	if remainder {
+		g.emit("movq %%rdx, %%rax")
+	}
+	g.label(done)
+}
+
+// shift emits %rax << %rcx (or >>) into %rax, failing on a negative amount.
+// sal and sar only look at the low six bits of the count, so amounts of 64
+// or more are handled separately: everything is shifted out. An unsigned
+// shift right (of a uint64) shifts zeros in, with shr.
+func (g *generator) shift(left, unsigned bool) {
+	g.runtime["negshift"] = true
+	nonnegative, small, done := g.newLabel(), g.newLabel(), g.newLabel()
+	g.emit("testq %%rcx, %%rcx")
+	g.emit("jns %s", nonnegative)
+	g.emit("movq %%rcx, %%rdx")
+	g.emit("leaq .Lrt_negshift(%%rip), %%rsi")
+	g.emit("call rt_fail")
+	g.label(nonnegative)
+	g.emit("cmpq $64, %%rcx")
+	g.emit("jl %s", small)
+	if left || unsigned {
+		g.emit("xorl %%eax, %%eax")
+	} else {
+		g.emit("sarq $63, %%rax")
+	}
+	g.emit("jmp %s", done)
+	g.label(small)
+	switch {
+	case left:
+		g.emit("salq %%cl, %%rax")
+	case unsigned:
+		g.emit("shrq %%cl, %%rax")
+	default:
+		g.emit("sarq %%cl, %%rax")
+	}
+	g.label(done)
+}
+
+// call emits a call to a function of the module or a builtin.
+func (g *generator) call(call *ir.Call) {
+	if name := call.Builtin(); name != "" {
+		g.builtin(name, call.Args)
+		return
+	}
+
+	// Stack arguments, right to left, padded so %rsp stays aligned
+	stackArgs := 0
+	if len(call.Args) > len(argRegisters) {
+		stackArgs = len(call.Args) - len(argRegisters)
+	}
+	pad := 8 * (stackArgs % 2)
+	if pad > 0 {
+		g.emit("subq $%d, %%rsp", pad)
+	}
+	for i := len(call.Args) - 1; i >= len(argRegisters); i-- {
+		g.load(call.Args[i], "%rax")
+		g.emit("pushq %%rax")
+	}
+	for i, arg := range call.Args {
+		if i < len(argRegisters) {
+			g.load(arg, argRegisters[i])
+		}
+	}
+
+	g.emit("call %s", functionName(call.Function.Name))
+	if cleanup := 8*stackArgs + pad; cleanup > 0 {
+		g.emit("addq $%d, %%rsp", cleanup)
+	}
+	if call.Dest != nil {
+		g.store("%rax", call.Dest)
+	}
+}
+
+// builtin emits print or println through printf.
+func (g *generator) builtin(name string, args []*ir.Value) {
+	if name != "print" && name != "println" {
+		g.unsupported("builtin %s is", name)
+		return
+	}
+	for _, arg := range args {
+		switch arg.Type.(type) {
+		case *types.IntType:
+			format := "fmt_int"
+			if types.IsUnsigned64(arg.Type) {
+				format = "fmt_uint"
+			}
+			g.runtime[format] = true
+			g.load(arg, "%rsi")
+			g.emit("leaq .Lrt_%s(%%rip), %%rdi", format)
+		case *types.StringType:
+			g.runtime["fmt_str"] = true
+			g.load(arg, "%rsi")
+			g.emit("leaq .Lrt_fmt_str(%%rip), %%rdi")
+		case *types.BoolType:
+			g.runtime["fmt_str"] = true
+			g.runtime["bools"] = true
+			g.load(arg, "%rax")
+			g.emit("leaq .Lrt_true(%%rip), %%rsi")
+			g.emit("leaq .Lrt_false(%%rip), %%rcx")
+			g.emit("testq %%rax, %%rax")
+			g.emit("cmovzq %%rcx, %%rsi")
+			g.emit("leaq .Lrt_fmt_str(%%rip), %%rdi")
+		default:
+			g.unsupported("%s of a %s is", name, arg.Type)
+			continue
+		}
+		g.emit("xorl %%eax, %%eax") // printf is variadic: no vector registers used
+		g.emit("call printf@PLT")
+	}
+	if name == "println" {
+		g.emit("movl $10, %%edi")
+		g.emit("call putchar@PLT")
+	}
+}
+
+// Runtime
+
+// runtimeFunctions emits the shared failure routine if anything can fail.
+func (g *generator) runtimeFunctions() {
+	if !g.runtime["divzero"] && !g.runtime["negshift"] {
+		return
+	}
+	// rt_fail(_, format %rsi, argument %rdx) flushes stdout, prints the
+	// message to stderr and exits with status 2. It never returns.
+	g.text.WriteString("\n\t.type rt_fail, @function\n")
+	g.label("rt_fail")
+	g.emit("pushq %%rbp")
+	g.emit("movq %%rsp, %%rbp")
+	g.emit("pushq %%rsi")
+	g.emit("pushq %%rdx")
+	g.emit("xorl %%edi, %%edi")
+	g.emit("call fflush@PLT")
+	g.emit("popq %%rdx")
+	g.emit("popq %%rsi")
+	g.emit("movq stderr@GOTPCREL(%%rip), %%rdi")
+	g.emit("movq (%%rdi), %%rdi")
+	g.emit("xorl %%eax, %%eax")
+	g.emit("call fprintf@PLT")
+	g.emit("movl $2, %%edi")
+	g.emit("call exit@PLT")
+	g.text.WriteString("\t.size rt_fail, .-rt_fail\n")
+}
+
+// runtimeData returns the runtime's read-only strings that the code uses.
+func (g *generator) runtimeData() string {
+	var sb strings.Builder
+	data := []struct{ use, label, text string }{
+		{"divzero", ".Lrt_divzero", "runtime error: integer division by zero\n"},
+		{"negshift", ".Lrt_negshift", "runtime error: negative shift amount %ld\n"},
+		{"fmt_int", ".Lrt_fmt_int", "%ld"},
+		{"fmt_uint", ".Lrt_fmt_uint", "%lu"},
+		{"fmt_str", ".Lrt_fmt_str", "%s"},
+		{"bools", ".Lrt_true", "true"},
+		{"bools", ".Lrt_false", "false"},
+	}
+	for _, d := range data {
+		if g.runtime[d.use] {
+			sb.WriteString(fmt.Sprintf("%s:\n\t.string %s\n", d.label, quote(d.text)))
+		}
+	}
+	return sb.String()
+}
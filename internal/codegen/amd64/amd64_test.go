@@ -0,0 +1,240 @@
+package amd64
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/hassan/compiler/internal/interp"
+	"github.com/hassan/compiler/internal/ir"
+	"github.com/hassan/compiler/pkg/compiler"
+)
+
+// update rewrites golden files with the current output instead of comparing.
+var update = flag.Bool("update", false, "update golden files")
+
+// compileSource compiles a program with the default optimizations.
+func compileSource(t *testing.T, source []byte, filename string) *ir.Module {
+	t.Helper()
+	result, err := compiler.Compile(source, filename, compiler.Options{OptLevel: 1})
+	if err != nil {
+		t.Fatalf("compiling %s: %v", filename, err)
+	}
+	return result.Module
+}
+
+// generate translates a module, failing the test on any backend error.
+func generate(t *testing.T, module *ir.Module) string {
+	t.Helper()
+	text, errs := Generate(module)
+	for _, err := range errs {
+		t.Error(err)
+	}
+	return text
+}
+
+// TestGenerate_Golden compares the assembly generated for every testdata
+// program that has a .golden file.
+func TestGenerate_Golden(t *testing.T) {
+	goldens, err := filepath.Glob("testdata/*.golden")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(goldens) == 0 {
+		t.Fatal("no golden files found")
+	}
+
+	for _, golden := range goldens {
+		path := strings.TrimSuffix(golden, ".golden") + ".src"
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			source, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := generate(t, compileSource(t, source, path))
+
+			if *update {
+				if err := os.WriteFile(golden, []byte(got), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != string(want) {
+				t.Errorf("output differs from %s (run with -update to accept):\ngot:\n%s\nwant:\n%s", golden, got, want)
+			}
+		})
+	}
+}
+
+// TestGenerate_Run assembles and links every testdata program with the
+// system C compiler and checks that the executable prints what the
+// interpreter prints and exits with main's result. Skipped off x86-64 Linux
+// and where there is no cc.
+func TestGenerate_Run(t *testing.T) {
+	if runtime.GOOS != "linux" || runtime.GOARCH != "amd64" {
+		t.Skipf("cannot run x86-64 Linux executables on %s/%s", runtime.GOOS, runtime.GOARCH)
+	}
+	cc, err := exec.LookPath("cc")
+	if err != nil {
+		t.Skip("no C compiler found")
+	}
+	sources, err := filepath.Glob("testdata/*.src")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range sources {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			source, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			module := compileSource(t, source, path)
+			dir := t.TempDir()
+			asm := filepath.Join(dir, "main.s")
+			exe := filepath.Join(dir, "main")
+			if err := os.WriteFile(asm, []byte(generate(t, module)), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if output, err := exec.Command(cc, "-o", exe, asm).CombinedOutput(); err != nil {
+				t.Fatalf("cc failed: %v\n%s", err, output)
+			}
+
+			// What the interpreter says the program does
+			var wantStdout bytes.Buffer
+			machine := interp.New(module)
+			machine.Stdout = &wantStdout
+			result, runErr := machine.Run("main", nil)
+			wantCode := 0
+			if code, ok := result.(int64); ok {
+				wantCode = int(uint8(code)) // Exit statuses are truncated to a byte
+			}
+			if runErr != nil {
+				wantCode = 2
+			}
+
+			var stdout, stderr bytes.Buffer
+			run := exec.Command(exe)
+			run.Stdout = &stdout
+			run.Stderr = &stderr
+			code := 0
+			if err := run.Run(); err != nil {
+				var exitErr *exec.ExitError
+				if !errors.As(err, &exitErr) {
+					t.Fatal(err)
+				}
+				code = exitErr.ExitCode()
+			}
+
+			if stdout.String() != wantStdout.String() {
+				t.Errorf("stdout = %q, interpreter printed %q", stdout.String(), wantStdout.String())
+			}
+			if code != wantCode {
+				t.Errorf("exit code = %d, want %d (stderr %q)", code, wantCode, stderr.String())
+			}
+			if runErr != nil && !strings.HasPrefix(stderr.String(), "runtime error: ") {
+				t.Errorf("stderr = %q, want a runtime error (interpreter: %v)", stderr.String(), runErr)
+			}
+		})
+	}
+}
+
+// TestGenerate_Branches checks how each kind of condition is branched on:
+// a comparison made just before the branch by a jump on its flags, and
+// anything else by testing it for nonzero.
+func TestGenerate_Branches(t *testing.T) {
+	tests := []struct {
+		name      string
+		source    string
+		want      string // the jump to the true block
+		wantTestq bool   // whether the condition is tested
+	}{
+		{
+			name:   "signed comparison",
+			source: "package main\n\nfunc f(a int, b int) int {\n    if (a < b) {\n        return 1;\n    }\n    return 0;\n}\n",
+			want:   "jl .Lf_1_if__then",
+		},
+		{
+			name:   "unsigned comparison",
+			source: "package main\n\nfunc f(a uint64, b uint64) int {\n    if (a >= b) {\n        return 1;\n    }\n    return 0;\n}\n",
+			want:   "jae .Lf_1_if__then",
+		},
+		{
+			name:      "bool parameter",
+			source:    "package main\n\nfunc f(c bool) int {\n    if (c) {\n        return 1;\n    }\n    return 0;\n}\n",
+			want:      "jnz .Lf_1_if__then",
+			wantTestq: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text := generate(t, compileSource(t, []byte(tt.source), "input.src"))
+			if !strings.Contains(text, "\t"+tt.want+"\n") {
+				t.Errorf("expected %q in:\n%s", tt.want, text)
+			}
+			if got := strings.Contains(text, "testq"); got != tt.wantTestq {
+				t.Errorf("expected testq %v, got %v in:\n%s", tt.wantTestq, got, text)
+			}
+		})
+	}
+}
+
+func TestGenerate_Unsupported(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{
+			name:   "float parameter",
+			source: "package main\n\nfunc half(x float) float {\n    return x;\n}\n",
+			want:   "in function half: parameter x of type float is not yet supported in amd64 backend",
+		},
+		{
+			name:   "string comparison",
+			source: "package main\n\nfunc less(a string, b string) bool {\n    return a < b;\n}\n",
+			want:   "in function less: operator < on string is not yet supported in amd64 backend",
+		},
+		{
+			name:   "print a char",
+			source: "package main\n\nfunc main() {\n    print('x');\n}\n",
+			want:   "in function main: print of a char is not yet supported in amd64 backend",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, errs := Generate(compileSource(t, []byte(tt.source), "input.src"))
+			for _, err := range errs {
+				if err.Error() == tt.want {
+					return
+				}
+			}
+			t.Errorf("expected error %q, got %v", tt.want, errs)
+		})
+	}
+}
+
+func TestQuote(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"hi", `"hi"`},
+		{"say \"hi\"\n", `"say \"hi\"\012"`},
+		{`C:\dir`, `"C:\\dir"`},
+		{"é", `"\303\251"`},
+	}
+	for _, tt := range tests {
+		if got := quote(tt.in); got != tt.want {
+			t.Errorf("quote(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
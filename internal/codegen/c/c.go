@@ -0,0 +1,921 @@
+// Package c translates an IR module into a C source file.
+//
+// WHY C:
+// A C compiler exists for practically every machine, so emitting C is the
+// shortest way from IR to a native executable: cc does the register
+// allocation, instruction selection and linking this compiler doesn't.
+//
+// DESIGN CHOICE: Rebuild structured control flow rather than translate the
+// CFG literally. Blocks with labels and gotos between them would be correct
+// C, and compile to the same code, but a reader would have to trace every
+// goto to see the loop it forms. Rebuilt as ifs and while loops (see
+// structure.go), the C reads like the program it came from; a goto remains
+// only for a jump no if, break or continue can express.
+//
+// TRANSLATION:
+//   - Each IR function becomes a static C function named fn_<name>; the
+//     module's main is called from a C main wrapper, whose exit status is
+//     main's result when main returns an int
+//   - Every value an instruction defines becomes a C local declared at the
+//     top of the function, zero-initialized; globals become static variables
+//   - Types map as int -> int64_t, float -> double, bool -> bool,
+//     string -> const char *, char -> int32_t (a code point); structs and
+//     fixed-size arrays become C structs, so they are copied by assignment
+//     like the language's values
+//   - Phi nodes are resolved by copies in the predecessor, just before it
+//     jumps (leaving SSA form)
+//
+// Names are prefixed (fn_, g_, st_) so no program name can collide with a
+// C keyword or a libc function, and locals carry their IR ID ("x_3") so
+// shadowed variables stay distinct.
+package c
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/hassan/compiler/internal/codegen/cfg"
+	"github.com/hassan/compiler/internal/ir"
+	"github.com/hassan/compiler/internal/semantic/types"
+)
+
+// generator holds the state of one translation.
+type generator struct {
+	module *ir.Module
+	out    strings.Builder
+
+	// errors accumulates constructs the backend can't translate. Generation
+	// carries on past them so one run reports all of them.
+	errors []error
+
+	// globals is the set of module-level values
+	globals map[*ir.Value]bool
+
+	// aggregates lists the C struct definitions needed, dependencies
+	// first; aggregateSeen dedupes them by C type name
+	aggregates    []string
+	aggregateSeen map[string]bool
+
+	// Per-function state
+
+	fn *ir.Function
+
+	// addresses maps each value holding an address (the result of an
+	// Alloca, GetElementPtr or GetFieldPtr) to the type it points to
+	addresses map[*ir.Value]types.Type
+
+	// phis lists the phi nodes at the top of each block
+	phis map[*ir.BasicBlock][]*ir.Phi
+
+	// blockIndex is each block's position in the function
+	blockIndex map[*ir.BasicBlock]int
+
+	// Structured emission state; see structure.go
+
+	cfg    *cfg.Graph
+	lines  []string
+	indent int
+	loops  []loop
+
+	// placed are the blocks written after the construct that reaches them,
+	// exits the loop exits of each loop header, latest first, and exit the
+	// set of all of those
+	placed map[*ir.BasicBlock]bool
+	exits  map[*ir.BasicBlock][]*ir.BasicBlock
+	exit   map[*ir.BasicBlock]bool
+
+	// jumped are the labels some goto names; uses counts the reads of each
+	// value; inlined are the conditions written in an if or while instead
+	// of a temporary
+	jumped  map[string]bool
+	uses    map[*ir.Value]int
+	inlined map[*ir.Value]bool
+}
+
+// Generate translates module into a complete C translation unit.
+//
+// The returned errors name each construct that could not be translated; the
+// source is only usable when there are none.
+func Generate(module *ir.Module) (string, []error) {
+	g := &generator{
+		module:        module,
+		globals:       make(map[*ir.Value]bool),
+		aggregateSeen: make(map[string]bool),
+	}
+
+	// Function bodies are generated first, into their own buffer, because
+	// they discover the aggregate types that must be defined above them
+	var body strings.Builder
+	for _, global := range module.Globals {
+		g.globals[global] = true
+	}
+	for _, fn := range module.Functions {
+		body.WriteString(g.function(fn))
+	}
+
+	g.out.WriteString(fmt.Sprintf("/* Generated from module %s. */\n\n", module.Name))
+	g.out.WriteString(runtime)
+
+	if len(g.aggregates) > 0 {
+		g.out.WriteString("\n")
+		for _, def := range g.aggregates {
+			g.out.WriteString(def)
+		}
+	}
+
+	if len(module.Globals) > 0 {
+		g.out.WriteString("\n")
+		for _, global := range module.Globals {
+			initial := g.zero(global.Type)
+			if global.Initializer != nil {
+				initial = g.literal(global.Initializer)
+			}
+			g.out.WriteString(fmt.Sprintf("static %s = %s;\n",
+				g.declare(global.Type, globalName(global)), initial))
+		}
+	}
+
+	if len(module.Functions) > 0 {
+		g.out.WriteString("\n")
+		for _, fn := range module.Functions {
+			g.out.WriteString(g.signature(fn) + ";\n")
+		}
+	}
+
+	g.out.WriteString(body.String())
+	g.out.WriteString(g.mainWrapper())
+
+	return g.out.String(), g.errors
+}
+
+// errorf records a construct that can't be translated, in the current
+// function if there is one.
+func (g *generator) errorf(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	if g.fn != nil {
+		msg = fmt.Sprintf("in function %s: %s", g.fn.Name, msg)
+	}
+	g.errors = append(g.errors, fmt.Errorf("C backend: %s", msg))
+}
+
+// mainWrapper returns the C entry point, or "" for a module without main
+// (a library package).
+func (g *generator) mainWrapper() string {
+	for _, fn := range g.module.Functions {
+		if fn.Name != "main" {
+			continue
+		}
+		if len(fn.Parameters) > 0 {
+			g.errorf("main must not take parameters")
+		}
+		if types.IsIntegerType(fn.ReturnType) {
+			return "\nint main(void) {\n    return (int)fn_main();\n}\n"
+		}
+		return "\nint main(void) {\n    fn_main();\n    return 0;\n}\n"
+	}
+	return ""
+}
+
+// Names
+
+// mangle turns an IR name into a C identifier. Qualified names
+// ("shapes/circle.Area") have their separators replaced.
+func mangle(name string) string {
+	var sb strings.Builder
+	for _, r := range name {
+		switch {
+		case r == '.' || r == '/':
+			sb.WriteString("__")
+		case r == '_' || r < 0x80 && (r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9'):
+			sb.WriteRune(r)
+		default:
+			sb.WriteString(fmt.Sprintf("_u%04x", r))
+		}
+	}
+	return sb.String()
+}
+
+func functionName(name string) string {
+	return "fn_" + mangle(name)
+}
+
+func globalName(v *ir.Value) string {
+	return "g_" + mangle(v.Name)
+}
+
+// localName names a function-local value: temporaries by number, variables
+// and parameters by name and number.
+func localName(v *ir.Value) string {
+	switch {
+	case v.Kind == ir.ValueTemporary:
+		return fmt.Sprintf("t%d", v.ID)
+	case v.Name != "":
+		return fmt.Sprintf("%s_%d", mangle(v.Name), v.ID)
+	default:
+		return fmt.Sprintf("v%d", v.ID)
+	}
+}
+
+// labelName names a block. IR labels repeat within a function (every if
+// has an "if.then"), so the block's position makes the C label unique.
+func (g *generator) labelName(block *ir.BasicBlock) string {
+	return fmt.Sprintf("bb%d_%s", g.blockIndex[block], mangle(block.Label))
+}
+
+// cKeywords are reserved in C but usable as field names in the language.
+var cKeywords = map[string]bool{
+	"auto": true, "break": true, "case": true, "char": true, "const": true,
+	"continue": true, "default": true, "do": true, "double": true, "else": true,
+	"enum": true, "extern": true, "float": true, "for": true, "goto": true,
+	"if": true, "inline": true, "int": true, "long": true, "register": true,
+	"restrict": true, "return": true, "short": true, "signed": true,
+	"sizeof": true, "static": true, "struct": true, "switch": true,
+	"typedef": true, "union": true, "unsigned": true, "void": true,
+	"volatile": true, "while": true, "bool": true, "true": true, "false": true,
+}
+
+// fieldName names a struct field. Fields need no prefix, since they live in
+// their struct's own namespace, unless they spell a C keyword.
+func fieldName(name string) string {
+	name = mangle(name)
+	if cKeywords[name] {
+		return name + "_"
+	}
+	return name
+}
+
+// Types
+
+// cType returns the C spelling of a type, defining any struct it needs. A
+// named type is spelled as the type it is declared from.
+func (g *generator) cType(t types.Type) string {
+	switch typ := types.Underlying(t).(type) {
+	case *types.IntType:
+		return "int64_t"
+	case *types.FloatType:
+		return "double"
+	case *types.BoolType:
+		return "bool"
+	case *types.StringType:
+		return "const char *"
+	case *types.CharType:
+		return "int32_t"
+	case *types.VoidType:
+		return "void"
+	case *types.PointerType:
+		return g.cType(typ.Elem) + " *"
+	case *types.StructType:
+		name := "struct st_" + mangle(typ.Name)
+		if !g.aggregateSeen[name] {
+			g.aggregateSeen[name] = true
+			var sb strings.Builder
+			sb.WriteString(name + " {\n")
+			for _, field := range typ.Fields {
+				sb.WriteString("    " + g.declare(field.Type, fieldName(field.Name)) + ";\n")
+			}
+			if len(typ.Fields) == 0 {
+				sb.WriteString("    char unused; /* C structs can't be empty */\n")
+			}
+			sb.WriteString("};\n")
+			g.aggregates = append(g.aggregates, sb.String())
+		}
+		return name
+	case *types.ArrayType:
+		if typ.Size < 0 {
+			g.errorf("slices are not supported")
+			return "void *"
+		}
+		elem := g.cType(typ.ElementType)
+		name := fmt.Sprintf("struct arr%d_%s", typ.Size, typeTag(typ.ElementType))
+		if !g.aggregateSeen[name] {
+			g.aggregateSeen[name] = true
+			size := typ.Size
+			if size == 0 {
+				size = 1 // C arrays can't be empty either; the length is still checked as 0
+			}
+			g.aggregates = append(g.aggregates, fmt.Sprintf("%s {\n    %s;\n};\n",
+				name, joinDecl(elem, fmt.Sprintf("elems[%d]", size))))
+		}
+		return name
+	default:
+		g.errorf("type %s is not supported", t)
+		return "int64_t"
+	}
+}
+
+// typeTag names a type inside the generated name of an array struct.
+func typeTag(t types.Type) string {
+	switch typ := t.(type) {
+	case *types.StructType:
+		return "st_" + mangle(typ.Name)
+	case *types.ArrayType:
+		return fmt.Sprintf("arr%d_%s", typ.Size, typeTag(typ.ElementType))
+	default:
+		return mangle(t.String())
+	}
+}
+
+// joinDecl puts a C type and a declarator together: "int64_t x", but
+// "const char *s".
+func joinDecl(cType, declarator string) string {
+	if strings.HasSuffix(cType, "*") {
+		return cType + declarator
+	}
+	return cType + " " + declarator
+}
+
+func (g *generator) declare(t types.Type, name string) string {
+	return joinDecl(g.cType(t), name)
+}
+
+// zero returns the C initializer for the zero value of t.
+func (g *generator) zero(t types.Type) string {
+	switch types.Underlying(t).(type) {
+	case *types.StringType:
+		return `""`
+	case *types.StructType, *types.ArrayType:
+		return "{0}"
+	case *types.BoolType:
+		return "false"
+	case *types.PointerType:
+		return "NULL"
+	default:
+		return "0"
+	}
+}
+
+// Functions
+
+func (g *generator) signature(fn *ir.Function) string {
+	params := make([]string, len(fn.Parameters))
+	for i, param := range fn.Parameters {
+		params[i] = g.declare(param.Type, localName(param))
+	}
+	list := strings.Join(params, ", ")
+	if list == "" {
+		list = "void"
+	}
+	return fmt.Sprintf("static %s(%s)", g.declare(fn.ReturnType, functionName(fn.Name)), list)
+}
+
+// function translates one function.
+func (g *generator) function(fn *ir.Function) string {
+	g.fn = fn
+	defer func() { g.fn = nil }()
+	g.analyze(fn)
+
+	// The body comes first: it decides which values are left undeclared
+	blocks := fn.Blocks
+	body, ok := g.structured(fn)
+	if ok {
+		blocks = g.cfg.Order
+	} else {
+		g.inlined = nil
+		body = g.gotos(fn)
+	}
+
+	var sb strings.Builder
+	sb.WriteString("\n" + g.signature(fn) + " {\n")
+
+	// Declarations: every value defined in the function, in order of first
+	// definition
+	params := make(map[*ir.Value]bool)
+	for _, param := range fn.Parameters {
+		params[param] = true
+	}
+	declared := make(map[*ir.Value]bool)
+	for _, block := range blocks {
+		for _, instr := range block.Instructions {
+			dest := instr.Result()
+			if dest == nil || declared[dest] || params[dest] || g.globals[dest] || g.inlined[dest] {
+				continue
+			}
+			declared[dest] = true
+			if pointee, ok := g.addresses[dest]; ok {
+				sb.WriteString("    " + joinDecl(g.cType(pointee)+" *", localName(dest)) + " = NULL;\n")
+			} else {
+				sb.WriteString(fmt.Sprintf("    %s = %s;\n", g.declare(dest.Type, localName(dest)), g.zero(dest.Type)))
+			}
+			if alloca, ok := instr.(*ir.Alloca); ok {
+				sb.WriteString(fmt.Sprintf("    %s = %s;\n",
+					g.declare(alloca.Type, localName(dest)+"_slot"), g.zero(alloca.Type)))
+			}
+		}
+	}
+
+	for _, line := range body {
+		sb.WriteString(line + "\n")
+	}
+	sb.WriteString("}\n")
+	return sb.String()
+}
+
+// gotos returns the body of the current function with a label for each
+// block and a goto for each jump, for a CFG with no structure to rebuild.
+func (g *generator) gotos(fn *ir.Function) []string {
+	// Only blocks that are jumped to need a label; an unused one is a
+	// compiler warning
+	targets := make(map[*ir.BasicBlock]bool)
+	for _, block := range fn.Blocks {
+		switch term := block.Terminator().(type) {
+		case *ir.Jump:
+			targets[term.Target] = true
+		case *ir.Branch:
+			targets[term.TrueBlock] = true
+			targets[term.FalseBlock] = true
+		}
+	}
+
+	var lines []string
+	for _, block := range fn.Blocks {
+		if targets[block] {
+			lines = append(lines, g.labelName(block)+":;")
+		}
+		for _, instr := range block.Instructions {
+			for _, line := range g.instruction(block, instr) {
+				lines = append(lines, "    "+line)
+			}
+		}
+	}
+	return lines
+}
+
+// analyze records which values are addresses, the phis of each block and
+// the position of each block.
+func (g *generator) analyze(fn *ir.Function) {
+	g.addresses = make(map[*ir.Value]types.Type)
+	g.phis = make(map[*ir.BasicBlock][]*ir.Phi)
+	g.blockIndex = make(map[*ir.BasicBlock]int)
+
+	for index, block := range fn.Blocks {
+		g.blockIndex[block] = index
+		for _, instr := range block.Instructions {
+			switch i := instr.(type) {
+			case *ir.Alloca:
+				g.addresses[i.Dest] = i.Type
+			case *ir.GetElementPtr:
+				if arr, ok := g.pointee(i.Base).(*types.ArrayType); ok {
+					g.addresses[i.Dest] = arr.ElementType
+				} else {
+					g.errorf("cannot index a value of type %s", g.pointee(i.Base))
+				}
+			case *ir.GetFieldPtr:
+				st, ok := g.pointee(i.Base).(*types.StructType)
+				if ok && i.FieldIndex >= 0 && i.FieldIndex < len(st.Fields) {
+					g.addresses[i.Dest] = st.Fields[i.FieldIndex].Type
+				} else {
+					g.errorf("no field %d in a value of type %s", i.FieldIndex, g.pointee(i.Base))
+				}
+			case *ir.Copy:
+				if pointee, ok := g.addresses[i.Value]; ok {
+					g.addresses[i.Dest] = pointee
+				}
+			case *ir.Phi:
+				g.phis[block] = append(g.phis[block], i)
+			}
+		}
+	}
+}
+
+// pointee returns the type an aggregate operand refers to: the type an
+// address or a pointer points to, or the value's own type.
+func (g *generator) pointee(v *ir.Value) types.Type {
+	if pointee, ok := g.addresses[v]; ok {
+		return pointee
+	}
+	if pointer, ok := types.Underlying(v.Type).(*types.PointerType); ok {
+		return types.Underlying(pointer.Elem)
+	}
+	return v.Type
+}
+
+// nilCheck returns the check that v, when it is a pointer of the program's
+// (a *T parameter, variable or field, rather than an address the builder
+// computed), isn't nil. A pointer field or element that was never assigned
+// is; the interpreter reports dereferencing it as a runtime error too.
+func (g *generator) nilCheck(v *ir.Value) []string {
+	if _, ok := types.Underlying(v.Type).(*types.PointerType); !ok {
+		return nil
+	}
+	return []string{fmt.Sprintf("if (%s == NULL) rt_fail(\"nil pointer dereference\");", g.operand(v))}
+}
+
+// Instructions
+
+// instruction returns the C statements for one instruction.
+func (g *generator) instruction(block *ir.BasicBlock, instr ir.Instruction) []string {
+	switch i := instr.(type) {
+	case *ir.BinaryOp:
+		return []string{fmt.Sprintf("%s = %s;", g.operand(i.Dest), g.binary(i))}
+
+	case *ir.UnaryOp:
+		return []string{fmt.Sprintf("%s = %s;", g.operand(i.Dest), g.unary(i))}
+
+	case *ir.Copy:
+		return []string{fmt.Sprintf("%s = %s;", g.operand(i.Dest), g.operand(i.Value))}
+
+	case *ir.Alloca:
+		return []string{fmt.Sprintf("%s = &%s_slot;", localName(i.Dest), localName(i.Dest))}
+
+	case *ir.Load:
+		return append(g.nilCheck(i.Address), fmt.Sprintf("%s = *%s;", g.operand(i.Dest), g.operand(i.Address)))
+
+	case *ir.Store:
+		return append(g.nilCheck(i.Address), fmt.Sprintf("*%s = %s;", g.operand(i.Address), g.operand(i.Value)))
+
+	case *ir.GetElementPtr:
+		arr, ok := g.pointee(i.Base).(*types.ArrayType)
+		if !ok {
+			return nil // Reported by analyze
+		}
+		index := g.operand(i.Index)
+		return append(g.nilCheck(i.Base),
+			fmt.Sprintf("rt_check_index(%s, %d);", index, arr.Size),
+			fmt.Sprintf("%s = &%s[%s];", localName(i.Dest), g.member(i.Base, "elems"), index),
+		)
+
+	case *ir.GetFieldPtr:
+		st, ok := g.pointee(i.Base).(*types.StructType)
+		if !ok || i.FieldIndex < 0 || i.FieldIndex >= len(st.Fields) {
+			return nil // Reported by analyze
+		}
+		return append(g.nilCheck(i.Base), fmt.Sprintf("%s = &%s;", localName(i.Dest),
+			g.member(i.Base, fieldName(st.Fields[i.FieldIndex].Name))))
+
+	case *ir.Call:
+		return g.call(i)
+
+	case *ir.Jump:
+		return append(g.phiCopies(block, i.Target), fmt.Sprintf("goto %s;", g.labelName(i.Target)))
+
+	case *ir.Branch:
+		cond := g.operand(i.Condition)
+		thenCopies := g.phiCopies(block, i.TrueBlock)
+		elseCopies := g.phiCopies(block, i.FalseBlock)
+		if len(thenCopies) == 0 && len(elseCopies) == 0 {
+			return []string{
+				fmt.Sprintf("if (%s) goto %s;", cond, g.labelName(i.TrueBlock)),
+				fmt.Sprintf("goto %s;", g.labelName(i.FalseBlock)),
+			}
+		}
+		lines := []string{fmt.Sprintf("if (%s) {", cond)}
+		for _, line := range append(thenCopies, fmt.Sprintf("goto %s;", g.labelName(i.TrueBlock))) {
+			lines = append(lines, "    "+line)
+		}
+		lines = append(lines, "}")
+		return append(append(lines, elseCopies...), fmt.Sprintf("goto %s;", g.labelName(i.FalseBlock)))
+
+	case *ir.Return:
+		if i.Value == nil {
+			return []string{"return;"}
+		}
+		return []string{fmt.Sprintf("return %s;", g.operand(i.Value))}
+
+	case *ir.Phi:
+		return nil // Resolved in the predecessors; see phiCopies
+
+	default:
+		g.errorf("instruction %s is not supported", instr)
+		return nil
+	}
+}
+
+// member accesses a member of an aggregate operand, through the pointer if
+// the operand is an address or a pointer.
+func (g *generator) member(base *ir.Value, member string) string {
+	_, isAddress := g.addresses[base]
+	_, isPointer := types.Underlying(base.Type).(*types.PointerType)
+	if isAddress || isPointer {
+		return g.operand(base) + "->" + member
+	}
+	return g.operand(base) + "." + member
+}
+
+// phiCopies returns the copies that leaving from for target performs: each
+// phi of target takes the value it lists for from.
+//
+// The phis of a block all read their incoming values before any of them is
+// assigned, so with more than one phi the values go through temporaries
+// first - otherwise a phi reading another phi's result would see the new
+// value instead of the old one.
+func (g *generator) phiCopies(from, target *ir.BasicBlock) []string {
+	phis := g.phis[target]
+	var incoming []*ir.Value
+	var dests []*ir.Phi
+	for _, phi := range phis {
+		for _, inc := range phi.Incomig {
+			if inc.Block == from {
+				incoming = append(incoming, inc.Value)
+				dests = append(dests, phi)
+				break
+			}
+		}
+	}
+	if len(dests) == 1 {
+		return []string{fmt.Sprintf("%s = %s;", g.operand(dests[0].Dest), g.operand(incoming[0]))}
+	}
+
+	var lines []string
+	for i, phi := range dests {
+		lines = append(lines, fmt.Sprintf("%s = %s;",
+			g.declare(phi.Dest.Type, fmt.Sprintf("phi_%s_%d", localName(phi.Dest), i)), g.operand(incoming[i])))
+	}
+	for i, phi := range dests {
+		lines = append(lines, fmt.Sprintf("%s = phi_%s_%d;", g.operand(phi.Dest), localName(phi.Dest), i))
+	}
+	if len(lines) > 0 {
+		// A block scope keeps the temporaries from clashing with the
+		// copies for another edge
+		for i := range lines {
+			lines[i] = "    " + lines[i]
+		}
+		lines = append(append([]string{"{"}, lines...), "}")
+	}
+	return lines
+}
+
+// call translates a call, to a function of the module or a builtin.
+func (g *generator) call(call *ir.Call) []string {
+	args := make([]string, len(call.Args))
+	for i, arg := range call.Args {
+		args[i] = g.operand(arg)
+	}
+
+	if name := call.Builtin(); name != "" {
+		switch name {
+		case "print", "println":
+			var lines []string
+			for i, arg := range call.Args {
+				lines = append(lines, fmt.Sprintf("rt_print_%s(%s);", printTag(arg.Type), args[i]))
+			}
+			if name == "println" {
+				lines = append(lines, "putchar('\\n');")
+			}
+			return lines
+		case "len":
+			// Only a string's length is computed; an array's is a constant
+			if call.Dest == nil || len(call.Args) != 1 || !types.Underlying(call.Args[0].Type).Equals(types.String) {
+				g.errorf("len of %s is not supported", call.Args[0].Type)
+				return nil
+			}
+			return []string{fmt.Sprintf("%s = (int64_t)strlen(%s);", g.operand(call.Dest), args[0])}
+		case "new":
+			// new(T) outlives the call that makes it, so it is never freed.
+			// calloc zeroes it as {0} zeroes a slot; a string needs its ""
+			if call.Dest == nil {
+				return nil
+			}
+			dest := g.operand(call.Dest)
+			lines := []string{
+				fmt.Sprintf("%s = calloc(1, sizeof *%s);", dest, dest),
+				fmt.Sprintf("if (%s == NULL) rt_fail(\"out of memory\");", dest),
+			}
+			if pointer, ok := types.Underlying(call.Dest.Type).(*types.PointerType); ok && types.Underlying(pointer.Elem).Equals(types.String) {
+				lines = append(lines, fmt.Sprintf("*%s = \"\";", dest))
+			}
+			return lines
+		case "checkIndex":
+			// The GetElementPtr that follows reports a base that isn't an array
+			arr, ok := g.pointee(call.Args[0]).(*types.ArrayType)
+			if !ok {
+				return nil
+			}
+			return []string{fmt.Sprintf("rt_check_index(%s, %d);", args[1], arr.Size)}
+		default:
+			g.errorf("builtin %s is not supported", name)
+			return nil
+		}
+	}
+
+	expr := fmt.Sprintf("%s(%s)", functionName(call.Function.Name), strings.Join(args, ", "))
+	if call.Dest == nil {
+		return []string{expr + ";"}
+	}
+	return []string{fmt.Sprintf("%s = %s;", g.operand(call.Dest), expr)}
+}
+
+// printTag selects the runtime print function for a value's type.
+func printTag(t types.Type) string {
+	if types.IsUnsigned64(t) {
+		return "uint"
+	}
+	switch t.(type) {
+	case *types.FloatType:
+		return "float"
+	case *types.BoolType:
+		return "bool"
+	case *types.StringType:
+		return "string"
+	case *types.CharType:
+		return "char"
+	default:
+		return "int"
+	}
+}
+
+// Operands
+
+// operand returns the C expression for an IR value.
+func (g *generator) operand(v *ir.Value) string {
+	switch {
+	case v.IsConstant():
+		return g.literal(v)
+	case g.globals[v]:
+		return globalName(v)
+	case v.ID == -1 && v.Kind == ir.ValueVariable:
+		// A function used as a value rather than called
+		g.errorf("function values are not supported (%s)", v.Name)
+		return functionName(v.Name)
+	default:
+		return localName(v)
+	}
+}
+
+// literal returns the C spelling of a constant.
+func (g *generator) literal(v *ir.Value) string {
+	switch c := v.Constant.(type) {
+	case int64:
+		switch {
+		case c == math.MinInt64:
+			return "INT64_MIN"
+		case c >= math.MinInt32 && c <= math.MaxInt32:
+			return strconv.FormatInt(c, 10)
+		default:
+			return fmt.Sprintf("INT64_C(%d)", c)
+		}
+	case float64:
+		switch {
+		case math.IsNaN(c):
+			return "NAN"
+		case math.IsInf(c, 1):
+			return "INFINITY"
+		case math.IsInf(c, -1):
+			return "-INFINITY"
+		}
+		s := strconv.FormatFloat(c, 'g', -1, 64)
+		if !strings.ContainsAny(s, ".e") {
+			s += ".0"
+		}
+		return s
+	case bool:
+		if c {
+			return "true"
+		}
+		return "false"
+	case string:
+		return quote(c)
+	case rune:
+		return strconv.Itoa(int(c))
+	case nil:
+		return "NULL"
+	default:
+		g.errorf("constant %v of type %T is not supported", c, c)
+		return "0"
+	}
+}
+
+// quote returns a C string literal for s. Bytes outside printable ASCII are
+// written as three-digit octal escapes, which unlike \x escapes can't
+// swallow a following digit; '?' is escaped so no trigraph can form.
+func quote(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch b := s[i]; b {
+		case '"', '\\', '?':
+			sb.WriteByte('\\')
+			sb.WriteByte(b)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\t':
+			sb.WriteString(`\t`)
+		default:
+			if b < 0x20 || b >= 0x7f {
+				sb.WriteString(fmt.Sprintf("\\%03o", b))
+			} else {
+				sb.WriteByte(b)
+			}
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+// cOperators spells the operators that C writes the same way.
+var cOperators = map[ir.BinaryOperator]string{
+	ir.OpAdd: "+", ir.OpSub: "-", ir.OpMul: "*", ir.OpDiv: "/",
+	ir.OpEq: "==", ir.OpNeq: "!=", ir.OpLt: "<", ir.OpLe: "<=", ir.OpGt: ">", ir.OpGe: ">=",
+	ir.OpAnd: "&&", ir.OpOr: "||",
+	ir.OpBitAnd: "&", ir.OpBitOr: "|", ir.OpBitXor: "^",
+}
+
+func isComparison(op ir.BinaryOperator) bool {
+	switch op {
+	case ir.OpEq, ir.OpNeq, ir.OpLt, ir.OpLe, ir.OpGt, ir.OpGe:
+		return true
+	default:
+		return false
+	}
+}
+
+// binary returns the C expression for a binary operation. The operand type
+// picks the translation, as it picks the semantics in the interpreter.
+func (g *generator) binary(b *ir.BinaryOp) string {
+	left, right := g.operand(b.Left), g.operand(b.Right)
+	op := cOperators[b.Op]
+
+	switch b.Left.Type.(type) {
+	case *types.IntType:
+		if types.IsUnsigned64(b.Left.Type) {
+			switch b.Op {
+			case ir.OpDiv:
+				return fmt.Sprintf("rt_udiv(%s, %s)", left, right)
+			case ir.OpMod:
+				return fmt.Sprintf("rt_umod(%s, %s)", left, right)
+			case ir.OpShr:
+				return fmt.Sprintf("rt_ushr(%s, %s)", left, right)
+			case ir.OpLt, ir.OpLe, ir.OpGt, ir.OpGe:
+				return fmt.Sprintf("(uint64_t)%s %s (uint64_t)%s", left, op, right)
+			}
+		}
+		switch b.Op {
+		case ir.OpAdd, ir.OpSub, ir.OpMul:
+			// Unsigned arithmetic wraps; signed overflow is undefined in C
+			return fmt.Sprintf("(int64_t)((uint64_t)%s %s (uint64_t)%s)", left, op, right)
+		case ir.OpDiv:
+			return fmt.Sprintf("rt_div(%s, %s)", left, right)
+		case ir.OpMod:
+			return fmt.Sprintf("rt_mod(%s, %s)", left, right)
+		case ir.OpShl:
+			return fmt.Sprintf("rt_shl(%s, %s)", left, right)
+		case ir.OpShr:
+			return fmt.Sprintf("rt_shr(%s, %s)", left, right)
+		case ir.OpBitAnd, ir.OpBitOr, ir.OpBitXor:
+			return fmt.Sprintf("%s %s %s", left, op, right)
+		}
+		if isComparison(b.Op) {
+			return fmt.Sprintf("%s %s %s", left, op, right)
+		}
+
+	case *types.FloatType:
+		switch b.Op {
+		case ir.OpAdd, ir.OpSub, ir.OpMul, ir.OpDiv:
+			return fmt.Sprintf("%s %s %s", left, op, right)
+		}
+		if isComparison(b.Op) {
+			return fmt.Sprintf("%s %s %s", left, op, right)
+		}
+
+	case *types.CharType:
+		switch b.Op {
+		case ir.OpAdd, ir.OpSub:
+			return fmt.Sprintf("(int32_t)(%s %s %s)", left, op, right)
+		}
+		if isComparison(b.Op) {
+			return fmt.Sprintf("%s %s %s", left, op, right)
+		}
+
+	case *types.StringType:
+		if b.Op == ir.OpAdd {
+			return fmt.Sprintf("rt_concat(%s, %s)", left, right)
+		}
+		if isComparison(b.Op) {
+			return fmt.Sprintf("strcmp(%s, %s) %s 0", left, right, op)
+		}
+
+	case *types.BoolType:
+		switch b.Op {
+		case ir.OpAnd, ir.OpOr, ir.OpEq, ir.OpNeq:
+			return fmt.Sprintf("%s %s %s", left, op, right)
+		}
+	}
+
+	g.errorf("operator %s on %s is not supported", b.Op, b.Left.Type)
+	return "0"
+}
+
+// unary returns the C expression for a unary operation.
+func (g *generator) unary(u *ir.UnaryOp) string {
+	operand := g.operand(u.Operand)
+	switch u.Operand.Type.(type) {
+	case *types.IntType:
+		switch u.Op {
+		case ir.OpNeg:
+			return fmt.Sprintf("(int64_t)(0 - (uint64_t)%s)", operand)
+		case ir.OpBitNot:
+			return "~" + operand
+		}
+	case *types.FloatType:
+		if u.Op == ir.OpNeg {
+			return "-" + operand
+		}
+	case *types.BoolType:
+		if u.Op == ir.OpNot {
+			return "!" + operand
+		}
+	}
+	g.errorf("operator %s on %s is not supported", u.Op, u.Operand.Type)
+	return "0"
+}
@@ -0,0 +1,271 @@
+package c
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hassan/compiler/internal/interp"
+	"github.com/hassan/compiler/internal/ir"
+	"github.com/hassan/compiler/internal/semantic/types"
+	"github.com/hassan/compiler/pkg/compiler"
+)
+
+// update rewrites golden files with the current output instead of comparing.
+var update = flag.Bool("update", false, "update golden files")
+
+// compileFile compiles a testdata program with the default optimizations.
+func compileFile(t *testing.T, path string) *ir.Module {
+	t.Helper()
+	source, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := compiler.Compile(source, path, compiler.Options{OptLevel: 1})
+	if err != nil {
+		t.Fatalf("compiling %s: %v", path, err)
+	}
+	return result.Module
+}
+
+// generate translates a module, failing the test on any backend error.
+func generate(t *testing.T, module *ir.Module) string {
+	t.Helper()
+	source, errs := Generate(module)
+	for _, err := range errs {
+		t.Error(err)
+	}
+	return source
+}
+
+// TestGenerate_Golden compares the C generated for every testdata program
+// that has a .golden file. The runtime is left out of the golden files, so
+// changing it doesn't touch all of them; TestGenerate_Compile covers it.
+func TestGenerate_Golden(t *testing.T) {
+	goldens, err := filepath.Glob("testdata/*.golden")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(goldens) == 0 {
+		t.Fatal("no golden files found")
+	}
+
+	for _, golden := range goldens {
+		source := strings.TrimSuffix(golden, ".golden") + ".src"
+		t.Run(filepath.Base(source), func(t *testing.T) {
+			got := generate(t, compileFile(t, source))
+			if !strings.Contains(got, runtime) {
+				t.Fatal("generated C does not include the runtime")
+			}
+			got = strings.Replace(got, runtime, "/* runtime */\n", 1)
+
+			if *update {
+				if err := os.WriteFile(golden, []byte(got), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != string(want) {
+				t.Errorf("output differs from %s (run with -update to accept):\ngot:\n%s\nwant:\n%s", golden, got, want)
+			}
+		})
+	}
+}
+
+// TestGenerate_Compile builds every testdata program with the system C
+// compiler and checks that the executable prints what the interpreter prints
+// and exits with main's result. Skipped where there is no cc.
+func TestGenerate_Compile(t *testing.T) {
+	cc, err := exec.LookPath("cc")
+	if err != nil {
+		t.Skip("no C compiler found")
+	}
+	sources, err := filepath.Glob("testdata/*.src")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, source := range sources {
+		t.Run(filepath.Base(source), func(t *testing.T) {
+			module := compileFile(t, source)
+			dir := t.TempDir()
+			cFile := filepath.Join(dir, "main.c")
+			exe := filepath.Join(dir, "main")
+			if err := os.WriteFile(cFile, []byte(generate(t, module)), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			build := exec.Command(cc, "-std=c99", "-Wall", "-Werror", "-o", exe, cFile, "-lm")
+			if output, err := build.CombinedOutput(); err != nil {
+				t.Fatalf("cc failed: %v\n%s", err, output)
+			}
+
+			// What the interpreter says the program does
+			var wantStdout bytes.Buffer
+			machine := interp.New(module)
+			machine.Stdout = &wantStdout
+			result, runErr := machine.Run("main", nil)
+			wantCode := 0
+			if code, ok := result.(int64); ok {
+				wantCode = int(uint8(code)) // Exit statuses are truncated to a byte
+			}
+			if runErr != nil {
+				wantCode = 2
+			}
+
+			var stdout, stderr bytes.Buffer
+			run := exec.Command(exe)
+			run.Stdout = &stdout
+			run.Stderr = &stderr
+			code := 0
+			if err := run.Run(); err != nil {
+				var exitErr *exec.ExitError
+				if !errors.As(err, &exitErr) {
+					t.Fatal(err)
+				}
+				code = exitErr.ExitCode()
+			}
+
+			if stdout.String() != wantStdout.String() {
+				t.Errorf("stdout = %q, interpreter printed %q", stdout.String(), wantStdout.String())
+			}
+			if code != wantCode {
+				t.Errorf("exit code = %d, want %d (stderr %q)", code, wantCode, stderr.String())
+			}
+			if runErr != nil && !strings.Contains(stderr.String(), "runtime error: ") {
+				t.Errorf("stderr = %q, want a runtime error (interpreter: %v)", stderr.String(), runErr)
+			}
+		})
+	}
+}
+
+func TestGenerate_NoMain(t *testing.T) {
+	module := ir.NewModule("lib")
+	fn := ir.NewFunction("helper", nil, types.Void)
+	fn.Entry.AddInstruction(&ir.Return{})
+	module.AddFunction(fn)
+
+	got := generate(t, module)
+	if strings.Contains(got, "int main(") {
+		t.Errorf("module without main got a C main:\n%s", got)
+	}
+	if !strings.Contains(got, "static void fn_helper(void) {") {
+		t.Errorf("missing fn_helper definition:\n%s", got)
+	}
+}
+
+// TestGenerate_Structured checks that ifs and loops come out as C ifs and
+// loops, with no goto left.
+func TestGenerate_Structured(t *testing.T) {
+	source := `package main
+
+func collatz(n int) int {
+    var steps int = 0;
+    while (n != 1) {
+        if (n % 2 == 0) {
+            n = n / 2;
+        } else {
+            n = 3 * n + 1;
+        }
+        steps = steps + 1;
+    }
+    return steps;
+}
+`
+	result, err := compiler.Compile([]byte(source), "input.src", compiler.Options{OptLevel: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := generate(t, result.Module)
+
+	for _, want := range []string{
+		"    while (n_1 != 1) {\n",
+		"        if (t4 == 0) {\n",
+		"        } else {\n",
+		"    return steps_2;\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected %q in:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "goto") {
+		t.Errorf("expected no goto in:\n%s", got)
+	}
+}
+
+// TestGenerate_Irreducible builds a loop with two entries, which no source
+// program produces, and checks that it is still translated, with gotos.
+func TestGenerate_Irreducible(t *testing.T) {
+	fn := ir.NewFunction("f", nil, types.Void)
+	cond := &ir.Value{ID: 0, Name: "c", Type: types.Bool, Kind: ir.ValueVariable}
+	a := fn.NewBasicBlockInFunc("a")
+	b := fn.NewBasicBlockInFunc("b")
+	fn.Entry.AddInstruction(&ir.Branch{Condition: cond, TrueBlock: a, FalseBlock: b})
+	a.AddInstruction(&ir.Branch{Condition: cond, TrueBlock: b, FalseBlock: a})
+	b.AddInstruction(&ir.Jump{Target: a})
+	module := ir.NewModule("main")
+	module.AddFunction(fn)
+
+	got := generate(t, module)
+	for _, want := range []string{"bb1_a:;\n", "bb2_b:;\n", "    goto bb1_a;\n"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected %q in:\n%s", want, got)
+		}
+	}
+}
+
+func TestGenerate_Unsupported(t *testing.T) {
+	module := ir.NewModule("main")
+	fn := ir.NewFunction("main", nil, types.Void)
+	entry := fn.Entry
+	slice := &ir.Value{ID: 0, Name: "s", Type: &types.ArrayType{ElementType: types.Int, Size: -1}}
+	entry.AddInstruction(&ir.Alloca{Dest: slice, Type: slice.Type})
+	entry.AddInstruction(&ir.Return{})
+	module.AddFunction(fn)
+
+	_, errs := Generate(module)
+	if len(errs) == 0 {
+		t.Fatal("expected an error for a slice")
+	}
+	if msg := errs[0].Error(); !strings.Contains(msg, "in function main") || !strings.Contains(msg, "slices are not supported") {
+		t.Errorf("error = %q", msg)
+	}
+}
+
+func TestLiteral(t *testing.T) {
+	tests := []struct {
+		name     string
+		constant interface{}
+		want     string
+	}{
+		{"small int", int64(42), "42"},
+		{"negative int", int64(-7), "-7"},
+		{"large int", int64(1) << 40, "INT64_C(1099511627776)"},
+		{"min int", int64(-1) << 63, "INT64_MIN"},
+		{"whole float", 2.0, "2.0"},
+		{"fraction", 0.25, "0.25"},
+		{"exponent", 1e21, "1e+21"},
+		{"true", true, "true"},
+		{"char", 'é', "233"},
+		{"plain string", "hi", `"hi"`},
+		{"escaped string", "a\"b\\c\n?", `"a\"b\\c\n\?"`},
+		{"non-ASCII string", "é1", `"\303\2511"`},
+	}
+
+	g := &generator{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := g.literal(&ir.Value{Kind: ir.ValueConstant, Constant: tt.constant})
+			if got != tt.want {
+				t.Errorf("literal(%v) = %s, want %s", tt.constant, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package c
+
+// runtime is the support code at the top of every generated file.
+//
+// DESIGN CHOICE: The generated program must behave exactly like the IR
+// interpreter, which implements the language's semantics. C leaves several
+// of those undefined (signed overflow, division by zero, oversized shifts),
+// so the operations that differ go through these helpers instead of the bare
+// C operator. They are static inline so a program that never divides doesn't
+// get an unused-function warning for rt_div.
+//
+// Runtime errors print "runtime error: <message>" to stderr and exit with
+// status 2, like `compiler run`.
+const runtime = `#include <inttypes.h>
+#include <math.h>
+#include <stdbool.h>
+#include <stdint.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+
+static inline void rt_fail(const char *message) {
+    fflush(stdout);
+    fprintf(stderr, "runtime error: %s\n", message);
+    exit(2);
+}
+
+static inline int64_t rt_div(int64_t a, int64_t b) {
+    if (b == 0) rt_fail("integer division by zero");
+    if (b == -1) return (int64_t)(0 - (uint64_t)a);
+    return a / b;
+}
+
+static inline int64_t rt_mod(int64_t a, int64_t b) {
+    if (b == 0) rt_fail("integer division by zero");
+    if (b == -1) return 0;
+    return a % b;
+}
+
+static inline void rt_check_shift(int64_t b) {
+    if (b < 0) {
+        char message[64];
+        snprintf(message, sizeof message, "negative shift amount %" PRId64, b);
+        rt_fail(message);
+    }
+}
+
+static inline int64_t rt_shl(int64_t a, int64_t b) {
+    rt_check_shift(b);
+    return b >= 64 ? 0 : (int64_t)((uint64_t)a << b);
+}
+
+static inline int64_t rt_shr(int64_t a, int64_t b) {
+    rt_check_shift(b);
+    if (b >= 64) return a < 0 ? -1 : 0;
+    return a >> b;
+}
+
+/* uint64 values are held in int64_t with the same bits; these are the
+   operations whose result depends on the sign bit. */
+static inline int64_t rt_udiv(int64_t a, int64_t b) {
+    if (b == 0) rt_fail("integer division by zero");
+    return (int64_t)((uint64_t)a / (uint64_t)b);
+}
+
+static inline int64_t rt_umod(int64_t a, int64_t b) {
+    if (b == 0) rt_fail("integer division by zero");
+    return (int64_t)((uint64_t)a % (uint64_t)b);
+}
+
+static inline int64_t rt_ushr(int64_t a, int64_t b) {
+    rt_check_shift(b);
+    return b >= 64 ? 0 : (int64_t)((uint64_t)a >> b);
+}
+
+static inline void rt_check_index(int64_t index, int64_t length) {
+    if (index < 0 || index >= length) {
+        char message[96];
+        snprintf(message, sizeof message, "index %" PRId64 " out of range [0:%" PRId64 "]", index, length);
+        rt_fail(message);
+    }
+}
+
+/* Strings are immutable and never freed. */
+static inline const char *rt_concat(const char *a, const char *b) {
+    size_t la = strlen(a), lb = strlen(b);
+    char *s = malloc(la + lb + 1);
+    if (s == NULL) rt_fail("out of memory");
+    memcpy(s, a, la);
+    memcpy(s + la, b, lb + 1);
+    return s;
+}
+
+static inline void rt_print_int(int64_t v) { printf("%" PRId64, v); }
+static inline void rt_print_uint(int64_t v) { printf("%" PRIu64, (uint64_t)v); }
+static inline void rt_print_bool(bool v) { fputs(v ? "true" : "false", stdout); }
+static inline void rt_print_string(const char *v) { fputs(v, stdout); }
+
+/* Chars are Unicode code points, printed as UTF-8. */
+static inline void rt_print_char(int32_t c) {
+    if (c < 0 || c > 0x10FFFF || (c >= 0xD800 && c <= 0xDFFF)) c = 0xFFFD;
+    if (c < 0x80) {
+        putchar(c);
+    } else if (c < 0x800) {
+        putchar(0xC0 | (c >> 6));
+        putchar(0x80 | (c & 0x3F));
+    } else if (c < 0x10000) {
+        putchar(0xE0 | (c >> 12));
+        putchar(0x80 | ((c >> 6) & 0x3F));
+        putchar(0x80 | (c & 0x3F));
+    } else {
+        putchar(0xF0 | (c >> 18));
+        putchar(0x80 | ((c >> 12) & 0x3F));
+        putchar(0x80 | ((c >> 6) & 0x3F));
+        putchar(0x80 | (c & 0x3F));
+    }
+}
+
+/* Floats print like Go's %v: the fewest digits that read back as the same
+   value, in exponent form below 1e-4 and from 1e6. */
+static inline void rt_print_float(double v) {
+    char buf[32];
+    int precision, exponent;
+    if (isnan(v)) { fputs("NaN", stdout); return; }
+    if (isinf(v)) { fputs(v > 0 ? "+Inf" : "-Inf", stdout); return; }
+    for (precision = 1; precision < 17; precision++) {
+        snprintf(buf, sizeof buf, "%.*e", precision - 1, v);
+        if (strtod(buf, NULL) == v) break;
+    }
+    snprintf(buf, sizeof buf, "%.*e", precision - 1, v);
+    exponent = atoi(strchr(buf, 'e') + 1);
+    if (exponent < -4 || exponent >= 6) {
+        fputs(buf, stdout);
+    } else {
+        int decimals = precision - 1 - exponent;
+        printf("%.*f", decimals > 0 ? decimals : 0, v);
+    }
+}
+`
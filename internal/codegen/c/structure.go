@@ -0,0 +1,473 @@
+package c
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hassan/compiler/internal/codegen/cfg"
+	"github.com/hassan/compiler/internal/ir"
+	"github.com/hassan/compiler/internal/semantic/types"
+)
+
+// Structured control flow.
+//
+// A function's blocks are laid out along its dominator tree, the way the
+// wasm backend lays them out (see package cfg), so that the C reads like
+// the source it came from:
+//
+//	entry:    i = 0; goto cond              i_1 = 0;
+//	cond:     t2 = i < 10                   while (i_1 < 10) {
+//	          if (t2) goto body; goto end       i_1 = i_1 + 1;
+//	body:     i = i + 1; goto cond          }
+//	end:      return i                      return i_1;
+//
+//   - A loop header becomes a loop: "while (c)" when all the header does
+//     is test c and leave the loop, "for (;;)" otherwise
+//   - A Branch becomes an if, and when one side leaves (a return, break,
+//     continue or goto) the other follows the if instead of nesting in an
+//     else: "if (!c) break;"
+//   - A block reached by one forward edge is written where that edge is
+//   - A merge node (two or more forward edges into it) and a loop exit are
+//     written after the construct their edges leave, so those edges fall
+//     through to them, or leave by break
+//
+// Whatever isn't a fall-through, a break out of the innermost loop or a
+// continue of it - a break out of two loops, say - is a goto, to a label
+// written only where some goto names it. C allows any jump, so every
+// reducible CFG translates; an irreducible one, which no source program
+// produces, falls back to the translation with gotos.
+//
+// A comparison computed just for the Branch after it, and used nowhere
+// else, is written in the if or while instead of into a temporary.
+//
+// DESIGN CHOICE: Structure rebuilt from the dominator tree rather than by
+// matching the shapes the IR builder emits for if and while. After the
+// optimizer has merged, folded and threaded the blocks, those shapes are
+// gone as often as not, and a pattern that fails to match would leave a
+// function full of gotos. The dominator tree finds the structure whatever
+// produced the CFG.
+
+// loop is a loop being written: its header, and follow, what falling out
+// of it reaches - where a break goes.
+type loop struct {
+	header *ir.BasicBlock
+	follow *ir.BasicBlock
+}
+
+// labelMarker starts a line standing for a block's label. The label is
+// written only if some goto names it, which is only known at the end.
+const labelMarker = "\x00"
+
+// structured returns the body of the current function as structured C,
+// or false if its CFG is irreducible.
+func (g *generator) structured(fn *ir.Function) ([]string, bool) {
+	g.cfg = cfg.New(fn)
+	if from, _ := g.cfg.Irreducible(); from != nil || len(g.cfg.Order) == 0 {
+		return nil, false
+	}
+	g.lines = nil
+	g.indent = 1
+	g.loops = nil
+	g.jumped = make(map[string]bool)
+	g.inlined = make(map[*ir.Value]bool)
+	g.placeBlocks()
+
+	g.countUses(fn)
+	g.doTree(g.cfg.Order[0], nil)
+
+	var lines []string
+	for _, line := range g.lines {
+		if !strings.HasPrefix(line, labelMarker) {
+			lines = append(lines, line)
+			continue
+		}
+		if name := line[len(labelMarker):]; g.jumped[name] {
+			lines = append(lines, name+":;")
+		}
+	}
+	return lines, true
+}
+
+// placeBlocks finds the blocks written after a construct rather than where
+// an edge to them is: merge nodes, and the exits of each loop. An exit is a
+// block outside a loop whose immediate dominator is in it, innermost loop
+// first - the block after a while, but also the code after a break, written
+// after the loop rather than in it. A lone block returning from the body of
+// the loop, rather than its header, stays there.
+func (g *generator) placeBlocks() {
+	g.placed = make(map[*ir.BasicBlock]bool)
+	g.exits = make(map[*ir.BasicBlock][]*ir.BasicBlock)
+	g.exit = make(map[*ir.BasicBlock]bool)
+
+	// Inner loop headers come later in reverse postorder, so each block
+	// ends up with the innermost loop around it
+	innermost := make(map[*ir.BasicBlock]*ir.BasicBlock)
+	bodies := make(map[*ir.BasicBlock]map[*ir.BasicBlock]bool)
+	for _, block := range g.cfg.Order {
+		if g.cfg.IsMergeNode(block) {
+			g.placed[block] = true
+		}
+		if g.cfg.IsLoopHeader(block) {
+			bodies[block] = g.cfg.Loop(block)
+			for member := range bodies[block] {
+				innermost[member] = block
+			}
+		}
+	}
+
+	for _, block := range g.cfg.Order {
+		for _, child := range g.cfg.Children(block) {
+			if _, returns := child.Terminator().(*ir.Return); returns && len(g.cfg.Children(child)) == 0 && !g.cfg.IsMergeNode(child) && !g.cfg.IsLoopHeader(block) {
+				continue // An early return reads best where it is
+			}
+			if header := innermost[block]; header != nil && !bodies[header][child] {
+				g.exits[header] = append(g.exits[header], child)
+				g.exit[child] = true
+				g.placed[child] = true
+			}
+		}
+	}
+	for _, exits := range g.exits {
+		sort.Slice(exits, func(i, j int) bool { return g.cfg.RPO(exits[i]) > g.cfg.RPO(exits[j]) })
+	}
+}
+
+// countUses counts how many times each value is read, for finding the
+// comparisons only a Branch reads.
+func (g *generator) countUses(fn *ir.Function) {
+	g.uses = make(map[*ir.Value]int)
+	for _, block := range fn.Blocks {
+		for _, instr := range block.Instructions {
+			for _, operand := range instr.Operands() {
+				g.uses[operand]++
+			}
+		}
+	}
+}
+
+// line writes one statement at the current depth.
+func (g *generator) line(format string, args ...interface{}) {
+	g.lines = append(g.lines, strings.Repeat("    ", g.indent)+fmt.Sprintf(format, args...))
+}
+
+// label writes the label of block, to be kept if a goto names it.
+func (g *generator) label(block *ir.BasicBlock) {
+	g.lines = append(g.lines, labelMarker+g.labelName(block))
+}
+
+// nested returns what emit writes one level deeper, without writing it.
+func (g *generator) nested(emit func()) []string {
+	saved := g.lines
+	g.lines = nil
+	g.indent++
+	emit()
+	g.indent--
+	lines := g.lines
+	g.lines = saved
+	return lines
+}
+
+// doTree writes block and everything it dominates. follow is the block
+// that falling off the end of what is written reaches, nil for the end of
+// the function.
+func (g *generator) doTree(block *ir.BasicBlock, follow *ir.BasicBlock) {
+	if g.cfg.IsLoopHeader(block) {
+		g.loopWithExits(block, g.exits[block], follow)
+		return
+	}
+	g.nodeWithin(block, g.merges(block), follow, func(follow *ir.BasicBlock) {
+		g.code(block, follow)
+	})
+}
+
+// merges returns the merge nodes block immediately dominates, latest in
+// reverse postorder first, leaving out loop exits: those follow their loop.
+func (g *generator) merges(block *ir.BasicBlock) []*ir.BasicBlock {
+	var merges []*ir.BasicBlock
+	for _, merge := range g.cfg.MergeChildren(block) {
+		if !g.exit[merge] {
+			merges = append(merges, merge)
+		}
+	}
+	return merges
+}
+
+// loopWithExits writes the loop headed by header followed by its exits,
+// latest in reverse postorder last, so that the loop falls through to the
+// earliest.
+func (g *generator) loopWithExits(header *ir.BasicBlock, exits []*ir.BasicBlock, follow *ir.BasicBlock) {
+	if len(exits) > 0 {
+		g.loopWithExits(header, exits[1:], exits[0])
+		g.label(exits[0])
+		g.doTree(exits[0], follow)
+		return
+	}
+
+	body := g.cfg.Loop(header)
+	merges := g.merges(header)
+	g.label(header)
+	g.loops = append(g.loops, loop{header: header, follow: follow})
+	defer func() { g.loops = g.loops[:len(g.loops)-1] }()
+
+	if cond, next, ok := g.whileCondition(header, body, follow); ok {
+		g.line("while (%s) {", cond)
+		g.lines = append(g.lines, g.nested(func() {
+			g.nodeWithin(header, merges, header, func(follow *ir.BasicBlock) {
+				g.edge(header, next, follow)
+			})
+		})...)
+		g.line("}")
+		return
+	}
+	g.line("for (;;) {")
+	g.lines = append(g.lines, g.nested(func() {
+		g.nodeWithin(header, merges, header, func(follow *ir.BasicBlock) {
+			g.code(header, follow)
+		})
+	})...)
+	g.line("}")
+}
+
+// whileCondition returns the condition of a while loop headed by header,
+// and the block the loop goes on to while it holds, if header does nothing
+// but test the condition and leave the loop for follow when it fails.
+func (g *generator) whileCondition(header *ir.BasicBlock, body map[*ir.BasicBlock]bool, follow *ir.BasicBlock) (string, *ir.BasicBlock, bool) {
+	branch, ok := header.Terminator().(*ir.Branch)
+	if !ok || len(g.phiCopies(header, branch.TrueBlock)) > 0 || len(g.phiCopies(header, branch.FalseBlock)) > 0 {
+		return "", nil, false
+	}
+	test := g.inlinedTest(header, branch)
+	if len(header.Instructions) != 1 && (test == nil || len(header.Instructions) != 2) {
+		return "", nil, false
+	}
+	switch {
+	case body[branch.TrueBlock] && branch.FalseBlock == follow:
+		return g.condition(branch, test, false), branch.TrueBlock, true
+	case body[branch.FalseBlock] && branch.TrueBlock == follow:
+		return g.condition(branch, test, true), branch.FalseBlock, true
+	}
+	return "", nil, false
+}
+
+// nodeWithin writes the code of block, by calling code, followed by each
+// merge node it dominates. Each merge node follows a region taking in the
+// code that branches to it, latest merge node outermost.
+func (g *generator) nodeWithin(block *ir.BasicBlock, merges []*ir.BasicBlock, follow *ir.BasicBlock, code func(follow *ir.BasicBlock)) {
+	if len(merges) > 0 {
+		g.nodeWithin(block, merges[1:], merges[0], code)
+		g.label(merges[0])
+		g.doTree(merges[0], follow)
+		return
+	}
+	code(follow)
+}
+
+// code writes the instructions of block, ending with its terminator.
+func (g *generator) code(block *ir.BasicBlock, follow *ir.BasicBlock) {
+	branch, _ := block.Terminator().(*ir.Branch)
+	var test ir.Instruction
+	if branch != nil {
+		test = g.inlinedTest(block, branch)
+	}
+
+	for _, instr := range block.Instructions {
+		switch i := instr.(type) {
+		case *ir.Jump:
+			g.edge(block, i.Target, follow)
+		case *ir.Branch:
+			g.branch(block, i, test, follow)
+		default:
+			if instr == test {
+				continue // Written in the if
+			}
+			for _, line := range g.instruction(block, instr) {
+				g.line("%s", line)
+			}
+		}
+	}
+}
+
+// inlinedTest returns the comparison branch tests if it is computed just
+// before the branch and read nowhere else, so it can be written in the if
+// itself; otherwise nil.
+func (g *generator) inlinedTest(block *ir.BasicBlock, branch *ir.Branch) ir.Instruction {
+	cond := branch.Condition
+	if cond.Kind != ir.ValueTemporary || g.uses[cond] != 1 || len(block.Instructions) < 2 {
+		return nil
+	}
+	switch test := block.Instructions[len(block.Instructions)-2].(type) {
+	case *ir.BinaryOp:
+		if test.Dest == cond && isComparison(test.Op) {
+			g.inlined[cond] = true
+			return test
+		}
+	case *ir.UnaryOp:
+		if test.Dest == cond && test.Op == ir.OpNot {
+			g.inlined[cond] = true
+			return test
+		}
+	}
+	return nil
+}
+
+// inverses are the comparisons that hold exactly when another doesn't.
+// They don't hold for floats, where every comparison with NaN is false.
+var inverses = map[ir.BinaryOperator]ir.BinaryOperator{
+	ir.OpEq: ir.OpNeq, ir.OpNeq: ir.OpEq,
+	ir.OpLt: ir.OpGe, ir.OpGe: ir.OpLt,
+	ir.OpGt: ir.OpLe, ir.OpLe: ir.OpGt,
+}
+
+// condition returns the C condition of branch, negated if negate is set.
+// test is the instruction computing it, to be written in its place, or nil.
+func (g *generator) condition(branch *ir.Branch, test ir.Instruction, negate bool) string {
+	switch t := test.(type) {
+	case *ir.BinaryOp:
+		if _, isFloat := t.Left.Type.(*types.FloatType); negate && !isFloat {
+			inverse := *t
+			inverse.Op = inverses[t.Op]
+			return g.binary(&inverse)
+		}
+		if negate {
+			return "!(" + g.binary(t) + ")"
+		}
+		return g.binary(t)
+	case *ir.UnaryOp:
+		if negate {
+			return g.operand(t.Operand)
+		}
+		return g.unary(t)
+	}
+	if negate {
+		return "!" + g.operand(branch.Condition)
+	}
+	return g.operand(branch.Condition)
+}
+
+// branch writes a Branch as an if.
+func (g *generator) branch(block *ir.BasicBlock, branch *ir.Branch, test ir.Instruction, follow *ir.BasicBlock) {
+	then := g.nested(func() { g.edge(block, branch.TrueBlock, follow) })
+	otherwise := g.nested(func() { g.edge(block, branch.FalseBlock, follow) })
+
+	switch {
+	case len(then) == 0 && len(otherwise) == 0:
+		return
+	case len(otherwise) == 0:
+		g.ifStatement(g.condition(branch, test, false), then)
+	case len(then) == 0:
+		g.ifStatement(g.condition(branch, test, true), otherwise)
+	case g.leaves(then):
+		g.ifStatement(g.condition(branch, test, false), then)
+		g.lines = append(g.lines, dedent(otherwise)...)
+	case g.leaves(otherwise):
+		g.ifStatement(g.condition(branch, test, true), otherwise)
+		g.lines = append(g.lines, dedent(then)...)
+	default:
+		g.line("if (%s) {", g.condition(branch, test, false))
+		g.lines = append(g.lines, then...)
+		if chained, ok := g.elseIf(otherwise); ok {
+			g.lines = append(g.lines, chained...)
+			return
+		}
+		g.line("} else {")
+		g.lines = append(g.lines, otherwise...)
+		g.line("}")
+	}
+}
+
+// ifStatement writes "if (cond)" and body, on one line if body is a lone
+// jump.
+func (g *generator) ifStatement(cond string, body []string) {
+	if len(body) == 1 && g.leaves(body) {
+		g.line("if (%s) %s", cond, strings.TrimSpace(body[0]))
+		return
+	}
+	g.line("if (%s) {", cond)
+	g.lines = append(g.lines, body...)
+	g.line("}")
+}
+
+// leaves reports whether lines, one level deeper than the current depth,
+// end in a statement that doesn't go on to the next one.
+func (g *generator) leaves(lines []string) bool {
+	if len(lines) == 0 {
+		return false
+	}
+	last := lines[len(lines)-1]
+	indent := strings.Repeat("    ", g.indent+1)
+	if !strings.HasPrefix(last, indent) || strings.HasPrefix(last, indent+" ") {
+		return false // The end of a nested statement
+	}
+	last = last[len(indent):]
+	return last == "break;" || last == "continue;" || strings.HasPrefix(last, "return") || strings.HasPrefix(last, "goto ")
+}
+
+// elseIf returns the rest of an if whose else branch, lines, is itself
+// nothing but an if (with any else-ifs and else of its own), written as
+// "} else if": the chain a switch becomes.
+func (g *generator) elseIf(lines []string) ([]string, bool) {
+	indent := strings.Repeat("    ", g.indent+1)
+	if !strings.HasPrefix(lines[0], indent+"if (") || !strings.HasSuffix(lines[0], "{") || lines[len(lines)-1] != indent+"}" {
+		return nil, false
+	}
+	for _, line := range lines[1 : len(lines)-1] {
+		if strings.HasPrefix(line, indent) && !strings.HasPrefix(line, indent+" ") &&
+			line != indent+"} else {" && !strings.HasPrefix(line, indent+"} else if (") {
+			return nil, false // A second statement
+		}
+		if strings.HasPrefix(line, labelMarker) {
+			return nil, false
+		}
+	}
+	chained := dedent(lines)
+	chained[0] = strings.Repeat("    ", g.indent) + "} else " + strings.TrimSpace(chained[0])
+	return chained, true
+}
+
+// dedent moves lines one level out.
+func dedent(lines []string) []string {
+	out := make([]string, len(lines))
+	for i, line := range lines {
+		out[i] = strings.TrimPrefix(line, "    ")
+	}
+	return out
+}
+
+// edge writes the transfer of control from one block to another: the phi
+// copies it does, then a fall-through, a break, a continue or a goto, or
+// the target itself if this is the only way into it.
+func (g *generator) edge(from, to *ir.BasicBlock, follow *ir.BasicBlock) {
+	for _, line := range g.phiCopies(from, to) {
+		g.line("%s", line)
+	}
+
+	var innermost *loop
+	if len(g.loops) > 0 {
+		innermost = &g.loops[len(g.loops)-1]
+	}
+	switch {
+	case to == follow:
+		// Falls through
+	case g.cfg.IsBackEdge(from, to):
+		if innermost != nil && innermost.header == to {
+			g.line("continue;")
+		} else {
+			g.jump(to)
+		}
+	case g.placed[to]:
+		if innermost != nil && innermost.follow == to {
+			g.line("break;")
+		} else {
+			g.jump(to)
+		}
+	default:
+		g.doTree(to, follow)
+	}
+}
+
+// jump writes a goto to block.
+func (g *generator) jump(block *ir.BasicBlock) {
+	g.jumped[g.labelName(block)] = true
+	g.line("goto %s;", g.labelName(block))
+}
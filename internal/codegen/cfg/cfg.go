@@ -0,0 +1,260 @@
+// Package cfg analyzes the control flow graph of an IR function for the
+// backends that turn it back into structured control flow.
+//
+// WHY RESTRUCTURE?
+// WebAssembly has no goto. A branch can only leave an enclosing block
+// (jumping to its end) or restart an enclosing loop (jumping to its start),
+// so the IR's arbitrary jumps between basic blocks must be rebuilt into
+// nested block/loop/if constructs. C has goto, but reads far better as the
+// ifs and loops the program was written with.
+package cfg
+
+import (
+	"sort"
+
+	"github.com/hassan/compiler/internal/ir"
+)
+
+// Graph is the control flow graph of one function, analyzed for turning
+// back into structured control flow.
+//
+// DESIGN CHOICE: The dominator-tree algorithm from Norman Ramsey's "Beyond
+// Relooper" (ICFP 2022) rather than the original Relooper. It places every
+// basic block exactly once - no code is duplicated - and needs only reverse
+// postorder numbers and the dominator tree:
+//   - A block with a back edge into it (a loop header) is wrapped in a loop,
+//     and the back edges become br to that loop
+//   - A block with two or more forward edges into it (a merge node) is
+//     emitted right after a block enclosing all of its predecessors, and the
+//     edges become br out of that block
+//   - A block with a single forward edge into it is emitted inline, at the
+//     branch that reaches it
+//
+// This works for every reducible CFG, which is every CFG the IR builder
+// produces from structured source. An irreducible one (a loop with two
+// entries) is reported by Irreducible, for the backend to refuse or to
+// translate some other way.
+type Graph struct {
+	// Order lists the blocks reachable from the entry in reverse postorder
+	Order []*ir.BasicBlock
+
+	// rpo is each reachable block's position in order
+	rpo map[*ir.BasicBlock]int
+
+	// succs and preds are the edges, read from the terminators. An edge
+	// appears twice when a branch has the same block on both sides.
+	succs map[*ir.BasicBlock][]*ir.BasicBlock
+	preds map[*ir.BasicBlock][]*ir.BasicBlock
+
+	// idom is the immediate dominator of each block except the entry
+	idom map[*ir.BasicBlock]*ir.BasicBlock
+
+	// children are the blocks each block immediately dominates, in reverse
+	// postorder
+	children map[*ir.BasicBlock][]*ir.BasicBlock
+}
+
+// successors returns the targets of a block's terminator.
+func successors(block *ir.BasicBlock) []*ir.BasicBlock {
+	switch term := block.Terminator().(type) {
+	case *ir.Jump:
+		return []*ir.BasicBlock{term.Target}
+	case *ir.Branch:
+		return []*ir.BasicBlock{term.TrueBlock, term.FalseBlock}
+	default:
+		return nil
+	}
+}
+
+// New analyzes the blocks of fn reachable from its entry. Unreachable
+// blocks need no code and are left out.
+func New(fn *ir.Function) *Graph {
+	g := &Graph{
+		rpo:      make(map[*ir.BasicBlock]int),
+		succs:    make(map[*ir.BasicBlock][]*ir.BasicBlock),
+		preds:    make(map[*ir.BasicBlock][]*ir.BasicBlock),
+		idom:     make(map[*ir.BasicBlock]*ir.BasicBlock),
+		children: make(map[*ir.BasicBlock][]*ir.BasicBlock),
+	}
+	if len(fn.Blocks) == 0 {
+		return g
+	}
+
+	// Postorder by depth-first search from the entry
+	visited := make(map[*ir.BasicBlock]bool)
+	var postorder []*ir.BasicBlock
+	var visit func(block *ir.BasicBlock)
+	visit = func(block *ir.BasicBlock) {
+		visited[block] = true
+		g.succs[block] = successors(block)
+		for _, succ := range g.succs[block] {
+			if !visited[succ] {
+				visit(succ)
+			}
+		}
+		postorder = append(postorder, block)
+	}
+	visit(fn.Blocks[0])
+
+	for i := len(postorder) - 1; i >= 0; i-- {
+		g.rpo[postorder[i]] = len(g.Order)
+		g.Order = append(g.Order, postorder[i])
+	}
+	for _, block := range g.Order {
+		for _, succ := range g.succs[block] {
+			g.preds[succ] = append(g.preds[succ], block)
+		}
+	}
+
+	g.computeDominators()
+	for _, block := range g.Order[1:] {
+		parent := g.idom[block]
+		g.children[parent] = append(g.children[parent], block)
+	}
+	return g
+}
+
+// computeDominators fills in idom with the iterative algorithm of Cooper,
+// Harvey and Kennedy ("A Simple, Fast Dominance Algorithm"): process blocks
+// in reverse postorder, intersecting the dominators of the processed
+// predecessors, until nothing changes.
+func (g *Graph) computeDominators() {
+	entry := g.Order[0]
+	g.idom[entry] = entry
+
+	intersect := func(a, b *ir.BasicBlock) *ir.BasicBlock {
+		for a != b {
+			for g.rpo[a] > g.rpo[b] {
+				a = g.idom[a]
+			}
+			for g.rpo[b] > g.rpo[a] {
+				b = g.idom[b]
+			}
+		}
+		return a
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for _, block := range g.Order[1:] {
+			var dom *ir.BasicBlock
+			for _, pred := range g.preds[block] {
+				if g.idom[pred] == nil {
+					continue // Not processed yet
+				}
+				if dom == nil {
+					dom = pred
+				} else {
+					dom = intersect(pred, dom)
+				}
+			}
+			if g.idom[block] != dom {
+				g.idom[block] = dom
+				changed = true
+			}
+		}
+	}
+	delete(g.idom, entry)
+}
+
+// Dominates reports whether every path from the entry to b passes through a.
+func (g *Graph) Dominates(a, b *ir.BasicBlock) bool {
+	for ; b != nil; b = g.idom[b] {
+		if a == b {
+			return true
+		}
+	}
+	return false
+}
+
+// IsBackEdge reports whether the edge from -> to goes backwards in reverse
+// postorder, closing a loop.
+func (g *Graph) IsBackEdge(from, to *ir.BasicBlock) bool {
+	return g.rpo[to] <= g.rpo[from]
+}
+
+// IsLoopHeader reports whether a back edge enters block.
+func (g *Graph) IsLoopHeader(block *ir.BasicBlock) bool {
+	for _, pred := range g.preds[block] {
+		if g.IsBackEdge(pred, block) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsMergeNode reports whether two or more forward edges enter block.
+func (g *Graph) IsMergeNode(block *ir.BasicBlock) bool {
+	forward := 0
+	for _, pred := range g.preds[block] {
+		if !g.IsBackEdge(pred, block) {
+			forward++
+		}
+	}
+	return forward >= 2
+}
+
+// MergeChildren returns the merge nodes block immediately dominates, latest
+// in reverse postorder first: the order of the blocks that enclose block's
+// code, from outermost in.
+func (g *Graph) MergeChildren(block *ir.BasicBlock) []*ir.BasicBlock {
+	var merges []*ir.BasicBlock
+	for _, child := range g.children[block] {
+		if g.IsMergeNode(child) {
+			merges = append(merges, child)
+		}
+	}
+	sort.Slice(merges, func(i, j int) bool { return g.rpo[merges[i]] > g.rpo[merges[j]] })
+	return merges
+}
+
+// Irreducible returns a back edge whose target does not dominate its
+// source - a second way into a loop - or nil if the CFG is reducible.
+func (g *Graph) Irreducible() (from, to *ir.BasicBlock) {
+	for _, block := range g.Order {
+		for _, succ := range g.succs[block] {
+			if g.IsBackEdge(block, succ) && !g.Dominates(succ, block) {
+				return block, succ
+			}
+		}
+	}
+	return nil, nil
+}
+
+// RPO returns block's position in reverse postorder.
+func (g *Graph) RPO(block *ir.BasicBlock) int {
+	return g.rpo[block]
+}
+
+// Children returns the blocks block immediately dominates, in reverse
+// postorder.
+func (g *Graph) Children(block *ir.BasicBlock) []*ir.BasicBlock {
+	return g.children[block]
+}
+
+// Loop returns the blocks of the natural loop headed by header: header
+// itself and every block that reaches one of its back edges without going
+// through it. It is empty if header is not a loop header.
+func (g *Graph) Loop(header *ir.BasicBlock) map[*ir.BasicBlock]bool {
+	blocks := make(map[*ir.BasicBlock]bool)
+	var work []*ir.BasicBlock
+	for _, pred := range g.preds[header] {
+		if g.IsBackEdge(pred, header) {
+			work = append(work, pred)
+		}
+	}
+	if len(work) == 0 {
+		return blocks
+	}
+	blocks[header] = true
+	for len(work) > 0 {
+		block := work[len(work)-1]
+		work = work[:len(work)-1]
+		if blocks[block] {
+			continue
+		}
+		blocks[block] = true
+		work = append(work, g.preds[block]...)
+	}
+	return blocks
+}
@@ -0,0 +1,51 @@
+package cfg
+
+import (
+	"testing"
+
+	"github.com/hassan/compiler/internal/ir"
+	"github.com/hassan/compiler/internal/semantic/types"
+)
+
+func TestCFG(t *testing.T) {
+	// entry -> loop <-> body, loop -> exit: a while loop
+	fn := ir.NewFunction("f", nil, types.Void)
+	cond := &ir.Value{ID: 0, Name: "c", Type: types.Bool, Kind: ir.ValueVariable}
+	loop := fn.NewBasicBlockInFunc("loop")
+	body := fn.NewBasicBlockInFunc("body")
+	exit := fn.NewBasicBlockInFunc("exit")
+	dead := fn.NewBasicBlockInFunc("dead")
+	fn.Entry.AddInstruction(&ir.Jump{Target: loop})
+	loop.AddInstruction(&ir.Branch{Condition: cond, TrueBlock: body, FalseBlock: exit})
+	body.AddInstruction(&ir.Jump{Target: loop})
+	exit.AddInstruction(&ir.Return{})
+	dead.AddInstruction(&ir.Jump{Target: exit})
+
+	g := New(fn)
+	if len(g.Order) != 4 {
+		t.Errorf("expected the 4 reachable blocks, got %d", len(g.Order))
+	}
+	if _, ok := g.rpo[dead]; ok {
+		t.Error("unreachable block was numbered")
+	}
+	if g.idom[body] != loop || g.idom[exit] != loop || g.idom[loop] != fn.Entry {
+		t.Errorf("wrong dominators: body %v, exit %v, loop %v", g.idom[body], g.idom[exit], g.idom[loop])
+	}
+	if !g.IsLoopHeader(loop) || g.IsLoopHeader(body) {
+		t.Error("expected loop, and only loop, to be a loop header")
+	}
+	if !g.IsBackEdge(body, loop) || g.IsBackEdge(loop, body) {
+		t.Error("expected body -> loop, and only that edge, to be a back edge")
+	}
+	// The back edge doesn't make loop a merge node; dead's edge into exit
+	// doesn't count either, since dead is unreachable
+	if g.IsMergeNode(loop) || g.IsMergeNode(exit) {
+		t.Error("expected no merge nodes")
+	}
+	if blocks := g.Loop(loop); len(blocks) != 2 || !blocks[loop] || !blocks[body] {
+		t.Errorf("expected the loop to be loop and body, got %v", blocks)
+	}
+	if blocks := g.Loop(body); len(blocks) != 0 {
+		t.Errorf("expected body to head no loop, got %v", blocks)
+	}
+}
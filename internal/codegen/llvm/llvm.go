@@ -0,0 +1,784 @@
+// Package llvm translates an IR module into LLVM IR text (.ll), which
+// llvm-as assembles, lli runs and clang compiles to a native executable.
+//
+// WHY LLVM:
+// The other backends stop at a C file, a WebAssembly module or assembly
+// that the rest of the toolchain takes as it is. LLVM IR goes to LLVM's own
+// optimizer and to every target it supports, so the code this backend
+// writes can stay simple: whatever is slow about it, opt removes.
+//
+// DESIGN CHOICE: The IR's non-SSA locals map to allocas that mem2reg
+// promotes, the way clang handles C locals, rather than this backend
+// building SSA itself. Every parameter and variable gets a stack slot in
+// the function's first block, each read of it is a load and each write a
+// store. Temporaries are already in SSA form - verified to be defined once,
+// before every use (see ir.Module.Verify) - so they become LLVM registers
+// of the same name, and a phi of the IR becomes an LLVM phi.
+//
+// EXAMPLE: x = x + 1, with x a local
+//
+//	%.3 = load i64, ptr %x.1.addr
+//	%t4 = add i64 %.3, 1
+//	store i64 %t4, ptr %x.1.addr
+//
+// NAMES: functions are @fn.<name> and globals @g.<name>, so neither can
+// collide with each other, with the C library or with @main, which calls
+// @fn.main and returns its result as the exit status. The qualified names
+// of imported packages are quoted (@"fn.shapes/circle.Area"). The runtime's
+// own functions and constants start with "rt.".
+//
+// SUPPORTED: int (every width, held in an i64 the way the IR computes it),
+// bool (i1), float (double), char (i32), string (a pointer to a constant
+// C string) and pointers, with opaque pointers (ptr), which LLVM 15 and
+// later read by default and LLVM 14 with -opaque-pointers. print and
+// println of ints, bools and strings call printf. Structs, arrays and the
+// instructions that address into them are reported as "not yet supported
+// in LLVM backend".
+//
+// Arithmetic follows the language, not LLVM: adds and multiplies wrap, so
+// they carry no nsw flag that would make overflow undefined, and
+// division, remainder and shifts - undefined in LLVM for a zero divisor,
+// MinInt / -1 and shift counts of 64 or more - call small runtime
+// functions that check their operands (see runtime.go). Runtime errors
+// print to stderr and exit with status 2, like `compiler run`.
+package llvm
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/hassan/compiler/internal/ir"
+	"github.com/hassan/compiler/internal/semantic/types"
+)
+
+// generator holds the state of one translation.
+type generator struct {
+	module *ir.Module
+	text   strings.Builder
+
+	// errors accumulates constructs the backend can't translate
+	errors []error
+
+	// globals is the set of module-level values
+	globals map[*ir.Value]bool
+
+	// functions are the module's functions, by name, for the parameter
+	// types of a call
+	functions map[string]*ir.Function
+
+	// strings pools the string constants, by value, as private globals
+	strings     map[string]string
+	stringOrder []string
+
+	// runtime records which runtime functions the code calls
+	runtime map[string]bool
+
+	// Per-function state
+
+	fn *ir.Function
+
+	// homes are the stack slots of the parameters and variables
+	homes map[*ir.Value]string
+
+	// addresses are the values an Alloca defined: the value is the slot
+	// itself, a pointer, rather than something held in one
+	addresses map[*ir.Value]bool
+
+	// aliases are the temporaries a Copy defined from a constant or another
+	// temporary, which LLVM has no instruction for: each use of one is a
+	// use of the value it copies
+	aliases map[*ir.Value]string
+
+	// blockIndex is each block's position in the function
+	blockIndex map[*ir.BasicBlock]int
+
+	// phiLoads are the variables a phi takes from each predecessor, which
+	// the predecessor loads just before its terminator
+	phiLoads map[*ir.BasicBlock][]phiLoad
+
+	// scratch numbers the registers of loads and other intermediate values
+	scratch int
+}
+
+// phiLoad is a variable loaded into register at the end of a block, for a
+// phi in one of its successors.
+type phiLoad struct {
+	value    *ir.Value
+	register string
+}
+
+// Generate translates module into LLVM IR text.
+//
+// The returned errors name each construct that could not be translated; the
+// text is only usable when there are none.
+func Generate(module *ir.Module) (string, []error) {
+	g := &generator{
+		module:    module,
+		globals:   make(map[*ir.Value]bool),
+		functions: make(map[string]*ir.Function),
+		strings:   make(map[string]string),
+		runtime:   make(map[string]bool),
+	}
+	for _, global := range module.Globals {
+		g.globals[global] = true
+	}
+	for _, fn := range module.Functions {
+		g.functions[fn.Name] = fn
+	}
+
+	for _, fn := range module.Functions {
+		g.function(fn)
+	}
+	g.mainWrapper()
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("; ModuleID = '%s'\n", module.Name))
+	sb.WriteString(fmt.Sprintf("source_filename = \"%s\"\n", module.Name))
+
+	// Globals are written before the string constants, which their
+	// initializers may add to
+	if len(module.Globals) > 0 {
+		sb.WriteString("\n")
+		for _, global := range module.Globals {
+			llvmType := g.typeOf(global.Type, "global "+global.Name)
+			initial := g.zero(global.Type)
+			if global.Initializer != nil {
+				initial = g.constant(global.Initializer)
+			}
+			sb.WriteString(fmt.Sprintf("%s = internal global %s %s\n", globalName(global), llvmType, initial))
+		}
+	}
+
+	if len(g.stringOrder) > 0 {
+		sb.WriteString("\n")
+		for _, s := range g.stringOrder {
+			sb.WriteString(fmt.Sprintf("%s = private unnamed_addr constant [%d x i8] c\"%s\\00\"\n", g.strings[s], len(s)+1, escape(s)))
+		}
+	}
+
+	sb.WriteString(g.text.String())
+	sb.WriteString(g.runtimeDefinitions())
+
+	return sb.String(), g.errors
+}
+
+// unsupported records a construct the backend can't translate yet.
+func (g *generator) unsupported(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	if g.fn != nil {
+		msg = fmt.Sprintf("in function %s: %s", g.fn.Name, msg)
+	}
+	g.errors = append(g.errors, fmt.Errorf("%s not yet supported in LLVM backend", msg))
+}
+
+// typeOf returns the LLVM type of t, reporting a type that has none yet.
+// what describes the value for the error message.
+func (g *generator) typeOf(t types.Type, what string) string {
+	llvmType, ok := llvmType(t)
+	if !ok {
+		g.unsupported("%s of type %s is", what, t)
+	}
+	return llvmType
+}
+
+// llvmType returns the LLVM spelling of a scalar type.
+func llvmType(t types.Type) (string, bool) {
+	switch types.Underlying(t).(type) {
+	case *types.IntType:
+		return "i64", true
+	case *types.FloatType:
+		return "double", true
+	case *types.BoolType:
+		return "i1", true
+	case *types.CharType:
+		return "i32", true
+	case *types.StringType, *types.PointerType, *types.NilType:
+		return "ptr", true
+	case *types.VoidType:
+		return "void", true
+	default:
+		return "void", false
+	}
+}
+
+// zero returns the zero value of t as an LLVM constant.
+func (g *generator) zero(t types.Type) string {
+	switch types.Underlying(t).(type) {
+	case *types.FloatType:
+		return "0.0"
+	case *types.BoolType:
+		return "false"
+	case *types.StringType:
+		return g.stringConstant("")
+	case *types.PointerType, *types.NilType:
+		return "null"
+	default:
+		return "0"
+	}
+}
+
+// emit writes one instruction.
+func (g *generator) emit(format string, args ...interface{}) {
+	g.text.WriteString("  ")
+	g.text.WriteString(fmt.Sprintf(format, args...))
+	g.text.WriteString("\n")
+}
+
+// newRegister returns a fresh register for an intermediate value.
+func (g *generator) newRegister() string {
+	g.scratch++
+	return fmt.Sprintf("%%.%d", g.scratch)
+}
+
+// Names
+
+// quoteName returns name as an LLVM identifier after sigil: as it is when
+// it only has the characters an unquoted identifier may, quoted otherwise.
+func quoteName(sigil, name string) string {
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '.' || c == '_' || c == '$' || c == '-' ||
+			i > 0 && c >= '0' && c <= '9') {
+			return sigil + "\"" + escape(name) + "\""
+		}
+	}
+	return sigil + name
+}
+
+func functionName(name string) string {
+	return quoteName("@", "fn."+name)
+}
+
+func globalName(v *ir.Value) string {
+	return quoteName("@", "g."+v.Name)
+}
+
+// valueName returns the register of a parameter or temporary, or the slot
+// of an Alloca: the name the IR prints it with ("x.0", "t3").
+func valueName(v *ir.Value) string {
+	switch {
+	case v.Kind == ir.ValueTemporary:
+		return fmt.Sprintf("%%t%d", v.ID)
+	case v.Name == "" && v.Kind == ir.ValueParameter:
+		return fmt.Sprintf("%%param%d", v.ID)
+	case v.Name == "":
+		return fmt.Sprintf("%%v%d", v.ID)
+	default:
+		return quoteName("%", fmt.Sprintf("%s.%d", v.Name, v.ID))
+	}
+}
+
+// blockLabel names a block. IR labels repeat within a function, so the
+// block's position makes the LLVM label unique.
+func (g *generator) blockLabel(block *ir.BasicBlock) string {
+	return quoteName("%", fmt.Sprintf("%s.%d", block.Label, g.blockIndex[block]))
+}
+
+// stringConstant returns the global holding a string constant.
+func (g *generator) stringConstant(s string) string {
+	if name, ok := g.strings[s]; ok {
+		return name
+	}
+	name := fmt.Sprintf("@.str.%d", len(g.stringOrder))
+	g.strings[s] = name
+	g.stringOrder = append(g.stringOrder, s)
+	return name
+}
+
+// escape returns s as the contents of an LLVM c"..." string. Bytes outside
+// printable ASCII, quotes and backslashes are written as \XX hex escapes.
+func escape(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch b := s[i]; {
+		case b == '"' || b == '\\' || b < 0x20 || b >= 0x7f:
+			sb.WriteString(fmt.Sprintf("\\%02X", b))
+		default:
+			sb.WriteByte(b)
+		}
+	}
+	return sb.String()
+}
+
+// Functions
+
+// function translates one function.
+//
+// Its first block, "allocas", holds the slot of every parameter and
+// variable, zeroed or holding the argument, then jumps to the IR's entry
+// block. LLVM wants both the allocas in the first block, where mem2reg
+// looks for them, and a first block no branch goes back to, which the IR's
+// entry need not be once the optimizer has merged blocks into it.
+func (g *generator) function(fn *ir.Function) {
+	g.fn = fn
+	g.homes = make(map[*ir.Value]string)
+	g.addresses = make(map[*ir.Value]bool)
+	g.aliases = make(map[*ir.Value]string)
+	g.blockIndex = make(map[*ir.BasicBlock]int)
+	g.phiLoads = make(map[*ir.BasicBlock][]phiLoad)
+	g.scratch = 0
+	for i, block := range fn.Blocks {
+		g.blockIndex[block] = i
+	}
+	defer func() { g.fn = nil }()
+
+	returnType := g.typeOf(fn.ReturnType, "result")
+	params := make([]string, len(fn.Parameters))
+	for i, param := range fn.Parameters {
+		params[i] = g.typeOf(param.Type, "parameter "+param.Name) + " " + valueName(param)
+	}
+	g.text.WriteString(fmt.Sprintf("\ndefine %s %s(%s) {\n", returnType, functionName(fn.Name), strings.Join(params, ", ")))
+
+	g.text.WriteString("allocas:\n")
+	var homes []*ir.Value
+	addHome := func(v *ir.Value) {
+		if v == nil || g.globals[v] || g.addresses[v] || g.homes[v] != "" {
+			return
+		}
+		if v.Kind != ir.ValueVariable && v.Kind != ir.ValueParameter || v.ID == -1 {
+			return
+		}
+		g.homes[v] = quoteName("%", strings.TrimPrefix(valueName(v), "%")+".addr")
+		homes = append(homes, v)
+	}
+	for _, param := range fn.Parameters {
+		addHome(param)
+	}
+	for _, block := range fn.Blocks {
+		for _, instr := range block.Instructions {
+			if alloca, ok := instr.(*ir.Alloca); ok {
+				g.addresses[alloca.Dest] = true
+				g.emit("%s = alloca %s", valueName(alloca.Dest), g.typeOf(alloca.Type, "value "+alloca.Dest.String()))
+				continue
+			}
+			addHome(instr.Result())
+			for _, operand := range instr.Operands() {
+				addHome(operand)
+			}
+			if phi, ok := instr.(*ir.Phi); ok {
+				g.collectPhiLoads(phi)
+			}
+		}
+	}
+	for _, v := range homes {
+		llvmType := g.typeOf(v.Type, "value "+v.String())
+		g.emit("%s = alloca %s", g.homes[v], llvmType)
+		initial := g.zero(v.Type)
+		if v.Kind == ir.ValueParameter {
+			initial = valueName(v)
+		}
+		g.emit("store %s %s, ptr %s", llvmType, initial, g.homes[v])
+	}
+	g.emit("br label %s", g.blockLabel(fn.Entry))
+
+	for _, block := range fn.Blocks {
+		g.text.WriteString(strings.TrimPrefix(g.blockLabel(block), "%") + ":\n")
+		for _, instr := range block.Instructions {
+			g.instruction(block, instr)
+		}
+	}
+	g.text.WriteString("}\n")
+}
+
+// collectPhiLoads records the incoming values of phi that are variables, to
+// be loaded at the end of the block they come from: a phi can only name a
+// register or a constant.
+func (g *generator) collectPhiLoads(phi *ir.Phi) {
+	for _, incoming := range phi.Incomig {
+		v := incoming.Value
+		if v.IsConstant() || v.Kind == ir.ValueTemporary {
+			continue
+		}
+		register := quoteName("%", fmt.Sprintf(".phi.%d.%d", phi.Dest.ID, g.blockIndex[incoming.Block]))
+		g.phiLoads[incoming.Block] = append(g.phiLoads[incoming.Block], phiLoad{v, register})
+	}
+}
+
+// mainWrapper defines the process entry point called by the C runtime,
+// which returns main's result (an int) as the exit status. It is omitted
+// for a module without main (a library package).
+func (g *generator) mainWrapper() {
+	fn := g.functions["main"]
+	if fn == nil {
+		return
+	}
+	if len(fn.Parameters) > 0 {
+		g.unsupported("main with parameters is")
+	}
+	g.text.WriteString("\ndefine i32 @main() {\nentry:\n")
+	if types.IsIntegerType(fn.ReturnType) {
+		g.emit("%%result = call i64 %s()", functionName(fn.Name))
+		g.emit("%%status = trunc i64 %%result to i32")
+		g.emit("ret i32 %%status")
+	} else {
+		g.emit("call %s %s()", g.typeOf(fn.ReturnType, "result of main"), functionName(fn.Name))
+		g.emit("ret i32 0")
+	}
+	g.text.WriteString("}\n")
+}
+
+// Operands
+
+// operand returns v as an LLVM operand, loading it first if it lives in a
+// slot.
+func (g *generator) operand(v *ir.Value) string {
+	switch {
+	case v.IsConstant():
+		return g.constant(v)
+	case g.addresses[v]:
+		return valueName(v)
+	case v.Kind == ir.ValueTemporary:
+		if alias, ok := g.aliases[v]; ok {
+			return alias
+		}
+		return valueName(v)
+	case v.ID == -1 && v.Kind == ir.ValueVariable && !g.globals[v]:
+		g.unsupported("function value %s is", v.Name)
+		return "undef"
+	}
+	register := g.newRegister()
+	g.emit("%s = load %s, ptr %s", register, g.typeOf(v.Type, "value "+v.String()), g.home(v))
+	return register
+}
+
+// home returns the slot of a parameter or variable: its alloca, or the
+// global itself.
+func (g *generator) home(v *ir.Value) string {
+	if g.globals[v] {
+		return globalName(v)
+	}
+	return g.homes[v]
+}
+
+// constant returns the LLVM spelling of a constant.
+func (g *generator) constant(v *ir.Value) string {
+	switch c := v.Constant.(type) {
+	case int64:
+		return fmt.Sprint(c)
+	case rune:
+		return fmt.Sprint(c)
+	case bool:
+		return fmt.Sprint(c)
+	case float64:
+		// The bits in hex: LLVM only takes a decimal double it can
+		// represent exactly
+		return fmt.Sprintf("0x%016X", math.Float64bits(c))
+	case string:
+		return g.stringConstant(c)
+	case nil:
+		return "null"
+	default:
+		g.unsupported("constant %v of type %s is", c, v.Type)
+		return "undef"
+	}
+}
+
+// operandType returns the LLVM type of v as an operand: ptr for the slot of
+// an Alloca, which the IR types with what it holds.
+func (g *generator) operandType(v *ir.Value) string {
+	if g.addresses[v] {
+		return "ptr"
+	}
+	return g.typeOf(v.Type, "value "+v.String())
+}
+
+// define gives dest the result of an instruction: text, the instruction
+// without its "%t3 =", is assigned to dest's register if dest is a
+// temporary and stored in its slot otherwise.
+func (g *generator) define(dest *ir.Value, text string) {
+	if dest.Kind == ir.ValueTemporary {
+		g.emit("%s = %s", valueName(dest), text)
+		return
+	}
+	register := g.newRegister()
+	g.emit("%s = %s", register, text)
+	g.assign(dest, register)
+}
+
+// assign stores operand, an LLVM operand, into dest's slot.
+func (g *generator) assign(dest *ir.Value, operand string) {
+	g.emit("store %s %s, ptr %s", g.typeOf(dest.Type, "value "+dest.String()), operand, g.home(dest))
+}
+
+// Instructions
+
+// instruction emits the code for one instruction of block.
+func (g *generator) instruction(block *ir.BasicBlock, instr ir.Instruction) {
+	switch i := instr.(type) {
+	case *ir.BinaryOp:
+		g.binary(i)
+
+	case *ir.UnaryOp:
+		g.unary(i)
+
+	case *ir.Copy:
+		g.copy(i)
+
+	case *ir.Call:
+		g.call(i)
+
+	case *ir.Phi:
+		llvmType := g.typeOf(i.Dest.Type, "value "+i.Dest.String())
+		incoming := make([]string, len(i.Incomig))
+		for n, in := range i.Incomig {
+			value := ""
+			for _, load := range g.phiLoads[in.Block] {
+				if load.value == in.Value {
+					value = load.register
+				}
+			}
+			if value == "" {
+				value = g.operand(in.Value)
+			}
+			incoming[n] = fmt.Sprintf("[ %s, %s ]", value, g.blockLabel(in.Block))
+		}
+		g.define(i.Dest, fmt.Sprintf("phi %s %s", llvmType, strings.Join(incoming, ", ")))
+
+	case *ir.Alloca:
+		// The slot itself is in the first block; here it is only zeroed,
+		// as it is each time the declaration runs
+		g.emit("store %s %s, ptr %s", g.typeOf(i.Type, "value "+i.Dest.String()), g.zero(i.Type), valueName(i.Dest))
+
+	case *ir.Load:
+		address := g.address(i.Address)
+		g.define(i.Dest, fmt.Sprintf("load %s, ptr %s", g.typeOf(i.Dest.Type, "value "+i.Dest.String()), address))
+
+	case *ir.Store:
+		address := g.address(i.Address)
+		value := g.operand(i.Value)
+		g.emit("store %s %s, ptr %s", g.operandType(i.Value), value, address)
+
+	case *ir.Jump:
+		g.loadForPhis(block)
+		g.emit("br label %s", g.blockLabel(i.Target))
+
+	case *ir.Branch:
+		condition := g.operand(i.Condition)
+		g.loadForPhis(block)
+		g.emit("br i1 %s, label %s, label %s", condition, g.blockLabel(i.TrueBlock), g.blockLabel(i.FalseBlock))
+
+	case *ir.Return:
+		if i.Value == nil {
+			g.emit("ret void")
+			break
+		}
+		value := g.operand(i.Value)
+		g.emit("ret %s %s", g.typeOf(g.fn.ReturnType, "result"), value)
+
+	case *ir.GetElementPtr, *ir.GetFieldPtr:
+		g.unsupported("memory access (%s) is", instr)
+	default:
+		g.unsupported("instruction %s is", instr)
+	}
+}
+
+// loadForPhis loads, at the end of block, the variables that phis in its
+// successors take from it.
+func (g *generator) loadForPhis(block *ir.BasicBlock) {
+	for _, load := range g.phiLoads[block] {
+		g.emit("%s = load %s, ptr %s", load.register, g.typeOf(load.value.Type, "value "+load.value.String()), g.home(load.value))
+	}
+}
+
+// address returns the pointer a Load or Store goes through: the slot of an
+// Alloca, or a pointer of the program's, checked for nil first (a pointer
+// never assigned is nil; the interpreter reports dereferencing it as a
+// runtime error too).
+func (g *generator) address(v *ir.Value) string {
+	pointer := g.operand(v)
+	if _, ok := types.Underlying(v.Type).(*types.PointerType); ok && !g.addresses[v] {
+		g.runtime["rt.deref"] = true
+		g.emit("call void @rt.deref(ptr %s)", pointer)
+	}
+	return pointer
+}
+
+// copy emits a Copy. Into a variable it is a store; into a temporary, which
+// LLVM has no copy instruction for, it is an alias of a constant or a
+// register, or a load of a variable straight into the temporary's register.
+func (g *generator) copy(c *ir.Copy) {
+	switch {
+	case c.Dest.Kind != ir.ValueTemporary:
+		g.assign(c.Dest, g.operand(c.Value))
+	case c.Value.IsConstant() || c.Value.Kind == ir.ValueTemporary || g.addresses[c.Value] || g.home(c.Value) == "":
+		g.aliases[c.Dest] = g.operand(c.Value)
+	default:
+		g.emit("%s = load %s, ptr %s", valueName(c.Dest), g.operandType(c.Value), g.home(c.Value))
+	}
+}
+
+// integerOps are the integer operators computed by a single instruction,
+// and floatOps the float ones. Division, remainder and shifts call the
+// runtime instead (see runtimeOps).
+var integerOps = map[ir.BinaryOperator]string{
+	ir.OpAdd: "add", ir.OpSub: "sub", ir.OpMul: "mul",
+	ir.OpBitAnd: "and", ir.OpBitOr: "or", ir.OpBitXor: "xor",
+}
+
+var floatOps = map[ir.BinaryOperator]string{
+	ir.OpAdd: "fadd", ir.OpSub: "fsub", ir.OpMul: "fmul", ir.OpDiv: "fdiv",
+}
+
+// The icmp and fcmp conditions of each comparison: signed, unsigned (for
+// uint64) and ordered (false when either side is NaN, as in the
+// interpreter, except != which is then true).
+var signedConditions = map[ir.BinaryOperator]string{
+	ir.OpEq: "eq", ir.OpNeq: "ne", ir.OpLt: "slt", ir.OpLe: "sle", ir.OpGt: "sgt", ir.OpGe: "sge",
+}
+
+var unsignedConditions = map[ir.BinaryOperator]string{
+	ir.OpEq: "eq", ir.OpNeq: "ne", ir.OpLt: "ult", ir.OpLe: "ule", ir.OpGt: "ugt", ir.OpGe: "uge",
+}
+
+var floatConditions = map[ir.BinaryOperator]string{
+	ir.OpEq: "oeq", ir.OpNeq: "une", ir.OpLt: "olt", ir.OpLe: "ole", ir.OpGt: "ogt", ir.OpGe: "oge",
+}
+
+// runtimeOps are the runtime functions computing the integer operators
+// LLVM leaves undefined for some operands, signed and unsigned.
+var runtimeOps = map[ir.BinaryOperator][2]string{
+	ir.OpDiv: {"rt.sdiv", "rt.udiv"},
+	ir.OpMod: {"rt.srem", "rt.urem"},
+	ir.OpShl: {"rt.shl", "rt.shl"},
+	ir.OpShr: {"rt.ashr", "rt.lshr"},
+}
+
+// binary emits a binary operation.
+func (g *generator) binary(b *ir.BinaryOp) {
+	var text string
+	switch types.Underlying(b.Left.Type).(type) {
+	case *types.IntType, *types.CharType:
+		llvmType := g.operandType(b.Left)
+		left, right := g.operand(b.Left), g.operand(b.Right)
+		unsigned := 0
+		conditions := signedConditions
+		if types.IsUnsigned64(b.Left.Type) {
+			unsigned = 1
+			conditions = unsignedConditions
+		}
+		if op, ok := integerOps[b.Op]; ok {
+			text = fmt.Sprintf("%s %s %s, %s", op, llvmType, left, right)
+		} else if cond, ok := conditions[b.Op]; ok {
+			text = fmt.Sprintf("icmp %s %s %s, %s", cond, llvmType, left, right)
+		} else if fns, ok := runtimeOps[b.Op]; ok && llvmType == "i64" {
+			// The shift count is an int, whatever the type shifted
+			g.runtime[fns[unsigned]] = true
+			text = fmt.Sprintf("call i64 @%s(i64 %s, i64 %s)", fns[unsigned], left, right)
+		}
+
+	case *types.FloatType:
+		left, right := g.operand(b.Left), g.operand(b.Right)
+		if op, ok := floatOps[b.Op]; ok {
+			text = fmt.Sprintf("%s double %s, %s", op, left, right)
+		} else if cond, ok := floatConditions[b.Op]; ok {
+			text = fmt.Sprintf("fcmp %s double %s, %s", cond, left, right)
+		}
+
+	case *types.BoolType:
+		left, right := g.operand(b.Left), g.operand(b.Right)
+		switch b.Op {
+		case ir.OpAnd:
+			text = fmt.Sprintf("and i1 %s, %s", left, right)
+		case ir.OpOr:
+			text = fmt.Sprintf("or i1 %s, %s", left, right)
+		case ir.OpEq, ir.OpNeq:
+			text = fmt.Sprintf("icmp %s i1 %s, %s", signedConditions[b.Op], left, right)
+		}
+	}
+
+	if text == "" {
+		g.unsupported("operator %s on %s is", b.Op, b.Left.Type)
+		return
+	}
+	g.define(b.Dest, text)
+}
+
+// unary emits a unary operation.
+func (g *generator) unary(u *ir.UnaryOp) {
+	operand := g.operand(u.Operand)
+	var text string
+	switch {
+	case u.Op == ir.OpNeg && types.IsIntegerType(u.Operand.Type):
+		text = fmt.Sprintf("sub i64 0, %s", operand)
+	case u.Op == ir.OpNeg && types.IsNumeric(u.Operand.Type):
+		text = fmt.Sprintf("fneg double %s", operand)
+	case u.Op == ir.OpBitNot && types.IsIntegerType(u.Operand.Type):
+		text = fmt.Sprintf("xor i64 %s, -1", operand)
+	case u.Op == ir.OpNot && types.IsBooleanType(u.Operand.Type):
+		text = fmt.Sprintf("xor i1 %s, true", operand)
+	default:
+		g.unsupported("operator %s on %s is", u.Op, u.Operand.Type)
+		return
+	}
+	g.define(u.Dest, text)
+}
+
+// call emits a call to a function of the module or a builtin. Arguments
+// take the types of the callee's parameters, so that the slot of a
+// variable passed for a *T parameter goes as the pointer it is.
+func (g *generator) call(call *ir.Call) {
+	if name := call.Builtin(); name != "" {
+		g.builtin(name, call.Args)
+		return
+	}
+
+	callee := g.functions[call.Function.Name]
+	args := make([]string, len(call.Args))
+	for i, arg := range call.Args {
+		llvmType := g.operandType(arg)
+		if callee != nil && i < len(callee.Parameters) {
+			llvmType = g.typeOf(callee.Parameters[i].Type, "argument "+arg.String())
+		}
+		args[i] = llvmType + " " + g.operand(arg)
+	}
+
+	returnType := "void"
+	switch {
+	case callee != nil:
+		returnType = g.typeOf(callee.ReturnType, "result of "+callee.Name)
+	case call.Dest != nil:
+		returnType = g.typeOf(call.Dest.Type, "value "+call.Dest.String())
+	}
+	text := fmt.Sprintf("call %s %s(%s)", returnType, functionName(call.Function.Name), strings.Join(args, ", "))
+	if call.Dest == nil {
+		g.emit("%s", text)
+		return
+	}
+	g.define(call.Dest, text)
+}
+
+// builtin emits print or println through printf.
+func (g *generator) builtin(name string, args []*ir.Value) {
+	if name != "print" && name != "println" {
+		g.unsupported("builtin %s is", name)
+		return
+	}
+	g.runtime["printf"] = true
+	for _, arg := range args {
+		var format, value string
+		switch arg.Type.(type) {
+		case *types.IntType:
+			format = "%ld"
+			if types.IsUnsigned64(arg.Type) {
+				format = "%lu"
+			}
+			value = "i64 " + g.operand(arg)
+		case *types.StringType:
+			format = "%s"
+			value = "ptr " + g.operand(arg)
+		case *types.BoolType:
+			format = "%s"
+			text := g.newRegister()
+			g.emit("%s = select i1 %s, ptr %s, ptr %s", text, g.operand(arg), g.stringConstant("true"), g.stringConstant("false"))
+			value = "ptr " + text
+		default:
+			g.unsupported("%s of a %s is", name, arg.Type)
+			continue
+		}
+		g.emit("call i32 (ptr, ...) @printf(ptr %s, %s)", g.stringConstant(format), value)
+	}
+	if name == "println" {
+		g.emit("call i32 @putchar(i32 10)")
+	}
+}
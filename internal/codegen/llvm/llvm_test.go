@@ -0,0 +1,259 @@
+package llvm
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/hassan/compiler/internal/interp"
+	"github.com/hassan/compiler/internal/ir"
+	"github.com/hassan/compiler/pkg/compiler"
+)
+
+// update rewrites golden files with the current output instead of comparing.
+var update = flag.Bool("update", false, "update golden files")
+
+// compileSource compiles a program with the default optimizations.
+func compileSource(t *testing.T, source []byte, filename string) *ir.Module {
+	t.Helper()
+	result, err := compiler.Compile(source, filename, compiler.Options{OptLevel: 1})
+	if err != nil {
+		t.Fatalf("compiling %s: %v", filename, err)
+	}
+	return result.Module
+}
+
+// generate translates a module, failing the test on any backend error.
+func generate(t *testing.T, module *ir.Module) string {
+	t.Helper()
+	text, errs := Generate(module)
+	for _, err := range errs {
+		t.Error(err)
+	}
+	return text
+}
+
+// TestGenerate_Golden compares the LLVM IR generated for every testdata
+// program that has a .golden file.
+func TestGenerate_Golden(t *testing.T) {
+	goldens, err := filepath.Glob("testdata/*.golden")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(goldens) == 0 {
+		t.Fatal("no golden files found")
+	}
+
+	for _, golden := range goldens {
+		path := strings.TrimSuffix(golden, ".golden") + ".src"
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			source, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := generate(t, compileSource(t, source, path))
+
+			if *update {
+				if err := os.WriteFile(golden, []byte(got), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != string(want) {
+				t.Errorf("output differs from %s (run with -update to accept):\ngot:\n%s\nwant:\n%s", golden, got, want)
+			}
+		})
+	}
+}
+
+// lliCommand returns the command running the file at path with lli, LLVM's
+// interpreter. LLVM 14 reads opaque pointers only when asked to, and later
+// versions no longer take the flag, so it is added if a first run asks
+// for it.
+func lliCommand(lli, path string) *exec.Cmd {
+	probe, _ := exec.Command(lli, path).CombinedOutput()
+	if bytes.Contains(probe, []byte("-opaque-pointers")) {
+		return exec.Command(lli, "-opaque-pointers", path)
+	}
+	return exec.Command(lli, path)
+}
+
+// TestGenerate_Run runs every testdata program with lli and checks that it
+// prints what the interpreter prints and exits with main's result. Skipped
+// where LLVM isn't installed.
+func TestGenerate_Run(t *testing.T) {
+	lli, err := exec.LookPath("lli")
+	if err != nil {
+		t.Skip("no lli found")
+	}
+	sources, err := filepath.Glob("testdata/*.src")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range sources {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			source, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			module := compileSource(t, source, path)
+			ll := filepath.Join(t.TempDir(), "main.ll")
+			if err := os.WriteFile(ll, []byte(generate(t, module)), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			// What the interpreter says the program does
+			var wantStdout bytes.Buffer
+			machine := interp.New(module)
+			machine.Stdout = &wantStdout
+			result, runErr := machine.Run("main", nil)
+			wantCode := 0
+			if code, ok := result.(int64); ok {
+				wantCode = int(uint8(code)) // Exit statuses are truncated to a byte
+			}
+			if runErr != nil {
+				wantCode = 2
+			}
+
+			var stdout, stderr bytes.Buffer
+			run := lliCommand(lli, ll)
+			run.Stdout = &stdout
+			run.Stderr = &stderr
+			code := 0
+			if err := run.Run(); err != nil {
+				var exitErr *exec.ExitError
+				if !errors.As(err, &exitErr) {
+					t.Fatal(err)
+				}
+				code = exitErr.ExitCode()
+			}
+
+			if stdout.String() != wantStdout.String() {
+				t.Errorf("stdout = %q, interpreter printed %q (stderr %q)", stdout.String(), wantStdout.String(), stderr.String())
+			}
+			if code != wantCode {
+				t.Errorf("exit code = %d, want %d (stderr %q)", code, wantCode, stderr.String())
+			}
+			if runErr != nil && !strings.HasPrefix(stderr.String(), "runtime error: ") {
+				t.Errorf("stderr = %q, want a runtime error (interpreter: %v)", stderr.String(), runErr)
+			}
+		})
+	}
+}
+
+// TestGenerate_Instructions checks the shape of what each kind of
+// instruction lowers to, unoptimized so that nothing is folded away.
+func TestGenerate_Instructions(t *testing.T) {
+	source := `package main
+
+var total int;
+
+func scale(x float, n int) float {
+    total = total + n;
+    if (n > 0 && x >= 1.5) {
+        return x * 2.0;
+    }
+    return -x;
+}
+
+func main() {
+    var y float = scale(3.0, 4 / 2);
+    println(total);
+}
+`
+	result, err := compiler.Compile([]byte(source), "input.src", compiler.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := generate(t, result.Module)
+
+	for _, want := range []string{
+		`^; ModuleID = 'main'$`,
+		`^@g\.total = internal global i64 0$`,
+		`^define double @fn\.scale\(double %x\.0, i64 %n\.1\) \{$`,
+		`^  %x\.0\.addr = alloca double$`,
+		`^  store double %x\.0, ptr %x\.0\.addr$`,
+		`^  %t\d+ = add i64 %\.\d+, %\.\d+$`,
+		`^  store i64 %t\d+, ptr @g\.total$`,
+		`^  %t\d+ = icmp sgt i64 %\.\d+, 0$`,
+		`^  %t\d+ = fcmp oge double %\.\d+, 0x3FF8000000000000$`,
+		`^  br i1 %t\d+, label %and\.rhs\.\d+, label %and\.end\.\d+$`,
+		`^  %t\d+ = phi i1 \[ false, %entry\.0 \], \[ %t\d+, %and\.rhs\.\d+ \]$`,
+		`^  %t\d+ = fmul double %\.\d+, 0x4000000000000000$`,
+		`^  %t\d+ = fneg double %\.\d+$`,
+		`^  ret double %t\d+$`,
+		`^define void @fn\.main\(\) \{$`,
+		`^  %t\d+ = call i64 @rt\.sdiv\(i64 4, i64 2\)$`,
+		`^  %t\d+ = call double @fn\.scale\(double 0x4008000000000000, i64 %t\d+\)$`,
+		`^  call i32 \(ptr, \.\.\.\) @printf\(ptr @\.str\.\d+, i64 %\.\d+\)$`,
+		`^  ret void$`,
+		`^define i32 @main\(\) \{$`,
+		`^define internal i64 @rt\.sdiv\(i64 %a, i64 %b\) \{$`,
+		`^declare i32 @printf\(ptr, \.\.\.\)$`,
+	} {
+		if !regexp.MustCompile("(?m)" + want).MatchString(got) {
+			t.Errorf("expected a line matching %s, got:\n%s", want, got)
+		}
+	}
+}
+
+func TestGenerate_Unsupported(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{
+			name:   "struct parameter",
+			source: "package main\n\nstruct Point {\n    x int;\n}\n\nfunc getX(p Point) int {\n    return p.x;\n}\n",
+			want:   "in function getX: parameter p of type struct Point is not yet supported in LLVM backend",
+		},
+		{
+			name:   "string comparison",
+			source: "package main\n\nfunc less(a string, b string) bool {\n    return a < b;\n}\n",
+			want:   "in function less: operator < on string is not yet supported in LLVM backend",
+		},
+		{
+			name:   "print a float",
+			source: "package main\n\nfunc main() {\n    print(1.5);\n}\n",
+			want:   "in function main: print of a float is not yet supported in LLVM backend",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, errs := Generate(compileSource(t, []byte(tt.source), "input.src"))
+			for _, err := range errs {
+				if err.Error() == tt.want {
+					return
+				}
+			}
+			t.Errorf("expected error %q, got %v", tt.want, errs)
+		})
+	}
+}
+
+func TestEscape(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"hi", `hi`},
+		{"say \"hi\"\n", `say \22hi\22\0A`},
+		{`C:\dir`, `C:\5Cdir`},
+		{"é", `\C3\A9`},
+	}
+	for _, tt := range tests {
+		if got := escape(tt.in); got != tt.want {
+			t.Errorf("escape(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package llvm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The runtime: LLVM functions for the operations whose LLVM instruction is
+// undefined for some operands, each written once and emitted only into
+// modules that call it.
+//
+// DESIGN CHOICE: Functions rather than the check inlined at every
+// division. An inlined check splits the block it is in, and a phi after it
+// would then have to name the new block its value comes from rather than
+// the IR's, so every instruction could no longer lower on its own. LLVM's
+// inliner puts the checks back in place at -O1 and up, and drops the ones
+// whose operands are constants it can check itself.
+//
+// Runtime errors go through rt.fail, which flushes stdout, prints the
+// message to stderr and exits with status 2, like the interpreter's
+// `compiler run`.
+
+// runtimeFunction is the LLVM text of one runtime function, and the
+// runtime functions and messages it uses in turn.
+type runtimeFunction struct {
+	name string
+	uses []string
+	text string
+}
+
+// runtimeFunctions are the runtime's functions, in the order they are
+// emitted.
+var runtimeFunctions = []runtimeFunction{
+	{"rt.sdiv", []string{"rt.fail", "rt.divzero"}, `define internal i64 @rt.sdiv(i64 %a, i64 %b) {
+entry:
+  %zero = icmp eq i64 %b, 0
+  br i1 %zero, label %fail, label %nonzero
+fail:
+  call void @rt.fail(ptr @rt.divzero, i64 0)
+  unreachable
+nonzero:
+  ; MinInt / -1 overflows sdiv; it wraps to MinInt, which is -a
+  %minus = icmp eq i64 %b, -1
+  br i1 %minus, label %negate, label %divide
+negate:
+  %negated = sub i64 0, %a
+  ret i64 %negated
+divide:
+  %quotient = sdiv i64 %a, %b
+  ret i64 %quotient
+}
+`},
+	{"rt.srem", []string{"rt.fail", "rt.divzero"}, `define internal i64 @rt.srem(i64 %a, i64 %b) {
+entry:
+  %zero = icmp eq i64 %b, 0
+  br i1 %zero, label %fail, label %nonzero
+fail:
+  call void @rt.fail(ptr @rt.divzero, i64 0)
+  unreachable
+nonzero:
+  ; Anything % -1 is 0, but MinInt % -1 overflows srem
+  %minus = icmp eq i64 %b, -1
+  br i1 %minus, label %none, label %divide
+none:
+  ret i64 0
+divide:
+  %remainder = srem i64 %a, %b
+  ret i64 %remainder
+}
+`},
+	{"rt.udiv", []string{"rt.fail", "rt.divzero"}, unsignedDivision("udiv")},
+	{"rt.urem", []string{"rt.fail", "rt.divzero"}, unsignedDivision("urem")},
+	{"rt.shl", []string{"rt.fail", "rt.negshift"}, shift("shl", "0")},
+	{"rt.ashr", []string{"rt.fail", "rt.negshift"}, shift("ashr", "%sign")},
+	{"rt.lshr", []string{"rt.fail", "rt.negshift"}, shift("lshr", "0")},
+	{"rt.deref", []string{"rt.fail", "rt.nilderef"}, `define internal void @rt.deref(ptr %p) {
+entry:
+  %null = icmp eq ptr %p, null
+  br i1 %null, label %fail, label %ok
+fail:
+  call void @rt.fail(ptr @rt.nilderef, i64 0)
+  unreachable
+ok:
+  ret void
+}
+`},
+	{"rt.fail", nil, `define internal void @rt.fail(ptr %format, i64 %arg) noreturn {
+entry:
+  call i32 @fflush(ptr null)
+  call i32 (i32, ptr, ...) @dprintf(i32 2, ptr %format, i64 %arg)
+  call void @exit(i32 2)
+  unreachable
+}
+
+declare i32 @fflush(ptr)
+declare i32 @dprintf(i32, ptr, ...)
+declare void @exit(i32) noreturn
+`},
+}
+
+// unsignedDivision returns the runtime function doing op, udiv or urem,
+// which only a zero divisor leaves undefined.
+func unsignedDivision(op string) string {
+	return fmt.Sprintf(`define internal i64 @rt.%s(i64 %%a, i64 %%b) {
+entry:
+  %%zero = icmp eq i64 %%b, 0
+  br i1 %%zero, label %%fail, label %%divide
+fail:
+  call void @rt.fail(ptr @rt.divzero, i64 0)
+  unreachable
+divide:
+  %%result = %s i64 %%a, %%b
+  ret i64 %%result
+}
+`, op, op)
+}
+
+// shift returns the runtime function doing op, shl, ashr or lshr. A count
+// of 64 or more, undefined in LLVM, shifts everything out, leaving large:
+// 0, or the sign for an arithmetic shift right.
+func shift(op, large string) string {
+	return fmt.Sprintf(`define internal i64 @rt.%s(i64 %%a, i64 %%b) {
+entry:
+  %%negative = icmp slt i64 %%b, 0
+  br i1 %%negative, label %%fail, label %%nonnegative
+fail:
+  call void @rt.fail(ptr @rt.negshift, i64 %%b)
+  unreachable
+nonnegative:
+  %%sign = ashr i64 %%a, 63
+  %%small = icmp slt i64 %%b, 64
+  br i1 %%small, label %%shift, label %%large
+large:
+  ret i64 %s
+shift:
+  %%result = %s i64 %%a, %%b
+  ret i64 %%result
+}
+`, op, large, op)
+}
+
+// runtimeMessages are the runtime's error messages, by name.
+var runtimeMessages = []struct{ name, text string }{
+	{"rt.divzero", "runtime error: integer division by zero\n"},
+	{"rt.negshift", "runtime error: negative shift amount %ld\n"},
+	{"rt.nilderef", "runtime error: nil pointer dereference\n"},
+}
+
+// runtimeDefinitions returns the runtime functions and messages the code
+// uses, and the declarations of the C library functions they call.
+func (g *generator) runtimeDefinitions() string {
+	// A function's uses are listed after it, so one pass in order finds
+	// everything that is needed
+	for _, fn := range runtimeFunctions {
+		if g.runtime[fn.name] {
+			for _, use := range fn.uses {
+				g.runtime[use] = true
+			}
+		}
+	}
+
+	var sb strings.Builder
+	for _, message := range runtimeMessages {
+		if g.runtime[message.name] {
+			sb.WriteString(fmt.Sprintf("\n@%s = private unnamed_addr constant [%d x i8] c\"%s\\00\"", message.name, len(message.text)+1, escape(message.text)))
+		}
+	}
+	if sb.Len() > 0 {
+		sb.WriteString("\n")
+	}
+	for _, fn := range runtimeFunctions {
+		if g.runtime[fn.name] {
+			sb.WriteString("\n" + fn.text)
+		}
+	}
+	if g.runtime["printf"] {
+		sb.WriteString("\ndeclare i32 @printf(ptr, ...)\ndeclare i32 @putchar(i32)\n")
+	}
+	return sb.String()
+}
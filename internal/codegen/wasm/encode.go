@@ -0,0 +1,384 @@
+package wasm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/hassan/compiler/internal/ir"
+)
+
+// Encode translates module into a WebAssembly binary module (.wasm), which
+// a runtime loads without first running wat2wasm over the text.
+//
+// DESIGN CHOICE: The binary is assembled from the text Generate produces
+// rather than lowered from the IR a second time. The text is already the
+// backend's whole translation - structured control flow, helpers, local
+// types - and every line of it is one instruction, so assembling it is a
+// table lookup per line. Two lowerings would have to be kept in step with
+// each other, and the golden files, which show the text, would say nothing
+// about the binary; as it is, they describe both.
+//
+// The module has the sections this backend needs, in the order the format
+// requires: type (the distinct signatures), function (each function's
+// signature), global, export and code. Immediates are LEB128 numbers:
+// unsigned ones, such as indices and sizes, are exactly binary.AppendUvarint's
+// encoding; signed ones, the constants, use the two's-complement form, which
+// encoding/binary's zig-zag varints are not.
+func Encode(module *ir.Module) ([]byte, []error) {
+	text, errs := Generate(module)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	code, err := assemble(text)
+	if err != nil {
+		return nil, []error{err}
+	}
+	return code, nil
+}
+
+// Section ids, value types and the other fixed bytes of the format.
+const (
+	sectionType     = 1
+	sectionFunction = 3
+	sectionGlobal   = 6
+	sectionExport   = 7
+	sectionCode     = 10
+
+	funcType   = 0x60
+	blockEmpty = 0x40
+	mutable    = 0x01
+	exportFunc = 0x00
+	opEnd      = 0x0B
+)
+
+// valueTypes are the encodings of the value types the text uses.
+var valueTypes = map[string]byte{"i32": 0x7F, "i64": 0x7E, "f64": 0x7C}
+
+// opcodes are the encodings of the instructions without immediates, and of
+// the opcode byte of those with them.
+var opcodes = map[string]byte{
+	"unreachable": 0x00, "block": 0x02, "loop": 0x03, "if": 0x04, "else": 0x05, "end": opEnd,
+	"br": 0x0C, "return": 0x0F, "call": 0x10, "drop": 0x1A,
+	"local.get": 0x20, "local.set": 0x21, "global.get": 0x23, "global.set": 0x24,
+	"i32.const": 0x41, "i64.const": 0x42, "f64.const": 0x44,
+
+	"i32.eqz": 0x45, "i32.eq": 0x46, "i32.ne": 0x47,
+	"i64.eqz": 0x50, "i64.eq": 0x51, "i64.ne": 0x52,
+	"i64.lt_s": 0x53, "i64.lt_u": 0x54, "i64.gt_s": 0x55, "i64.gt_u": 0x56,
+	"i64.le_s": 0x57, "i64.le_u": 0x58, "i64.ge_s": 0x59, "i64.ge_u": 0x5A,
+	"f64.eq": 0x61, "f64.ne": 0x62, "f64.lt": 0x63, "f64.gt": 0x64, "f64.le": 0x65, "f64.ge": 0x66,
+
+	"i32.and": 0x71, "i32.or": 0x72,
+	"i64.add": 0x7C, "i64.sub": 0x7D, "i64.mul": 0x7E, "i64.div_s": 0x7F, "i64.div_u": 0x80,
+	"i64.rem_s": 0x81, "i64.rem_u": 0x82, "i64.and": 0x83, "i64.or": 0x84, "i64.xor": 0x85,
+	"i64.shl": 0x86, "i64.shr_s": 0x87, "i64.shr_u": 0x88,
+	"f64.neg": 0x9A, "f64.add": 0xA0, "f64.sub": 0xA1, "f64.mul": 0xA2, "f64.div": 0xA3,
+}
+
+// watFunc is one function of the text: its signature, its locals after the
+// parameters, and its body lines.
+type watFunc struct {
+	name                   string
+	paramNames, paramTypes []string
+	result                 string // "" for none
+	localNames, localTypes []string
+	body                   []string
+}
+
+// signature identifies the function's type, to give each distinct one a
+// single entry in the type section.
+func (fn *watFunc) signature() string {
+	return strings.Join(fn.paramTypes, " ") + " -> " + fn.result
+}
+
+// The lines of the text that aren't instructions.
+var (
+	globalLine = regexp.MustCompile(`^\(global (\$\S+) \(mut (\w+)\) \((\w+\.const) (\S+)\)\)$`)
+	funcLine   = regexp.MustCompile(`^\(func (\$\S+)((?: \(param \$\S+ \w+\))*)(?: \(result (\w+)\))?$`)
+	paramPart  = regexp.MustCompile(`\(param (\$\S+) (\w+)\)`)
+	localLine  = regexp.MustCompile(`^\(local (\$\S+) (\w+)\)$`)
+	exportLine = regexp.MustCompile(`^\(export "([^"]*)" \(func (\$\S+)\)\)$`)
+)
+
+// assemble encodes the module text Generate produced. An error means the
+// text has something this assembler doesn't know, which is a bug in one or
+// the other.
+func assemble(text string) ([]byte, error) {
+	var globals, globalTypes, globalInits []string
+	var funcs []*watFunc
+	var exports [][2]string
+
+	var fn *watFunc
+	for n, raw := range strings.Split(text, "\n") {
+		line := strings.TrimSpace(raw)
+		switch {
+		case line == "" || strings.HasPrefix(line, ";;") || line == "(module":
+		case fn != nil && line == ")":
+			fn = nil
+		case fn != nil:
+			if m := localLine.FindStringSubmatch(line); m != nil && len(fn.body) == 0 {
+				fn.localNames = append(fn.localNames, m[1])
+				fn.localTypes = append(fn.localTypes, m[2])
+			} else {
+				fn.body = append(fn.body, line)
+			}
+		case line == ")":
+		default:
+			if m := globalLine.FindStringSubmatch(line); m != nil {
+				globals = append(globals, m[1])
+				globalTypes = append(globalTypes, m[2])
+				globalInits = append(globalInits, m[3]+" "+m[4])
+			} else if m := funcLine.FindStringSubmatch(line); m != nil {
+				fn = &watFunc{name: m[1], result: m[3]}
+				for _, param := range paramPart.FindAllStringSubmatch(m[2], -1) {
+					fn.paramNames = append(fn.paramNames, param[1])
+					fn.paramTypes = append(fn.paramTypes, param[2])
+				}
+				funcs = append(funcs, fn)
+			} else if m := exportLine.FindStringSubmatch(line); m != nil {
+				exports = append(exports, [2]string{m[1], m[2]})
+			} else {
+				return nil, fmt.Errorf("wasm text line %d: cannot assemble %q", n+1, line)
+			}
+		}
+	}
+
+	a := &assembler{functions: make(map[string]int), globals: make(map[string]int)}
+	for i, name := range globals {
+		a.globals[name] = i
+	}
+	for i, fn := range funcs {
+		a.functions[fn.name] = i
+	}
+
+	out := []byte("\x00asm\x01\x00\x00\x00")
+
+	// Types: each distinct signature once, in order of first use
+	var typeSection []byte
+	typeIndex := make(map[string]int)
+	var signatures []*watFunc
+	for _, fn := range funcs {
+		if _, ok := typeIndex[fn.signature()]; !ok {
+			typeIndex[fn.signature()] = len(signatures)
+			signatures = append(signatures, fn)
+		}
+	}
+	typeSection = binary.AppendUvarint(typeSection, uint64(len(signatures)))
+	for _, fn := range signatures {
+		typeSection = append(typeSection, funcType)
+		typeSection = binary.AppendUvarint(typeSection, uint64(len(fn.paramTypes)))
+		for _, t := range fn.paramTypes {
+			typeSection = append(typeSection, valueTypes[t])
+		}
+		if fn.result == "" {
+			typeSection = append(typeSection, 0)
+		} else {
+			typeSection = append(typeSection, 1, valueTypes[fn.result])
+		}
+	}
+	out = appendSection(out, sectionType, typeSection)
+
+	var funcSection []byte
+	funcSection = binary.AppendUvarint(funcSection, uint64(len(funcs)))
+	for _, fn := range funcs {
+		funcSection = binary.AppendUvarint(funcSection, uint64(typeIndex[fn.signature()]))
+	}
+	out = appendSection(out, sectionFunction, funcSection)
+
+	// Each global starts at the constant the text initializes it with
+	if len(globals) > 0 {
+		var globalSection []byte
+		globalSection = binary.AppendUvarint(globalSection, uint64(len(globals)))
+		for i, t := range globalTypes {
+			op, arg, _ := strings.Cut(globalInits[i], " ")
+			globalSection = append(globalSection, valueTypes[t], mutable, opcodes[op])
+			var err error
+			if globalSection, err = appendConst(globalSection, op, arg); err != nil {
+				return nil, fmt.Errorf("global %s: %v", globals[i], err)
+			}
+			globalSection = append(globalSection, opEnd)
+		}
+		out = appendSection(out, sectionGlobal, globalSection)
+	}
+
+	if len(exports) > 0 {
+		var exportSection []byte
+		exportSection = binary.AppendUvarint(exportSection, uint64(len(exports)))
+		for _, export := range exports {
+			exportSection = appendName(exportSection, export[0])
+			exportSection = append(exportSection, exportFunc)
+			exportSection = binary.AppendUvarint(exportSection, uint64(a.functions[export[1]]))
+		}
+		out = appendSection(out, sectionExport, exportSection)
+	}
+
+	var codeSection []byte
+	codeSection = binary.AppendUvarint(codeSection, uint64(len(funcs)))
+	for _, fn := range funcs {
+		body, err := a.function(fn)
+		if err != nil {
+			return nil, fmt.Errorf("wasm function %s: %v", fn.name[1:], err)
+		}
+		codeSection = binary.AppendUvarint(codeSection, uint64(len(body)))
+		codeSection = append(codeSection, body...)
+	}
+	out = appendSection(out, sectionCode, codeSection)
+
+	return out, nil
+}
+
+// appendSection appends a section: its id, its size, then its contents.
+func appendSection(out []byte, id byte, contents []byte) []byte {
+	out = append(out, id)
+	out = binary.AppendUvarint(out, uint64(len(contents)))
+	return append(out, contents...)
+}
+
+// appendName appends a name, as its length and its UTF-8 bytes.
+func appendName(out []byte, name string) []byte {
+	out = binary.AppendUvarint(out, uint64(len(name)))
+	return append(out, name...)
+}
+
+// appendSigned appends v as a signed LEB128 number: seven bits at a time,
+// low bits first, until what is left is all copies of the sign bit of the
+// last group written.
+func appendSigned(out []byte, v int64) []byte {
+	for {
+		group := byte(v & 0x7F)
+		v >>= 7
+		if (v == 0 && group&0x40 == 0) || (v == -1 && group&0x40 != 0) {
+			return append(out, group)
+		}
+		out = append(out, group|0x80)
+	}
+}
+
+// assembler resolves the names in a function body to indices.
+type assembler struct {
+	functions map[string]int
+	globals   map[string]int
+}
+
+// function encodes one function's locals and body.
+func (a *assembler) function(fn *watFunc) ([]byte, error) {
+	locals := make(map[string]int)
+	for i, name := range fn.paramNames {
+		locals[name] = i
+	}
+	for i, name := range fn.localNames {
+		locals[name] = len(fn.paramNames) + i
+	}
+
+	// Locals are declared in runs of the same type
+	var runs [][2]int // count, index of the first of the run
+	for i, t := range fn.localTypes {
+		if len(runs) > 0 && fn.localTypes[runs[len(runs)-1][1]] == t {
+			runs[len(runs)-1][0]++
+		} else {
+			runs = append(runs, [2]int{1, i})
+		}
+	}
+	var out []byte
+	out = binary.AppendUvarint(out, uint64(len(runs)))
+	for _, run := range runs {
+		out = binary.AppendUvarint(out, uint64(run[0]))
+		out = append(out, valueTypes[fn.localTypes[run[1]]])
+	}
+
+	// labels is the stack of enclosing blocks, innermost last; an if has no
+	// label. br names one, and encodes how many blocks out it is
+	var labels []string
+	for _, line := range fn.body {
+		op, arg, _ := strings.Cut(line, " ")
+		code, ok := opcodes[op]
+		if !ok {
+			return nil, fmt.Errorf("unknown instruction %q", line)
+		}
+		out = append(out, code)
+
+		switch op {
+		case "block", "loop", "if":
+			labels = append(labels, arg)
+			out = append(out, blockEmpty)
+		case "end":
+			if len(labels) == 0 {
+				return nil, fmt.Errorf("end outside any block")
+			}
+			labels = labels[:len(labels)-1]
+		case "br":
+			depth := -1
+			for i := len(labels) - 1; i >= 0; i-- {
+				if labels[i] == arg {
+					depth = len(labels) - 1 - i
+					break
+				}
+			}
+			if depth < 0 {
+				return nil, fmt.Errorf("br to unknown label %s", arg)
+			}
+			out = binary.AppendUvarint(out, uint64(depth))
+		case "call":
+			index, ok := a.functions[arg]
+			if !ok {
+				return nil, fmt.Errorf("call to unknown function %s", arg)
+			}
+			out = binary.AppendUvarint(out, uint64(index))
+		case "local.get", "local.set":
+			index, ok := locals[arg]
+			if !ok {
+				return nil, fmt.Errorf("unknown local %s", arg)
+			}
+			out = binary.AppendUvarint(out, uint64(index))
+		case "global.get", "global.set":
+			index, ok := a.globals[arg]
+			if !ok {
+				return nil, fmt.Errorf("unknown global %s", arg)
+			}
+			out = binary.AppendUvarint(out, uint64(index))
+		case "i32.const", "i64.const", "f64.const":
+			var err error
+			if out, err = appendConst(out, op, arg); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(labels) != 0 {
+		return nil, fmt.Errorf("%d blocks left open", len(labels))
+	}
+	return append(out, opEnd), nil
+}
+
+// appendConst appends the immediate of the constant instruction op, as
+// constant spells it in arg.
+func appendConst(out []byte, op, arg string) ([]byte, error) {
+	if op == "f64.const" {
+		v, err := parseFloat(arg)
+		if err != nil {
+			return nil, err
+		}
+		return binary.LittleEndian.AppendUint64(out, math.Float64bits(v)), nil
+	}
+	v, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return appendSigned(out, v), nil
+}
+
+// parseFloat reads an f64.const immediate as constant spells it.
+func parseFloat(s string) (float64, error) {
+	switch s {
+	case "nan":
+		return math.Float64frombits(0x7FF8000000000000), nil // The canonical NaN
+	case "inf":
+		return math.Inf(1), nil
+	case "-inf":
+		return math.Inf(-1), nil
+	}
+	return strconv.ParseFloat(s, 64)
+}
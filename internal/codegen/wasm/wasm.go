@@ -0,0 +1,574 @@
+// Package wasm translates an IR module into WebAssembly text format (WAT).
+//
+// WHY WEBASSEMBLY:
+// A .wat file runs unchanged in every browser and in standalone runtimes
+// such as wasmtime, so it is the portable counterpart of the C backend:
+// `wat2wasm` (or the runtime itself) turns it into a binary module, as
+// Encode does (see encode.go).
+//
+// TRANSLATION:
+//   - Each IR function becomes a wasm func whose IR values are wasm locals;
+//     int is i64, float is f64 and bool is i32, the type wasm comparisons
+//     produce
+//   - Globals become mutable wasm globals, starting at their initializer
+//   - The CFG is rebuilt into structured block/loop/if constructs (see
+//     package cfg)
+//   - main is exported as "main", so a host calls it by that name and gets
+//     its result back
+//
+// DESIGN CHOICE: Integer, float and bool programs only, for now. Strings and
+// arrays need linear memory and an allocator, and print needs imports from
+// the host; until they exist, any construct the backend can't translate is
+// reported as "not yet supported in wasm backend" instead of producing a
+// module that fails to validate or computes the wrong thing.
+//
+// Operations whose wasm instruction traps where the language defines a
+// result (MinInt / -1, shifts of 64 or more) call small helper functions,
+// emitted only when used. Their names contain a ':', which no identifier in
+// the language can, so they never collide with the program's functions.
+package wasm
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/hassan/compiler/internal/codegen/cfg"
+	"github.com/hassan/compiler/internal/ir"
+	"github.com/hassan/compiler/internal/semantic/types"
+)
+
+// generator holds the state of one translation.
+type generator struct {
+	module *ir.Module
+
+	// errors accumulates constructs the backend can't translate
+	errors []error
+
+	// globals is the set of module-level values
+	globals map[*ir.Value]bool
+
+	// helpers records which helper functions the code calls
+	helpers map[string]bool
+
+	// functions finds a callee's definition by name
+	functions map[string]*ir.Function
+
+	// Per-function state
+
+	fn    *ir.Function
+	cfg   *cfg.Graph
+	out   strings.Builder
+	depth int
+}
+
+// Generate translates module into a WAT module.
+//
+// The returned errors name each construct that could not be translated; the
+// text is only usable when there are none.
+func Generate(module *ir.Module) (string, []error) {
+	g := &generator{
+		module:    module,
+		globals:   make(map[*ir.Value]bool),
+		helpers:   make(map[string]bool),
+		functions: make(map[string]*ir.Function),
+	}
+	for _, global := range module.Globals {
+		g.globals[global] = true
+	}
+	for _, fn := range module.Functions {
+		g.functions[fn.Name] = fn
+	}
+
+	var funcs []string
+	for _, fn := range module.Functions {
+		funcs = append(funcs, g.function(fn))
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf(";; Generated from module %s.\n(module\n", module.Name))
+	for _, global := range module.Globals {
+		typ := g.valueType(global.Type, "global "+global.Name)
+		initial := typ + ".const 0"
+		if global.Initializer != nil {
+			initial = g.constant(global.Initializer)
+		}
+		sb.WriteString(fmt.Sprintf("  (global $%s (mut %s) (%s))\n", global.Name, typ, initial))
+	}
+	for _, fn := range funcs {
+		sb.WriteString(fn)
+	}
+	for _, name := range helperOrder {
+		if g.helpers[name] {
+			sb.WriteString(helperFuncs[name])
+		}
+	}
+	for _, fn := range module.Functions {
+		if fn.Name == "main" {
+			sb.WriteString("  (export \"main\" (func $main))\n")
+		}
+	}
+	sb.WriteString(")\n")
+
+	return sb.String(), g.errors
+}
+
+// unsupported records a construct the backend can't translate yet.
+func (g *generator) unsupported(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	if g.fn != nil {
+		msg = fmt.Sprintf("in function %s: %s", g.fn.Name, msg)
+	}
+	g.errors = append(g.errors, fmt.Errorf("%s not yet supported in wasm backend", msg))
+}
+
+// valueType returns the wasm type representing t. what describes the value
+// for the error message.
+func (g *generator) valueType(t types.Type, what string) string {
+	switch t.(type) {
+	case *types.IntType:
+		return "i64"
+	case *types.FloatType:
+		return "f64"
+	case *types.BoolType:
+		return "i32"
+	default:
+		g.unsupported("%s of type %s is", what, t)
+		return "i64"
+	}
+}
+
+// isVoid reports whether a function of result type t returns nothing.
+func isVoid(t types.Type) bool {
+	_, ok := t.(*types.VoidType)
+	return ok
+}
+
+// line writes one line of function body at the current nesting depth.
+func (g *generator) line(format string, args ...interface{}) {
+	g.out.WriteString(strings.Repeat("  ", g.depth+2))
+	g.out.WriteString(fmt.Sprintf(format, args...))
+	g.out.WriteString("\n")
+}
+
+// localName names a function-local value: temporaries by number, variables
+// and parameters by name and number, so shadowed variables stay distinct.
+func localName(v *ir.Value) string {
+	switch {
+	case v.Kind == ir.ValueTemporary:
+		return fmt.Sprintf("$t%d", v.ID)
+	case v.Name != "":
+		return fmt.Sprintf("$%s_%d", v.Name, v.ID)
+	default:
+		return fmt.Sprintf("$v%d", v.ID)
+	}
+}
+
+// blockLabel names the wasm block that a branch leaves to reach block;
+// loopLabel the loop that a branch restarts to reach block. IR labels
+// repeat within a function, so the position makes them unique.
+func (g *generator) blockLabel(block *ir.BasicBlock) string {
+	return fmt.Sprintf("$%s_%d", block.Label, g.cfg.RPO(block))
+}
+
+func (g *generator) loopLabel(block *ir.BasicBlock) string {
+	return fmt.Sprintf("$%s_%d_loop", block.Label, g.cfg.RPO(block))
+}
+
+// function translates one function.
+func (g *generator) function(fn *ir.Function) string {
+	g.fn = fn
+	g.cfg = cfg.New(fn)
+	g.out.Reset()
+	g.depth = 0
+	defer func() { g.fn = nil }()
+
+	var header strings.Builder
+	header.WriteString(fmt.Sprintf("  (func $%s", fn.Name))
+	params := make(map[*ir.Value]bool)
+	for _, param := range fn.Parameters {
+		params[param] = true
+		header.WriteString(fmt.Sprintf(" (param %s %s)", localName(param),
+			g.valueType(param.Type, "parameter "+param.Name)))
+	}
+	returns := !isVoid(fn.ReturnType)
+	if returns {
+		header.WriteString(fmt.Sprintf(" (result %s)", g.valueType(fn.ReturnType, "result")))
+	}
+	header.WriteString("\n")
+
+	// Locals: every value an instruction defines. Wasm zero-initializes
+	// them, matching the language's zero values.
+	declared := make(map[*ir.Value]bool)
+	for _, block := range g.cfg.Order {
+		for _, instr := range block.Instructions {
+			dest := instr.Result()
+			if dest == nil || declared[dest] || params[dest] || g.globals[dest] {
+				continue
+			}
+			declared[dest] = true
+			header.WriteString(fmt.Sprintf("    (local %s %s)\n", localName(dest),
+				g.valueType(dest.Type, "variable "+localName(dest)[1:])))
+		}
+	}
+
+	if from, to := g.cfg.Irreducible(); from != nil {
+		g.unsupported("irreducible control flow (a second entry into loop %s from %s) is", to.Label, from.Label)
+	} else if len(g.cfg.Order) > 0 {
+		g.doTree(g.cfg.Order[0])
+	}
+
+	// Every path ends in a return or a branch, but the validator only knows
+	// that about the last instruction, not about a loop or if before it
+	if returns {
+		g.line("unreachable")
+	}
+
+	return header.String() + g.out.String() + "  )\n"
+}
+
+// doTree emits block and everything it dominates, wrapped in a loop if
+// block is a loop header.
+func (g *generator) doTree(block *ir.BasicBlock) {
+	if !g.cfg.IsLoopHeader(block) {
+		g.nodeWithin(block, g.cfg.MergeChildren(block))
+		return
+	}
+	g.line("loop %s", g.loopLabel(block))
+	g.depth++
+	g.nodeWithin(block, g.cfg.MergeChildren(block))
+	g.depth--
+	g.line("end")
+}
+
+// nodeWithin emits block's code inside one wasm block per merge node it
+// dominates, each merge node following the end of its wasm block. A branch
+// to a merge node is then a br to that label.
+func (g *generator) nodeWithin(block *ir.BasicBlock, merges []*ir.BasicBlock) {
+	if len(merges) > 0 {
+		g.line("block %s", g.blockLabel(merges[0]))
+		g.depth++
+		g.nodeWithin(block, merges[1:])
+		g.depth--
+		g.line("end")
+		g.doTree(merges[0])
+		return
+	}
+
+	for _, instr := range block.Instructions {
+		g.instruction(block, instr)
+	}
+	if block.Terminator() == nil {
+		g.unsupported("block %s without a terminator is", block.Label)
+	}
+}
+
+// doBranch emits the transfer of control from one block to another.
+func (g *generator) doBranch(from, to *ir.BasicBlock) {
+	switch {
+	case g.cfg.IsBackEdge(from, to):
+		g.line("br %s", g.loopLabel(to))
+	case g.cfg.IsMergeNode(to):
+		g.line("br %s", g.blockLabel(to))
+	default:
+		g.doTree(to)
+	}
+}
+
+// push emits the instruction that puts a value on the stack.
+func (g *generator) push(v *ir.Value) {
+	switch {
+	case v.IsConstant():
+		g.line("%s", g.constant(v))
+	case g.globals[v]:
+		g.line("global.get $%s", v.Name)
+	case v.ID == -1 && v.Kind == ir.ValueVariable:
+		g.unsupported("function value %s is", v.Name)
+	default:
+		g.line("local.get %s", localName(v))
+	}
+}
+
+// pop emits the instruction that stores the top of the stack in a value.
+func (g *generator) pop(v *ir.Value) {
+	if g.globals[v] {
+		g.line("global.set $%s", v.Name)
+	} else {
+		g.line("local.set %s", localName(v))
+	}
+}
+
+// constant returns the instruction pushing a constant.
+func (g *generator) constant(v *ir.Value) string {
+	switch c := v.Constant.(type) {
+	case int64:
+		return fmt.Sprintf("i64.const %d", c)
+	case float64:
+		switch {
+		case math.IsNaN(c):
+			return "f64.const nan"
+		case math.IsInf(c, 1):
+			return "f64.const inf"
+		case math.IsInf(c, -1):
+			return "f64.const -inf"
+		}
+		return "f64.const " + strconv.FormatFloat(c, 'g', -1, 64)
+	case bool:
+		if c {
+			return "i32.const 1"
+		}
+		return "i32.const 0"
+	default:
+		g.unsupported("constant %v of type %s is", c, v.Type)
+		return "i64.const 0"
+	}
+}
+
+// instruction emits the code for one instruction.
+func (g *generator) instruction(block *ir.BasicBlock, instr ir.Instruction) {
+	switch i := instr.(type) {
+	case *ir.BinaryOp:
+		g.push(i.Left)
+		g.push(i.Right)
+		g.binary(i)
+		g.pop(i.Dest)
+
+	case *ir.UnaryOp:
+		g.unary(i)
+		g.pop(i.Dest)
+
+	case *ir.Copy:
+		g.push(i.Value)
+		g.pop(i.Dest)
+
+	case *ir.Call:
+		if name := i.Builtin(); name != "" {
+			g.unsupported("builtin %s is", name)
+			return
+		}
+		for _, arg := range i.Args {
+			g.push(arg)
+		}
+		g.line("call $%s", i.Function.Name)
+		if i.Dest != nil {
+			g.pop(i.Dest)
+		} else if callee := g.functions[i.Function.Name]; callee != nil && !isVoid(callee.ReturnType) {
+			g.line("drop")
+		}
+
+	case *ir.Jump:
+		g.doBranch(block, i.Target)
+
+	case *ir.Branch:
+		g.push(i.Condition)
+		g.line("if")
+		g.depth++
+		g.doBranch(block, i.TrueBlock)
+		g.depth--
+		g.line("else")
+		g.depth++
+		g.doBranch(block, i.FalseBlock)
+		g.depth--
+		g.line("end")
+
+	case *ir.Return:
+		if i.Value != nil {
+			g.push(i.Value)
+		}
+		g.line("return")
+
+	case *ir.Phi:
+		g.unsupported("phi node %s is", localName(i.Dest)[1:])
+	case *ir.Alloca, *ir.Load, *ir.Store, *ir.GetElementPtr, *ir.GetFieldPtr:
+		g.unsupported("memory access (%s) is", instr)
+	default:
+		g.unsupported("instruction %s is", instr)
+	}
+}
+
+// intOps, floatOps and boolOps spell the operators that map to a single
+// wasm instruction, by operand type.
+var intOps = map[ir.BinaryOperator]string{
+	ir.OpAdd: "i64.add", ir.OpSub: "i64.sub", ir.OpMul: "i64.mul", ir.OpMod: "i64.rem_s",
+	ir.OpBitAnd: "i64.and", ir.OpBitOr: "i64.or", ir.OpBitXor: "i64.xor",
+	ir.OpEq: "i64.eq", ir.OpNeq: "i64.ne",
+	ir.OpLt: "i64.lt_s", ir.OpLe: "i64.le_s", ir.OpGt: "i64.gt_s", ir.OpGe: "i64.ge_s",
+}
+
+// uintOps replace intOps and intHelpers for uint64 operands, where the
+// operators that depend on the sign bit have unsigned forms. Unlike
+// i64.div_s, i64.div_u can't overflow: only a zero divisor traps.
+var uintOps = map[ir.BinaryOperator]string{
+	ir.OpDiv: "i64.div_u", ir.OpMod: "i64.rem_u",
+	ir.OpLt: "i64.lt_u", ir.OpLe: "i64.le_u", ir.OpGt: "i64.gt_u", ir.OpGe: "i64.ge_u",
+}
+
+var floatOps = map[ir.BinaryOperator]string{
+	ir.OpAdd: "f64.add", ir.OpSub: "f64.sub", ir.OpMul: "f64.mul", ir.OpDiv: "f64.div",
+	ir.OpEq: "f64.eq", ir.OpNeq: "f64.ne",
+	ir.OpLt: "f64.lt", ir.OpLe: "f64.le", ir.OpGt: "f64.gt", ir.OpGe: "f64.ge",
+}
+
+var boolOps = map[ir.BinaryOperator]string{
+	ir.OpAnd: "i32.and", ir.OpOr: "i32.or", ir.OpEq: "i32.eq", ir.OpNeq: "i32.ne",
+}
+
+// intHelpers are the integer operators implemented by a helper function.
+var intHelpers = map[ir.BinaryOperator]string{
+	ir.OpDiv: "$rt:div", ir.OpShl: "$rt:shl", ir.OpShr: "$rt:shr",
+}
+
+// binary emits the instruction for a binary operator, its operands already
+// on the stack.
+func (g *generator) binary(b *ir.BinaryOp) {
+	var ops map[ir.BinaryOperator]string
+	switch b.Left.Type.(type) {
+	case *types.IntType:
+		if types.IsUnsigned64(b.Left.Type) {
+			if op, ok := uintOps[b.Op]; ok {
+				g.line("%s", op)
+				return
+			}
+			if b.Op == ir.OpShr {
+				g.helpers["$rt:ushr"] = true
+				g.line("call $rt:ushr")
+				return
+			}
+		}
+		if helper, ok := intHelpers[b.Op]; ok {
+			g.helpers[helper] = true
+			g.line("call %s", helper)
+			return
+		}
+		ops = intOps
+	case *types.FloatType:
+		ops = floatOps
+	case *types.BoolType:
+		ops = boolOps
+	}
+	if op, ok := ops[b.Op]; ok {
+		g.line("%s", op)
+		return
+	}
+	g.unsupported("operator %s on %s is", b.Op, b.Left.Type)
+}
+
+// unary emits a unary operation, operand included: negation needs its zero
+// pushed first.
+func (g *generator) unary(u *ir.UnaryOp) {
+	switch u.Operand.Type.(type) {
+	case *types.IntType:
+		switch u.Op {
+		case ir.OpNeg:
+			g.line("i64.const 0")
+			g.push(u.Operand)
+			g.line("i64.sub")
+			return
+		case ir.OpBitNot:
+			g.push(u.Operand)
+			g.line("i64.const -1")
+			g.line("i64.xor")
+			return
+		}
+	case *types.FloatType:
+		if u.Op == ir.OpNeg {
+			g.push(u.Operand)
+			g.line("f64.neg")
+			return
+		}
+	case *types.BoolType:
+		if u.Op == ir.OpNot {
+			g.push(u.Operand)
+			g.line("i32.eqz")
+			return
+		}
+	}
+	g.unsupported("operator %s on %s is", u.Op, u.Operand.Type)
+}
+
+// helperOrder is the order helpers appear in the module.
+var helperOrder = []string{"$rt:div", "$rt:shl", "$rt:shr", "$rt:ushr"}
+
+// helperFuncs are the helper functions' definitions. i64.div_s traps on
+// MinInt / -1, which the language defines as MinInt (wrapping negation);
+// i64.shl and i64.shr_s take the shift amount modulo 64, where the language
+// shifts everything out. A negative shift amount traps, as a runtime error.
+// Division by zero is left to i64.div_s, which traps on it. $rt:ushr is
+// $rt:shr for uint64, shifting zeros in.
+var helperFuncs = map[string]string{
+	"$rt:div": `  (func $rt:div (param $a i64) (param $b i64) (result i64)
+    local.get $b
+    i64.const -1
+    i64.eq
+    if
+      i64.const 0
+      local.get $a
+      i64.sub
+      return
+    end
+    local.get $a
+    local.get $b
+    i64.div_s
+  )
+`,
+	"$rt:shl": `  (func $rt:shl (param $a i64) (param $b i64) (result i64)
+    local.get $b
+    i64.const 0
+    i64.lt_s
+    if
+      unreachable
+    end
+    local.get $b
+    i64.const 64
+    i64.ge_s
+    if
+      i64.const 0
+      return
+    end
+    local.get $a
+    local.get $b
+    i64.shl
+  )
+`,
+	"$rt:shr": `  (func $rt:shr (param $a i64) (param $b i64) (result i64)
+    local.get $b
+    i64.const 0
+    i64.lt_s
+    if
+      unreachable
+    end
+    local.get $b
+    i64.const 64
+    i64.ge_s
+    if
+      local.get $a
+      i64.const 63
+      i64.shr_s
+      return
+    end
+    local.get $a
+    local.get $b
+    i64.shr_s
+  )
+`,
+	"$rt:ushr": `  (func $rt:ushr (param $a i64) (param $b i64) (result i64)
+    local.get $b
+    i64.const 0
+    i64.lt_s
+    if
+      unreachable
+    end
+    local.get $b
+    i64.const 64
+    i64.ge_s
+    if
+      i64.const 0
+      return
+    end
+    local.get $a
+    local.get $b
+    i64.shr_u
+  )
+`,
+}
@@ -0,0 +1,242 @@
+package wasm
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hassan/compiler/internal/interp"
+	"github.com/hassan/compiler/internal/ir"
+	"github.com/hassan/compiler/internal/semantic/types"
+	"github.com/hassan/compiler/pkg/compiler"
+)
+
+// update rewrites golden files with the current output instead of comparing.
+var update = flag.Bool("update", false, "update golden files")
+
+// compileSource compiles a program with the default optimizations.
+func compileSource(t *testing.T, source []byte, filename string) *ir.Module {
+	t.Helper()
+	result, err := compiler.Compile(source, filename, compiler.Options{OptLevel: 1})
+	if err != nil {
+		t.Fatalf("compiling %s: %v", filename, err)
+	}
+	return result.Module
+}
+
+// TestGenerate_Golden compares the WAT generated for every testdata program
+// that has a .golden file.
+func TestGenerate_Golden(t *testing.T) {
+	goldens, err := filepath.Glob("testdata/*.golden")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(goldens) == 0 {
+		t.Fatal("no golden files found")
+	}
+
+	for _, golden := range goldens {
+		path := strings.TrimSuffix(golden, ".golden") + ".src"
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			source, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, errs := Generate(compileSource(t, source, path))
+			for _, err := range errs {
+				t.Error(err)
+			}
+
+			if *update {
+				if err := os.WriteFile(golden, []byte(got), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != string(want) {
+				t.Errorf("output differs from %s (run with -update to accept):\ngot:\n%s\nwant:\n%s", golden, got, want)
+			}
+		})
+	}
+}
+
+func TestGenerate_Unsupported(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{
+			name:   "string result",
+			source: "package main\n\nfunc name() string {\n    return \"x\";\n}\n",
+			want:   "in function name: result of type string is not yet supported in wasm backend",
+		},
+		{
+			name:   "print",
+			source: "package main\n\nfunc main() {\n    println(1);\n}\n",
+			want:   "in function main: builtin println is not yet supported in wasm backend",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, errs := Generate(compileSource(t, []byte(tt.source), "input.src"))
+			for _, err := range errs {
+				if err.Error() == tt.want {
+					return
+				}
+			}
+			t.Errorf("expected error %q, got %v", tt.want, errs)
+		})
+	}
+}
+
+// TestEncode checks the binary of a small module byte by byte, unoptimized
+// so that add isn't folded into main.
+func TestEncode(t *testing.T) {
+	source := `package main
+
+func add(a int, b int) int {
+    return a + b;
+}
+
+func main() int {
+    return add(2, 3);
+}
+`
+	result, err := compiler.Compile([]byte(source), "input.src", compiler.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, errs := Encode(result.Module)
+	for _, err := range errs {
+		t.Error(err)
+	}
+
+	want := [][]byte{
+		{0x00, 'a', 's', 'm', 0x01, 0x00, 0x00, 0x00}, // magic and version
+		// Type section: (i64, i64) -> i64 and () -> i64
+		{0x01, 0x0B, 0x02, 0x60, 0x02, 0x7E, 0x7E, 0x01, 0x7E, 0x60, 0x00, 0x01, 0x7E},
+		// Function section: add has type 0, main type 1
+		{0x03, 0x03, 0x02, 0x00, 0x01},
+		// Export section: "main" is function 1
+		{0x07, 0x08, 0x01, 0x04, 'm', 'a', 'i', 'n', 0x00, 0x01},
+		// Code section: two bodies, each with one i64 local
+		{0x0A, 0x22, 0x02},
+		{0x0F, 0x01, 0x01, 0x7E,
+			0x20, 0x00, 0x20, 0x01, 0x7C, // local.get 0, local.get 1, i64.add
+			0x21, 0x02, 0x20, 0x02, 0x0F, 0x00, 0x0B}, // local.set 2, local.get 2, return, unreachable, end
+		{0x10, 0x01, 0x01, 0x7E,
+			0x42, 0x02, 0x42, 0x03, 0x10, 0x00, // i64.const 2, i64.const 3, call 0
+			0x21, 0x00, 0x20, 0x00, 0x0F, 0x00, 0x0B},
+	}
+	if want := bytes.Join(want, nil); !bytes.Equal(got, want) {
+		t.Errorf("got\n% x\nwant\n% x", got, want)
+	}
+}
+
+// TestEncode_Run loads every testdata program's binary with Node.js, which
+// validates it, and checks that main returns what the interpreter says it
+// does. Skipped where Node.js isn't installed.
+func TestEncode_Run(t *testing.T) {
+	node, err := exec.LookPath("node")
+	if err != nil {
+		t.Skip("no node found")
+	}
+	sources, err := filepath.Glob("testdata/*.src")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const script = `const fs = require("fs");
+const module = new WebAssembly.Module(fs.readFileSync(process.argv[1]));
+const instance = new WebAssembly.Instance(module, {});
+try {
+  console.log(String(instance.exports.main()));
+} catch (e) {
+  console.log(e instanceof WebAssembly.RuntimeError ? "trap" : String(e));
+}`
+	for _, path := range sources {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			source, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			module := compileSource(t, source, path)
+			code, errs := Encode(module)
+			for _, err := range errs {
+				t.Fatal(err)
+			}
+			file := filepath.Join(t.TempDir(), "main.wasm")
+			if err := os.WriteFile(file, code, 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			result, runErr := interp.New(module).Run("main", nil)
+			want := fmt.Sprint(result)
+			if runErr != nil {
+				want = "trap"
+			}
+
+			out, err := exec.Command(node, "-e", script, file).Output()
+			if err != nil {
+				var exitErr *exec.ExitError
+				if errors.As(err, &exitErr) {
+					t.Fatalf("node failed: %s", exitErr.Stderr)
+				}
+				t.Fatal(err)
+			}
+			if got := strings.TrimSpace(string(out)); got != want {
+				t.Errorf("main returned %s, interpreter returned %s", got, want)
+			}
+		})
+	}
+}
+
+func TestAppendSigned(t *testing.T) {
+	tests := []struct {
+		v    int64
+		want []byte
+	}{
+		{0, []byte{0x00}},
+		{63, []byte{0x3F}},
+		{64, []byte{0xC0, 0x00}},
+		{-1, []byte{0x7F}},
+		{-64, []byte{0x40}},
+		{-65, []byte{0xBF, 0x7F}},
+		{624485, []byte{0xE5, 0x8E, 0x26}},
+		{-9223372036854775808, []byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x7F}},
+	}
+	for _, tt := range tests {
+		if got := appendSigned(nil, tt.v); !bytes.Equal(got, tt.want) {
+			t.Errorf("appendSigned(%d) = % x, want % x", tt.v, got, tt.want)
+		}
+	}
+}
+
+// TestGenerate_Irreducible builds a loop with two entries, which no source
+// program produces, and checks it is refused rather than mistranslated.
+func TestGenerate_Irreducible(t *testing.T) {
+	fn := ir.NewFunction("f", nil, types.Void)
+	cond := &ir.Value{ID: 0, Name: "c", Type: types.Bool, Kind: ir.ValueVariable}
+	a := fn.NewBasicBlockInFunc("a")
+	b := fn.NewBasicBlockInFunc("b")
+	fn.Entry.AddInstruction(&ir.Branch{Condition: cond, TrueBlock: a, FalseBlock: b})
+	a.AddInstruction(&ir.Branch{Condition: cond, TrueBlock: b, FalseBlock: a})
+	b.AddInstruction(&ir.Jump{Target: a})
+	module := ir.NewModule("main")
+	module.AddFunction(fn)
+
+	_, errs := Generate(module)
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "irreducible control flow") {
+		t.Errorf("expected an irreducible control flow error, got %v", errs)
+	}
+}
@@ -0,0 +1,209 @@
+// Package errors defines the structured diagnostic type shared by every
+// compiler phase.
+//
+// DESIGN CHOICE: Each phase used to report problems as opaque fmt.Errorf
+// values whose only structure was the "file:line:col: message" text. That is
+// fine for a human reading a terminal, but editors and CI tools need the
+// location as data. CompileError keeps the exact same Error() text (so nothing
+// that prints errors changes) while exposing the fields tools care about.
+//
+// The package is deliberately named errors to read naturally at call sites
+// (errors.New(errors.CodeSyntax, pos, msg)). Files that also need the standard
+// library package import one of the two under an alias.
+package errors
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/hassan/compiler/internal/lexer"
+)
+
+// Error codes identify the phase (and eventually the specific check) that
+// produced a diagnostic.
+//
+// DESIGN CHOICE: Codes are stable strings rather than an int enum because they
+// are part of the JSON contract - tools match on "E003", and a string survives
+// being reordered in this file.
+const (
+	CodeLexical  = "E001" // Malformed token (unterminated string, bad escape, ...)
+	CodeSyntax   = "E002" // Parser could not match the grammar
+	CodeSemantic = "E003" // Name resolution or type checking failure
+	CodeIR       = "E004" // IR generation failure
+	CodeVerify   = "E005" // IR failed structural verification
+	CodeInternal = "E999" // Anything else (should not reach users)
+
+	CodeUnusedVariable = "W001" // Local variable declared but never read
+	CodeUnusedImport   = "W002" // Import never referenced
+	CodeShadowed       = "W003" // Declaration hides one in an enclosing scope
+	CodeUnreachable    = "W004" // Statement after return/break/continue
+	CodeOverflow       = "W005" // Integer constant expression wraps around
+	CodeEmptySwitch    = "W006" // Switch without any case or default
+	CodeAssignCond     = "W007" // Assignment used directly as a condition
+)
+
+// DiagnosticSeverity says how seriously a diagnostic should be taken.
+//
+// DESIGN CHOICE: Severity is a property of the diagnostic rather than of the
+// list it arrives in, so a driver can promote warnings to errors
+// (--warnings-as-errors) by flipping a field instead of moving values between
+// slices, and the JSON output can carry one mixed, ordered array.
+//
+// Error is the zero value, so every diagnostic created before severities
+// existed - and every New call that doesn't ask otherwise - stays an error.
+type DiagnosticSeverity int
+
+const (
+	SeverityError DiagnosticSeverity = iota
+	SeverityWarning
+	SeverityInfo
+)
+
+// String returns the lowercase name used in rendered and JSON output.
+func (s DiagnosticSeverity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	case SeverityInfo:
+		return "info"
+	default:
+		return "unknown"
+	}
+}
+
+// CompileError is a single diagnostic with its source range.
+//
+// Pos is required for a located diagnostic; End may be left zero, in which case
+// the range collapses to the single point Pos. Notes carry secondary
+// information such as "previous declaration was here".
+type CompileError struct {
+	Pos      lexer.Position
+	End      lexer.Position
+	Severity DiagnosticSeverity
+	Code     string
+	Message  string
+	Notes    []string
+}
+
+// New creates a located error.
+func New(code string, pos lexer.Position, message string) *CompileError {
+	return &CompileError{Pos: pos, Code: code, Message: message}
+}
+
+// NewWarning creates a located warning.
+func NewWarning(code string, pos lexer.Position, message string) *CompileError {
+	return &CompileError{Pos: pos, Severity: SeverityWarning, Code: code, Message: message}
+}
+
+// IsError reports whether the diagnostic should fail compilation.
+func (e *CompileError) IsError() bool {
+	return e.Severity == SeverityError
+}
+
+// Error implements the error interface.
+//
+// The format matches what the phases produced before this type existed:
+// "file:line:col: message", or just the message when there is no position.
+// Non-errors have their severity spelled out: "file:line:col: warning: ...".
+func (e *CompileError) Error() string {
+	message := e.Message
+	if e.Severity != SeverityError {
+		message = e.Severity.String() + ": " + message
+	}
+	if e.Pos.IsValid() {
+		return e.Pos.String() + ": " + message
+	}
+	return message
+}
+
+// WithEnd sets the end of the diagnostic's range and returns the error so it
+// can be chained onto New.
+func (e *CompileError) WithEnd(end lexer.Position) *CompileError {
+	e.End = end
+	return e
+}
+
+// WithNote appends a secondary note and returns the error for chaining.
+func (e *CompileError) WithNote(note string) *CompileError {
+	e.Notes = append(e.Notes, note)
+	return e
+}
+
+// FromError converts an arbitrary error into a CompileError.
+//
+// Errors that already are *CompileError are copied unchanged. Anything else
+// (for example the IR verifier's plain fmt errors) becomes an unlocated
+// diagnostic tagged with the given code.
+func FromError(err error, code string) CompileError {
+	if ce, ok := err.(*CompileError); ok {
+		return *ce
+	}
+	return CompileError{Code: code, Message: err.Error()}
+}
+
+// FromErrors converts a slice of errors with FromError.
+func FromErrors(errs []error, code string) []CompileError {
+	result := make([]CompileError, len(errs))
+	for i, err := range errs {
+		result[i] = FromError(err, code)
+	}
+	return result
+}
+
+// jsonError is the wire format for a CompileError.
+//
+// DESIGN CHOICE: A separate struct keeps the JSON shape (snake_case keys, flat
+// line/column numbers) independent of the Go field layout, so CompileError can
+// keep using lexer.Position internally.
+type jsonError struct {
+	File      string   `json:"file"`
+	Line      int      `json:"line"`
+	Column    int      `json:"column"`
+	EndLine   int      `json:"end_line"`
+	EndColumn int      `json:"end_column"`
+	Severity  string   `json:"severity"`
+	Code      string   `json:"code"`
+	Message   string   `json:"message"`
+	Notes     []string `json:"notes"`
+}
+
+// MarshalJSON encodes diagnostics as a JSON array of objects.
+//
+// A missing end position is reported as equal to the start, and notes are
+// always an array (never null), so consumers don't need special cases. HTML
+// escaping is disabled so messages such as "cannot assign <invalid> to int"
+// stay readable in the raw output.
+func MarshalJSON(errs []CompileError) ([]byte, error) {
+	out := make([]jsonError, len(errs))
+	for i, e := range errs {
+		end := e.End
+		if !end.IsValid() {
+			end = e.Pos
+		}
+		notes := e.Notes
+		if notes == nil {
+			notes = []string{}
+		}
+		out[i] = jsonError{
+			File:      e.Pos.Filename,
+			Line:      e.Pos.Line,
+			Column:    e.Pos.Column,
+			EndLine:   end.Line,
+			EndColumn: end.Column,
+			Severity:  e.Severity.String(),
+			Code:      e.Code,
+			Message:   e.Message,
+			Notes:     notes,
+		}
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(out); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
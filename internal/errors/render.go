@@ -0,0 +1,84 @@
+package errors
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/hassan/compiler/internal/lexer"
+)
+
+// Render formats the diagnostic for a terminal, with the offending source
+// lines and a caret underline beneath them:
+//
+//	main.src:4:17: undefined: missing
+//	   4 |     var y int = missing;
+//	     |                 ^
+//	   = note: ...
+//
+// source is the full text of the file the diagnostic refers to. When it is
+// empty, or the diagnostic has no position, Render degrades to Error().
+//
+// DESIGN CHOICE: Rendering lives here rather than in the driver so every
+// front end (the CLI today, a REPL or test harness later) shows errors the
+// same way. The JSON renderer doesn't need it - consumers there get the raw
+// line/column range and draw their own squiggles.
+func (e *CompileError) Render(source string) string {
+	var sb strings.Builder
+	sb.WriteString(e.Error())
+
+	span := lexer.Span{Start: e.Pos, End: e.End}
+	lines := span.ExtractLines(source)
+	if source != "" && len(lines) > 0 {
+		// Size the gutter for the widest line number so the bars line up.
+		gutter := len(strconv.Itoa(lines[len(lines)-1].Line)) + 2
+		for _, line := range lines {
+			sb.WriteByte('\n')
+			sb.WriteString(padLeft(strconv.Itoa(line.Line), gutter))
+			sb.WriteString(" | ")
+			sb.WriteString(line.Text)
+			sb.WriteByte('\n')
+			sb.WriteString(strings.Repeat(" ", gutter))
+			sb.WriteString(" | ")
+			sb.WriteString(underline(line))
+		}
+	}
+
+	for _, note := range e.Notes {
+		sb.WriteString("\n   = note: ")
+		sb.WriteString(note)
+	}
+	return sb.String()
+}
+
+// underline builds the caret line for an annotated source line.
+//
+// Tabs before the underlined region are copied through (everything else
+// becomes a space) so the carets land under the right characters no matter
+// how the terminal expands tabs.
+func underline(line lexer.AnnotatedLine) string {
+	var sb strings.Builder
+	col := 1
+	for _, r := range line.Text {
+		if col >= line.StartColumn {
+			break
+		}
+		if r == '\t' {
+			sb.WriteByte('\t')
+		} else {
+			sb.WriteByte(' ')
+		}
+		col++
+	}
+	for ; col < line.StartColumn; col++ {
+		sb.WriteByte(' ')
+	}
+	sb.WriteString(strings.Repeat("^", line.EndColumn-line.StartColumn))
+	return sb.String()
+}
+
+func padLeft(s string, width int) string {
+	if len(s) >= width {
+		return s
+	}
+	return strings.Repeat(" ", width-len(s)) + s
+}
@@ -0,0 +1,576 @@
+// Package formatter prints an AST back out as canonical source code.
+//
+// The layout is fixed, in the spirit of gofmt: there are no options to argue
+// about. Blocks are indented four spaces, opening braces stay on the line of
+// the statement they belong to, binary and assignment operators have a space
+// on each side, and top-level declarations are separated by exactly one
+// blank line. Inside a block a single blank line between statements is kept
+// where the source had one or more, because those usually separate steps of
+// the algorithm.
+//
+// DESIGN CHOICE: The formatter works from the AST rather than the token
+// stream. Everything that is layout is decided here, so two programs that
+// differ only in whitespace format identically, and formatting formatted code
+// changes nothing. What the AST does not keep is preserved by reading the
+// tokens it does keep: literals are printed with their original lexeme (0xFF
+// stays hex, "\t" stays escaped) and parentheses survive as GroupingExpr, so
+// the formatter never has to reason about precedence.
+//
+// Comments are not part of the tree. Top-level comments are put back
+// between the declarations by position, on their own line, after the
+// declaration they trailed on the same line, or before the one they led on
+// the same line. A comment inside a declaration goes back the same way
+// among the statements or struct fields around it.
+// One inside a line the formatter rewrites whole, such as between two
+// parameters, moves to its own line at the next place a line begins.
+package formatter
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hassan/compiler/internal/lexer"
+	"github.com/hassan/compiler/internal/parser/ast"
+)
+
+// indent is one level of block indentation.
+const indent = "    "
+
+// Formatter renders files as canonical source code.
+type Formatter struct{}
+
+// New creates a formatter.
+func New() *Formatter {
+	return &Formatter{}
+}
+
+// Format returns the canonical source text of file. The file must have
+// parsed without errors; nodes dropped by error recovery are skipped, which
+// loses code.
+func (f *Formatter) Format(file *ast.File) string {
+	p := &printer{}
+	p.file(file)
+	return p.sb.String()
+}
+
+// printer is the Visitor that does the formatting. Statements and
+// declarations write whole lines at the current depth; expressions return
+// their text as a string, so the enclosing statement decides where it goes.
+type printer struct {
+	sb    strings.Builder
+	depth int
+
+	// comments are the comments inside declarations not yet written, in
+	// source order
+	comments []*ast.Comment
+
+	// leading holds the comments that came before the next line's code on
+	// the same source line, written at the start of that line
+	leading string
+}
+
+// line writes one line at the current depth. Empty lines are written
+// without indentation so no line ends in whitespace.
+func (p *printer) line(text string) {
+	if text != "" {
+		if p.leading != "" {
+			text = p.leading + " " + text
+			p.leading = ""
+		}
+		p.sb.WriteString(strings.Repeat(indent, p.depth))
+		p.sb.WriteString(text)
+	}
+	p.sb.WriteString("\n")
+}
+
+// item is a top-level element of a file in source order: a declaration
+// (including the package clause or an import) or a comment.
+type item struct {
+	offset        int
+	line, endLine int
+	comment       *ast.Comment
+	node          ast.Node
+}
+
+func (p *printer) file(file *ast.File) {
+	var items []item
+	add := func(node ast.Node) {
+		items = append(items, item{offset: node.Pos().Offset, line: node.Pos().Line, endLine: node.End().Line, node: node})
+	}
+	if file.Package != nil {
+		add(file.Package)
+	}
+	for _, imp := range file.Imports {
+		if imp != nil && imp.Path != nil {
+			add(imp)
+		}
+	}
+	for _, decl := range file.Decls {
+		if decl != nil {
+			add(decl)
+		}
+	}
+	for _, comment := range file.Comments {
+		if insideDecl(file, comment) {
+			p.comments = append(p.comments, comment)
+			continue
+		}
+		items = append(items, item{
+			offset:  comment.Position.Offset,
+			line:    comment.Position.Line,
+			endLine: comment.Position.Line + strings.Count(comment.Text, "\n"),
+			comment: comment,
+		})
+	}
+	sort.SliceStable(items, func(i, j int) bool { return items[i].offset < items[j].offset })
+
+	for i, it := range items {
+		if i > 0 {
+			prev := items[i-1]
+
+			// A comment on the last line of the declaration before it
+			// trails that declaration, which stays the previous item
+			if it.comment != nil && prev.comment == nil && it.line == prev.endLine {
+				p.trail(it.comment.Text)
+				items[i] = prev
+				continue
+			}
+			if blankLineBetween(prev, it) {
+				p.line("")
+			}
+		}
+
+		switch node := it.node.(type) {
+		case nil:
+			if leadsDecl(items, i) {
+				p.lead(it.comment.Text)
+				continue
+			}
+			p.line(it.comment.Text)
+		case *ast.PackageDecl:
+			p.line("package " + node.Name.Name)
+		case *ast.ImportDecl:
+			if node.Name != nil {
+				p.line("import " + node.Name.Name + " " + node.Path.Token.Lexeme)
+			} else {
+				p.line("import " + node.Path.Token.Lexeme)
+			}
+		case ast.Decl:
+			_ = node.Accept(p)
+			// What the declaration had no line for goes after it
+			p.commentsBefore(node.End().Line + 1)
+		}
+	}
+}
+
+// leadsDecl reports whether the comment items[i] comes before a declaration
+// on the line the declaration starts on, alone or with other comments in
+// between ("/* a */ /* b */ var x int;").
+func leadsDecl(items []item, i int) bool {
+	for j := i + 1; j < len(items); j++ {
+		if items[j].line != items[j-1].endLine {
+			return false
+		}
+		if items[j].node != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// insideDecl reports whether comment lies within one of the declarations of
+// file rather than between them.
+func insideDecl(file *ast.File, comment *ast.Comment) bool {
+	offset := comment.Position.Offset
+	for _, decl := range file.Decls {
+		if decl != nil && decl.Pos().Offset < offset && offset < decl.End().Offset {
+			return true
+		}
+	}
+	return false
+}
+
+// commentsBefore writes the comments not yet written that start before line,
+// each on its own line at the current depth, and returns the last source
+// line they cover, or 0 if there were none.
+func (p *printer) commentsBefore(line int) int {
+	last := 0
+	for len(p.comments) > 0 && p.comments[0].Position.Line < line {
+		comment := p.comments[0]
+		p.comments = p.comments[1:]
+		p.line(comment.Text)
+		last = comment.End().Line
+	}
+	return last
+}
+
+// leadingComments holds the comments before pos on pos's line for the start
+// of the next line written, so that "/* note */ x = 1;" keeps the comment
+// in front of the code rather than moving it after.
+func (p *printer) leadingComments(pos lexer.Position) {
+	for len(p.comments) > 0 && p.comments[0].Position.Line == pos.Line && p.comments[0].Position.Offset < pos.Offset {
+		p.lead(p.comments[0].Text)
+		p.comments = p.comments[1:]
+	}
+}
+
+// lead adds a comment to those written at the start of the next line.
+func (p *printer) lead(text string) {
+	if p.leading != "" {
+		p.leading += " "
+	}
+	p.leading += text
+}
+
+// trailingComment appends the next comment to the line just written when it
+// starts on line, the source line the written code ended on.
+func (p *printer) trailingComment(line int) {
+	if len(p.comments) > 0 && p.comments[0].Position.Line == line {
+		p.trail(p.comments[0].Text)
+		p.comments = p.comments[1:]
+	}
+}
+
+// blankLineBetween decides whether consecutive top-level items are
+// separated by a blank line. Declarations always are, except that imports
+// are grouped together. A comment sticks to what follows it on the next
+// source line - it documents it - and otherwise stands on its own.
+func blankLineBetween(prev, next item) bool {
+	_, prevImport := prev.node.(*ast.ImportDecl)
+	_, nextImport := next.node.(*ast.ImportDecl)
+	switch {
+	case prevImport && nextImport:
+		return false
+	case prev.comment != nil:
+		return next.line > prev.endLine+1
+	default:
+		return true
+	}
+}
+
+// trail appends a comment to the line just written.
+func (p *printer) trail(text string) {
+	out := strings.TrimSuffix(p.sb.String(), "\n")
+	p.sb.Reset()
+	p.sb.WriteString(out + " " + text + "\n")
+}
+
+// expr renders an expression. Nil renders as nothing, which only happens
+// after error recovery.
+func (p *printer) expr(expr ast.Expr) string {
+	if expr == nil {
+		return ""
+	}
+	text, _ := expr.Accept(p)
+	s, _ := text.(string)
+	return s
+}
+
+func (p *printer) exprList(exprs []ast.Expr) string {
+	parts := make([]string, len(exprs))
+	for i, expr := range exprs {
+		parts[i] = p.expr(expr)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// stmts writes a statement list one level deeper, with the comments among
+// them, keeping one blank line wherever the source separated two statements
+// or comments by at least one. end is the line the list ends on, such as
+// its closing brace's: the comments before it are the list's last lines.
+func (p *printer) stmts(stmts []ast.Stmt, end int) {
+	p.depth++
+	// last is the source line of what was written last, 0 at the start
+	last := 0
+	comments := func(line int) {
+		for len(p.comments) > 0 && p.comments[0].Position.Line < line {
+			if last != 0 && p.comments[0].Position.Line > last+1 {
+				p.line("")
+			}
+			last = p.commentsBefore(p.comments[0].Position.Line + 1)
+		}
+	}
+	for _, stmt := range stmts {
+		if stmt == nil {
+			continue
+		}
+		comments(stmt.Pos().Line)
+		if last != 0 && stmt.Pos().Line > last+1 {
+			p.line("")
+		}
+		p.leadingComments(stmt.Pos())
+		_ = stmt.Accept(p)
+		last = stmt.End().Line
+		p.trailingComment(last)
+	}
+	comments(end)
+	p.depth--
+}
+
+// block writes the statements of a block followed by its closing brace;
+// the opening brace ends the line the caller already wrote.
+func (p *printer) block(block *ast.BlockStmt) {
+	if block != nil {
+		p.stmts(block.Statements, block.RightBrace.Position.Line)
+	}
+	p.line("}")
+}
+
+// simpleStmt renders the statement forms that fit on one line and can
+// appear in a for clause, without their semicolon.
+func (p *printer) simpleStmt(stmt ast.Stmt) string {
+	switch s := stmt.(type) {
+	case *ast.ExprStmt:
+		return p.expr(s.Expression)
+	case *ast.VarDecl:
+		return p.varDecl(s)
+	default:
+		return ""
+	}
+}
+
+// Expressions
+
+func (p *printer) VisitBinaryExpr(expr *ast.BinaryExpr) (interface{}, error) {
+	return p.expr(expr.Left) + " " + expr.Operator.Lexeme + " " + p.expr(expr.Right), nil
+}
+
+func (p *printer) VisitUnaryExpr(expr *ast.UnaryExpr) (interface{}, error) {
+	operand := p.expr(expr.Operand)
+	if expr.IsPostfix {
+		return operand + expr.Operator.Lexeme, nil
+	}
+	// - -x must not come out as --x, which lexes as a decrement, nor & &x
+	// as &&x. (**p is fine: the parser reads ** before an operand as two *)
+	op := expr.Operator.Lexeme
+	if strings.HasPrefix(operand, op[len(op)-1:]) && strings.ContainsAny(op, "+-&") {
+		return op + " " + operand, nil
+	}
+	return op + operand, nil
+}
+
+func (p *printer) VisitLiteralExpr(expr *ast.LiteralExpr) (interface{}, error) {
+	return expr.Token.Lexeme, nil
+}
+
+func (p *printer) VisitIdentifierExpr(expr *ast.IdentifierExpr) (interface{}, error) {
+	if expr == nil {
+		// A name, member or type that error recovery left out
+		return "", nil
+	}
+	return expr.Name, nil
+}
+
+func (p *printer) VisitCallExpr(expr *ast.CallExpr) (interface{}, error) {
+	return p.expr(expr.Callee) + "(" + p.exprList(expr.Args) + ")", nil
+}
+
+func (p *printer) VisitIndexExpr(expr *ast.IndexExpr) (interface{}, error) {
+	return p.expr(expr.Object) + "[" + p.expr(expr.Index) + "]", nil
+}
+
+func (p *printer) VisitMemberExpr(expr *ast.MemberExpr) (interface{}, error) {
+	return p.expr(expr.Object) + "." + p.expr(expr.Member), nil
+}
+
+func (p *printer) VisitAssignmentExpr(expr *ast.AssignmentExpr) (interface{}, error) {
+	return p.expr(expr.Target) + " " + expr.Operator.Lexeme + " " + p.expr(expr.Value), nil
+}
+
+func (p *printer) VisitLogicalExpr(expr *ast.LogicalExpr) (interface{}, error) {
+	return p.expr(expr.Left) + " " + expr.Operator.Lexeme + " " + p.expr(expr.Right), nil
+}
+
+func (p *printer) VisitGroupingExpr(expr *ast.GroupingExpr) (interface{}, error) {
+	return "(" + p.expr(expr.Expression) + ")", nil
+}
+
+func (p *printer) VisitArrayLiteralExpr(expr *ast.ArrayLiteralExpr) (interface{}, error) {
+	return "[" + p.exprList(expr.Elements) + "]", nil
+}
+
+func (p *printer) VisitStructLiteralExpr(expr *ast.StructLiteralExpr) (interface{}, error) {
+	fields := make([]string, 0, len(expr.Fields))
+	for _, field := range expr.Fields {
+		switch {
+		case field == nil:
+		case field.Name == nil:
+			fields = append(fields, p.expr(field.Value))
+		default:
+			fields = append(fields, p.expr(field.Name)+": "+p.expr(field.Value))
+		}
+	}
+	return p.expr(expr.TypeName) + "{" + strings.Join(fields, ", ") + "}", nil
+}
+
+// Statements
+
+func (p *printer) VisitExprStmt(stmt *ast.ExprStmt) error {
+	p.line(p.expr(stmt.Expression) + ";")
+	return nil
+}
+
+func (p *printer) VisitBlockStmt(stmt *ast.BlockStmt) error {
+	p.line("{")
+	p.block(stmt)
+	return nil
+}
+
+func (p *printer) VisitIfStmt(stmt *ast.IfStmt) error {
+	p.line("if (" + p.expr(stmt.Condition) + ") {")
+	for {
+		if stmt.ThenBranch != nil {
+			p.stmts(stmt.ThenBranch.Statements, stmt.ThenBranch.RightBrace.Position.Line)
+		}
+		switch elseBranch := stmt.ElseBranch.(type) {
+		case *ast.IfStmt:
+			// An else-if chain stays flat instead of nesting deeper
+			p.line("} else if (" + p.expr(elseBranch.Condition) + ") {")
+			stmt = elseBranch
+			continue
+		case *ast.BlockStmt:
+			p.line("} else {")
+			p.block(elseBranch)
+		default:
+			p.line("}")
+		}
+		return nil
+	}
+}
+
+func (p *printer) VisitWhileStmt(stmt *ast.WhileStmt) error {
+	p.line("while (" + p.expr(stmt.Condition) + ") {")
+	p.block(stmt.Body)
+	return nil
+}
+
+func (p *printer) VisitForStmt(stmt *ast.ForStmt) error {
+	header := "for (" + p.simpleStmt(stmt.Init) + ";"
+	if stmt.Condition != nil {
+		header += " " + p.expr(stmt.Condition)
+	}
+	header += ";"
+	if stmt.Post != nil {
+		header += " " + p.simpleStmt(stmt.Post)
+	}
+	p.line(header + ") {")
+	p.block(stmt.Body)
+	return nil
+}
+
+func (p *printer) VisitReturnStmt(stmt *ast.ReturnStmt) error {
+	if stmt.Value == nil {
+		p.line("return;")
+	} else {
+		p.line("return " + p.expr(stmt.Value) + ";")
+	}
+	return nil
+}
+
+func (p *printer) VisitBreakStmt(stmt *ast.BreakStmt) error {
+	p.line("break;")
+	return nil
+}
+
+func (p *printer) VisitContinueStmt(stmt *ast.ContinueStmt) error {
+	p.line("continue;")
+	return nil
+}
+
+// VisitSwitchStmt lines case labels up with the switch, as gofmt does, so
+// the bodies sit one level in rather than two.
+func (p *printer) VisitSwitchStmt(stmt *ast.SwitchStmt) error {
+	p.line("switch (" + p.expr(stmt.Value) + ") {")
+	for i, clause := range stmt.Cases {
+		if clause == nil {
+			continue
+		}
+		p.commentsBefore(clause.Pos().Line)
+		p.leadingComments(clause.Pos())
+		if clause.IsDefault {
+			p.line("default:")
+		} else {
+			p.line("case " + p.exprList(clause.Values) + ":")
+		}
+		// A clause runs until the next one starts
+		end := stmt.End().Line
+		for _, next := range stmt.Cases[i+1:] {
+			if next != nil {
+				end = next.Pos().Line
+				break
+			}
+		}
+		p.stmts(clause.Body, end)
+	}
+	p.commentsBefore(stmt.End().Line)
+	p.line("}")
+	return nil
+}
+
+// Declarations
+
+func (p *printer) varDecl(decl *ast.VarDecl) string {
+	names := make([]string, len(decl.Names))
+	for i, name := range decl.Names {
+		names[i] = p.expr(name)
+	}
+	text := "var " + strings.Join(names, ", ")
+	if decl.Type != nil {
+		text += " " + p.expr(decl.Type)
+	}
+	if decl.Initializer != nil {
+		text += " = " + p.expr(decl.Initializer)
+	}
+	return text
+}
+
+func (p *printer) VisitVarDecl(decl *ast.VarDecl) error {
+	p.line(p.varDecl(decl) + ";")
+	return nil
+}
+
+func (p *printer) VisitFuncDecl(decl *ast.FuncDecl) error {
+	params := make([]string, 0, len(decl.Params))
+	for _, param := range decl.Params {
+		if param != nil {
+			params = append(params, p.expr(param.Name)+" "+p.expr(param.Type))
+		}
+	}
+	header := fmt.Sprintf("func %s(%s)", p.expr(decl.Name), strings.Join(params, ", "))
+	if decl.ReturnType != nil {
+		header += " " + p.expr(decl.ReturnType)
+	}
+	p.line(header + " {")
+	p.block(decl.Body)
+	return nil
+}
+
+func (p *printer) VisitTypeDecl(decl *ast.TypeDecl) error {
+	if decl.IsAlias {
+		p.line("type " + p.expr(decl.Name) + " = " + p.expr(decl.Type) + ";")
+	} else {
+		p.line("type " + p.expr(decl.Name) + " " + p.expr(decl.Type) + ";")
+	}
+	return nil
+}
+
+func (p *printer) VisitStructDecl(decl *ast.StructDecl) error {
+	p.line("struct " + p.expr(decl.Name) + " {")
+	p.depth++
+	for _, field := range decl.Fields {
+		if field != nil {
+			p.commentsBefore(field.Pos().Line)
+			p.leadingComments(field.Pos())
+			p.line(p.expr(field.Name) + " " + p.expr(field.Type) + ";")
+			p.trailingComment(field.End().Line)
+		}
+	}
+	p.commentsBefore(decl.RightBrace.Position.Line)
+	p.depth--
+	p.line("}")
+	return nil
+}
+
+// Compile-time check that printer handles every node kind.
+var _ ast.Visitor = (*printer)(nil)
@@ -0,0 +1,147 @@
+package formatter
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hassan/compiler/internal/lexer"
+	"github.com/hassan/compiler/internal/parser"
+	"github.com/hassan/compiler/internal/parser/ast"
+)
+
+// update rewrites golden files with the current output instead of comparing.
+var update = flag.Bool("update", false, "update golden files")
+
+// parse parses source, failing the test if it doesn't parse cleanly.
+func parse(t *testing.T, source, filename string) *ast.File {
+	t.Helper()
+	file, errs := parser.New(lexer.New(source, filename)).ParseFile(filename)
+	for _, err := range errs {
+		t.Errorf("parsing %s: %v", filename, err)
+	}
+	if len(errs) > 0 {
+		t.FailNow()
+	}
+	return file
+}
+
+func format(t *testing.T, source string) string {
+	t.Helper()
+	return New().Format(parse(t, source, "input.src"))
+}
+
+func TestFormat_Golden(t *testing.T) {
+	goldens, err := filepath.Glob("testdata/*.golden")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(goldens) == 0 {
+		t.Fatal("no golden files found")
+	}
+
+	for _, golden := range goldens {
+		path := strings.TrimSuffix(golden, ".golden") + ".src"
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			source, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := format(t, string(source))
+
+			if *update {
+				if err := os.WriteFile(golden, []byte(got), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != string(want) {
+				t.Errorf("output differs from %s (run with -update to accept):\ngot:\n%s\nwant:\n%s", golden, got, want)
+			}
+		})
+	}
+}
+
+// TestFormat_Idempotent formats every test program, formats the result
+// again, and requires the second pass to change nothing.
+func TestFormat_Idempotent(t *testing.T) {
+	var paths []string
+	for _, pattern := range []string{"testdata/*.src", "../../testdata/valid/*.src"} {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, matches...)
+	}
+
+	for _, path := range paths {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			source, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			once := format(t, string(source))
+			twice := format(t, once)
+			if once != twice {
+				t.Errorf("formatting is not idempotent:\nfirst pass:\n%s\nsecond pass:\n%s", once, twice)
+			}
+		})
+	}
+}
+
+func TestFormat_Layout(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{
+			name:   "spacing",
+			source: "package main\nfunc f(a int,b int) int{return a*b+-a;}",
+			want:   "package main\n\nfunc f(a int, b int) int {\n    return a * b + -a;\n}\n",
+		},
+		{
+			name:   "double negation keeps a space",
+			source: "package main\nfunc f(a int) int { return - -a; }",
+			want:   "package main\n\nfunc f(a int) int {\n    return - -a;\n}\n",
+		},
+		{
+			name:   "blank lines between statements collapse to one",
+			source: "package main\nfunc f() {\n    g();\n\n\n\n    g();\n    g();\n}\n",
+			want:   "package main\n\nfunc f() {\n    g();\n\n    g();\n    g();\n}\n",
+		},
+		{
+			name:   "comments",
+			source: "package main\n// doc for f\nfunc f() {\n}\nvar x int; // trailing\n\n\n// standalone\n\nvar y int;\n",
+			want:   "package main\n\n// doc for f\nfunc f() {\n}\n\nvar x int; // trailing\n\n// standalone\n\nvar y int;\n",
+		},
+		{
+			name: "comments in bodies",
+			source: "package main\nstruct P {\n// first\nx int; // across\n\n/* last */\n}\n" +
+				"func f(a int, // the input\nb int) int {\n// before\ng(); // after\n\n\n// between\n" +
+				"if (a > b) {\nreturn a;\n// dead end\n}\nswitch (a) {\n// one\ncase 1:\ng();\n// still one\n" +
+				"default:\n}\nreturn b;\n// at the end\n}\n",
+			want: "package main\n\nstruct P {\n    // first\n    x int; // across\n    /* last */\n}\n\n" +
+				"func f(a int, b int) int {\n    // the input\n\n    // before\n    g(); // after\n\n    // between\n" +
+				"    if (a > b) {\n        return a;\n        // dead end\n    }\n    switch (a) {\n    // one\n    case 1:\n        g();\n        // still one\n" +
+				"    default:\n    }\n    return b;\n    // at the end\n}\n",
+		},
+		{
+			name:   "for clauses",
+			source: "package main\nfunc f() { for (;;) { break; } for (var i int = 0; i < 3;) { i += 1; } }",
+			want:   "package main\n\nfunc f() {\n    for (;;) {\n        break;\n    }\n    for (var i int = 0; i < 3;) {\n        i += 1;\n    }\n}\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := format(t, tt.source); got != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,784 @@
+// Package interp executes IR directly.
+//
+// WHY AN INTERPRETER:
+// Until there is a native backend, the only way to find out what a compiled
+// program does is to read its IR. An interpreter closes that loop: tests can
+// compile a program, run it, and compare the result with what the source
+// says it should be, which is how optimizer and builder bugs that produce
+// well-formed but wrong IR get caught.
+//
+// DESIGN CHOICE: Interpret the IR rather than the AST. Walking the AST would
+// be simpler, but it would test the front end only; running the IR exercises
+// the builder and every optimization pass too, and it can run the IR before
+// and after optimization to check they agree.
+//
+// EXECUTION MODEL:
+//   - Each call gets a frame mapping IR values (by pointer identity, like the
+//     rest of the IR tooling) to runtime values
+//   - A block executes instruction by instruction until its terminator picks
+//     the next block; phis at the top of a block read the edge just taken
+//   - Calls recurse on the Go stack, bounded by MaxCallDepth so runaway
+//     recursion is a runtime error rather than a crash
+package interp
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/hassan/compiler/internal/ir"
+	"github.com/hassan/compiler/internal/semantic/types"
+)
+
+// DefaultMaxCallDepth bounds recursion unless Interpreter.MaxCallDepth is set.
+const DefaultMaxCallDepth = 10000
+
+// Interpreter executes the functions of one module.
+type Interpreter struct {
+	// Stdout receives anything the program prints.
+	Stdout io.Writer
+
+	// MaxCallDepth is the deepest the call stack may grow.
+	MaxCallDepth int
+
+	module    *ir.Module
+	functions map[string]*ir.Function
+	globals   map[*ir.Value]Value
+	stack     []*frame
+}
+
+// frame is the activation record of one call.
+type frame struct {
+	fn     *ir.Function
+	block  *ir.BasicBlock
+	instr  ir.Instruction
+	values map[*ir.Value]Value
+}
+
+// RuntimeError is a failure while executing the program, such as a division
+// by zero. It records where execution was when it happened.
+type RuntimeError struct {
+	Message     string
+	Function    string
+	Block       string
+	Instruction string
+
+	// Stack lists the active functions, innermost first.
+	Stack []string
+}
+
+// Error implements the error interface.
+//
+// Format: "runtime error: message (in main, block entry: t1 = a / b)"
+func (e *RuntimeError) Error() string {
+	if e.Function == "" {
+		return "runtime error: " + e.Message
+	}
+	where := fmt.Sprintf("in %s, block %s", e.Function, e.Block)
+	if e.Instruction != "" {
+		where += ": " + e.Instruction
+	}
+	return fmt.Sprintf("runtime error: %s (%s)", e.Message, where)
+}
+
+// New creates an interpreter for module. Output goes to os.Stdout unless
+// Stdout is changed.
+func New(module *ir.Module) *Interpreter {
+	functions := make(map[string]*ir.Function, len(module.Functions))
+	for _, fn := range module.Functions {
+		functions[fn.Name] = fn
+	}
+	return &Interpreter{
+		Stdout:       os.Stdout,
+		MaxCallDepth: DefaultMaxCallDepth,
+		module:       module,
+		functions:    functions,
+	}
+}
+
+// initialValue returns the value global starts with: its initializer, or
+// the zero value of its type.
+func initialValue(global *ir.Value) Value {
+	if global.Initializer != nil {
+		return global.Initializer.Constant
+	}
+	return Zero(global.Type)
+}
+
+// Run calls the function named entry with args and returns its result (nil
+// for a void function).
+//
+// Globals start from their initial values on every Run, so running the
+// same module twice gives the same answer.
+func (in *Interpreter) Run(entry string, args []Value) (Value, error) {
+	in.globals = nil
+	return in.Call(entry, args)
+}
+
+// Call is Run without starting over: globals keep the values earlier calls
+// left in them. Functions and globals added to the module since the last
+// call are picked up, the new globals with their initial values.
+//
+// This is how the REPL runs one line after another against the same state.
+func (in *Interpreter) Call(entry string, args []Value) (Value, error) {
+	in.functions = make(map[string]*ir.Function, len(in.module.Functions))
+	for _, fn := range in.module.Functions {
+		in.functions[fn.Name] = fn
+	}
+	fn, ok := in.functions[entry]
+	if !ok {
+		return nil, &RuntimeError{Message: fmt.Sprintf("no function %s in module %s", entry, in.module.Name)}
+	}
+	if len(args) != len(fn.Parameters) {
+		return nil, &RuntimeError{Message: fmt.Sprintf(
+			"%s takes %d arguments, got %d", entry, len(fn.Parameters), len(args))}
+	}
+
+	if in.globals == nil {
+		in.globals = make(map[*ir.Value]Value, len(in.module.Globals))
+	}
+	for _, global := range in.module.Globals {
+		if _, ok := in.globals[global]; !ok {
+			in.globals[global] = initialValue(global)
+		}
+	}
+	in.stack = nil
+
+	return in.call(fn, args)
+}
+
+// fault creates a RuntimeError located at the current instruction.
+func (in *Interpreter) fault(format string, args ...interface{}) error {
+	err := &RuntimeError{Message: fmt.Sprintf(format, args...)}
+	if len(in.stack) == 0 {
+		return err
+	}
+	top := in.stack[len(in.stack)-1]
+	err.Function = top.fn.Name
+	if top.block != nil {
+		err.Block = top.block.Label
+	}
+	if top.instr != nil {
+		err.Instruction = top.instr.String()
+	}
+	for i := len(in.stack) - 1; i >= 0; i-- {
+		err.Stack = append(err.Stack, in.stack[i].fn.Name)
+	}
+	return err
+}
+
+// call executes fn in a new frame.
+func (in *Interpreter) call(fn *ir.Function, args []Value) (Value, error) {
+	if len(in.stack) >= in.MaxCallDepth {
+		return nil, in.fault("stack overflow calling %s (depth %d)", fn.Name, len(in.stack))
+	}
+
+	fr := &frame{fn: fn, values: make(map[*ir.Value]Value)}
+	for i, param := range fn.Parameters {
+		fr.values[param] = args[i]
+	}
+	in.stack = append(in.stack, fr)
+	defer func() { in.stack = in.stack[:len(in.stack)-1] }()
+
+	var prev *ir.BasicBlock
+	block := fn.Entry
+	for {
+		fr.block = block
+		fr.instr = nil
+
+		// Phis are evaluated together, before anything else in the block,
+		// so one phi reading another's destination sees the old value.
+		start, err := in.execPhis(fr, prev)
+		if err != nil {
+			return nil, err
+		}
+
+		var next *ir.BasicBlock
+		for _, instr := range block.Instructions[start:] {
+			fr.instr = instr
+			switch i := instr.(type) {
+			case *ir.Jump:
+				next = i.Target
+
+			case *ir.Branch:
+				cond, err := in.value(fr, i.Condition)
+				if err != nil {
+					return nil, err
+				}
+				b, ok := cond.(bool)
+				if !ok {
+					return nil, in.fault("branch condition is %s, not bool", Format(cond))
+				}
+				if b {
+					next = i.TrueBlock
+				} else {
+					next = i.FalseBlock
+				}
+
+			case *ir.Return:
+				if i.Value == nil {
+					return nil, nil
+				}
+				return in.value(fr, i.Value)
+
+			default:
+				if err := in.exec(fr, instr); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			break
+		}
+
+		if next == nil {
+			fr.instr = nil
+			return nil, in.fault("fell off the end of block %s", block.Label)
+		}
+		prev, block = block, next
+	}
+}
+
+// execPhis assigns the phis at the top of the current block for the edge
+// from prev, and returns the index of the first non-phi instruction.
+func (in *Interpreter) execPhis(fr *frame, prev *ir.BasicBlock) (int, error) {
+	var phis []*ir.Phi
+	for _, instr := range fr.block.Instructions {
+		phi, ok := instr.(*ir.Phi)
+		if !ok {
+			break
+		}
+		phis = append(phis, phi)
+	}
+
+	incoming := make([]Value, len(phis))
+	for i, phi := range phis {
+		fr.instr = phi
+		found := false
+		for _, inc := range phi.Incomig {
+			if inc.Block == prev {
+				v, err := in.value(fr, inc.Value)
+				if err != nil {
+					return 0, err
+				}
+				incoming[i], found = v, true
+				break
+			}
+		}
+		if !found {
+			from := "function entry"
+			if prev != nil {
+				from = prev.Label
+			}
+			return 0, in.fault("phi has no incoming value from %s", from)
+		}
+	}
+	for i, phi := range phis {
+		in.assign(fr, phi.Dest, incoming[i])
+	}
+	return len(phis), nil
+}
+
+// exec executes one non-terminator instruction.
+func (in *Interpreter) exec(fr *frame, instr ir.Instruction) error {
+	switch i := instr.(type) {
+	case *ir.BinaryOp:
+		left, err := in.value(fr, i.Left)
+		if err != nil {
+			return err
+		}
+		right, err := in.value(fr, i.Right)
+		if err != nil {
+			return err
+		}
+		var result Value
+		var msg string
+		if types.IsUnsigned64(i.Left.Type) {
+			result, msg = unsignedBinary(i.Op, left, right)
+		} else {
+			result, msg = binary(i.Op, left, right)
+		}
+		if msg != "" {
+			return in.fault("%s", msg)
+		}
+		in.assign(fr, i.Dest, result)
+
+	case *ir.UnaryOp:
+		operand, err := in.value(fr, i.Operand)
+		if err != nil {
+			return err
+		}
+		result, msg := unary(i.Op, operand)
+		if msg != "" {
+			return in.fault("%s", msg)
+		}
+		in.assign(fr, i.Dest, result)
+
+	case *ir.Copy:
+		v, err := in.value(fr, i.Value)
+		if err != nil {
+			return err
+		}
+		in.assign(fr, i.Dest, copyValue(v))
+
+	case *ir.Alloca:
+		slot := Zero(i.Type)
+		in.assign(fr, i.Dest, &Pointer{slot: &slot})
+
+	case *ir.Load:
+		ptr, err := in.pointer(fr, i.Address)
+		if err != nil {
+			return err
+		}
+		in.assign(fr, i.Dest, copyValue(ptr.Load()))
+
+	case *ir.Store:
+		ptr, err := in.pointer(fr, i.Address)
+		if err != nil {
+			return err
+		}
+		v, err := in.value(fr, i.Value)
+		if err != nil {
+			return err
+		}
+		ptr.Store(copyValue(v))
+
+	case *ir.GetElementPtr:
+		base, err := in.aggregate(fr, i.Base)
+		if err != nil {
+			return err
+		}
+		index, err := in.value(fr, i.Index)
+		if err != nil {
+			return err
+		}
+		slot, msg := element(base, index)
+		if msg != "" {
+			return in.fault("%s", msg)
+		}
+		in.assign(fr, i.Dest, &Pointer{slot: slot})
+
+	case *ir.GetFieldPtr:
+		base, err := in.aggregate(fr, i.Base)
+		if err != nil {
+			return err
+		}
+		s, ok := base.(*Struct)
+		if !ok {
+			return in.fault("cannot select a field of %s", Format(base))
+		}
+		if i.FieldIndex < 0 || i.FieldIndex >= len(s.Fields) {
+			return in.fault("field index %d out of range for %s", i.FieldIndex, Format(s))
+		}
+		in.assign(fr, i.Dest, &Pointer{slot: &s.Fields[i.FieldIndex]})
+
+	case *ir.Call:
+		return in.execCall(fr, i)
+
+	default:
+		return in.fault("cannot execute %T", instr)
+	}
+	return nil
+}
+
+// execCall evaluates the arguments and calls the named function. Arguments
+// are passed by value: the callee gets its own copy of a struct or array.
+// Builtins only read theirs, so they get the values themselves.
+func (in *Interpreter) execCall(fr *frame, call *ir.Call) error {
+	args := make([]Value, len(call.Args))
+	for i, arg := range call.Args {
+		v, err := in.value(fr, arg)
+		if err != nil {
+			return err
+		}
+		args[i] = v
+	}
+	if name := call.Builtin(); name != "" {
+		// A uint64 is held like an int; print needs to know it isn't one
+		for i, arg := range call.Args {
+			if n, ok := args[i].(int64); ok && types.IsUnsigned64(arg.Type) {
+				args[i] = uint64(n)
+			}
+		}
+		result, err := in.execBuiltin(call, name, args)
+		if err != nil {
+			return err
+		}
+		if call.Dest != nil {
+			in.assign(fr, call.Dest, result)
+		}
+		return nil
+	}
+
+	callee, err := in.value(fr, call.Function)
+	if err != nil {
+		return err
+	}
+	ref, ok := callee.(funcRef)
+	if !ok {
+		return in.fault("cannot call %s", Format(callee))
+	}
+
+	fn, ok := in.functions[ref.name]
+	if !ok {
+		return in.fault("call to undefined function %s", ref.name)
+	}
+	if len(args) != len(fn.Parameters) {
+		return in.fault("%s takes %d arguments, got %d", fn.Name, len(fn.Parameters), len(args))
+	}
+	for i := range args {
+		args[i] = copyValue(args[i])
+	}
+	result, err := in.call(fn, args)
+	if err != nil {
+		return err
+	}
+	if call.Dest != nil {
+		in.assign(fr, call.Dest, result)
+	}
+	return nil
+}
+
+// execBuiltin runs a call to a builtin function and returns its result, nil
+// for the builtins that return nothing.
+func (in *Interpreter) execBuiltin(call *ir.Call, name string, args []Value) (Value, error) {
+	switch name {
+	case "print", "println":
+		var text strings.Builder
+		for _, arg := range args {
+			text.WriteString(Format(arg))
+		}
+		if name == "println" {
+			text.WriteByte('\n')
+		}
+		if _, err := io.WriteString(in.Stdout, text.String()); err != nil {
+			return nil, in.fault("%s: %v", name, err)
+		}
+		return nil, nil
+	case "len", "cap":
+		if len(args) != 1 {
+			return nil, in.fault("%s takes 1 argument, got %d", name, len(args))
+		}
+		switch v := args[0].(type) {
+		case string:
+			return int64(len(v)), nil
+		case *Array:
+			return int64(len(v.Elems)), nil
+		}
+		return nil, in.fault("cannot take the %s of %s", name, Format(args[0]))
+	case "new":
+		// The T to allocate is what the result, a *T, points at
+		if call.Dest == nil {
+			return nil, in.fault("new must produce a pointer")
+		}
+		pointer, ok := types.Underlying(call.Dest.Type).(*types.PointerType)
+		if !ok {
+			return nil, in.fault("new must produce a pointer, not %s", call.Dest.Type)
+		}
+		slot := Zero(pointer.Elem)
+		return &Pointer{slot: &slot}, nil
+	case "checkIndex":
+		// checkIndex(array, index), where array may be its address
+		if len(args) != 2 {
+			return nil, in.fault("checkIndex takes 2 arguments, got %d", len(args))
+		}
+		base := args[0]
+		if ptr, ok := base.(*Pointer); ok {
+			base = ptr.Load()
+		}
+		index := args[1]
+		if n, ok := index.(uint64); ok {
+			index = int64(n)
+		}
+		if _, msg := element(base, index); msg != "" {
+			return nil, in.fault("%s", msg)
+		}
+		return nil, nil
+	default:
+		return nil, in.fault("unknown builtin %s", name)
+	}
+}
+
+// value returns the runtime value of an IR operand.
+func (in *Interpreter) value(fr *frame, v *ir.Value) (Value, error) {
+	if v == nil {
+		return nil, nil
+	}
+	if v.Kind == ir.ValueConstant {
+		return v.Constant, nil
+	}
+	if val, ok := fr.values[v]; ok {
+		return val, nil
+	}
+	if val, ok := in.globals[v]; ok {
+		return val, nil
+	}
+
+	// The builder references functions by name with a fresh, ID-less value
+	if v.ID == -1 && v.Kind == ir.ValueVariable {
+		if _, ok := in.functions[v.Name]; ok {
+			return funcRef{name: v.Name}, nil
+		}
+		return nil, in.fault("undefined function %s", v.Name)
+	}
+
+	// A variable declared without an initializer holds its zero value
+	return Zero(v.Type), nil
+}
+
+// assign stores a runtime value into an IR destination.
+func (in *Interpreter) assign(fr *frame, dest *ir.Value, v Value) {
+	if dest == nil {
+		return
+	}
+	if _, ok := in.globals[dest]; ok {
+		in.globals[dest] = v
+		return
+	}
+	fr.values[dest] = v
+}
+
+// pointer evaluates an operand that must be an address.
+func (in *Interpreter) pointer(fr *frame, v *ir.Value) (*Pointer, error) {
+	val, err := in.value(fr, v)
+	if err != nil {
+		return nil, err
+	}
+	if val == nil {
+		// The zero value of a pointer field or element never assigned
+		return nil, in.fault("nil pointer dereference")
+	}
+	ptr, ok := val.(*Pointer)
+	if !ok {
+		return nil, in.fault("%s is not an address", Format(val))
+	}
+	return ptr, nil
+}
+
+// aggregate evaluates the base of an element or field address, which may
+// be the aggregate itself or an address holding it.
+func (in *Interpreter) aggregate(fr *frame, v *ir.Value) (Value, error) {
+	val, err := in.value(fr, v)
+	if err != nil {
+		return nil, err
+	}
+	if val == nil {
+		return nil, in.fault("nil pointer dereference")
+	}
+	if ptr, ok := val.(*Pointer); ok {
+		return ptr.Load(), nil
+	}
+	return val, nil
+}
+
+// element returns the slot of element index of the array base. A non-empty
+// message reports a runtime error; the caller adds the location.
+func element(base, index Value) (*Value, string) {
+	arr, ok := base.(*Array)
+	if !ok {
+		return nil, fmt.Sprintf("cannot index %s", Format(base))
+	}
+	n, ok := index.(int64)
+	if !ok {
+		return nil, fmt.Sprintf("array index is %s, not int", Format(index))
+	}
+	if n < 0 || n >= int64(len(arr.Elems)) {
+		return nil, fmt.Sprintf("index %d out of range [0:%d]", n, len(arr.Elems))
+	}
+	return &arr.Elems[n], ""
+}
+
+// binary applies a binary operator. A non-empty message reports a runtime
+// error; the caller adds the location.
+func binary(op ir.BinaryOperator, left, right Value) (Value, string) {
+	invalid := fmt.Sprintf("invalid operation: %s %s %s", describe(left), op, describe(right))
+
+	switch l := left.(type) {
+	case int64:
+		r, ok := right.(int64)
+		if !ok {
+			return nil, invalid
+		}
+		switch op {
+		case ir.OpAdd:
+			return l + r, ""
+		case ir.OpSub:
+			return l - r, ""
+		case ir.OpMul:
+			return l * r, ""
+		case ir.OpDiv, ir.OpMod:
+			if r == 0 {
+				return nil, "integer division by zero"
+			}
+			if op == ir.OpDiv {
+				return l / r, ""
+			}
+			return l % r, ""
+		case ir.OpBitAnd:
+			return l & r, ""
+		case ir.OpBitOr:
+			return l | r, ""
+		case ir.OpBitXor:
+			return l ^ r, ""
+		case ir.OpShl, ir.OpShr:
+			if r < 0 {
+				return nil, fmt.Sprintf("negative shift amount %d", r)
+			}
+			if op == ir.OpShl {
+				return l << uint64(r), ""
+			}
+			return l >> uint64(r), ""
+		}
+		return compare(op, l < r, l == r, invalid)
+
+	case float64:
+		r, ok := right.(float64)
+		if !ok {
+			return nil, invalid
+		}
+		// Float division by zero is not an error: it yields ±Inf or NaN,
+		// as IEEE 754 (and Go) define it.
+		switch op {
+		case ir.OpAdd:
+			return l + r, ""
+		case ir.OpSub:
+			return l - r, ""
+		case ir.OpMul:
+			return l * r, ""
+		case ir.OpDiv:
+			return l / r, ""
+		}
+		return compare(op, l < r, l == r, invalid)
+
+	case rune:
+		r, ok := right.(rune)
+		if !ok {
+			return nil, invalid
+		}
+		switch op {
+		case ir.OpAdd:
+			return l + r, ""
+		case ir.OpSub:
+			return l - r, ""
+		}
+		return compare(op, l < r, l == r, invalid)
+
+	case string:
+		r, ok := right.(string)
+		if !ok {
+			return nil, invalid
+		}
+		if op == ir.OpAdd {
+			return l + r, ""
+		}
+		return compare(op, l < r, l == r, invalid)
+
+	case bool:
+		r, ok := right.(bool)
+		if !ok {
+			return nil, invalid
+		}
+		switch op {
+		case ir.OpAnd:
+			return l && r, ""
+		case ir.OpOr:
+			return l || r, ""
+		case ir.OpEq:
+			return l == r, ""
+		case ir.OpNeq:
+			return l != r, ""
+		}
+	}
+	return nil, invalid
+}
+
+// unsignedBinary applies a binary operator to uint64 operands. Their values
+// are held as the int64 with the same bits, so only the operators whose
+// result depends on the sign bit differ from binary: the rest wrap the same.
+func unsignedBinary(op ir.BinaryOperator, left, right Value) (Value, string) {
+	l, lok := left.(int64)
+	r, rok := right.(int64)
+	if !lok || !rok {
+		return binary(op, left, right)
+	}
+	ul, ur := uint64(l), uint64(r)
+	switch op {
+	case ir.OpDiv, ir.OpMod:
+		if r == 0 {
+			return nil, "integer division by zero"
+		}
+		if op == ir.OpDiv {
+			return int64(ul / ur), ""
+		}
+		return int64(ul % ur), ""
+	case ir.OpShr:
+		// A shift count is read as an int, whatever its type
+		if r < 0 {
+			return nil, fmt.Sprintf("negative shift amount %d", r)
+		}
+		return int64(ul >> ur), ""
+	case ir.OpLt, ir.OpLe, ir.OpGt, ir.OpGe:
+		return compare(op, ul < ur, ul == ur, "")
+	}
+	return binary(op, left, right)
+}
+
+// compare implements the six comparison operators from "less" and "equal".
+func compare(op ir.BinaryOperator, less, equal bool, invalid string) (Value, string) {
+	switch op {
+	case ir.OpEq:
+		return equal, ""
+	case ir.OpNeq:
+		return !equal, ""
+	case ir.OpLt:
+		return less, ""
+	case ir.OpLe:
+		return less || equal, ""
+	case ir.OpGt:
+		return !less && !equal, ""
+	case ir.OpGe:
+		return !less, ""
+	default:
+		return nil, invalid
+	}
+}
+
+// unary applies a unary operator, reporting errors like binary.
+func unary(op ir.UnaryOperator, operand Value) (Value, string) {
+	switch v := operand.(type) {
+	case int64:
+		switch op {
+		case ir.OpNeg:
+			return -v, ""
+		case ir.OpBitNot:
+			return ^v, ""
+		}
+	case float64:
+		if op == ir.OpNeg {
+			return -v, ""
+		}
+	case bool:
+		if op == ir.OpNot {
+			return !v, ""
+		}
+	}
+	return nil, fmt.Sprintf("invalid operation: %s%s", op, describe(operand))
+}
+
+// describe names a value and its runtime type for error messages.
+func describe(v Value) string {
+	switch v.(type) {
+	case int64:
+		return fmt.Sprintf("%s (int)", Format(v))
+	case float64:
+		return fmt.Sprintf("%s (float)", Format(v))
+	case bool:
+		return fmt.Sprintf("%s (bool)", Format(v))
+	case string:
+		return fmt.Sprintf("%q (string)", v)
+	case rune:
+		return fmt.Sprintf("%q (char)", v)
+	default:
+		return strings.TrimSpace(Format(v))
+	}
+}
@@ -0,0 +1,274 @@
+package interp
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hassan/compiler/internal/ir"
+	"github.com/hassan/compiler/internal/semantic/types"
+	"github.com/hassan/compiler/pkg/compiler"
+)
+
+// update rewrites golden files with the current output instead of comparing.
+var update = flag.Bool("update", false, "update golden files")
+
+// compileFile compiles a testdata program at the given optimization level.
+func compileFile(t *testing.T, path string, optLevel int) *ir.Module {
+	t.Helper()
+	source, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := compiler.Compile(source, path, compiler.Options{OptLevel: optLevel})
+	if err != nil {
+		t.Fatalf("compiling %s: %v", path, err)
+	}
+	return result.Module
+}
+
+// runMain runs main and renders what the program printed followed by its
+// result, which is the format of the .golden files.
+func runMain(t *testing.T, module *ir.Module) string {
+	t.Helper()
+	var stdout bytes.Buffer
+	interp := New(module)
+	interp.Stdout = &stdout
+	result, err := interp.Run("main", nil)
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	return stdout.String() + "=> " + Format(result) + "\n"
+}
+
+// TestRun_Golden runs every testdata program that has a .golden file at each
+// optimization level: an optimization that changes the answer is a bug even
+// if the IR still verifies.
+func TestRun_Golden(t *testing.T) {
+	goldens, err := filepath.Glob("testdata/*.golden")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(goldens) == 0 {
+		t.Fatal("no golden files found")
+	}
+
+	for _, golden := range goldens {
+		source := strings.TrimSuffix(golden, ".golden") + ".src"
+		t.Run(filepath.Base(source), func(t *testing.T) {
+			unoptimized := runMain(t, compileFile(t, source, 0))
+			for _, level := range []int{1, 2} {
+				optimized := runMain(t, compileFile(t, source, level))
+				if optimized != unoptimized {
+					t.Errorf("-O%d changed the result:\nunoptimized:\n%s\noptimized:\n%s", level, unoptimized, optimized)
+				}
+			}
+
+			if *update {
+				if err := os.WriteFile(golden, []byte(unoptimized), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if unoptimized != string(want) {
+				t.Errorf("output differs from %s (run with -update to accept):\ngot:\n%s\nwant:\n%s", golden, unoptimized, want)
+			}
+		})
+	}
+}
+
+func TestRun_DivisionByZero(t *testing.T) {
+	module := compileFile(t, "testdata/divzero.src", 1)
+	_, err := New(module).Run("main", nil)
+	rtErr, ok := err.(*RuntimeError)
+	if !ok {
+		t.Fatalf("expected *RuntimeError, got %T (%v)", err, err)
+	}
+	if rtErr.Message != "integer division by zero" || rtErr.Function != "divide" || rtErr.Block != "entry" {
+		t.Errorf("unexpected error: %v", rtErr)
+	}
+	if strings.Join(rtErr.Stack, " ") != "divide main" {
+		t.Errorf("expected stack [divide main], got %v", rtErr.Stack)
+	}
+}
+
+// TestRun_UnusedTraps checks that an operation failing at run time fails at
+// every optimization level, though nothing reads its result: dead code
+// elimination must not take the error away.
+func TestRun_UnusedTraps(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		message string
+	}{
+		{"division", "var q int = 5 / z;", "integer division by zero"},
+		{"remainder", "var q int = 5 % z;", "integer division by zero"},
+		{"shift", "var q int = 1 << (z - 1);", "negative shift amount -1"},
+	}
+
+	for _, tt := range tests {
+		for _, level := range []int{0, 2} {
+			t.Run(fmt.Sprintf("%s/O%d", tt.name, level), func(t *testing.T) {
+				source := "package main\nfunc main() int {\n    var z int = 0;\n    " + tt.body + "\n    return 3;\n}\n"
+				result, err := compiler.Compile([]byte(source), "trap.src", compiler.Options{OptLevel: level})
+				if err != nil {
+					t.Fatal(err)
+				}
+				value, err := New(result.Module).Run("main", nil)
+				rtErr, ok := err.(*RuntimeError)
+				if !ok {
+					t.Fatalf("expected *RuntimeError, got %v (error %v)", value, err)
+				}
+				if rtErr.Message != tt.message {
+					t.Errorf("expected %q, got %q", tt.message, rtErr.Message)
+				}
+			})
+		}
+	}
+}
+
+func TestRun_NilPointer(t *testing.T) {
+	module := compileFile(t, "testdata/nilpointer.src", 1)
+	var out strings.Builder
+	machine := New(module)
+	machine.Stdout = &out
+	_, err := machine.Run("main", nil)
+	rtErr, ok := err.(*RuntimeError)
+	if !ok {
+		t.Fatalf("expected *RuntimeError, got %T (%v)", err, err)
+	}
+	if rtErr.Message != "nil pointer dereference" || rtErr.Function != "main" {
+		t.Errorf("unexpected error: %v", rtErr)
+	}
+	if out.String() != "1\n" {
+		t.Errorf("expected the output before the error, got %q", out.String())
+	}
+}
+
+// Hand-built IR pins down the instruction the error names: alloca a [3]int
+// and address element 3.
+func TestRun_IndexOutOfRange(t *testing.T) {
+	arrayType := &types.ArrayType{ElementType: types.Int, Size: 3}
+	fn := ir.NewFunction("main", nil, types.Int)
+	arr := fn.NewTemp(arrayType)
+	elem := fn.NewTemp(types.Int)
+	fn.Entry.AddInstruction(&ir.Alloca{Dest: arr, Type: arrayType})
+	fn.Entry.AddInstruction(&ir.GetElementPtr{
+		Dest:  elem,
+		Base:  arr,
+		Index: &ir.Value{ID: -1, Type: types.Int, Kind: ir.ValueConstant, Constant: int64(3)},
+	})
+	fn.Entry.AddInstruction(&ir.Return{Value: elem})
+
+	module := ir.NewModule("main")
+	module.AddFunction(fn)
+
+	_, err := New(module).Run("main", nil)
+	if err == nil || !strings.Contains(err.Error(), "index 3 out of range [0:3] (in main, block entry: t1 = &t0[const(3)])") {
+		t.Errorf("expected an out-of-range error with its location, got %v", err)
+	}
+}
+
+func TestRun_CheckIndex(t *testing.T) {
+	// With explicit bounds checks, the $checkIndex call fails first
+	source := []byte("package main\n\nfunc get(i int) int {\n    var a = [1, 2, 3];\n    return a[i];\n}\n\nfunc main() int {\n    return get(3);\n}\n")
+	result, err := compiler.Compile(source, "index.src", compiler.Options{BoundsChecks: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = New(result.Module).Run("main", nil)
+	rtErr, ok := err.(*RuntimeError)
+	if !ok {
+		t.Fatalf("expected *RuntimeError, got %T (%v)", err, err)
+	}
+	if rtErr.Message != "index 3 out of range [0:3]" || rtErr.Function != "get" || !strings.HasPrefix(rtErr.Instruction, "call $checkIndex") {
+		t.Errorf("unexpected error: %v", rtErr)
+	}
+
+	// A slice is checked against its current length. The language can't
+	// make one yet, so the IR is hand-built: an empty []int, indexed at 0
+	sliceType := &types.ArrayType{ElementType: types.Int, Size: -1}
+	fn := ir.NewFunction("main", nil, types.Void)
+	s := fn.NewTemp(sliceType)
+	fn.Entry.AddInstruction(&ir.Alloca{Dest: s, Type: sliceType})
+	fn.Entry.AddInstruction(&ir.Call{
+		Function: &ir.Value{ID: -1, Name: ir.BuiltinPrefix + "checkIndex", Type: types.Invalid, Kind: ir.ValueVariable},
+		Args:     []*ir.Value{s, {ID: -1, Type: types.Int, Kind: ir.ValueConstant, Constant: int64(0)}},
+	})
+	fn.Entry.AddInstruction(&ir.Return{})
+
+	module := ir.NewModule("main")
+	module.AddFunction(fn)
+
+	_, err = New(module).Run("main", nil)
+	if err == nil || !strings.Contains(err.Error(), "index 0 out of range [0:0]") {
+		t.Errorf("expected an out-of-range error for the slice, got %v", err)
+	}
+}
+
+func TestRun_StackOverflow(t *testing.T) {
+	source := []byte("package main\n\nfunc forever(n int) int {\n    return forever(n + 1);\n}\n")
+	result, err := compiler.Compile(source, "forever.src", compiler.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	interp := New(result.Module)
+	interp.MaxCallDepth = 50
+	_, err = interp.Run("forever", []Value{int64(0)})
+	if err == nil || !strings.Contains(err.Error(), "stack overflow") {
+		t.Errorf("expected a stack overflow, got %v", err)
+	}
+}
+
+func TestRun_BadEntry(t *testing.T) {
+	module := compileFile(t, "testdata/factorial.src", 0)
+	if _, err := New(module).Run("missing", nil); err == nil {
+		t.Errorf("expected an error for a missing entry point")
+	}
+	if _, err := New(module).Run("factorial", nil); err == nil {
+		t.Errorf("expected an error for a missing argument")
+	}
+	result, err := New(module).Run("factorial", []Value{int64(5)})
+	if err != nil || result != int64(120) {
+		t.Errorf("expected factorial(5) = 120, got %v (%v)", result, err)
+	}
+}
+
+func TestCall_KeepsGlobals(t *testing.T) {
+	source := []byte("package main\n\nvar count int;\n\nfunc bump() int {\n    count = count + 1;\n    return count;\n}\n")
+	result, err := compiler.Compile(source, "bump.src", compiler.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	interp := New(result.Module)
+	for want := int64(1); want <= 3; want++ {
+		if got, err := interp.Call("bump", nil); err != nil || got != want {
+			t.Errorf("call %d: expected %d, got %v (%v)", want, want, got, err)
+		}
+	}
+
+	// Run starts over from the zero values
+	if got, err := interp.Run("bump", nil); err != nil || got != int64(1) {
+		t.Errorf("expected Run to start count over, got %v (%v)", got, err)
+	}
+
+	// A function and a global added to the module after the first call
+	more := &ir.Value{ID: len(result.Module.Globals), Name: "more", Type: types.Int, Kind: ir.ValueVariable}
+	result.Module.Globals = append(result.Module.Globals, more)
+	fn := ir.NewFunction("readMore", nil, types.Int)
+	fn.Entry.AddInstruction(&ir.Return{Value: more})
+	result.Module.AddFunction(fn)
+	if got, err := interp.Call("readMore", nil); err != nil || got != int64(0) {
+		t.Errorf("expected the new global at zero, got %v (%v)", got, err)
+	}
+	if got, err := interp.Call("bump", nil); err != nil || got != int64(2) {
+		t.Errorf("expected count to carry on from Run, got %v (%v)", got, err)
+	}
+}
@@ -0,0 +1,185 @@
+package interp
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hassan/compiler/internal/semantic/types"
+)
+
+// Value is a runtime value.
+//
+// DESIGN CHOICE: Scalars are plain Go values, exactly the representation the
+// parser already uses for literal constants (ir.Value.Constant), so a
+// constant operand needs no conversion at all:
+//   - int:    int64
+//   - float:  float64
+//   - bool:   bool
+//   - string: string
+//   - char:   rune
+//   - nil and void: nil
+//
+// Aggregates and addresses are pointers to the types below. An interface is
+// less type-safe than a tagged struct, but every operation has to switch on
+// the dynamic type anyway, and Go's type switch is exactly that.
+type Value interface{}
+
+// Array is a runtime array.
+//
+// Slices (dynamic arrays) share their elements when copied; fixed-size arrays
+// are copied element by element, like structs.
+type Array struct {
+	Elems []Value
+	Slice bool
+}
+
+// Struct is a runtime struct value. Fields are stored in declaration order,
+// which is the index GetFieldPtr uses.
+type Struct struct {
+	Type   *types.StructType
+	Fields []Value
+}
+
+// Pointer is the address of a storage slot: a stack slot from Alloca, an
+// array element or a struct field.
+type Pointer struct {
+	slot *Value
+}
+
+// Load returns the value stored at the address.
+func (p *Pointer) Load() Value {
+	return *p.slot
+}
+
+// Store replaces the value stored at the address.
+//
+// A struct or fixed-size array is overwritten in place, field by field and
+// element by element, rather than replaced: a pointer to one of its fields or
+// elements (&s.x, taken before "s = t") must see the new value, as it would
+// in memory.
+func (p *Pointer) Store(v Value) {
+	storeInto(p.slot, v)
+}
+
+// storeInto writes v into slot, reusing the aggregate already there when
+// v has the same shape.
+func storeInto(slot *Value, v Value) {
+	switch val := v.(type) {
+	case *Struct:
+		if old, ok := (*slot).(*Struct); ok && len(old.Fields) == len(val.Fields) {
+			for i := range val.Fields {
+				storeInto(&old.Fields[i], val.Fields[i])
+			}
+			return
+		}
+	case *Array:
+		if old, ok := (*slot).(*Array); ok && !val.Slice && !old.Slice && len(old.Elems) == len(val.Elems) {
+			for i := range val.Elems {
+				storeInto(&old.Elems[i], val.Elems[i])
+			}
+			return
+		}
+	}
+	*slot = v
+}
+
+// funcRef is the value of a function name used as an operand.
+type funcRef struct {
+	name string
+}
+
+// Zero returns the zero value of a type: 0, 0.0, false, "", '\x00', or an
+// aggregate of zero values. Arrays of unknown size start out empty, and a
+// pointer is nil, pointing at nothing.
+func Zero(t types.Type) Value {
+	switch typ := types.Underlying(t).(type) {
+	case *types.IntType:
+		return int64(0)
+	case *types.FloatType:
+		return float64(0)
+	case *types.BoolType:
+		return false
+	case *types.StringType:
+		return ""
+	case *types.CharType:
+		return rune(0)
+	case *types.ArrayType:
+		if typ.Size < 0 {
+			return &Array{Slice: true}
+		}
+		elems := make([]Value, typ.Size)
+		for i := range elems {
+			elems[i] = Zero(typ.ElementType)
+		}
+		return &Array{Elems: elems}
+	case *types.StructType:
+		fields := make([]Value, len(typ.Fields))
+		for i, field := range typ.Fields {
+			fields[i] = Zero(field.Type)
+		}
+		return &Struct{Type: typ, Fields: fields}
+	default:
+		return nil
+	}
+}
+
+// copyValue implements assignment: structs and fixed-size arrays are values,
+// so assigning one must not alias the original.
+func copyValue(v Value) Value {
+	switch val := v.(type) {
+	case *Struct:
+		fields := make([]Value, len(val.Fields))
+		for i, field := range val.Fields {
+			fields[i] = copyValue(field)
+		}
+		return &Struct{Type: val.Type, Fields: fields}
+	case *Array:
+		if val.Slice {
+			return val
+		}
+		elems := make([]Value, len(val.Elems))
+		for i, elem := range val.Elems {
+			elems[i] = copyValue(elem)
+		}
+		return &Array{Elems: elems}
+	default:
+		return v
+	}
+}
+
+// Format renders a value the way the language would print it: strings and
+// chars without quotes, aggregates in literal-like syntax.
+func Format(v Value) string {
+	switch val := v.(type) {
+	case nil:
+		return "nil"
+	case rune:
+		return string(val)
+	case *Array:
+		parts := make([]string, len(val.Elems))
+		for i, elem := range val.Elems {
+			parts[i] = Format(elem)
+		}
+		return "[" + strings.Join(parts, ", ") + "]"
+	case *Struct:
+		parts := make([]string, len(val.Fields))
+		for i, field := range val.Fields {
+			name := fmt.Sprintf("field%d", i)
+			if val.Type != nil && i < len(val.Type.Fields) {
+				name = val.Type.Fields[i].Name
+			}
+			parts[i] = name + ": " + Format(field)
+		}
+		typeName := ""
+		if val.Type != nil {
+			typeName = val.Type.Name
+		}
+		return typeName + "{" + strings.Join(parts, ", ") + "}"
+	case *Pointer:
+		return fmt.Sprintf("&%s", Format(val.Load()))
+	case funcRef:
+		return "func " + val.name
+	default:
+		return fmt.Sprint(val)
+	}
+}
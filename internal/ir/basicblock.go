@@ -3,6 +3,7 @@ package ir
 import (
 	"fmt"
 	"strings"
+	"sync"
 
 	"github.com/hassan/compiler/internal/semantic/types"
 )
@@ -46,9 +47,10 @@ type BasicBlock struct {
 	// Updated when building the CFG
 	Predecessors []*BasicBlock
 
-	// Dominated tracks blocks dominated by this block
+	// Dominated lists the blocks this block immediately dominates: its
+	// children in the dominator tree, in function block order.
 	// A block B dominates block C if every path to C goes through B
-	// Used for SSA construction and optimization
+	// Set by ComputeDominators; used for SSA construction and optimization
 	Dominated []*BasicBlock
 
 	// Index is the position in the function's block list
@@ -169,6 +171,16 @@ type Function struct {
 	// Locals are local variables (allocas)
 	Locals []*Value
 
+	// IDom maps every reachable block except the entry to its immediate
+	// dominator. Nil until ComputeDominators runs, and stale once the CFG
+	// changes after that
+	IDom map[*BasicBlock]*BasicBlock
+
+	// DominanceFrontier maps a block to the blocks where its dominance
+	// ends: those it doesn't strictly dominate but has a predecessor of.
+	// Set by ComputeDominators alongside IDom
+	DominanceFrontier map[*BasicBlock][]*BasicBlock
+
 	// nextValueID is used to generate unique value IDs
 	nextValueID int
 }
@@ -257,6 +269,9 @@ type Module struct {
 
 	// Globals are global variables
 	Globals []*Value
+
+	// mu guards Functions while a parallel build adds to it
+	mu sync.Mutex
 }
 
 // NewModule creates a new module.
@@ -268,8 +283,11 @@ func NewModule(name string) *Module {
 	}
 }
 
-// AddFunction adds a function to the module.
+// AddFunction adds a function to the module. It is safe to call from
+// several goroutines at once.
 func (m *Module) AddFunction(fn *Function) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	m.Functions = append(m.Functions, fn)
 }
 
@@ -289,6 +307,10 @@ func (m *Module) String() string {
 			sb.WriteString(global.String())
 			sb.WriteString(": ")
 			sb.WriteString(global.Type.String())
+			if global.Initializer != nil {
+				sb.WriteString(" = ")
+				sb.WriteString(global.Initializer.String())
+			}
 			sb.WriteString("\n")
 		}
 		sb.WriteString("\n")
@@ -303,21 +325,41 @@ func (m *Module) String() string {
 	return sb.String()
 }
 
+// earlyTerminator returns the first jump, branch or return in block that
+// isn't its last instruction, or nil if there is none.
+func earlyTerminator(block *BasicBlock) Instruction {
+	for i, instr := range block.Instructions {
+		switch instr.(type) {
+		case *Jump, *Branch, *Return:
+			if i < len(block.Instructions)-1 {
+				return instr
+			}
+		}
+	}
+	return nil
+}
+
 // Verify checks that the IR is well-formed.
 // Returns a list of errors found.
 //
 // CHECKS:
-// - Every block ends with a terminator
-// - Successors match terminator
-// - No unreachable blocks
-// - SSA properties (if applicable)
+// - Every block ends with a terminator, and has no other
+// - The entry block has no predecessors
+// - Temporaries are in SSA form (see verifySSA)
+// - Operands have the types their instructions need (see verifyTypes)
 func (m *Module) Verify() []error {
 	errors := make([]error, 0)
 
 	for _, fn := range m.Functions {
-		// Check each block has a terminator
+		// Check each block has a terminator, at the end. Code after one
+		// never runs, and a pass that walks the block would treat it as
+		// if it did
 		for _, block := range fn.Blocks {
-			if !block.IsTerminated() {
+			if early := earlyTerminator(block); early != nil {
+				errors = append(errors, fmt.Errorf(
+					"block %s in function %s has instructions after its terminator, %s",
+					block.Label, fn.Name, early))
+			} else if !block.IsTerminated() {
 				errors = append(errors, fmt.Errorf(
 					"block %s in function %s has no terminator",
 					block.Label, fn.Name))
@@ -330,6 +372,9 @@ func (m *Module) Verify() []error {
 				"entry block of function %s has predecessors",
 				fn.Name))
 		}
+
+		errors = append(errors, verifySSA(fn)...)
+		errors = append(errors, verifyTypes(fn)...)
 	}
 
 	return errors
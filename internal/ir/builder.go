@@ -2,8 +2,12 @@ package ir
 
 import (
 	"fmt"
+	"sort"
+	"sync"
 
+	"github.com/hassan/compiler/internal/errors"
 	"github.com/hassan/compiler/internal/lexer"
+	"github.com/hassan/compiler/internal/parser"
 	"github.com/hassan/compiler/internal/parser/ast"
 	"github.com/hassan/compiler/internal/semantic"
 	"github.com/hassan/compiler/internal/semantic/types"
@@ -39,8 +43,18 @@ type Builder struct {
 	// variables maps symbols to their IR values
 	variables map[*symtab.Symbol]*Value
 
-	// namedValues maps variable names to their IR values (for local lookup)
-	namedValues map[string]*Value
+	// scopes maps the names of the current function's parameters and
+	// locals to their IR values, one map per scope with the innermost
+	// last. See pushScope
+	scopes []map[string]*Value
+
+	// addressTaken holds the names of the current function's locals and
+	// parameters whose address is taken somewhere in its body
+	addressTaken map[string]bool
+
+	// slots holds the stack slots those variables live in. A name mapped
+	// to a slot is read with a Load and assigned with a Store
+	slots map[*Value]bool
 
 	// breakTarget is the block to jump to on break
 	breakTarget *BasicBlock
@@ -50,31 +64,227 @@ type Builder struct {
 
 	// errors accumulates IR generation errors
 	errors []error
+
+	// pkgPath qualifies the names of this package's functions and globals
+	// when it is built as an import. Empty for the main package.
+	pkgPath string
+
+	// externals are the globals of imported packages, by qualified name
+	externals map[string]*Value
+
+	// Parallel builds each function on a goroutine of its own. The module
+	// is the same either way, functions in declaration order included.
+	//
+	// DESIGN CHOICE: Off by default. Most functions build in microseconds,
+	// so the goroutines only pay for themselves in packages with many large
+	// ones, and a build on one goroutine is the easier one to step through.
+	Parallel bool
+
+	// EmitBoundsChecks makes every array index check its range explicitly,
+	// with a call to the $checkIndex builtin ahead of the GetElementPtr.
+	//
+	// DESIGN CHOICE: Off by default. The interpreter and the C backend
+	// already check the index where they execute the GetElementPtr, so for
+	// them the call only repeats the check. It is for consumers of the IR
+	// that compute element addresses without one, and shows each check
+	// where passes reading the IR can see (and count) it.
+	EmitBoundsChecks bool
 }
 
 // NewBuilder creates a new IR builder.
 func NewBuilder(analyzer *semantic.Analyzer) *Builder {
 	return &Builder{
-		analyzer:    analyzer,
-		variables:   make(map[*symtab.Symbol]*Value),
-		namedValues: make(map[string]*Value),
-		errors:      make([]error, 0),
+		analyzer:  analyzer,
+		variables: make(map[*symtab.Symbol]*Value),
+		errors:    make([]error, 0),
+	}
+}
+
+// SetPackagePath makes the builder emit the functions and globals it builds
+// under qualified names ("geometry.Area"), as required when the package is
+// an import linked into another package's module.
+//
+// DESIGN CHOICE: The main package keeps bare names, so single-package
+// programs produce exactly the IR they always did. Qualifying by import path
+// rather than package name keeps two packages that happen to share a name
+// ("a/util" and "b/util") from colliding.
+func (b *Builder) SetPackagePath(path string) {
+	b.pkgPath = path
+}
+
+// SetExternals provides the already-built globals of imported packages, keyed
+// by qualified name, so that references to them use the same values the
+// imported packages' functions do.
+func (b *Builder) SetExternals(globals map[string]*Value) {
+	b.externals = globals
+}
+
+// qualify returns the IR name of a package-level name of this package.
+func (b *Builder) qualify(name string) string {
+	if b.pkgPath == "" {
+		return name
 	}
+	return b.pkgPath + "." + name
 }
 
 // Build generates IR for a file.
 func (b *Builder) Build(file *ast.File) (*Module, []error) {
-	// Create module
-	b.module = NewModule(file.Package.Name.Name)
+	return b.BuildFiles([]*ast.File{file})
+}
 
-	// Generate IR for each declaration
-	for _, decl := range file.Decls {
-		b.buildDecl(decl)
+// BuildFiles generates one module for all the files of a package.
+//
+// The files must have been analyzed together (Analyzer.AnalyzeFiles), so the
+// global scope already holds every function and global of the package.
+//
+// Like the analyzer, this runs in two passes: every global of every file is
+// mapped to its IR value first, then the functions are built. Otherwise a
+// function in a.src reading a global from b.src would find no value for it.
+// Calls are resolved by name, so functions need no such pre-pass.
+func (b *Builder) BuildFiles(files []*ast.File) (*Module, []error) {
+	if err := b.checkAnalysis(); err != nil {
+		return nil, []error{err}
 	}
 
+	// Create module, named after the package (analysis has checked that
+	// every file agrees on it)
+	pkgSet := parser.NewPackageSet(files)
+	b.module = NewModule(pkgSet.Name())
+	b.buildDecls(pkgSet.Decls())
 	return b.module, b.errors
 }
 
+// BuildIncremental adds the IR for decls to the module built so far, and
+// returns it: the first call starts a module of its own, named main. The
+// declarations must have been analyzed by Analyzer.AnalyzeIncremental.
+//
+// Globals built by earlier calls keep their values, so the functions of
+// one call read and write the same globals as those of the calls before.
+// Returns the errors of this call only.
+func (b *Builder) BuildIncremental(decls []ast.Decl) (*Module, []error) {
+	if err := b.checkAnalysis(); err != nil {
+		return b.module, []error{err}
+	}
+	if b.module == nil {
+		b.module = NewModule("main")
+	}
+	b.errors = make([]error, 0)
+	b.buildDecls(decls)
+	return b.module, b.errors
+}
+
+// checkAnalysis returns the reason the builder can't run on what its
+// analyzer left behind, or nil if it can.
+//
+// DESIGN CHOICE: Refuse to build after any semantic error, rather than
+// build what can be built. The builder takes the analyzer's word for
+// everything - that a call's callee is a function, that an operand has the
+// type recorded for it, that a name refers to what it was resolved to - and
+// after an error those answers are Invalid types and symbols half
+// declared. Guarding every such use would still produce IR for a program
+// that means nothing. The driver only builds a package that analyzed
+// cleanly; this makes the same true for code that uses Builder directly.
+func (b *Builder) checkAnalysis() error {
+	if b.analyzer == nil {
+		return errors.New(errors.CodeIR, lexer.Position{}, "cannot build IR without a semantic analyzer")
+	}
+	if n := len(b.analyzer.Errors()); n > 0 {
+		return errors.New(errors.CodeIR, lexer.Position{}, fmt.Sprintf(
+			"cannot build IR: semantic analysis reported %d error(s)", n))
+	}
+	return nil
+}
+
+// buildDecls builds top-level declarations into b.module.
+func (b *Builder) buildDecls(decls []ast.Decl) {
+	// Pass 1: globals
+	for _, decl := range decls {
+		if v, ok := decl.(*ast.VarDecl); ok {
+			b.buildGlobalVar(v)
+		}
+	}
+
+	// Pass 2: everything else
+	if b.Parallel {
+		b.buildFunctionsParallel(decls)
+		return
+	}
+	for _, decl := range decls {
+		if _, ok := decl.(*ast.VarDecl); !ok {
+			b.buildDecl(decl)
+		}
+	}
+}
+
+// buildFunctionsParallel builds the functions among decls, each on its own
+// goroutine with a builder of its own (see fork), once pass 1 has mapped
+// every global. Errors come back over a channel tagged with the function
+// they belong to; they, and the functions in the module, are put back in
+// declaration order, so the result is the same whichever finishes first.
+func (b *Builder) buildFunctionsParallel(decls []ast.Decl) {
+	var funcs []*ast.FuncDecl
+	for _, decl := range decls {
+		if fn, ok := decl.(*ast.FuncDecl); ok {
+			funcs = append(funcs, fn)
+		}
+	}
+
+	type result struct {
+		index  int
+		errors []error
+	}
+	start := len(b.module.Functions)
+	results := make(chan result, len(funcs))
+	var wg sync.WaitGroup
+	for i, decl := range funcs {
+		wg.Add(1)
+		go func(i int, decl *ast.FuncDecl) {
+			defer wg.Done()
+			fork := b.fork()
+			fork.buildFunction(decl)
+			results <- result{index: i, errors: fork.errors}
+		}(i, decl)
+	}
+	wg.Wait()
+	close(results)
+
+	funcErrors := make([][]error, len(funcs))
+	for r := range results {
+		funcErrors[r.index] = r.errors
+	}
+	for _, errs := range funcErrors {
+		b.errors = append(b.errors, errs...)
+	}
+
+	// Analysis rejects a name declared twice, so names identify functions
+	order := make(map[string]int, len(funcs))
+	for i, decl := range funcs {
+		order[b.qualify(decl.Name.Name)] = i
+	}
+	built := b.module.Functions[start:]
+	sort.SliceStable(built, func(i, j int) bool {
+		return order[built[i].Name] < order[built[j].Name]
+	})
+}
+
+// fork returns a builder for one function of the module b is building.
+//
+// What building a function changes - the current function and block, the
+// names in scope, the loop targets, the errors - is the fork's own. What
+// it shares with b is only read once pass 1 is done: the analyzer, the
+// globals and externals. The module is shared too, and only added to.
+func (b *Builder) fork() *Builder {
+	return &Builder{
+		module:           b.module,
+		analyzer:         b.analyzer,
+		variables:        b.variables,
+		errors:           make([]error, 0),
+		pkgPath:          b.pkgPath,
+		externals:        b.externals,
+		EmitBoundsChecks: b.EmitBoundsChecks,
+	}
+}
+
 // buildDecl generates IR for a declaration.
 func (b *Builder) buildDecl(decl ast.Decl) {
 	switch d := decl.(type) {
@@ -91,75 +301,164 @@ func (b *Builder) buildDecl(decl ast.Decl) {
 func (b *Builder) buildFunction(decl *ast.FuncDecl) {
 	// Look up function symbol to get type
 	scope := b.analyzer.GetScope()
-	symbol := scope.Lookup(decl.Name.Name)
+	symbol := scope.Resolve(decl.Name.Name)
 	if symbol == nil {
 		b.error(decl.Pos(), "function symbol not found")
 		return
 	}
 
-	funcType := symbol.Type.(*types.FunctionType)
+	funcType, ok := symbol.Type.(*types.FunctionType)
+	if !ok || len(funcType.Parameters) != len(decl.Params) {
+		b.error(decl.Pos(), fmt.Sprintf("%s has no function type", decl.Name.Name))
+		return
+	}
 
-	// Create parameter values
+	// Create parameter values. Named types exist only for type checking:
+	// in the IR a Meters is the int it is represented as
 	params := make([]*Value, len(decl.Params))
 	for i, param := range decl.Params {
 		params[i] = &Value{
 			ID:   i,
 			Name: param.Name.Name,
-			Type: funcType.Parameters[i],
+			Type: types.Underlying(funcType.Parameters[i]),
 			Kind: ValueParameter,
 		}
 	}
 
 	// Create function
-	b.currentFunc = NewFunction(decl.Name.Name, params, funcType.ReturnType)
+	b.currentFunc = NewFunction(b.qualify(decl.Name.Name), params, types.Underlying(funcType.ReturnType))
 	b.currentBlock = b.currentFunc.Entry
 
-	// Reset named values for this function
-	b.namedValues = make(map[string]*Value)
-
-	// Map parameters to values by name
+	// The parameters are the function's outermost scope
+	b.scopes = nil
+	b.pushScope()
+
+	// Map parameters to values by name. A parameter whose address is taken
+	// is copied into a slot on entry, and the slot stands for it; one the
+	// body assigns is copied into a local the same way.
+	//
+	// DESIGN CHOICE: A parameter is an incoming value with no defining
+	// instruction, so "n = n + 1" must not become a Copy into it: dead code
+	// elimination looks for a value's definitions among the instructions,
+	// and propagating a copy of the parameter past the assignment would
+	// read the new value where the old one was meant. Parameters behave as
+	// variables in the language, and a local is what the builder makes of
+	// any other assigned variable. One that is only read gets no local, so
+	// the common case costs nothing
+	b.addressTaken = addressTakenNames(decl.Body)
+	assigned := assignedNames(decl.Body)
+	b.slots = make(map[*Value]bool)
 	for i, param := range decl.Params {
-		b.namedValues[param.Name.Name] = params[i]
+		name := param.Name.Name
+		switch {
+		case b.addressTaken[name]:
+			slot := b.newSlot(name, params[i].Type, b.analyzer.Escapes(param.Name))
+			b.currentBlock.AddInstruction(&Store{Address: slot, Value: params[i]})
+			b.define(name, slot)
+		case assigned[name]:
+			local := b.currentFunc.NewValue(name, params[i].Type, ValueVariable)
+			b.currentFunc.Locals = append(b.currentFunc.Locals, local)
+			b.currentBlock.AddInstruction(&Copy{Dest: local, Value: params[i]})
+			b.define(name, local)
+		default:
+			b.define(name, params[i])
+		}
 	}
 
 	// Generate body
 	if decl.Body != nil {
 		b.buildStmt(decl.Body)
 
-		// Add implicit return for void functions if needed
-		if funcType.ReturnType.Equals(types.Void) && !b.currentBlock.IsTerminated() {
+		// When every path returned before the end (both branches of an
+		// if/else), the block the end would fall into is empty and
+		// unreachable: drop it rather than leave it unterminated, or give
+		// a void function's implicit return to a block nothing reaches
+		if !b.currentBlock.IsTerminated() && !b.discardUnreachable(b.currentBlock) &&
+			funcType.ReturnType.Equals(types.Void) {
 			b.currentBlock.AddInstruction(&Return{Value: nil})
 		}
+
+		if err := CheckMissingReturn(b.currentFunc); err != nil {
+			b.error(decl.Body.End(), err.Error())
+		}
 	}
 
 	// Add function to module
 	b.module.AddFunction(b.currentFunc)
 
-	// Clean up
+	// Clean up. The function's names go too, so that nothing built
+	// outside a function resolves to one of its locals
 	b.currentFunc = nil
 	b.currentBlock = nil
+	b.scopes = nil
+	b.slots = nil
 }
 
-// buildGlobalVar generates IR for a global variable.
+// discardUnreachable removes block from the function if nothing jumps to
+// it and it holds nothing, reporting whether it did. That is the block
+// after an if whose branches all returned: there is nothing to terminate
+// it with, and nothing needs it.
+func (b *Builder) discardUnreachable(block *BasicBlock) bool {
+	fn := b.currentFunc
+	if block == fn.Entry || len(block.Predecessors) > 0 || len(block.Instructions) > 0 {
+		return false
+	}
+	blocks := fn.Blocks[:0]
+	for _, other := range fn.Blocks {
+		if other != block {
+			other.Index = len(blocks)
+			blocks = append(blocks, other)
+		}
+	}
+	fn.Blocks = blocks
+	return true
+}
+
+// buildGlobalVar generates IR for a global variable. The analyzer has
+// checked that its initializer, if it has one, is a constant: that is the
+// global's Initializer. No code runs to initialize a global.
 func (b *Builder) buildGlobalVar(decl *ast.VarDecl) {
-	// For now, just create the global value
-	// Initialization will be handled specially
 	scope := b.analyzer.GetScope()
 	for _, name := range decl.Names {
-		symbol := scope.Lookup(name.Name)
+		symbol := scope.Resolve(name.Name)
+		if symbol == nil {
+			b.error(name.Pos(), "variable symbol not found")
+		}
 		if symbol != nil {
 			global := &Value{
 				ID:   len(b.module.Globals),
-				Name: name.Name,
-				Type: symbol.Type,
+				Name: b.qualify(name.Name),
+				Type: types.Underlying(symbol.Type),
 				Kind: ValueVariable,
 			}
+			global.Initializer = b.globalInitializer(decl.Initializer, global.Type)
 			b.module.Globals = append(b.module.Globals, global)
 			b.variables[symbol] = global
 		}
 	}
 }
 
+// globalInitializer returns the constant a global of type t declared with
+// initializer starts with, or nil for its zero value: when there is no
+// initializer, or the initializer is nil. The REPL accepts any initializer
+// and assigns it when the line runs (see AnalyzeIncremental), so one that
+// isn't a constant also leaves the global at zero until then.
+func (b *Builder) globalInitializer(initializer ast.Expr, t types.Type) *Value {
+	if initializer == nil {
+		return nil
+	}
+	value, ok := b.analyzer.ConstantValue(initializer)
+	if !ok || value == nil {
+		return nil
+	}
+	if n, ok := value.(int64); ok {
+		if intType, ok := t.(*types.IntType); ok {
+			value = intType.Wrap(n)
+		}
+	}
+	return &Value{ID: -1, Type: t, Kind: ValueConstant, Constant: value}
+}
+
 // buildStmt generates IR for a statement.
 func (b *Builder) buildStmt(stmt ast.Stmt) {
 	switch s := stmt.(type) {
@@ -167,9 +466,9 @@ func (b *Builder) buildStmt(stmt ast.Stmt) {
 		b.buildExpr(s.Expression)
 
 	case *ast.BlockStmt:
-		for _, inner := range s.Statements {
-			b.buildStmt(inner)
-		}
+		b.pushScope()
+		b.buildStmts(s.Statements)
+		b.popScope()
 
 	case *ast.IfStmt:
 		b.buildIf(s)
@@ -180,24 +479,47 @@ func (b *Builder) buildStmt(stmt ast.Stmt) {
 	case *ast.ForStmt:
 		b.buildFor(s)
 
+	case *ast.SwitchStmt:
+		b.buildSwitch(s)
+
 	case *ast.ReturnStmt:
 		b.buildReturn(s)
 
 	case *ast.BreakStmt:
-		if b.breakTarget != nil {
-			b.currentBlock.AddInstruction(&Jump{Target: b.breakTarget})
+		if b.breakTarget == nil {
+			b.error(s.Pos(), "break outside loop or switch")
+			return
 		}
+		b.currentBlock.AddInstruction(&Jump{Target: b.breakTarget})
+		b.currentBlock.AddSuccessor(b.breakTarget)
 
 	case *ast.ContinueStmt:
-		if b.continueTarget != nil {
-			b.currentBlock.AddInstruction(&Jump{Target: b.continueTarget})
+		if b.continueTarget == nil {
+			b.error(s.Pos(), "continue outside loop")
+			return
 		}
+		b.currentBlock.AddInstruction(&Jump{Target: b.continueTarget})
+		b.currentBlock.AddSuccessor(b.continueTarget)
 
 	case *ast.VarDecl:
 		b.buildLocalVar(s)
 	}
 }
 
+// buildStmts generates IR for a list of statements, up to the first one
+// that ends the current block: a return, break or continue. What follows
+// it in the list can't run - the analyzer warns about it - and building it
+// would put instructions after the block's terminator, with edges out of
+// the block that no path takes.
+func (b *Builder) buildStmts(stmts []ast.Stmt) {
+	for _, stmt := range stmts {
+		if b.currentBlock.IsTerminated() {
+			return
+		}
+		b.buildStmt(stmt)
+	}
+}
+
 // buildIf generates IR for an if statement.
 func (b *Builder) buildIf(stmt *ast.IfStmt) {
 	// Evaluate condition
@@ -224,9 +546,11 @@ func (b *Builder) buildIf(stmt *ast.IfStmt) {
 	b.currentBlock.AddSuccessor(elseBlock)
 
 	// Then block
+	// A branch can end in the unreachable end of a nested if, which
+	// doesn't jump anywhere: it is discarded instead
 	b.currentBlock = thenBlock
 	b.buildStmt(stmt.ThenBranch)
-	if !b.currentBlock.IsTerminated() {
+	if !b.currentBlock.IsTerminated() && !b.discardUnreachable(b.currentBlock) {
 		b.currentBlock.AddInstruction(&Jump{Target: endBlock})
 		b.currentBlock.AddSuccessor(endBlock)
 	}
@@ -235,7 +559,7 @@ func (b *Builder) buildIf(stmt *ast.IfStmt) {
 	if stmt.ElseBranch != nil {
 		b.currentBlock = elseBlock
 		b.buildStmt(stmt.ElseBranch)
-		if !b.currentBlock.IsTerminated() {
+		if !b.currentBlock.IsTerminated() && !b.discardUnreachable(b.currentBlock) {
 			b.currentBlock.AddInstruction(&Jump{Target: endBlock})
 			b.currentBlock.AddSuccessor(endBlock)
 		}
@@ -288,6 +612,10 @@ func (b *Builder) buildWhile(stmt *ast.WhileStmt) {
 
 // buildFor generates IR for a for loop.
 func (b *Builder) buildFor(stmt *ast.ForStmt) {
+	// The loop variable is scoped to the loop
+	b.pushScope()
+	defer b.popScope()
+
 	// Init
 	if stmt.Init != nil {
 		b.buildStmt(stmt.Init)
@@ -317,12 +645,13 @@ func (b *Builder) buildFor(stmt *ast.ForStmt) {
 			TrueBlock:  bodyBlock,
 			FalseBlock: endBlock,
 		})
+		b.currentBlock.AddSuccessor(bodyBlock)
+		b.currentBlock.AddSuccessor(endBlock)
 	} else {
-		// Infinite loop
+		// Infinite loop: only a break leaves it
 		b.currentBlock.AddInstruction(&Jump{Target: bodyBlock})
+		b.currentBlock.AddSuccessor(bodyBlock)
 	}
-	b.currentBlock.AddSuccessor(bodyBlock)
-	b.currentBlock.AddSuccessor(endBlock)
 
 	// Body block
 	b.currentBlock = bodyBlock
@@ -347,6 +676,101 @@ func (b *Builder) buildFor(stmt *ast.ForStmt) {
 	b.currentBlock = endBlock
 }
 
+// buildSwitch generates IR for a switch statement: a chain of comparisons
+// of the value against each case value in turn, the first match jumping to
+// its case's body.
+//
+// EXAMPLE: switch (n) { case 1, 2: a; default: b; }
+//
+//	entry:          t1 = n == const(1); br t1, switch.case, switch.test
+//	switch.test:    t2 = n == const(2); br t2, switch.case, switch.default
+//	switch.case:    a; jump switch.end
+//	switch.default: b; jump switch.end
+//
+// When no case value matches, control goes to the default, or without one
+// past the switch. An exhaustive switch without a default (a bool switch
+// with both cases) has nowhere for a value matching nothing to go, so its
+// last case value isn't compared at all: the last test jumps straight to
+// the body. The CFG then agrees with the analyzer that every path runs a
+// case, which matters for a switch whose cases all return.
+func (b *Builder) buildSwitch(stmt *ast.SwitchStmt) {
+	value := b.buildExpr(stmt.Value)
+
+	// The case values in the order they are tested, and whose body each
+	// jumps to
+	type test struct {
+		value ast.Expr
+		body  int
+	}
+	var tests []test
+	for i, c := range stmt.Cases {
+		for _, v := range c.Values {
+			tests = append(tests, test{v, i})
+		}
+	}
+
+	testBlocks := []*BasicBlock{b.currentBlock}
+	for k := 1; k < len(tests); k++ {
+		testBlocks = append(testBlocks, b.currentFunc.NewBasicBlockInFunc("switch.test"))
+	}
+	bodies := make([]*BasicBlock, len(stmt.Cases))
+	var miss *BasicBlock
+	for i, c := range stmt.Cases {
+		if c.IsDefault {
+			bodies[i] = b.currentFunc.NewBasicBlockInFunc("switch.default")
+			miss = bodies[i]
+		} else {
+			bodies[i] = b.currentFunc.NewBasicBlockInFunc("switch.case")
+		}
+	}
+	endBlock := b.currentFunc.NewBasicBlockInFunc("switch.end")
+	if miss == nil {
+		miss = endBlock
+	}
+	omitLast := miss == endBlock && b.analyzer.IsExhaustive(stmt)
+
+	if len(tests) == 0 {
+		b.currentBlock.AddInstruction(&Jump{Target: miss})
+		b.currentBlock.AddSuccessor(miss)
+	}
+	for k, t := range tests {
+		b.currentBlock = testBlocks[k]
+		body := bodies[t.body]
+		if k == len(tests)-1 && omitLast {
+			b.currentBlock.AddInstruction(&Jump{Target: body})
+			b.currentBlock.AddSuccessor(body)
+			break
+		}
+		next := miss
+		if k+1 < len(tests) {
+			next = testBlocks[k+1]
+		}
+		cond := b.currentFunc.NewTemp(types.Bool)
+		b.currentBlock.AddInstruction(&BinaryOp{Op: OpEq, Dest: cond, Left: value, Right: b.buildExpr(t.value)})
+		b.currentBlock.AddInstruction(&Branch{Condition: cond, TrueBlock: body, FalseBlock: next})
+		b.currentBlock.AddSuccessor(body)
+		b.currentBlock.AddSuccessor(next)
+	}
+
+	// A break in a case leaves the switch; continue still means the
+	// enclosing loop. The cases share one scope, as in the analyzer
+	oldBreak := b.breakTarget
+	b.breakTarget = endBlock
+	b.pushScope()
+	defer b.popScope()
+	for i, c := range stmt.Cases {
+		b.currentBlock = bodies[i]
+		b.buildStmts(c.Body)
+		if !b.currentBlock.IsTerminated() && !b.discardUnreachable(b.currentBlock) {
+			b.currentBlock.AddInstruction(&Jump{Target: endBlock})
+			b.currentBlock.AddSuccessor(endBlock)
+		}
+	}
+	b.breakTarget = oldBreak
+
+	b.currentBlock = endBlock
+}
+
 // buildReturn generates IR for a return statement.
 func (b *Builder) buildReturn(stmt *ast.ReturnStmt) {
 	var value *Value
@@ -359,17 +783,27 @@ func (b *Builder) buildReturn(stmt *ast.ReturnStmt) {
 // buildLocalVar generates IR for a local variable declaration.
 func (b *Builder) buildLocalVar(decl *ast.VarDecl) {
 	for _, name := range decl.Names {
-		// Get type from analyzer
-		varType := types.Int // Default, should get from semantic analysis
-		if decl.Type != nil {
-			// Type is specified - would resolve this properly
-			varType = types.Int
+		// The analyzer gives each name the variable's type, declared or
+		// inferred from the initializer. A name it never saw is from an
+		// AST other than the one analyzed
+		varType := types.Underlying(b.analyzer.GetExprType(name))
+		if varType == types.Invalid {
+			b.error(name.Pos(), fmt.Sprintf("%s has no type", name.Name))
+		}
+
+		if b.addressTaken[name.Name] {
+			slot := b.newSlot(name.Name, varType, b.analyzer.Escapes(name))
+			b.define(name.Name, slot)
+			if decl.Initializer != nil {
+				b.currentBlock.AddInstruction(&Store{Address: slot, Value: b.buildExpr(decl.Initializer)})
+			}
+			continue
 		}
 
 		// Allocate space for the variable
 		alloca := b.currentFunc.NewValue(name.Name, varType, ValueVariable)
 		b.currentFunc.Locals = append(b.currentFunc.Locals, alloca)
-		b.namedValues[name.Name] = alloca
+		b.define(name.Name, alloca)
 
 		// Initialize if there's an initializer
 		if decl.Initializer != nil {
@@ -383,9 +817,32 @@ func (b *Builder) buildLocalVar(decl *ast.VarDecl) {
 	}
 }
 
-// buildExpr generates IR for an expression and returns the resulting value.
+// buildExpr generates IR for an expression and returns the resulting value,
+// widened when the expression is assigned to a wider integer type.
 func (b *Builder) buildExpr(expr ast.Expr) *Value {
-	exprType := b.analyzer.GetExprType(expr)
+	v := b.buildValue(expr)
+	if target := b.analyzer.Widening(expr); target != nil {
+		return b.widen(v, target)
+	}
+	return v
+}
+
+// widen gives the integer v the wider type target it is assigned to.
+//
+// DESIGN CHOICE: A Copy of the wider type, rather than instructions of its
+// own to sign- or zero-extend. Every integer is already held extended to
+// 64 bits (see types.IntType), so the extension has nothing left to do at
+// run time: only the type changes, as it does in a conversion between two
+// types of the same width.
+func (b *Builder) widen(v *Value, target types.Type) *Value {
+	result := b.currentFunc.NewTemp(types.Underlying(target))
+	b.currentBlock.AddInstruction(&Copy{Dest: result, Value: v})
+	return result
+}
+
+// buildValue generates IR for an expression, with the expression's own type.
+func (b *Builder) buildValue(expr ast.Expr) *Value {
+	exprType := types.Underlying(b.analyzer.GetExprType(expr))
 
 	switch e := expr.(type) {
 	case *ast.BinaryExpr:
@@ -394,6 +851,13 @@ func (b *Builder) buildExpr(expr ast.Expr) *Value {
 	case *ast.UnaryExpr:
 		return b.buildUnary(e, exprType)
 
+	case *ast.LogicalExpr:
+		return b.buildLogical(e, exprType)
+
+	case *ast.GroupingExpr:
+		// Parentheses only group; the inner expression is the value
+		return b.buildExpr(e.Expression)
+
 	case *ast.LiteralExpr:
 		return b.buildLiteral(e, exprType)
 
@@ -406,6 +870,33 @@ func (b *Builder) buildExpr(expr ast.Expr) *Value {
 	case *ast.AssignmentExpr:
 		return b.buildAssignment(e)
 
+	case *ast.StructLiteralExpr:
+		return b.buildStructLiteral(e, exprType)
+
+	case *ast.ArrayLiteralExpr:
+		return b.buildArrayLiteral(e, exprType)
+
+	case *ast.MemberExpr:
+		if value := b.buildPackageMember(e, exprType); value != nil {
+			return value
+		}
+		if address := b.buildFieldAddress(e); address != nil {
+			result := b.currentFunc.NewTemp(exprType)
+			b.currentBlock.AddInstruction(&Load{Dest: result, Address: address})
+			return result
+		}
+		b.error(expr.Pos(), fmt.Sprintf("unsupported expression type: %T", expr))
+		return b.currentFunc.NewTemp(types.Invalid)
+
+	case *ast.IndexExpr:
+		if address := b.buildElementAddress(e); address != nil {
+			result := b.currentFunc.NewTemp(exprType)
+			b.currentBlock.AddInstruction(&Load{Dest: result, Address: address})
+			return result
+		}
+		b.error(expr.Pos(), fmt.Sprintf("unsupported expression type: %T", expr))
+		return b.currentFunc.NewTemp(types.Invalid)
+
 	default:
 		b.error(expr.Pos(), fmt.Sprintf("unsupported expression type: %T", expr))
 		return b.currentFunc.NewTemp(types.Invalid)
@@ -417,60 +908,88 @@ func (b *Builder) buildBinary(expr *ast.BinaryExpr, resultType types.Type) *Valu
 	left := b.buildExpr(expr.Left)
 	right := b.buildExpr(expr.Right)
 
+	op, ok := binaryOperators[expr.Operator.Type]
+	if !ok {
+		b.error(expr.Operator.Position, "unsupported binary operator")
+		return b.currentFunc.NewTemp(resultType)
+	}
+	return b.emitBinary(op, left, right, resultType)
+}
+
+// binaryOperators maps the tokens of binary operators to IR operators.
+var binaryOperators = map[lexer.TokenType]BinaryOperator{
+	lexer.TokenPlus:         OpAdd,
+	lexer.TokenMinus:        OpSub,
+	lexer.TokenStar:         OpMul,
+	lexer.TokenSlash:        OpDiv,
+	lexer.TokenPercent:      OpMod,
+	lexer.TokenEqual:        OpEq,
+	lexer.TokenNotEqual:     OpNeq,
+	lexer.TokenLess:         OpLt,
+	lexer.TokenLessEqual:    OpLe,
+	lexer.TokenGreater:      OpGt,
+	lexer.TokenGreaterEqual: OpGe,
+	lexer.TokenBitAnd:       OpBitAnd,
+	lexer.TokenBitOr:        OpBitOr,
+	lexer.TokenBitXor:       OpBitXor,
+	lexer.TokenShl:          OpShl,
+	lexer.TokenShr:          OpShr,
+}
+
+// compoundOperators maps compound assignment tokens to the operator they
+// apply: "x += v" is "x = x + v".
+var compoundOperators = map[lexer.TokenType]BinaryOperator{
+	lexer.TokenPlusEq:    OpAdd,
+	lexer.TokenMinusEq:   OpSub,
+	lexer.TokenStarEq:    OpMul,
+	lexer.TokenSlashEq:   OpDiv,
+	lexer.TokenPercentEq: OpMod,
+	lexer.TokenAndEq:     OpBitAnd,
+	lexer.TokenOrEq:      OpBitOr,
+	lexer.TokenXorEq:     OpBitXor,
+	lexer.TokenShlEq:     OpShl,
+	lexer.TokenShrEq:     OpShr,
+}
+
+// emitBinary emits "left op right" into a new temporary of resultType.
+func (b *Builder) emitBinary(op BinaryOperator, left, right *Value, resultType types.Type) *Value {
 	result := b.currentFunc.NewTemp(resultType)
 
-	// Map token to IR operator
-	var op BinaryOperator
-	switch expr.Operator.Type {
-	case lexer.TokenPlus:
-		op = OpAdd
-	case lexer.TokenMinus:
-		op = OpSub
-	case lexer.TokenStar:
-		op = OpMul
-	case lexer.TokenSlash:
-		op = OpDiv
-	case lexer.TokenPercent:
-		op = OpMod
-	case lexer.TokenEqual:
-		op = OpEq
-	case lexer.TokenNotEqual:
-		op = OpNeq
-	case lexer.TokenLess:
-		op = OpLt
-	case lexer.TokenLessEqual:
-		op = OpLe
-	case lexer.TokenGreater:
-		op = OpGt
-	case lexer.TokenGreaterEqual:
-		op = OpGe
-	case lexer.TokenBitAnd:
-		op = OpBitAnd
-	case lexer.TokenBitOr:
-		op = OpBitOr
-	case lexer.TokenBitXor:
-		op = OpBitXor
-	case lexer.TokenShl:
-		op = OpShl
-	case lexer.TokenShr:
-		op = OpShr
-	default:
-		b.error(expr.Operator.Position, "unsupported binary operator")
-		return result
+	// A narrow integer result is computed in 64 bits, then wrapped. The
+	// other operators can't leave the range of their operands
+	dest := result
+	overflows := op == OpAdd || op == OpSub || op == OpMul || op == OpDiv || op == OpShl
+	if overflows && isNarrow(resultType) {
+		dest = b.currentFunc.NewTemp(types.Int)
 	}
 
 	b.currentBlock.AddInstruction(&BinaryOp{
 		Op:    op,
-		Dest:  result,
+		Dest:  dest,
 		Left:  left,
 		Right: right,
 	})
 
+	if dest != result {
+		b.wrapInto(result, dest)
+	}
 	return result
 }
 
 // buildUnary generates IR for a unary expression.
 func (b *Builder) buildUnary(expr *ast.UnaryExpr, resultType types.Type) *Value {
+	switch expr.Operator.Type {
+	case lexer.TokenBitAnd:
+		return b.buildAddress(expr.Operand)
+	case lexer.TokenStar:
+		address := b.buildExpr(expr.Operand)
+		result := b.currentFunc.NewTemp(resultType)
+		b.currentBlock.AddInstruction(&Load{Dest: result, Address: address})
+		return result
+	case lexer.TokenPlusPlus, lexer.TokenMinusMinus:
+		return b.buildIncrement(expr, resultType)
+	}
+
 	operand := b.buildExpr(expr.Operand)
 	result := b.currentFunc.NewTemp(resultType)
 
@@ -487,15 +1006,134 @@ func (b *Builder) buildUnary(expr *ast.UnaryExpr, resultType types.Type) *Value
 		return result
 	}
 
+	// Negating a narrow integer can leave its range (-(-128) for int8), and
+	// so can inverting an unsigned one (~uint8(0) is -1 in 64 bits)
+	dest := result
+	if isNarrow(resultType) && (op == OpNeg || (op == OpBitNot && resultType.(*types.IntType).Unsigned)) {
+		dest = b.currentFunc.NewTemp(types.Int)
+	}
+
 	b.currentBlock.AddInstruction(&UnaryOp{
 		Op:      op,
-		Dest:    result,
+		Dest:    dest,
 		Operand: operand,
 	})
 
+	if dest != result {
+		b.wrapInto(result, dest)
+	}
 	return result
 }
 
+// buildLogical generates IR for && and ||, which evaluate their right
+// operand only when the left doesn't already decide the result.
+//
+// EXAMPLE: a && b
+//
+//	entry:   br a, and.rhs, and.end
+//	and.rhs: t1 = b; jump and.end
+//	and.end: t2 = phi [const(false), entry], [t1, and.rhs]
+//
+// || is the same with the branch targets swapped and const(true) coming
+// from the left. The right operand can itself branch ("a && (b || c)"), so
+// its incoming block is wherever building it left off, not and.rhs.
+//
+// DESIGN CHOICE: A phi at the join rather than a variable both paths copy
+// into. The result is a temporary defined exactly once, like every other
+// expression result, so the optimizer passes that rely on single
+// definitions (constant folding, value numbering) treat it as one value,
+// and a backend sees directly which value each edge carries.
+func (b *Builder) buildLogical(expr *ast.LogicalExpr, resultType types.Type) *Value {
+	left := b.buildExpr(expr.Left)
+	leftBlock := b.currentBlock
+
+	isAnd := expr.Operator.Type == lexer.TokenAnd
+	prefix := "or"
+	if isAnd {
+		prefix = "and"
+	}
+	rhsBlock := b.currentFunc.NewBasicBlockInFunc(prefix + ".rhs")
+	endBlock := b.currentFunc.NewBasicBlockInFunc(prefix + ".end")
+
+	// The left operand decides the result when it is false for && and true
+	// for ||
+	branch := &Branch{Condition: left, TrueBlock: rhsBlock, FalseBlock: endBlock}
+	if !isAnd {
+		branch.TrueBlock, branch.FalseBlock = endBlock, rhsBlock
+	}
+	leftBlock.AddInstruction(branch)
+	leftBlock.AddSuccessor(rhsBlock)
+	leftBlock.AddSuccessor(endBlock)
+
+	b.currentBlock = rhsBlock
+	right := b.buildExpr(expr.Right)
+	rightBlock := b.currentBlock
+	rightBlock.AddInstruction(&Jump{Target: endBlock})
+	rightBlock.AddSuccessor(endBlock)
+
+	b.currentBlock = endBlock
+	result := b.currentFunc.NewTemp(resultType)
+	endBlock.AddInstruction(&Phi{
+		Dest: result,
+		Incomig: []PhiIncoming{
+			{Value: &Value{ID: -1, Type: resultType, Kind: ValueConstant, Constant: !isAnd}, Block: leftBlock},
+			{Value: right, Block: rightBlock},
+		},
+	})
+	return result
+}
+
+// isNarrow reports whether t is an integer type of fewer than 64 bits.
+func isNarrow(t types.Type) bool {
+	i, ok := t.(*types.IntType)
+	return ok && i.Bits < 64
+}
+
+// wrapInto brings the 64-bit integer v into the range of dest's type,
+// narrower than 64 bits, and stores it in dest: sign-extending the low bits
+// with a shift left and an arithmetic shift right, or zero-extending them
+// with a mask.
+//
+// DESIGN CHOICE: Wrapping is spelled out with ordinary instructions rather
+// than being implied by the type of an operation's result. Every backend
+// and the interpreter then compute narrow integers exactly as they compute
+// int, and a value of any width is always held as its 64-bit equivalent
+// (see types.IntType).
+func (b *Builder) wrapInto(dest, v *Value) {
+	t := dest.Type.(*types.IntType)
+	if t.Unsigned {
+		mask := int64(1)<<uint(t.Bits) - 1
+		b.currentBlock.AddInstruction(&BinaryOp{Op: OpBitAnd, Dest: dest, Left: v, Right: intConstant(mask)})
+		return
+	}
+	shift := intConstant(int64(64 - t.Bits))
+	shifted := b.currentFunc.NewTemp(types.Int)
+	b.currentBlock.AddInstruction(&BinaryOp{Op: OpShl, Dest: shifted, Left: v, Right: shift})
+	b.currentBlock.AddInstruction(&BinaryOp{Op: OpShr, Dest: dest, Left: shifted, Right: shift})
+}
+
+// convert gives v the type target, for a conversion. Only a change of
+// integer type does anything: a narrower target wraps the value, and any
+// other gets a copy of the target type, so that a uint64 is computed with
+// unsigned operations from then on.
+func (b *Builder) convert(v *Value, target types.Type) *Value {
+	if _, ok := target.(*types.IntType); !ok || v.Type.Equals(target) {
+		return v
+	}
+	result := b.currentFunc.NewTemp(target)
+	if isNarrow(target) {
+		b.wrapInto(result, v)
+	} else {
+		b.currentBlock.AddInstruction(&Copy{Dest: result, Value: v})
+	}
+	return result
+}
+
+// intConstant builds an int constant operand.
+func intConstant(n int64) *Value {
+	return &Value{ID: -1, Type: types.Int, Kind: ValueConstant, Constant: n}
+}
+
 // buildLiteral generates IR for a literal.
 func (b *Builder) buildLiteral(expr *ast.LiteralExpr, exprType types.Type) *Value {
 	return &Value{
@@ -506,21 +1144,145 @@ func (b *Builder) buildLiteral(expr *ast.LiteralExpr, exprType types.Type) *Valu
 	}
 }
 
+// buildStructLiteral generates IR for a struct literal: it fills a stack slot
+// field by field and loads the finished struct from it.
+//
+// EXAMPLE: Point{y: n} for struct Point { x int; y int; }
+//
+//	t1 = alloca struct Point
+//	t2 = &t1.field0
+//	store const(0), t2
+//	t3 = &t1.field1
+//	store n, t3
+//	t4 = load t1
+//
+// The values are evaluated in the order they are written, then stored in
+// declaration order, each field getting its value or, when the literal
+// leaves it out, a zero constant. A field that is itself a struct or an
+// array has no constant to store; it keeps the zeroed storage of the slot.
+func (b *Builder) buildStructLiteral(expr *ast.StructLiteralExpr, exprType types.Type) *Value {
+	structType, ok := exprType.(*types.StructType)
+	if !ok {
+		b.error(expr.Pos(), fmt.Sprintf("struct literal of non-struct type %s", exprType))
+		return b.currentFunc.NewTemp(types.Invalid)
+	}
+
+	values := make([]*Value, len(structType.Fields))
+	for i, field := range expr.Fields {
+		index := i
+		if field.Name != nil {
+			index = structType.FieldIndex(field.Name.Name)
+		}
+		values[index] = b.buildExpr(field.Value)
+	}
+
+	slot := b.currentFunc.NewTemp(structType)
+	b.currentBlock.AddInstruction(&Alloca{Dest: slot, Type: structType})
+	for i, field := range structType.Fields {
+		value := values[i]
+		if value == nil {
+			if value = zeroConstant(field.Type); value == nil {
+				continue
+			}
+		}
+		address := b.currentFunc.NewTemp(types.Underlying(field.Type))
+		b.currentBlock.AddInstruction(&GetFieldPtr{Dest: address, Base: slot, FieldIndex: i})
+		b.currentBlock.AddInstruction(&Store{Address: address, Value: value})
+	}
+
+	result := b.currentFunc.NewTemp(structType)
+	b.currentBlock.AddInstruction(&Load{Dest: result, Address: slot})
+	return result
+}
+
+// buildArrayLiteral generates IR for an array literal, which fills a fresh
+// slot element by element, like a struct literal does field by field.
+//
+// EXAMPLE: [4, 5]
+//
+//	t0 = alloca [2]int
+//	t1 = &t0[const(0)]
+//	store const(4), t1
+//	t2 = &t0[const(1)]
+//	store const(5), t2
+//	t3 = load t0
+//
+// The indices are constants within the literal's own length, so no bounds
+// check is emitted for them even with EmitBoundsChecks.
+func (b *Builder) buildArrayLiteral(expr *ast.ArrayLiteralExpr, exprType types.Type) *Value {
+	arrayType, ok := exprType.(*types.ArrayType)
+	if !ok {
+		b.error(expr.Pos(), fmt.Sprintf("array literal of non-array type %s", exprType))
+		return b.currentFunc.NewTemp(types.Invalid)
+	}
+
+	values := make([]*Value, len(expr.Elements))
+	for i, elem := range expr.Elements {
+		values[i] = b.buildExpr(elem)
+	}
+
+	slot := b.currentFunc.NewTemp(arrayType)
+	b.currentBlock.AddInstruction(&Alloca{Dest: slot, Type: arrayType})
+	for i, value := range values {
+		address := b.currentFunc.NewTemp(types.Underlying(arrayType.ElementType))
+		b.currentBlock.AddInstruction(&GetElementPtr{Dest: address, Base: slot, Index: intConstant(int64(i))})
+		b.currentBlock.AddInstruction(&Store{Address: address, Value: value})
+	}
+
+	result := b.currentFunc.NewTemp(arrayType)
+	b.currentBlock.AddInstruction(&Load{Dest: result, Address: slot})
+	return result
+}
+
+// zeroConstant returns the zero value of a scalar type as a constant
+// operand, or nil for an aggregate type.
+func zeroConstant(t types.Type) *Value {
+	var zero interface{}
+	switch types.Underlying(t).(type) {
+	case *types.IntType:
+		zero = int64(0)
+	case *types.FloatType:
+		zero = float64(0)
+	case *types.BoolType:
+		zero = false
+	case *types.StringType:
+		zero = ""
+	case *types.CharType:
+		zero = rune(0)
+	default:
+		return nil
+	}
+	return &Value{ID: -1, Type: types.Underlying(t), Kind: ValueConstant, Constant: zero}
+}
+
 // buildIdentifier generates IR for an identifier reference.
 func (b *Builder) buildIdentifier(expr *ast.IdentifierExpr) *Value {
 	// Try named values first (local variables and parameters)
-	if val, ok := b.namedValues[expr.Name]; ok {
+	if val, ok := b.lookup(expr.Name); ok {
+		if b.slots[val] {
+			result := b.currentFunc.NewTemp(val.Type)
+			b.currentBlock.AddInstruction(&Load{Dest: result, Address: val})
+			return result
+		}
 		return val
 	}
 
 	// Check if it's a function - create a function reference
 	scope := b.analyzer.GetScope()
-	symbol := scope.Lookup(expr.Name)
+	symbol := scope.Resolve(expr.Name)
+	if symbol != nil && symbol.Kind == symtab.SymbolBuiltin {
+		return &Value{
+			ID:   -1,
+			Name: BuiltinPrefix + expr.Name,
+			Type: symbol.Type,
+			Kind: ValueVariable,
+		}
+	}
 	if symbol != nil && symbol.Kind == symtab.SymbolFunction {
 		// Create a function value reference
 		return &Value{
 			ID:   -1, // Functions don't need IDs
-			Name: expr.Name,
+			Name: b.qualify(expr.Name),
 			Type: symbol.Type,
 			Kind: ValueVariable, // Treat as variable for now
 		}
@@ -529,7 +1291,7 @@ func (b *Builder) buildIdentifier(expr *ast.IdentifierExpr) *Value {
 	// Try symbol-based lookup for globals
 	if symbol == nil {
 		b.error(expr.Pos(), "undefined variable")
-		return b.currentFunc.NewTemp(types.Invalid)
+		return b.invalidValue()
 	}
 
 	if val, ok := b.variables[symbol]; ok {
@@ -537,11 +1299,374 @@ func (b *Builder) buildIdentifier(expr *ast.IdentifierExpr) *Value {
 	}
 
 	b.error(expr.Pos(), "variable not mapped to IR value")
+	return b.invalidValue()
+}
+
+// invalidValue returns a value of Invalid type to stand for an expression
+// that has been reported as an error, so building carries on. Outside a
+// function there is no function to hold a temporary, so the value belongs
+// to none.
+func (b *Builder) invalidValue() *Value {
+	if b.currentFunc == nil {
+		return &Value{ID: -1, Type: types.Invalid, Kind: ValueTemporary}
+	}
+	return b.currentFunc.NewTemp(types.Invalid)
+}
+
+// buildPackageMember generates a reference to an imported package's
+// function or global ("geometry.Area"). Returns nil if expr is not a
+// qualified reference (that is, it is a struct field access).
+//
+// Globals resolve to the imported package's own values (see SetExternals).
+// Functions are referenced by qualified name, exactly like a call to a
+// function of this package is by bare name.
+func (b *Builder) buildPackageMember(expr *ast.MemberExpr, exprType types.Type) *Value {
+	ident, ok := expr.Object.(*ast.IdentifierExpr)
+	if !ok {
+		return nil
+	}
+	if _, isLocal := b.lookup(ident.Name); isLocal {
+		return nil
+	}
+	symbol := b.analyzer.GetScope().Resolve(ident.Name)
+	if symbol == nil || symbol.Kind != symtab.SymbolPackage {
+		return nil
+	}
+	name := symbol.ImportPath + "." + expr.Member.Name
+	if global, ok := b.externals[name]; ok {
+		return global
+	}
+	return &Value{
+		ID:   -1,
+		Name: name,
+		Type: exprType,
+		Kind: ValueVariable,
+	}
+}
+
+// buildFieldAddress generates the address of a struct field, or returns nil
+// if expr doesn't select one (it names a member of a package).
+//
+// EXAMPLE: line.to.x, with line a local
+//
+//	t1 = &line.field1    // line.to
+//	t2 = &t1.field0      // line.to.x
+//
+// DESIGN CHOICE: A field of a field is addressed through the outer field's
+// address rather than a loaded copy of it. Reading works either way, but
+// "line.to.x = 5" must store into line itself, and one lowering serves
+// both. The innermost base is the struct value itself - a variable,
+// parameter or call result - which GetFieldPtr addresses in place.
+func (b *Builder) buildFieldAddress(expr *ast.MemberExpr) *Value {
+	structType, ok := types.Underlying(b.analyzer.GetExprType(expr.Object)).(*types.StructType)
+	if !ok {
+		return nil
+	}
+	index := structType.FieldIndex(expr.Member.Name)
+	if index < 0 {
+		return nil
+	}
+
+	base := b.buildAggregate(expr.Object)
+	address := b.currentFunc.NewTemp(types.Underlying(structType.Fields[index].Type))
+	b.currentBlock.AddInstruction(&GetFieldPtr{Dest: address, Base: base, FieldIndex: index})
+	return address
+}
+
+// buildElementAddress generates the address of an array element, or returns
+// nil if expr doesn't index an array.
+//
+// EXAMPLE: grid[i][j] = 5, with EmitBoundsChecks
+//
+//	call $checkIndex(grid, i)
+//	t1 = &grid[i]
+//	call $checkIndex(t1, j)
+//	t2 = &t1[j]
+//	store 5, t2
+//
+// As with fields, an element of an element (or of a field, or a field of an
+// element: "points[i].x") is addressed through the outer address, so that
+// the same lowering stores into the variable itself.
+func (b *Builder) buildElementAddress(expr *ast.IndexExpr) *Value {
+	arrayType, ok := types.Underlying(b.analyzer.GetExprType(expr.Object)).(*types.ArrayType)
+	if !ok {
+		return nil
+	}
+
+	base := b.buildAggregate(expr.Object)
+	index := b.buildExpr(expr.Index)
+	if b.EmitBoundsChecks {
+		b.currentBlock.AddInstruction(&Call{
+			Function: &Value{ID: -1, Name: BuiltinPrefix + "checkIndex", Type: types.Invalid, Kind: ValueVariable},
+			Args:     []*Value{base, index},
+		})
+	}
+	address := b.currentFunc.NewTemp(types.Underlying(arrayType.ElementType))
+	b.currentBlock.AddInstruction(&GetElementPtr{Dest: address, Base: base, Index: index})
+	return address
+}
+
+// buildAggregate generates the base of a field or element access: the
+// address of the struct or array when it is itself a field or an element,
+// lives in a slot or is pointed at ("(*p).x"), and otherwise its value (a
+// variable, parameter or call result).
+func (b *Builder) buildAggregate(expr ast.Expr) *Value {
+	switch e := expr.(type) {
+	case *ast.GroupingExpr:
+		return b.buildAggregate(e.Expression)
+	case *ast.IdentifierExpr:
+		if val, ok := b.lookup(e.Name); ok && b.slots[val] {
+			return val
+		}
+	case *ast.UnaryExpr:
+		if e.Operator.Type == lexer.TokenStar {
+			return b.buildExpr(e.Operand)
+		}
+	case *ast.MemberExpr:
+		if address := b.buildFieldAddress(e); address != nil {
+			return address
+		}
+	case *ast.IndexExpr:
+		if address := b.buildElementAddress(e); address != nil {
+			return address
+		}
+	}
+	return b.buildExpr(expr)
+}
+
+// buildBuiltinValue generates the builtins that aren't an ordinary call of
+// their arguments, reporting whether callee is one of them:
+//
+//   - len(a) and cap(a) of a fixed-size array are its length, a constant.
+//     The array is still evaluated, for whatever its expression does
+//   - new(T) has a type for its argument: "t = call $new()" allocates the
+//     T that t's type, *T, points at
+//
+// len of a string is left to the general path, as "t = call $len(s)".
+func (b *Builder) buildBuiltinValue(callee *ast.IdentifierExpr, expr *ast.CallExpr, resultType types.Type) (*Value, bool) {
+	if _, isLocal := b.lookup(callee.Name); isLocal {
+		return nil, false
+	}
+	symbol := b.analyzer.GetScope().Resolve(callee.Name)
+	if symbol == nil || symbol.Kind != symtab.SymbolBuiltin {
+		return nil, false
+	}
+
+	switch callee.Name {
+	case "len", "cap":
+		arrayType, ok := types.Underlying(b.analyzer.GetExprType(expr.Args[0])).(*types.ArrayType)
+		if !ok || arrayType.Size < 0 {
+			return nil, false
+		}
+		b.buildExpr(expr.Args[0])
+		return intConstant(int64(arrayType.Size)), true
+	case "new":
+		result := b.currentFunc.NewTemp(resultType)
+		b.currentBlock.AddInstruction(&Call{
+			Dest:     result,
+			Function: &Value{ID: -1, Name: BuiltinPrefix + "new", Type: symbol.Type, Kind: ValueVariable},
+		})
+		return result, true
+	}
+	return nil, false
+}
+
+// buildAddress generates the address of an addressable expression, the
+// operand of &.
+//
+// EXAMPLE: &n, then &p.y, with n and p locals whose address is taken
+//
+//	n.1 = alloca int         // at n's declaration
+//	p.2 = alloca struct Point
+//	t3 = &p.2.field1         // &p.y; &n is n.1 itself
+//
+// DESIGN CHOICE: A variable whose address is taken anywhere in the function
+// lives in a slot for all of it, not just from the & on. Every read of it is
+// then a Load and every assignment a Store - including the ones before the
+// &, in a loop that takes it on a later iteration - so a store through a
+// pointer is seen by the next read of the name, and no optimizer pass ever
+// takes the variable for a value it can fold or copy-propagate. Variables
+// whose address isn't taken are unaffected and stay plain values. The
+// analysis is by name, so a variable shadowing one whose address is taken
+// also gets a slot; that only costs the loads.
+//
+// Addresses are typed with what they point at, as the slots of literals
+// are, rather than with a pointer type, so &n is simply n's slot.
+func (b *Builder) buildAddress(expr ast.Expr) *Value {
+	switch e := expr.(type) {
+	case *ast.GroupingExpr:
+		return b.buildAddress(e.Expression)
+	case *ast.IdentifierExpr:
+		if val, ok := b.lookup(e.Name); ok && b.slots[val] {
+			return val
+		}
+	case *ast.MemberExpr:
+		if address := b.buildFieldAddress(e); address != nil {
+			return address
+		}
+	case *ast.IndexExpr:
+		if address := b.buildElementAddress(e); address != nil {
+			return address
+		}
+	case *ast.UnaryExpr:
+		if e.Operator.Type == lexer.TokenStar {
+			return b.buildExpr(e.Operand)
+		}
+	}
+	b.error(expr.Pos(), "cannot take the address of this expression")
 	return b.currentFunc.NewTemp(types.Invalid)
 }
 
+// newSlot allocates the slot of a local or parameter whose address is
+// taken, zeroed until it is stored to.
+//
+// The slot is on the stack unless the variable escapes (see
+// semantic/escape.go): then a pointer to it may be used after the function
+// returns, and the slot is allocated as new(T) would be, once each time
+// the declaration runs.
+func (b *Builder) newSlot(name string, t types.Type, escapes bool) *Value {
+	if escapes {
+		slot := b.currentFunc.NewValue(name, types.NewPointer(t), ValueVariable)
+		b.currentFunc.Locals = append(b.currentFunc.Locals, slot)
+		b.currentBlock.AddInstruction(&Call{
+			Dest:     slot,
+			Function: &Value{ID: -1, Name: BuiltinPrefix + "new", Type: types.Invalid, Kind: ValueVariable},
+		})
+		b.slots[slot] = true
+		return slot
+	}
+	slot := b.currentFunc.NewValue(name, t, ValueVariable)
+	b.currentFunc.Locals = append(b.currentFunc.Locals, slot)
+	b.currentBlock.AddInstruction(&Alloca{Dest: slot, Type: t})
+	b.slots[slot] = true
+	return slot
+}
+
+// pushScope opens a scope for the names declared from here until the
+// matching popScope. The builder opens one wherever the analyzer does - a
+// block, a for statement (for its loop variable), a switch - so that a name
+// means the same variable to both.
+//
+// DESIGN CHOICE: Resolve a local by name through a stack of scopes, rather
+// than through the analyzer's symbols. The analyzer doesn't record which
+// symbol an identifier resolved to, and mirroring its scopes needs nothing
+// from it: "var x" in a block hides the outer x until the block ends, and
+// sibling blocks each get an x of their own, just as the analyzer saw it.
+func (b *Builder) pushScope() {
+	b.scopes = append(b.scopes, make(map[string]*Value))
+}
+
+// popScope closes the innermost scope: the names declared in it go out of
+// scope, and any they hid are visible again.
+func (b *Builder) popScope() {
+	b.scopes = b.scopes[:len(b.scopes)-1]
+}
+
+// define declares name in the innermost scope as v.
+func (b *Builder) define(name string, v *Value) {
+	b.scopes[len(b.scopes)-1][name] = v
+}
+
+// lookup finds the value of the local or parameter name in the innermost
+// scope that declares it. Outside a function nothing is in scope.
+func (b *Builder) lookup(name string) (*Value, bool) {
+	for i := len(b.scopes) - 1; i >= 0; i-- {
+		if v, ok := b.scopes[i][name]; ok {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// addressTakenNames returns the names of the variables whose address body
+// takes: the variable at the root of each & operand ("&n", "&p.y",
+// "&grid[i][j]"). The root of "&*q" is what q points at, which already
+// had its address taken.
+func addressTakenNames(body *ast.BlockStmt) map[string]bool {
+	names := make(map[string]bool)
+	if body == nil {
+		return names
+	}
+	ast.Inspect(body, func(node ast.Node) bool {
+		unary, ok := node.(*ast.UnaryExpr)
+		if !ok || unary.Operator.Type != lexer.TokenBitAnd {
+			return true
+		}
+		if root := addressRoot(unary.Operand); root != nil {
+			names[root.Name] = true
+		}
+		return true
+	})
+	return names
+}
+
+// assignedNames returns the names of the variables body assigns as a whole
+// ("n = 1", "n += 1", "n++"). Assigning a field or element ("p.x = 1") writes the
+// memory the variable refers to, not the variable, so it doesn't count.
+func assignedNames(body *ast.BlockStmt) map[string]bool {
+	names := make(map[string]bool)
+	if body == nil {
+		return names
+	}
+	ast.Inspect(body, func(node ast.Node) bool {
+		var target ast.Expr
+		switch e := node.(type) {
+		case *ast.AssignmentExpr:
+			target = e.Target
+		case *ast.UnaryExpr:
+			if e.Operator.Type != lexer.TokenPlusPlus && e.Operator.Type != lexer.TokenMinusMinus {
+				return true
+			}
+			target = e.Operand
+		default:
+			return true
+		}
+		for {
+			grouping, ok := target.(*ast.GroupingExpr)
+			if !ok {
+				break
+			}
+			target = grouping.Expression
+		}
+		if ident, ok := target.(*ast.IdentifierExpr); ok {
+			names[ident.Name] = true
+		}
+		return true
+	})
+	return names
+}
+
+// addressRoot returns the variable whose storage expr is part of, or nil if
+// expr is a dereference (or isn't addressable at all).
+func addressRoot(expr ast.Expr) *ast.IdentifierExpr {
+	switch e := expr.(type) {
+	case *ast.IdentifierExpr:
+		return e
+	case *ast.GroupingExpr:
+		return addressRoot(e.Expression)
+	case *ast.MemberExpr:
+		return addressRoot(e.Object)
+	case *ast.IndexExpr:
+		return addressRoot(e.Object)
+	default:
+		return nil
+	}
+}
+
 // buildCall generates IR for a function call.
 func (b *Builder) buildCall(expr *ast.CallExpr, resultType types.Type) *Value {
+	// A conversion only changes the type the analyzer checks against, or
+	// for integers the width the value is wrapped to
+	if b.analyzer.IsConversion(expr) {
+		return b.convert(b.buildExpr(expr.Args[0]), resultType)
+	}
+
+	if ident, ok := expr.Callee.(*ast.IdentifierExpr); ok {
+		if value, ok := b.buildBuiltinValue(ident, expr, resultType); ok {
+			return value
+		}
+	}
+
 	function := b.buildExpr(expr.Callee)
 
 	args := make([]*Value, len(expr.Args))
@@ -564,38 +1689,118 @@ func (b *Builder) buildCall(expr *ast.CallExpr, resultType types.Type) *Value {
 }
 
 // buildAssignment generates IR for an assignment.
+//
+// A compound assignment reads the target, applies its operator and writes
+// the result back. The target is evaluated once: in "a[f()] += 1" the
+// address is computed once and both loaded from and stored to.
+//
+// EXAMPLE: x += 5, then p.n += 5
+//
+//	t1 = x               // the current value
+//	t2 = t1 + const(5)
+//	x = t2
+//	t3 = &p.field0
+//	t4 = load t3
+//	t5 = t4 + const(5)
+//	store t5, t3
 func (b *Builder) buildAssignment(expr *ast.AssignmentExpr) *Value {
 	value := b.buildExpr(expr.Value)
+	op, compound := compoundOperators[expr.Operator.Type]
+	targetType := types.Underlying(b.analyzer.GetExprType(expr))
+	result, _ := b.assign(expr.Target, targetType, op, compound, value)
+	return result
+}
+
+// buildIncrement generates IR for ++ and --, as the compound assignments
+// "x += 1" and "x -= 1". The value of i++ is i's value before the
+// increment, and of ++i the value after.
+func (b *Builder) buildIncrement(expr *ast.UnaryExpr, resultType types.Type) *Value {
+	op := OpAdd
+	if expr.Operator.Type == lexer.TokenMinusMinus {
+		op = OpSub
+	}
+	var one interface{} = int64(1)
+	if _, ok := resultType.(*types.FloatType); ok {
+		one = float64(1)
+	}
+	value := &Value{ID: -1, Type: resultType, Kind: ValueConstant, Constant: one}
+
+	target := expr.Operand
+	for {
+		grouping, ok := target.(*ast.GroupingExpr)
+		if !ok {
+			break
+		}
+		target = grouping.Expression
+	}
+	result, previous := b.assign(target, resultType, op, true, value)
+	if expr.IsPostfix {
+		return previous
+	}
+	return result
+}
+
+// assign stores value to target, first applying op to the target's current
+// value when compound. It returns the value of the assignment and, when
+// compound, the target's value before it.
+func (b *Builder) assign(targetExpr ast.Expr, targetType types.Type, op BinaryOperator, compound bool, value *Value) (*Value, *Value) {
+	var current *Value
 
 	// Get target
-	if ident, ok := expr.Target.(*ast.IdentifierExpr); ok {
-		// Try named values first
-		if target, ok := b.namedValues[ident.Name]; ok {
+	if ident, ok := targetExpr.(*ast.IdentifierExpr); ok {
+		// Try named values first, then the symbol (a global)
+		target, ok := b.lookup(ident.Name)
+		if !ok {
+			if symbol := b.analyzer.GetScope().Resolve(ident.Name); symbol != nil {
+				target, ok = b.variables[symbol]
+			}
+		}
+		if ok && b.slots[target] {
+			if compound {
+				current = b.currentFunc.NewTemp(targetType)
+				b.currentBlock.AddInstruction(&Load{Dest: current, Address: target})
+				value = b.emitBinary(op, current, value, targetType)
+			}
+			b.currentBlock.AddInstruction(&Store{Address: target, Value: value})
+			return value, current
+		}
+		if ok {
+			if compound {
+				current = b.currentFunc.NewTemp(targetType)
+				b.currentBlock.AddInstruction(&Copy{Dest: current, Value: target})
+				value = b.emitBinary(op, current, value, targetType)
+			}
 			b.currentBlock.AddInstruction(&Copy{
 				Dest:  target,
 				Value: value,
 			})
-			return target
+			return target, current
 		}
+	}
 
-		// Try symbol-based lookup
-		scope := b.analyzer.GetScope()
-		symbol := scope.Lookup(ident.Name)
-		if symbol != nil {
-			if target, ok := b.variables[symbol]; ok {
-				b.currentBlock.AddInstruction(&Copy{
-					Dest:  target,
-					Value: value,
-				})
-				return target
-			}
+	var address *Value
+	switch target := targetExpr.(type) {
+	case *ast.MemberExpr:
+		address = b.buildFieldAddress(target)
+	case *ast.IndexExpr:
+		address = b.buildElementAddress(target)
+	case *ast.UnaryExpr:
+		// "*p = v" stores through p
+		address = b.buildExpr(target.Operand)
+	}
+	if address != nil {
+		if compound {
+			current = b.currentFunc.NewTemp(targetType)
+			b.currentBlock.AddInstruction(&Load{Dest: current, Address: address})
+			value = b.emitBinary(op, current, value, targetType)
 		}
+		b.currentBlock.AddInstruction(&Store{Address: address, Value: value})
 	}
 
-	return value
+	return value, current
 }
 
 // error records an IR generation error.
 func (b *Builder) error(pos lexer.Position, message string) {
-	b.errors = append(b.errors, fmt.Errorf("%s: %s", pos.String(), message))
+	b.errors = append(b.errors, errors.New(errors.CodeIR, pos, message))
 }
@@ -0,0 +1,768 @@
+package ir
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hassan/compiler/internal/lexer"
+	"github.com/hassan/compiler/internal/parser"
+	"github.com/hassan/compiler/internal/parser/ast"
+	"github.com/hassan/compiler/internal/semantic"
+	"github.com/hassan/compiler/internal/semantic/types"
+)
+
+// parse parses source, which must be free of syntax errors.
+func parse(t *testing.T, source string) *ast.File {
+	t.Helper()
+	file, errs := parser.New(lexer.New(source, "test.src")).ParseFile("test.src")
+	if len(errs) > 0 {
+		t.Fatalf("parse: %v", errs)
+	}
+	return file
+}
+
+// expectError fails unless one of errs contains want.
+func expectError(t *testing.T, errs []error, want string) {
+	t.Helper()
+	for _, err := range errs {
+		if strings.Contains(err.Error(), want) {
+			return
+		}
+	}
+	t.Errorf("expected an error containing %q, got %v", want, errs)
+}
+
+// TestBuild_RefusesAfterSemanticErrors checks that the builder won't run
+// on what a failed analysis left behind: here a call to a variable, which
+// it would otherwise build as a call through an int.
+func TestBuild_RefusesAfterSemanticErrors(t *testing.T) {
+	file := parse(t, `package main
+var notAFunction int = 1;
+func main() int {
+    return notAFunction(1);
+}
+`)
+	analyzer := semantic.New()
+	semanticErrors := analyzer.Analyze(file)
+	if len(semanticErrors) == 0 {
+		t.Fatal("expected semantic errors")
+	}
+
+	module, errs := NewBuilder(analyzer).Build(file)
+	if module != nil {
+		t.Errorf("expected no module, got\n%s", module)
+	}
+	if len(errs) != 1 {
+		t.Fatalf("expected 1 error, got %v", errs)
+	}
+	expectError(t, errs, fmt.Sprintf("cannot build IR: semantic analysis reported %d error(s)", len(semanticErrors)))
+}
+
+func TestBuild_NoAnalyzer(t *testing.T) {
+	module, errs := NewBuilder(nil).Build(parse(t, "package main\nfunc main() {}\n"))
+	if module != nil {
+		t.Errorf("expected no module, got\n%s", module)
+	}
+	expectError(t, errs, "cannot build IR without a semantic analyzer")
+}
+
+// TestBuildIncremental_RefusesAfterSemanticErrors checks that a failed
+// line leaves the module as the lines before built it.
+func TestBuildIncremental_RefusesAfterSemanticErrors(t *testing.T) {
+	analyzer := semantic.New()
+	builder := NewBuilder(analyzer)
+	good := parse(t, "package main\nfunc one() int { return 1; }\n")
+	if errs := analyzer.AnalyzeIncremental(good.Decls); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	module, errs := builder.BuildIncremental(good.Decls)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+
+	bad := parse(t, "package main\nfunc two() int { return one() + true; }\n")
+	if errs := analyzer.AnalyzeIncremental(bad.Decls); len(errs) == 0 {
+		t.Fatal("expected semantic errors")
+	}
+	after, errs := builder.BuildIncremental(bad.Decls)
+	expectError(t, errs, "cannot build IR")
+	if after != module || len(module.Functions) != 1 {
+		t.Errorf("expected the module to keep only one(), got\n%s", after)
+	}
+}
+
+// TestBuild_MismatchedAST builds files other than the ones analyzed, as a
+// caller holding a stale or hand-edited AST might. What the analysis has
+// no answer for is reported at the declaration, not a panic.
+func TestBuild_MismatchedAST(t *testing.T) {
+	analyzer := semantic.New()
+	if errs := analyzer.Analyze(parse(t, "package main\nvar f int;\nfunc g(a int) {}\n")); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{"function declared as a variable", "package main\nfunc f() {}\n", "test.src:2:1: f has no function type"},
+		{"parameters that don't match", "package main\nfunc g(a int, b int) {}\n", "test.src:2:1: g has no function type"},
+		{"function never declared", "package main\nfunc h() {}\n", "test.src:2:1: function symbol not found"},
+		{"undefined variable", "package main\nfunc g(a int) { a = b; }\n", "test.src:2:21: undefined variable"},
+		{"local never analyzed", "package main\nfunc g(a int) { var s = \"x\"; }\n", "test.src:2:21: s has no type"},
+		{"global never declared", "package main\nvar missing int;\n", "test.src:2:5: variable symbol not found"},
+		{"break outside a loop", "package main\nfunc g(a int) { break; }\n", "test.src:2:17: break outside loop or switch"},
+		{"continue outside a loop", "package main\nfunc g(a int) { continue; }\n", "test.src:2:17: continue outside loop"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, errs := NewBuilder(analyzer).Build(parse(t, tt.source))
+			expectError(t, errs, tt.want)
+		})
+	}
+
+	t.Run("missing package clause", func(t *testing.T) {
+		module, errs := NewBuilder(analyzer).Build(&ast.File{Filename: "test.src"})
+		if len(errs) > 0 {
+			t.Fatal(errs)
+		}
+		if module.Name != "" || len(module.Functions) != 0 {
+			t.Errorf("expected an empty, unnamed module, got\n%s", module)
+		}
+	})
+}
+
+// TestBuildIdentifier_OutsideFunction checks that an identifier built with
+// no current function - as code outside any function would be - neither
+// panics nor resolves to a local of the function built before it.
+func TestBuildIdentifier_OutsideFunction(t *testing.T) {
+	file := parse(t, "package main\nfunc f() int { var local int = 1; return local; }\n")
+	analyzer := semantic.New()
+	if errs := analyzer.Analyze(file); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	builder := NewBuilder(analyzer)
+	if _, errs := builder.Build(file); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+
+	value := builder.buildIdentifier(&ast.IdentifierExpr{Name: "local"})
+	if value == nil || value.Type != types.Invalid {
+		t.Errorf("expected a value of invalid type, got %v", value)
+	}
+	expectError(t, builder.errors, "undefined variable")
+
+	if f := builder.buildIdentifier(&ast.IdentifierExpr{Name: "f"}); f == nil || f.Name != "f" {
+		t.Errorf("expected a reference to f, got %v", f)
+	}
+}
+
+// TestBuild_GlobalInitializers checks that a global starts at the value of
+// its constant initializer, folded and wrapped to the global's type, and
+// that one without an initializer has none to print.
+func TestBuild_GlobalInitializers(t *testing.T) {
+	file := parse(t, `package main
+var answer int = 42;
+var hour = 60 * 60;
+var low uint8 = (1 << 8) - 1;
+var ratio float = -(2.5);
+var on bool = !true;
+var name string = "hi";
+var letter char = 'q';
+var counter int;
+func main() {}
+`)
+	analyzer := semantic.New()
+	if errs := analyzer.Analyze(file); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	module, errs := NewBuilder(analyzer).Build(file)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+
+	want := []string{
+		"global answer.0: int = const(42)",
+		"global hour.1: int = const(3600)",
+		"global low.2: uint8 = const(255)",
+		"global ratio.3: float = const(-2.5)",
+		"global on.4: bool = const(false)",
+		"global name.5: string = const(hi)",
+		"global letter.6: char = const(113)",
+		"global counter.7: int\n",
+	}
+	printed := module.String()
+	for _, line := range want {
+		if !strings.Contains(printed, line) {
+			t.Errorf("expected %q in\n%s", line, printed)
+		}
+	}
+}
+
+// TestBuildIncremental_GlobalInitializers checks the one place a global
+// may start from something other than a constant: a REPL line, which runs
+// its initializer as an assignment, so the global itself has none.
+func TestBuildIncremental_GlobalInitializers(t *testing.T) {
+	analyzer := semantic.New()
+	builder := NewBuilder(analyzer)
+	line := parse(t, "package main\nfunc one() int { return 1; }\nvar n int = one();\n")
+	if errs := analyzer.AnalyzeIncremental(line.Decls); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	module, errs := builder.BuildIncremental(line.Decls)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if len(module.Globals) != 1 || module.Globals[0].Initializer != nil {
+		t.Errorf("expected n without an initializer, got\n%s", module)
+	}
+}
+
+// TestBuild_BreakContinue checks the control-flow graph break and continue
+// build: each jumps to its own loop's end or next iteration, records that
+// edge, and nothing after it in the same block is built.
+func TestBuild_BreakContinue(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{
+			"break in while",
+			`var i = 0;
+    while (i < 10) {
+        i = i + 1;
+        break;
+        i = i + 2;
+    }`,
+			`entry -> while.cond
+while.cond -> while.body while.end
+while.body -> while.end
+while.end ->
+`,
+		},
+		{
+			"continue in while",
+			`var i = 0;
+    while (i < 10) {
+        i = i + 1;
+        continue;
+        i = i + 2;
+    }`,
+			`entry -> while.cond
+while.cond -> while.body while.end
+while.body -> while.cond
+while.end ->
+`,
+		},
+		{
+			"nested loops",
+			`for (var i = 0; i < 3; i = i + 1) {
+        while (true) {
+            if (i == 2) { break; }
+            continue;
+        }
+        if (i == 1) { continue; }
+        break;
+    }`,
+			`entry -> for.cond
+for.cond -> for.body for.end
+for.body -> while.cond
+for.post -> for.cond
+for.end ->
+while.cond -> while.body while.end
+while.body -> if.then if.end
+while.end -> if.then if.end
+if.then -> while.end
+if.end -> while.cond
+if.then -> for.post
+if.end -> for.end
+`,
+		},
+		{
+			"break in switch",
+			`var i = 0;
+    while (i < 10) {
+        switch (i) {
+        case 1:
+            break;
+            i = 5;
+        default:
+            i = i + 1;
+        }
+        i = i + 1;
+    }`,
+			`entry -> while.cond
+while.cond -> while.body while.end
+while.body -> switch.case switch.default
+while.end ->
+switch.case -> switch.end
+switch.default -> switch.end
+switch.end -> while.cond
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := parse(t, "package main\nfunc main() {\n    "+tt.body+"\n}\n")
+			analyzer := semantic.New()
+			if errs := analyzer.Analyze(file); len(errs) > 0 {
+				t.Fatal(errs)
+			}
+			module, errs := NewBuilder(analyzer).Build(file)
+			if len(errs) > 0 {
+				t.Fatal(errs)
+			}
+			fn := module.Functions[0]
+			if errs := module.Verify(); len(errs) > 0 {
+				t.Fatalf("%v\n%s", errs, fn)
+			}
+			if got := edges(fn); got != tt.want {
+				t.Errorf("expected edges\n%sgot\n%s\n%s", tt.want, got, fn)
+			}
+		})
+	}
+}
+
+// TestBuild_TrailingIf checks the block an if that ends a function joins
+// into: a void function returns from it, and where nothing reaches it - an
+// if and else that both return - it is dropped rather than left with no
+// terminator.
+func TestBuild_TrailingIf(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   string
+		want string // the edges, or for a missing return the error
+	}{
+		{
+			"void without else",
+			"func f(a int) {\n    if (a > 0) {\n        println(a);\n    }\n}",
+			`entry -> if.then if.end
+if.then -> if.end
+if.end ->
+`,
+		},
+		{
+			"void with else",
+			"func f(a int) {\n    if (a > 0) {\n        println(a);\n    } else {\n        println(0);\n    }\n}",
+			`entry -> if.then if.else
+if.then -> if.end
+if.end ->
+if.else -> if.end
+`,
+		},
+		{
+			"void, returning from both branches",
+			"func f(a int) {\n    if (a > 0) {\n        return;\n    } else {\n        return;\n    }\n}",
+			`entry -> if.then if.else
+if.then ->
+if.else ->
+`,
+		},
+		{
+			"void, returning from then alone",
+			"func f(a int) {\n    if (a > 0) {\n        return;\n    }\n}",
+			`entry -> if.then if.end
+if.then ->
+if.end ->
+`,
+		},
+		{
+			"non-void with else",
+			"func f(a int) int {\n    if (a > 0) {\n        return a;\n    } else {\n        return 0;\n    }\n}",
+			`entry -> if.then if.else
+if.then ->
+if.else ->
+`,
+		},
+		{
+			"non-void, nested",
+			"func f(a int) int {\n    if (a > 0) {\n        if (a > 9) {\n            return 9;\n        } else {\n            return a;\n        }\n    } else {\n        return 0;\n    }\n}",
+			`entry -> if.then if.else
+if.then -> if.then if.else
+if.else ->
+if.then ->
+if.else ->
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := parse(t, "package main\n"+tt.fn+"\n")
+			analyzer := semantic.New()
+			if errs := analyzer.Analyze(file); len(errs) > 0 {
+				t.Fatal(errs)
+			}
+			module, errs := NewBuilder(analyzer).Build(file)
+			if len(errs) > 0 {
+				t.Fatal(errs)
+			}
+			fn := module.Functions[0]
+			if errs := module.Verify(); len(errs) > 0 {
+				t.Fatalf("%v\n%s", errs, fn)
+			}
+			if got := edges(fn); got != tt.want {
+				t.Errorf("expected edges\n%sgot\n%s\n%s", tt.want, got, fn)
+			}
+		})
+	}
+
+	// Without an else, the end is reached, and a function with a result
+	// has none to return there
+	t.Run("non-void without else", func(t *testing.T) {
+		file := parse(t, "package main\nfunc f(a int) int {\n    if (a > 0) {\n        return a;\n    }\n}\n")
+		analyzer := semantic.New()
+		errs := analyzer.Analyze(file)
+		expectError(t, errs, "test.src:6:1: missing return")
+	})
+}
+
+// edges lists the successors of each block of fn, one block a line.
+func edges(fn *Function) string {
+	var sb strings.Builder
+	for _, block := range fn.Blocks {
+		sb.WriteString(block.Label + " ->")
+		for _, succ := range block.Successors {
+			sb.WriteString(" " + succ.Label)
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// TestBuild_UnreachableCode checks that code after a return is reported
+// once, by the analyzer, and not built: the block the return ends holds
+// nothing after it.
+func TestBuild_UnreachableCode(t *testing.T) {
+	file := parse(t, "package main\nfunc f() int {\n    return 1;\n    var x = 2;\n    x = 3;\n}\n")
+	analyzer := semantic.New()
+	if errs := analyzer.Analyze(file); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	var unreachable []string
+	for _, warning := range analyzer.Warnings() {
+		if strings.HasSuffix(warning.Error(), "unreachable code") {
+			unreachable = append(unreachable, warning.Error())
+		}
+	}
+	if want := []string{"test.src:4:5: warning: unreachable code"}; !reflect.DeepEqual(unreachable, want) {
+		t.Errorf("expected %v, got %v", want, unreachable)
+	}
+
+	module, errs := NewBuilder(analyzer).Build(file)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if errs := module.Verify(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	fn := module.Functions[0]
+	if len(fn.Blocks) != 1 || len(fn.Entry.Instructions) != 1 {
+		t.Errorf("expected a block holding only the return, got\n%s", fn)
+	}
+}
+
+// TestBuild_LocalTypes checks that a local has the type the analyzer gave
+// it, whether declared or inferred from its initializer, in a register or
+// in a stack slot.
+func TestBuild_LocalTypes(t *testing.T) {
+	file := parse(t, `package main
+struct Point {
+    x int;
+    y int;
+}
+func main() {
+    var s = "hello";
+    var f = 2.5;
+    var c char = 'c';
+    var small uint8 = 7;
+    var p = Point{x: 1, y: 2};
+    var slot = "taken";
+    var q *string = &slot;
+}
+`)
+	analyzer := semantic.New()
+	if errs := analyzer.Analyze(file); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	module, errs := NewBuilder(analyzer).Build(file)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+
+	want := map[string]string{
+		"s":     "string",
+		"f":     "float",
+		"c":     "char",
+		"small": "uint8",
+		"p":     "struct Point",
+		"slot":  "string",
+		"q":     "*string",
+	}
+	got := make(map[string]string)
+	for _, local := range module.Functions[0].Locals {
+		got[local.Name] = local.Type.String()
+	}
+	for name, typ := range want {
+		if got[name] != typ {
+			t.Errorf("expected %s to be a %s, got %q\n%s", name, typ, got[name], module.Functions[0])
+		}
+	}
+}
+
+// TestBuild_AssignedParameters checks that a parameter the body assigns is
+// copied into a local on entry, so no instruction ever defines the
+// parameter itself, and that the function still computes what it should.
+func TestBuild_AssignedParameters(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		args   []interface{}
+		want   interface{}
+		locals int
+	}{
+		{
+			name:   "assigned once",
+			source: "func f(n int) int {\n    n = n + 1;\n    return n;\n}\n",
+			args:   []interface{}{4},
+			want:   int64(5),
+			locals: 1,
+		},
+		{
+			name:   "compound assignment",
+			source: "func f(n int) int {\n    n += 2;\n    return n;\n}\n",
+			args:   []interface{}{1},
+			want:   int64(3),
+			locals: 1,
+		},
+		{
+			name:   "assigned in a loop",
+			source: "func f(n int, step int) int {\n    var total int = 0;\n    while (n > 0) {\n        total = total + n;\n        n = n - step;\n    }\n    return total + step;\n}\n",
+			args:   []interface{}{5, 2},
+			want:   int64(11),
+			locals: 2,
+		},
+		{
+			name:   "only read",
+			source: "func f(n int) int {\n    return n * 2;\n}\n",
+			args:   []interface{}{6},
+			want:   int64(12),
+			locals: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := parse(t, "package main\n"+tt.source)
+			analyzer := semantic.New()
+			if errs := analyzer.Analyze(file); len(errs) > 0 {
+				t.Fatal(errs)
+			}
+			module, errs := NewBuilder(analyzer).Build(file)
+			if len(errs) > 0 {
+				t.Fatal(errs)
+			}
+			if errs := module.Verify(); len(errs) > 0 {
+				t.Fatal(errs)
+			}
+			fn := module.Functions[0]
+
+			for _, block := range fn.Blocks {
+				for _, instr := range block.Instructions {
+					if dest := instr.Result(); dest != nil && dest.Kind == ValueParameter {
+						t.Errorf("%s: %q assigns a parameter\n%s", block.Label, instr, fn)
+					}
+				}
+			}
+			if len(fn.Locals) != tt.locals {
+				t.Errorf("expected %d locals, got %v\n%s", tt.locals, fn.Locals, fn)
+			}
+			got, err := Interpret(fn, tt.args...)
+			if err != nil || got != tt.want {
+				t.Errorf("f%v: expected %v, got %v (error %v)\n%s", tt.args, tt.want, got, err, fn)
+			}
+		})
+	}
+}
+
+// TestBuild_Scopes checks that a name means the variable the analyzer
+// resolved it to: a declaration in a block hides an outer one only until
+// the block ends, and sibling blocks each have their own.
+func TestBuild_Scopes(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		args   []interface{}
+		want   interface{}
+	}{
+		{
+			name:   "shadowed in an if",
+			source: "func f(n int) int {\n    var x int = 1;\n    if (n > 0) {\n        var x int = 2;\n        x = x + 10;\n    }\n    return x;\n}\n",
+			args:   []interface{}{1},
+			want:   int64(1),
+		},
+		{
+			name:   "sibling blocks",
+			source: "func f() int {\n    var x int = 1;\n    {\n        var x int = 2;\n        x = x * 3;\n    }\n    {\n        x = x + 10;\n    }\n    return x;\n}\n",
+			want:   int64(11),
+		},
+		{
+			name:   "same name in sibling blocks",
+			source: "func f(n int) int {\n    var total int = 0;\n    if (n > 0) {\n        var x int = n;\n        total = total + x;\n    } else {\n        var x int = 0 - n;\n        total = total + x * 2;\n    }\n    return total;\n}\n",
+			args:   []interface{}{-4},
+			want:   int64(8),
+		},
+		{
+			name:   "loop variable shadowed in the body",
+			source: "func f() int {\n    var total int = 0;\n    for (var i int = 0; i < 3; i = i + 1) {\n        var i int = 100;\n        total = total + i;\n    }\n    return total;\n}\n",
+			want:   int64(300),
+		},
+		{
+			name:   "loop variable after the loop",
+			source: "func f() int {\n    var i int = 7;\n    for (var i int = 0; i < 3; i = i + 1) {\n    }\n    return i;\n}\n",
+			want:   int64(7),
+		},
+		{
+			name:   "declared in a switch case",
+			source: "func f(n int) int {\n    var x int = 5;\n    switch (n) {\n    case 1:\n        var x int = 50;\n        return x;\n    }\n    return x;\n}\n",
+			args:   []interface{}{2},
+			want:   int64(5),
+		},
+		{
+			name:   "parameter shadowed",
+			source: "func f(n int) int {\n    if (true) {\n        var n int = 5;\n        n = n * 2;\n    }\n    return n;\n}\n",
+			args:   []interface{}{3},
+			want:   int64(3),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := parse(t, "package main\n"+tt.source)
+			analyzer := semantic.New()
+			if errs := analyzer.Analyze(file); len(errs) > 0 {
+				t.Fatal(errs)
+			}
+			module, errs := NewBuilder(analyzer).Build(file)
+			if len(errs) > 0 {
+				t.Fatal(errs)
+			}
+			if errs := module.Verify(); len(errs) > 0 {
+				t.Fatal(errs)
+			}
+			fn := module.Functions[0]
+			got, err := Interpret(fn, tt.args...)
+			if err != nil || got != tt.want {
+				t.Errorf("f%v: expected %v, got %v (error %v)\n%s", tt.args, tt.want, got, err, fn)
+			}
+		})
+	}
+}
+
+// manyFunctions returns a package of n functions that read and write the
+// same global, call each other, loop, branch and take addresses - each
+// one's IR different, so a function built in the wrong place shows.
+func manyFunctions(n int) string {
+	var sb strings.Builder
+	sb.WriteString("package main\n\nvar counter int = 0;\n\nstruct Pair {\n    a int;\n    b int;\n}\n\nfunc bump(p *int) {\n    *p = *p + 1;\n}\n")
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&sb, "\nfunc f%d(x int) int {\n", i)
+		switch i % 4 {
+		case 0:
+			fmt.Fprintf(&sb, "    var total int = 0;\n    for (var i int = 0; i < x; i = i + 1) {\n        total = total + i * %d;\n    }\n    return total;\n", i)
+		case 1:
+			fmt.Fprintf(&sb, "    if (x > %d && counter < 10) {\n        counter = counter + x;\n        return f%d(x - 1);\n    }\n    return %d;\n", i, i-1, i)
+		case 2:
+			fmt.Fprintf(&sb, "    var n int = x;\n    bump(&n);\n    while (n %% %d != 0) {\n        bump(&n);\n    }\n    return n;\n", i)
+		case 3:
+			fmt.Fprintf(&sb, "    var p Pair = Pair{a: x, b: %d};\n    switch (p.a) {\n    case 1:\n        return p.b;\n    default:\n        return p.a * p.b;\n    }\n", i)
+		}
+		sb.WriteString("}\n")
+	}
+	return sb.String()
+}
+
+// TestBuild_Parallel checks that building functions on goroutines of their
+// own produces the module building them one after another does, function
+// order included. Run it with -race to check the builds share nothing they
+// write.
+func TestBuild_Parallel(t *testing.T) {
+	file := parse(t, manyFunctions(20))
+	analyzer := semantic.New()
+	if errs := analyzer.Analyze(file); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+
+	sequential, errs := NewBuilder(analyzer).Build(file)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if len(sequential.Functions) != 21 {
+		t.Fatalf("expected 21 functions, got %d", len(sequential.Functions))
+	}
+	if verifyErrors := sequential.Verify(); len(verifyErrors) > 0 {
+		t.Fatal(verifyErrors)
+	}
+
+	for run := 0; run < 5; run++ {
+		builder := NewBuilder(analyzer)
+		builder.Parallel = true
+		parallel, errs := builder.Build(file)
+		if len(errs) > 0 {
+			t.Fatal(errs)
+		}
+		if got, want := parallel.String(), sequential.String(); got != want {
+			t.Fatalf("run %d: parallel build differs from sequential:\n%s", run, diffLines(want, got))
+		}
+	}
+}
+
+// TestBuild_ParallelErrors checks that the errors of a parallel build are
+// those of a sequential one, in the same order.
+func TestBuild_ParallelErrors(t *testing.T) {
+	var sb strings.Builder
+	sb.WriteString("package main\n")
+	for i := 0; i < 8; i++ {
+		fmt.Fprintf(&sb, "func f%d() { var x int = %d; }\n", i, i)
+	}
+	analyzer := semantic.New()
+	if errs := analyzer.Analyze(parse(t, sb.String())); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+
+	// A second parse of the same source has the same functions, but none
+	// of its locals were typed by the analyzer
+	file := parse(t, sb.String())
+
+	_, want := NewBuilder(analyzer).Build(file)
+	if len(want) != 8 {
+		t.Fatalf("expected an error in each function, got %v", want)
+	}
+	builder := NewBuilder(analyzer)
+	builder.Parallel = true
+	_, got := builder.Build(file)
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+// diffLines returns the lines of got that differ from want, numbered.
+func diffLines(want, got string) string {
+	w, g := strings.Split(want, "\n"), strings.Split(got, "\n")
+	var sb strings.Builder
+	for i := 0; i < len(w) || i < len(g); i++ {
+		var wl, gl string
+		if i < len(w) {
+			wl = w[i]
+		}
+		if i < len(g) {
+			gl = g[i]
+		}
+		if wl != gl {
+			fmt.Fprintf(&sb, "%d: expected %q\n%d: got      %q\n", i+1, wl, i+1, gl)
+		}
+	}
+	return sb.String()
+}
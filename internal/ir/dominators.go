@@ -0,0 +1,187 @@
+package ir
+
+// ComputeDominators fills in fn.IDom, fn.DominanceFrontier and every block's
+// Dominated list from the current CFG.
+//
+// WHAT IS DOMINANCE?
+// Block A dominates block B when every path from the entry to B goes
+// through A. The closest such A (other than B itself) is B's immediate
+// dominator, and linking every block to its immediate dominator gives the
+// dominator tree, rooted at the entry.
+//
+// EXAMPLE (a diamond):
+//
+//	entry -> then, entry -> else, then -> end, else -> end
+//	idom(then) = idom(else) = idom(end) = entry
+//	neither branch dominates end: it can be reached around either one
+//
+// ALGORITHM (Cooper, Harvey and Kennedy, "A Simple, Fast Dominance
+// Algorithm"):
+//  1. Number the blocks reachable from the entry in reverse postorder
+//  2. Set each block's idom to the intersection of its processed
+//     predecessors' idoms, walking up the tree until the two meet
+//  3. Repeat until no idom changes; one round suffices without loops
+//
+// DESIGN CHOICE: The iterative algorithm rather than Lengauer-Tarjan.
+// It is a fraction of the code, and on the small CFGs a function produces
+// it is as fast in practice; the paper measures it beating Lengauer-Tarjan
+// on graphs of the size real compilers see.
+//
+// DESIGN CHOICE: Works from the Predecessors and Successors lists, which
+// AddSuccessor keeps in step, rather than re-deriving edges from
+// terminators. That is the CFG every pass already edits and reads.
+//
+// Blocks unreachable from the entry take no part: they get no IDom entry,
+// no frontier and an empty Dominated list.
+func ComputeDominators(fn *Function) {
+	fn.IDom = make(map[*BasicBlock]*BasicBlock)
+	fn.DominanceFrontier = make(map[*BasicBlock][]*BasicBlock)
+	for _, block := range fn.Blocks {
+		block.Dominated = block.Dominated[:0]
+	}
+	if fn.Entry == nil {
+		return
+	}
+
+	order := reversePostorder(fn.Entry)
+	rpo := make(map[*BasicBlock]int, len(order))
+	for i, block := range order {
+		rpo[block] = i
+	}
+
+	// The entry is its own idom while iterating, so every walk up the tree
+	// stops there; it is removed again at the end
+	idom := fn.IDom
+	idom[fn.Entry] = fn.Entry
+	intersect := func(a, b *BasicBlock) *BasicBlock {
+		for a != b {
+			for rpo[a] > rpo[b] {
+				a = idom[a]
+			}
+			for rpo[b] > rpo[a] {
+				b = idom[b]
+			}
+		}
+		return a
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for _, block := range order[1:] {
+			var dom *BasicBlock
+			for _, pred := range block.Predecessors {
+				// Skip unreachable predecessors and ones not processed yet
+				if idom[pred] == nil {
+					continue
+				}
+				if dom == nil {
+					dom = pred
+				} else {
+					dom = intersect(pred, dom)
+				}
+			}
+			if idom[block] != dom {
+				idom[block] = dom
+				changed = true
+			}
+		}
+	}
+	delete(idom, fn.Entry)
+
+	// The tree's children, in function block order so output is stable
+	for _, block := range fn.Blocks {
+		if parent, ok := idom[block]; ok {
+			parent.Dominated = append(parent.Dominated, block)
+		}
+	}
+
+	computeDominanceFrontier(fn, rpo)
+}
+
+// computeDominanceFrontier fills in fn.DominanceFrontier from fn.IDom.
+//
+// A block's frontier is where its dominance stops: the join points it can
+// reach without dominating them. Those are where SSA construction places
+// phis for a variable assigned in the block.
+//
+// ALGORITHM (from the same paper): only a join point - a block with two or
+// more predecessors - is in anyone's frontier. For each predecessor of a
+// join point, walk up the dominator tree until reaching the join point's
+// idom; every block passed on the way has the join point in its frontier.
+func computeDominanceFrontier(fn *Function, rpo map[*BasicBlock]int) {
+	for _, block := range fn.Blocks {
+		if _, reachable := rpo[block]; !reachable || len(block.Predecessors) < 2 {
+			continue
+		}
+		stop := fn.IDom[block] // nil for the entry: walk all the way up
+		for _, pred := range block.Predecessors {
+			if _, reachable := rpo[pred]; !reachable {
+				continue
+			}
+			for runner := pred; runner != nil && runner != stop; runner = fn.IDom[runner] {
+				if !containsBlock(fn.DominanceFrontier[runner], block) {
+					fn.DominanceFrontier[runner] = append(fn.DominanceFrontier[runner], block)
+				}
+			}
+		}
+	}
+}
+
+// Dominates reports whether every path from the entry to b passes through a,
+// using the dominators from the last ComputeDominators. A block dominates
+// itself; an unreachable block is dominated by nothing else.
+func (f *Function) Dominates(a, b *BasicBlock) bool {
+	for ; b != nil; b = f.IDom[b] {
+		if a == b {
+			return true
+		}
+	}
+	return false
+}
+
+// reversePostorder returns the blocks reachable from entry in reverse
+// postorder: every block comes before its successors, except along the back
+// edge of a loop.
+//
+// DESIGN CHOICE: Explicit stack, like removeUnreachableBlocks in the DCE
+// pass, so a long chain of blocks can't overflow the goroutine stack.
+func reversePostorder(entry *BasicBlock) []*BasicBlock {
+	type frame struct {
+		block *BasicBlock
+		next  int // index of the next successor to visit
+	}
+	visited := map[*BasicBlock]bool{entry: true}
+	stack := []frame{{block: entry}}
+	var postorder []*BasicBlock
+
+	for len(stack) > 0 {
+		top := &stack[len(stack)-1]
+		if top.next < len(top.block.Successors) {
+			succ := top.block.Successors[top.next]
+			top.next++
+			if !visited[succ] {
+				visited[succ] = true
+				stack = append(stack, frame{block: succ})
+			}
+			continue
+		}
+		postorder = append(postorder, top.block)
+		stack = stack[:len(stack)-1]
+	}
+
+	order := make([]*BasicBlock, len(postorder))
+	for i, block := range postorder {
+		order[len(postorder)-1-i] = block
+	}
+	return order
+}
+
+// containsBlock reports whether block is in blocks.
+func containsBlock(blocks []*BasicBlock, block *BasicBlock) bool {
+	for _, b := range blocks {
+		if b == block {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,229 @@
+package ir
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/hassan/compiler/internal/semantic/types"
+)
+
+// graphFunction builds a function whose CFG has the given "from->to" edges.
+// Blocks are created in the order they are first named, "entry" being the
+// function's entry block. Dominance only looks at edges, so the blocks have
+// no instructions.
+func graphFunction(edges ...string) (*Function, map[string]*BasicBlock) {
+	fn := NewFunction("graph", nil, types.Void)
+	blocks := map[string]*BasicBlock{"entry": fn.Entry}
+	block := func(label string) *BasicBlock {
+		if b, ok := blocks[label]; ok {
+			return b
+		}
+		b := fn.NewBasicBlockInFunc(label)
+		blocks[label] = b
+		return b
+	}
+	for _, edge := range edges {
+		ends := strings.Split(edge, "->")
+		from, to := block(ends[0]), block(ends[1])
+		from.AddSuccessor(to)
+	}
+	return fn, blocks
+}
+
+// idoms renders fn.IDom as "block:idom" pairs in block order.
+func idoms(fn *Function) []string {
+	var pairs []string
+	for _, block := range fn.Blocks {
+		if idom, ok := fn.IDom[block]; ok {
+			pairs = append(pairs, block.Label+":"+idom.Label)
+		}
+	}
+	return pairs
+}
+
+// frontiers renders fn.DominanceFrontier as "block:a,b" in block order,
+// leaving out empty frontiers.
+func frontiers(fn *Function) []string {
+	var entries []string
+	for _, block := range fn.Blocks {
+		var labels []string
+		for _, b := range fn.DominanceFrontier[block] {
+			labels = append(labels, b.Label)
+		}
+		if len(labels) > 0 {
+			sort.Strings(labels)
+			entries = append(entries, block.Label+":"+strings.Join(labels, ","))
+		}
+	}
+	return entries
+}
+
+// labels returns the labels of blocks.
+func labels(blocks []*BasicBlock) []string {
+	var names []string
+	for _, b := range blocks {
+		names = append(names, b.Label)
+	}
+	return names
+}
+
+func TestComputeDominators(t *testing.T) {
+	tests := []struct {
+		name      string
+		edges     []string
+		idoms     []string
+		frontiers []string
+		dominated map[string][]string
+	}{
+		{
+			name:      "diamond",
+			edges:     []string{"entry->then", "entry->else", "then->end", "else->end"},
+			idoms:     []string{"then:entry", "else:entry", "end:entry"},
+			frontiers: []string{"then:end", "else:end"},
+			dominated: map[string][]string{"entry": {"then", "else", "end"}},
+		},
+		{
+			// while (cond) { body } with the body jumping back
+			name:      "loop",
+			edges:     []string{"entry->cond", "cond->body", "body->cond", "cond->exit"},
+			idoms:     []string{"cond:entry", "body:cond", "exit:cond"},
+			frontiers: []string{"cond:cond", "body:cond"},
+			dominated: map[string][]string{"entry": {"cond"}, "cond": {"body", "exit"}},
+		},
+		{
+			// a and b form a cycle entered at both blocks: neither dominates
+			// the other, and each is in the other's frontier
+			name:      "two entries into a cycle",
+			edges:     []string{"entry->a", "entry->b", "a->b", "b->a", "b->exit"},
+			idoms:     []string{"a:entry", "b:entry", "exit:b"},
+			frontiers: []string{"a:b", "b:a"},
+			dominated: map[string][]string{"entry": {"a", "b"}, "b": {"exit"}},
+		},
+		{
+			// dead is never reached, so its edge into end changes nothing
+			name:      "unreachable predecessor",
+			edges:     []string{"entry->end", "dead->end"},
+			idoms:     []string{"end:entry"},
+			dominated: map[string][]string{"entry": {"end"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fn, blocks := graphFunction(tt.edges...)
+			ComputeDominators(fn)
+
+			if got := idoms(fn); !reflect.DeepEqual(got, tt.idoms) {
+				t.Errorf("expected idoms %v, got %v", tt.idoms, got)
+			}
+			if got := frontiers(fn); !reflect.DeepEqual(got, tt.frontiers) {
+				t.Errorf("expected frontiers %v, got %v", tt.frontiers, got)
+			}
+			for label, block := range blocks {
+				if got, want := labels(block.Dominated), tt.dominated[label]; !reflect.DeepEqual(got, want) {
+					t.Errorf("expected %s to dominate %v, got %v", label, want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestFunction_Dominates(t *testing.T) {
+	fn, b := graphFunction("entry->then", "entry->else", "then->end", "else->end", "dead->end")
+	ComputeDominators(fn)
+
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"entry", "end", true},
+		{"end", "end", true},
+		{"then", "end", false},
+		{"end", "entry", false},
+		{"then", "else", false},
+		{"entry", "dead", false},
+		{"dead", "dead", true},
+	}
+	for _, tt := range tests {
+		if got := fn.Dominates(b[tt.a], b[tt.b]); got != tt.want {
+			t.Errorf("Dominates(%s, %s) = %v, expected %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestComputeDominators_Rerun(t *testing.T) {
+	fn, b := graphFunction("entry->a", "a->b")
+	ComputeDominators(fn)
+
+	// Route around a: b no longer depends on it
+	fn.Entry.AddSuccessor(b["b"])
+	ComputeDominators(fn)
+
+	if got, want := idoms(fn), []string{"a:entry", "b:entry"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected idoms %v, got %v", want, got)
+	}
+	if len(b["a"].Dominated) != 0 {
+		t.Errorf("expected a to dominate nothing, got %v", labels(b["a"].Dominated))
+	}
+}
+
+func TestFindLoops(t *testing.T) {
+	// An outer loop around an inner one, a continue-style second latch on
+	// the outer header, and a separate loop after them
+	fn, b := graphFunction(
+		"entry->outer",
+		"outer->inner", "inner->body", "body->inner", "inner->latch",
+		"latch->outer", "inner->outer",
+		"outer->second", "second->second", "second->exit",
+	)
+	info := FindLoops(fn)
+
+	if len(info.Loops) != 3 {
+		t.Fatalf("expected 3 loops, got %d", len(info.Loops))
+	}
+	outer, inner, second := info.Loops[0], info.Loops[1], info.Loops[2]
+
+	if outer.Header != b["outer"] || inner.Header != b["inner"] || second.Header != b["second"] {
+		t.Fatalf("expected loops headed by outer, inner and second, got %s, %s and %s",
+			outer.Header.Label, inner.Header.Label, second.Header.Label)
+	}
+	if got, want := labels(outer.Blocks), []string{"outer", "inner", "body", "latch"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected outer loop blocks %v, got %v", want, got)
+	}
+	if got, want := labels(outer.Latches), []string{"inner", "latch"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected outer loop latches %v, got %v", want, got)
+	}
+	if got, want := labels(inner.Blocks), []string{"inner", "body"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected inner loop blocks %v, got %v", want, got)
+	}
+	if got, want := labels(second.Blocks), []string{"second"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected a self-loop, got %v", got)
+	}
+
+	if inner.Parent != outer || outer.Parent != nil || second.Parent != nil {
+		t.Error("expected only the inner loop to be nested, in the outer one")
+	}
+	if len(outer.Children) != 1 || outer.Children[0] != inner {
+		t.Errorf("expected outer to have inner as its only child, got %d children", len(outer.Children))
+	}
+
+	if info.LoopFor(b["body"]) != inner || info.LoopFor(b["latch"]) != outer || info.LoopFor(b["exit"]) != nil {
+		t.Error("expected LoopFor to return the innermost loop of a block")
+	}
+	for label, want := range map[string]int{"entry": 0, "outer": 1, "body": 2, "second": 1, "exit": 0} {
+		if got := info.LoopDepth(b[label]); got != want {
+			t.Errorf("expected %s at depth %d, got %d", label, want, got)
+		}
+	}
+}
+
+func TestFindLoops_Irreducible(t *testing.T) {
+	// The a/b cycle has two entries, so neither block dominates the other
+	// and no edge of the cycle is a back edge: FindLoops reports nothing
+	fn, _ := graphFunction("entry->a", "entry->b", "a->b", "b->a", "b->exit")
+	if info := FindLoops(fn); len(info.Loops) != 0 {
+		t.Errorf("expected no natural loops, got %d", len(info.Loops))
+	}
+}
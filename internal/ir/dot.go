@@ -0,0 +1,192 @@
+package ir
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ToDOT renders the function's control-flow graph in Graphviz dot syntax,
+// for viewing with `dot -Tsvg`.
+//
+// Each basic block is a box listing its label and instructions; the entry
+// block is filled, and an instruction longer than maxDOTInstruction is cut
+// short. Edges follow the terminators, so a branch draws a "true" and a
+// "false" edge and a return draws none. An edge back to the top of a loop
+// is dashed, which makes each loop stand out from the forward flow.
+//
+// DESIGN CHOICE: Edges are read from the terminators rather than the
+// Successors lists because the terminators are what executes. A pass that
+// rewrites a jump without updating Successors then shows up in the picture
+// as it would at run time, which is usually the bug being looked for.
+func (f *Function) ToDOT() string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("digraph %s {\n", dotQuote(f.Name)))
+	sb.WriteString("\tnode [shape=box, fontname=\"monospace\"];\n")
+	f.writeDOT(&sb, "\t", "b")
+	sb.WriteString("}\n")
+	return sb.String()
+}
+
+// ToDOT renders every function of the module as one graph, each function in
+// its own labeled cluster.
+func (m *Module) ToDOT() string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("digraph %s {\n", dotQuote(m.Name)))
+	sb.WriteString("\tnode [shape=box, fontname=\"monospace\"];\n")
+	for i, fn := range m.Functions {
+		sb.WriteString(fmt.Sprintf("\tsubgraph cluster_%d {\n", i))
+		sb.WriteString(fmt.Sprintf("\t\tlabel = %s;\n", dotQuote(fn.Name)))
+		fn.writeDOT(&sb, "\t\t", fmt.Sprintf("f%d_b", i))
+		sb.WriteString("\t}\n")
+	}
+	sb.WriteString("}\n")
+	return sb.String()
+}
+
+// maxDOTInstruction is how many characters of an instruction a block's box
+// shows. A call with many arguments or a long string constant would
+// otherwise stretch the box, and with it the whole picture.
+const maxDOTInstruction = 60
+
+// writeDOT writes the nodes and edges of the function, naming each block
+// prefix followed by its position in Blocks. Labels can repeat within a
+// function, so they cannot serve as node names.
+func (f *Function) writeDOT(sb *strings.Builder, indent, prefix string) {
+	ids := make(map[*BasicBlock]string, len(f.Blocks))
+	for i, block := range f.Blocks {
+		ids[block] = fmt.Sprintf("%s%d", prefix, i)
+	}
+	id := func(block *BasicBlock) string {
+		if name, ok := ids[block]; ok {
+			return name
+		}
+		// A target missing from Blocks is a malformed CFG; draw it anyway
+		name := fmt.Sprintf("%s%d", prefix, len(ids))
+		ids[block] = name
+		sb.WriteString(fmt.Sprintf("%s%s [label=%s, color=red];\n", indent, name, dotQuote(block.Label+" (not in function)")))
+		return name
+	}
+
+	for _, block := range f.Blocks {
+		var label strings.Builder
+		label.WriteString(dotEscape(block.Label + ":"))
+		label.WriteString(`\l`)
+		for _, instr := range block.Instructions {
+			label.WriteString(dotEscape("  " + truncate(instr.String(), maxDOTInstruction)))
+			label.WriteString(`\l`)
+		}
+		attrs := ""
+		if block == f.Entry {
+			attrs = ", style=filled, fillcolor=lightgrey"
+		}
+		sb.WriteString(fmt.Sprintf("%s%s [label=\"%s\"%s];\n", indent, ids[block], label.String(), attrs))
+	}
+
+	back := f.backEdges()
+	edge := func(from, to *BasicBlock, label, style string) {
+		if style == "" && back[dotEdge{from, to}] {
+			style = "dashed"
+		}
+		var attrs []string
+		if label != "" {
+			attrs = append(attrs, fmt.Sprintf("label=%q", label))
+		}
+		if style != "" {
+			attrs = append(attrs, "style="+style)
+		}
+		list := ""
+		if len(attrs) > 0 {
+			list = " [" + strings.Join(attrs, ", ") + "]"
+		}
+		sb.WriteString(fmt.Sprintf("%s%s -> %s%s;\n", indent, ids[from], id(to), list))
+	}
+	for _, block := range f.Blocks {
+		switch term := block.Terminator().(type) {
+		case *Jump:
+			edge(block, term.Target, "", "")
+		case *Branch:
+			edge(block, term.TrueBlock, "true", "")
+			edge(block, term.FalseBlock, "false", "")
+		case nil:
+			// Not terminated yet: fall back to the recorded successors,
+			// dotted whether or not they go back
+			for _, succ := range block.Successors {
+				edge(block, succ, "", "dotted")
+			}
+		}
+	}
+}
+
+// dotEdge is an edge of the drawn graph.
+type dotEdge struct {
+	from, to *BasicBlock
+}
+
+// backEdges returns the edges that go back to a block still being visited
+// in a depth-first walk from the entry, which are the edges that close a
+// loop. The walk follows the same edges writeDOT draws.
+//
+// DESIGN CHOICE: A depth-first walk rather than FindLoops's dominator test.
+// Computing dominators overwrites fn.IDom, and drawing a function shouldn't
+// change it. On the loops the builder makes, which are only entered through
+// their header, the two find the same edges.
+func (f *Function) backEdges() map[dotEdge]bool {
+	const (
+		unvisited = iota
+		active
+		finished
+	)
+	state := make(map[*BasicBlock]int)
+	back := make(map[dotEdge]bool)
+
+	var visit func(block *BasicBlock)
+	visit = func(block *BasicBlock) {
+		state[block] = active
+		for _, succ := range dotTargets(block) {
+			switch state[succ] {
+			case active:
+				back[dotEdge{block, succ}] = true
+			case unvisited:
+				visit(succ)
+			}
+		}
+		state[block] = finished
+	}
+	if f.Entry != nil {
+		visit(f.Entry)
+	}
+	return back
+}
+
+// dotTargets returns the blocks writeDOT draws an edge to from block.
+func dotTargets(block *BasicBlock) []*BasicBlock {
+	switch term := block.Terminator().(type) {
+	case *Jump:
+		return []*BasicBlock{term.Target}
+	case *Branch:
+		return []*BasicBlock{term.TrueBlock, term.FalseBlock}
+	case nil:
+		return block.Successors
+	}
+	return nil
+}
+
+// truncate shortens s to at most max characters, marking the cut with "...".
+func truncate(s string, max int) string {
+	runes := []rune(s)
+	if len(runes) <= max {
+		return s
+	}
+	return string(runes[:max-3]) + "..."
+}
+
+// dotQuote returns s as a quoted dot ID.
+func dotQuote(s string) string {
+	return `"` + dotEscape(s) + `"`
+}
+
+// dotEscape escapes s for use inside a quoted dot string. Newlines become
+// left-justified line breaks so multi-line text keeps its alignment.
+func dotEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\l`).Replace(s)
+}
@@ -0,0 +1,162 @@
+package ir
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hassan/compiler/internal/semantic/types"
+)
+
+// ifElseFunction builds
+//
+//	func sign(x int) int { if x < 0 { return -1; } else { y = 1; } return y; }
+//
+// as four blocks: entry, if.then, if.else and if.end.
+func ifElseFunction() *Function {
+	x := &Value{ID: 0, Name: "x", Type: types.Int, Kind: ValueParameter}
+	fn := NewFunction("sign", []*Value{x}, types.Int)
+	thenBlock := fn.NewBasicBlockInFunc("if.then")
+	elseBlock := fn.NewBasicBlockInFunc("if.else")
+	endBlock := fn.NewBasicBlockInFunc("if.end")
+
+	cond := fn.NewTemp(types.Bool)
+	y := fn.NewValue("y", types.Int, ValueVariable)
+	zero := &Value{ID: -1, Type: types.Int, Kind: ValueConstant, Constant: int64(0)}
+	minusOne := &Value{ID: -1, Type: types.Int, Kind: ValueConstant, Constant: int64(-1)}
+	one := &Value{ID: -1, Type: types.Int, Kind: ValueConstant, Constant: int64(1)}
+
+	fn.Entry.AddInstruction(&BinaryOp{Dest: cond, Op: OpLt, Left: x, Right: zero})
+	fn.Entry.AddInstruction(&Branch{Condition: cond, TrueBlock: thenBlock, FalseBlock: elseBlock})
+	fn.Entry.AddSuccessor(thenBlock)
+	fn.Entry.AddSuccessor(elseBlock)
+	thenBlock.AddInstruction(&Return{Value: minusOne})
+	elseBlock.AddInstruction(&Copy{Dest: y, Value: one})
+	elseBlock.AddInstruction(&Jump{Target: endBlock})
+	elseBlock.AddSuccessor(endBlock)
+	endBlock.AddInstruction(&Return{Value: y})
+	return fn
+}
+
+func TestFunction_ToDOT(t *testing.T) {
+	got := ifElseFunction().ToDOT()
+
+	if !strings.HasPrefix(got, "digraph \"sign\" {\n") || !strings.HasSuffix(got, "}\n") {
+		t.Errorf("expected a digraph named sign, got:\n%s", got)
+	}
+	if nodes := strings.Count(got, "\\l\""); nodes != 4 {
+		t.Errorf("expected 4 block nodes, got %d:\n%s", nodes, got)
+	}
+	for _, want := range []string{
+		"\tb0 [label=\"entry:\\l  t1 = param(x.0) < const(0)\\l  branch t1, if.then, if.else\\l\", style=filled, fillcolor=lightgrey];\n",
+		"\tb1 [label=\"if.then:\\l  return const(-1)\\l\"];\n",
+		"\tb2 [label=\"if.else:\\l  y.2 = const(1)\\l  jump if.end\\l\"];\n",
+		"\tb3 [label=\"if.end:\\l  return y.2\\l\"];\n",
+		"\tb0 -> b1 [label=\"true\"];\n",
+		"\tb0 -> b2 [label=\"false\"];\n",
+		"\tb2 -> b3;\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected dot to contain %q, got:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "b1 ->") || strings.Contains(got, "b3 ->") {
+		t.Errorf("return blocks should have no outgoing edges:\n%s", got)
+	}
+}
+
+func TestFunction_ToDOT_Loop(t *testing.T) {
+	// while (n > 0) { n = report(n, "a long message"...); } return;
+	n := &Value{ID: 0, Name: "n", Type: types.Int, Kind: ValueParameter}
+	fn := NewFunction("countdown", []*Value{n}, types.Void)
+	cond := fn.NewBasicBlockInFunc("while.cond")
+	body := fn.NewBasicBlockInFunc("while.body")
+	end := fn.NewBasicBlockInFunc("while.end")
+
+	positive := fn.NewTemp(types.Bool)
+	zero := &Value{ID: -1, Type: types.Int, Kind: ValueConstant, Constant: int64(0)}
+	message := &Value{ID: -1, Type: types.String, Kind: ValueConstant, Constant: strings.Repeat("x", 80)}
+	report := &Value{ID: -1, Name: "report", Type: types.Int, Kind: ValueVariable}
+
+	fn.Entry.AddInstruction(&Jump{Target: cond})
+	cond.AddInstruction(&BinaryOp{Dest: positive, Op: OpGt, Left: n, Right: zero})
+	cond.AddInstruction(&Branch{Condition: positive, TrueBlock: body, FalseBlock: end})
+	body.AddInstruction(&Call{Dest: n, Function: report, Args: []*Value{n, message}})
+	body.AddInstruction(&Jump{Target: cond})
+	end.AddInstruction(&Return{})
+	got := fn.ToDOT()
+
+	for _, want := range []string{
+		"\tb0 -> b1;\n",
+		"\tb1 -> b2 [label=\"true\"];\n",
+		"\tb1 -> b3 [label=\"false\"];\n",
+		"\tb2 -> b1 [style=dashed];\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected dot to contain %q, got:\n%s", want, got)
+		}
+	}
+	if dashed := strings.Count(got, "dashed"); dashed != 1 {
+		t.Errorf("expected only the back edge dashed, got %d:\n%s", dashed, got)
+	}
+
+	// The call is cut short rather than widening its block
+	for _, line := range strings.Split(got, `\l`) {
+		if strings.HasPrefix(line, "  ") && len(line) > maxDOTInstruction+len("  ") {
+			t.Errorf("expected no instruction longer than %d, got %q", maxDOTInstruction, line)
+		}
+	}
+	if !strings.Contains(got, `xxx...\l  jump while.cond`) {
+		t.Errorf("expected the call to end in ..., got:\n%s", got)
+	}
+}
+
+func TestFunction_ToDOT_Unterminated(t *testing.T) {
+	// Blocks without terminators draw their successors dotted, even the one
+	// going back
+	fn, _ := graphFunction("entry->loop", "loop->loop", "loop->exit")
+	got := fn.ToDOT()
+	for _, want := range []string{
+		"\tb0 -> b1 [style=dotted];\n",
+		"\tb1 -> b1 [style=dotted];\n",
+		"\tb1 -> b2 [style=dotted];\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected dot to contain %q, got:\n%s", want, got)
+		}
+	}
+}
+
+func TestModule_ToDOT(t *testing.T) {
+	module := NewModule("main")
+	module.AddFunction(ifElseFunction())
+	module.AddFunction(ifElseFunction())
+	got := module.ToDOT()
+
+	for _, want := range []string{
+		"digraph \"main\" {\n",
+		"\tsubgraph cluster_0 {\n\t\tlabel = \"sign\";\n",
+		"\tsubgraph cluster_1 {\n",
+		"\t\tf0_b0 -> f0_b1 [label=\"true\"];\n",
+		"\t\tf1_b2 -> f1_b3;\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected dot to contain %q, got:\n%s", want, got)
+		}
+	}
+}
+
+func TestDotEscape(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"plain", "plain"},
+		{`t1 = copy "hi"`, `t1 = copy \"hi\"`},
+		{`a\b`, `a\\b`},
+		{"two\nlines", `two\llines`},
+	}
+	for _, tt := range tests {
+		if got := dotEscape(tt.in); got != tt.want {
+			t.Errorf("dotEscape(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,361 @@
+package ir
+
+import (
+	"fmt"
+
+	"github.com/hassan/compiler/internal/semantic/types"
+)
+
+// interpretStepLimit bounds the instructions one Interpret call executes, so
+// a function that loops forever - or was optimized into one that does -
+// fails instead of hanging the test that runs it.
+const interpretStepLimit = 1000000
+
+// Interpret runs fn on args and returns what it returns: an int64, float64
+// or bool, or nil for a function with no result. An int argument may be
+// passed as a Go int; other arguments must already be one of those three.
+//
+// WHY A SECOND INTERPRETER?
+// internal/interp runs whole programs: calls, memory, globals, builtins.
+// Interpret runs one function of pure arithmetic and control flow - the
+// instructions an optimization pass rewrites - and nothing else, which is
+// what checking a pass needs: run the function before the pass and after,
+// and the results must agree. Being this small, it is easy to trust as
+// the definition of what those instructions mean:
+//
+//   - Integers are int64, wrapped to the width of the value they are
+//     assigned to, as constant folding wraps them; the uint64 operations
+//     that depend on the sign bit (division, remainder, right shift and the
+//     orderings) are unsigned. Division by zero and a negative shift count
+//     are errors, as at run time.
+//   - Floats are float64 and follow IEEE 754, so dividing by zero is not an
+//     error.
+//   - A named variable that has not been assigned reads as zero, as a local
+//     declared without an initializer does. A temporary has no such default:
+//     reading one that was never assigned is an error, which is how a pass
+//     that deletes a definition still in use shows up.
+//   - The phis at the top of a block take the value for the block that
+//     jumped there, all at once, before anything else in the block runs.
+//     The builder's && and || join through a phi, so without them hardly a
+//     condition could be checked.
+//
+// Any other instruction (a call, a load, a store) is an error rather than
+// being skipped, so a function Interpret can't fully run never passes for
+// one it did.
+func Interpret(fn *Function, args ...interface{}) (interface{}, error) {
+	if len(args) != len(fn.Parameters) {
+		return nil, fmt.Errorf("%s takes %d arguments, got %d", fn.Name, len(fn.Parameters), len(args))
+	}
+	values := make(map[*Value]interface{})
+	for i, param := range fn.Parameters {
+		arg := args[i]
+		if n, ok := arg.(int); ok {
+			arg = int64(n)
+		}
+		values[param] = arg
+	}
+
+	steps := 0
+	var prev *BasicBlock
+	block := fn.Entry
+	for {
+		phis, err := enterBlock(values, block, prev)
+		if err != nil {
+			return nil, err
+		}
+
+		var next *BasicBlock
+		for _, instr := range block.Instructions[phis:] {
+			if steps++; steps > interpretStepLimit {
+				return nil, fmt.Errorf("%s: no result after %d instructions", fn.Name, interpretStepLimit)
+			}
+
+			switch i := instr.(type) {
+			case *Jump:
+				next = i.Target
+
+			case *Branch:
+				cond, err := operand(values, i.Condition)
+				if err != nil {
+					return nil, err
+				}
+				b, ok := cond.(bool)
+				if !ok {
+					return nil, fmt.Errorf("%s: branch on %v, not a bool", block.Label, cond)
+				}
+				next = i.FalseBlock
+				if b {
+					next = i.TrueBlock
+				}
+
+			case *Return:
+				if i.Value == nil {
+					return nil, nil
+				}
+				return operand(values, i.Value)
+
+			default:
+				if err := step(values, instr); err != nil {
+					return nil, fmt.Errorf("%s: %s: %v", block.Label, instr, err)
+				}
+				continue
+			}
+			break
+		}
+
+		if next == nil {
+			return nil, fmt.Errorf("%s: fell off the end of block %s", fn.Name, block.Label)
+		}
+		prev, block = block, next
+	}
+}
+
+// enterBlock assigns the phis at the top of block, reached from prev, and
+// returns how many there were. Every incoming value is read before any phi
+// is assigned, since one phi may read another's previous value.
+func enterBlock(values map[*Value]interface{}, block, prev *BasicBlock) (int, error) {
+	var phis []*Phi
+	for _, instr := range block.Instructions {
+		phi, ok := instr.(*Phi)
+		if !ok {
+			break
+		}
+		phis = append(phis, phi)
+	}
+
+	results := make([]interface{}, len(phis))
+	for i, phi := range phis {
+		var incoming *Value
+		for _, inc := range phi.Incomig {
+			if inc.Block == prev {
+				incoming = inc.Value
+			}
+		}
+		if incoming == nil && prev == nil {
+			return 0, fmt.Errorf("%s: %s: no value on entry to the function", block.Label, phi)
+		}
+		if incoming == nil {
+			return 0, fmt.Errorf("%s: %s: no value for the jump from %s", block.Label, phi, prev.Label)
+		}
+		result, err := operand(values, incoming)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %s: %v", block.Label, phi, err)
+		}
+		results[i] = result
+	}
+	for i, phi := range phis {
+		values[phi.Dest] = results[i]
+	}
+	return len(phis), nil
+}
+
+// step executes an instruction that isn't a terminator.
+func step(values map[*Value]interface{}, instr Instruction) error {
+	var result interface{}
+	var err error
+	switch i := instr.(type) {
+	case *BinaryOp:
+		var left, right interface{}
+		if left, err = operand(values, i.Left); err != nil {
+			return err
+		}
+		if right, err = operand(values, i.Right); err != nil {
+			return err
+		}
+		result, err = evalBinary(i.Op, types.IsUnsigned64(i.Left.Type), left, right)
+
+	case *UnaryOp:
+		var v interface{}
+		if v, err = operand(values, i.Operand); err != nil {
+			return err
+		}
+		result, err = evalUnary(i.Op, v)
+
+	case *Copy:
+		result, err = operand(values, i.Value)
+
+	default:
+		return fmt.Errorf("cannot interpret %T", instr)
+	}
+	if err != nil {
+		return err
+	}
+
+	dest := instr.Result()
+	if n, ok := result.(int64); ok {
+		if t, ok := types.Underlying(dest.Type).(*types.IntType); ok {
+			result = t.Wrap(n)
+		}
+	}
+	values[dest] = result
+	return nil
+}
+
+// operand returns the current value of v.
+func operand(values map[*Value]interface{}, v *Value) (interface{}, error) {
+	if v.IsConstant() {
+		if n, ok := v.Constant.(int); ok {
+			return int64(n), nil
+		}
+		return v.Constant, nil
+	}
+	if val, ok := values[v]; ok {
+		return val, nil
+	}
+	if v.Kind == ValueVariable {
+		switch types.Underlying(v.Type).(type) {
+		case *types.FloatType:
+			return 0.0, nil
+		case *types.BoolType:
+			return false, nil
+		default:
+			return int64(0), nil
+		}
+	}
+	return nil, fmt.Errorf("%s is used before it is assigned", v)
+}
+
+// evalBinary applies op to two values of the same type.
+func evalBinary(op BinaryOperator, unsigned bool, left, right interface{}) (interface{}, error) {
+	invalid := fmt.Errorf("invalid operation: %v %s %v", left, op, right)
+	switch l := left.(type) {
+	case int64:
+		r, ok := right.(int64)
+		if !ok {
+			return nil, invalid
+		}
+		switch op {
+		case OpAdd:
+			return l + r, nil
+		case OpSub:
+			return l - r, nil
+		case OpMul:
+			return l * r, nil
+		case OpDiv, OpMod:
+			if r == 0 {
+				return nil, fmt.Errorf("integer division by zero")
+			}
+			switch {
+			case unsigned && op == OpDiv:
+				return int64(uint64(l) / uint64(r)), nil
+			case unsigned:
+				return int64(uint64(l) % uint64(r)), nil
+			case op == OpDiv:
+				return l / r, nil
+			default:
+				return l % r, nil
+			}
+		case OpBitAnd:
+			return l & r, nil
+		case OpBitOr:
+			return l | r, nil
+		case OpBitXor:
+			return l ^ r, nil
+		case OpShl, OpShr:
+			if r < 0 {
+				return nil, fmt.Errorf("negative shift amount %d", r)
+			}
+			switch {
+			case op == OpShl:
+				return l << uint64(r), nil
+			case unsigned:
+				return int64(uint64(l) >> uint64(r)), nil
+			default:
+				return l >> uint64(r), nil
+			}
+		}
+		if unsigned {
+			return evalComparison(op, uint64(l) < uint64(r), l == r, invalid)
+		}
+		return evalComparison(op, l < r, l == r, invalid)
+
+	case float64:
+		r, ok := right.(float64)
+		if !ok {
+			return nil, invalid
+		}
+		switch op {
+		case OpAdd:
+			return l + r, nil
+		case OpSub:
+			return l - r, nil
+		case OpMul:
+			return l * r, nil
+		case OpDiv:
+			return l / r, nil
+		// Compared directly rather than through evalComparison: with a NaN
+		// neither less nor equal holds, and the orderings are all false
+		case OpEq:
+			return l == r, nil
+		case OpNeq:
+			return l != r, nil
+		case OpLt:
+			return l < r, nil
+		case OpLe:
+			return l <= r, nil
+		case OpGt:
+			return l > r, nil
+		case OpGe:
+			return l >= r, nil
+		}
+
+	case bool:
+		r, ok := right.(bool)
+		if !ok {
+			return nil, invalid
+		}
+		switch op {
+		case OpAnd:
+			return l && r, nil
+		case OpOr:
+			return l || r, nil
+		case OpEq:
+			return l == r, nil
+		case OpNeq:
+			return l != r, nil
+		}
+	}
+	return nil, invalid
+}
+
+// evalComparison implements the comparison operators from "less" and
+// "equal", or returns invalid for any other operator.
+func evalComparison(op BinaryOperator, less, equal bool, invalid error) (interface{}, error) {
+	switch op {
+	case OpEq:
+		return equal, nil
+	case OpNeq:
+		return !equal, nil
+	case OpLt:
+		return less, nil
+	case OpLe:
+		return less || equal, nil
+	case OpGt:
+		return !less && !equal, nil
+	case OpGe:
+		return !less, nil
+	}
+	return nil, invalid
+}
+
+// evalUnary applies op to a value.
+func evalUnary(op UnaryOperator, v interface{}) (interface{}, error) {
+	switch x := v.(type) {
+	case int64:
+		switch op {
+		case OpNeg:
+			return -x, nil
+		case OpBitNot:
+			return ^x, nil
+		}
+	case float64:
+		if op == OpNeg {
+			return -x, nil
+		}
+	case bool:
+		if op == OpNot {
+			return !x, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid operation: %s%v", op, v)
+}
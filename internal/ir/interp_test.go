@@ -0,0 +1,219 @@
+package ir
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/hassan/compiler/internal/semantic/types"
+)
+
+// constant returns a constant operand of type t.
+func constant(value interface{}, t types.Type) *Value {
+	return &Value{ID: -1, Type: t, Kind: ValueConstant, Constant: value}
+}
+
+// sumTo builds
+//
+//	func sum(n int) int { var total int; for (var i int = 1; i <= n; i = i + 1) { total = total + i; } return total; }
+//
+// with total never assigned before the loop, so it reads as zero.
+func sumTo() *Function {
+	n := &Value{ID: 0, Name: "n", Type: types.Int, Kind: ValueParameter}
+	fn := NewFunction("sum", []*Value{n}, types.Int)
+	total := fn.NewValue("total", types.Int, ValueVariable)
+	i := fn.NewValue("i", types.Int, ValueVariable)
+	cond := fn.NewBasicBlockInFunc("for.cond")
+	body := fn.NewBasicBlockInFunc("for.body")
+	end := fn.NewBasicBlockInFunc("for.end")
+
+	fn.Entry.AddInstruction(&Copy{Dest: i, Value: constant(int64(1), types.Int)})
+	fn.Entry.AddInstruction(&Jump{Target: cond})
+	le := fn.NewTemp(types.Bool)
+	cond.AddInstruction(&BinaryOp{Op: OpLe, Dest: le, Left: i, Right: n})
+	cond.AddInstruction(&Branch{Condition: le, TrueBlock: body, FalseBlock: end})
+	sum := fn.NewTemp(types.Int)
+	body.AddInstruction(&BinaryOp{Op: OpAdd, Dest: sum, Left: total, Right: i})
+	body.AddInstruction(&Copy{Dest: total, Value: sum})
+	next := fn.NewTemp(types.Int)
+	body.AddInstruction(&BinaryOp{Op: OpAdd, Dest: next, Left: i, Right: constant(int64(1), types.Int)})
+	body.AddInstruction(&Copy{Dest: i, Value: next})
+	body.AddInstruction(&Jump{Target: cond})
+	end.AddInstruction(&Return{Value: total})
+	return fn
+}
+
+// binary builds a function returning left op right, computed as a value of
+// type result.
+func binary(op BinaryOperator, left, right *Value, result types.Type) *Function {
+	fn := NewFunction("binary", nil, result)
+	dest := fn.NewTemp(result)
+	fn.Entry.AddInstruction(&BinaryOp{Op: op, Dest: dest, Left: left, Right: right})
+	fn.Entry.AddInstruction(&Return{Value: dest})
+	return fn
+}
+
+// choose builds "func choose(c bool) int { return c ? 1 : 2 }" with the
+// result joined by a phi.
+func choose() *Function {
+	c := &Value{ID: 0, Name: "c", Type: types.Bool, Kind: ValueParameter}
+	fn := NewFunction("choose", []*Value{c}, types.Int)
+	then := fn.NewBasicBlockInFunc("then")
+	els := fn.NewBasicBlockInFunc("else")
+	join := fn.NewBasicBlockInFunc("join")
+	fn.Entry.AddInstruction(&Branch{Condition: c, TrueBlock: then, FalseBlock: els})
+	then.AddInstruction(&Jump{Target: join})
+	els.AddInstruction(&Jump{Target: join})
+	result := fn.NewTemp(types.Int)
+	join.AddInstruction(&Phi{Dest: result, Incomig: []PhiIncoming{
+		{Value: constant(int64(1), types.Int), Block: then},
+		{Value: constant(int64(2), types.Int), Block: els},
+	}})
+	join.AddInstruction(&Return{Value: result})
+	return fn
+}
+
+// swap builds a block that runs twice, swapping a and b through phis the
+// second time:
+//
+//	swap: a = phi [1, entry], [b, swap]; b = phi [2, entry], [a, swap]
+//	      done = phi [false, entry], [true, swap]; branch done, end, swap
+//	end:  return a*10 + b
+//
+// which returns 21 only if both phis read their incoming values before
+// either is assigned.
+func swap() *Function {
+	fn := NewFunction("swap", nil, types.Int)
+	loop := fn.NewBasicBlockInFunc("swap")
+	end := fn.NewBasicBlockInFunc("end")
+	a, b := fn.NewTemp(types.Int), fn.NewTemp(types.Int)
+	done := fn.NewTemp(types.Bool)
+	fn.Entry.AddInstruction(&Jump{Target: loop})
+	loop.AddInstruction(&Phi{Dest: a, Incomig: []PhiIncoming{{Value: constant(int64(1), types.Int), Block: fn.Entry}, {Value: b, Block: loop}}})
+	loop.AddInstruction(&Phi{Dest: b, Incomig: []PhiIncoming{{Value: constant(int64(2), types.Int), Block: fn.Entry}, {Value: a, Block: loop}}})
+	loop.AddInstruction(&Phi{Dest: done, Incomig: []PhiIncoming{{Value: constant(false, types.Bool), Block: fn.Entry}, {Value: constant(true, types.Bool), Block: loop}}})
+	loop.AddInstruction(&Branch{Condition: done, TrueBlock: end, FalseBlock: loop})
+	tens, result := fn.NewTemp(types.Int), fn.NewTemp(types.Int)
+	end.AddInstruction(&BinaryOp{Op: OpMul, Dest: tens, Left: a, Right: constant(int64(10), types.Int)})
+	end.AddInstruction(&BinaryOp{Op: OpAdd, Dest: result, Left: tens, Right: b})
+	end.AddInstruction(&Return{Value: result})
+	return fn
+}
+
+func TestInterpret(t *testing.T) {
+	nan := constant(math.NaN(), types.Float)
+	tests := []struct {
+		name string
+		fn   *Function
+		args []interface{}
+		want interface{}
+	}{
+		{"loop", sumTo(), []interface{}{10}, int64(55)},
+		{"loop that never runs", sumTo(), []interface{}{int64(0)}, int64(0)},
+		{"int arithmetic", binary(OpMul, constant(int64(6), types.Int), constant(int64(7), types.Int), types.Int), nil, int64(42)},
+		{"truncating division", binary(OpDiv, constant(int64(-7), types.Int), constant(int64(2), types.Int), types.Int), nil, int64(-3)},
+		{"int8 wraps", binary(OpAdd, constant(int64(100), types.Int8), constant(int64(100), types.Int8), types.Int8), nil, int64(-56)},
+		{"uint8 wraps", binary(OpSub, constant(int64(0), types.Uint8), constant(int64(1), types.Uint8), types.Uint8), nil, int64(255)},
+		{"uint64 division is unsigned", binary(OpDiv, constant(int64(-2), types.Uint64), constant(int64(2), types.Uint64), types.Uint64), nil, int64(math.MaxInt64)},
+		{"uint64 ordering is unsigned", binary(OpGt, constant(int64(-1), types.Uint64), constant(int64(1), types.Uint64), types.Bool), nil, true},
+		{"signed shift", binary(OpShr, constant(int64(-8), types.Int), constant(int64(1), types.Int), types.Int), nil, int64(-4)},
+		{"float division by zero", binary(OpDiv, constant(1.0, types.Float), constant(0.0, types.Float), types.Float), nil, math.Inf(1)},
+		{"NaN is not ordered", binary(OpGe, nan, constant(0.0, types.Float), types.Bool), nil, false},
+		{"NaN is not equal to itself", binary(OpNeq, nan, nan, types.Bool), nil, true},
+		{"bool", binary(OpOr, constant(false, types.Bool), constant(true, types.Bool), types.Bool), nil, true},
+		{"phi from then", choose(), []interface{}{true}, int64(1)},
+		{"phi from else", choose(), []interface{}{false}, int64(2)},
+		{"phis are assigned together", swap(), nil, int64(21)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Interpret(tt.fn, tt.args...)
+			if err != nil {
+				t.Fatalf("Interpret: %v\n%s", err, tt.fn)
+			}
+			if got != tt.want {
+				t.Errorf("expected %v (%T), got %v (%T)", tt.want, tt.want, got, got)
+			}
+		})
+	}
+}
+
+func TestInterpret_Unary(t *testing.T) {
+	tests := []struct {
+		op      UnaryOperator
+		operand *Value
+		want    interface{}
+	}{
+		{OpNeg, constant(int64(5), types.Int), int64(-5)},
+		{OpBitNot, constant(int64(0), types.Int), int64(-1)},
+		{OpNeg, constant(2.5, types.Float), -2.5},
+		{OpNot, constant(true, types.Bool), false},
+	}
+	for _, tt := range tests {
+		fn := NewFunction("unary", nil, tt.operand.Type)
+		dest := fn.NewTemp(tt.operand.Type)
+		fn.Entry.AddInstruction(&UnaryOp{Op: tt.op, Dest: dest, Operand: tt.operand})
+		fn.Entry.AddInstruction(&Return{Value: dest})
+
+		got, err := Interpret(fn)
+		if err != nil {
+			t.Errorf("%s%v: %v", tt.op, tt.operand.Constant, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s%v: expected %v, got %v", tt.op, tt.operand.Constant, tt.want, got)
+		}
+	}
+}
+
+func TestInterpret_Errors(t *testing.T) {
+	undefined := NewFunction("undefined", nil, types.Int)
+	undefined.Entry.AddInstruction(&Return{Value: undefined.NewTemp(types.Int)})
+
+	call := NewFunction("call", nil, types.Void)
+	call.Entry.AddInstruction(&Call{Function: &Value{ID: -1, Name: "f", Kind: ValueVariable}})
+	call.Entry.AddInstruction(&Return{})
+
+	open := NewFunction("open", nil, types.Void)
+	open.Entry.AddInstruction(&Copy{Dest: open.NewTemp(types.Int), Value: constant(int64(1), types.Int)})
+
+	forever := NewFunction("forever", nil, types.Void)
+	forever.Entry.AddInstruction(&Jump{Target: forever.Entry})
+
+	notBool := NewFunction("notBool", nil, types.Void)
+	notBool.Entry.AddInstruction(&Branch{Condition: constant(int64(1), types.Int), TrueBlock: notBool.Entry, FalseBlock: notBool.Entry})
+
+	entryPhi := NewFunction("entryPhi", nil, types.Int)
+	entryPhi.Entry.AddInstruction(&Phi{Dest: entryPhi.NewTemp(types.Int)})
+
+	tests := []struct {
+		name string
+		fn   *Function
+		args []interface{}
+		want string
+	}{
+		{"arity", sumTo(), nil, "sum takes 1 arguments, got 0"},
+		{"division by zero", binary(OpMod, constant(int64(1), types.Int), constant(int64(0), types.Int), types.Int), nil, "integer division by zero"},
+		{"negative shift", binary(OpShl, constant(int64(1), types.Int), constant(int64(-1), types.Int), types.Int), nil, "negative shift amount -1"},
+		{"mixed operands", binary(OpAdd, constant(int64(1), types.Int), constant(1.0, types.Float), types.Int), nil, "invalid operation: 1 + 1"},
+		{"temporary never assigned", undefined, nil, "t0 is used before it is assigned"},
+		{"unsupported instruction", call, nil, "cannot interpret *ir.Call"},
+		{"no terminator", open, nil, "fell off the end of block entry"},
+		{"infinite loop", forever, nil, "no result after 1000000 instructions"},
+		{"branch on an int", notBool, nil, "branch on 1, not a bool"},
+		{"phi in the entry block", entryPhi, nil, "no value on entry to the function"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Interpret(tt.fn, tt.args...)
+			if err == nil {
+				t.Fatalf("expected an error, got %v", got)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected an error containing %q, got %q", tt.want, err)
+			}
+		})
+	}
+}
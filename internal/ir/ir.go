@@ -28,6 +28,7 @@ package ir
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/hassan/compiler/internal/semantic/types"
 )
@@ -55,6 +56,10 @@ type Value struct {
 
 	// Constant is the constant value (if Kind == ValueConstant)
 	Constant interface{}
+
+	// Initializer is the constant a global starts with, nil if it starts
+	// with the zero value of its type. Only module globals have one
+	Initializer *Value
 }
 
 // ValueKind represents the kind of value.
@@ -201,6 +206,16 @@ func (op BinaryOperator) String() string {
 	}
 }
 
+// isComparison reports whether op compares its operands, producing a bool
+// whatever their type.
+func (op BinaryOperator) isComparison() bool {
+	switch op {
+	case OpEq, OpNeq, OpLt, OpLe, OpGt, OpGe:
+		return true
+	}
+	return false
+}
+
 // Unary operations
 // Format: result = op operand
 
@@ -374,6 +389,21 @@ func (c *Call) Operands() []*Value {
 
 func (c *Call) Result() *Value { return c.Dest }
 
+// BuiltinPrefix starts the callee name of a call to a builtin function
+// ("$print"). No identifier or import path can contain '$', so a builtin
+// can never collide with a function of the program, and a backend can tell
+// the calls it has to implement itself from the calls it links.
+const BuiltinPrefix = "$"
+
+// Builtin returns the name of the builtin the call invokes ("print"), or ""
+// if it calls a function of the program.
+func (c *Call) Builtin() string {
+	if name := c.Function.Name; strings.HasPrefix(name, BuiltinPrefix) {
+		return strings.TrimPrefix(name, BuiltinPrefix)
+	}
+	return ""
+}
+
 // Return from function
 // Format: return value
 
@@ -424,7 +454,11 @@ type PhiIncoming struct {
 }
 
 func (p *Phi) String() string {
-	return fmt.Sprintf("%s = phi %v", p.Dest, p.Incomig)
+	incoming := make([]string, len(p.Incomig))
+	for i, inc := range p.Incomig {
+		incoming[i] = fmt.Sprintf("[%s, %s]", inc.Value, inc.Block.Label)
+	}
+	return fmt.Sprintf("%s = phi %s", p.Dest, strings.Join(incoming, ", "))
 }
 
 func (p *Phi) Operands() []*Value {
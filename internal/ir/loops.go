@@ -0,0 +1,192 @@
+package ir
+
+import "sort"
+
+// Loop is a natural loop: a header block and every block that can reach one
+// of its back edges without going through the header.
+//
+// WHAT IS A NATURAL LOOP?
+// An edge latch -> header is a back edge when header dominates latch: control
+// returns to a block it can only have come through. The loop is the header
+// plus everything that reaches the latch backwards without passing the
+// header. The header is the only way in, which is what lets a pass hoist
+// code into a block placed just before it.
+type Loop struct {
+	// Header is the single entry of the loop, the target of its back edges
+	Header *BasicBlock
+
+	// Latches are the blocks with a back edge to Header
+	Latches []*BasicBlock
+
+	// Blocks are all blocks of the loop, nested loops included, in function
+	// block order. The header is always one of them
+	Blocks []*BasicBlock
+
+	// Parent is the innermost loop containing this one, nil at the top level
+	Parent *Loop
+
+	// Children are the loops directly nested in this one
+	Children []*Loop
+}
+
+// Contains reports whether block is part of the loop.
+func (l *Loop) Contains(block *BasicBlock) bool {
+	return containsBlock(l.Blocks, block)
+}
+
+// Depth is 1 for an outermost loop, 2 for one nested in it, and so on.
+func (l *Loop) Depth() int {
+	depth := 0
+	for ; l != nil; l = l.Parent {
+		depth++
+	}
+	return depth
+}
+
+// LoopInfo holds the natural loops of a function.
+type LoopInfo struct {
+	// Loops are all loops of the function in preorder: each loop is
+	// followed by the loops nested in it, and loops at the same level are
+	// in the order of their headers
+	Loops []*Loop
+
+	// innermost maps each block in a loop to the innermost loop holding it
+	innermost map[*BasicBlock]*Loop
+}
+
+// LoopFor returns the innermost loop containing block, or nil if it is in
+// none.
+func (li *LoopInfo) LoopFor(block *BasicBlock) *Loop {
+	return li.innermost[block]
+}
+
+// LoopDepth returns how many loops contain block: 0 outside any loop.
+func (li *LoopInfo) LoopDepth(block *BasicBlock) int {
+	return li.LoopFor(block).Depth()
+}
+
+// FindLoops computes dominators for fn and returns its natural loops.
+//
+// ALGORITHM:
+//  1. Every edge whose target dominates its source is a back edge
+//  2. Back edges to the same header belong to one loop (a while loop with a
+//     continue has two)
+//  3. A loop's blocks are found by walking predecessors back from its
+//     latches, stopping at the header
+//  4. A loop is nested in the smallest other loop containing its header
+//
+// DESIGN CHOICE: FindLoops runs ComputeDominators itself instead of trusting
+// fn.IDom. Loops are wanted after passes have rewritten the CFG, and reusing
+// dominators from before that would quietly give wrong loops.
+//
+// A cycle with more than one entry (irreducible control flow) has no block
+// that dominates the rest, so no back edge and no loop is reported for it.
+// The builder never produces one: while, for and continue all enter a loop
+// through its condition block.
+func FindLoops(fn *Function) *LoopInfo {
+	ComputeDominators(fn)
+	info := &LoopInfo{innermost: make(map[*BasicBlock]*Loop)}
+
+	position := make(map[*BasicBlock]int, len(fn.Blocks))
+	for i, block := range fn.Blocks {
+		position[block] = i
+	}
+
+	// One loop per header, with every latch that jumps back to it
+	byHeader := make(map[*BasicBlock]*Loop)
+	for _, block := range fn.Blocks {
+		for _, succ := range block.Successors {
+			if !fn.Dominates(succ, block) {
+				continue
+			}
+			loop := byHeader[succ]
+			if loop == nil {
+				loop = &Loop{Header: succ}
+				byHeader[succ] = loop
+				info.Loops = append(info.Loops, loop)
+			}
+			loop.Latches = append(loop.Latches, block)
+		}
+	}
+
+	for _, loop := range info.Loops {
+		loop.Blocks = loopBlocks(fn, loop, position)
+	}
+
+	// A loop's parent is the smallest other loop containing its header.
+	// Loops with different headers are either nested or disjoint, so
+	// containing the header means containing the whole loop
+	var top []*Loop
+	for _, loop := range info.Loops {
+		for _, outer := range info.Loops {
+			if outer == loop || !outer.Contains(loop.Header) {
+				continue
+			}
+			if loop.Parent == nil || len(outer.Blocks) < len(loop.Parent.Blocks) {
+				loop.Parent = outer
+			}
+		}
+		if loop.Parent == nil {
+			top = append(top, loop)
+		} else {
+			loop.Parent.Children = append(loop.Parent.Children, loop)
+		}
+	}
+
+	// List the loops outermost first, visiting each level in header order;
+	// a nested loop is visited after its parent, so it ends up innermost
+	byPosition := func(loops []*Loop) {
+		sort.Slice(loops, func(i, j int) bool {
+			return position[loops[i].Header] < position[loops[j].Header]
+		})
+	}
+	info.Loops = info.Loops[:0]
+	var visit func(loops []*Loop)
+	visit = func(loops []*Loop) {
+		byPosition(loops)
+		for _, loop := range loops {
+			info.Loops = append(info.Loops, loop)
+			for _, block := range loop.Blocks {
+				info.innermost[block] = loop
+			}
+			visit(loop.Children)
+		}
+	}
+	visit(top)
+
+	return info
+}
+
+// loopBlocks walks predecessors back from the latches of loop until the
+// header, returning what it reached in function block order.
+func loopBlocks(fn *Function, loop *Loop, position map[*BasicBlock]int) []*BasicBlock {
+	in := map[*BasicBlock]bool{loop.Header: true}
+	stack := make([]*BasicBlock, 0, len(loop.Latches))
+	for _, latch := range loop.Latches {
+		if !in[latch] {
+			in[latch] = true
+			stack = append(stack, latch)
+		}
+	}
+	for len(stack) > 0 {
+		block := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for _, pred := range block.Predecessors {
+			// The header dominates its whole loop, which also keeps out
+			// unreachable blocks jumping into it
+			if !in[pred] && fn.Dominates(loop.Header, pred) {
+				in[pred] = true
+				stack = append(stack, pred)
+			}
+		}
+	}
+
+	blocks := make([]*BasicBlock, 0, len(in))
+	for block := range in {
+		blocks = append(blocks, block)
+	}
+	sort.Slice(blocks, func(i, j int) bool {
+		return position[blocks[i]] < position[blocks[j]]
+	})
+	return blocks
+}
@@ -0,0 +1,1052 @@
+package ir
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hassan/compiler/internal/semantic/types"
+)
+
+// IR text serialization.
+//
+// Module.String is for people: it leaves out what a reader can see from
+// context (the type of every temporary, which constant is a char and which
+// an int) and can't be read back. MarshalText writes everything, so that
+// UnmarshalText rebuilds a module the backends and interpreters treat
+// exactly as the one written, down to the IDs that name values in their
+// output. The module printed as
+//
+//	global x.0: int = const(3)
+//
+//	func main() int {
+//	entry:
+//	  t0 = x.0 + const(1)
+//	  return t0
+//	}
+//
+// is written
+//
+//	irtext 1
+//	module "main"
+//	value %0 temp 0 "" int
+//	value %1 const -1 "" int i64 3
+//	value %2 var 0 "x" int init %1
+//	value %3 const -1 "" int i64 1
+//	globals %2
+//	func "main" int 1 1 0
+//	params
+//	locals
+//	block "entry" succ 0 pred 0
+//	binop + %0 %2 %3
+//	return %0
+//	end
+//
+// Types, then values, are numbered in tables that come before the first
+// function, so an instruction names its operands by number (%3) and sharing
+// survives: the global two functions store to is one value after reading,
+// and a named type is one type, which NamedType.Equals depends on. The
+// predefined types are written by name and read back as the singletons in
+// package types.
+//
+// A func line gives the function's name, result type, next free value ID,
+// number of blocks and entry block; a block line gives its label and, by
+// number, the blocks it jumps to and is jumped to from, in the order the
+// module has them.
+//
+// DESIGN CHOICE: A format of its own rather than encoding/gob or JSON of
+// the structs. The IR is a graph - blocks jump to each other, values are
+// shared between instructions, a struct type can contain a pointer to
+// itself - and both of those encoders write a tree, copying what is shared
+// and looping on cycles. A line per entity, with references by number, is
+// the smallest thing that keeps the graph, and stays readable for a person
+// looking at a cached module.
+//
+// Dominator information (IDom, DominanceFrontier, Dominated) isn't written:
+// like after any change to the CFG, whoever needs it calls
+// ComputeDominators.
+
+// textVersion is the first line of MarshalText's output. A change to the
+// format changes it, so text in an older format fails to read instead of
+// being read wrongly.
+const textVersion = "irtext 1"
+
+// MarshalText writes m in the IR text format. It fails only for a module
+// holding something the format has no way to write, such as a constant of
+// a Go type no IR builder produces.
+func (m *Module) MarshalText() ([]byte, error) {
+	w := &textWriter{
+		typeIndex:  make(map[types.Type]int),
+		valueIndex: make(map[*Value]int),
+	}
+
+	// The functions are written first, into body, so the tables list every
+	// value and type they use; values add the types they have
+	var body strings.Builder
+	for _, fn := range m.Functions {
+		if err := w.function(&body, fn); err != nil {
+			return nil, err
+		}
+	}
+	globals := make([]string, len(m.Globals))
+	for i, global := range m.Globals {
+		globals[i] = w.value(global)
+	}
+	var values strings.Builder
+	for i := 0; i < len(w.values); i++ {
+		line, err := w.valueLine(i)
+		if err != nil {
+			return nil, err
+		}
+		values.WriteString(line)
+	}
+	var typeTable strings.Builder
+	for i := 0; i < len(w.types); i++ {
+		line, err := w.typeLine(i)
+		if err != nil {
+			return nil, err
+		}
+		typeTable.WriteString(line)
+	}
+
+	var sb strings.Builder
+	sb.WriteString(textVersion + "\n")
+	fmt.Fprintf(&sb, "module %s\n", strconv.Quote(m.Name))
+	sb.WriteString(typeTable.String())
+	sb.WriteString(values.String())
+	sb.WriteString(strings.TrimRight("globals "+strings.Join(globals, " "), " ") + "\n")
+	sb.WriteString(body.String())
+	return []byte(sb.String()), nil
+}
+
+// textWriter numbers the types and values of a module as MarshalText
+// meets them.
+type textWriter struct {
+	types      []types.Type
+	typeIndex  map[types.Type]int
+	values     []*Value
+	valueIndex map[*Value]int
+}
+
+// predefinedTypes are the types written by name. Integer types are written
+// by name too, whichever *IntType they are.
+var predefinedTypes = map[string]types.Type{
+	"invalid": types.Invalid,
+	"void":    types.Void,
+	"float":   types.Float,
+	"bool":    types.Bool,
+	"string":  types.String,
+	"char":    types.Char,
+	"nil":     types.Nil,
+	"int":     types.Int,
+	"int8":    types.Int8,
+	"int16":   types.Int16,
+	"int32":   types.Int32,
+	"uint8":   types.Uint8,
+	"uint16":  types.Uint16,
+	"uint32":  types.Uint32,
+	"uint64":  types.Uint64,
+}
+
+// typeRef returns how t is written where it is used: its name if it is
+// predefined, else its number in the type table, adding it if it's new.
+func (w *textWriter) typeRef(t types.Type) string {
+	switch t.(type) {
+	case nil:
+		return "-"
+	case *types.InvalidType:
+		return "invalid"
+	case *types.VoidType, *types.FloatType, *types.BoolType, *types.StringType, *types.CharType, *types.NilType, *types.IntType:
+		return t.String()
+	}
+	index, ok := w.typeIndex[t]
+	if !ok {
+		index = len(w.types)
+		w.typeIndex[t] = index
+		w.types = append(w.types, t)
+	}
+	return fmt.Sprintf("#%d", index)
+}
+
+// typeLine returns the definition of the type numbered index.
+func (w *textWriter) typeLine(index int) (string, error) {
+	var fields []string
+	switch t := w.types[index].(type) {
+	case *types.PointerType:
+		fields = []string{"pointer", w.typeRef(t.Elem)}
+	case *types.ArrayType:
+		fields = []string{"array", strconv.Itoa(t.Size), w.typeRef(t.ElementType)}
+	case *types.StructType:
+		fields = []string{"struct", strconv.Quote(t.Name), strconv.Itoa(len(t.Fields))}
+		for _, field := range t.Fields {
+			fields = append(fields, strconv.Quote(field.Name), w.typeRef(field.Type))
+		}
+	case *types.FunctionType:
+		fields = []string{"func", w.typeRef(t.ReturnType), strconv.Itoa(len(t.Parameters))}
+		for _, param := range t.Parameters {
+			fields = append(fields, w.typeRef(param))
+		}
+	case *types.NamedType:
+		fields = []string{"named", strconv.Quote(t.Name), w.typeRef(t.Underlying)}
+	default:
+		return "", fmt.Errorf("cannot write type %s (%T)", t, t)
+	}
+	return fmt.Sprintf("type #%d %s\n", index, strings.Join(fields, " ")), nil
+}
+
+// value returns how v is written where it is used, adding it to the value
+// table if it's new. A global's initializer is added before the global.
+func (w *textWriter) value(v *Value) string {
+	if v == nil {
+		return "-"
+	}
+	index, ok := w.valueIndex[v]
+	if !ok {
+		if v.Initializer != nil {
+			w.value(v.Initializer)
+		}
+		index = len(w.values)
+		w.valueIndex[v] = index
+		w.values = append(w.values, v)
+	}
+	return fmt.Sprintf("%%%d", index)
+}
+
+// valueKinds are the words for each ValueKind.
+var valueKinds = map[ValueKind]string{
+	ValueVariable:  "var",
+	ValueTemporary: "temp",
+	ValueConstant:  "const",
+	ValueParameter: "param",
+}
+
+// valueLine returns the definition of the value numbered index.
+func (w *textWriter) valueLine(index int) (string, error) {
+	v := w.values[index]
+	kind, ok := valueKinds[v.Kind]
+	if !ok {
+		return "", fmt.Errorf("cannot write %s: unknown kind %d", v, v.Kind)
+	}
+	line := fmt.Sprintf("value %%%d %s %d %s %s", index, kind, v.ID, strconv.Quote(v.Name), w.typeRef(v.Type))
+	if v.Kind == ValueConstant {
+		constant, err := constantText(v.Constant)
+		if err != nil {
+			return "", fmt.Errorf("cannot write %s: %v", v, err)
+		}
+		line += " " + constant
+	}
+	if v.Initializer != nil {
+		line += " init " + w.value(v.Initializer)
+	}
+	return line + "\n", nil
+}
+
+// constantText writes a constant as its Go type and value, so it reads back
+// as the same type: the backends tell a char from an int by it.
+func constantText(c interface{}) (string, error) {
+	switch c := c.(type) {
+	case nil:
+		return "nil -", nil
+	case int64:
+		return "i64 " + strconv.FormatInt(c, 10), nil
+	case int:
+		return "int " + strconv.Itoa(c), nil
+	case rune:
+		return "rune " + strconv.FormatInt(int64(c), 10), nil
+	case float64:
+		// Hexadecimal is exact, and ParseFloat reads NaN and ±Inf back
+		return "f64 " + strconv.FormatFloat(c, 'x', -1, 64), nil
+	case bool:
+		return "bool " + strconv.FormatBool(c), nil
+	case string:
+		return "str " + strconv.Quote(c), nil
+	}
+	return "", fmt.Errorf("constant %v of unsupported Go type %T", c, c)
+}
+
+// function writes fn: its signature, parameters and locals, then each block
+// with its edges and instructions.
+func (w *textWriter) function(sb *strings.Builder, fn *Function) error {
+	blockIndex := make(map[*BasicBlock]int, len(fn.Blocks))
+	for i, block := range fn.Blocks {
+		blockIndex[block] = i
+	}
+	block := func(b *BasicBlock) (string, error) {
+		index, ok := blockIndex[b]
+		if !ok {
+			return "", fmt.Errorf("function %s refers to a block that is not one of its own", fn.Name)
+		}
+		return strconv.Itoa(index), nil
+	}
+	blocks := func(list []*BasicBlock) (string, error) {
+		fields := []string{strconv.Itoa(len(list))}
+		for _, b := range list {
+			index, err := block(b)
+			if err != nil {
+				return "", err
+			}
+			fields = append(fields, index)
+		}
+		return strings.Join(fields, " "), nil
+	}
+	values := func(word string, list []*Value) string {
+		fields := []string{word}
+		for _, v := range list {
+			fields = append(fields, w.value(v))
+		}
+		return strings.Join(fields, " ") + "\n"
+	}
+
+	entry, err := block(fn.Entry)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(sb, "func %s %s %d %d %s\n", strconv.Quote(fn.Name), w.typeRef(fn.ReturnType), fn.nextValueID, len(fn.Blocks), entry)
+	sb.WriteString(values("params", fn.Parameters))
+	sb.WriteString(values("locals", fn.Locals))
+	for _, b := range fn.Blocks {
+		succ, err := blocks(b.Successors)
+		if err != nil {
+			return err
+		}
+		pred, err := blocks(b.Predecessors)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(sb, "block %s succ %s pred %s\n", strconv.Quote(b.Label), succ, pred)
+		for _, instr := range b.Instructions {
+			line, err := w.instruction(instr, block)
+			if err != nil {
+				return fmt.Errorf("function %s, block %s: %v", fn.Name, b.Label, err)
+			}
+			sb.WriteString(line + "\n")
+		}
+	}
+	sb.WriteString("end\n")
+	return nil
+}
+
+// instruction returns the line for instr, writing blocks with block.
+func (w *textWriter) instruction(instr Instruction, block func(*BasicBlock) (string, error)) (string, error) {
+	// words starts a line with the instruction's name and its operands
+	words := func(name string, values ...*Value) []string {
+		out := []string{name}
+		for _, v := range values {
+			out = append(out, w.value(v))
+		}
+		return out
+	}
+
+	var line []string
+	switch i := instr.(type) {
+	case *BinaryOp:
+		line = words("binop "+i.Op.String(), i.Dest, i.Left, i.Right)
+	case *UnaryOp:
+		line = words("unop "+i.Op.String(), i.Dest, i.Operand)
+	case *Copy:
+		line = words("copy", i.Dest, i.Value)
+	case *Load:
+		line = words("load", i.Dest, i.Address)
+	case *Store:
+		line = words("store", i.Address, i.Value)
+	case *GetElementPtr:
+		line = words("gep", i.Dest, i.Base, i.Index)
+	case *GetFieldPtr:
+		line = append(words("field", i.Dest, i.Base), strconv.Itoa(i.FieldIndex))
+	case *Alloca:
+		line = append(words("alloca", i.Dest), w.typeRef(i.Type))
+	case *Call:
+		line = words("call", append([]*Value{i.Dest, i.Function}, i.Args...)...)
+	case *Return:
+		line = words("return", i.Value)
+	case *Jump:
+		target, err := block(i.Target)
+		if err != nil {
+			return "", err
+		}
+		line = []string{"jump", target}
+	case *Branch:
+		t, err := block(i.TrueBlock)
+		if err != nil {
+			return "", err
+		}
+		f, err := block(i.FalseBlock)
+		if err != nil {
+			return "", err
+		}
+		line = append(words("branch", i.Condition), t, f)
+	case *Phi:
+		line = words("phi", i.Dest)
+		for _, inc := range i.Incomig {
+			from, err := block(inc.Block)
+			if err != nil {
+				return "", err
+			}
+			line = append(line, w.value(inc.Value), from)
+		}
+	default:
+		return "", fmt.Errorf("cannot write instruction %s (%T)", instr, instr)
+	}
+	return strings.Join(line, " "), nil
+}
+
+// UnmarshalText reads the IR text format back into m, replacing anything m
+// held. Malformed text is an error, naming the line it is on.
+func (m *Module) UnmarshalText(text []byte) error {
+	r := &textReader{lines: strings.Split(strings.TrimSuffix(string(text), "\n"), "\n")}
+	if len(r.lines) == 0 || r.lines[0] != textVersion {
+		return fmt.Errorf("not IR text: expected %q on the first line", textVersion)
+	}
+	r.line = 1
+	name, err := r.module()
+	if err != nil {
+		return fmt.Errorf("line %d: %v", r.current+1, err)
+	}
+	m.Name = name
+	m.Globals = r.globals
+	m.Functions = r.functions
+	return nil
+}
+
+// textReader reads the IR text format a line at a time.
+type textReader struct {
+	lines   []string
+	line    int // index of the next line to read
+	current int // index of the line an error is about
+
+	types     []types.Type
+	values    []*Value
+	globals   []*Value
+	functions []*Function
+}
+
+// splitFields splits a line into its words, a quoted string being one word.
+func splitFields(line string) ([]string, error) {
+	var fields []string
+	for line = strings.TrimLeft(line, " "); line != ""; line = strings.TrimLeft(line, " ") {
+		if line[0] == '"' {
+			quoted, err := strconv.QuotedPrefix(line)
+			if err != nil {
+				return nil, fmt.Errorf("bad string %s", line)
+			}
+			fields = append(fields, quoted)
+			line = line[len(quoted):]
+			continue
+		}
+		end := strings.IndexByte(line, ' ')
+		if end < 0 {
+			end = len(line)
+		}
+		fields = append(fields, line[:end])
+		line = line[end:]
+	}
+	return fields, nil
+}
+
+// next returns the words of the next line if it starts with word, and
+// whether it did. At the end of the text there is no next line.
+func (r *textReader) next(word string) ([]string, bool, error) {
+	r.current = r.line
+	if r.line >= len(r.lines) {
+		return nil, false, nil
+	}
+	fields, err := splitFields(r.lines[r.line])
+	if err != nil {
+		return nil, false, err
+	}
+	if len(fields) == 0 || fields[0] != word {
+		return nil, false, nil
+	}
+	r.line++
+	return fields[1:], true, nil
+}
+
+// expect returns the words of the next line, which must start with word.
+func (r *textReader) expect(word string) ([]string, error) {
+	fields, ok, err := r.next(word)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("expected %q", word)
+	}
+	return fields, nil
+}
+
+// module reads everything after the version line, returning the module's
+// name.
+func (r *textReader) module() (string, error) {
+	fields, err := r.expect("module")
+	if err != nil || len(fields) != 1 {
+		return "", fmt.Errorf("expected module and a name")
+	}
+	name, err := strconv.Unquote(fields[0])
+	if err != nil {
+		return "", err
+	}
+	if err := r.typeTable(); err != nil {
+		return "", err
+	}
+	if err := r.valueTable(); err != nil {
+		return "", err
+	}
+	if fields, err = r.expect("globals"); err != nil {
+		return "", err
+	}
+	if r.globals, err = r.valueList(fields); err != nil {
+		return "", err
+	}
+
+	for r.line < len(r.lines) {
+		fn, err := r.function()
+		if err != nil {
+			return "", err
+		}
+		r.functions = append(r.functions, fn)
+	}
+	return name, nil
+}
+
+// typeTable reads the type lines. Every type is made before any is filled
+// in, since one may refer to a type after it, or to itself.
+func (r *textReader) typeTable() error {
+	start := r.line
+	var defs [][]string
+	for {
+		fields, ok, err := r.next("type")
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+		if len(fields) < 2 || fields[0] != fmt.Sprintf("#%d", len(defs)) {
+			return fmt.Errorf("expected type #%d", len(defs))
+		}
+		var t types.Type
+		switch fields[1] {
+		case "pointer":
+			t = &types.PointerType{}
+		case "array":
+			t = &types.ArrayType{}
+		case "struct":
+			t = &types.StructType{}
+		case "func":
+			t = &types.FunctionType{}
+		case "named":
+			t = &types.NamedType{}
+		default:
+			return fmt.Errorf("unknown type kind %q", fields[1])
+		}
+		r.types = append(r.types, t)
+		defs = append(defs, fields[2:])
+	}
+
+	for i, fields := range defs {
+		r.current = start + i
+		if err := r.fillType(r.types[i], fields); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fillType sets the parts of t from the words after its kind.
+func (r *textReader) fillType(t types.Type, fields []string) error {
+	var err error
+	switch t := t.(type) {
+	case *types.PointerType:
+		if len(fields) != 1 {
+			return fmt.Errorf("expected pointer and a type")
+		}
+		t.Elem, err = r.typeRef(fields[0])
+	case *types.ArrayType:
+		if len(fields) != 2 {
+			return fmt.Errorf("expected array, a size and a type")
+		}
+		if t.Size, err = strconv.Atoi(fields[0]); err != nil {
+			return err
+		}
+		t.ElementType, err = r.typeRef(fields[1])
+	case *types.StructType:
+		count, rest, err := countedFields(fields[1:], 2)
+		if err != nil || len(fields) == 0 {
+			return fmt.Errorf("expected struct, a name and fields")
+		}
+		if t.Name, err = strconv.Unquote(fields[0]); err != nil {
+			return err
+		}
+		t.Fields = make([]types.StructField, count)
+		for i := range t.Fields {
+			if t.Fields[i].Name, err = strconv.Unquote(rest[2*i]); err != nil {
+				return err
+			}
+			if t.Fields[i].Type, err = r.typeRef(rest[2*i+1]); err != nil {
+				return err
+			}
+		}
+	case *types.FunctionType:
+		count, rest, err := countedFields(fields[1:], 1)
+		if err != nil || len(fields) == 0 {
+			return fmt.Errorf("expected func, a result and parameters")
+		}
+		if t.ReturnType, err = r.typeRef(fields[0]); err != nil {
+			return err
+		}
+		t.Parameters = make([]types.Type, count)
+		for i := range t.Parameters {
+			if t.Parameters[i], err = r.typeRef(rest[i]); err != nil {
+				return err
+			}
+		}
+	case *types.NamedType:
+		if len(fields) != 2 {
+			return fmt.Errorf("expected named, a name and a type")
+		}
+		if t.Name, err = strconv.Unquote(fields[0]); err != nil {
+			return err
+		}
+		t.Underlying, err = r.typeRef(fields[1])
+	}
+	return err
+}
+
+// countedFields reads a count and then that many groups of size words,
+// returning the count and the words, which must be all that is left.
+func countedFields(fields []string, size int) (int, []string, error) {
+	if len(fields) == 0 {
+		return 0, nil, fmt.Errorf("expected a count")
+	}
+	count, err := strconv.Atoi(fields[0])
+	if err != nil || count < 0 || len(fields)-1 != count*size {
+		return 0, nil, fmt.Errorf("bad count %s", fields[0])
+	}
+	return count, fields[1:], nil
+}
+
+// typeRef returns the type a reference names: a predefined type, a number
+// in the type table, or - for none.
+func (r *textReader) typeRef(ref string) (types.Type, error) {
+	if ref == "-" {
+		return nil, nil
+	}
+	if t, ok := predefinedTypes[ref]; ok {
+		return t, nil
+	}
+	if strings.HasPrefix(ref, "#") {
+		if index, err := strconv.Atoi(ref[1:]); err == nil && index >= 0 && index < len(r.types) {
+			return r.types[index], nil
+		}
+	}
+	return nil, fmt.Errorf("unknown type %s", ref)
+}
+
+// valueTable reads the value lines. As for types, every value is made
+// before any is filled in, so a global's initializer can be found by number
+// wherever it is.
+func (r *textReader) valueTable() error {
+	start := r.line
+	var defs [][]string
+	for {
+		fields, ok, err := r.next("value")
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+		if len(fields) < 1 || fields[0] != fmt.Sprintf("%%%d", len(defs)) {
+			return fmt.Errorf("expected value %%%d", len(defs))
+		}
+		r.values = append(r.values, &Value{})
+		defs = append(defs, fields[1:])
+	}
+
+	for i, fields := range defs {
+		r.current = start + i
+		if err := r.fillValue(r.values[i], fields); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fillValue sets the parts of v from the words after its number: its kind,
+// ID, name and type, a constant's value, and a global's initializer.
+func (r *textReader) fillValue(v *Value, fields []string) error {
+	if len(fields) < 4 {
+		return fmt.Errorf("expected a kind, an ID, a name and a type")
+	}
+	kind, ok := ValueKind(-1), false
+	for k, word := range valueKinds {
+		if word == fields[0] {
+			kind, ok = k, true
+		}
+	}
+	if !ok {
+		return fmt.Errorf("unknown value kind %q", fields[0])
+	}
+	v.Kind = kind
+
+	var err error
+	if v.ID, err = strconv.Atoi(fields[1]); err != nil {
+		return err
+	}
+	if v.Name, err = strconv.Unquote(fields[2]); err != nil {
+		return err
+	}
+	if v.Type, err = r.typeRef(fields[3]); err != nil {
+		return err
+	}
+	rest := fields[4:]
+	if kind == ValueConstant {
+		if len(rest) < 2 {
+			return fmt.Errorf("expected the constant's type and value")
+		}
+		if v.Constant, err = parseConstant(rest[0], rest[1]); err != nil {
+			return err
+		}
+		rest = rest[2:]
+	}
+	if len(rest) == 2 && rest[0] == "init" {
+		if v.Initializer, err = r.valueRef(rest[1]); err != nil {
+			return err
+		}
+		rest = rest[2:]
+	}
+	if len(rest) > 0 {
+		return fmt.Errorf("unexpected %s", strings.Join(rest, " "))
+	}
+	return nil
+}
+
+// parseConstant reads a constant constantText wrote.
+func parseConstant(kind, text string) (interface{}, error) {
+	switch kind {
+	case "nil":
+		return nil, nil
+	case "i64":
+		return strconv.ParseInt(text, 10, 64)
+	case "int":
+		return strconv.Atoi(text)
+	case "rune":
+		n, err := strconv.ParseInt(text, 10, 32)
+		return rune(n), err
+	case "f64":
+		return strconv.ParseFloat(text, 64)
+	case "bool":
+		return strconv.ParseBool(text)
+	case "str":
+		return strconv.Unquote(text)
+	}
+	return nil, fmt.Errorf("unknown constant type %q", kind)
+}
+
+// valueRef returns the value a reference names: a number in the value
+// table, or - for none.
+func (r *textReader) valueRef(ref string) (*Value, error) {
+	if ref == "-" {
+		return nil, nil
+	}
+	if strings.HasPrefix(ref, "%") {
+		if index, err := strconv.Atoi(ref[1:]); err == nil && index >= 0 && index < len(r.values) {
+			return r.values[index], nil
+		}
+	}
+	return nil, fmt.Errorf("unknown value %s", ref)
+}
+
+// valueList returns the values refs name.
+func (r *textReader) valueList(refs []string) ([]*Value, error) {
+	values := make([]*Value, len(refs))
+	for i, ref := range refs {
+		v, err := r.valueRef(ref)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+// function reads a function, from its func line through end.
+func (r *textReader) function() (*Function, error) {
+	fields, err := r.expect("func")
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("expected func, a name, a result type, the next value ID, a block count and the entry")
+	}
+	fn := &Function{}
+	if fn.Name, err = strconv.Unquote(fields[0]); err != nil {
+		return nil, err
+	}
+	if fn.ReturnType, err = r.typeRef(fields[1]); err != nil {
+		return nil, err
+	}
+	if fn.nextValueID, err = strconv.Atoi(fields[2]); err != nil {
+		return nil, err
+	}
+	count, err := strconv.Atoi(fields[3])
+	if err != nil || count < 1 {
+		return nil, fmt.Errorf("bad block count %s", fields[3])
+	}
+	fn.Blocks = make([]*BasicBlock, count)
+	for i := range fn.Blocks {
+		fn.Blocks[i] = NewBasicBlock("")
+		fn.Blocks[i].Index = i
+	}
+	block := func(ref string) (*BasicBlock, error) {
+		index, err := strconv.Atoi(ref)
+		if err != nil || index < 0 || index >= len(fn.Blocks) {
+			return nil, fmt.Errorf("unknown block %s", ref)
+		}
+		return fn.Blocks[index], nil
+	}
+	if fn.Entry, err = block(fields[4]); err != nil {
+		return nil, err
+	}
+
+	if fields, err = r.expect("params"); err != nil {
+		return nil, err
+	}
+	if fn.Parameters, err = r.valueList(fields); err != nil {
+		return nil, err
+	}
+	if fields, err = r.expect("locals"); err != nil {
+		return nil, err
+	}
+	if fn.Locals, err = r.valueList(fields); err != nil {
+		return nil, err
+	}
+
+	for _, b := range fn.Blocks {
+		if err := r.block(b, block); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := r.expect("end"); err != nil {
+		return nil, err
+	}
+	return fn, nil
+}
+
+// block reads one block of a function: its block line, then instructions
+// up to the next block or the end of the function.
+func (r *textReader) block(b *BasicBlock, block func(string) (*BasicBlock, error)) error {
+	fields, err := r.expect("block")
+	if err != nil {
+		return err
+	}
+	blockList := func(fields []string, word string) ([]*BasicBlock, []string, error) {
+		if len(fields) < 2 || fields[0] != word {
+			return nil, nil, fmt.Errorf("expected %s", word)
+		}
+		count, err := strconv.Atoi(fields[1])
+		if err != nil || count < 0 || len(fields) < 2+count {
+			return nil, nil, fmt.Errorf("bad %s count %s", word, fields[1])
+		}
+		list := make([]*BasicBlock, count)
+		for i := range list {
+			if list[i], err = block(fields[2+i]); err != nil {
+				return nil, nil, err
+			}
+		}
+		return list, fields[2+count:], nil
+	}
+	if len(fields) == 0 {
+		return fmt.Errorf("expected block and a label")
+	}
+	if b.Label, err = strconv.Unquote(fields[0]); err != nil {
+		return err
+	}
+	rest := fields[1:]
+	if b.Successors, rest, err = blockList(rest, "succ"); err != nil {
+		return err
+	}
+	if b.Predecessors, rest, err = blockList(rest, "pred"); err != nil {
+		return err
+	}
+	if len(rest) > 0 {
+		return fmt.Errorf("unexpected %s", strings.Join(rest, " "))
+	}
+
+	for r.line < len(r.lines) {
+		r.current = r.line
+		fields, err := splitFields(r.lines[r.line])
+		if err != nil {
+			return err
+		}
+		if len(fields) > 0 && (fields[0] == "block" || fields[0] == "end") {
+			return nil
+		}
+		instr, err := r.instruction(fields, block)
+		if err != nil {
+			return err
+		}
+		b.Instructions = append(b.Instructions, instr)
+		r.line++
+	}
+	return nil
+}
+
+// binaryOperatorNames and unaryOperatorNames map each operator's text back to it.
+var binaryOperatorNames, unaryOperatorNames = func() (map[string]BinaryOperator, map[string]UnaryOperator) {
+	binary := make(map[string]BinaryOperator)
+	for op := OpAdd; op <= OpShr; op++ {
+		binary[op.String()] = op
+	}
+	unary := make(map[string]UnaryOperator)
+	for op := OpNeg; op <= OpBitNot; op++ {
+		unary[op.String()] = op
+	}
+	return binary, unary
+}()
+
+// instruction reads the instruction whose line has the words fields.
+func (r *textReader) instruction(fields []string, block func(string) (*BasicBlock, error)) (Instruction, error) {
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("expected an instruction")
+	}
+	op, args := fields[0], fields[1:]
+
+	// values reads the operands after the first skip words, which must be
+	// count of them exactly, or at least count if count is negative
+	values := func(skip, count int) ([]*Value, error) {
+		if len(args) < skip || (count >= 0 && len(args)-skip != count) || (count < 0 && len(args)-skip < -count) {
+			return nil, fmt.Errorf("wrong number of operands for %s", op)
+		}
+		return r.valueList(args[skip:])
+	}
+
+	switch op {
+	case "binop", "unop":
+		count := 3
+		if op == "unop" {
+			count = 2
+		}
+		v, err := values(1, count)
+		if err != nil {
+			return nil, err
+		}
+		if op == "unop" {
+			operator, ok := unaryOperatorNames[args[0]]
+			if !ok {
+				return nil, fmt.Errorf("unknown operator %s", args[0])
+			}
+			return &UnaryOp{Op: operator, Dest: v[0], Operand: v[1]}, nil
+		}
+		operator, ok := binaryOperatorNames[args[0]]
+		if !ok {
+			return nil, fmt.Errorf("unknown operator %s", args[0])
+		}
+		return &BinaryOp{Op: operator, Dest: v[0], Left: v[1], Right: v[2]}, nil
+	case "copy", "load", "store":
+		v, err := values(0, 2)
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case "copy":
+			return &Copy{Dest: v[0], Value: v[1]}, nil
+		case "load":
+			return &Load{Dest: v[0], Address: v[1]}, nil
+		}
+		return &Store{Address: v[0], Value: v[1]}, nil
+	case "gep":
+		v, err := values(0, 3)
+		if err != nil {
+			return nil, err
+		}
+		return &GetElementPtr{Dest: v[0], Base: v[1], Index: v[2]}, nil
+	case "field", "alloca":
+		last := len(args) - 1
+		if last < 0 {
+			return nil, fmt.Errorf("wrong number of operands for %s", op)
+		}
+		args, extra := args[:last], args[last]
+		v, err := r.valueList(args)
+		if err != nil {
+			return nil, err
+		}
+		if op == "alloca" {
+			if len(v) != 1 {
+				return nil, fmt.Errorf("wrong number of operands for alloca")
+			}
+			t, err := r.typeRef(extra)
+			if err != nil {
+				return nil, err
+			}
+			return &Alloca{Dest: v[0], Type: t}, nil
+		}
+		if len(v) != 2 {
+			return nil, fmt.Errorf("wrong number of operands for field")
+		}
+		index, err := strconv.Atoi(extra)
+		if err != nil {
+			return nil, err
+		}
+		return &GetFieldPtr{Dest: v[0], Base: v[1], FieldIndex: index}, nil
+	case "call":
+		v, err := values(0, -2)
+		if err != nil {
+			return nil, err
+		}
+		return &Call{Dest: v[0], Function: v[1], Args: v[2:]}, nil
+	case "return":
+		v, err := values(0, 1)
+		if err != nil {
+			return nil, err
+		}
+		return &Return{Value: v[0]}, nil
+	case "jump":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("expected jump and a block")
+		}
+		target, err := block(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return &Jump{Target: target}, nil
+	case "branch":
+		if len(args) != 3 {
+			return nil, fmt.Errorf("expected branch, a condition and two blocks")
+		}
+		condition, err := r.valueRef(args[0])
+		if err != nil {
+			return nil, err
+		}
+		t, err := block(args[1])
+		if err != nil {
+			return nil, err
+		}
+		f, err := block(args[2])
+		if err != nil {
+			return nil, err
+		}
+		return &Branch{Condition: condition, TrueBlock: t, FalseBlock: f}, nil
+	case "phi":
+		if len(args) == 0 || len(args)%2 != 1 {
+			return nil, fmt.Errorf("expected phi, a destination and value-block pairs")
+		}
+		dest, err := r.valueRef(args[0])
+		if err != nil {
+			return nil, err
+		}
+		phi := &Phi{Dest: dest}
+		for i := 1; i < len(args); i += 2 {
+			v, err := r.valueRef(args[i])
+			if err != nil {
+				return nil, err
+			}
+			from, err := block(args[i+1])
+			if err != nil {
+				return nil, err
+			}
+			phi.Incomig = append(phi.Incomig, PhiIncoming{Value: v, Block: from})
+		}
+		return phi, nil
+	}
+	return nil, fmt.Errorf("unknown instruction %q", op)
+}
@@ -0,0 +1,178 @@
+package ir
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hassan/compiler/internal/semantic"
+	"github.com/hassan/compiler/internal/semantic/types"
+)
+
+// roundTripSource uses every kind of type, constant and instruction the
+// builder makes: named and recursive types, arrays, pointers, struct
+// fields, calls to builtins and functions, and the phi of &&.
+const roundTripSource = `package main
+
+type Celsius float;
+
+struct Node {
+    value int;
+    next *Node;
+}
+
+var limit int = 1 << 10;
+var scale float = 1.5;
+var greeting string = "hi \"there\"\n";
+var letter char = 'q';
+var small uint8 = 200;
+var ready bool;
+
+func sum(head *Node, count int) int {
+    var n int = 0;
+    var total int = 0;
+    var node Node = *head;
+    while (n < count && n < limit) {
+        total = total + node.value;
+        n = n + 1;
+    }
+    return total;
+}
+
+func main() int {
+    var values = [1, 2, 3];
+    var second Node;
+    second.value = values[1];
+    var first Node = Node{value: values[0], next: &second};
+    var warm Celsius = Celsius(scale * 2.0);
+    if (warm > Celsius(2.5) || !ready) {
+        println(greeting);
+    }
+    println(letter);
+    return sum(&first, 2) + -values[2];
+}
+`
+
+// TestModuleText_RoundTrip checks that reading what MarshalText wrote gives
+// the module back: the same printed IR, the same text when written again,
+// and values and types still shared as they were.
+func TestModuleText_RoundTrip(t *testing.T) {
+	sources := map[string]string{
+		"types":          roundTripSource,
+		"many functions": manyFunctions(8),
+	}
+	for name, source := range sources {
+		t.Run(name, func(t *testing.T) {
+			file := parse(t, source)
+			analyzer := semantic.New()
+			if errs := analyzer.Analyze(file); len(errs) > 0 {
+				t.Fatal(errs)
+			}
+			module, errs := NewBuilder(analyzer).Build(file)
+			if len(errs) > 0 {
+				t.Fatal(errs)
+			}
+
+			text, err := module.MarshalText()
+			if err != nil {
+				t.Fatal(err)
+			}
+			read := &Module{}
+			if err := read.UnmarshalText(text); err != nil {
+				t.Fatalf("%v\n%s", err, text)
+			}
+			if got, want := read.String(), module.String(); got != want {
+				t.Errorf("read module differs:\n%s", diffLines(want, got))
+			}
+			again, err := read.MarshalText()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(again) != string(text) {
+				t.Errorf("text differs when written again:\n%s", diffLines(string(text), string(again)))
+			}
+			if verifyErrors := read.Verify(); len(verifyErrors) > 0 {
+				t.Errorf("read module doesn't verify: %v", verifyErrors)
+			}
+		})
+	}
+}
+
+// TestModuleText_Sharing checks what String can't show: that a global is
+// one value wherever it is used, that the predefined types come back as
+// package types' singletons, and that a constant keeps its Go type.
+func TestModuleText_Sharing(t *testing.T) {
+	file := parse(t, roundTripSource)
+	analyzer := semantic.New()
+	if errs := analyzer.Analyze(file); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	module, errs := NewBuilder(analyzer).Build(file)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	text, err := module.MarshalText()
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := &Module{}
+	if err := read.UnmarshalText(text); err != nil {
+		t.Fatal(err)
+	}
+
+	limit := read.Globals[0]
+	if limit.Type != types.Int || limit.Initializer.Constant != int64(1024) {
+		t.Errorf("expected limit to be an int starting at int64 1024, got %s: %s = %#v", limit, limit.Type, limit.Initializer.Constant)
+	}
+	if letter := read.Globals[3]; letter.Initializer.Constant != 'q' {
+		t.Errorf("expected letter to start at rune 'q', got %#v", letter.Initializer.Constant)
+	}
+	// Node is one type, and its next field points to it
+	var node types.Type
+	for _, local := range read.Functions[1].Locals {
+		if local.Name == "first" || local.Name == "second" {
+			if node != nil && local.Type != node {
+				t.Errorf("expected first and second to share one type, got two")
+			}
+			node = local.Type
+		}
+	}
+	if s, ok := node.(*types.StructType); !ok || s.Fields[1].Type.(*types.PointerType).Elem != node {
+		t.Errorf("expected Node to be a struct pointing to itself, got %v", node)
+	}
+
+	// The read of limit in sum is the global itself
+	length := read.Functions[0]
+	found := false
+	for _, block := range length.Blocks {
+		for _, instr := range block.Instructions {
+			for _, operand := range instr.Operands() {
+				found = found || operand == limit
+			}
+		}
+	}
+	if !found {
+		t.Errorf("expected sum to use the global limit itself:\n%s", length)
+	}
+}
+
+func TestModuleText_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"not IR text", "; Module: main\n", "not IR text"},
+		{"unknown value", "irtext 1\nmodule \"main\"\nglobals %4\n", "line 3: unknown value %4"},
+		{"unknown type", "irtext 1\nmodule \"main\"\nvalue %0 var 0 \"x\" #2\nglobals %0\n", "line 3: unknown type #2"},
+		{"unknown instruction", "irtext 1\nmodule \"main\"\nglobals\nfunc \"f\" void 0 1 0\nparams\nlocals\nblock \"entry\" succ 0 pred 0\nleap 0\nend\n", "line 8: unknown instruction \"leap\""},
+		{"no end", "irtext 1\nmodule \"main\"\nglobals\nfunc \"f\" void 0 1 0\nparams\nlocals\nblock \"entry\" succ 0 pred 0\nreturn -\n", "expected \"end\""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := (&Module{}).UnmarshalText([]byte(tt.text))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected an error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
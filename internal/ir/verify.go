@@ -0,0 +1,276 @@
+package ir
+
+import (
+	"fmt"
+
+	"github.com/hassan/compiler/internal/semantic/types"
+)
+
+// CheckMissingReturn reports a path through fn that reaches the end of the
+// function without returning, when fn has a result to return.
+//
+// A path ends at a block with no successors. Reached from the entry, such
+// a block must end in a Return: anything else would fall off the end of
+// the function with no value for the caller.
+//
+// EXAMPLE:
+//
+//	entry:    branch c, if.then, if.end
+//	if.then:  return const(1)
+//	if.end:   (nothing)          <- reached when c is false
+//
+// Blocks the entry can't reach are left alone, so the empty block after an
+// if whose branches both return doesn't count.
+//
+// DESIGN CHOICE: Separate from Module.Verify. Verify checks the IR is
+// well-formed, and must keep doing so after every optimization; this is a
+// property of the source program, checked once, right after the builder
+// produced the function, where the error can still point at the source.
+// The semantic analyzer rejects the same programs first (see
+// semantic/returns.go), so this is the backstop for whatever the
+// statement-level approximation and the CFG disagree on.
+func CheckMissingReturn(fn *Function) error {
+	if fn.ReturnType == nil || fn.ReturnType.Equals(types.Void) {
+		return nil
+	}
+	for _, block := range reversePostorder(fn.Entry) {
+		if len(block.Successors) > 0 {
+			continue
+		}
+		if _, ok := block.Terminator().(*Return); !ok {
+			return fmt.Errorf("missing return in function %s: block %s reaches the end of the function", fn.Name, block.Label)
+		}
+	}
+	return nil
+}
+
+// verifySSA checks the part of fn that is in SSA form: its temporaries.
+// Each temporary must be defined by exactly one instruction, and that
+// definition must come before every use on every path - earlier in the
+// same block, or in a block that dominates the using one.
+//
+// EXAMPLE (invalid twice over):
+//
+//	entry:    branch c, if.then, if.end
+//	if.then:  t1 = const(1)
+//	          jump if.end
+//	if.end:   t1 = const(2)        <- t1 defined a second time
+//	          t2 = t1 + t3         <- t3 never defined
+//
+// A phi's incoming value is used at the end of the block it comes from,
+// not in the phi's own block.
+//
+// Named variables, parameters, globals and constants are exempt. A local
+// is a variable assigned by every Copy to it, as many times as the source
+// assigns it (see Builder), and the others are defined outside any block.
+// Only the temporaries the builder makes for intermediate results promise
+// to be assigned once, and the passes that rewrite them (copy propagation,
+// CSE) rely on that promise.
+//
+// DESIGN CHOICE: Dominance is checked only for uses in blocks the entry can
+// reach. An unreachable block is dominated by nothing, so every use in it
+// would count as undefined, and dead code elimination is free to leave such
+// blocks behind until it runs again. Whether their temporaries are defined
+// more than once is still checked.
+//
+// verifySSA recomputes fn's dominators. They depend on the CFG alone, so
+// this only brings them up to date.
+func verifySSA(fn *Function) []error {
+	var errs []error
+
+	type definition struct {
+		block *BasicBlock
+		index int
+	}
+	defs := make(map[*Value]definition)
+	for _, block := range fn.Blocks {
+		for i, instr := range block.Instructions {
+			dest := instr.Result()
+			if dest == nil || dest.Kind != ValueTemporary {
+				continue
+			}
+			if _, seen := defs[dest]; seen {
+				errs = append(errs, fmt.Errorf("value %s defined more than once in function %s", dest, fn.Name))
+				continue
+			}
+			defs[dest] = definition{block, i}
+		}
+	}
+
+	ComputeDominators(fn)
+	reachable := func(block *BasicBlock) bool {
+		return block == fn.Entry || fn.IDom[block] != nil
+	}
+	reported := make(map[*Value]bool)
+
+	// check reports operand unless its definition comes before position
+	// index of block, where block is reachable
+	check := func(operand *Value, block *BasicBlock, index int) {
+		if operand == nil || operand.Kind != ValueTemporary || reported[operand] {
+			return
+		}
+		def, ok := defs[operand]
+		switch {
+		case !ok:
+			errs = append(errs, fmt.Errorf("value %s used in block %s of function %s is never defined", operand, block.Label, fn.Name))
+		case def.block == block && def.index >= index,
+			def.block != block && !fn.Dominates(def.block, block):
+			errs = append(errs, fmt.Errorf("value %s used in block %s of function %s is not defined on every path to it", operand, block.Label, fn.Name))
+		default:
+			return
+		}
+		reported[operand] = true
+	}
+
+	for _, block := range fn.Blocks {
+		if !reachable(block) {
+			continue
+		}
+		for i, instr := range block.Instructions {
+			phi, ok := instr.(*Phi)
+			if !ok {
+				for _, operand := range instr.Operands() {
+					check(operand, block, i)
+				}
+				continue
+			}
+			// A phi reads each incoming value as control leaves the block
+			// it comes from, so that is where the value must be defined
+			for _, incoming := range phi.Incomig {
+				if incoming.Block != nil && reachable(incoming.Block) {
+					check(incoming.Value, incoming.Block, len(incoming.Block.Instructions))
+				}
+			}
+		}
+	}
+	return errs
+}
+
+// verifyTypes checks that the operands of fn's instructions have the types
+// their instructions need:
+//
+//   - the operands of a binary operation have the same type, except that a
+//     shift count may be of any integer type, and a comparison produces a
+//     bool while any other operation produces its operands' type
+//   - a branch condition is a bool
+//   - a call passes as many arguments as the callee has parameters, each
+//     assignable to its parameter, and its result has the callee's result
+//     type
+//   - a return gives a value assignable to the function's result type, or
+//     none in a function without one
+//
+// EXAMPLE (invalid):
+//
+//	t0 = x + 1.5         <- int + float
+//	branch t0, a, b      <- the condition is an int
+//
+// The rules are the IR's, which are looser than the language's in three
+// places. Values have the underlying type of a named type (see
+// buildLocalVar), while signatures keep the name, so types are compared
+// by what underlies them. Every integer is computed in 64 bits and wrapped
+// to its width afterwards (see wrapInto), so integer operands and results
+// may have any mix of integer types. And an address has the type of what
+// it points at (see buildAddress), so an argument for a *T parameter may
+// have type T.
+//
+// A value without a type is left alone, and so is anything it's an operand
+// of: the builder gives every value it makes a type, so a missing one is IR
+// built by hand (a test's), not a mismatch. Builtins are skipped too: they
+// have no function type to check against, and the analyzer checked each
+// builtin's own rule.
+func verifyTypes(fn *Function) []error {
+	var errs []error
+	report := func(block *BasicBlock, instr Instruction, format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("%s in block %s of function %s: %s", instr, block.Label, fn.Name, fmt.Sprintf(format, args...)))
+	}
+
+	for _, block := range fn.Blocks {
+		for _, instr := range block.Instructions {
+			switch in := instr.(type) {
+			case *BinaryOp:
+				if !typed(in.Dest, in.Left, in.Right) {
+					continue
+				}
+				left, right := types.Underlying(in.Left.Type), types.Underlying(in.Right.Type)
+				integers := types.IsIntegerType(left) && types.IsIntegerType(right)
+				switch {
+				case (in.Op == OpShl || in.Op == OpShr) && !integers:
+					report(block, in, "shifts %s by %s, not an integer by an integer", left, right)
+				case !integers && !left.Equals(right) && !right.AssignableTo(left) && !left.AssignableTo(right):
+					report(block, in, "mismatched operand types %s and %s", left, right)
+				case in.Op.isComparison():
+					if !types.IsBooleanType(in.Dest.Type) {
+						report(block, in, "comparison produces %s, not bool", in.Dest.Type)
+					}
+				case integers:
+					if !types.IsIntegerType(in.Dest.Type) {
+						report(block, in, "integer operation produces %s", in.Dest.Type)
+					}
+				case !types.Underlying(in.Dest.Type).Equals(left):
+					report(block, in, "result has type %s, not the operand type %s", in.Dest.Type, left)
+				}
+
+			case *Branch:
+				if typed(in.Condition) && !types.IsBooleanType(in.Condition.Type) {
+					report(block, in, "condition has type %s, not bool", in.Condition.Type)
+				}
+
+			case *Call:
+				if in.Builtin() != "" || !typed(in.Function) {
+					continue
+				}
+				callee, ok := types.Underlying(in.Function.Type).(*types.FunctionType)
+				if !ok {
+					report(block, in, "callee has type %s, not a function type", in.Function.Type)
+					continue
+				}
+				if len(in.Args) != len(callee.Parameters) {
+					report(block, in, "%d arguments for %d parameters", len(in.Args), len(callee.Parameters))
+					continue
+				}
+				for i, arg := range in.Args {
+					if typed(arg) && !assignableIR(arg.Type, callee.Parameters[i]) {
+						report(block, in, "argument %d has type %s, not %s", i+1, arg.Type, callee.Parameters[i])
+					}
+				}
+				if typed(in.Dest) && callee.ReturnType != nil &&
+					!types.Underlying(in.Dest.Type).Equals(types.Underlying(callee.ReturnType)) {
+					report(block, in, "result has type %s, not %s", in.Dest.Type, callee.ReturnType)
+				}
+
+			case *Return:
+				void := fn.ReturnType == nil || fn.ReturnType.Equals(types.Void)
+				switch {
+				case in.Value == nil && !void:
+					report(block, in, "no value returned from a function returning %s", fn.ReturnType)
+				case in.Value != nil && void:
+					report(block, in, "value returned from a function with no result")
+				case in.Value != nil && typed(in.Value) && !assignableIR(in.Value.Type, fn.ReturnType):
+					report(block, in, "returned value has type %s, not %s", in.Value.Type, fn.ReturnType)
+				}
+			}
+		}
+	}
+	return errs
+}
+
+// assignableIR reports whether a value of type v may be passed or returned
+// as a t, by the IR's rules (see verifyTypes).
+func assignableIR(v, t types.Type) bool {
+	v, t = types.Underlying(v), types.Underlying(t)
+	if v.AssignableTo(t) || v.Equals(t) {
+		return true
+	}
+	pointer, ok := t.(*types.PointerType)
+	return ok && types.Underlying(pointer.Elem).Equals(v)
+}
+
+// typed reports whether every one of values has a type.
+func typed(values ...*Value) bool {
+	for _, v := range values {
+		if v == nil || v.Type == nil {
+			return false
+		}
+	}
+	return true
+}
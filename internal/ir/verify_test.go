@@ -0,0 +1,386 @@
+package ir
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hassan/compiler/internal/semantic/types"
+)
+
+func TestCheckMissingReturn(t *testing.T) {
+	tests := []struct {
+		name    string
+		edges   []string
+		returns []string // blocks that end in a return
+		result  types.Type
+		want    string // the dead-end block reported, "" for none
+	}{
+		{
+			name:    "both branches return",
+			edges:   []string{"entry->then", "entry->else"},
+			returns: []string{"then", "else"},
+			result:  types.Int,
+		},
+		{
+			name:    "one branch falls off the end",
+			edges:   []string{"entry->then", "entry->end"},
+			returns: []string{"then"},
+			result:  types.Int,
+			want:    "end",
+		},
+		{
+			name:   "void function",
+			edges:  []string{"entry->then", "entry->end"},
+			result: types.Void,
+		},
+		{
+			// dead follows a return and is never reached
+			name:    "unreachable dead end",
+			edges:   []string{"dead->exit"},
+			returns: []string{"entry"},
+			result:  types.Int,
+		},
+		{
+			// for (;;) { } never reaches the end at all
+			name:   "infinite loop",
+			edges:  []string{"entry->body", "body->body"},
+			result: types.Int,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fn, blocks := graphFunction(tt.edges...)
+			fn.ReturnType = tt.result
+			for _, label := range tt.returns {
+				blocks[label].AddInstruction(&Return{Value: &Value{ID: -1, Type: types.Int, Kind: ValueConstant, Constant: int64(0)}})
+			}
+
+			err := CheckMissingReturn(fn)
+			if tt.want == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), "block "+tt.want+" ") {
+				t.Errorf("expected block %s reported, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestVerifyTerminators(t *testing.T) {
+	tests := []struct {
+		name   string
+		instrs []Instruction
+		want   string // the violation reported, "" for none
+	}{
+		{"return at the end", []Instruction{&Copy{Dest: &Value{ID: 0, Name: "x", Type: types.Int}, Value: intConst(1)}, &Return{}}, ""},
+		{"no terminator", []Instruction{&Copy{Dest: &Value{ID: 0, Name: "x", Type: types.Int}, Value: intConst(1)}},
+			"block entry in function f has no terminator"},
+		{"code after a return", []Instruction{&Return{}, &Copy{Dest: &Value{ID: 0, Name: "x", Type: types.Int}, Value: intConst(1)}},
+			"block entry in function f has instructions after its terminator, return"},
+		{"two returns", []Instruction{&Return{}, &Return{}},
+			"block entry in function f has instructions after its terminator, return"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fn := NewFunction("f", nil, types.Void)
+			for _, instr := range tt.instrs {
+				fn.Entry.AddInstruction(instr)
+			}
+			module := NewModule("main")
+			module.AddFunction(fn)
+			errs := module.Verify()
+			if tt.want == "" {
+				if len(errs) != 0 {
+					t.Errorf("expected no errors, got %v", errs)
+				}
+				return
+			}
+			if len(errs) != 1 || errs[0].Error() != tt.want {
+				t.Errorf("expected %q, got %v", tt.want, errs)
+			}
+		})
+	}
+}
+
+func TestVerifySSA(t *testing.T) {
+	// Each case fills in the blocks of a diamond, whose terminators are
+	// added after it: entry branches on c to then and else, which both jump
+	// to end
+	tests := []struct {
+		name string
+		fill func(fn *Function, c *Value, entry, then, els, end *BasicBlock)
+		want string // the violation reported, "" for none
+	}{
+		{
+			name: "valid",
+			fill: func(fn *Function, c *Value, entry, then, els, end *BasicBlock) {
+				t1 := fn.NewTemp(types.Int)
+				t2 := fn.NewTemp(types.Int)
+				entry.AddInstruction(&BinaryOp{Dest: t1, Op: OpAdd, Left: intConst(1), Right: intConst(2)})
+				end.AddInstruction(&BinaryOp{Dest: t2, Op: OpMul, Left: t1, Right: t1})
+			},
+		},
+		{
+			name: "named variable assigned on both paths",
+			fill: func(fn *Function, c *Value, entry, then, els, end *BasicBlock) {
+				x := fn.NewValue("x", types.Int, ValueVariable)
+				then.AddInstruction(&Copy{Dest: x, Value: intConst(1)})
+				els.AddInstruction(&Copy{Dest: x, Value: intConst(2)})
+				end.AddInstruction(&Copy{Dest: x, Value: x})
+			},
+		},
+		{
+			name: "phi of values from each branch",
+			fill: func(fn *Function, c *Value, entry, then, els, end *BasicBlock) {
+				a, b, r := fn.NewTemp(types.Int), fn.NewTemp(types.Int), fn.NewTemp(types.Int)
+				then.AddInstruction(&Copy{Dest: a, Value: intConst(1)})
+				els.AddInstruction(&Copy{Dest: b, Value: intConst(2)})
+				end.AddInstruction(&Phi{Dest: r, Incomig: []PhiIncoming{{a, then}, {b, els}}})
+			},
+		},
+		{
+			name: "defined in two blocks",
+			fill: func(fn *Function, c *Value, entry, then, els, end *BasicBlock) {
+				t1 := fn.NewTemp(types.Int)
+				then.AddInstruction(&Copy{Dest: t1, Value: intConst(1)})
+				els.AddInstruction(&Copy{Dest: t1, Value: intConst(2)})
+			},
+			want: "value t0 defined more than once in function f",
+		},
+		{
+			name: "never defined",
+			fill: func(fn *Function, c *Value, entry, then, els, end *BasicBlock) {
+				t1, t2 := fn.NewTemp(types.Int), fn.NewTemp(types.Int)
+				end.AddInstruction(&UnaryOp{Dest: t2, Op: OpNeg, Operand: t1})
+			},
+			want: "value t0 used in block end of function f is never defined",
+		},
+		{
+			name: "defined on one path only",
+			fill: func(fn *Function, c *Value, entry, then, els, end *BasicBlock) {
+				t1, t2 := fn.NewTemp(types.Int), fn.NewTemp(types.Int)
+				then.AddInstruction(&Copy{Dest: t1, Value: intConst(1)})
+				end.AddInstruction(&UnaryOp{Dest: t2, Op: OpNeg, Operand: t1})
+			},
+			want: "value t0 used in block end of function f is not defined on every path to it",
+		},
+		{
+			name: "used before its definition",
+			fill: func(fn *Function, c *Value, entry, then, els, end *BasicBlock) {
+				t1, t2 := fn.NewTemp(types.Int), fn.NewTemp(types.Int)
+				then.AddInstruction(&UnaryOp{Dest: t2, Op: OpNeg, Operand: t1})
+				then.AddInstruction(&Copy{Dest: t1, Value: intConst(1)})
+			},
+			want: "value t0 used in block then of function f is not defined on every path to it",
+		},
+		{
+			name: "phi of a value from the other branch",
+			fill: func(fn *Function, c *Value, entry, then, els, end *BasicBlock) {
+				a, r := fn.NewTemp(types.Int), fn.NewTemp(types.Int)
+				then.AddInstruction(&Copy{Dest: a, Value: intConst(1)})
+				end.AddInstruction(&Phi{Dest: r, Incomig: []PhiIncoming{{a, then}, {a, els}}})
+			},
+			want: "value t0 used in block else of function f is not defined on every path to it",
+		},
+		{
+			name: "unreachable use",
+			fill: func(fn *Function, c *Value, entry, then, els, end *BasicBlock) {
+				t1, t2 := fn.NewTemp(types.Int), fn.NewTemp(types.Int)
+				dead := fn.NewBasicBlockInFunc("dead")
+				dead.AddInstruction(&UnaryOp{Dest: t2, Op: OpNeg, Operand: t1})
+				dead.AddInstruction(&Jump{Target: end})
+				dead.AddSuccessor(end)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fn := NewFunction("f", nil, types.Void)
+			c := &Value{ID: -1, Type: types.Bool, Kind: ValueConstant, Constant: true}
+			entry := fn.Entry
+			then := fn.NewBasicBlockInFunc("then")
+			els := fn.NewBasicBlockInFunc("else")
+			end := fn.NewBasicBlockInFunc("end")
+			tt.fill(fn, c, entry, then, els, end)
+			entry.AddInstruction(&Branch{Condition: c, TrueBlock: then, FalseBlock: els})
+			entry.AddSuccessor(then)
+			entry.AddSuccessor(els)
+			for _, block := range []*BasicBlock{then, els} {
+				block.AddInstruction(&Jump{Target: end})
+				block.AddSuccessor(end)
+			}
+			end.AddInstruction(&Return{})
+
+			errs := verifySSA(fn)
+			if tt.want == "" {
+				if len(errs) != 0 {
+					t.Errorf("expected no errors, got %v", errs)
+				}
+				return
+			}
+			if len(errs) != 1 || errs[0].Error() != tt.want {
+				t.Errorf("expected %q, got %v", tt.want, errs)
+			}
+		})
+	}
+}
+
+func intConst(n int64) *Value {
+	return &Value{ID: -1, Type: types.Int, Kind: ValueConstant, Constant: n}
+}
+
+func TestVerifyTypes(t *testing.T) {
+	// Each case fills in the entry block of a function f(x int, s string)
+	// int, terminator included
+	tests := []struct {
+		name string
+		fill func(fn *Function, x, s *Value)
+		want string // the error Verify reports, "" for none
+	}{
+		{
+			name: "valid",
+			fill: func(fn *Function, x, s *Value) {
+				sum, less := fn.NewTemp(types.Int), fn.NewTemp(types.Bool)
+				fn.Entry.AddInstruction(&BinaryOp{Dest: sum, Op: OpAdd, Left: x, Right: intConst(1)})
+				fn.Entry.AddInstruction(&BinaryOp{Dest: less, Op: OpLt, Left: sum, Right: x})
+				fn.Entry.AddInstruction(&Return{Value: sum})
+			},
+		},
+		{
+			name: "narrow integer wrapped from 64 bits",
+			fill: func(fn *Function, x, s *Value) {
+				wide, narrow := fn.NewTemp(types.Int), fn.NewTemp(types.Uint8)
+				fn.Entry.AddInstruction(&BinaryOp{Dest: wide, Op: OpAdd, Left: x, Right: intConst(1)})
+				fn.Entry.AddInstruction(&BinaryOp{Dest: narrow, Op: OpBitAnd, Left: wide, Right: intConst(255)})
+				fn.Entry.AddInstruction(&Return{Value: x})
+			},
+		},
+		{
+			name: "mismatched operands",
+			fill: func(fn *Function, x, s *Value) {
+				sum := fn.NewTemp(types.Int)
+				fn.Entry.AddInstruction(&BinaryOp{Dest: sum, Op: OpAdd, Left: x, Right: s})
+				fn.Entry.AddInstruction(&Return{Value: sum})
+			},
+			want: "t2 = param(x.0) + param(s.1) in block entry of function f: mismatched operand types int and string",
+		},
+		{
+			name: "comparison producing an int",
+			fill: func(fn *Function, x, s *Value) {
+				less := fn.NewTemp(types.Int)
+				fn.Entry.AddInstruction(&BinaryOp{Dest: less, Op: OpLt, Left: x, Right: intConst(1)})
+				fn.Entry.AddInstruction(&Return{Value: less})
+			},
+			want: "t2 = param(x.0) < const(1) in block entry of function f: comparison produces int, not bool",
+		},
+		{
+			name: "shift by a string",
+			fill: func(fn *Function, x, s *Value) {
+				shifted := fn.NewTemp(types.Int)
+				fn.Entry.AddInstruction(&BinaryOp{Dest: shifted, Op: OpShl, Left: x, Right: s})
+				fn.Entry.AddInstruction(&Return{Value: shifted})
+			},
+			want: "t2 = param(x.0) << param(s.1) in block entry of function f: shifts int by string, not an integer by an integer",
+		},
+		{
+			name: "branch on an int",
+			fill: func(fn *Function, x, s *Value) {
+				exit := fn.NewBasicBlockInFunc("exit")
+				exit.AddInstruction(&Return{Value: x})
+				fn.Entry.AddInstruction(&Branch{Condition: x, TrueBlock: exit, FalseBlock: exit})
+				fn.Entry.AddSuccessor(exit)
+			},
+			want: "branch param(x.0), exit, exit in block entry of function f: condition has type int, not bool",
+		},
+		{
+			name: "argument of the wrong type",
+			fill: func(fn *Function, x, s *Value) {
+				g := &Value{ID: -1, Name: "g", Type: types.NewFunction([]types.Type{types.Int}, types.Int)}
+				result := fn.NewTemp(types.Int)
+				fn.Entry.AddInstruction(&Call{Dest: result, Function: g, Args: []*Value{s}})
+				fn.Entry.AddInstruction(&Return{Value: result})
+			},
+			want: "t2 = call g.-1([param(s.1)]) in block entry of function f: argument 1 has type string, not int",
+		},
+		{
+			name: "too few arguments",
+			fill: func(fn *Function, x, s *Value) {
+				g := &Value{ID: -1, Name: "g", Type: types.NewFunction([]types.Type{types.Int, types.Int}, types.Void)}
+				fn.Entry.AddInstruction(&Call{Function: g, Args: []*Value{x}})
+				fn.Entry.AddInstruction(&Return{Value: x})
+			},
+			want: "call g.-1([param(x.0)]) in block entry of function f: 1 arguments for 2 parameters",
+		},
+		{
+			name: "call result of the wrong type",
+			fill: func(fn *Function, x, s *Value) {
+				g := &Value{ID: -1, Name: "g", Type: types.NewFunction(nil, types.String)}
+				result := fn.NewTemp(types.Int)
+				fn.Entry.AddInstruction(&Call{Dest: result, Function: g})
+				fn.Entry.AddInstruction(&Return{Value: result})
+			},
+			want: "t2 = call g.-1([]) in block entry of function f: result has type int, not string",
+		},
+		{
+			name: "address passed for a pointer parameter",
+			fill: func(fn *Function, x, s *Value) {
+				inc := &Value{ID: -1, Name: "inc", Type: types.NewFunction([]types.Type{types.NewPointer(types.Int)}, types.Void)}
+				slot := fn.NewValue("n", types.Int, ValueVariable)
+				fn.Entry.AddInstruction(&Alloca{Dest: slot, Type: types.Int})
+				fn.Entry.AddInstruction(&Call{Function: inc, Args: []*Value{slot}})
+				fn.Entry.AddInstruction(&Return{Value: x})
+			},
+		},
+		{
+			name: "builtin",
+			fill: func(fn *Function, x, s *Value) {
+				builtin := &Value{ID: -1, Name: BuiltinPrefix + "print"}
+				fn.Entry.AddInstruction(&Call{Function: builtin, Args: []*Value{x, s}})
+				fn.Entry.AddInstruction(&Return{Value: x})
+			},
+		},
+		{
+			name: "returned value of the wrong type",
+			fill: func(fn *Function, x, s *Value) {
+				fn.Entry.AddInstruction(&Return{Value: s})
+			},
+			want: "return param(s.1) in block entry of function f: returned value has type string, not int",
+		},
+		{
+			name: "no value returned",
+			fill: func(fn *Function, x, s *Value) {
+				fn.Entry.AddInstruction(&Return{})
+			},
+			want: "return in block entry of function f: no value returned from a function returning int",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fn := NewFunction("f", nil, types.Int)
+			x := fn.NewValue("x", types.Int, ValueParameter)
+			s := fn.NewValue("s", types.String, ValueParameter)
+			fn.Parameters = []*Value{x, s}
+			tt.fill(fn, x, s)
+
+			module := NewModule("main")
+			module.AddFunction(fn)
+			errs := module.Verify()
+			if tt.want == "" {
+				if len(errs) != 0 {
+					t.Errorf("expected no errors, got %v", errs)
+				}
+				return
+			}
+			if len(errs) != 1 || errs[0].Error() != tt.want {
+				t.Errorf("expected %q, got %v", tt.want, errs)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package lexer
+
+import (
+	"strings"
+	"testing"
+)
+
+// benchmarkSource is about 1MB of generated source: many small functions,
+// an occasional non-ASCII string and comment, and one long line, which is
+// what generated code tends to look like.
+var benchmarkSource = func() string {
+	var sb strings.Builder
+	sb.WriteString("package main\n\n")
+	for i := 0; sb.Len() < 1<<20; i++ {
+		sb.WriteString("// compute returns a value derived from its arguments\n")
+		sb.WriteString("func compute(a int, b int) int {\n")
+		sb.WriteString("    var total int = a * 31 + b;\n")
+		sb.WriteString("    if (total >= 1024 && b != 0) {\n")
+		sb.WriteString("        total = total / b - 0x1F;\n")
+		sb.WriteString("    }\n")
+		if i%16 == 0 {
+			sb.WriteString("    println(\"grüße, 世界\"); /* non-ASCII */\n")
+		}
+		sb.WriteString("    return total << 2;\n")
+		sb.WriteString("}\n\n")
+	}
+	sb.WriteString("var table = [")
+	for i := 0; i < 10000; i++ {
+		sb.WriteString("1, 2, 3, ")
+	}
+	sb.WriteString("4];\n")
+	return sb.String()
+}()
+
+func BenchmarkNextToken(b *testing.B) {
+	b.SetBytes(int64(len(benchmarkSource)))
+	for i := 0; i < b.N; i++ {
+		l := New(benchmarkSource, "bench.src")
+		for {
+			token, _ := l.NextToken()
+			if token.Type == TokenEOF {
+				break
+			}
+		}
+	}
+}
+
+func BenchmarkTokenize(b *testing.B) {
+	b.SetBytes(int64(len(benchmarkSource)))
+	for i := 0; i < b.N; i++ {
+		Tokenize(benchmarkSource, "bench.src")
+	}
+}
+
+// tenThousandLines is the first 10,000 lines of benchmarkSource, the size
+// of a large hand-written file. NextToken allocates nothing for it, where
+// Tokenize allocates the slice it returns.
+var tenThousandLines = func() string {
+	lines := strings.SplitAfter(benchmarkSource, "\n")
+	return strings.Join(lines[:10000], "")
+}()
+
+func BenchmarkNextToken_10000Lines(b *testing.B) {
+	b.SetBytes(int64(len(tenThousandLines)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		l := New(tenThousandLines, "bench.src")
+		for {
+			token, _ := l.NextToken()
+			if token.Type == TokenEOF {
+				break
+			}
+		}
+	}
+}
+
+func BenchmarkTokenize_10000Lines(b *testing.B) {
+	b.SetBytes(int64(len(tenThousandLines)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		New(tenThousandLines, "bench.src").Tokenize()
+	}
+}
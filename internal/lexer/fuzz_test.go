@@ -0,0 +1,131 @@
+package lexer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// fuzzSeeds are the seed corpus: valid programs, and the kinds of broken
+// input a lexer is most likely to get wrong.
+var fuzzSeeds = []string{
+	// Valid
+	"",
+	"package main\n\nfunc main() int {\n    var x int = 1 + 2 * 3;\n    return x;\n}\n",
+	"var s string = \"a\\\"b\\n\"; var c char = '\\'';\n",
+	"x <<= 1; y >>= 2; z := a ** b -> c :: d ... e;\n",
+	"var f float = 1.5e-3 + 2E10 + 0.25;\n",
+	"var 名前 = \"héllo\"; // コメント\n",
+
+	// Invalid UTF-8, on its own, in an identifier, a string and a comment
+	"\xff",
+	"abc\xc3(x)",
+	"\"\xe2\x82\"",
+	"// \xf0\x9f\n x",
+	"/* \xc0\xaf */",
+
+	// Unterminated literals and comments
+	"\"no closing quote",
+	"\"ends at the newline\nx",
+	"\"escape at the end\\",
+	"'",
+	"'a",
+	"'\\",
+	"''",
+	"'''",
+	"'ab'",
+	"'ab\n'",
+	"/* never closed",
+	"/* /* closed once */",
+
+	// Deeply nested block comments
+	strings.Repeat("/*", 200) + strings.Repeat("*/", 200) + " x",
+	strings.Repeat("/* a\n", 50) + strings.Repeat("*/\n", 49),
+	"/*/ */ */",
+
+	// Input that once lexed badly: ".." swallowed a dot; floats without
+	// digits on one side of the dot were accepted; positions after a
+	// multi-line block comment and after non-ASCII text were off
+	"1..2",
+	"a..b",
+	"....",
+	".5 + 5. + x.5",
+	"1.e5 1e 1e+ 5.x",
+	"/* one\ntwo */ x /* three */\ny",
+	"var 名前 = \"héllo\"; x",
+
+	// CRLF line endings, and a '\r' on its own
+	"var x\r\n  = 1; // one\r\n/* two\r\nlines */\r\ny\r\n",
+	"// \r\r\n\r",
+}
+
+// FuzzLexer lexes arbitrary input to the end and checks what must hold for
+// any input at all: the lexer doesn't panic, it ends, tokens come out in
+// order and where they say they are, and TokenInvalid is only ever the
+// token of an error.
+//
+// Run it for longer than the seed corpus with
+//
+//	go test ./internal/lexer -fuzz=FuzzLexer -fuzztime=10s
+func FuzzLexer(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add([]byte(seed))
+	}
+	programs, _ := filepath.Glob("../../testdata/*/*.src")
+	for _, path := range programs {
+		if data, err := os.ReadFile(path); err == nil {
+			f.Add(data)
+		}
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		source := string(data)
+		l := New(source, "fuzz.src")
+
+		end := 0 // The end of the previous token
+		// Every token but EOF consumes at least one byte, so a lexer that
+		// doesn't end by then is stuck
+		for count := 0; ; count++ {
+			if count > len(source)+1 {
+				t.Fatalf("no EOF after %d tokens", count)
+			}
+			token, err := l.NextToken()
+
+			pos := token.Position
+			if pos.Offset < end || pos.Offset+token.Length > len(source) {
+				t.Fatalf("token %v at offset %d, length %d: the previous one ended at %d, the source at %d",
+					token, pos.Offset, token.Length, end, len(source))
+			}
+			if token.Type != TokenEOF && token.Length == 0 {
+				t.Fatalf("token %v at offset %d consumed nothing", token, pos.Offset)
+			}
+			end = pos.Offset + token.Length
+
+			before := source[:pos.Offset]
+			line := strings.Count(before, "\n") + 1
+			column := utf8.RuneCountInString(before[strings.LastIndexByte(before, '\n')+1:]) + 1
+			if pos.Line != line || pos.Column != column {
+				t.Fatalf("token %v at offset %d says %d:%d, want %d:%d", token, pos.Offset, pos.Line, pos.Column, line, column)
+			}
+
+			if err == nil && token.Type == TokenInvalid {
+				t.Fatalf("TokenInvalid at offset %d without an error", pos.Offset)
+			}
+			if err != nil {
+				lexErr, ok := err.(*Error)
+				if !ok || lexErr.Pos != pos {
+					t.Fatalf("error %v for the token at %v", err, pos)
+				}
+			}
+
+			if token.Type == TokenEOF {
+				if pos.Offset != len(source) {
+					t.Fatalf("EOF at offset %d of %d", pos.Offset, len(source))
+				}
+				return
+			}
+		}
+	})
+}
@@ -2,6 +2,7 @@ package lexer
 
 import (
 	"fmt"
+	"strings"
 	"unicode"
 	"unicode/utf8"
 )
@@ -51,13 +52,34 @@ type Lexer struct {
 	line int
 
 	// lineStart is the byte offset where the current line started.
-	// Used to calculate column numbers: column = current - lineStart + 1
+	// Used to calculate column numbers: the column of a token is one more
+	// than the number of runes in source[lineStart:start]
 	//
 	// DESIGN CHOICE: We track lineStart rather than column directly because:
 	// - It's more efficient (no increment on every character)
-	// - It's more accurate (handles multi-byte UTF-8 correctly)
+	// - Every path that consumes a character (advance, whitespace, comments,
+	//   backtracking in scanNumber) would otherwise have to keep a rune
+	//   count in step
 	// - Column can be computed on demand when creating tokens
 	lineStart int
+
+	// startLine and startLineStart are line and lineStart as they were at
+	// start. A block comment can span lines, and its position is where it
+	// begins, not where it ends.
+	startLine      int
+	startLineStart int
+
+	// columnOffset and column are the byte offset and rune column of the
+	// last position computed, so the next one on the same line only counts
+	// the runes after it. Counting from lineStart every time would make a
+	// long line (generated code, a big array literal) quadratic to lex.
+	columnOffset int
+	column       int
+
+	// endsStatement is whether the last token returned, comments aside,
+	// can end a statement, so that a newline next is a semicolon (see
+	// NextToken)
+	endsStatement bool
 }
 
 // New creates a new Lexer for the given source code.
@@ -75,9 +97,28 @@ func New(source, filename string) *Lexer {
 		current:   0,
 		line:      1, // Lines are 1-based
 		lineStart: 0,
+		startLine: 1,
 	}
 }
 
+// NewAt creates a lexer for source that starts at byte offset rather than
+// at the beginning, with the line and column it would have reached there.
+// offset must be the start of a token, or whitespace before one.
+//
+// It is for re-lexing part of a file that changed (see parser.Reparse):
+// tokens come out with the same positions as lexing the whole file would
+// give them.
+func NewAt(source, filename string, offset int) *Lexer {
+	l := New(source, filename)
+	l.current = offset
+	l.start = offset
+	l.line = strings.Count(source[:offset], "\n") + 1
+	l.lineStart = strings.LastIndexByte(source[:offset], '\n') + 1
+	l.startLine = l.line
+	l.startLineStart = l.lineStart
+	return l
+}
+
 // NextToken returns the next token from the source.
 //
 // This is the main entry point for consuming tokens. The parser will call this
@@ -92,6 +133,19 @@ func New(source, filename string) *Lexer {
 // - It allows error recovery (parser can continue)
 // - The position in the token is useful
 // - Multiple errors can be reported in one pass
+//
+// SEMICOLON INSERTION:
+// A newline after a token that can end a statement - an identifier, a
+// literal, a closing ')', ']' or '}', 'return', 'break', 'continue', '++'
+// or '--' - comes out as a TokenSemicolon, whose Lexeme is "\n". Comments
+// in between don't count, so "x = 1 // one" ends its statement too.
+//
+// DESIGN CHOICE: Go's rule, and for Go's reasons: it is decided by the
+// token before the newline alone, so the lexer can apply it without
+// knowing the grammar, and a reader can apply it without knowing the
+// parser. The cost is Go's too: a line can't end inside an expression
+// just before an operator or a '(' - "return a\n+ b" is two statements -
+// so a long expression breaks after its operator instead.
 func (l *Lexer) NextToken() (Token, error) {
 	// Skip whitespace and comments before each token.
 	// DESIGN CHOICE: Skip whitespace here rather than in a separate phase because:
@@ -102,7 +156,41 @@ func (l *Lexer) NextToken() (Token, error) {
 
 	// Mark the start of this token
 	l.start = l.current
+	l.startLine = l.line
+	l.startLineStart = l.lineStart
+
+	// skipWhitespace stops at the newline a semicolon goes in
+	if l.endsStatement && !l.isAtEnd() && l.source[l.current] == '\n' {
+		l.current++
+		l.line++
+		l.lineStart = l.current
+		l.endsStatement = false
+		return l.makeToken(TokenSemicolon, "\n"), nil
+	}
 
+	token, err := l.scanToken()
+	if token.Type != TokenComment {
+		l.endsStatement = endsStatement(token.Type)
+	}
+	return token, err
+}
+
+// endsStatement reports whether a token of type t can be the last of a
+// statement, and so is followed by a semicolon at the end of its line.
+func endsStatement(t TokenType) bool {
+	switch t {
+	case TokenIdentifier, TokenNumber, TokenString, TokenChar,
+		TokenTrue, TokenFalse, TokenNil,
+		TokenRightParen, TokenRightBracket, TokenRightBrace,
+		TokenReturn, TokenBreak, TokenContinue,
+		TokenPlusPlus, TokenMinusMinus:
+		return true
+	}
+	return false
+}
+
+// scanToken scans the token that starts at l.start.
+func (l *Lexer) scanToken() (Token, error) {
 	// Check for end of file
 	if l.isAtEnd() {
 		return l.makeToken(TokenEOF, ""), nil
@@ -259,10 +347,21 @@ func (l *Lexer) NextToken() (Token, error) {
 		return l.makeToken(TokenColon, ":"), nil
 
 	case '.':
-		// Check for ellipsis (...)
-		if l.match('.') && l.match('.') {
+		// A leading-dot float (".5") is scanned whole, to be rejected
+		if !l.isAtEnd() && isDigit(l.peek()) {
+			return l.scanNumber()
+		}
+		// Check for ellipsis (...). Both dots after the first are looked at
+		// before either is consumed: ".." isn't an ellipsis, and matching
+		// its second dot on the way to finding that out would drop it
+		if l.peek() == '.' && l.peekNext() == '.' {
+			l.advance()
+			l.advance()
 			return l.makeToken(TokenEllipsis, "..."), nil
 		}
+		if l.match('.') {
+			return l.makeToken(TokenInvalid, ".."), l.error("unexpected '..'")
+		}
 		return l.makeToken(TokenDot, "."), nil
 
 	case '"':
@@ -272,8 +371,13 @@ func (l *Lexer) NextToken() (Token, error) {
 		return l.scanChar()
 
 	default:
-		// Invalid character
-		_ = size // Unused for now, but needed for UTF-8 multi-byte handling
+		// Invalid character. A byte that doesn't decode comes out of
+		// advance as utf8.RuneError, which would be reported as a '\ufffd'
+		// that isn't in the source
+		if ch == utf8.RuneError && size == 1 {
+			return l.makeToken(TokenInvalid, ""),
+				l.error(fmt.Sprintf("invalid UTF-8 encoding: byte %#x", l.source[l.start]))
+		}
 		return l.makeToken(TokenInvalid, ""),
 			l.error(fmt.Sprintf("unexpected character: %q", ch))
 	}
@@ -287,10 +391,18 @@ func (l *Lexer) NextToken() (Token, error) {
 //
 // UNICODE HANDLING: We use utf8.DecodeRuneInString for proper Unicode support.
 // This means "世界" is two characters, not six bytes.
+//
+// PERFORMANCE: Nearly all source is ASCII, and a byte below utf8.RuneSelf is
+// a whole character on its own, so advance, peek, peekNext and match take it
+// directly and only decode when the byte starts a multi-byte character.
 func (l *Lexer) advance() (rune, int) {
 	if l.isAtEnd() {
 		return 0, 0
 	}
+	if b := l.source[l.current]; b < utf8.RuneSelf {
+		l.current++
+		return rune(b), 1
+	}
 	ch, size := utf8.DecodeRuneInString(l.source[l.current:])
 	l.current += size
 	return ch, size
@@ -305,6 +417,9 @@ func (l *Lexer) peek() rune {
 	if l.isAtEnd() {
 		return 0
 	}
+	if b := l.source[l.current]; b < utf8.RuneSelf {
+		return rune(b)
+	}
 	ch, _ := utf8.DecodeRuneInString(l.source[l.current:])
 	return ch
 }
@@ -318,7 +433,16 @@ func (l *Lexer) peekNext() rune {
 		return 0
 	}
 	// Skip the current character to get the next one
-	_, size := utf8.DecodeRuneInString(l.source[l.current:])
+	size := 1
+	if l.source[l.current] >= utf8.RuneSelf {
+		_, size = utf8.DecodeRuneInString(l.source[l.current:])
+	}
+	if l.current+size >= len(l.source) {
+		return 0
+	}
+	if b := l.source[l.current+size]; b < utf8.RuneSelf {
+		return rune(b)
+	}
 	ch, _ := utf8.DecodeRuneInString(l.source[l.current+size:])
 	return ch
 }
@@ -332,6 +456,13 @@ func (l *Lexer) match(expected rune) bool {
 	if l.isAtEnd() {
 		return false
 	}
+	if b := l.source[l.current]; b < utf8.RuneSelf {
+		if rune(b) != expected {
+			return false
+		}
+		l.current++
+		return true
+	}
 	ch, size := utf8.DecodeRuneInString(l.source[l.current:])
 	if ch != expected {
 		return false
@@ -353,22 +484,22 @@ func (l *Lexer) isAtEnd() bool {
 // - It's more efficient (fewer tokens to process)
 //
 // However, we DO track newlines for position information.
+//
+// Every whitespace character is a single byte, so the loop looks at bytes
+// and never decodes: a multi-byte character isn't whitespace, and ends it.
 func (l *Lexer) skipWhitespace() {
-	for {
-		if l.isAtEnd() {
-			return
-		}
-
-		ch := l.peek()
-		switch ch {
+	for ; !l.isAtEnd(); l.current++ {
+		switch l.source[l.current] {
 		case ' ', '\r', '\t':
 			// Simple whitespace - just skip it
-			l.advance()
 		case '\n':
-			// Newline - skip it but update line tracking
-			l.advance()
+			// Newline - skip it but update line tracking, unless it is
+			// a semicolon
+			if l.endsStatement {
+				return
+			}
 			l.line++
-			l.lineStart = l.current
+			l.lineStart = l.current + 1
 		default:
 			// Not whitespace - stop skipping
 			return
@@ -411,25 +542,35 @@ func (l *Lexer) scanIdentifier() Token {
 //
 // SUPPORTED FORMATS:
 // - Integers: 123, 0, 999999
-// - Floats: 123.456, 0.5, .5 (if we allow leading dot)
-// - Scientific notation: 1.23e10, 1e-5 (if we support it)
+// - Floats: 123.456, 0.5
+// - Scientific notation: 1.23e10, 1e-5
 // - Hex: 0x1234, 0xFF (if we support it)
 // - Binary: 0b1010 (if we support it)
 // - Octal: 0o777 (if we support it)
 //
 // For now, we'll implement a simple version that handles integers and floats.
 //
+// DESIGN CHOICE: A float needs digits on both sides of its '.': ".5" and "5."
+// are errors. Each is scanned as one token, so the error is about the
+// literal rather than a stray '.' the parser can't place. Accepting them
+// would make "5." the one place a '.' isn't followed by what it applies
+// to, and the error costs a "0" to fix.
+//
 // DESIGN CHOICE: The lexer doesn't validate number format (e.g., overflow).
 // It just recognizes that it's a number and passes it to the parser.
 // The semantic analyzer will validate and convert to the appropriate type.
 func (l *Lexer) scanNumber() (Token, error) {
+	// A leading '.' was consumed by NextToken
+	leadingDot := l.source[l.start] == '.'
+
 	// Scan integer part
 	for !l.isAtEnd() && isDigit(l.peek()) {
 		l.advance()
 	}
 
 	// Check for decimal point
-	if !l.isAtEnd() && l.peek() == '.' {
+	trailingDot := false
+	if !leadingDot && !l.isAtEnd() && l.peek() == '.' {
 		// Make sure it's not "..." (ellipsis) or ".field" (member access)
 		if l.peekNext() != '.' && isDigit(l.peekNext()) {
 			// Consume the '.'
@@ -439,6 +580,10 @@ func (l *Lexer) scanNumber() (Token, error) {
 			for !l.isAtEnd() && isDigit(l.peek()) {
 				l.advance()
 			}
+		} else if l.peekNext() != '.' && !isLetter(l.peekNext()) {
+			// "5." with nothing after the '.' to access
+			l.advance()
+			trailingDot = true
 		}
 	}
 
@@ -466,6 +611,10 @@ func (l *Lexer) scanNumber() (Token, error) {
 	}
 
 	text := l.source[l.start:l.current]
+	if leadingDot || trailingDot {
+		return l.makeToken(TokenNumber, text),
+			l.error("floating-point literal must have digits on both sides of '.'")
+	}
 	return l.makeToken(TokenNumber, text), nil
 }
 
@@ -539,6 +688,14 @@ func (l *Lexer) scanChar() (Token, error) {
 			l.error("unterminated character literal")
 	}
 
+	// '' holds nothing: the second quote closes it rather than being the
+	// character, which is written '\''
+	if ch == '\'' {
+		l.advance()
+		return l.makeToken(TokenInvalid, ""),
+			l.error("empty character literal")
+	}
+
 	if ch == '\\' {
 		// Escape sequence
 		l.advance() // consume backslash
@@ -549,8 +706,18 @@ func (l *Lexer) scanChar() (Token, error) {
 		l.advance() // consume regular character
 	}
 
-	// Expect closing quote
+	// Expect closing quote. One later on the line most likely closes a
+	// literal of several characters ('ab'), which is taken whole rather
+	// than lexed on as the identifier ab and a quote opening another
 	if l.isAtEnd() || l.peek() != '\'' {
+		rest := l.source[l.current:]
+		if end := strings.IndexAny(rest, "'\n"); end >= 0 && rest[end] == '\'' {
+			for target := l.current + end + 1; l.current < target; {
+				l.advance()
+			}
+			return l.makeToken(TokenInvalid, ""),
+				l.error("more than one character in character literal")
+		}
 		return l.makeToken(TokenInvalid, ""),
 			l.error("unterminated character literal")
 	}
@@ -570,8 +737,9 @@ func (l *Lexer) scanChar() (Token, error) {
 //
 // The parser can choose to ignore them if it wants.
 func (l *Lexer) scanLineComment() Token {
-	// Consume everything until newline
-	for !l.isAtEnd() && l.peek() != '\n' {
+	// Consume everything until newline. The '\r' of a "\r\n" line ending
+	// belongs to the newline, not the comment
+	for !l.isAtEnd() && l.peek() != '\n' && !(l.peek() == '\r' && l.peekNext() == '\n') {
 		l.advance()
 	}
 
@@ -589,6 +757,10 @@ func (l *Lexer) scanBlockComment() (Token, error) {
 	// Track nesting depth
 	depth := 1
 
+	// Line tracking works as in skipWhitespace: the newline is consumed
+	// first, so lineStart is the offset just past it. The nesting markers
+	// are two characters that are never a newline, so only the one-character
+	// branch can cross a line
 	for !l.isAtEnd() && depth > 0 {
 		ch := l.peek()
 
@@ -603,11 +775,11 @@ func (l *Lexer) scanBlockComment() (Token, error) {
 			l.advance()
 			depth--
 		} else {
+			l.advance()
 			if ch == '\n' {
 				l.line++
-				l.lineStart = l.current + 1
+				l.lineStart = l.current
 			}
-			l.advance()
 		}
 	}
 
@@ -634,18 +806,46 @@ func (l *Lexer) makeToken(tokenType TokenType, lexeme string) Token {
 }
 
 // currentPosition returns the current position in the source.
+//
+// Column counts runes, as Position promises, so the bytes between the start
+// of the line and the token are decoded rather than just measured: after
+// `var 名前 = "héllo"; ` the next token is at column 19, not 24.
 func (l *Lexer) currentPosition() Position {
+	if l.column == 0 || l.columnOffset < l.startLineStart || l.columnOffset > l.start {
+		// The first position, a new line, or further back than the last
+		// one: count from the start of the line
+		l.columnOffset = l.startLineStart
+		l.column = 1
+	}
+	l.column += utf8.RuneCountInString(l.source[l.columnOffset:l.start])
+	l.columnOffset = l.start
+
 	return Position{
 		Filename: l.filename,
-		Line:     l.line,
-		Column:   l.start - l.lineStart + 1, // 1-based column at start of token
-		Offset:   l.start,                    // 0-based
+		Line:     l.startLine,
+		Column:   l.column, // 1-based column at start of token
+		Offset:   l.start,  // 0-based
 	}
 }
 
+// Error is a lexical error with the position of the offending token.
+//
+// DESIGN CHOICE: The lexer returns a concrete type instead of a formatted
+// fmt error so callers (the parser, the JSON diagnostics writer) can recover
+// the position without parsing it back out of the message text.
+type Error struct {
+	Pos     Position
+	Message string
+}
+
+// Error implements the error interface as "file:line:col: message".
+func (e *Error) Error() string {
+	return e.Pos.String() + ": " + e.Message
+}
+
 // error creates an error with the current position.
 func (l *Lexer) error(message string) error {
-	return fmt.Errorf("%s: %s", l.currentPosition().String(), message)
+	return &Error{Pos: l.currentPosition(), Message: message}
 }
 
 // Helper functions for character classification
@@ -1,6 +1,7 @@
 package lexer
 
 import (
+	"fmt"
 	"testing"
 )
 
@@ -78,6 +79,91 @@ func TestLexer_Numbers(t *testing.T) {
 	}
 }
 
+func TestLexer_MalformedFloats(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{".5", ".5"},
+		{"5.", "5."},
+		{".5e3", ".5e3"},
+		{"12. ", "12."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			l := New(tt.source, "test.src")
+			token, err := l.NextToken()
+			if err == nil || err.Error() != "test.src:1:1: floating-point literal must have digits on both sides of '.'" {
+				t.Errorf("expected the malformed float error, got %v", err)
+			}
+			if token.Type != TokenNumber || token.Lexeme != tt.want {
+				t.Errorf("expected TokenNumber %q, got %v %q", tt.want, token.Type, token.Lexeme)
+			}
+		})
+	}
+
+	// A '.' next to a number that isn't part of it
+	for source, want := range map[string][]TokenType{
+		"p.x":  {TokenIdentifier, TokenDot, TokenIdentifier},
+		"5.x":  {TokenNumber, TokenDot, TokenIdentifier},
+		"a...": {TokenIdentifier, TokenEllipsis},
+		"1...": {TokenNumber, TokenEllipsis},
+	} {
+		l := New(source, "test.src")
+		for _, typ := range want {
+			token, err := l.NextToken()
+			if err != nil || token.Type != typ {
+				t.Errorf("%s: expected %v, got %v %q (%v)", source, typ, token.Type, token.Lexeme, err)
+			}
+		}
+	}
+}
+
+func TestLexer_Dots(t *testing.T) {
+	tests := []struct {
+		source string
+		want   []string // each token as TYPE(lexeme)@column
+		err    string   // the error, "" for none
+	}{
+		{".", []string{"DOT(.)@1"}, ""},
+		{"..", []string{"INVALID(..)@1"}, "test.src:1:1: unexpected '..'"},
+		{"...", []string{"ELLIPSIS(...)@1"}, ""},
+		{"....", []string{"ELLIPSIS(...)@1", "DOT(.)@4"}, ""},
+		{"a..b", []string{"IDENTIFIER(a)@1", "INVALID(..)@2", "IDENTIFIER(b)@4"}, "test.src:1:2: unexpected '..'"},
+		{"a. .b", []string{"IDENTIFIER(a)@1", "DOT(.)@2", "DOT(.)@4", "IDENTIFIER(b)@5"}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			l := New(tt.source, "test.src")
+			var got []string
+			var errs []string
+			for {
+				token, err := l.NextToken()
+				if err != nil {
+					errs = append(errs, err.Error())
+				}
+				if token.Type == TokenEOF {
+					break
+				}
+				got = append(got, fmt.Sprintf("%s(%s)@%d", token.Type, token.Lexeme, token.Position.Column))
+			}
+
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+			var want []string
+			if tt.err != "" {
+				want = []string{tt.err}
+			}
+			if fmt.Sprint(errs) != fmt.Sprint(want) {
+				t.Errorf("expected errors %v, got %v", want, errs)
+			}
+		})
+	}
+}
+
 func TestLexer_Strings(t *testing.T) {
 	source := `"hello" "world\n" "with\"quotes"`
 	l := New(source, "test.src")
@@ -176,7 +262,12 @@ func TestLexer_PositionTracking(t *testing.T) {
 		t.Errorf("expected column 1, got %d", token1.Position.Column)
 	}
 
-	// Second token: bar on line 2
+	// The newline between them is a semicolon
+	if token, _ := l.NextToken(); token.Type != TokenSemicolon {
+		t.Errorf("expected a semicolon, got %v", token.Type)
+	}
+
+	// Third token: bar on line 2
 	token2, _ := l.NextToken()
 	if token2.Position.Line != 2 {
 		t.Errorf("expected line 2, got %d", token2.Position.Line)
@@ -185,3 +276,380 @@ func TestLexer_PositionTracking(t *testing.T) {
 		t.Errorf("expected column 1, got %d", token2.Position.Column)
 	}
 }
+
+func TestLexer_MultiLineCommentPosition(t *testing.T) {
+	source := "x /* one\n   two */ y"
+	l := New(source, "test.src")
+	l.NextToken()
+
+	// The comment is where it starts, the token after it where it is
+	comment, _ := l.NextToken()
+	if comment.Type != TokenComment || comment.Position.Line != 1 || comment.Position.Column != 3 {
+		t.Errorf("expected the comment at 1:3, got %s", comment)
+	}
+	y, _ := l.NextToken()
+	if y.Position.Line != 2 || y.Position.Column != 11 {
+		t.Errorf("expected y at 2:11, got %s", y)
+	}
+}
+
+func TestLexer_PositionAfterBlockComment(t *testing.T) {
+	tests := []struct {
+		name       string
+		comment    string
+		line, col  int // of the y after the comment
+		commentEnd int // the offset just past the comment
+	}{
+		{"one line", "/* one */", 1, 13, 11},
+		{"two lines", "/* one\n   two */", 2, 11, 18},
+		{"five lines", "/*\n *\n *\n *\n */", 5, 5, 17},
+		{"nested", "/* a /* b */ c */", 1, 21, 19},
+		{"nested across lines", "/* a\n/* b\n*/\nc */", 4, 6, 19},
+		{"markers at line ends", "/*/*\n*/\n*/", 3, 4, 12},
+		{"unicode before a newline", "/* é\n */", 2, 5, 11},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := New("x "+tt.comment+" y", "test.src")
+			l.NextToken()
+
+			comment, err := l.NextToken()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if comment.Type != TokenComment || comment.Position.Line != 1 || comment.Position.Column != 3 {
+				t.Errorf("expected the comment at 1:3, got %s", comment)
+			}
+			if comment.Position.Offset+comment.Length != tt.commentEnd {
+				t.Errorf("expected the comment to end at offset %d, got %d", tt.commentEnd, comment.Position.Offset+comment.Length)
+			}
+
+			y, _ := l.NextToken()
+			if y.Type != TokenIdentifier || y.Position.Line != tt.line || y.Position.Column != tt.col {
+				t.Errorf("expected y at %d:%d, got %s", tt.line, tt.col, y)
+			}
+		})
+	}
+}
+
+func TestLexer_RuneColumns(t *testing.T) {
+	// Columns count runes: 名前 is two columns and six bytes, é one and two
+	source := "var 名前 = \"héllo\"; var x = 'ü';\n  名 = x;"
+	want := []struct {
+		lexeme         string
+		line, col, off int
+	}{
+		{"var", 1, 1, 0},
+		{"名前", 1, 5, 4},
+		{"=", 1, 8, 11},
+		{"\"héllo\"", 1, 10, 13},
+		{";", 1, 17, 21},
+		{"var", 1, 19, 23},
+		{"x", 1, 23, 27},
+		{"=", 1, 25, 29},
+		{"'ü'", 1, 27, 31},
+		{";", 1, 30, 35},
+		{"名", 2, 3, 39},
+		{"=", 2, 5, 43},
+	}
+
+	l := New(source, "test.src")
+	for _, w := range want {
+		token, err := l.NextToken()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		pos := token.Position
+		if token.Lexeme != w.lexeme || pos.Line != w.line || pos.Column != w.col || pos.Offset != w.off {
+			t.Errorf("expected %q at %d:%d (offset %d), got %q at %d:%d (offset %d)",
+				w.lexeme, w.line, w.col, w.off, token.Lexeme, pos.Line, pos.Column, pos.Offset)
+		}
+	}
+}
+
+// tok is a token as the tables below expect it.
+type tok struct {
+	typ    TokenType
+	lexeme string
+	line   int
+	column int
+}
+
+// lexAll lexes source to the end, failing the test on any error.
+func lexAll(t *testing.T, source string) []tok {
+	t.Helper()
+	l := New(source, "test.src")
+	var tokens []tok
+	for {
+		token, err := l.NextToken()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		tokens = append(tokens, tok{token.Type, token.Lexeme, token.Position.Line, token.Position.Column})
+		if token.Type == TokenEOF {
+			return tokens
+		}
+	}
+}
+
+func TestLexer_TokenSequences(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   []tok
+	}{
+		{
+			"shift assignments",
+			"x <<= 1 >>= y<<z",
+			[]tok{
+				{TokenIdentifier, "x", 1, 1}, {TokenShlEq, "<<=", 1, 3}, {TokenNumber, "1", 1, 7},
+				{TokenShrEq, ">>=", 1, 9}, {TokenIdentifier, "y", 1, 13}, {TokenShl, "<<", 1, 14},
+				{TokenIdentifier, "z", 1, 16}, {TokenEOF, "", 1, 17},
+			},
+		},
+		{
+			// An 'e' not followed by an exponent is backed out of, and
+			// lexes as the identifier it starts
+			"exponents",
+			"1e 1e+ 1E5 2.5e-3 3ex",
+			[]tok{
+				{TokenNumber, "1", 1, 1}, {TokenIdentifier, "e", 1, 2},
+				{TokenNumber, "1", 1, 4}, {TokenIdentifier, "e", 1, 5}, {TokenPlus, "+", 1, 6},
+				{TokenNumber, "1E5", 1, 8}, {TokenNumber, "2.5e-3", 1, 12},
+				{TokenNumber, "3", 1, 19}, {TokenIdentifier, "ex", 1, 20}, {TokenEOF, "", 1, 22},
+			},
+		},
+		{
+			"dots",
+			"p.x 1.5 f(a ...) 7.y",
+			[]tok{
+				{TokenIdentifier, "p", 1, 1}, {TokenDot, ".", 1, 2}, {TokenIdentifier, "x", 1, 3},
+				{TokenNumber, "1.5", 1, 5}, {TokenIdentifier, "f", 1, 9}, {TokenLeftParen, "(", 1, 10},
+				{TokenIdentifier, "a", 1, 11}, {TokenEllipsis, "...", 1, 13}, {TokenRightParen, ")", 1, 16},
+				{TokenNumber, "7", 1, 18}, {TokenDot, ".", 1, 19}, {TokenIdentifier, "y", 1, 20},
+				{TokenEOF, "", 1, 21},
+			},
+		},
+		{
+			// Columns count runes, so a multi-byte character is one column
+			"chars",
+			`'é' '世' '\n' '\'' 'a'`,
+			[]tok{
+				{TokenChar, "'é'", 1, 1}, {TokenChar, "'世'", 1, 5}, {TokenChar, `'\n'`, 1, 9},
+				{TokenChar, `'\''`, 1, 14}, {TokenChar, "'a'", 1, 19}, {TokenEOF, "", 1, 22},
+			},
+		},
+		{
+			"strings",
+			`"" "a\"b" "héllo" x`,
+			[]tok{
+				{TokenString, `""`, 1, 1}, {TokenString, `"a\"b"`, 1, 4}, {TokenString, `"héllo"`, 1, 11},
+				{TokenIdentifier, "x", 1, 19}, {TokenEOF, "", 1, 20},
+			},
+		},
+		{
+			"nested block comments",
+			"/* a /* b */ c */ x /**/ /*/**/*/\ny",
+			[]tok{
+				{TokenComment, "/* a /* b */ c */", 1, 1}, {TokenIdentifier, "x", 1, 19},
+				{TokenComment, "/**/", 1, 21}, {TokenComment, "/*/**/*/", 1, 26},
+				{TokenSemicolon, "\n", 1, 34}, {TokenIdentifier, "y", 2, 1}, {TokenEOF, "", 2, 2},
+			},
+		},
+		{
+			// A "\r\n" ending counts as one line, and the '\r' is not part
+			// of the line comment before it
+			"CRLF line endings",
+			"var x\r\n  = 1; // one\r\n/* two\r\nlines */\r\ny\r\n",
+			[]tok{
+				{TokenVar, "var", 1, 1}, {TokenIdentifier, "x", 1, 5}, {TokenSemicolon, "\n", 1, 7},
+				{TokenAssign, "=", 2, 3}, {TokenNumber, "1", 2, 5}, {TokenSemicolon, ";", 2, 6},
+				{TokenComment, "// one", 2, 8}, {TokenComment, "/* two\r\nlines */", 3, 1},
+				{TokenIdentifier, "y", 5, 1}, {TokenSemicolon, "\n", 5, 3}, {TokenEOF, "", 6, 1},
+			},
+		},
+		{
+			"keywords and identifiers",
+			"func iff(_x int) { return nil; }",
+			[]tok{
+				{TokenFunc, "func", 1, 1}, {TokenIdentifier, "iff", 1, 6}, {TokenLeftParen, "(", 1, 9},
+				{TokenIdentifier, "_x", 1, 10}, {TokenIdentifier, "int", 1, 13}, {TokenRightParen, ")", 1, 16},
+				{TokenLeftBrace, "{", 1, 18}, {TokenReturn, "return", 1, 20}, {TokenNil, "nil", 1, 27},
+				{TokenSemicolon, ";", 1, 30}, {TokenRightBrace, "}", 1, 32}, {TokenEOF, "", 1, 33},
+			},
+		},
+		{
+			// Each line ends with a token that can end a statement
+			"semicolons inserted",
+			"x\n12\n\"s\"\n'c'\ntrue\nnil\n)\n]\n}\nreturn\nbreak\ncontinue\ni++\n",
+			[]tok{
+				{TokenIdentifier, "x", 1, 1}, {TokenSemicolon, "\n", 1, 2},
+				{TokenNumber, "12", 2, 1}, {TokenSemicolon, "\n", 2, 3},
+				{TokenString, `"s"`, 3, 1}, {TokenSemicolon, "\n", 3, 4},
+				{TokenChar, "'c'", 4, 1}, {TokenSemicolon, "\n", 4, 4},
+				{TokenTrue, "true", 5, 1}, {TokenSemicolon, "\n", 5, 5},
+				{TokenNil, "nil", 6, 1}, {TokenSemicolon, "\n", 6, 4},
+				{TokenRightParen, ")", 7, 1}, {TokenSemicolon, "\n", 7, 2},
+				{TokenRightBracket, "]", 8, 1}, {TokenSemicolon, "\n", 8, 2},
+				{TokenRightBrace, "}", 9, 1}, {TokenSemicolon, "\n", 9, 2},
+				{TokenReturn, "return", 10, 1}, {TokenSemicolon, "\n", 10, 7},
+				{TokenBreak, "break", 11, 1}, {TokenSemicolon, "\n", 11, 6},
+				{TokenContinue, "continue", 12, 1}, {TokenSemicolon, "\n", 12, 9},
+				{TokenIdentifier, "i", 13, 1}, {TokenPlusPlus, "++", 13, 2}, {TokenSemicolon, "\n", 13, 4},
+				{TokenEOF, "", 14, 1},
+			},
+		},
+		{
+			// Nor after a ';' already there, nor at the end of the source
+			"semicolons not inserted",
+			"x +\ny,\nif (\n{\nz;\nw",
+			[]tok{
+				{TokenIdentifier, "x", 1, 1}, {TokenPlus, "+", 1, 3},
+				{TokenIdentifier, "y", 2, 1}, {TokenComma, ",", 2, 2},
+				{TokenIf, "if", 3, 1}, {TokenLeftParen, "(", 3, 4},
+				{TokenLeftBrace, "{", 4, 1},
+				{TokenIdentifier, "z", 5, 1}, {TokenSemicolon, ";", 5, 2},
+				{TokenIdentifier, "w", 6, 1}, {TokenEOF, "", 6, 2},
+			},
+		},
+		{
+			// A comment before the newline doesn't stop it being one, and
+			// the blank lines after it aren't more
+			"semicolons after comments",
+			"x // one\n\n\ny /* a */\nz",
+			[]tok{
+				{TokenIdentifier, "x", 1, 1}, {TokenComment, "// one", 1, 3}, {TokenSemicolon, "\n", 1, 9},
+				{TokenIdentifier, "y", 4, 1}, {TokenComment, "/* a */", 4, 3}, {TokenSemicolon, "\n", 4, 10},
+				{TokenIdentifier, "z", 5, 1}, {TokenEOF, "", 5, 2},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := lexAll(t, tt.source)
+			if len(got) != len(tt.want) {
+				t.Fatalf("expected %d tokens, got %d: %v", len(tt.want), len(got), got)
+			}
+			for i := range tt.want {
+				if got[i] != tt.want[i] {
+					t.Errorf("token %d: expected %v, got %v", i, tt.want[i], got[i])
+				}
+			}
+		})
+	}
+}
+
+// TestLexer_EveryOperator lexes each operator and delimiter on its own, and
+// all of them in a row where each could run into the next.
+func TestLexer_EveryOperator(t *testing.T) {
+	operators := []struct {
+		text string
+		typ  TokenType
+	}{
+		{"+", TokenPlus}, {"++", TokenPlusPlus}, {"+=", TokenPlusEq},
+		{"-", TokenMinus}, {"--", TokenMinusMinus}, {"-=", TokenMinusEq}, {"->", TokenArrow},
+		{"*", TokenStar}, {"**", TokenStarStar}, {"*=", TokenStarEq},
+		{"/", TokenSlash}, {"/=", TokenSlashEq}, {"%", TokenPercent}, {"%=", TokenPercentEq},
+		{"&", TokenBitAnd}, {"&&", TokenAnd}, {"&=", TokenAndEq},
+		{"|", TokenBitOr}, {"||", TokenOr}, {"|=", TokenOrEq},
+		{"^", TokenBitXor}, {"^=", TokenXorEq}, {"~", TokenBitNot},
+		{"=", TokenAssign}, {"==", TokenEqual}, {"!", TokenNot}, {"!=", TokenNotEqual},
+		{"<", TokenLess}, {"<=", TokenLessEqual}, {"<<", TokenShl}, {"<<=", TokenShlEq},
+		{">", TokenGreater}, {">=", TokenGreaterEqual}, {">>", TokenShr}, {">>=", TokenShrEq},
+		{":", TokenColon}, {"::", TokenColonColon}, {"?", TokenQuestion},
+		{".", TokenDot}, {"...", TokenEllipsis},
+		{"(", TokenLeftParen}, {")", TokenRightParen}, {"{", TokenLeftBrace}, {"}", TokenRightBrace},
+		{"[", TokenLeftBracket}, {"]", TokenRightBracket}, {";", TokenSemicolon}, {",", TokenComma},
+	}
+
+	var source string
+	var want []tok
+	for _, op := range operators {
+		got := lexAll(t, op.text)
+		if len(got) != 2 || got[0] != (tok{op.typ, op.text, 1, 1}) {
+			t.Errorf("%q: expected %v, got %v", op.text, op.typ, got)
+		}
+		want = append(want, tok{op.typ, op.text, 1, len(source) + 1})
+		source += op.text + " "
+	}
+	got := lexAll(t, source)
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("in a row, token %d: expected %v, got %v", i, want[i], got[i])
+		}
+	}
+}
+
+func TestLexer_EveryKeyword(t *testing.T) {
+	for text, typ := range keywords {
+		if got := lexAll(t, text); got[0] != (tok{typ, text, 1, 1}) {
+			t.Errorf("%q: expected %v, got %v", text, typ, got[0])
+		}
+		// Only the whole word is the keyword
+		for _, ident := range []string{text + "x", "_" + text, text + "1"} {
+			if got := lexAll(t, ident); got[0] != (tok{TokenIdentifier, ident, 1, 1}) {
+				t.Errorf("%q: expected an identifier, got %v", ident, got[0])
+			}
+		}
+	}
+}
+
+// TestLexer_Errors checks the error for each kind of bad input, and that
+// lexing carries on after it with the token that follows.
+func TestLexer_Errors(t *testing.T) {
+	tests := []struct {
+		source string
+		err    string
+		length int // of the token that came with the error
+		next   tok
+	}{
+		{`"abc`, "test.src:1:1: unterminated string literal", 4, tok{TokenEOF, "", 1, 5}},
+		{"\"abc\nx", "test.src:1:1: unterminated string literal", 4, tok{TokenIdentifier, "x", 2, 1}},
+		{`"ends in \`, "test.src:1:1: unterminated string literal", 10, tok{TokenEOF, "", 1, 11}},
+		{"'a", "test.src:1:1: unterminated character literal", 2, tok{TokenEOF, "", 1, 3}},
+		{"'", "test.src:1:1: unterminated character literal", 1, tok{TokenEOF, "", 1, 2}},
+		{"'\nx", "test.src:1:1: unterminated character literal", 1, tok{TokenIdentifier, "x", 2, 1}},
+		{"x 'é", "test.src:1:3: unterminated character literal", 3, tok{TokenEOF, "", 1, 5}},
+		{"'' x", "test.src:1:1: empty character literal", 2, tok{TokenIdentifier, "x", 1, 4}},
+		{"'ab' x", "test.src:1:1: more than one character in character literal", 4, tok{TokenIdentifier, "x", 1, 6}},
+		{"'ab\nx'", "test.src:1:1: unterminated character literal", 2, tok{TokenIdentifier, "b", 1, 3}},
+		{"/* never closed", "test.src:1:1: unterminated block comment", 15, tok{TokenEOF, "", 1, 16}},
+		{"/* /* closed once */\n", "test.src:1:1: unterminated block comment", 21, tok{TokenEOF, "", 2, 1}},
+		{"x @ y", "test.src:1:3: unexpected character: '@'", 1, tok{TokenIdentifier, "y", 1, 5}},
+		{"#", "test.src:1:1: unexpected character: '#'", 1, tok{TokenEOF, "", 1, 2}},
+		{"§x", "test.src:1:1: unexpected character: '§'", 2, tok{TokenIdentifier, "x", 1, 2}},
+		{"a\xffb", "test.src:1:2: invalid UTF-8 encoding: byte 0xff", 1, tok{TokenIdentifier, "b", 1, 3}},
+		{"a..b", "test.src:1:2: unexpected '..'", 2, tok{TokenIdentifier, "b", 1, 4}},
+		{".5", "test.src:1:1: floating-point literal must have digits on both sides of '.'", 2, tok{TokenEOF, "", 1, 3}},
+		{"5. x", "test.src:1:1: floating-point literal must have digits on both sides of '.'", 2, tok{TokenIdentifier, "x", 1, 4}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			l := New(tt.source, "test.src")
+			var token Token
+			var err error
+			for err == nil {
+				token, err = l.NextToken()
+				if token.Type == TokenEOF {
+					t.Fatalf("expected an error, got none")
+				}
+			}
+			if err.Error() != tt.err {
+				t.Errorf("expected error %q, got %q", tt.err, err.Error())
+			}
+			if token.Length != tt.length {
+				t.Errorf("expected the bad token to be %d bytes, got %d", tt.length, token.Length)
+			}
+
+			next, err := l.NextToken()
+			if err != nil {
+				t.Fatalf("unexpected second error: %v", err)
+			}
+			if got := (tok{next.Type, next.Lexeme, next.Position.Line, next.Position.Column}); got != tt.next {
+				t.Errorf("expected %v next, got %v", tt.next, got)
+			}
+		})
+	}
+}
@@ -2,6 +2,8 @@
 // It transforms raw source code text into a stream of tokens that can be consumed by the parser.
 package lexer
 
+import "unicode/utf8"
+
 // Position represents a location in the source code.
 //
 // DESIGN CHOICE: Position is a value type (not a pointer) because:
@@ -85,6 +87,73 @@ func (p Position) After(other Position) bool {
 	return p.Offset > other.Offset
 }
 
+// ExtractLine returns the text of the line this position is on (without the
+// line terminator) and the byte offset of the position's column within it.
+//
+// Column is measured in runes, so the returned offset accounts for any
+// multi-byte characters before it: for "x := \"世界\" + y" the column of y
+// is 13, but its byte offset is 16. A column past the end of the line is
+// clamped to len(lineText), so a caret can still point "just after" the last
+// character (where a missing semicolon would go).
+//
+// DESIGN CHOICE: We locate the line by scanning source for newlines rather
+// than trusting Offset. Offset is only meaningful against the exact text the
+// lexer saw; line numbers survive, for example, the driver re-reading a file
+// whose line endings were normalized. An invalid position, or a line past the
+// end of source, yields ("", 0).
+func (p Position) ExtractLine(source string) (lineText string, caretOffset int) {
+	if !p.IsValid() {
+		return "", 0
+	}
+	lineText, ok := lineAt(source, p.Line)
+	if !ok {
+		return "", 0
+	}
+	return lineText, columnToOffset(lineText, p.Column)
+}
+
+// lineAt returns the 1-based line number'th line of source, with any trailing
+// "\r" removed so Windows line endings don't leak into rendered output.
+func lineAt(source string, line int) (string, bool) {
+	start := 0
+	for current := 1; current < line; current++ {
+		i := indexByte(source[start:], '\n')
+		if i < 0 {
+			return "", false
+		}
+		start += i + 1
+	}
+	end := start + indexByte(source[start:], '\n')
+	if end < start {
+		end = len(source)
+	}
+	text := source[start:end]
+	if len(text) > 0 && text[len(text)-1] == '\r' {
+		text = text[:len(text)-1]
+	}
+	return text, true
+}
+
+// indexByte is strings.IndexByte, kept local for the same reason as itoa.
+func indexByte(s string, c byte) int {
+	for i := 0; i < len(s); i++ {
+		if s[i] == c {
+			return i
+		}
+	}
+	return -1
+}
+
+// columnToOffset converts a 1-based rune column into a byte offset in line.
+func columnToOffset(line string, column int) int {
+	offset := 0
+	for col := 1; col < column && offset < len(line); col++ {
+		_, size := utf8.DecodeRuneInString(line[offset:])
+		offset += size
+	}
+	return offset
+}
+
 // itoa is a simple integer to ASCII conversion.
 // We implement our own instead of using strconv.Itoa because:
 // - It avoids an import
@@ -176,3 +245,68 @@ func (s Span) Length() int {
 	}
 	return s.End.Offset - s.Start.Offset
 }
+
+// AnnotatedLine is one source line covered by a Span, with the part of it
+// the span covers.
+//
+// StartColumn and EndColumn are 1-based rune columns; EndColumn is exclusive,
+// so the underline is EndColumn-StartColumn characters wide (always at least
+// one). Renderers turn this into text like:
+//
+//	4 |     var y int = missing;
+//	  |                 ^^^^^^^
+type AnnotatedLine struct {
+	Line        int
+	Text        string
+	StartColumn int
+	EndColumn   int
+}
+
+// ExtractLines returns every source line the span touches, each annotated
+// with the columns to underline.
+//
+// The first line is underlined from Start.Column, the last up to End.Column,
+// and any lines in between in full - so an error spanning a multi-line block
+// comment marks the whole comment. A span whose End is missing or before
+// Start is treated as the single point Start.
+func (s Span) ExtractLines(source string) []AnnotatedLine {
+	if !s.Start.IsValid() {
+		return nil
+	}
+	end := s.End
+	if !end.IsValid() || end.Line < s.Start.Line ||
+		(end.Line == s.Start.Line && end.Column <= s.Start.Column) {
+		end = Position{Line: s.Start.Line, Column: s.Start.Column + 1}
+	}
+
+	lines := make([]AnnotatedLine, 0, end.Line-s.Start.Line+1)
+	for line := s.Start.Line; line <= end.Line; line++ {
+		text, ok := lineAt(source, line)
+		if !ok {
+			break
+		}
+		width := utf8.RuneCountInString(text)
+
+		startCol := 1
+		if line == s.Start.Line {
+			startCol = s.Start.Column
+		}
+		endCol := width + 1
+		if line == end.Line {
+			endCol = end.Column
+		}
+		// Always underline at least one column, even for an empty line in
+		// the middle of a span or a point just past the end of the text.
+		if endCol <= startCol {
+			endCol = startCol + 1
+		}
+
+		lines = append(lines, AnnotatedLine{
+			Line:        line,
+			Text:        text,
+			StartColumn: startCol,
+			EndColumn:   endCol,
+		})
+	}
+	return lines
+}
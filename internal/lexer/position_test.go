@@ -422,3 +422,84 @@ func TestSpan_Length(t *testing.T) {
 		})
 	}
 }
+
+func TestPosition_ExtractLine(t *testing.T) {
+	source := "package main\nvar s string = \"世界\" + y;\r\nlast"
+
+	tests := []struct {
+		name       string
+		pos        Position
+		wantText   string
+		wantOffset int
+	}{
+		{"first line", Position{Line: 1, Column: 9}, "package main", 8},
+		// 世 and 界 are 3 bytes each, so column 23 (the y) is byte 26.
+		{"after multi-byte runes", Position{Line: 2, Column: 23}, "var s string = \"世界\" + y;", 26},
+		{"on a multi-byte rune", Position{Line: 2, Column: 17}, "var s string = \"世界\" + y;", 16},
+		{"last line without newline", Position{Line: 3, Column: 1}, "last", 0},
+		{"column past end is clamped", Position{Line: 3, Column: 10}, "last", 4},
+		{"line past end", Position{Line: 9, Column: 1}, "", 0},
+		{"invalid position", Position{}, "", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, offset := tt.pos.ExtractLine(source)
+			if text != tt.wantText {
+				t.Errorf("expected line %q, got %q", tt.wantText, text)
+			}
+			if offset != tt.wantOffset {
+				t.Errorf("expected offset %d, got %d", tt.wantOffset, offset)
+			}
+		})
+	}
+}
+
+func TestSpan_ExtractLines_MultiLine(t *testing.T) {
+	source := "var x int = 1;\n/* unterminated\n   block\n   comment */ foo"
+
+	// The span an error about the block comment would carry: from its
+	// opening "/*" to just past its closing "*/".
+	span := Span{Start: Position{Line: 2, Column: 1}, End: Position{Line: 4, Column: 14}}
+	lines := span.ExtractLines(source)
+
+	expected := []AnnotatedLine{
+		{Line: 2, Text: "/* unterminated", StartColumn: 1, EndColumn: 16},
+		{Line: 3, Text: "   block", StartColumn: 1, EndColumn: 9},
+		{Line: 4, Text: "   comment */ foo", StartColumn: 1, EndColumn: 14},
+	}
+	if len(lines) != len(expected) {
+		t.Fatalf("expected %d lines, got %d: %+v", len(expected), len(lines), lines)
+	}
+	for i, want := range expected {
+		if lines[i] != want {
+			t.Errorf("line %d: expected %+v, got %+v", i, want, lines[i])
+		}
+	}
+}
+
+func TestSpan_ExtractLines_UTF8Column(t *testing.T) {
+	source := "var s string = \"é\";"
+
+	// A single rune that is two bytes wide still underlines one column.
+	span := Span{Start: Position{Line: 1, Column: 17}, End: Position{Line: 1, Column: 18}}
+	lines := span.ExtractLines(source)
+	if len(lines) != 1 {
+		t.Fatalf("expected 1 line, got %d", len(lines))
+	}
+	if lines[0].StartColumn != 17 || lines[0].EndColumn != 18 {
+		t.Errorf("expected columns 17-18, got %d-%d", lines[0].StartColumn, lines[0].EndColumn)
+	}
+
+	_, offset := span.Start.ExtractLine(source)
+	if source[offset:offset+2] != "é" {
+		t.Errorf("expected caret offset to land on the rune, got %q", source[offset:])
+	}
+
+	// A point span (no End) still underlines a single column.
+	point := Span{Start: Position{Line: 1, Column: 5}}
+	lines = point.ExtractLines(source)
+	if len(lines) != 1 || lines[0].EndColumn-lines[0].StartColumn != 1 {
+		t.Errorf("expected a one-column underline for a point span, got %+v", lines)
+	}
+}
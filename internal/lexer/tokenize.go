@@ -0,0 +1,49 @@
+package lexer
+
+// Tokenize lexes all of source at once, returning every token through the
+// final TokenEOF, comments included, and every error in the order it was
+// found.
+//
+// Each error is an *Error positioned at the start of the token it came
+// with, so a consumer that walks the tokens can tell which of them failed
+// to lex by comparing offsets. parser.NewFromTokens does this to report
+// exactly what parsing straight from a Lexer would.
+//
+// DESIGN CHOICE: A slice of every token rather than a channel or iterator,
+// because the callers that want tokens in bulk (the token dump, tools that
+// parse the same source more than once) want all of them anyway. Lexing
+// into a slice up front keeps the lexer's loop tight, and a token is small
+// enough that holding a whole file of them costs little.
+func Tokenize(source, filename string) ([]Token, []error) {
+	return New(source, filename).Tokenize()
+}
+
+// Tokenize returns the rest of l's tokens through the final TokenEOF, and
+// their errors, as the package-level Tokenize does for a whole source. A
+// lexer made by NewAt gives the tokens from its offset on.
+//
+// DESIGN CHOICE: Not a way around allocation. NextToken allocates nothing:
+// a Token is a value, and its Lexeme is a slice of the source, so there is
+// no per-token garbage for a pool of buffers to take back, and the one
+// allocation here - the slice - is all Tokenize adds. For the same reason
+// the parser keeps reading from its Lexer a token at a time rather than
+// tokenizing first: that is faster and needs half the memory
+// (parser's BenchmarkParse against BenchmarkParseFromTokens), and
+// parser.Reparse stops at the end of the region it re-lexes. Tokenize is
+// for a caller that needs every token anyway.
+func (l *Lexer) Tokenize() ([]Token, []error) {
+	// A token for every few bytes is typical; guessing the size up front
+	// saves most of the slice's regrowth
+	tokens := make([]Token, 0, (len(l.source)-l.current)/4+1)
+	var errs []error
+	for {
+		token, err := l.NextToken()
+		if err != nil {
+			errs = append(errs, err)
+		}
+		tokens = append(tokens, token)
+		if token.Type == TokenEOF {
+			return tokens, errs
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package lexer
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+	}{
+		{"empty", ""},
+		{"declaration", "var x int = 5; // five\n"},
+		{"errors", "var a = .5;\nvar b = a..b;\nvar c = \"open\n"},
+		{"non-ASCII", "var 名前 = \"héllo\"; /* ü\n世界 */ var y = 'ü';"},
+		{"long line", "var t = [" + strings.Repeat("1, 2, 3, ", 500) + "4];"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens, errs := Tokenize(tt.source, "test.src")
+
+			// The same tokens and errors as calling NextToken until EOF
+			l := New(tt.source, "test.src")
+			var want []Token
+			var wantErrs []error
+			for {
+				token, err := l.NextToken()
+				if err != nil {
+					wantErrs = append(wantErrs, err)
+				}
+				want = append(want, token)
+				if token.Type == TokenEOF {
+					break
+				}
+			}
+			if fmt.Sprint(tokens) != fmt.Sprint(want) {
+				t.Errorf("expected tokens %v, got %v", want, tokens)
+			}
+			if fmt.Sprint(errs) != fmt.Sprint(wantErrs) {
+				t.Errorf("expected errors %v, got %v", wantErrs, errs)
+			}
+			if len(tokens) == 0 || tokens[len(tokens)-1].Type != TokenEOF {
+				t.Fatalf("expected the tokens to end with EOF")
+			}
+
+			// Each error sits at the start of a token that came with it
+			offsets := make(map[int]bool)
+			for _, token := range tokens {
+				offsets[token.Position.Offset] = true
+			}
+			for _, err := range errs {
+				lexErr, ok := err.(*Error)
+				if !ok || !offsets[lexErr.Pos.Offset] {
+					t.Errorf("expected %v to be a *Error at the start of a token", err)
+				}
+			}
+
+			// Every column matches a count of the runes from its line start
+			for _, token := range tokens {
+				lineStart := strings.LastIndexByte(tt.source[:token.Position.Offset], '\n') + 1
+				column := utf8.RuneCountInString(tt.source[lineStart:token.Position.Offset]) + 1
+				if token.Position.Column != column {
+					t.Errorf("expected %v at column %d, got %d", token, column, token.Position.Column)
+				}
+			}
+		})
+	}
+}
+
+// TestLexerTokenize checks that a lexer made by NewAt tokenizes from its
+// offset, with the positions lexing the whole source gives those tokens.
+func TestLexerTokenize(t *testing.T) {
+	source := "var a = 1;\nvar 名 = \"x\"; var c = @;\n"
+	whole, wholeErrs := Tokenize(source, "test.src")
+	offset := strings.Index(source, "var 名")
+
+	tokens, errs := NewAt(source, "test.src", offset).Tokenize()
+	var want []Token
+	for _, token := range whole {
+		if token.Position.Offset >= offset {
+			want = append(want, token)
+		}
+	}
+	if fmt.Sprint(tokens) != fmt.Sprint(want) {
+		t.Errorf("expected tokens %v, got %v", want, tokens)
+	}
+	if fmt.Sprint(errs) != fmt.Sprint(wholeErrs) {
+		t.Errorf("expected errors %v, got %v", wholeErrs, errs)
+	}
+}
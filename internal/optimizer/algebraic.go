@@ -0,0 +1,261 @@
+package optimizer
+
+import (
+	"github.com/hassan/compiler/internal/ir"
+	"github.com/hassan/compiler/internal/semantic/types"
+)
+
+// AlgebraicSimplificationPass rewrites operations whose result follows from
+// an algebraic identity, or that have a cheaper equivalent.
+//
+// EXAMPLE:
+//
+//	Before:  t1 = x * 1
+//	         t2 = y * 8
+//	         t3 = -t4        // t4 = -z
+//	After:   t1 = x
+//	         t2 = y << 3
+//	         t3 = z
+//
+// IDENTITIES (x is any integer value, c a constant):
+// - x * 1, 1 * x, x + 0, 0 + x, x - 0, x / 1  ->  x
+// - x * 0, 0 * x, x - x, x % 1                ->  0
+// - x * 2^n, 2^n * x                          ->  x << n
+// - x % 2^n                                   ->  x & (2^n - 1), x >= 0 only
+// - -(-x), !(!x), ~(~x)                       ->  x
+//
+// Unlike constant folding, only one operand needs to be known. The two
+// passes feed each other: "x - x" becoming 0 lets folding evaluate what
+// uses it, and a folded 1 turns "y * t1" into y.
+//
+// DESIGN CHOICE: Every rewrite keeps the instruction's Dest, replacing it
+// with a Copy or a cheaper BinaryOp in the same place. Nothing that reads
+// the result has to change, and a "t1 = x" left behind is a Copy like any
+// other for the later passes.
+//
+// SIGNEDNESS: Integers are signed 64-bit and wrap on overflow, as in the
+// interpreter and every backend.
+//   - x << n is exactly x * 2^n, including when the product overflows: both
+//     keep the low 64 bits of the two's-complement result.
+//   - x % 2^n is not x & (2^n - 1) for negative x. % truncates toward zero
+//     and keeps the sign of x (-3 % 4 is -3), while the mask always gives a
+//     non-negative result (-3 & 3 is 1). The rewrite is only made when x is
+//     provably non-negative (see nonNegative), which a uint64 always is.
+//   - x / 2^n is left alone for the same reason: a shift rounds toward
+//     minus infinity, division toward zero.
+//
+// Only integer operations are rewritten. For floats x - x is NaN when x
+// is, and x * 0 is -0 for negative x.
+type AlgebraicSimplificationPass struct{}
+
+// Name returns the name of this optimization pass.
+func (a *AlgebraicSimplificationPass) Name() string {
+	return "AlgebraicSimplification"
+}
+
+// Run simplifies the instructions of fn, reporting whether any changed.
+func (a *AlgebraicSimplificationPass) Run(fn *ir.Function) (bool, error) {
+	stats, err := a.RunWithStats(fn)
+	return stats.ExpressionsSimplified > 0, err
+}
+
+// RunWithStats simplifies like Run and counts the rewritten instructions.
+func (a *AlgebraicSimplificationPass) RunWithStats(fn *ir.Function) (PassStats, error) {
+	var stats PassStats
+	constants, definitions := knownConstants(fn)
+
+	// The one definition of each value defined once, for nonNegative
+	single := make(map[*ir.Value]ir.Instruction)
+	for _, block := range fn.Blocks {
+		for _, instr := range block.Instructions {
+			if dest := instr.Result(); dest != nil && definitions[dest] == 1 {
+				single[dest] = instr
+			}
+		}
+	}
+	s := &simplifier{constants: constants, single: single}
+
+	for _, block := range fn.Blocks {
+		// negations maps t to "t = op x" for the unary operations seen so
+		// far in this block whose operand hasn't been assigned since, so
+		// "op t" is still "op op x"
+		negations := make(map[*ir.Value]*ir.UnaryOp)
+
+		for i, instr := range block.Instructions {
+			var replacement ir.Instruction
+			switch in := instr.(type) {
+			case *ir.BinaryOp:
+				replacement = s.binary(in)
+			case *ir.UnaryOp:
+				replacement = s.unary(in, negations)
+			}
+			if replacement != nil {
+				block.Instructions[i] = replacement
+				instr = replacement
+				stats.ExpressionsSimplified++
+
+				// Later instructions can use a produced constant, as
+				// they can a folded one
+				if copy, ok := replacement.(*ir.Copy); ok && copy.Value.IsConstant() && definitions[copy.Dest] == 1 {
+					constants[copy.Dest] = copy.Value.Constant
+				}
+			}
+
+			dest := instr.Result()
+			if dest == nil {
+				continue
+			}
+			delete(negations, dest)
+			for t, neg := range negations {
+				if neg.Operand == dest {
+					delete(negations, t)
+				}
+			}
+			if neg, ok := instr.(*ir.UnaryOp); ok && neg.Operand != dest {
+				negations[dest] = neg
+			}
+		}
+	}
+
+	return stats, nil
+}
+
+// simplifier holds what the pass knows about the function's values.
+type simplifier struct {
+	// constants maps values known to hold one constant to it
+	constants map[*ir.Value]interface{}
+
+	// single maps each value with exactly one definition to it
+	single map[*ir.Value]ir.Instruction
+}
+
+// intConstant returns the integer constant v is known to hold.
+func (s *simplifier) intConstant(v *ir.Value) (int64, bool) {
+	if v.IsConstant() {
+		n, ok := v.Constant.(int64)
+		return n, ok
+	}
+	n, ok := s.constants[v].(int64)
+	return n, ok
+}
+
+// binary returns a simpler replacement for op, or nil if there is none.
+func (s *simplifier) binary(op *ir.BinaryOp) ir.Instruction {
+	if !types.IsIntegerType(op.Dest.Type) {
+		return nil
+	}
+	left, leftConst := s.intConstant(op.Left)
+	right, rightConst := s.intConstant(op.Right)
+
+	switch op.Op {
+	case ir.OpAdd:
+		if rightConst && right == 0 {
+			return copyOf(op.Dest, op.Left)
+		}
+		if leftConst && left == 0 {
+			return copyOf(op.Dest, op.Right)
+		}
+
+	case ir.OpSub:
+		if rightConst && right == 0 {
+			return copyOf(op.Dest, op.Left)
+		}
+		if op.Left == op.Right {
+			return copyOf(op.Dest, intValue(0))
+		}
+
+	case ir.OpMul:
+		// Operands are tried in both orders: multiplication commutes
+		for _, pair := range [2][2]*ir.Value{{op.Left, op.Right}, {op.Right, op.Left}} {
+			x, c := pair[0], pair[1]
+			n, ok := s.intConstant(c)
+			if !ok {
+				continue
+			}
+			switch {
+			case n == 1:
+				return copyOf(op.Dest, x)
+			case n == 0:
+				return copyOf(op.Dest, intValue(0))
+			case isPowerOfTwo(n):
+				return &ir.BinaryOp{Op: ir.OpShl, Dest: op.Dest, Left: x, Right: intValue(log2(n))}
+			}
+		}
+
+	case ir.OpDiv:
+		if rightConst && right == 1 {
+			return copyOf(op.Dest, op.Left)
+		}
+
+	case ir.OpMod:
+		if rightConst && right == 1 {
+			return copyOf(op.Dest, intValue(0))
+		}
+		if rightConst && isPowerOfTwo(right) && s.nonNegative(op.Left) {
+			return &ir.BinaryOp{Op: ir.OpBitAnd, Dest: op.Dest, Left: op.Left, Right: intValue(right - 1)}
+		}
+	}
+	return nil
+}
+
+// unary replaces the second of two identical negations with a copy of what
+// the first negated. negations holds the unary operations still valid at op.
+func (s *simplifier) unary(op *ir.UnaryOp, negations map[*ir.Value]*ir.UnaryOp) ir.Instruction {
+	inner, ok := negations[op.Operand]
+	if !ok || inner.Op != op.Op {
+		return nil
+	}
+	return copyOf(op.Dest, inner.Operand)
+}
+
+// nonNegative reports whether v can be proved never to be negative: it is a
+// uint64, a non-negative constant, or its only definition masks with one ("t = x & 7").
+//
+// DESIGN CHOICE: Only the proofs that need no analysis beyond the single
+// definition. The sign of a parameter or a loop counter needs range analysis
+// this optimizer doesn't have; until it does, % by a power of two on those
+// stays a %.
+func (s *simplifier) nonNegative(v *ir.Value) bool {
+	if types.IsUnsigned64(v.Type) {
+		return true
+	}
+	if n, ok := s.intConstant(v); ok {
+		return n >= 0
+	}
+	def, ok := s.single[v].(*ir.BinaryOp)
+	if !ok || def.Op != ir.OpBitAnd {
+		return false
+	}
+	for _, operand := range []*ir.Value{def.Left, def.Right} {
+		if n, ok := s.intConstant(operand); ok && n >= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// copyOf builds "dest = v".
+func copyOf(dest, v *ir.Value) *ir.Copy {
+	return &ir.Copy{Dest: dest, Value: v}
+}
+
+// intValue builds an integer constant operand.
+func intValue(n int64) *ir.Value {
+	return &ir.Value{ID: -1, Type: types.Int, Kind: ir.ValueConstant, Constant: n}
+}
+
+// isPowerOfTwo reports whether n is 2^k for some k >= 1. 1 is left out: its
+// identities (x * 1, x % 1) are simpler than a shift or a mask.
+func isPowerOfTwo(n int64) bool {
+	return n > 1 && n&(n-1) == 0
+}
+
+// log2 returns k for n = 2^k.
+func log2(n int64) int64 {
+	k := int64(0)
+	for n > 1 {
+		n >>= 1
+		k++
+	}
+	return k
+}
@@ -45,21 +45,17 @@ func (c *ConstantFoldingPass) Name() string {
 // - Handles chains of constant operations in one pass
 // - Avoids infinite loops
 // - More efficient than iterating
-func (c *ConstantFoldingPass) Run(fn *ir.Function) error {
-	// Map from values to their constant values
-	constants := make(map[*ir.Value]interface{})
+func (c *ConstantFoldingPass) Run(fn *ir.Function) (bool, error) {
+	stats, err := c.RunWithStats(fn)
+	return stats.ConstantsFolded > 0, err
+}
+
+// RunWithStats folds constants like Run and counts the folded instructions.
+func (c *ConstantFoldingPass) RunWithStats(fn *ir.Function) (PassStats, error) {
+	var stats PassStats
 
 	// First pass: identify all existing constants
-	for _, block := range fn.Blocks {
-		for _, instr := range block.Instructions {
-			// Check for Copy instructions from constants
-			if copy, ok := instr.(*ir.Copy); ok {
-				if copy.Value.IsConstant() {
-					constants[copy.Dest] = copy.Value.Constant
-				}
-			}
-		}
-	}
+	constants, definitions := knownConstants(fn)
 
 	// Second pass: fold instructions
 	for _, block := range fn.Blocks {
@@ -67,10 +63,11 @@ func (c *ConstantFoldingPass) Run(fn *ir.Function) error {
 			folded := c.foldInstructionWithConstants(instr, constants)
 			if folded != nil {
 				block.Instructions[i] = folded
+				stats.ConstantsFolded++
 
 				// Update constants map with newly folded value
 				if copy, ok := folded.(*ir.Copy); ok {
-					if copy.Value.IsConstant() {
+					if copy.Value.IsConstant() && definitions[copy.Dest] == 1 {
 						constants[copy.Dest] = copy.Value.Constant
 					}
 				}
@@ -78,7 +75,37 @@ func (c *ConstantFoldingPass) Run(fn *ir.Function) error {
 		}
 	}
 
-	return nil
+	return stats, nil
+}
+
+// knownConstants maps every value whose only definition copies a constant to
+// that constant, and counts the definitions of every value.
+//
+// The IR is not in SSA form: a source variable is one value assigned by
+// every assignment to it, so "x = const(0)" only makes x a constant if
+// nothing else ever assigns x. A loop counter initialized to 0 is not 0.
+func knownConstants(fn *ir.Function) (map[*ir.Value]interface{}, map[*ir.Value]int) {
+	definitions := make(map[*ir.Value]int)
+	for _, block := range fn.Blocks {
+		for _, instr := range block.Instructions {
+			if dest := instr.Result(); dest != nil {
+				definitions[dest]++
+			}
+		}
+	}
+
+	constants := make(map[*ir.Value]interface{})
+	for _, block := range fn.Blocks {
+		for _, instr := range block.Instructions {
+			// Check for Copy instructions from constants
+			if copy, ok := instr.(*ir.Copy); ok {
+				if copy.Value.IsConstant() && definitions[copy.Dest] == 1 {
+					constants[copy.Dest] = copy.Value.Constant
+				}
+			}
+		}
+	}
+	return constants, definitions
 }
 
 // foldInstructionWithConstants attempts to fold using a constant map.
@@ -110,6 +137,23 @@ func (c *ConstantFoldingPass) getConstantValue(v *ir.Value, constants map[*ir.Va
 // IMPLEMENTATION NOTE:
 // We only fold integer operations for now. Floating point folding
 // is tricky due to precision and rounding modes.
+//
+// INTEGER SEMANTICS: A folded operation must give what the program would
+// have computed at run time, which the interpreter and every backend agree
+// on:
+//   - Integers are 64-bit two's complement and wrap: MaxInt + 1 is MinInt,
+//     and MinInt / -1 is MinInt (with MinInt % -1 being 0)
+//   - A shift of 64 or more shifts every bit out, giving 0 (or -1 for >> of
+//     a negative value)
+//   - Division by zero and a negative shift amount are runtime errors
+//   - A result stored as a narrower integer type wraps to its width; uint64
+//     operands divide, compare and shift right unsigned (see types.IntType)
+//
+// Go's int64 arithmetic already wraps the same way, so the first two need
+// nothing special. The runtime errors are never folded: the instruction is
+// left for the program to fail on when (and if) it runs. Overflow in a
+// constant expression is reported by the semantic analyzer, which still
+// knows where in the source it is.
 func (c *ConstantFoldingPass) foldBinaryOpWithConstants(op *ir.BinaryOp, constants map[*ir.Value]interface{}) ir.Instruction {
 	// Check if both operands are constants (directly or via the map)
 	leftConst, leftOk := c.getConstantValue(op.Left, constants)
@@ -126,6 +170,11 @@ func (c *ConstantFoldingPass) foldBinaryOpWithConstants(op *ir.BinaryOp, constan
 	if !leftOk || !rightOk {
 		return nil
 	}
+	if types.IsUnsigned64(op.Left.Type) {
+		if folded, ok := c.foldUnsigned(op, uint64(leftVal), uint64(rightVal)); ok {
+			return folded
+		}
+	}
 
 	// Evaluate the operation
 	var result int64
@@ -190,10 +239,16 @@ func (c *ConstantFoldingPass) foldBinaryOpWithConstants(op *ir.BinaryOp, constan
 		result = leftVal | rightVal
 	case ir.OpBitXor:
 		result = leftVal ^ rightVal
-	case ir.OpShl:
-		result = leftVal << uint(rightVal)
-	case ir.OpShr:
-		result = leftVal >> uint(rightVal)
+	case ir.OpShl, ir.OpShr:
+		// Don't fold a negative shift amount: it fails at run time
+		if rightVal < 0 {
+			return nil
+		}
+		if op.Op == ir.OpShl {
+			result = leftVal << uint64(rightVal)
+		} else {
+			result = leftVal >> uint64(rightVal)
+		}
 
 	default:
 		isValid = false
@@ -203,19 +258,39 @@ func (c *ConstantFoldingPass) foldBinaryOpWithConstants(op *ir.BinaryOp, constan
 		return nil
 	}
 
-	// Create a constant value
-	constValue := &ir.Value{
-		ID:       -1, // Temporary ID
-		Type:     types.Int,
-		Kind:     ir.ValueConstant,
-		Constant: result,
-	}
-
 	// Replace with a copy instruction
-	return &ir.Copy{
-		Dest:  op.Dest,
-		Value: constValue,
+	return c.createIntCopy(op.Dest, result)
+}
+
+// foldUnsigned folds the operations on uint64 operands that differ from the
+// signed ones, reporting whether op was one of them. A division by zero or a
+// negative shift count (the count is read as an int) is left unfolded, like
+// its signed counterpart.
+func (c *ConstantFoldingPass) foldUnsigned(op *ir.BinaryOp, left, right uint64) (ir.Instruction, bool) {
+	switch op.Op {
+	case ir.OpDiv, ir.OpMod:
+		if right == 0 {
+			return nil, true
+		}
+		if op.Op == ir.OpDiv {
+			return c.createIntCopy(op.Dest, int64(left/right)), true
+		}
+		return c.createIntCopy(op.Dest, int64(left%right)), true
+	case ir.OpShr:
+		if int64(right) < 0 {
+			return nil, true
+		}
+		return c.createIntCopy(op.Dest, int64(left>>right)), true
+	case ir.OpLt:
+		return c.createBoolCopy(op.Dest, left < right), true
+	case ir.OpLe:
+		return c.createBoolCopy(op.Dest, left <= right), true
+	case ir.OpGt:
+		return c.createBoolCopy(op.Dest, left > right), true
+	case ir.OpGe:
+		return c.createBoolCopy(op.Dest, left >= right), true
 	}
+	return nil, false
 }
 
 // foldUnaryOpWithConstants attempts to fold a unary operation using constant map.
@@ -239,17 +314,7 @@ func (c *ConstantFoldingPass) foldUnaryOpWithConstants(op *ir.UnaryOp, constants
 			return nil
 		}
 
-		constValue := &ir.Value{
-			ID:       -1,
-			Type:     types.Int,
-			Kind:     ir.ValueConstant,
-			Constant: result,
-		}
-
-		return &ir.Copy{
-			Dest:  op.Dest,
-			Value: constValue,
-		}
+		return c.createIntCopy(op.Dest, result)
 	}
 
 	// Handle boolean constants
@@ -262,6 +327,27 @@ func (c *ConstantFoldingPass) foldUnaryOpWithConstants(op *ir.UnaryOp, constants
 	return nil
 }
 
+// createIntCopy creates a Copy instruction with an integer constant, wrapped
+// to the width of dest's type.
+func (c *ConstantFoldingPass) createIntCopy(dest *ir.Value, value int64) ir.Instruction {
+	var resultType types.Type = types.Int
+	if t, ok := dest.Type.(*types.IntType); ok {
+		value = t.Wrap(value)
+		resultType = t
+	}
+	constValue := &ir.Value{
+		ID:       -1,
+		Type:     resultType,
+		Kind:     ir.ValueConstant,
+		Constant: value,
+	}
+
+	return &ir.Copy{
+		Dest:  dest,
+		Value: constValue,
+	}
+}
+
 // createBoolCopy creates a Copy instruction with a boolean constant.
 func (c *ConstantFoldingPass) createBoolCopy(dest *ir.Value, value bool) ir.Instruction {
 	constValue := &ir.Value{
@@ -2,6 +2,7 @@ package optimizer
 
 import (
 	"github.com/hassan/compiler/internal/ir"
+	"github.com/hassan/compiler/internal/semantic/types"
 )
 
 // DeadCodeEliminationPass removes unused instructions and unreachable code.
@@ -48,18 +49,23 @@ func (d *DeadCodeEliminationPass) Name() string {
 // 2. Recursively mark all values used by critical instructions
 // 3. Remove unmarked instructions
 // 4. Remove unreachable blocks
-func (d *DeadCodeEliminationPass) Run(fn *ir.Function) error {
+func (d *DeadCodeEliminationPass) Run(fn *ir.Function) (bool, error) {
+	changed := false
 	modified := true
 
+	// Removing code never adds a write, so the escaping variables found up
+	// front stay correct through every round
+	escaping := d.escapingVariables(fn)
+
 	// Keep running until no changes (handles transitive dependencies)
 	for modified {
 		modified = false
 
 		// Pass 1: Mark used values
-		usedValues := d.markUsedValues(fn)
+		usedValues := d.markUsedValues(fn, escaping)
 
 		// Pass 2: Remove unused instructions
-		if d.removeUnusedInstructions(fn, usedValues) {
+		if d.removeUnusedInstructions(fn, usedValues, escaping) {
 			modified = true
 		}
 
@@ -67,9 +73,10 @@ func (d *DeadCodeEliminationPass) Run(fn *ir.Function) error {
 		if d.removeUnreachableBlocks(fn) {
 			modified = true
 		}
+		changed = changed || modified
 	}
 
-	return nil
+	return changed, nil
 }
 
 // markUsedValues identifies all values that are actually used.
@@ -81,17 +88,34 @@ func (d *DeadCodeEliminationPass) Run(fn *ir.Function) error {
 // - Function calls (may have side effects)
 // - Return statements (define function behavior)
 // - Branches/jumps (affect control flow)
-func (d *DeadCodeEliminationPass) markUsedValues(fn *ir.Function) map[*ir.Value]bool {
+// - Writes to variables the function doesn't own (see escapingVariables)
+//
+// DESIGN CHOICE: The definitions of every value are collected in one sweep
+// before marking. markValue used to rescan the whole function for each value
+// it marked, which is quadratic in the function's size; with the map each
+// definition is looked at once per marked result.
+func (d *DeadCodeEliminationPass) markUsedValues(fn *ir.Function, escaping map[*ir.Value]bool) map[*ir.Value]bool {
 	used := make(map[*ir.Value]bool)
 
+	// A variable is assigned by one Copy per assignment, so a value can have
+	// several definitions: all of them are kept, in program order
+	defs := make(map[*ir.Value][]ir.Instruction)
+	for _, block := range fn.Blocks {
+		for _, instr := range block.Instructions {
+			if result := instr.Result(); result != nil {
+				defs[result] = append(defs[result], instr)
+			}
+		}
+	}
+
 	// Process all blocks
 	for _, block := range fn.Blocks {
 		for _, instr := range block.Instructions {
 			// Check if this instruction is critical
-			if d.isCritical(instr) {
+			if d.isCritical(instr, escaping) {
 				// Mark all operands as used
 				for _, operand := range instr.Operands() {
-					d.markValue(operand, used, fn)
+					d.markValue(operand, used, defs)
 				}
 			}
 		}
@@ -100,12 +124,52 @@ func (d *DeadCodeEliminationPass) markUsedValues(fn *ir.Function) map[*ir.Value]
 	return used
 }
 
+// escapingVariables returns the variables fn writes to that outlive it: every
+// ValueVariable that is neither one of fn's locals nor a parameter. Those are
+// module globals, or globals of an imported package, and a write to one is
+// seen by whoever reads it after fn returns.
+//
+// DESIGN CHOICE: Decided from what the function owns rather than by looking
+// the value up in Module.Globals. A pass only sees one function, and an
+// imported package's globals aren't in this module's list at all; anything
+// the builder didn't declare in this function is assumed to escape, which
+// errs on the side of keeping code. Parameters are passed by value, so a
+// write to one is as local as a write to a local.
+func (d *DeadCodeEliminationPass) escapingVariables(fn *ir.Function) map[*ir.Value]bool {
+	own := make(map[*ir.Value]bool, len(fn.Locals)+len(fn.Parameters))
+	for _, v := range fn.Locals {
+		own[v] = true
+	}
+	for _, v := range fn.Parameters {
+		own[v] = true
+	}
+
+	escaping := make(map[*ir.Value]bool)
+	for _, block := range fn.Blocks {
+		for _, instr := range block.Instructions {
+			result := instr.Result()
+			if result != nil && result.Kind == ir.ValueVariable && !own[result] {
+				escaping[result] = true
+			}
+		}
+	}
+	return escaping
+}
+
 // isCritical returns true if an instruction has side effects and must be kept.
 //
 // DESIGN CHOICE: Conservative approach - if we're unsure, keep it.
 // Better to keep unnecessary code than break the program.
-func (d *DeadCodeEliminationPass) isCritical(instr ir.Instruction) bool {
-	switch instr.(type) {
+func (d *DeadCodeEliminationPass) isCritical(instr ir.Instruction, escaping map[*ir.Value]bool) bool {
+	switch i := instr.(type) {
+	case *ir.BinaryOp:
+		// An operation that can fail at run time is kept for the program to
+		// fail on, as constant folding leaves it, even when nothing reads
+		// the result
+		if mayTrap(i) {
+			return true
+		}
+		return escaping[i.Dest]
 	case *ir.Store:
 		// Stores modify memory - critical
 		return true
@@ -122,18 +186,55 @@ func (d *DeadCodeEliminationPass) isCritical(instr ir.Instruction) bool {
 		// Jumps affect control flow - critical
 		return true
 	default:
+		// A write to a global is read after the function returns, even
+		// when nothing in the function reads it
+		if result := instr.Result(); result != nil && escaping[result] {
+			return true
+		}
 		// Pure computation - only keep if result is used
 		return false
 	}
 }
 
+// mayTrap reports whether op can be a runtime error: an integer division or
+// remainder by anything but a non-zero constant, or a shift by anything but
+// a non-negative one. Float division follows IEEE 754 and never fails.
+func mayTrap(op *ir.BinaryOp) bool {
+	switch op.Op {
+	case ir.OpDiv, ir.OpMod:
+		if !types.IsIntegerType(op.Right.Type) {
+			return false
+		}
+		n, ok := constantInt(op.Right)
+		return !ok || n == 0
+	case ir.OpShl, ir.OpShr:
+		n, ok := constantInt(op.Right)
+		return !ok || n < 0
+	}
+	return false
+}
+
+// constantInt returns the integer v holds, if v is a constant.
+func constantInt(v *ir.Value) (int64, bool) {
+	if !v.IsConstant() {
+		return 0, false
+	}
+	switch n := v.Constant.(type) {
+	case int64:
+		return n, true
+	case int:
+		return int64(n), true
+	}
+	return 0, false
+}
+
 // markValue recursively marks a value and all values it depends on as used.
 //
 // DESIGN CHOICE: Recursive algorithm because:
 // - Natural way to follow def-use chains
 // - Simple to implement
 // - Depth is bounded by function size
-func (d *DeadCodeEliminationPass) markValue(v *ir.Value, used map[*ir.Value]bool, fn *ir.Function) {
+func (d *DeadCodeEliminationPass) markValue(v *ir.Value, used map[*ir.Value]bool, defs map[*ir.Value][]ir.Instruction) {
 	if v == nil {
 		return
 	}
@@ -151,16 +252,12 @@ func (d *DeadCodeEliminationPass) markValue(v *ir.Value, used map[*ir.Value]bool
 	// Mark this value
 	used[v] = true
 
-	// Find the instruction that defines this value and mark its operands
-	for _, block := range fn.Blocks {
-		for _, instr := range block.Instructions {
-			if instr.Result() == v {
-				// Mark all operands
-				for _, operand := range instr.Operands() {
-					d.markValue(operand, used, fn)
-				}
-				return
-			}
+	// Mark the operands of every definition. Stopping at the first drops
+	// the operands of later assignments (a loop counter's increment, for
+	// example).
+	for _, instr := range defs[v] {
+		for _, operand := range instr.Operands() {
+			d.markValue(operand, used, defs)
 		}
 	}
 }
@@ -172,7 +269,7 @@ func (d *DeadCodeEliminationPass) markValue(v *ir.Value, used map[*ir.Value]bool
 // - Avoids index shifting bugs
 // - Cleaner code
 // - Performance difference is negligible for typical function sizes
-func (d *DeadCodeEliminationPass) removeUnusedInstructions(fn *ir.Function, used map[*ir.Value]bool) bool {
+func (d *DeadCodeEliminationPass) removeUnusedInstructions(fn *ir.Function, used map[*ir.Value]bool, escaping map[*ir.Value]bool) bool {
 	modified := false
 
 	for _, block := range fn.Blocks {
@@ -180,7 +277,7 @@ func (d *DeadCodeEliminationPass) removeUnusedInstructions(fn *ir.Function, used
 
 		for _, instr := range block.Instructions {
 			// Keep critical instructions
-			if d.isCritical(instr) {
+			if d.isCritical(instr, escaping) {
 				newInstructions = append(newInstructions, instr)
 				continue
 			}
@@ -2,6 +2,8 @@ package optimizer
 
 import (
 	"fmt"
+	"io"
+	"strings"
 
 	"github.com/hassan/compiler/internal/ir"
 )
@@ -36,12 +38,51 @@ type Pass interface {
 	Name() string
 
 	// Run executes this optimization pass on the given function
-	// Returns an error if the pass fails
-	Run(fn *ir.Function) error
+	// Returns whether the pass changed the function, and an error if the
+	// pass fails
+	//
+	// DESIGN CHOICE: Passes report changes themselves because only the pass
+	// knows. Comparing instruction counts misses every rewrite in place - a
+	// BinaryOp folded into a Copy is still one instruction - and those are
+	// exactly the changes that enable another round of folding.
+	Run(fn *ir.Function) (changed bool, err error)
 }
 
+// StatsReporter is implemented by passes that can say what they changed.
+//
+// DESIGN CHOICE: An optional interface rather than part of Pass, so a pass
+// with nothing to count beyond removals doesn't have to. The optimizer
+// measures what any pass removes by counting instructions and blocks before
+// and after it; only rewrites in place (a BinaryOp folded into a Copy) are
+// invisible to counting, and those are what a reporter adds.
+type StatsReporter interface {
+	// RunWithStats does what Run does and reports the in-place rewrites.
+	// The optimizer fills in InstructionsRemoved and BlocksRemoved, and
+	// takes any nonzero count as a change.
+	RunWithStats(fn *ir.Function) (PassStats, error)
+}
+
+// Optimization levels, as selected by the -O flag.
+const (
+	// O0 runs no passes: the IR is exactly what the builder produced
+	O0 = 0
+
+	// O1 runs the cheap passes that almost always pay off
+	O1 = 1
+
+	// O2 adds the passes that look for redundant computations within a
+	// block: algebraic simplification and value numbering
+	O2 = 2
+)
+
 // Optimizer coordinates the execution of optimization passes.
 //
+// OPTIMIZATION LEVELS (see SetLevel):
+//   - O0: no passes
+//   - O1: constant folding, dead code elimination
+//   - O2: constant folding, algebraic simplification, value numbering
+//     (reusing a computation repeated within a block), dead code elimination
+//
 // DESIGN CHOICE: Separate optimizer from passes because:
 // - Optimizer manages pass ordering and iteration
 // - Passes focus on their specific transformation
@@ -50,12 +91,24 @@ type Optimizer struct {
 	// passes is the list of optimization passes to run
 	passes []Pass
 
+	// level is the optimization level the pass list was configured for
+	level int
+
 	// maxIterations limits how many times we run all passes
 	// This prevents infinite loops in case passes keep modifying IR
 	maxIterations int
 
-	// verbose enables detailed logging
-	verbose bool
+	// trace receives the verbose log of each pass and iteration, or is nil
+	trace io.Writer
+
+	// stats accumulates what every Optimize and OptimizeFunction call did
+	stats *OptimizationStats
+
+	// manager creates the passes SetLevel and SetPasses name
+	manager *PassManager
+
+	// disabled holds the names DisablePass turned off
+	disabled map[string]bool
 }
 
 // NewOptimizer creates a new optimizer with default passes.
@@ -75,14 +128,108 @@ type Optimizer struct {
 // 3. Dependency-based scheduling: Complex to implement
 // 4. Fixed-point iteration (chosen): Good balance of simplicity and effectiveness
 func NewOptimizer() *Optimizer {
-	return &Optimizer{
-		passes: []Pass{
-			&ConstantFoldingPass{},
-			&DeadCodeEliminationPass{},
-		},
+	o := &Optimizer{
 		maxIterations: 10, // Reasonable default
-		verbose:       false,
+		stats:         NewOptimizationStats(),
+		manager:       DefaultPassManager(),
+		disabled:      make(map[string]bool),
+	}
+	o.SetLevel(O1)
+	return o
+}
+
+// SetLevel configures the pass list for an optimization level, using the
+// level's preset in the pass manager (see DefaultPassManager). Levels below
+// O0 are treated as O0 and levels above O2 as O2.
+//
+// SetLevel replaces the whole pass list, including passes added with
+// AddPass, so call it first. Passes turned off with DisablePass stay off.
+func (o *Optimizer) SetLevel(level int) {
+	if level < O0 {
+		level = O0
+	}
+	if level > O2 {
+		level = O2
+	}
+	o.level = level
+
+	// Presets are checked when they are set, so this can't fail
+	o.passes, _ = o.create(o.manager.Preset(level))
+}
+
+// SetPasses replaces the pass list with the named passes, in the order
+// given. It returns an error, and changes nothing, if a name isn't a
+// registered pass. Passes turned off with DisablePass are left out.
+func (o *Optimizer) SetPasses(names []string) error {
+	passes, err := o.create(names)
+	if err != nil {
+		return err
 	}
+	o.passes = passes
+	return nil
+}
+
+// DisablePass removes a pass from the pass list and keeps it out of lists
+// set later with SetLevel or SetPasses. The name must be a registered pass
+// or one added with AddPass.
+//
+// DESIGN CHOICE: An unknown name is an error rather than a no-op. Disabling
+// "DeadCodeElimintion" would otherwise silently leave the pass running.
+func (o *Optimizer) DisablePass(name string) error {
+	if err := o.manager.Check(name); err != nil && !o.hasPass(name) {
+		return err
+	}
+	o.disabled[name] = true
+
+	kept := o.passes[:0]
+	for _, pass := range o.passes {
+		if pass.Name() != name {
+			kept = append(kept, pass)
+		}
+	}
+	o.passes = kept
+	return nil
+}
+
+// PassNames returns the names of the passes that will run, in order.
+func (o *Optimizer) PassNames() []string {
+	names := make([]string, 0, len(o.passes))
+	for _, pass := range o.passes {
+		names = append(names, pass.Name())
+	}
+	return names
+}
+
+// PassManager returns the registry SetLevel and SetPasses create passes
+// from. Passes registered with it can then be named in SetPasses.
+func (o *Optimizer) PassManager() *PassManager {
+	return o.manager
+}
+
+// create makes the named passes, leaving out disabled ones.
+func (o *Optimizer) create(names []string) ([]Pass, error) {
+	var enabled []string
+	for _, name := range names {
+		if !o.disabled[name] {
+			enabled = append(enabled, name)
+		}
+	}
+	return o.manager.Passes(enabled)
+}
+
+// hasPass reports whether a pass with the given name is in the pass list.
+func (o *Optimizer) hasPass(name string) bool {
+	for _, pass := range o.passes {
+		if pass.Name() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Level returns the optimization level set by SetLevel.
+func (o *Optimizer) Level() int {
+	return o.level
 }
 
 // AddPass adds a custom optimization pass.
@@ -95,9 +242,21 @@ func (o *Optimizer) AddPass(pass Pass) {
 	o.passes = append(o.passes, pass)
 }
 
-// SetVerbose enables or disables verbose logging.
-func (o *Optimizer) SetVerbose(verbose bool) {
-	o.verbose = verbose
+// SetVerbose logs to w, as each function is optimized, the passes that run
+// and what each changed, and every iteration's instruction count before and
+// after. A nil w turns the log off.
+//
+// DESIGN CHOICE: A writer rather than a flag printing to stdout. The
+// compiler's stdout may be the IR or tokens it was asked to emit, which the
+// log must not end up in; the driver passes stderr.
+func (o *Optimizer) SetVerbose(w io.Writer) {
+	o.trace = w
+}
+
+// Stats returns what the optimizer has done so far, totalled over every
+// function it optimized and broken down by function and by pass.
+func (o *Optimizer) Stats() *OptimizationStats {
+	return o.stats
 }
 
 // SetMaxIterations sets the maximum number of optimization iterations.
@@ -151,29 +310,79 @@ func (o *Optimizer) Optimize(module *ir.Module) error {
 // In practice, most functions reach a fixed point in 2-3 iterations.
 // The max iterations guard is just for pathological cases.
 func (o *Optimizer) OptimizeFunction(fn *ir.Function) error {
-	// SIMPLIFIED APPROACH: Run each pass once in sequence
-	// This avoids issues with fixed-point detection and infinite loops
-	// Most optimization opportunities are found in a single pass through all optimizations
+	fnStats := &OptimizationStats{Function: fn.Name, InstructionsBefore: o.countInstructions(fn)}
+	if o.trace != nil {
+		fmt.Fprintf(o.trace, "Optimizing %s:\n", fn.Name)
+	}
+	defer func() {
+		fnStats.InstructionsAfter = o.countInstructions(fn)
+		o.stats.addFunction(fnStats)
+	}()
+
+	for iteration := 1; iteration <= o.maxIterations; iteration++ {
+		changed, err := o.runPasses(fn, iteration, fnStats)
+		if err != nil {
+			return err
+		}
+		if !changed {
+			if o.trace != nil {
+				fmt.Fprintf(o.trace, "  Fixed point reached after %d iterations\n", iteration)
+			}
+			return nil
+		}
+	}
+
+	// Not an error: the IR is valid after every iteration, just not
+	// necessarily as small as it could be
+	if o.trace != nil {
+		fmt.Fprintf(o.trace, "  Stopped after %d iterations without reaching a fixed point\n", o.maxIterations)
+	}
+	return nil
+}
+
+// runPasses runs every pass over fn once, recording what each one changed in
+// fnStats, and reports whether any pass changed fn. iteration numbers the run
+// in verbose output.
+func (o *Optimizer) runPasses(fn *ir.Function, iteration int, fnStats *OptimizationStats) (bool, error) {
+	start := o.countInstructions(fn)
+	anyChanged := false
 	for _, pass := range o.passes {
-		if o.verbose {
-			fmt.Printf("  Running %s...\n", pass.Name())
+		if o.trace != nil {
+			fmt.Fprintf(o.trace, "  Running %s...\n", pass.Name())
+		}
+
+		instructions, blocks := o.countInstructions(fn), len(fn.Blocks)
+		var delta PassStats
+		var changed bool
+		var err error
+		if reporter, ok := pass.(StatsReporter); ok {
+			delta, err = reporter.RunWithStats(fn)
+		} else {
+			changed, err = pass.Run(fn)
+		}
+		if err != nil {
+			return false, fmt.Errorf("pass %s failed: %w", pass.Name(), err)
 		}
+		delta.InstructionsRemoved = instructions - o.countInstructions(fn)
+		delta.BlocksRemoved = blocks - len(fn.Blocks)
+		fnStats.addPass(pass.Name(), delta)
+		anyChanged = anyChanged || changed || delta != (PassStats{})
 
-		if err := pass.Run(fn); err != nil {
-			return fmt.Errorf("pass %s failed: %w", pass.Name(), err)
+		if o.trace != nil && delta != (PassStats{}) {
+			fmt.Fprintf(o.trace, "    %s\n", delta)
 		}
 	}
 
-	return nil
+	if o.trace != nil {
+		fmt.Fprintf(o.trace, "  Iteration %d: %d -> %d instructions\n", iteration, start, o.countInstructions(fn))
+	}
+	return anyChanged, nil
 }
 
 // countInstructions counts the total number of instructions in a function.
 //
-// DESIGN CHOICE: Use instruction count as a simple proxy for "did anything change".
-//
-// LIMITATION: This doesn't catch all changes (e.g., instruction reordering).
-// A more robust approach would be to compute a hash of the IR.
-// However, for our purposes, instruction count is sufficient and fast.
+// It measures what passes removed for the stats. It says nothing about
+// whether a pass changed anything - passes report that themselves.
 func (o *Optimizer) countInstructions(fn *ir.Function) int {
 	count := 0
 	for _, block := range fn.Blocks {
@@ -182,40 +391,164 @@ func (o *Optimizer) countInstructions(fn *ir.Function) int {
 	return count
 }
 
+// PassStats counts the changes optimization made.
+type PassStats struct {
+	// InstructionsRemoved is the number of instructions eliminated
+	InstructionsRemoved int
+
+	// BlocksRemoved is the number of basic blocks eliminated
+	BlocksRemoved int
+
+	// ConstantsFolded is the number of constant expressions folded
+	ConstantsFolded int
+
+	// ExpressionsReused is the number of computations replaced by a copy of
+	// an earlier identical one
+	ExpressionsReused int
+
+	// ExpressionsSimplified is the number of operations rewritten by an
+	// algebraic identity ("x * 1" to "x", "x * 8" to "x << 3")
+	ExpressionsSimplified int
+}
+
+func (s *PassStats) add(other PassStats) {
+	s.InstructionsRemoved += other.InstructionsRemoved
+	s.BlocksRemoved += other.BlocksRemoved
+	s.ConstantsFolded += other.ConstantsFolded
+	s.ExpressionsReused += other.ExpressionsReused
+	s.ExpressionsSimplified += other.ExpressionsSimplified
+}
+
+// String lists the nonzero counts: "2 constants folded, 1 instruction
+// removed", or "no changes".
+func (s PassStats) String() string {
+	var parts []string
+	count := func(n int, singular, plural string) {
+		if n == 1 {
+			parts = append(parts, "1 "+singular)
+		} else if n != 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", n, plural))
+		}
+	}
+	count(s.ConstantsFolded, "constant folded", "constants folded")
+	count(s.ExpressionsSimplified, "expression simplified", "expressions simplified")
+	count(s.ExpressionsReused, "expression reused", "expressions reused")
+	count(s.InstructionsRemoved, "instruction removed", "instructions removed")
+	count(s.BlocksRemoved, "block removed", "blocks removed")
+	if len(parts) == 0 {
+		return "no changes"
+	}
+	return strings.Join(parts, ", ")
+}
+
 // OptimizationStats tracks statistics about optimization.
 //
+// The same type describes one function and a whole module. The module's
+// stats are the totals over its functions, which it also lists one by one.
+//
 // DESIGN CHOICE: Collect stats for analysis and tuning because:
 // - Helps understand optimization effectiveness
 // - Identifies which passes are most valuable
 // - Useful for benchmarking and regression testing
 type OptimizationStats struct {
-	// InstructionsRemoved is the number of instructions eliminated
-	InstructionsRemoved int
+	// PassStats holds the totals over every pass
+	PassStats
 
-	// BlocksRemoved is the number of basic blocks eliminated
-	BlocksRemoved int
+	// Function is the name of the function described, or "" for a module
+	Function string
 
-	// ConstantsFolded is the number of constant expressions folded
-	ConstantsFolded int
+	// InstructionsBefore and InstructionsAfter count the instructions
+	// before the first pass and after the last
+	InstructionsBefore int
+	InstructionsAfter  int
 
 	// PassExecutions tracks how many times each pass ran
 	PassExecutions map[string]int
+
+	// Passes holds what each pass changed, by pass name
+	Passes map[string]*PassStats
+
+	// Functions are the stats of each function, in the order they were
+	// optimized. Empty in the stats of a single function.
+	Functions []*OptimizationStats
+
+	// passOrder lists the keys of Passes in the order the passes first ran
+	passOrder []string
 }
 
 // NewOptimizationStats creates a new stats tracker.
 func NewOptimizationStats() *OptimizationStats {
 	return &OptimizationStats{
 		PassExecutions: make(map[string]int),
+		Passes:         make(map[string]*PassStats),
+	}
+}
+
+// addPass records one run of a pass.
+func (s *OptimizationStats) addPass(name string, delta PassStats) {
+	if s.PassExecutions == nil {
+		s.PassExecutions = make(map[string]int)
+		s.Passes = make(map[string]*PassStats)
+	}
+	if _, ok := s.Passes[name]; !ok {
+		s.Passes[name] = &PassStats{}
+		s.passOrder = append(s.passOrder, name)
+	}
+	s.PassExecutions[name]++
+	s.Passes[name].add(delta)
+	s.PassStats.add(delta)
+}
+
+// addFunction adds a function's stats to the module totals.
+func (s *OptimizationStats) addFunction(fnStats *OptimizationStats) {
+	s.Functions = append(s.Functions, fnStats)
+	s.InstructionsBefore += fnStats.InstructionsBefore
+	s.InstructionsAfter += fnStats.InstructionsAfter
+	for _, name := range fnStats.passOrder {
+		delta := *fnStats.Passes[name]
+		if _, ok := s.Passes[name]; !ok {
+			s.Passes[name] = &PassStats{}
+			s.passOrder = append(s.passOrder, name)
+		}
+		s.PassExecutions[name] += fnStats.PassExecutions[name]
+		s.Passes[name].add(delta)
+		s.PassStats.add(delta)
 	}
 }
 
 // String returns a human-readable summary of optimization statistics.
 func (s *OptimizationStats) String() string {
-	return fmt.Sprintf("Optimization Stats:\n"+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("Optimization Stats:\n"+
+		"  Instructions: %d before, %d after\n"+
 		"  Instructions removed: %d\n"+
 		"  Blocks removed: %d\n"+
-		"  Constants folded: %d\n",
+		"  Constants folded: %d\n"+
+		"  Expressions simplified: %d\n"+
+		"  Expressions reused: %d\n",
+		s.InstructionsBefore,
+		s.InstructionsAfter,
 		s.InstructionsRemoved,
 		s.BlocksRemoved,
-		s.ConstantsFolded)
+		s.ConstantsFolded,
+		s.ExpressionsSimplified,
+		s.ExpressionsReused))
+
+	if len(s.passOrder) > 0 {
+		sb.WriteString("  Passes:\n")
+		for _, name := range s.passOrder {
+			runs := "runs"
+			if s.PassExecutions[name] == 1 {
+				runs = "run"
+			}
+			sb.WriteString(fmt.Sprintf("    %s (%d %s): %s\n", name, s.PassExecutions[name], runs, *s.Passes[name]))
+		}
+	}
+	if len(s.Functions) > 0 {
+		sb.WriteString("  Functions:\n")
+		for _, fn := range s.Functions {
+			sb.WriteString(fmt.Sprintf("    %s: %d -> %d instructions\n", fn.Function, fn.InstructionsBefore, fn.InstructionsAfter))
+		}
+	}
+	return sb.String()
 }
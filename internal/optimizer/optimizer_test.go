@@ -1,6 +1,8 @@
 package optimizer
 
 import (
+	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/hassan/compiler/internal/ir"
@@ -30,7 +32,7 @@ func TestConstantFolding(t *testing.T) {
 				}
 
 				// t1 = 2 + 3
-				dest := &ir.Value{ID: 1, Type: types.Int}
+				dest := &ir.Value{ID: 1, Type: types.Int, Kind: ir.ValueTemporary}
 				left := &ir.Value{ID: -1, Type: types.Int, Kind: ir.ValueConstant, Constant: int64(2)}
 				right := &ir.Value{ID: -1, Type: types.Int, Kind: ir.ValueConstant, Constant: int64(3)}
 
@@ -85,7 +87,7 @@ func TestConstantFolding(t *testing.T) {
 				}
 
 				// t1 = 7 * 8
-				dest := &ir.Value{ID: 1, Type: types.Int}
+				dest := &ir.Value{ID: 1, Type: types.Int, Kind: ir.ValueTemporary}
 				left := &ir.Value{ID: -1, Type: types.Int, Kind: ir.ValueConstant, Constant: int64(7)}
 				right := &ir.Value{ID: -1, Type: types.Int, Kind: ir.ValueConstant, Constant: int64(8)}
 
@@ -130,7 +132,7 @@ func TestConstantFolding(t *testing.T) {
 				}
 
 				// t1 = 5 > 3
-				dest := &ir.Value{ID: 1, Type: types.Bool}
+				dest := &ir.Value{ID: 1, Type: types.Bool, Kind: ir.ValueTemporary}
 				left := &ir.Value{ID: -1, Type: types.Int, Kind: ir.ValueConstant, Constant: int64(5)}
 				right := &ir.Value{ID: -1, Type: types.Int, Kind: ir.ValueConstant, Constant: int64(3)}
 
@@ -159,6 +161,31 @@ func TestConstantFolding(t *testing.T) {
 				}
 			},
 		},
+		{
+			name: "do not propagate a reassigned variable",
+			setup: func() *ir.Function {
+				fn := &ir.Function{Name: "test", ReturnType: types.Int}
+				entry := &ir.BasicBlock{Label: "entry"}
+
+				// x = 0; x = 1; t1 = x + 1
+				x := &ir.Value{ID: 1, Type: types.Int, Kind: ir.ValueVariable, Name: "x"}
+				t1 := &ir.Value{ID: 2, Type: types.Int, Kind: ir.ValueTemporary}
+				entry.Instructions = []ir.Instruction{
+					&ir.Copy{Dest: x, Value: &ir.Value{ID: -1, Type: types.Int, Kind: ir.ValueConstant, Constant: int64(0)}},
+					&ir.Copy{Dest: x, Value: &ir.Value{ID: -1, Type: types.Int, Kind: ir.ValueConstant, Constant: int64(1)}},
+					&ir.BinaryOp{Op: ir.OpAdd, Dest: t1, Left: x, Right: &ir.Value{ID: -1, Type: types.Int, Kind: ir.ValueConstant, Constant: int64(1)}},
+				}
+				fn.Blocks = []*ir.BasicBlock{entry}
+				fn.Entry = entry
+				return fn
+			},
+			validate: func(t *testing.T, fn *ir.Function) {
+				// x has two definitions, so x + 1 is not a constant
+				if _, ok := fn.Blocks[0].Instructions[2].(*ir.BinaryOp); !ok {
+					t.Errorf("expected x + 1 to stay a BinaryOp, got %s", fn.Blocks[0].Instructions[2])
+				}
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -166,7 +193,7 @@ func TestConstantFolding(t *testing.T) {
 			fn := tt.setup()
 			pass := &ConstantFoldingPass{}
 
-			if err := pass.Run(fn); err != nil {
+			if _, err := pass.Run(fn); err != nil {
 				t.Fatalf("constant folding failed: %v", err)
 			}
 
@@ -175,6 +202,120 @@ func TestConstantFolding(t *testing.T) {
 	}
 }
 
+// TestConstantFolding_IntegerSemantics tests that folding computes what the
+// program would at run time, and leaves the operations that fail for it
+func TestConstantFolding_IntegerSemantics(t *testing.T) {
+	const maxInt, minInt = int64(9223372036854775807), int64(-9223372036854775808)
+
+	tests := []struct {
+		name        string
+		op          ir.BinaryOperator
+		left, right int64
+		want        string // "" when the operation must not be folded
+	}{
+		{"addition wraps", ir.OpAdd, maxInt, 1, "const(-9223372036854775808)"},
+		{"subtraction wraps", ir.OpSub, minInt, 1, "const(9223372036854775807)"},
+		{"multiplication wraps", ir.OpMul, maxInt, 2, "const(-2)"},
+		{"MinInt / -1 wraps", ir.OpDiv, minInt, -1, "const(-9223372036854775808)"},
+		{"MinInt % -1", ir.OpMod, minInt, -1, "const(0)"},
+		{"shift left by 64", ir.OpShl, 1, 64, "const(0)"},
+		{"shift right by 64", ir.OpShr, -8, 64, "const(-1)"},
+		{"shift right by 63", ir.OpShr, maxInt, 63, "const(0)"},
+		{"division by zero", ir.OpDiv, 1, 0, ""},
+		{"modulo by zero", ir.OpMod, 1, 0, ""},
+		{"negative shift left", ir.OpShl, 1, -1, ""},
+		{"negative shift right", ir.OpShr, 1, -1, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dest := &ir.Value{ID: 1, Type: types.Int, Kind: ir.ValueTemporary}
+			op := &ir.BinaryOp{Op: tt.op, Dest: dest, Left: constInt(tt.left), Right: constInt(tt.right)}
+			entry := &ir.BasicBlock{Label: "entry", Instructions: []ir.Instruction{op}}
+			fn := &ir.Function{Name: "test", ReturnType: types.Int, Blocks: []*ir.BasicBlock{entry}, Entry: entry}
+
+			pass := &ConstantFoldingPass{}
+			if _, err := pass.Run(fn); err != nil {
+				t.Fatalf("constant folding failed: %v", err)
+			}
+
+			got := entry.Instructions[0]
+			if tt.want == "" {
+				if got != op {
+					t.Errorf("expected the operation left for run time, got %s", got)
+				}
+				return
+			}
+			if want := "t1 = " + tt.want; got.String() != want {
+				t.Errorf("expected %s, got %s", want, got)
+			}
+		})
+	}
+
+	// Negating MinInt wraps back to MinInt
+	dest := &ir.Value{ID: 1, Type: types.Int, Kind: ir.ValueTemporary}
+	entry := &ir.BasicBlock{Label: "entry", Instructions: []ir.Instruction{
+		&ir.UnaryOp{Op: ir.OpNeg, Dest: dest, Operand: constInt(minInt)},
+	}}
+	fn := &ir.Function{Name: "test", ReturnType: types.Int, Blocks: []*ir.BasicBlock{entry}, Entry: entry}
+	if _, err := (&ConstantFoldingPass{}).Run(fn); err != nil {
+		t.Fatalf("constant folding failed: %v", err)
+	}
+	if got := entry.Instructions[0].String(); got != "t1 = const(-9223372036854775808)" {
+		t.Errorf("expected -MinInt to fold to MinInt, got %s", got)
+	}
+}
+
+// TestConstantFolding_IntegerWidths tests that folding wraps to the result's
+// width, and treats uint64 operands as unsigned
+func TestConstantFolding_IntegerWidths(t *testing.T) {
+	tests := []struct {
+		name        string
+		op          ir.BinaryOperator
+		typ         *types.IntType
+		left, right int64
+		want        string
+	}{
+		{"int8 addition wraps", ir.OpAdd, types.Int8, 127, 1, "t1 = const(-128)"},
+		{"uint8 multiplication wraps", ir.OpMul, types.Uint8, 20, 15, "t1 = const(44)"},
+		{"uint64 division", ir.OpDiv, types.Uint64, -1, 2, "t1 = const(9223372036854775807)"},
+		{"uint64 modulo", ir.OpMod, types.Uint64, -1, 10, "t1 = const(5)"},
+		{"uint64 shift right", ir.OpShr, types.Uint64, -8, 60, "t1 = const(15)"},
+		{"uint64 comparison", ir.OpGt, types.Uint64, -1, 1, "t1 = const(true)"},
+		{"uint64 division by zero", ir.OpDiv, types.Uint64, 1, 0, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var destType types.Type = tt.typ
+			if tt.op == ir.OpGt {
+				destType = types.Bool
+			}
+			operand := func(n int64) *ir.Value {
+				return &ir.Value{ID: -1, Type: tt.typ, Kind: ir.ValueConstant, Constant: n}
+			}
+			dest := &ir.Value{ID: 1, Type: destType, Kind: ir.ValueTemporary}
+			op := &ir.BinaryOp{Op: tt.op, Dest: dest, Left: operand(tt.left), Right: operand(tt.right)}
+			entry := &ir.BasicBlock{Label: "entry", Instructions: []ir.Instruction{op}}
+			fn := &ir.Function{Name: "test", ReturnType: destType, Blocks: []*ir.BasicBlock{entry}, Entry: entry}
+
+			if _, err := (&ConstantFoldingPass{}).Run(fn); err != nil {
+				t.Fatalf("constant folding failed: %v", err)
+			}
+			got := entry.Instructions[0]
+			if tt.want == "" {
+				if got != op {
+					t.Errorf("expected the operation left for run time, got %s", got)
+				}
+				return
+			}
+			if got.String() != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
 // TestDeadCodeElimination tests the dead code elimination pass
 func TestDeadCodeElimination(t *testing.T) {
 	tests := []struct {
@@ -198,7 +339,7 @@ func TestDeadCodeElimination(t *testing.T) {
 				}
 
 				// t1 = 2 + 3 (unused)
-				t1 := &ir.Value{ID: 1, Type: types.Int}
+				t1 := &ir.Value{ID: 1, Type: types.Int, Kind: ir.ValueTemporary}
 				binop := &ir.BinaryOp{
 					Op:    ir.OpAdd,
 					Dest:  t1,
@@ -244,7 +385,7 @@ func TestDeadCodeElimination(t *testing.T) {
 				}
 
 				// t1 = 2 + 3
-				t1 := &ir.Value{ID: 1, Type: types.Int}
+				t1 := &ir.Value{ID: 1, Type: types.Int, Kind: ir.ValueTemporary}
 				binop := &ir.BinaryOp{
 					Op:    ir.OpAdd,
 					Dest:  t1,
@@ -268,6 +409,83 @@ func TestDeadCodeElimination(t *testing.T) {
 				}
 			},
 		},
+		{
+			name: "keep operands of every assignment",
+			setup: func() *ir.Function {
+				fn := &ir.Function{Name: "test", ReturnType: types.Int}
+				entry := &ir.BasicBlock{Label: "entry"}
+
+				// x = 0; t1 = x + 1; x = t1; return x
+				x := &ir.Value{ID: 1, Type: types.Int, Kind: ir.ValueVariable, Name: "x"}
+				fn.Locals = []*ir.Value{x}
+				t1 := &ir.Value{ID: 2, Type: types.Int, Kind: ir.ValueTemporary}
+				entry.Instructions = []ir.Instruction{
+					&ir.Copy{Dest: x, Value: &ir.Value{ID: -1, Type: types.Int, Kind: ir.ValueConstant, Constant: int64(0)}},
+					&ir.BinaryOp{Op: ir.OpAdd, Dest: t1, Left: x, Right: &ir.Value{ID: -1, Type: types.Int, Kind: ir.ValueConstant, Constant: int64(1)}},
+					&ir.Copy{Dest: x, Value: t1},
+					&ir.Return{Value: x},
+				}
+				fn.Blocks = []*ir.BasicBlock{entry}
+				fn.Entry = entry
+				return fn
+			},
+			validate: func(t *testing.T, fn *ir.Function) {
+				// The second assignment to x needs t1
+				if len(fn.Blocks[0].Instructions) != 4 {
+					t.Errorf("expected 4 instructions, got %d", len(fn.Blocks[0].Instructions))
+				}
+			},
+		},
+		{
+			name: "keep a write to a global in a void function",
+			setup: func() *ir.Function {
+				fn := &ir.Function{Name: "set", ReturnType: types.Void}
+				entry := &ir.BasicBlock{Label: "entry"}
+
+				// total = t1 + 1; return, with total a module global
+				total := &ir.Value{ID: 0, Type: types.Int, Kind: ir.ValueVariable, Name: "total"}
+				t1 := &ir.Value{ID: 1, Type: types.Int, Kind: ir.ValueTemporary}
+				n := &ir.Value{ID: 0, Type: types.Int, Kind: ir.ValueParameter, Name: "n"}
+				fn.Parameters = []*ir.Value{n}
+				entry.Instructions = []ir.Instruction{
+					&ir.BinaryOp{Op: ir.OpAdd, Dest: t1, Left: n, Right: &ir.Value{ID: -1, Type: types.Int, Kind: ir.ValueConstant, Constant: int64(1)}},
+					&ir.Copy{Dest: total, Value: t1},
+					&ir.Return{},
+				}
+				fn.Blocks = []*ir.BasicBlock{entry}
+				fn.Entry = entry
+				return fn
+			},
+			validate: func(t *testing.T, fn *ir.Function) {
+				// Nothing in the function reads total, but its caller does
+				if len(fn.Blocks[0].Instructions) != 3 {
+					t.Errorf("expected the Copy to total and its operand to stay, got %d instructions", len(fn.Blocks[0].Instructions))
+				}
+			},
+		},
+		{
+			name: "remove an unread write to a local",
+			setup: func() *ir.Function {
+				fn := &ir.Function{Name: "test", ReturnType: types.Void}
+				entry := &ir.BasicBlock{Label: "entry"}
+
+				// x = 1; return
+				x := &ir.Value{ID: 1, Type: types.Int, Kind: ir.ValueVariable, Name: "x"}
+				fn.Locals = []*ir.Value{x}
+				entry.Instructions = []ir.Instruction{
+					&ir.Copy{Dest: x, Value: &ir.Value{ID: -1, Type: types.Int, Kind: ir.ValueConstant, Constant: int64(1)}},
+					&ir.Return{},
+				}
+				fn.Blocks = []*ir.BasicBlock{entry}
+				fn.Entry = entry
+				return fn
+			},
+			validate: func(t *testing.T, fn *ir.Function) {
+				if len(fn.Blocks[0].Instructions) != 1 {
+					t.Errorf("expected only the return to stay, got %d instructions", len(fn.Blocks[0].Instructions))
+				}
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -275,7 +493,7 @@ func TestDeadCodeElimination(t *testing.T) {
 			fn := tt.setup()
 			pass := &DeadCodeEliminationPass{}
 
-			if err := pass.Run(fn); err != nil {
+			if _, err := pass.Run(fn); err != nil {
 				t.Fatalf("dead code elimination failed: %v", err)
 			}
 
@@ -284,6 +502,53 @@ func TestDeadCodeElimination(t *testing.T) {
 	}
 }
 
+// TestDeadCodeElimination_KeepsTraps checks that an unused operation that
+// may fail at run time stays, for the program to fail on, and one that
+// can't fail goes.
+func TestDeadCodeElimination_KeepsTraps(t *testing.T) {
+	n := &ir.Value{ID: 0, Name: "n", Type: types.Int, Kind: ir.ValueParameter}
+	x := &ir.Value{ID: 0, Name: "x", Type: types.Float, Kind: ir.ValueParameter}
+	float := &ir.Value{ID: -1, Type: types.Float, Kind: ir.ValueConstant, Constant: 0.0}
+	tests := []struct {
+		name        string
+		op          ir.BinaryOperator
+		left, right *ir.Value
+		kept        bool
+	}{
+		{"division by a variable", ir.OpDiv, constInt(5), n, true},
+		{"remainder by a variable", ir.OpMod, constInt(5), n, true},
+		{"division by zero", ir.OpDiv, n, constInt(0), true},
+		{"remainder by zero", ir.OpMod, n, constInt(0), true},
+		{"division by a constant", ir.OpDiv, n, constInt(3), false},
+		{"remainder by a constant", ir.OpMod, n, constInt(-2), false},
+		{"shift by a variable", ir.OpShl, constInt(1), n, true},
+		{"shift by a negative constant", ir.OpShr, n, constInt(-1), true},
+		{"shift by a constant", ir.OpShl, n, constInt(70), false},
+		{"float division by zero", ir.OpDiv, x, float, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var params []*ir.Value
+			for _, v := range []*ir.Value{tt.left, tt.right} {
+				if v.Kind == ir.ValueParameter {
+					params = append(params, v)
+				}
+			}
+			fn := ir.NewFunction("test", params, types.Int)
+			fn.Entry.AddInstruction(&ir.BinaryOp{Op: tt.op, Dest: fn.NewTemp(tt.left.Type), Left: tt.left, Right: tt.right})
+			fn.Entry.AddInstruction(&ir.Return{Value: constInt(3)})
+
+			if _, err := (&DeadCodeEliminationPass{}).Run(fn); err != nil {
+				t.Fatalf("dead code elimination failed: %v", err)
+			}
+			if kept := len(fn.Entry.Instructions) == 2; kept != tt.kept {
+				t.Errorf("expected kept = %v, got\n%s", tt.kept, fn)
+			}
+		})
+	}
+}
+
 // TestOptimizerIntegration tests the full optimizer with multiple passes
 func TestOptimizerIntegration(t *testing.T) {
 	// Create a function with constant folding opportunity and dead code
@@ -300,7 +565,7 @@ func TestOptimizerIntegration(t *testing.T) {
 	}
 
 	// t1 = 2 + 3 (will fold to 5, then be marked dead)
-	t1 := &ir.Value{ID: 1, Type: types.Int}
+	t1 := &ir.Value{ID: 1, Type: types.Int, Kind: ir.ValueTemporary}
 	binop1 := &ir.BinaryOp{
 		Op:    ir.OpAdd,
 		Dest:  t1,
@@ -309,7 +574,7 @@ func TestOptimizerIntegration(t *testing.T) {
 	}
 
 	// t2 = 4 * 5 (will fold to 20)
-	t2 := &ir.Value{ID: 2, Type: types.Int}
+	t2 := &ir.Value{ID: 2, Type: types.Int, Kind: ir.ValueTemporary}
 	binop2 := &ir.BinaryOp{
 		Op:    ir.OpMul,
 		Dest:  t2,
@@ -351,4 +616,582 @@ func TestOptimizerIntegration(t *testing.T) {
 	if _, ok := instructions[1].(*ir.Return); !ok {
 		t.Errorf("expected second instruction to be Return, got %T", instructions[1])
 	}
+
+	// The stats say how that happened. Folding runs before DCE, so both
+	// products fold before t1 is found dead: 2 folded, 1 removed.
+	stats := opt.Stats()
+	if stats.ConstantsFolded != 2 || stats.InstructionsRemoved != 1 || stats.BlocksRemoved != 0 {
+		t.Errorf("expected 2 constants folded and 1 instruction removed, got %+v", stats.PassStats)
+	}
+	if stats.InstructionsBefore != 3 || stats.InstructionsAfter != 2 {
+		t.Errorf("expected 3 -> 2 instructions, got %d -> %d", stats.InstructionsBefore, stats.InstructionsAfter)
+	}
+	if got := *stats.Passes["ConstantFolding"]; got != (PassStats{ConstantsFolded: 2}) {
+		t.Errorf("ConstantFolding: expected 2 folded and nothing removed, got %+v", got)
+	}
+	if got := *stats.Passes["DeadCodeElimination"]; got != (PassStats{InstructionsRemoved: 1}) {
+		t.Errorf("DeadCodeElimination: expected 1 removed, got %+v", got)
+	}
+}
+
+// TestOptimizationStats tests that stats add up per pass, per function and
+// per module
+func TestOptimizationStats(t *testing.T) {
+	// f computes a + b twice; g returns after an unreachable block
+	a := &ir.Value{ID: 0, Name: "a", Type: types.Int, Kind: ir.ValueParameter}
+	b := &ir.Value{ID: 1, Name: "b", Type: types.Int, Kind: ir.ValueParameter}
+	f := ir.NewFunction("f", []*ir.Value{a, b}, types.Int)
+	t1, t2, t3 := f.NewTemp(types.Int), f.NewTemp(types.Int), f.NewTemp(types.Int)
+	f.Entry.AddInstruction(&ir.BinaryOp{Dest: t1, Op: ir.OpAdd, Left: a, Right: b})
+	f.Entry.AddInstruction(&ir.BinaryOp{Dest: t2, Op: ir.OpAdd, Left: a, Right: b})
+	f.Entry.AddInstruction(&ir.BinaryOp{Dest: t3, Op: ir.OpMul, Left: t1, Right: t2})
+	f.Entry.AddInstruction(&ir.Return{Value: t3})
+
+	g := ir.NewFunction("g", nil, types.Int)
+	dead := g.NewBasicBlockInFunc("dead")
+	g.Entry.AddInstruction(&ir.Return{Value: constInt(1)})
+	dead.AddInstruction(&ir.Return{Value: constInt(2)})
+
+	module := ir.NewModule("main")
+	module.AddFunction(f)
+	module.AddFunction(g)
+
+	opt := NewOptimizer()
+	opt.SetLevel(O2)
+	if err := opt.Optimize(module); err != nil {
+		t.Fatalf("optimization failed: %v", err)
+	}
+	stats := opt.Stats()
+
+	if len(stats.Functions) != 2 || stats.Functions[0].Function != "f" || stats.Functions[1].Function != "g" {
+		t.Fatalf("expected stats for f and g in order, got %v", stats.Functions)
+	}
+	fStats, gStats := stats.Functions[0], stats.Functions[1]
+	if fStats.ExpressionsReused != 1 || fStats.InstructionsBefore != 4 || fStats.InstructionsAfter != 4 {
+		t.Errorf("f: expected 1 reuse and 4 -> 4 instructions, got %+v", fStats)
+	}
+	if gStats.BlocksRemoved != 1 || gStats.InstructionsRemoved != 1 {
+		t.Errorf("g: expected 1 block and its instruction removed, got %+v", gStats.PassStats)
+	}
+
+	// Each function takes two iterations: one changes it, one finds nothing
+	// left to do
+	if stats.InstructionsBefore != 6 || stats.InstructionsAfter != 5 || stats.PassExecutions["ValueNumbering"] != 4 {
+		t.Errorf("expected module totals over both functions, got %+v", stats)
+	}
+	if got := *stats.Passes["ValueNumbering"]; got != (PassStats{ExpressionsReused: 1}) {
+		t.Errorf("ValueNumbering: expected 1 reuse, got %+v", got)
+	}
+
+	summary := stats.String()
+	for _, want := range []string{
+		"Instructions: 6 before, 5 after\n",
+		"Blocks removed: 1\n",
+		"    ConstantFolding (4 runs): no changes\n",
+		"    ValueNumbering (4 runs): 1 expression reused\n",
+		"    DeadCodeElimination (4 runs): 1 instruction removed, 1 block removed\n",
+		"    g: 2 -> 1 instructions\n",
+	} {
+		if !strings.Contains(summary, want) {
+			t.Errorf("expected summary to contain %q, got:\n%s", want, summary)
+		}
+	}
+}
+
+// TestOptimizerFixedPoint tests that the optimizer repeats its passes until
+// nothing changes
+func TestOptimizerFixedPoint(t *testing.T) {
+	// a = 1 + 2; b = a + 3; c = b + 4; return c
+	//
+	// Folding sweeps the blocks in layout order, not the order they run in.
+	// With each definition laid out after the block that uses it, a sweep
+	// only folds the level whose operands are already known.
+	chain := func() *ir.Function {
+		fn := ir.NewFunction("chain", nil, types.Int)
+		useB := fn.NewBasicBlockInFunc("use.b")
+		useA := fn.NewBasicBlockInFunc("use.a")
+		defA := fn.NewBasicBlockInFunc("def.a")
+		a, b, c := fn.NewTemp(types.Int), fn.NewTemp(types.Int), fn.NewTemp(types.Int)
+
+		fn.Entry.AddInstruction(&ir.Jump{Target: defA})
+		fn.Entry.AddSuccessor(defA)
+		useB.AddInstruction(&ir.BinaryOp{Dest: c, Op: ir.OpAdd, Left: b, Right: constInt(4)})
+		useB.AddInstruction(&ir.Return{Value: c})
+		useA.AddInstruction(&ir.BinaryOp{Dest: b, Op: ir.OpAdd, Left: a, Right: constInt(3)})
+		useA.AddInstruction(&ir.Jump{Target: useB})
+		useA.AddSuccessor(useB)
+		defA.AddInstruction(&ir.BinaryOp{Dest: a, Op: ir.OpAdd, Left: constInt(1), Right: constInt(2)})
+		defA.AddInstruction(&ir.Jump{Target: useA})
+		defA.AddSuccessor(useA)
+		return fn
+	}
+	// returned is the instruction computing the value chain returns
+	returned := func(fn *ir.Function) ir.Instruction {
+		return fn.Blocks[1].Instructions[0]
+	}
+
+	tests := []struct {
+		name          string
+		maxIterations int
+		folded        bool
+	}{
+		{"one iteration", 1, false},
+		{"two iterations", 2, false},
+		{"until fixed point", 10, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fn := chain()
+			opt := NewOptimizer()
+			opt.SetMaxIterations(tt.maxIterations)
+			if err := opt.OptimizeFunction(fn); err != nil {
+				t.Fatalf("optimization failed: %v", err)
+			}
+
+			copy, ok := returned(fn).(*ir.Copy)
+			if folded := ok && copy.Value.IsConstant(); folded != tt.folded {
+				t.Fatalf("expected c folded = %v, got %s", tt.folded, returned(fn))
+			}
+			if tt.folded && copy.Value.Constant != int64(10) {
+				t.Errorf("expected c = 10, got %s", copy)
+			}
+		})
+	}
+
+	// Three iterations fold one level each, the fourth finds nothing to do
+	opt := NewOptimizer()
+	if err := opt.OptimizeFunction(chain()); err != nil {
+		t.Fatalf("optimization failed: %v", err)
+	}
+	if runs := opt.Stats().PassExecutions["ConstantFolding"]; runs != 4 {
+		t.Errorf("expected 4 iterations, got %d", runs)
+	}
+}
+
+// alwaysChanged claims to change the function every time it runs.
+type alwaysChanged struct{ runs int }
+
+func (p *alwaysChanged) Name() string { return "AlwaysChanged" }
+
+func (p *alwaysChanged) Run(fn *ir.Function) (bool, error) {
+	p.runs++
+	return true, nil
+}
+
+// TestOptimizerMaxIterations tests that a pass that never settles can't
+// loop forever
+func TestOptimizerMaxIterations(t *testing.T) {
+	pass := &alwaysChanged{}
+	opt := NewOptimizer()
+	opt.SetMaxIterations(3)
+	opt.AddPass(pass)
+
+	fn := ir.NewFunction("f", nil, types.Int)
+	fn.Entry.AddInstruction(&ir.Return{Value: constInt(0)})
+	if err := opt.OptimizeFunction(fn); err != nil {
+		t.Fatalf("optimization failed: %v", err)
+	}
+	if pass.runs != 3 {
+		t.Errorf("expected the pass to run 3 times, got %d", pass.runs)
+	}
+}
+
+// constInt returns an int constant operand.
+func constInt(n int64) *ir.Value {
+	return &ir.Value{ID: -1, Type: types.Int, Kind: ir.ValueConstant, Constant: n}
+}
+
+// TestOptimizerLevels tests that each level runs the passes it documents
+func TestOptimizerLevels(t *testing.T) {
+	tests := []struct {
+		level    int
+		passes   []string
+		keepsAdd bool
+	}{
+		{O0, nil, true},
+		{O1, []string{"ConstantFolding", "DeadCodeElimination"}, false},
+		{O2, []string{"ConstantFolding", "AlgebraicSimplification", "ValueNumbering", "DeadCodeElimination"}, false},
+		{-1, nil, true},
+		{9, []string{"ConstantFolding", "AlgebraicSimplification", "ValueNumbering", "DeadCodeElimination"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("O%d", tt.level), func(t *testing.T) {
+			// t1 = 2 + 3 (unused); return 0
+			entry := &ir.BasicBlock{Label: "entry"}
+			entry.Instructions = []ir.Instruction{
+				&ir.BinaryOp{Op: ir.OpAdd, Dest: &ir.Value{ID: 1, Type: types.Int, Kind: ir.ValueTemporary}, Left: constInt(2), Right: constInt(3)},
+				&ir.Return{Value: constInt(0)},
+			}
+			fn := &ir.Function{Name: "test", ReturnType: types.Int, Blocks: []*ir.BasicBlock{entry}, Entry: entry}
+
+			opt := NewOptimizer()
+			opt.SetLevel(tt.level)
+
+			var names []string
+			for _, pass := range opt.passes {
+				names = append(names, pass.Name())
+			}
+			if strings.Join(names, " ") != strings.Join(tt.passes, " ") {
+				t.Errorf("expected passes %v, got %v", tt.passes, names)
+			}
+
+			if err := opt.OptimizeFunction(fn); err != nil {
+				t.Fatalf("optimization failed: %v", err)
+			}
+			_, kept := entry.Instructions[0].(*ir.BinaryOp)
+			if kept != tt.keepsAdd {
+				t.Errorf("expected 2 + 3 kept = %v, got IR:\n%s", tt.keepsAdd, fn)
+			}
+		})
+	}
+
+	if level := NewOptimizer().Level(); level != O1 {
+		t.Errorf("expected the default level to be O1, got O%d", level)
+	}
+}
+
+// unusedAdd builds "t1 = 2 + 3 (unused); return 0".
+func unusedAdd() *ir.Function {
+	fn := ir.NewFunction("test", nil, types.Int)
+	fn.Entry.AddInstruction(&ir.BinaryOp{Op: ir.OpAdd, Dest: fn.NewTemp(types.Int), Left: constInt(2), Right: constInt(3)})
+	fn.Entry.AddInstruction(&ir.Return{Value: constInt(0)})
+	return fn
+}
+
+// TestOptimizerDisablePass tests that a disabled pass doesn't run, whatever
+// the level
+func TestOptimizerDisablePass(t *testing.T) {
+	opt := NewOptimizer()
+	if err := opt.DisablePass("DeadCodeElimination"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	opt.SetLevel(O2)
+	if got := strings.Join(opt.PassNames(), " "); got != "ConstantFolding AlgebraicSimplification ValueNumbering" {
+		t.Errorf("expected DCE left out of O2, got %s", got)
+	}
+
+	fn := unusedAdd()
+	if err := opt.OptimizeFunction(fn); err != nil {
+		t.Fatalf("optimization failed: %v", err)
+	}
+	if len(fn.Entry.Instructions) != 2 {
+		t.Errorf("expected the unused computation to survive, got IR:\n%s", fn)
+	}
+
+	err := opt.DisablePass("DeadCodeElimintion")
+	if err == nil || !strings.Contains(err.Error(), "available: AlgebraicSimplification, ConstantFolding, DeadCodeElimination, ValueNumbering") {
+		t.Errorf("expected an error listing the passes, got %v", err)
+	}
+
+	// A pass added with AddPass can be disabled by name too
+	custom := NewOptimizer()
+	custom.AddPass(&alwaysChanged{})
+	if err := custom.DisablePass("AlwaysChanged"); err != nil {
+		t.Errorf("unexpected error disabling a custom pass: %v", err)
+	}
+	if got := strings.Join(custom.PassNames(), " "); got != "ConstantFolding DeadCodeElimination" {
+		t.Errorf("expected the custom pass removed, got %s", got)
+	}
+}
+
+// TestOptimizerSetPasses tests explicit pass lists
+func TestOptimizerSetPasses(t *testing.T) {
+	opt := NewOptimizer()
+	if err := opt.SetPasses([]string{"DeadCodeElimination", "ConstantFolding"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := strings.Join(opt.PassNames(), " "); got != "DeadCodeElimination ConstantFolding" {
+		t.Errorf("expected the passes in the order given, got %s", got)
+	}
+
+	// An unknown name changes nothing
+	err := opt.SetPasses([]string{"ConstantFolding", "Inlining"})
+	if err == nil || !strings.Contains(err.Error(), `unknown optimization pass "Inlining"`) {
+		t.Errorf("expected an unknown pass error, got %v", err)
+	}
+	if got := strings.Join(opt.PassNames(), " "); got != "DeadCodeElimination ConstantFolding" {
+		t.Errorf("expected the failed call to keep the old list, got %s", got)
+	}
+
+	// Registered passes can be named
+	opt.PassManager().Register(func() Pass { return &alwaysChanged{} })
+	if err := opt.SetPasses([]string{"AlwaysChanged"}); err != nil {
+		t.Errorf("unexpected error naming a registered pass: %v", err)
+	}
+}
+
+// TestPassManager tests the registry and its presets
+func TestPassManager(t *testing.T) {
+	m := DefaultPassManager()
+	if got := strings.Join(m.Available(), " "); got != "AlgebraicSimplification ConstantFolding DeadCodeElimination ValueNumbering" {
+		t.Errorf("unexpected passes: %s", got)
+	}
+	if got := strings.Join(m.Preset(O1), " "); got != "ConstantFolding DeadCodeElimination" {
+		t.Errorf("unexpected O1 preset: %s", got)
+	}
+	if err := m.SetPreset(3, []string{"ConstantFolding", "LoopUnrolling"}); err == nil {
+		t.Error("expected a preset with an unknown pass to be rejected")
+	}
+
+	// Each list gets passes of its own, so state doesn't leak between them
+	m.Register(func() Pass { return &alwaysChanged{} })
+	first, _ := m.Passes([]string{"AlwaysChanged"})
+	second, _ := m.Passes([]string{"AlwaysChanged"})
+	first[0].Run(ir.NewFunction("f", nil, types.Int))
+	if second[0].(*alwaysChanged).runs != 0 {
+		t.Error("expected a new pass for every list")
+	}
+}
+
+// TestValueNumbering tests the value numbering pass
+func TestValueNumbering(t *testing.T) {
+	a := &ir.Value{ID: 1, Type: types.Int, Kind: ir.ValueVariable, Name: "a"}
+	b := &ir.Value{ID: 2, Type: types.Int, Kind: ir.ValueVariable, Name: "b"}
+	temp := func(id int) *ir.Value { return &ir.Value{ID: id, Type: types.Int, Kind: ir.ValueTemporary} }
+
+	tests := []struct {
+		name   string
+		instrs func() []ir.Instruction
+		want   []string
+	}{
+		{
+			name: "repeated binary op",
+			instrs: func() []ir.Instruction {
+				t3, t4 := temp(3), temp(4)
+				return []ir.Instruction{
+					&ir.BinaryOp{Op: ir.OpMul, Dest: t3, Left: a, Right: b},
+					&ir.BinaryOp{Op: ir.OpMul, Dest: t4, Left: a, Right: b},
+				}
+			},
+			want: []string{"t3 = a.1 * b.2", "t4 = t3"},
+		},
+		{
+			name: "repeated constant operand",
+			instrs: func() []ir.Instruction {
+				t3, t4 := temp(3), temp(4)
+				return []ir.Instruction{
+					&ir.UnaryOp{Op: ir.OpNeg, Dest: t3, Operand: constInt(7)},
+					&ir.UnaryOp{Op: ir.OpNeg, Dest: t4, Operand: constInt(7)},
+				}
+			},
+			want: []string{"t3 = -const(7)", "t4 = t3"},
+		},
+		{
+			name: "operand reassigned in between",
+			instrs: func() []ir.Instruction {
+				t3, t4 := temp(3), temp(4)
+				return []ir.Instruction{
+					&ir.BinaryOp{Op: ir.OpAdd, Dest: t3, Left: a, Right: b},
+					&ir.Copy{Dest: a, Value: constInt(1)},
+					&ir.BinaryOp{Op: ir.OpAdd, Dest: t4, Left: a, Right: b},
+				}
+			},
+			want: []string{"t3 = a.1 + b.2", "a.1 = const(1)", "t4 = a.1 + b.2"},
+		},
+		{
+			name: "operands not reordered",
+			instrs: func() []ir.Instruction {
+				t3, t4 := temp(3), temp(4)
+				return []ir.Instruction{
+					&ir.BinaryOp{Op: ir.OpAdd, Dest: t3, Left: a, Right: b},
+					&ir.BinaryOp{Op: ir.OpAdd, Dest: t4, Left: b, Right: a},
+				}
+			},
+			want: []string{"t3 = a.1 + b.2", "t4 = b.2 + a.1"},
+		},
+		{
+			name: "self-assignment is not reused",
+			instrs: func() []ir.Instruction {
+				return []ir.Instruction{
+					&ir.BinaryOp{Op: ir.OpAdd, Dest: a, Left: a, Right: constInt(1)},
+					&ir.BinaryOp{Op: ir.OpAdd, Dest: a, Left: a, Right: constInt(1)},
+				}
+			},
+			want: []string{"a.1 = a.1 + const(1)", "a.1 = a.1 + const(1)"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := &ir.BasicBlock{Label: "entry", Instructions: tt.instrs()}
+			fn := &ir.Function{Name: "test", ReturnType: types.Int, Blocks: []*ir.BasicBlock{entry}, Entry: entry}
+
+			pass := &ValueNumberingPass{}
+			if _, err := pass.Run(fn); err != nil {
+				t.Fatalf("value numbering failed: %v", err)
+			}
+
+			var got []string
+			for _, instr := range entry.Instructions {
+				got = append(got, instr.String())
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("expected:\n%s\ngot:\n%s", strings.Join(tt.want, "\n"), strings.Join(got, "\n"))
+			}
+		})
+	}
+}
+
+// TestAlgebraicSimplification tests each identity of the algebraic
+// simplification pass, and the cases it must leave alone
+func TestAlgebraicSimplification(t *testing.T) {
+	x := &ir.Value{ID: 1, Type: types.Int, Kind: ir.ValueVariable, Name: "x"}
+	f := &ir.Value{ID: 2, Type: types.Float, Kind: ir.ValueVariable, Name: "f"}
+	c := &ir.Value{ID: 3, Type: types.Bool, Kind: ir.ValueVariable, Name: "c"}
+	temp := func(id int) *ir.Value { return &ir.Value{ID: id, Type: types.Int, Kind: ir.ValueTemporary} }
+	binary := func(op ir.BinaryOperator, left, right *ir.Value) func() []ir.Instruction {
+		return func() []ir.Instruction {
+			return []ir.Instruction{&ir.BinaryOp{Op: op, Dest: temp(4), Left: left, Right: right}}
+		}
+	}
+
+	tests := []struct {
+		name   string
+		instrs func() []ir.Instruction
+		want   []string
+	}{
+		{"x + 0", binary(ir.OpAdd, x, constInt(0)), []string{"t4 = x.1"}},
+		{"0 + x", binary(ir.OpAdd, constInt(0), x), []string{"t4 = x.1"}},
+		{"x - 0", binary(ir.OpSub, x, constInt(0)), []string{"t4 = x.1"}},
+		{"x - x", binary(ir.OpSub, x, x), []string{"t4 = const(0)"}},
+		{"x * 1", binary(ir.OpMul, x, constInt(1)), []string{"t4 = x.1"}},
+		{"1 * x", binary(ir.OpMul, constInt(1), x), []string{"t4 = x.1"}},
+		{"x * 0", binary(ir.OpMul, x, constInt(0)), []string{"t4 = const(0)"}},
+		{"x * 8", binary(ir.OpMul, x, constInt(8)), []string{"t4 = x.1 << const(3)"}},
+		{"8 * x", binary(ir.OpMul, constInt(8), x), []string{"t4 = x.1 << const(3)"}},
+		{"x * 6 is kept", binary(ir.OpMul, x, constInt(6)), []string{"t4 = x.1 * const(6)"}},
+		{"x / 1", binary(ir.OpDiv, x, constInt(1)), []string{"t4 = x.1"}},
+		{"x / 4 is kept", binary(ir.OpDiv, x, constInt(4)), []string{"t4 = x.1 / const(4)"}},
+		{"x % 1", binary(ir.OpMod, x, constInt(1)), []string{"t4 = const(0)"}},
+		{"x % 4 of a possibly negative x is kept", binary(ir.OpMod, x, constInt(4)), []string{"t4 = x.1 % const(4)"}},
+		{
+			name: "x % 4 of a masked x",
+			instrs: func() []ir.Instruction {
+				t4, t5 := temp(4), temp(5)
+				return []ir.Instruction{
+					&ir.BinaryOp{Op: ir.OpBitAnd, Dest: t4, Left: x, Right: constInt(15)},
+					&ir.BinaryOp{Op: ir.OpMod, Dest: t5, Left: t4, Right: constInt(4)},
+				}
+			},
+			want: []string{"t4 = x.1 & const(15)", "t5 = t4 & const(3)"},
+		},
+		{
+			name: "float operations are kept",
+			instrs: func() []ir.Instruction {
+				t4 := &ir.Value{ID: 4, Type: types.Float, Kind: ir.ValueTemporary}
+				return []ir.Instruction{&ir.BinaryOp{Op: ir.OpSub, Dest: t4, Left: f, Right: f}}
+			},
+			want: []string{"t4 = f.2 - f.2"},
+		},
+		{
+			name: "double negation",
+			instrs: func() []ir.Instruction {
+				t4, t5 := temp(4), temp(5)
+				return []ir.Instruction{
+					&ir.UnaryOp{Op: ir.OpNeg, Dest: t4, Operand: x},
+					&ir.UnaryOp{Op: ir.OpNeg, Dest: t5, Operand: t4},
+				}
+			},
+			want: []string{"t4 = -x.1", "t5 = x.1"},
+		},
+		{
+			name: "double not",
+			instrs: func() []ir.Instruction {
+				t4 := &ir.Value{ID: 4, Type: types.Bool, Kind: ir.ValueTemporary}
+				t5 := &ir.Value{ID: 5, Type: types.Bool, Kind: ir.ValueTemporary}
+				return []ir.Instruction{
+					&ir.UnaryOp{Op: ir.OpNot, Dest: t4, Operand: c},
+					&ir.UnaryOp{Op: ir.OpNot, Dest: t5, Operand: t4},
+				}
+			},
+			want: []string{"t4 = !c.3", "t5 = c.3"},
+		},
+		{
+			name: "different unary operations are kept",
+			instrs: func() []ir.Instruction {
+				t4, t5 := temp(4), temp(5)
+				return []ir.Instruction{
+					&ir.UnaryOp{Op: ir.OpNeg, Dest: t4, Operand: x},
+					&ir.UnaryOp{Op: ir.OpBitNot, Dest: t5, Operand: t4},
+				}
+			},
+			want: []string{"t4 = -x.1", "t5 = ~t4"},
+		},
+		{
+			name: "operand reassigned between negations",
+			instrs: func() []ir.Instruction {
+				t4, t5 := temp(4), temp(5)
+				return []ir.Instruction{
+					&ir.UnaryOp{Op: ir.OpNeg, Dest: t4, Operand: x},
+					&ir.Copy{Dest: x, Value: constInt(1)},
+					&ir.UnaryOp{Op: ir.OpNeg, Dest: t5, Operand: t4},
+				}
+			},
+			want: []string{"t4 = -x.1", "x.1 = const(1)", "t5 = -t4"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := &ir.BasicBlock{Label: "entry", Instructions: tt.instrs()}
+			fn := &ir.Function{Name: "test", ReturnType: types.Int, Blocks: []*ir.BasicBlock{entry}, Entry: entry}
+
+			before := strings.Join(instructionStrings(entry), "\n")
+			pass := &AlgebraicSimplificationPass{}
+			changed, err := pass.Run(fn)
+			if err != nil {
+				t.Fatalf("algebraic simplification failed: %v", err)
+			}
+
+			got := strings.Join(instructionStrings(entry), "\n")
+			if got != strings.Join(tt.want, "\n") {
+				t.Errorf("expected:\n%s\ngot:\n%s", strings.Join(tt.want, "\n"), got)
+			}
+			if changed != (got != before) {
+				t.Errorf("expected changed = %v, got %v", got != before, changed)
+			}
+		})
+	}
+}
+
+// TestAlgebraicSimplificationWithFolding tests that simplification and
+// constant folding feed each other across iterations
+func TestAlgebraicSimplificationWithFolding(t *testing.T) {
+	// t1 = x - x; t2 = t1 < 1; return t2
+	//
+	// Folding can't evaluate t2 until simplification has turned t1 into 0,
+	// which takes the second iteration
+	fn := ir.NewFunction("chain", nil, types.Bool)
+	x := &ir.Value{ID: 100, Type: types.Int, Kind: ir.ValueVariable, Name: "x"}
+	fn.Parameters = []*ir.Value{x}
+	t1, t2 := fn.NewTemp(types.Int), fn.NewTemp(types.Bool)
+	fn.Entry.AddInstruction(&ir.BinaryOp{Dest: t1, Op: ir.OpSub, Left: x, Right: x})
+	fn.Entry.AddInstruction(&ir.BinaryOp{Dest: t2, Op: ir.OpLt, Left: t1, Right: constInt(1)})
+	fn.Entry.AddInstruction(&ir.Return{Value: t2})
+
+	opt := NewOptimizer()
+	if err := opt.SetPasses([]string{"ConstantFolding", "AlgebraicSimplification"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := opt.OptimizeFunction(fn); err != nil {
+		t.Fatalf("optimization failed: %v", err)
+	}
+
+	copy, ok := fn.Entry.Instructions[1].(*ir.Copy)
+	if !ok || !copy.Value.IsConstant() || copy.Value.Constant != true {
+		t.Errorf("expected t2 folded to true, got %s", fn.Entry.Instructions[1])
+	}
+	if runs := opt.Stats().PassExecutions["ConstantFolding"]; runs != 3 {
+		t.Errorf("expected 3 iterations, got %d", runs)
+	}
+}
+
+// instructionStrings renders the instructions of block.
+func instructionStrings(block *ir.BasicBlock) []string {
+	var lines []string
+	for _, instr := range block.Instructions {
+		lines = append(lines, instr.String())
+	}
+	return lines
 }
@@ -0,0 +1,113 @@
+package optimizer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// PassManager knows the optimization passes by name and turns names and
+// optimization levels into pass lists.
+//
+// DESIGN CHOICE: Passes are registered as constructors, not instances. Each
+// Optimizer gets passes of its own, so a pass that keeps state between runs
+// (a cache, statistics) can't leak it into another compilation, and the
+// registry itself stays read-only once set up.
+//
+// DESIGN CHOICE: Levels are presets over the same registry rather than pass
+// lists of their own. --opt=... and -O2 then name passes the same way, and a
+// pass added to the registry is available to explicit lists before any
+// level includes it.
+type PassManager struct {
+	// constructors maps each pass name to a function creating the pass
+	constructors map[string]func() Pass
+
+	// presets holds the pass names each optimization level runs, in order
+	presets map[int][]string
+}
+
+// NewPassManager creates a pass manager with no passes registered.
+// DefaultPassManager has the built-in ones.
+func NewPassManager() *PassManager {
+	return &PassManager{
+		constructors: make(map[string]func() Pass),
+		presets:      make(map[int][]string),
+	}
+}
+
+// DefaultPassManager returns a pass manager with every built-in pass and
+// the presets for O0 to O2.
+//
+// DESIGN CHOICE: Value numbering runs between constant folding and DCE.
+// Folding first means "2 + 3" and "5" are numbered as the same constant;
+// DCE last removes whatever the other two made unused. Algebraic
+// simplification sits right after folding, so it sees folded operands, and
+// before numbering, so "x * 1" and "x" are already the same copy of x.
+func DefaultPassManager() *PassManager {
+	m := NewPassManager()
+	m.Register(func() Pass { return &ConstantFoldingPass{} })
+	m.Register(func() Pass { return &AlgebraicSimplificationPass{} })
+	m.Register(func() Pass { return &ValueNumberingPass{} })
+	m.Register(func() Pass { return &DeadCodeEliminationPass{} })
+
+	// The passes were registered just above, so these can't fail
+	_ = m.SetPreset(O0, nil)
+	_ = m.SetPreset(O1, []string{"ConstantFolding", "DeadCodeElimination"})
+	_ = m.SetPreset(O2, []string{"ConstantFolding", "AlgebraicSimplification", "ValueNumbering", "DeadCodeElimination"})
+	return m
+}
+
+// Register adds a pass under the name it reports. Registering a name again
+// replaces the earlier pass.
+func (m *PassManager) Register(constructor func() Pass) {
+	m.constructors[constructor().Name()] = constructor
+}
+
+// SetPreset sets the passes an optimization level runs, checking that each
+// name has been registered.
+func (m *PassManager) SetPreset(level int, names []string) error {
+	if err := m.Check(names...); err != nil {
+		return err
+	}
+	m.presets[level] = names
+	return nil
+}
+
+// Preset returns the pass names of an optimization level, or nil if the
+// level has none.
+func (m *PassManager) Preset(level int) []string {
+	return m.presets[level]
+}
+
+// Available returns the registered pass names in alphabetical order.
+func (m *PassManager) Available() []string {
+	names := make([]string, 0, len(m.constructors))
+	for name := range m.constructors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Check returns an error naming the first name that isn't a registered
+// pass, and listing the ones that are.
+func (m *PassManager) Check(names ...string) error {
+	for _, name := range names {
+		if _, ok := m.constructors[name]; !ok {
+			return fmt.Errorf("unknown optimization pass %q (available: %s)", name, strings.Join(m.Available(), ", "))
+		}
+	}
+	return nil
+}
+
+// Passes creates the named passes, in the order given.
+func (m *PassManager) Passes(names []string) ([]Pass, error) {
+	if err := m.Check(names...); err != nil {
+		return nil, err
+	}
+	passes := make([]Pass, 0, len(names))
+	for _, name := range names {
+		passes = append(passes, m.constructors[name]())
+	}
+	return passes, nil
+}
@@ -0,0 +1,122 @@
+package optimizer
+
+import (
+	"github.com/hassan/compiler/internal/ir"
+)
+
+// ValueNumberingPass reuses the result of a computation repeated within a
+// basic block.
+//
+// EXAMPLE:
+//
+//	Before:  t1 = a + b
+//	         t2 = a + b      // same operator, same operands
+//	After:   t1 = a + b
+//	         t2 = t1
+//
+// A copy is cheaper than the arithmetic it replaces, and constant folding
+// can see through it when the first result turns out to be a constant.
+//
+// DESIGN CHOICE: Local (per-block) value numbering rather than global value
+// numbering. The IR is not in SSA form - a variable is reassigned by every
+// assignment to it - so "a + b" only means the same thing twice if neither
+// operand was assigned in between. Within one block that is a forward scan;
+// across blocks it needs reaching-definitions analysis this optimizer doesn't
+// have yet. Most repeated expressions (an index computed twice, a condition
+// re-tested) sit in a single block anyway.
+//
+// Operands are never reordered: "a + b" and "b + a" get different numbers,
+// because + on strings is concatenation and does not commute.
+type ValueNumberingPass struct{}
+
+// Name returns the name of this optimization pass.
+func (v *ValueNumberingPass) Name() string {
+	return "ValueNumbering"
+}
+
+// operandKey identifies an operand for value numbering: constants by value,
+// everything else by identity, as elsewhere in the IR.
+type operandKey struct {
+	value    *ir.Value
+	constant interface{}
+}
+
+// exprKey identifies a computation. unary distinguishes "-x" from a binary
+// operator that happens to share the numeric value of UnaryOperator.
+type exprKey struct {
+	unary       bool
+	op          int
+	left, right operandKey
+}
+
+func keyOf(v *ir.Value) operandKey {
+	if v.IsConstant() {
+		return operandKey{constant: v.Constant}
+	}
+	return operandKey{value: v}
+}
+
+// Run executes value numbering on each block of the function.
+func (v *ValueNumberingPass) Run(fn *ir.Function) (bool, error) {
+	stats, err := v.RunWithStats(fn)
+	return stats.ExpressionsReused > 0, err
+}
+
+// RunWithStats numbers values like Run and counts the reused computations.
+func (v *ValueNumberingPass) RunWithStats(fn *ir.Function) (PassStats, error) {
+	var stats PassStats
+	for _, block := range fn.Blocks {
+		stats.ExpressionsReused += v.numberBlock(block)
+	}
+	return stats, nil
+}
+
+// numberBlock replaces repeated computations in one block and returns how
+// many it replaced.
+//
+// ALGORITHM:
+//  1. Walk the block in order, keeping the computations seen so far
+//  2. A computation seen before becomes a copy of the earlier result
+//  3. Any instruction that assigns a value forgets every computation that
+//     read it or produced it: they no longer describe its current contents
+func (v *ValueNumberingPass) numberBlock(block *ir.BasicBlock) int {
+	available := make(map[exprKey]*ir.Value)
+	reused := 0
+
+	for i, instr := range block.Instructions {
+		var key exprKey
+		computes := false
+		switch inst := instr.(type) {
+		case *ir.BinaryOp:
+			key = exprKey{op: int(inst.Op), left: keyOf(inst.Left), right: keyOf(inst.Right)}
+			computes = true
+		case *ir.UnaryOp:
+			key = exprKey{unary: true, op: int(inst.Op), left: keyOf(inst.Operand)}
+			computes = true
+		}
+
+		result := instr.Result()
+		if computes {
+			if previous, ok := available[key]; ok {
+				block.Instructions[i] = &ir.Copy{Dest: result, Value: previous}
+				computes = false
+				reused++
+			}
+		}
+
+		if result == nil {
+			continue
+		}
+		for k, value := range available {
+			if value == result || k.left.value == result || k.right.value == result {
+				delete(available, k)
+			}
+		}
+
+		// "x = x + 1" can't be reused: afterwards, x + 1 means something else
+		if computes && key.left.value != result && key.right.value != result {
+			available[key] = result
+		}
+	}
+	return reused
+}
@@ -0,0 +1,228 @@
+package optimizer
+
+import (
+	"math"
+	"testing"
+
+	"github.com/hassan/compiler/internal/ir"
+	"github.com/hassan/compiler/internal/lexer"
+	"github.com/hassan/compiler/internal/parser"
+	"github.com/hassan/compiler/internal/semantic"
+	"github.com/hassan/compiler/internal/semantic/types"
+)
+
+// verifySource is built by the IR builder for TestOptimizerPreservesSemantics.
+// Each function folds to something, leaves something dead or has a branch
+// whose condition folds; none calls anything, so ir.Interpret can run it.
+const verifySource = `package main
+
+func folding(n int) int {
+    var a int = 6 * 7;
+    var unused int = a + 1;
+    var b int = a - 2;
+    if (b > 100) {
+        return 0;
+    }
+    return b + n;
+}
+
+func branches(n int) int {
+    var limit int = 5;
+    if (1 < 2) {
+        limit = limit * 2;
+    } else {
+        limit = 0;
+    }
+    if (n > limit) {
+        return n - limit;
+    }
+    return limit - n;
+}
+
+func loops(n int) int {
+    var total int = 0;
+    var step int = 2 * 3;
+    for (var i int = 0; i < n; i = i + 1) {
+        if (i % 2 == 0) {
+            total = total + step;
+        } else {
+            total = total - 1;
+        }
+    }
+    while (false) {
+        total = 0;
+    }
+    return total;
+}
+
+func logic(a bool, n int) bool {
+    var yes bool = !false;
+    return (a && yes) || n > 3;
+}
+
+func bits(n int) int {
+    var mask int = (1 << 4) - 1;
+    return (n & mask) ^ (n << 2) | (0 - 1) * 0;
+}
+
+func algebra(n int) int {
+    var zero int = n - n;
+    return (n * 1 + 0) * (zero + 1) - n / 1;
+}
+
+func increment(n int) int {
+    n = n + 1;
+    return n;
+}
+
+func countdown(n int) int {
+    var total int = n;
+    while (n > 0) {
+        n = n - 1;
+        total = total + n;
+    }
+    return total + n;
+}
+`
+
+// buildFunction builds the named function of verifySource. The optimizer
+// rewrites the function it is given, so every run builds a fresh one.
+func buildFunction(t *testing.T, name string) *ir.Function {
+	t.Helper()
+	file, errs := parser.New(lexer.New(verifySource, "verify.src")).ParseFile("verify.src")
+	if len(errs) > 0 {
+		t.Fatalf("parse: %v", errs)
+	}
+	analyzer := semantic.New()
+	if errs := analyzer.Analyze(file); len(errs) > 0 {
+		t.Fatalf("analyze: %v", errs)
+	}
+	module, errs := ir.NewBuilder(analyzer).Build(file)
+	if len(errs) > 0 {
+		t.Fatalf("build: %v", errs)
+	}
+	for _, fn := range module.Functions {
+		if fn.Name == name {
+			return fn
+		}
+	}
+	t.Fatalf("no function %s", name)
+	return nil
+}
+
+// wrappingAdd builds "t1 = x + const(100); return t1" on int8, which folding
+// must wrap the same as the addition does at run time.
+func wrappingAdd() *ir.Function {
+	x := &ir.Value{ID: 0, Name: "x", Type: types.Int8, Kind: ir.ValueParameter}
+	fn := ir.NewFunction("wrap", []*ir.Value{x}, types.Int8)
+	sum := fn.NewTemp(types.Int8)
+	fn.Entry.AddInstruction(&ir.BinaryOp{Op: ir.OpAdd, Dest: sum, Left: x, Right: &ir.Value{ID: -1, Type: types.Int8, Kind: ir.ValueConstant, Constant: int64(100)}})
+	fn.Entry.AddInstruction(&ir.Return{Value: sum})
+	return fn
+}
+
+// foldedChain builds
+//
+//	t1 = 2 + 3; t2 = t1 * 4; t3 = -t2; t4 = t3 < 0; branch t4, neg, pos
+//	neg: return t3
+//	pos: t5 = 4 * 5 (dead); return 0
+//
+// in which every value folds, and one branch becomes unreachable.
+func foldedChain() *ir.Function {
+	fn := ir.NewFunction("chain", nil, types.Int)
+	neg := fn.NewBasicBlockInFunc("neg")
+	pos := fn.NewBasicBlockInFunc("pos")
+	t1, t2, t3 := fn.NewTemp(types.Int), fn.NewTemp(types.Int), fn.NewTemp(types.Int)
+	t4 := fn.NewTemp(types.Bool)
+	fn.Entry.AddInstruction(&ir.BinaryOp{Op: ir.OpAdd, Dest: t1, Left: constInt(2), Right: constInt(3)})
+	fn.Entry.AddInstruction(&ir.BinaryOp{Op: ir.OpMul, Dest: t2, Left: t1, Right: constInt(4)})
+	fn.Entry.AddInstruction(&ir.UnaryOp{Op: ir.OpNeg, Dest: t3, Operand: t2})
+	fn.Entry.AddInstruction(&ir.BinaryOp{Op: ir.OpLt, Dest: t4, Left: t3, Right: constInt(0)})
+	fn.Entry.AddInstruction(&ir.Branch{Condition: t4, TrueBlock: neg, FalseBlock: pos})
+	fn.Entry.AddSuccessor(neg)
+	fn.Entry.AddSuccessor(pos)
+	neg.AddInstruction(&ir.Return{Value: t3})
+	pos.AddInstruction(&ir.BinaryOp{Op: ir.OpMul, Dest: fn.NewTemp(types.Int), Left: constInt(4), Right: constInt(5)})
+	pos.AddInstruction(&ir.Return{Value: constInt(0)})
+	return fn
+}
+
+// TestOptimizerPreservesSemantics runs each function with ir.Interpret
+// before and after optimization, on several arguments, and requires the
+// same result every time. Each optimization level is
+// checked, and each pass on its own, so a pass that only goes wrong when
+// no other pass cleans up after it can't hide.
+func TestOptimizerPreservesSemantics(t *testing.T) {
+	ints := [][]interface{}{{-7}, {0}, {1}, {5}, {12}, {math.MaxInt64}}
+	tests := []struct {
+		name  string
+		build func(t *testing.T) *ir.Function
+		args  [][]interface{}
+	}{
+		{"unused add", func(*testing.T) *ir.Function { return unusedAdd() }, [][]interface{}{nil}},
+		{"folded chain", func(*testing.T) *ir.Function { return foldedChain() }, [][]interface{}{nil}},
+		{"int8 wraps", func(*testing.T) *ir.Function { return wrappingAdd() }, [][]interface{}{{-100}, {0}, {27}, {100}}},
+		{"folding", func(t *testing.T) *ir.Function { return buildFunction(t, "folding") }, ints},
+		{"branches", func(t *testing.T) *ir.Function { return buildFunction(t, "branches") }, ints[:5]},
+		{"loops", func(t *testing.T) *ir.Function { return buildFunction(t, "loops") }, [][]interface{}{{-1}, {0}, {1}, {2}, {9}}},
+		{"logic", func(t *testing.T) *ir.Function { return buildFunction(t, "logic") }, [][]interface{}{{true, 0}, {false, 0}, {false, 4}, {true, 9}}},
+		{"bits", func(t *testing.T) *ir.Function { return buildFunction(t, "bits") }, ints},
+		{"algebra", func(t *testing.T) *ir.Function { return buildFunction(t, "algebra") }, ints},
+		{"increment", func(t *testing.T) *ir.Function { return buildFunction(t, "increment") }, ints},
+		{"countdown", func(t *testing.T) *ir.Function { return buildFunction(t, "countdown") }, [][]interface{}{{-3}, {0}, {1}, {4}}},
+	}
+
+	// Each configuration sets up a fresh optimizer: a level, or one pass
+	configs := []struct {
+		name  string
+		setup func(*Optimizer) error
+	}{
+		{"O1", func(o *Optimizer) error { o.SetLevel(O1); return nil }},
+		{"O2", func(o *Optimizer) error { o.SetLevel(O2); return nil }},
+	}
+	for _, pass := range DefaultPassManager().Available() {
+		pass := pass
+		configs = append(configs, struct {
+			name  string
+			setup func(*Optimizer) error
+		}{pass, func(o *Optimizer) error { return o.SetPasses([]string{pass}) }})
+	}
+
+	for _, tt := range tests {
+		for _, config := range configs {
+			t.Run(tt.name+"/"+config.name, func(t *testing.T) {
+				fn := tt.build(t)
+				before := fn.String()
+				type outcome struct {
+					value interface{}
+					err   error
+				}
+				want := make([]outcome, len(tt.args))
+				for i, args := range tt.args {
+					want[i].value, want[i].err = ir.Interpret(fn, args...)
+					// Every case runs to a result unoptimized; one that
+					// can't would pass against any pass that fails the same way
+					if want[i].err != nil {
+						t.Fatalf("%s%v: %v\n%s", fn.Name, args, want[i].err, before)
+					}
+				}
+
+				opt := NewOptimizer()
+				if err := config.setup(opt); err != nil {
+					t.Fatal(err)
+				}
+				if err := opt.OptimizeFunction(fn); err != nil {
+					t.Fatalf("optimization failed: %v", err)
+				}
+
+				for i, args := range tt.args {
+					got, err := ir.Interpret(fn, args...)
+					if err != nil || got != want[i].value {
+						t.Errorf("%s%v: expected %v, got %v (error %v)\nbefore:\n%s\nafter:\n%s",
+							fn.Name, args, want[i].value, got, err, before, fn)
+					}
+				}
+			})
+		}
+	}
+}
@@ -15,6 +15,8 @@
 package ast
 
 import (
+	"unicode/utf8"
+
 	"github.com/hassan/compiler/internal/lexer"
 )
 
@@ -144,6 +146,61 @@ type Visitor interface {
 	VisitStructDecl(decl *StructDecl) error
 }
 
+// BaseVisitor implements every Visitor method as a no-op.
+//
+// DESIGN CHOICE: Embed BaseVisitor in a custom visitor and override only the
+// methods you care about:
+//   type callCounter struct {
+//       ast.BaseVisitor
+//       calls int
+//   }
+//   func (c *callCounter) VisitCallExpr(expr *ast.CallExpr) (interface{}, error) { ... }
+//
+// The no-op methods do NOT recurse into children - with Go embedding the
+// promoted method would call the BaseVisitor's own methods, not the override,
+// so it could never dispatch back to the embedding type anyway. Visitors that
+// need to reach every node should recurse explicitly, or use Walk/Inspect.
+type BaseVisitor struct{}
+
+func (BaseVisitor) VisitBinaryExpr(expr *BinaryExpr) (interface{}, error)   { return nil, nil }
+func (BaseVisitor) VisitUnaryExpr(expr *UnaryExpr) (interface{}, error)     { return nil, nil }
+func (BaseVisitor) VisitLiteralExpr(expr *LiteralExpr) (interface{}, error) { return nil, nil }
+func (BaseVisitor) VisitIdentifierExpr(expr *IdentifierExpr) (interface{}, error) {
+	return nil, nil
+}
+func (BaseVisitor) VisitCallExpr(expr *CallExpr) (interface{}, error)     { return nil, nil }
+func (BaseVisitor) VisitIndexExpr(expr *IndexExpr) (interface{}, error)   { return nil, nil }
+func (BaseVisitor) VisitMemberExpr(expr *MemberExpr) (interface{}, error) { return nil, nil }
+func (BaseVisitor) VisitAssignmentExpr(expr *AssignmentExpr) (interface{}, error) {
+	return nil, nil
+}
+func (BaseVisitor) VisitLogicalExpr(expr *LogicalExpr) (interface{}, error)   { return nil, nil }
+func (BaseVisitor) VisitGroupingExpr(expr *GroupingExpr) (interface{}, error) { return nil, nil }
+func (BaseVisitor) VisitArrayLiteralExpr(expr *ArrayLiteralExpr) (interface{}, error) {
+	return nil, nil
+}
+func (BaseVisitor) VisitStructLiteralExpr(expr *StructLiteralExpr) (interface{}, error) {
+	return nil, nil
+}
+
+func (BaseVisitor) VisitExprStmt(stmt *ExprStmt) error         { return nil }
+func (BaseVisitor) VisitBlockStmt(stmt *BlockStmt) error       { return nil }
+func (BaseVisitor) VisitIfStmt(stmt *IfStmt) error             { return nil }
+func (BaseVisitor) VisitWhileStmt(stmt *WhileStmt) error       { return nil }
+func (BaseVisitor) VisitForStmt(stmt *ForStmt) error           { return nil }
+func (BaseVisitor) VisitReturnStmt(stmt *ReturnStmt) error     { return nil }
+func (BaseVisitor) VisitBreakStmt(stmt *BreakStmt) error       { return nil }
+func (BaseVisitor) VisitContinueStmt(stmt *ContinueStmt) error { return nil }
+func (BaseVisitor) VisitSwitchStmt(stmt *SwitchStmt) error     { return nil }
+
+func (BaseVisitor) VisitVarDecl(decl *VarDecl) error       { return nil }
+func (BaseVisitor) VisitFuncDecl(decl *FuncDecl) error     { return nil }
+func (BaseVisitor) VisitTypeDecl(decl *TypeDecl) error     { return nil }
+func (BaseVisitor) VisitStructDecl(decl *StructDecl) error { return nil }
+
+// Compile-time check that BaseVisitor satisfies the full interface.
+var _ Visitor = BaseVisitor{}
+
 // File represents a single source file.
 //
 // DESIGN CHOICE: The root of our AST is a File, not a Program, because:
@@ -171,6 +228,29 @@ type File struct {
 	Filename string
 }
 
+// Pos returns the position of the package clause, which starts every file.
+// File implements Node so it can be the root of ast.Walk and ast.Inspect.
+func (f *File) Pos() lexer.Position {
+	if f.Package != nil {
+		return f.Package.Pos()
+	}
+	return lexer.Position{Filename: f.Filename}
+}
+
+// End returns the end of the last declaration (or import, or package clause).
+func (f *File) End() lexer.Position {
+	if len(f.Decls) > 0 {
+		return f.Decls[len(f.Decls)-1].End()
+	}
+	if len(f.Imports) > 0 && f.Imports[len(f.Imports)-1] != nil {
+		return f.Imports[len(f.Imports)-1].End()
+	}
+	if f.Package != nil {
+		return f.Package.End()
+	}
+	return lexer.Position{Filename: f.Filename}
+}
+
 // PackageDecl represents a package declaration (package foo).
 type PackageDecl struct {
 	PackagePos lexer.Position // Position of 'package' keyword
@@ -216,9 +296,9 @@ func (c *Comment) End() lexer.Position {
 	endLine := c.Position.Line + lines
 	endCol := c.Position.Column
 	if lines > 0 {
-		endCol = len(c.Text) - lastNewline
+		endCol = utf8.RuneCountInString(c.Text[lastNewline:])
 	} else {
-		endCol += len(c.Text)
+		endCol += utf8.RuneCountInString(c.Text)
 	}
 	return lexer.Position{
 		Filename: c.Position.Filename,
@@ -0,0 +1,367 @@
+package ast
+
+// Clone returns a deep copy of node: every node and slice below it is new, so
+// the copy can be rewritten without touching the original. Clone(nil) is nil.
+//
+// Only immutable leaf values are shared: tokens and positions are value types
+// and are copied with the node that holds them, and a LiteralExpr's Value is
+// an int64, float64, string, bool or rune.
+//
+// DESIGN CHOICE: Each case copies the whole struct first (c := *n) and then
+// replaces the children, rather than building the copy field by field. A
+// field added to a node later is then copied without anyone remembering to
+// update Clone; only a new child node needs a line here, the same as in Walk.
+//
+// A comment that is both in File.Comments and a declaration's LeadingComment
+// is copied once, so the copies point at the same comment too.
+//
+// Semantic information (types recorded by the analyzer, symbol table
+// entries) is keyed by node identity, so a clone has none until it is
+// analyzed itself. That is what inlining wants: the cloned body is checked
+// again in its new context.
+func Clone(node Node) Node {
+	return (&cloner{comments: make(map[*Comment]*Comment)}).clone(node)
+}
+
+// cloner carries the comments copied so far through one Clone.
+type cloner struct {
+	comments map[*Comment]*Comment
+}
+
+func (cl *cloner) clone(node Node) Node {
+	if node == nil {
+		return nil
+	}
+
+	switch n := node.(type) {
+	// Root and file-level nodes
+	case *File:
+		c := *n
+		if n.Package != nil {
+			c.Package = cl.clone(n.Package).(*PackageDecl)
+		}
+		if n.Imports != nil {
+			c.Imports = make([]*ImportDecl, len(n.Imports))
+			for i, imp := range n.Imports {
+				if imp != nil {
+					c.Imports[i] = cl.clone(imp).(*ImportDecl)
+				}
+			}
+		}
+		if n.Decls != nil {
+			c.Decls = make([]Decl, len(n.Decls))
+			for i, decl := range n.Decls {
+				c.Decls[i] = cl.decl(decl)
+			}
+		}
+		if n.Comments != nil {
+			c.Comments = make([]*Comment, len(n.Comments))
+			for i, comment := range n.Comments {
+				c.Comments[i] = cl.comment(comment)
+			}
+		}
+		return &c
+
+	case *PackageDecl:
+		c := *n
+		c.Name = cl.ident(n.Name)
+		return &c
+
+	case *ImportDecl:
+		c := *n
+		c.Name = cl.ident(n.Name)
+		if n.Path != nil {
+			c.Path = cl.clone(n.Path).(*LiteralExpr)
+		}
+		return &c
+
+	case *Comment:
+		return cl.comment(n)
+
+	// Expressions
+	case *BinaryExpr:
+		c := *n
+		c.Left = cl.expr(n.Left)
+		c.Right = cl.expr(n.Right)
+		return &c
+
+	case *UnaryExpr:
+		c := *n
+		c.Operand = cl.expr(n.Operand)
+		return &c
+
+	case *LiteralExpr:
+		c := *n
+		return &c
+
+	case *IdentifierExpr:
+		c := *n
+		return &c
+
+	case *CallExpr:
+		c := *n
+		c.Callee = cl.expr(n.Callee)
+		c.Args = cl.exprs(n.Args)
+		return &c
+
+	case *IndexExpr:
+		c := *n
+		c.Object = cl.expr(n.Object)
+		c.Index = cl.expr(n.Index)
+		return &c
+
+	case *MemberExpr:
+		c := *n
+		c.Object = cl.expr(n.Object)
+		c.Member = cl.ident(n.Member)
+		return &c
+
+	case *AssignmentExpr:
+		c := *n
+		c.Target = cl.expr(n.Target)
+		c.Value = cl.expr(n.Value)
+		return &c
+
+	case *LogicalExpr:
+		c := *n
+		c.Left = cl.expr(n.Left)
+		c.Right = cl.expr(n.Right)
+		return &c
+
+	case *GroupingExpr:
+		c := *n
+		c.Expression = cl.expr(n.Expression)
+		return &c
+
+	case *ArrayLiteralExpr:
+		c := *n
+		c.ElementType = cl.expr(n.ElementType)
+		c.Elements = cl.exprs(n.Elements)
+		return &c
+
+	case *StructLiteralExpr:
+		c := *n
+		c.TypeName = cl.ident(n.TypeName)
+		if n.Fields != nil {
+			c.Fields = make([]*FieldInit, len(n.Fields))
+			for i, field := range n.Fields {
+				if field != nil {
+					c.Fields[i] = cl.clone(field).(*FieldInit)
+				}
+			}
+		}
+		return &c
+
+	case *FieldInit:
+		c := *n
+		c.Name = cl.ident(n.Name)
+		c.Value = cl.expr(n.Value)
+		return &c
+
+	// Statements
+	case *ExprStmt:
+		c := *n
+		c.Expression = cl.expr(n.Expression)
+		return &c
+
+	case *BlockStmt:
+		c := *n
+		c.Statements = cl.stmts(n.Statements)
+		return &c
+
+	case *IfStmt:
+		c := *n
+		c.Condition = cl.expr(n.Condition)
+		c.ThenBranch = cl.block(n.ThenBranch)
+		c.ElseBranch = cl.stmt(n.ElseBranch)
+		return &c
+
+	case *WhileStmt:
+		c := *n
+		c.Condition = cl.expr(n.Condition)
+		c.Body = cl.block(n.Body)
+		return &c
+
+	case *ForStmt:
+		c := *n
+		c.Init = cl.stmt(n.Init)
+		c.Condition = cl.expr(n.Condition)
+		c.Post = cl.stmt(n.Post)
+		c.Body = cl.block(n.Body)
+		return &c
+
+	case *ReturnStmt:
+		c := *n
+		c.Value = cl.expr(n.Value)
+		return &c
+
+	case *BreakStmt:
+		c := *n
+		return &c
+
+	case *ContinueStmt:
+		c := *n
+		return &c
+
+	case *SwitchStmt:
+		c := *n
+		c.Value = cl.expr(n.Value)
+		if n.Cases != nil {
+			c.Cases = make([]*CaseClause, len(n.Cases))
+			for i, clause := range n.Cases {
+				if clause != nil {
+					c.Cases[i] = cl.clone(clause).(*CaseClause)
+				}
+			}
+		}
+		return &c
+
+	case *CaseClause:
+		c := *n
+		c.Values = cl.exprs(n.Values)
+		c.Body = cl.stmts(n.Body)
+		return &c
+
+	// Declarations
+	case *VarDecl:
+		c := *n
+		if n.Names != nil {
+			c.Names = make([]*IdentifierExpr, len(n.Names))
+			for i, name := range n.Names {
+				c.Names[i] = cl.ident(name)
+			}
+		}
+		c.Type = cl.expr(n.Type)
+		c.Initializer = cl.expr(n.Initializer)
+		c.LeadingComment = cl.comment(n.LeadingComment)
+		return &c
+
+	case *FuncDecl:
+		c := *n
+		c.Name = cl.ident(n.Name)
+		if n.Params != nil {
+			c.Params = make([]*Parameter, len(n.Params))
+			for i, param := range n.Params {
+				if param != nil {
+					c.Params[i] = cl.clone(param).(*Parameter)
+				}
+			}
+		}
+		c.ReturnType = cl.expr(n.ReturnType)
+		c.Body = cl.block(n.Body)
+		c.LeadingComment = cl.comment(n.LeadingComment)
+		return &c
+
+	case *Parameter:
+		c := *n
+		c.Name = cl.ident(n.Name)
+		c.Type = cl.expr(n.Type)
+		return &c
+
+	case *TypeDecl:
+		c := *n
+		c.Name = cl.ident(n.Name)
+		c.Type = cl.expr(n.Type)
+		c.LeadingComment = cl.comment(n.LeadingComment)
+		return &c
+
+	case *StructDecl:
+		c := *n
+		c.Name = cl.ident(n.Name)
+		if n.Fields != nil {
+			c.Fields = make([]*FieldDecl, len(n.Fields))
+			for i, field := range n.Fields {
+				if field != nil {
+					c.Fields[i] = cl.clone(field).(*FieldDecl)
+				}
+			}
+		}
+		c.LeadingComment = cl.comment(n.LeadingComment)
+		return &c
+
+	case *FieldDecl:
+		c := *n
+		c.Name = cl.ident(n.Name)
+		c.Type = cl.expr(n.Type)
+		return &c
+	}
+
+	// A node kind Clone doesn't know about: returning it uncopied would
+	// silently share it between the original and the copy
+	panic("ast.Clone: unexpected node type")
+}
+
+// comment copies a comment once: a declaration's LeadingComment is also in
+// File.Comments, and both must point at the same copy.
+func (cl *cloner) comment(comment *Comment) *Comment {
+	if comment == nil {
+		return nil
+	}
+	if c, ok := cl.comments[comment]; ok {
+		return c
+	}
+	c := *comment
+	cl.comments[comment] = &c
+	return &c
+}
+
+// The helpers below keep nil children nil - an interface that is nil, or a
+// typed pointer left nil by error recovery - and keep the static types, so
+// the copy holds the same kinds of values as the original.
+
+func (cl *cloner) expr(expr Expr) Expr {
+	if expr == nil {
+		return nil
+	}
+	return cl.clone(expr).(Expr)
+}
+
+func (cl *cloner) exprs(exprs []Expr) []Expr {
+	if exprs == nil {
+		return nil
+	}
+	c := make([]Expr, len(exprs))
+	for i, expr := range exprs {
+		c[i] = cl.expr(expr)
+	}
+	return c
+}
+
+func (cl *cloner) stmt(stmt Stmt) Stmt {
+	if stmt == nil {
+		return nil
+	}
+	return cl.clone(stmt).(Stmt)
+}
+
+func (cl *cloner) stmts(stmts []Stmt) []Stmt {
+	if stmts == nil {
+		return nil
+	}
+	c := make([]Stmt, len(stmts))
+	for i, stmt := range stmts {
+		c[i] = cl.stmt(stmt)
+	}
+	return c
+}
+
+func (cl *cloner) decl(decl Decl) Decl {
+	if decl == nil {
+		return nil
+	}
+	return cl.clone(decl).(Decl)
+}
+
+func (cl *cloner) ident(ident *IdentifierExpr) *IdentifierExpr {
+	if ident == nil {
+		return nil
+	}
+	return cl.clone(ident).(*IdentifierExpr)
+}
+
+func (cl *cloner) block(block *BlockStmt) *BlockStmt {
+	if block == nil {
+		return nil
+	}
+	return cl.clone(block).(*BlockStmt)
+}
@@ -0,0 +1,105 @@
+package ast_test
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/hassan/compiler/internal/parser/ast"
+)
+
+// nodesOf lists every node Walk reaches under node.
+func nodesOf(node ast.Node) []ast.Node {
+	var nodes []ast.Node
+	ast.Walk(node, func(n ast.Node) bool {
+		nodes = append(nodes, n)
+		return true
+	}, nil)
+	return nodes
+}
+
+// dump prints file with ast.Print.
+func dump(t *testing.T, file *ast.File) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := ast.Print(file, &buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestClone_FuncDecl(t *testing.T) {
+	file := parseFixture(t, "walk.src")
+	var fn *ast.FuncDecl
+	for _, decl := range file.Decls {
+		if f, ok := decl.(*ast.FuncDecl); ok {
+			fn = f
+		}
+	}
+	before := dump(t, file)
+
+	clone := ast.Clone(fn).(*ast.FuncDecl)
+	clone.Name.Name = "inlined"
+	clone.Params[0].Name.Name = "q"
+	clone.Body.Statements = clone.Body.Statements[:1]
+	clone.Body.Statements[0].(*ast.VarDecl).Initializer.(*ast.BinaryExpr).Operator.Lexeme = "-"
+
+	if fn.Name.Name != "classify" || fn.Params[0].Name.Name != "p" {
+		t.Errorf("renaming the clone renamed the original: %s(%s)", fn.Name.Name, fn.Params[0].Name.Name)
+	}
+	if after := dump(t, file); after != before {
+		t.Errorf("editing the clone changed the original:\nbefore:\n%s\nafter:\n%s", before, after)
+	}
+	if clone.Pos() != fn.Pos() {
+		t.Errorf("expected the clone to keep its position, got %v", clone.Pos())
+	}
+}
+
+func TestClone_File(t *testing.T) {
+	file := parseFixture(t, "walk.src")
+	clone := ast.Clone(file).(*ast.File)
+
+	if got, want := dump(t, clone), dump(t, file); got != want {
+		t.Errorf("clone prints differently:\ngot:\n%s\nwant:\n%s", got, want)
+	}
+
+	// Same shape, but not one node in common
+	original, copied := nodesOf(file), nodesOf(clone)
+	if len(copied) != len(original) {
+		t.Fatalf("expected %d nodes in the clone, got %d", len(original), len(copied))
+	}
+	shared := make(map[ast.Node]bool)
+	for _, n := range original {
+		shared[n] = true
+	}
+	for i, n := range copied {
+		if fmt.Sprintf("%T", n) != fmt.Sprintf("%T", original[i]) {
+			t.Errorf("node %d: expected %T, got %T", i, original[i], n)
+		}
+		if shared[n] {
+			t.Errorf("node %d (%T) is shared with the original", i, n)
+		}
+	}
+
+	// Slices are copied too: appending to one doesn't show in the other
+	clone.Decls = append(clone.Decls[:0], clone.Decls[len(clone.Decls)-1])
+	if _, ok := file.Decls[0].(*ast.StructDecl); !ok {
+		t.Errorf("rewriting the clone's declarations changed the original's: %T", file.Decls[0])
+	}
+}
+
+func TestClone_Nil(t *testing.T) {
+	if ast.Clone(nil) != nil {
+		t.Error("expected Clone(nil) to be nil")
+	}
+
+	// Optional children stay nil rather than becoming typed nils
+	stmt := ast.Clone(&ast.IfStmt{Condition: &ast.IdentifierExpr{Name: "ok"}}).(*ast.IfStmt)
+	if stmt.ThenBranch != nil || stmt.ElseBranch != nil {
+		t.Errorf("expected missing branches to stay nil, got %v and %v", stmt.ThenBranch, stmt.ElseBranch)
+	}
+	ret := ast.Clone(&ast.ReturnStmt{}).(*ast.ReturnStmt)
+	if ret.Value != nil {
+		t.Errorf("expected a void return to stay void, got %v", ret.Value)
+	}
+}
@@ -0,0 +1,93 @@
+package ast
+
+import "github.com/hassan/compiler/internal/lexer"
+
+// AttachComments sets the LeadingComment of every function, struct, type and
+// variable declaration in file, local variables included, from file.Comments.
+//
+// A comment leads a declaration when it ends on the line just above it: a
+// blank line in between means the comment is about something else. A run of
+// // lines is one Comment per line, so the nearest line is the one attached.
+//
+// A comment sharing its line with code is never leading. One after the code
+// ("var x int; // count") annotates that line, and one before it
+// ("/* old */ var y int;") leads the code beside it, not the line below.
+//
+// DESIGN CHOICE: A separate step over a parsed file rather than something the
+// parser does while parsing. The parser sees comments as stray tokens between
+// declarations, and deciding which declaration one belongs to needs the
+// positions of both sides. Keeping it out of the parser also means tools that
+// don't care about doc comments (the compiler itself) don't pay for it.
+//
+// Calling AttachComments again recomputes every LeadingComment, so it can be
+// rerun after comments or declarations are added.
+func AttachComments(file *File) {
+	// first and last are the columns of the first and last code on each line
+	first := make(map[int]int)
+	last := make(map[int]int)
+	mark := func(pos lexer.Position) {
+		if pos.Line == 0 {
+			return
+		}
+		if col, ok := first[pos.Line]; !ok || pos.Column < col {
+			first[pos.Line] = pos.Column
+		}
+		if pos.Column > last[pos.Line] {
+			last[pos.Line] = pos.Column
+		}
+	}
+
+	var decls []Node
+	Walk(file, func(n Node) bool {
+		switch n := n.(type) {
+		case *File, *Comment:
+			return true
+		case *FuncDecl, *VarDecl, *TypeDecl:
+			decls = append(decls, n)
+		case *StructDecl:
+			decls = append(decls, n)
+			mark(n.RightBrace.Position)
+		// A closing brace is the one token that can sit on a line by itself
+		// without being the start of a node
+		case *BlockStmt:
+			mark(n.RightBrace.Position)
+		case *SwitchStmt:
+			mark(n.RightBrace.Position)
+		case *StructLiteralExpr:
+			mark(n.RightBrace.Position)
+		}
+		mark(n.Pos())
+		return true
+	}, nil)
+
+	// above[line] is the comment alone on the line just above line
+	above := make(map[int]*Comment)
+	for _, comment := range file.Comments {
+		if comment == nil {
+			continue
+		}
+		start, end := comment.Pos(), comment.End()
+		if col, ok := first[start.Line]; ok && col < start.Column {
+			continue
+		}
+		if col, ok := last[end.Line]; ok && col >= end.Column {
+			continue
+		}
+		above[end.Line+1] = comment
+	}
+
+	// Every declaration is assigned, clearing what an earlier call attached
+	for _, decl := range decls {
+		comment := above[decl.Pos().Line]
+		switch d := decl.(type) {
+		case *FuncDecl:
+			d.LeadingComment = comment
+		case *VarDecl:
+			d.LeadingComment = comment
+		case *TypeDecl:
+			d.LeadingComment = comment
+		case *StructDecl:
+			d.LeadingComment = comment
+		}
+	}
+}
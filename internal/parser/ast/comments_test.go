@@ -0,0 +1,133 @@
+package ast_test
+
+import (
+	"testing"
+
+	"github.com/hassan/compiler/internal/lexer"
+	"github.com/hassan/compiler/internal/parser"
+	"github.com/hassan/compiler/internal/parser/ast"
+)
+
+// parseSource parses source, failing the test on any error.
+func parseSource(t *testing.T, source string) *ast.File {
+	t.Helper()
+	file, errs := parser.New(lexer.New(source, "test.src")).ParseFile("test.src")
+	if len(errs) > 0 {
+		t.Fatalf("parse errors: %v", errs)
+	}
+	return file
+}
+
+// leadingComment returns the LeadingComment of a declaration.
+func leadingComment(decl ast.Decl) *ast.Comment {
+	switch d := decl.(type) {
+	case *ast.FuncDecl:
+		return d.LeadingComment
+	case *ast.VarDecl:
+		return d.LeadingComment
+	case *ast.TypeDecl:
+		return d.LeadingComment
+	case *ast.StructDecl:
+		return d.LeadingComment
+	}
+	return nil
+}
+
+func TestAttachComments(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string // the last declaration's comment, "" for none
+	}{
+		{
+			name:   "line comment",
+			source: "package main\n\n// add returns a + b\nfunc add(a int, b int) int {\n    return a + b;\n}\n",
+			want:   "// add returns a + b",
+		},
+		{
+			name:   "blank line in between",
+			source: "package main\n\n// not about add\n\nfunc add(a int, b int) int {\n    return a + b;\n}\n",
+		},
+		{
+			name:   "block comment",
+			source: "package main\n\n/* Point is a position\n   on the grid */\nstruct Point {\n    x int;\n    y int;\n}\n",
+			want:   "/* Point is a position\n   on the grid */",
+		},
+		{
+			name:   "nearest line of a run",
+			source: "package main\n\n// first\n// second\ntype Num = int;\n",
+			want:   "// second",
+		},
+		{
+			name:   "global variable",
+			source: "package main\n\n// count of calls\nvar count int = 0;\n",
+			want:   "// count of calls",
+		},
+		{
+			name:   "trailing comment on the line above",
+			source: "package main\n\nvar a int = 1; // a\nvar b int = 2;\n",
+		},
+		{
+			name:   "trailing comment after a closing brace",
+			source: "package main\n\nfunc f() {\n} // end of f\nfunc g() {\n}\n",
+		},
+		{
+			name:   "comment leading code on its own line",
+			source: "package main\n\n/* a */ var a int = 1;\nvar b int = 2;\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := parseSource(t, tt.source)
+			ast.AttachComments(file)
+
+			decl := file.Decls[len(file.Decls)-1]
+			comment := leadingComment(decl)
+			got := ""
+			if comment != nil {
+				got = comment.Text
+			}
+			if got != tt.want {
+				t.Errorf("expected %T to have comment %q, got %q", decl, tt.want, got)
+			}
+			if comment != nil && !containsComment(file.Comments, comment) {
+				t.Error("expected the leading comment to be one of file.Comments")
+			}
+		})
+	}
+}
+
+func TestAttachComments_Rerun(t *testing.T) {
+	file := parseSource(t, "package main\n\n// doc\nfunc f() {\n}\n")
+	ast.AttachComments(file)
+	file.Comments = nil
+	ast.AttachComments(file)
+
+	if c := file.Decls[0].(*ast.FuncDecl).LeadingComment; c != nil {
+		t.Errorf("expected a removed comment to be detached, got %q", c.Text)
+	}
+}
+
+func TestClone_LeadingComment(t *testing.T) {
+	file := parseSource(t, "package main\n\n// doc\nfunc f() {\n}\n")
+	ast.AttachComments(file)
+	clone := ast.Clone(file).(*ast.File)
+
+	comment := clone.Decls[0].(*ast.FuncDecl).LeadingComment
+	if comment != clone.Comments[0] {
+		t.Error("expected the cloned function's comment to be the cloned file's comment")
+	}
+	if comment == file.Comments[0] {
+		t.Error("expected the comment to be copied")
+	}
+}
+
+func containsComment(comments []*ast.Comment, comment *ast.Comment) bool {
+	for _, c := range comments {
+		if c == comment {
+			return true
+		}
+	}
+	return false
+}
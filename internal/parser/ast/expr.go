@@ -1,6 +1,8 @@
 package ast
 
 import (
+	"unicode/utf8"
+
 	"github.com/hassan/compiler/internal/lexer"
 )
 
@@ -85,12 +87,9 @@ type LiteralExpr struct {
 
 func (l *LiteralExpr) Pos() lexer.Position { return l.Token.Position }
 func (l *LiteralExpr) End() lexer.Position {
-	return lexer.Position{
-		Filename: l.Token.Position.Filename,
-		Line:     l.Token.Position.Line,
-		Column:   l.Token.Position.Column + len(l.Token.Lexeme),
-		Offset:   l.Token.Position.Offset + l.Token.Length,
-	}
+	// The lexeme is the literal as written, so a string's end counts its
+	// quotes and escapes, and its runes rather than bytes
+	return l.Token.Span().End
 }
 func (l *LiteralExpr) exprNode() {}
 func (l *LiteralExpr) Accept(v Visitor) (interface{}, error) {
@@ -114,7 +113,7 @@ func (i *IdentifierExpr) End() lexer.Position {
 	return lexer.Position{
 		Filename: i.Token.Position.Filename,
 		Line:     i.Token.Position.Line,
-		Column:   i.Token.Position.Column + len(i.Name),
+		Column:   i.Token.Position.Column + utf8.RuneCountInString(i.Name),
 		Offset:   i.Token.Position.Offset + len(i.Name),
 	}
 }
@@ -287,11 +286,12 @@ func (a *ArrayLiteralExpr) Accept(v Visitor) (interface{}, error) {
 	return v.VisitArrayLiteralExpr(a)
 }
 
-// StructLiteralExpr represents struct literals: Point{x: 1, y: 2}
+// StructLiteralExpr represents struct literals: Point{x: 1, y: 2}, or
+// positionally, Point{1, 2}
 //
 // COMPONENTS:
 // - Type: the struct type name
-// - Fields: field initializers (name: value pairs)
+// - Fields: field initializers (name: value pairs, or values alone)
 // - LeftBrace/RightBrace: for position tracking
 //
 // DESIGN CHOICE: Store fields as a slice of FieldInit rather than a map because:
@@ -312,12 +312,19 @@ func (s *StructLiteralExpr) Accept(v Visitor) (interface{}, error) {
 	return v.VisitStructLiteralExpr(s)
 }
 
-// FieldInit represents a field initializer in a struct literal: name: value
+// FieldInit represents a field initializer in a struct literal: name: value.
+// In a positional literal Name is nil and the field is the one declared at
+// the initializer's index.
 type FieldInit struct {
 	Name  *IdentifierExpr
 	Colon lexer.Token
 	Value Expr
 }
 
-func (f *FieldInit) Pos() lexer.Position { return f.Name.Pos() }
+func (f *FieldInit) Pos() lexer.Position {
+	if f.Name == nil {
+		return f.Value.Pos()
+	}
+	return f.Name.Pos()
+}
 func (f *FieldInit) End() lexer.Position { return f.Value.End() }
@@ -0,0 +1,54 @@
+package ast
+
+import "github.com/hassan/compiler/internal/lexer"
+
+// FindNodeAt returns the innermost node of file whose span, from Pos() to
+// End(), contains pos, or nil if pos is outside every declaration. This is
+// the question an editor asks for hover, go-to-definition and the like:
+// "what is under the cursor?"
+//
+// EXAMPLE: with the cursor on the x of "return p.x + 1;"
+//
+//	ReturnStmt       contains it
+//	  BinaryExpr     contains it
+//	    MemberExpr   contains it
+//	      p          doesn't
+//	      x          contains it <- returned
+//
+// Spans are inclusive of End (see lexer.Span.Contains), so a cursor just
+// after an identifier, where an editor leaves it at the end of a word,
+// still finds the identifier. Where one node ends exactly as another
+// begins, the deeper of the two wins, and between two at the same depth
+// the one starting at pos: the cursor is on its first character.
+//
+// DESIGN CHOICE: A pruned Walk rather than a search by position down the
+// tree. Walk already knows how to reach every child of every node kind, and
+// a node whose span doesn't contain pos can't have a child that does, so
+// returning false from pre skips whole declarations and the search touches
+// only the path to pos and the siblings along it. The File itself is never
+// pruned: its End is the end of its last declaration, so the comments after
+// that would be out of reach.
+func FindNodeAt(file *File, pos lexer.Position) Node {
+	var found Node
+	foundDepth, depth := -1, 0
+	Walk(file, func(node Node) bool {
+		if _, ok := node.(*File); !ok {
+			span := lexer.Span{Start: node.Pos(), End: node.End()}
+			if !span.Start.IsValid() || !span.Contains(pos) {
+				return false
+			}
+		}
+		depth++
+		if depth > foundDepth || (depth == foundDepth && node.Pos().Offset == pos.Offset) {
+			found, foundDepth = node, depth
+		}
+		return true
+	}, func(Node) bool {
+		depth--
+		return true
+	})
+	if _, ok := found.(*File); ok {
+		return nil
+	}
+	return found
+}
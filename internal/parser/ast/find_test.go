@@ -0,0 +1,58 @@
+package ast_test
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/hassan/compiler/internal/lexer"
+	"github.com/hassan/compiler/internal/parser/ast"
+)
+
+func TestFindNodeAt(t *testing.T) {
+	file := parseFixture(t, "walk.src")
+	source, err := os.ReadFile("testdata/walk.src")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		at   string // text in walk.src; the cursor is on its first occurrence
+		skip int    // bytes into at where the cursor is
+		want string
+	}{
+		{"a field in a member access", "p.x + p.y", 2, "x"},
+		{"the dot of a member access", "p.x + p.y", 1, "p"},
+		{"the end of a word", "total > limit", 5, "total"},
+		{"an operator", "total > limit", 6, "*ast.BinaryExpr"},
+		{"a parameter type", "p Point", 2, "Point"},
+		{"between a call's arguments", "origin, items", 7, "*ast.CallExpr"},
+		{"a call's closing paren", "items);", 5, "items"},
+		{"a statement keyword", "while", 0, "*ast.WhileStmt"},
+		{"a local declaration", "var origin", 1, "*ast.VarDecl"},
+		{"a struct field type", "    y int;", 6, "int"},
+		{"a comment", "classify buckets", 0, "*ast.Comment"},
+		{"between declarations", "\n\ntype Coord", 1, "<nil>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			offset := strings.Index(string(source), tt.at)
+			if offset < 0 {
+				t.Fatalf("%q not in walk.src", tt.at)
+			}
+			node := ast.FindNodeAt(file, lexer.Position{Line: 1, Offset: offset + tt.skip})
+
+			got := fmt.Sprintf("%T", node)
+			if ident, ok := node.(*ast.IdentifierExpr); ok {
+				got = ident.Name
+			} else if node == nil {
+				got = "<nil>"
+			}
+			if got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,410 @@
+package ast
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Print writes file to w as an indented tree, one node per line:
+//
+//	File
+//	  Package: main
+//	  FuncDecl: add
+//	    Param: a int
+//	    Returns: int
+//	    Body:
+//	      ReturnStmt
+//	        BinaryExpr: +
+//	          IdentifierExpr: a
+//	          IdentifierExpr: b
+//
+// Each line names the node type, followed by whatever identifies it (a name,
+// an operator, a literal's source text). Children are indented two spaces
+// under their parent. Where a node's children play different roles they are
+// grouped under a label ("Cond:", "Then:", "Body:"); where the order says it
+// all (the operands of a binary expression) they are printed bare.
+//
+// DESIGN CHOICE: The output is meant for people debugging the parser, so it
+// shows structure rather than positions. Types are printed inline after the
+// name they belong to ("Param: a int") because in this language they are
+// always plain identifiers, and a separate IdentifierExpr line for each one
+// would double the length of the dump.
+//
+// Print needs only the AST, so it works on any file that parsed, whether or
+// not the program would pass semantic analysis. It returns the first write
+// error, if any.
+func Print(file *File, w io.Writer) error {
+	p := &printer{w: w}
+	p.printFile(file)
+	return p.err
+}
+
+// printer is a Visitor that writes each node it visits at the current depth.
+type printer struct {
+	w     io.Writer
+	depth int
+	err   error
+}
+
+// line writes one line at the current depth.
+func (p *printer) line(format string, args ...interface{}) {
+	if p.err != nil {
+		return
+	}
+	_, p.err = fmt.Fprintf(p.w, "%s%s\n", strings.Repeat("  ", p.depth), fmt.Sprintf(format, args...))
+}
+
+// nested runs fn one level deeper.
+func (p *printer) nested(fn func()) {
+	p.depth++
+	fn()
+	p.depth--
+}
+
+// labeled writes a label line and runs fn one level below it.
+func (p *printer) labeled(label string, fn func()) {
+	p.line("%s:", label)
+	p.nested(fn)
+}
+
+// The helpers below skip nil children, which error recovery can leave behind.
+
+func (p *printer) expr(expr Expr) {
+	if expr != nil {
+		_, _ = expr.Accept(p)
+	}
+}
+
+func (p *printer) exprs(exprs []Expr) {
+	for _, expr := range exprs {
+		p.expr(expr)
+	}
+}
+
+func (p *printer) stmt(stmt Stmt) {
+	if stmt != nil {
+		_ = stmt.Accept(p)
+	}
+}
+
+func (p *printer) stmts(stmts []Stmt) {
+	for _, stmt := range stmts {
+		p.stmt(stmt)
+	}
+}
+
+// block prints the statements of a block without a BlockStmt line of its
+// own; the label above it already says where the block belongs.
+func (p *printer) block(block *BlockStmt) {
+	if block != nil {
+		p.stmts(block.Statements)
+	}
+}
+
+// typeName renders a type expression inline.
+func typeName(expr Expr) string {
+	if ident, ok := expr.(*IdentifierExpr); ok && ident != nil {
+		return ident.Name
+	}
+	if expr == nil {
+		return "<none>"
+	}
+	return fmt.Sprintf("%T", expr)
+}
+
+func identName(ident *IdentifierExpr) string {
+	if ident == nil {
+		return "<missing>"
+	}
+	return ident.Name
+}
+
+func (p *printer) printFile(file *File) {
+	p.line("File")
+	p.nested(func() {
+		if file.Package != nil {
+			p.line("Package: %s", identName(file.Package.Name))
+		}
+		for _, imp := range file.Imports {
+			if imp == nil || imp.Path == nil {
+				continue
+			}
+			if imp.Name != nil {
+				p.line("Import: %s %s", imp.Name.Name, imp.Path.Token.Lexeme)
+			} else {
+				p.line("Import: %s", imp.Path.Token.Lexeme)
+			}
+		}
+		for _, decl := range file.Decls {
+			if decl != nil {
+				_ = decl.Accept(p)
+			}
+		}
+	})
+}
+
+// Expressions
+
+func (p *printer) VisitBinaryExpr(expr *BinaryExpr) (interface{}, error) {
+	p.line("BinaryExpr: %s", expr.Operator.Lexeme)
+	p.nested(func() {
+		p.expr(expr.Left)
+		p.expr(expr.Right)
+	})
+	return nil, nil
+}
+
+func (p *printer) VisitUnaryExpr(expr *UnaryExpr) (interface{}, error) {
+	if expr.IsPostfix {
+		p.line("UnaryExpr: %s (postfix)", expr.Operator.Lexeme)
+	} else {
+		p.line("UnaryExpr: %s", expr.Operator.Lexeme)
+	}
+	p.nested(func() { p.expr(expr.Operand) })
+	return nil, nil
+}
+
+func (p *printer) VisitLiteralExpr(expr *LiteralExpr) (interface{}, error) {
+	p.line("LiteralExpr: %s", expr.Token.Lexeme)
+	return nil, nil
+}
+
+func (p *printer) VisitIdentifierExpr(expr *IdentifierExpr) (interface{}, error) {
+	p.line("IdentifierExpr: %s", expr.Name)
+	return nil, nil
+}
+
+func (p *printer) VisitCallExpr(expr *CallExpr) (interface{}, error) {
+	p.line("CallExpr")
+	p.nested(func() {
+		p.expr(expr.Callee)
+		if len(expr.Args) > 0 {
+			p.labeled("Args", func() { p.exprs(expr.Args) })
+		}
+	})
+	return nil, nil
+}
+
+func (p *printer) VisitIndexExpr(expr *IndexExpr) (interface{}, error) {
+	p.line("IndexExpr")
+	p.nested(func() {
+		p.expr(expr.Object)
+		p.expr(expr.Index)
+	})
+	return nil, nil
+}
+
+func (p *printer) VisitMemberExpr(expr *MemberExpr) (interface{}, error) {
+	p.line("MemberExpr: .%s", identName(expr.Member))
+	p.nested(func() { p.expr(expr.Object) })
+	return nil, nil
+}
+
+func (p *printer) VisitAssignmentExpr(expr *AssignmentExpr) (interface{}, error) {
+	p.line("AssignmentExpr: %s", expr.Operator.Lexeme)
+	p.nested(func() {
+		p.expr(expr.Target)
+		p.expr(expr.Value)
+	})
+	return nil, nil
+}
+
+func (p *printer) VisitLogicalExpr(expr *LogicalExpr) (interface{}, error) {
+	p.line("LogicalExpr: %s", expr.Operator.Lexeme)
+	p.nested(func() {
+		p.expr(expr.Left)
+		p.expr(expr.Right)
+	})
+	return nil, nil
+}
+
+func (p *printer) VisitGroupingExpr(expr *GroupingExpr) (interface{}, error) {
+	p.line("GroupingExpr")
+	p.nested(func() { p.expr(expr.Expression) })
+	return nil, nil
+}
+
+func (p *printer) VisitArrayLiteralExpr(expr *ArrayLiteralExpr) (interface{}, error) {
+	if expr.ElementType != nil {
+		p.line("ArrayLiteralExpr: %s", typeName(expr.ElementType))
+	} else {
+		p.line("ArrayLiteralExpr")
+	}
+	p.nested(func() { p.exprs(expr.Elements) })
+	return nil, nil
+}
+
+func (p *printer) VisitStructLiteralExpr(expr *StructLiteralExpr) (interface{}, error) {
+	p.line("StructLiteralExpr: %s", identName(expr.TypeName))
+	p.nested(func() {
+		for _, field := range expr.Fields {
+			if field == nil {
+				continue
+			}
+			if field.Name == nil {
+				p.line("FieldInit")
+			} else {
+				p.line("FieldInit: %s", identName(field.Name))
+			}
+			p.nested(func() { p.expr(field.Value) })
+		}
+	})
+	return nil, nil
+}
+
+// Statements
+
+func (p *printer) VisitExprStmt(stmt *ExprStmt) error {
+	p.line("ExprStmt")
+	p.nested(func() { p.expr(stmt.Expression) })
+	return nil
+}
+
+func (p *printer) VisitBlockStmt(stmt *BlockStmt) error {
+	p.line("BlockStmt")
+	p.nested(func() { p.stmts(stmt.Statements) })
+	return nil
+}
+
+func (p *printer) VisitIfStmt(stmt *IfStmt) error {
+	p.line("IfStmt")
+	p.nested(func() {
+		p.labeled("Cond", func() { p.expr(stmt.Condition) })
+		p.labeled("Then", func() { p.block(stmt.ThenBranch) })
+		if stmt.ElseBranch != nil {
+			p.labeled("Else", func() {
+				// An else block prints like a then block; an else-if
+				// keeps its IfStmt line
+				if block, ok := stmt.ElseBranch.(*BlockStmt); ok {
+					p.block(block)
+				} else {
+					p.stmt(stmt.ElseBranch)
+				}
+			})
+		}
+	})
+	return nil
+}
+
+func (p *printer) VisitWhileStmt(stmt *WhileStmt) error {
+	p.line("WhileStmt")
+	p.nested(func() {
+		p.labeled("Cond", func() { p.expr(stmt.Condition) })
+		p.labeled("Body", func() { p.block(stmt.Body) })
+	})
+	return nil
+}
+
+func (p *printer) VisitForStmt(stmt *ForStmt) error {
+	p.line("ForStmt")
+	p.nested(func() {
+		if stmt.Init != nil {
+			p.labeled("Init", func() { p.stmt(stmt.Init) })
+		}
+		if stmt.Condition != nil {
+			p.labeled("Cond", func() { p.expr(stmt.Condition) })
+		}
+		if stmt.Post != nil {
+			p.labeled("Post", func() { p.stmt(stmt.Post) })
+		}
+		p.labeled("Body", func() { p.block(stmt.Body) })
+	})
+	return nil
+}
+
+func (p *printer) VisitReturnStmt(stmt *ReturnStmt) error {
+	p.line("ReturnStmt")
+	p.nested(func() { p.expr(stmt.Value) })
+	return nil
+}
+
+func (p *printer) VisitBreakStmt(stmt *BreakStmt) error {
+	p.line("BreakStmt")
+	return nil
+}
+
+func (p *printer) VisitContinueStmt(stmt *ContinueStmt) error {
+	p.line("ContinueStmt")
+	return nil
+}
+
+func (p *printer) VisitSwitchStmt(stmt *SwitchStmt) error {
+	p.line("SwitchStmt")
+	p.nested(func() {
+		p.labeled("Value", func() { p.expr(stmt.Value) })
+		for _, clause := range stmt.Cases {
+			if clause == nil {
+				continue
+			}
+			if clause.IsDefault {
+				p.line("Default")
+			} else {
+				p.line("Case")
+			}
+			p.nested(func() {
+				p.exprs(clause.Values)
+				p.labeled("Body", func() { p.stmts(clause.Body) })
+			})
+		}
+	})
+	return nil
+}
+
+// Declarations
+
+func (p *printer) VisitVarDecl(decl *VarDecl) error {
+	names := make([]string, len(decl.Names))
+	for i, name := range decl.Names {
+		names[i] = identName(name)
+	}
+	if decl.Type != nil {
+		p.line("VarDecl: %s %s", strings.Join(names, ", "), typeName(decl.Type))
+	} else {
+		p.line("VarDecl: %s", strings.Join(names, ", "))
+	}
+	p.nested(func() { p.expr(decl.Initializer) })
+	return nil
+}
+
+func (p *printer) VisitFuncDecl(decl *FuncDecl) error {
+	p.line("FuncDecl: %s", identName(decl.Name))
+	p.nested(func() {
+		for _, param := range decl.Params {
+			if param != nil {
+				p.line("Param: %s %s", identName(param.Name), typeName(param.Type))
+			}
+		}
+		if decl.ReturnType != nil {
+			p.line("Returns: %s", typeName(decl.ReturnType))
+		}
+		if decl.Body != nil {
+			p.labeled("Body", func() { p.block(decl.Body) })
+		}
+	})
+	return nil
+}
+
+func (p *printer) VisitTypeDecl(decl *TypeDecl) error {
+	if decl.IsAlias {
+		p.line("TypeDecl: %s = %s", identName(decl.Name), typeName(decl.Type))
+	} else {
+		p.line("TypeDecl: %s %s", identName(decl.Name), typeName(decl.Type))
+	}
+	return nil
+}
+
+func (p *printer) VisitStructDecl(decl *StructDecl) error {
+	p.line("StructDecl: %s", identName(decl.Name))
+	p.nested(func() {
+		for _, field := range decl.Fields {
+			if field != nil {
+				p.line("Field: %s %s", identName(field.Name), typeName(field.Type))
+			}
+		}
+	})
+	return nil
+}
+
+// Compile-time check that printer handles every node kind.
+var _ Visitor = (*printer)(nil)
@@ -0,0 +1,36 @@
+package ast_test
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"testing"
+
+	"github.com/hassan/compiler/internal/parser/ast"
+)
+
+// update rewrites golden files with the current output instead of comparing.
+var update = flag.Bool("update", false, "update golden files")
+
+func TestPrint_Golden(t *testing.T) {
+	file := parseFixture(t, "print.src")
+
+	var buf bytes.Buffer
+	if err := ast.Print(file, &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	golden := "testdata/print.golden"
+	if *update {
+		if err := os.WriteFile(golden, buf.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != string(want) {
+		t.Errorf("AST dump differs from %s (run with -update to accept):\ngot:\n%s\nwant:\n%s", golden, buf.String(), want)
+	}
+}
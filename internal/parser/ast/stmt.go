@@ -226,13 +226,17 @@ func (c *ContinueStmt) Accept(v Visitor) error {
 //
 // This is simpler and safer than C-style switches.
 type SwitchStmt struct {
-	SwitchPos lexer.Position
-	Value     Expr // The value being switched on
-	Cases     []*CaseClause
+	SwitchPos  lexer.Position
+	Value      Expr // The value being switched on
+	Cases      []*CaseClause
+	RightBrace lexer.Token // Closing '}' (zero if the parser never reached it)
 }
 
 func (s *SwitchStmt) Pos() lexer.Position { return s.SwitchPos }
 func (s *SwitchStmt) End() lexer.Position {
+	if s.RightBrace.Lexeme != "" {
+		return s.RightBrace.Position
+	}
 	if len(s.Cases) > 0 {
 		return s.Cases[len(s.Cases)-1].End()
 	}
@@ -295,6 +299,10 @@ type VarDecl struct {
 	Names       []*IdentifierExpr
 	Type        Expr // Can be nil (type inference)
 	Initializer Expr // Can be nil (default initialization)
+
+	// LeadingComment is the comment on the line just above, set by
+	// AttachComments. It is also in File.Comments; Walk doesn't visit it.
+	LeadingComment *Comment
 }
 
 func (v *VarDecl) Pos() lexer.Position { return v.VarPos }
@@ -332,6 +340,9 @@ type FuncDecl struct {
 	Params     []*Parameter
 	ReturnType Expr // Can be nil for void
 	Body       *BlockStmt
+
+	// LeadingComment is the doc comment, set by AttachComments
+	LeadingComment *Comment
 }
 
 func (f *FuncDecl) Pos() lexer.Position { return f.FuncPos }
@@ -363,18 +374,28 @@ type Parameter struct {
 func (p *Parameter) Pos() lexer.Position { return p.Name.Pos() }
 func (p *Parameter) End() lexer.Position { return p.Type.End() }
 
-// TypeDecl represents a type alias declaration: type Name = OtherType
+// TypeDecl represents a type declaration, of one of two kinds:
+//   - An alias, "type Name = OtherType": Name is another spelling of
+//     OtherType, interchangeable with it
+//   - A named type, "type Name OtherType": a new type with OtherType's
+//     representation and operations, which mixes with OtherType (or other
+//     named types declared from it) only through a conversion, Name(x)
 //
-// EXAMPLE: type StringMap = map[string]string
+// EXAMPLE:
 //
-// DESIGN CHOICE: Type aliases (not new types) because:
-// - Simpler to implement
-// - Good enough for most use cases
-// - Can add "new types" later if needed
+//	type Count = int;    // a Count is an int
+//	type Meters int;     // a Meters is not an int, nor a Seconds
+//	type Seconds int;
 type TypeDecl struct {
 	TypePos lexer.Position
 	Name    *IdentifierExpr
 	Type    Expr
+
+	// IsAlias is true for "type Name = OtherType"
+	IsAlias bool
+
+	// LeadingComment is the doc comment, set by AttachComments
+	LeadingComment *Comment
 }
 
 func (t *TypeDecl) Pos() lexer.Position { return t.TypePos }
@@ -401,6 +422,9 @@ type StructDecl struct {
 	LeftBrace  lexer.Token
 	Fields     []*FieldDecl
 	RightBrace lexer.Token
+
+	// LeadingComment is the doc comment, set by AttachComments
+	LeadingComment *Comment
 }
 
 func (s *StructDecl) Pos() lexer.Position { return s.StructPos }
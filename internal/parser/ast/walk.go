@@ -0,0 +1,271 @@
+package ast
+
+// Walk traverses an AST in depth-first order.
+//
+// pre is called for node before its children and post after them. If pre
+// returns false, Walk skips the children (but not the siblings) of that node,
+// and post is not called for it. If post returns false, Walk stops: no further
+// node is visited, so a search can end as soon as it has found what it was
+// looking for. Either callback may be nil.
+//
+// DESIGN CHOICE: Walk complements the Visitor interface rather than replacing
+// it. A Visitor is the right tool when every node kind needs handling and a
+// result flows back up the tree (the type checker, the IR builder). Most
+// ad-hoc analyses - "find every call", "count the identifiers", "rename x to
+// y" - only care about one or two node kinds, and implementing 25 Visit
+// methods for that is pure noise. Walk gives those analyses a callback or
+// two, the same trade-off go/ast makes with ast.Inspect.
+//
+// Unlike Accept, Walk reaches the nodes that are not Expr/Stmt/Decl: File,
+// PackageDecl, ImportDecl, Comment, Parameter, FieldDecl, FieldInit and
+// CaseClause. Optional children that are nil (a missing else branch, a
+// function without a return type, a node dropped by parser error recovery)
+// are skipped, so the callbacks never receive nil.
+func Walk(node Node, pre, post func(Node) bool) {
+	if pre == nil {
+		pre = func(Node) bool { return true }
+	}
+	(&walker{pre: pre, post: post}).walk(node)
+}
+
+// Inspect traverses an AST in depth-first order, like go/ast.Inspect.
+//
+// fn(node) is called before node's children; if it returns true the children
+// are inspected and then fn(nil) is called. The trailing nil call lets callers
+// keep a stack of enclosing nodes (push on non-nil, pop on nil) without a
+// separate post-order hook.
+func Inspect(node Node, fn func(Node) bool) {
+	Walk(node, fn, func(Node) bool {
+		fn(nil)
+		return true
+	})
+}
+
+// walker carries the callbacks through the recursion. post runs after a
+// node's children, and only for nodes whose pre callback returned true;
+// stopped is set once post has returned false.
+type walker struct {
+	pre     func(Node) bool
+	post    func(Node) bool
+	stopped bool
+}
+
+func (w *walker) walk(node Node) {
+	if node == nil || w.stopped || !w.pre(node) {
+		return
+	}
+
+	switch n := node.(type) {
+	// Root and file-level nodes
+	case *File:
+		if n.Package != nil {
+			w.walk(n.Package)
+		}
+		for _, imp := range n.Imports {
+			if imp != nil {
+				w.walk(imp)
+			}
+		}
+		for _, decl := range n.Decls {
+			w.walkDecl(decl)
+		}
+		for _, comment := range n.Comments {
+			if comment != nil {
+				w.walk(comment)
+			}
+		}
+
+	case *PackageDecl:
+		w.walkIdent(n.Name)
+
+	case *ImportDecl:
+		w.walkIdent(n.Name)
+		if n.Path != nil {
+			w.walk(n.Path)
+		}
+
+	case *Comment:
+		// Leaf
+
+	// Expressions
+	case *BinaryExpr:
+		w.walkExpr(n.Left)
+		w.walkExpr(n.Right)
+
+	case *UnaryExpr:
+		w.walkExpr(n.Operand)
+
+	case *LiteralExpr, *IdentifierExpr:
+		// Leaves
+
+	case *CallExpr:
+		w.walkExpr(n.Callee)
+		w.walkExprs(n.Args)
+
+	case *IndexExpr:
+		w.walkExpr(n.Object)
+		w.walkExpr(n.Index)
+
+	case *MemberExpr:
+		w.walkExpr(n.Object)
+		w.walkIdent(n.Member)
+
+	case *AssignmentExpr:
+		w.walkExpr(n.Target)
+		w.walkExpr(n.Value)
+
+	case *LogicalExpr:
+		w.walkExpr(n.Left)
+		w.walkExpr(n.Right)
+
+	case *GroupingExpr:
+		w.walkExpr(n.Expression)
+
+	case *ArrayLiteralExpr:
+		w.walkExpr(n.ElementType)
+		w.walkExprs(n.Elements)
+
+	case *StructLiteralExpr:
+		w.walkIdent(n.TypeName)
+		for _, field := range n.Fields {
+			if field != nil {
+				w.walk(field)
+			}
+		}
+
+	case *FieldInit:
+		w.walkIdent(n.Name)
+		w.walkExpr(n.Value)
+
+	// Statements
+	case *ExprStmt:
+		w.walkExpr(n.Expression)
+
+	case *BlockStmt:
+		w.walkStmts(n.Statements)
+
+	case *IfStmt:
+		w.walkExpr(n.Condition)
+		if n.ThenBranch != nil {
+			w.walk(n.ThenBranch)
+		}
+		w.walkStmt(n.ElseBranch)
+
+	case *WhileStmt:
+		w.walkExpr(n.Condition)
+		if n.Body != nil {
+			w.walk(n.Body)
+		}
+
+	case *ForStmt:
+		w.walkStmt(n.Init)
+		w.walkExpr(n.Condition)
+		w.walkStmt(n.Post)
+		if n.Body != nil {
+			w.walk(n.Body)
+		}
+
+	case *ReturnStmt:
+		w.walkExpr(n.Value)
+
+	case *BreakStmt, *ContinueStmt:
+		// Leaves
+
+	case *SwitchStmt:
+		w.walkExpr(n.Value)
+		for _, clause := range n.Cases {
+			if clause != nil {
+				w.walk(clause)
+			}
+		}
+
+	case *CaseClause:
+		w.walkExprs(n.Values)
+		w.walkStmts(n.Body)
+
+	// Declarations
+	case *VarDecl:
+		for _, name := range n.Names {
+			w.walkIdent(name)
+		}
+		w.walkExpr(n.Type)
+		w.walkExpr(n.Initializer)
+
+	case *FuncDecl:
+		w.walkIdent(n.Name)
+		for _, param := range n.Params {
+			if param != nil {
+				w.walk(param)
+			}
+		}
+		w.walkExpr(n.ReturnType)
+		if n.Body != nil {
+			w.walk(n.Body)
+		}
+
+	case *Parameter:
+		w.walkIdent(n.Name)
+		w.walkExpr(n.Type)
+
+	case *TypeDecl:
+		w.walkIdent(n.Name)
+		w.walkExpr(n.Type)
+
+	case *StructDecl:
+		w.walkIdent(n.Name)
+		for _, field := range n.Fields {
+			if field != nil {
+				w.walk(field)
+			}
+		}
+
+	case *FieldDecl:
+		w.walkIdent(n.Name)
+		w.walkExpr(n.Type)
+	}
+
+	if w.post != nil && !w.stopped && !w.post(node) {
+		w.stopped = true
+	}
+}
+
+// The helpers below exist because the AST stores children as interfaces
+// (Expr, Stmt, Decl) that may be nil, and as typed pointers that may be nil
+// after error recovery. Passing either straight to Walk would hand fn a nil
+// (or a typed-nil) Node.
+
+func (w *walker) walkExpr(expr Expr) {
+	if expr != nil {
+		w.walk(expr)
+	}
+}
+
+func (w *walker) walkExprs(exprs []Expr) {
+	for _, expr := range exprs {
+		w.walkExpr(expr)
+	}
+}
+
+func (w *walker) walkStmt(stmt Stmt) {
+	if stmt != nil {
+		w.walk(stmt)
+	}
+}
+
+func (w *walker) walkStmts(stmts []Stmt) {
+	for _, stmt := range stmts {
+		w.walkStmt(stmt)
+	}
+}
+
+func (w *walker) walkDecl(decl Decl) {
+	if decl != nil {
+		w.walk(decl)
+	}
+}
+
+func (w *walker) walkIdent(ident *IdentifierExpr) {
+	if ident != nil {
+		w.walk(ident)
+	}
+}
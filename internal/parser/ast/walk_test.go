@@ -0,0 +1,272 @@
+package ast_test
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/hassan/compiler/internal/lexer"
+	"github.com/hassan/compiler/internal/parser"
+	"github.com/hassan/compiler/internal/parser/ast"
+)
+
+// parseFixture parses a file from testdata, failing the test on any error.
+func parseFixture(t *testing.T, name string) *ast.File {
+	t.Helper()
+	path := "testdata/" + name
+	source, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, errs := parser.New(lexer.New(string(source), path)).ParseFile(path)
+	if len(errs) > 0 {
+		t.Fatalf("parse errors: %v", errs)
+	}
+	return file
+}
+
+// countKinds tallies the nodes Walk reports by their Go type.
+func countKinds(node ast.Node, fn func(ast.Node) bool) map[string]int {
+	counts := make(map[string]int)
+	ast.Walk(node, func(n ast.Node) bool {
+		counts[fmt.Sprintf("%T", n)]++
+		if fn != nil {
+			return fn(n)
+		}
+		return true
+	}, nil)
+	return counts
+}
+
+func TestWalk_CountsNodeKinds(t *testing.T) {
+	file := parseFixture(t, "walk.src")
+	counts := countKinds(file, nil)
+
+	expected := map[string]int{
+		"*ast.File":              1,
+		"*ast.PackageDecl":       1,
+		"*ast.ImportDecl":        2,
+		"*ast.Comment":           2,
+		"*ast.StructDecl":        1,
+		"*ast.FieldDecl":         2,
+		"*ast.TypeDecl":          1,
+		"*ast.FuncDecl":          1,
+		"*ast.Parameter":         2,
+		"*ast.StructLiteralExpr": 1,
+		"*ast.FieldInit":         2,
+		"*ast.ArrayLiteralExpr":  1,
+		"*ast.IndexExpr":         1,
+		"*ast.MemberExpr":        2,
+		"*ast.LogicalExpr":       1,
+		"*ast.GroupingExpr":      1,
+		"*ast.UnaryExpr":         2,
+		"*ast.CallExpr":          1,
+		"*ast.IfStmt":            1,
+		"*ast.WhileStmt":         1,
+		"*ast.ForStmt":           1,
+		"*ast.SwitchStmt":        1,
+		"*ast.CaseClause":        2,
+		"*ast.BreakStmt":         1,
+		"*ast.ContinueStmt":      1,
+		"*ast.ReturnStmt":        3,
+		"*ast.VarDecl":           4,
+	}
+
+	for kind, want := range expected {
+		if got := counts[kind]; got != want {
+			t.Errorf("%s: expected %d, got %d", kind, want, got)
+		}
+	}
+}
+
+func TestWalk_SkipChildren(t *testing.T) {
+	file := parseFixture(t, "walk.src")
+
+	// Pruning at the function skips everything inside it, but its siblings
+	// (the struct and type declarations) are still visited.
+	counts := countKinds(file, func(n ast.Node) bool {
+		_, isFunc := n.(*ast.FuncDecl)
+		return !isFunc
+	})
+
+	if counts["*ast.FuncDecl"] != 1 {
+		t.Errorf("expected the FuncDecl itself to be visited, got %d", counts["*ast.FuncDecl"])
+	}
+	for _, kind := range []string{"*ast.Parameter", "*ast.IfStmt", "*ast.CallExpr", "*ast.BlockStmt"} {
+		if counts[kind] != 0 {
+			t.Errorf("%s: expected children of FuncDecl to be skipped, got %d", kind, counts[kind])
+		}
+	}
+	if counts["*ast.FieldDecl"] != 2 {
+		t.Errorf("expected sibling struct fields to be visited, got %d", counts["*ast.FieldDecl"])
+	}
+}
+
+func TestWalk_PostOrder(t *testing.T) {
+	// a + b * c
+	expr := &ast.BinaryExpr{
+		Left:     &ast.IdentifierExpr{Name: "a"},
+		Operator: lexer.Token{Lexeme: "+"},
+		Right: &ast.BinaryExpr{
+			Left:     &ast.IdentifierExpr{Name: "b"},
+			Operator: lexer.Token{Lexeme: "*"},
+			Right:    &ast.IdentifierExpr{Name: "c"},
+		},
+	}
+	name := func(n ast.Node) string {
+		if ident, ok := n.(*ast.IdentifierExpr); ok {
+			return ident.Name
+		}
+		return n.(*ast.BinaryExpr).Operator.Lexeme
+	}
+
+	var pre, post []string
+	ast.Walk(expr, func(n ast.Node) bool {
+		pre = append(pre, name(n))
+		return true
+	}, func(n ast.Node) bool {
+		post = append(post, name(n))
+		return true
+	})
+
+	if got := fmt.Sprint(pre); got != "[+ a * b c]" {
+		t.Errorf("pre order: got %s", got)
+	}
+	if got := fmt.Sprint(post); got != "[a b c * +]" {
+		t.Errorf("post order: got %s", got)
+	}
+}
+
+func TestWalk_SkipsPostForSkippedNodes(t *testing.T) {
+	file := parseFixture(t, "walk.src")
+
+	posts := 0
+	ast.Walk(file, func(n ast.Node) bool {
+		_, isFunc := n.(*ast.FuncDecl)
+		return !isFunc
+	}, func(n ast.Node) bool {
+		if _, isFunc := n.(*ast.FuncDecl); isFunc {
+			t.Error("post called for a node whose children were skipped")
+		}
+		posts++
+		return true
+	})
+	if posts == 0 {
+		t.Error("expected post calls for the other nodes")
+	}
+}
+
+func TestWalk_StopEarly(t *testing.T) {
+	file := parseFixture(t, "walk.src")
+
+	// Stop at the first return statement: nothing after it is visited,
+	// including the post calls of the nodes enclosing it
+	var visited []string
+	returns := 0
+	ast.Walk(file, func(n ast.Node) bool {
+		visited = append(visited, fmt.Sprintf("%T", n))
+		return true
+	}, func(n ast.Node) bool {
+		if _, ok := n.(*ast.ReturnStmt); ok {
+			returns++
+			return false
+		}
+		if _, ok := n.(*ast.FuncDecl); ok {
+			t.Error("post called for the function after the walk stopped")
+		}
+		return true
+	})
+
+	if returns != 1 {
+		t.Errorf("expected to stop at the first return, saw %d", returns)
+	}
+	if last := visited[len(visited)-1]; last != "*ast.LiteralExpr" {
+		t.Errorf("expected the returned literal to be the last node visited, got %s", last)
+	}
+	for _, kind := range visited {
+		if kind == "*ast.Comment" {
+			t.Error("expected the comments after the function not to be visited")
+		}
+	}
+}
+
+func TestWalk_Rename(t *testing.T) {
+	file := parseFixture(t, "walk.src")
+
+	// Find-and-replace needs nothing but the nodes themselves
+	ast.Walk(file, func(n ast.Node) bool {
+		if ident, ok := n.(*ast.IdentifierExpr); ok && ident.Name == "total" {
+			ident.Name = "sum"
+		}
+		return true
+	}, nil)
+
+	var names []string
+	ast.Walk(file, func(n ast.Node) bool {
+		if ident, ok := n.(*ast.IdentifierExpr); ok && (ident.Name == "total" || ident.Name == "sum") {
+			names = append(names, ident.Name)
+		}
+		return true
+	}, nil)
+	if len(names) == 0 {
+		t.Fatal("expected to find the renamed variable")
+	}
+	for _, name := range names {
+		if name != "sum" {
+			t.Errorf("expected every total to be renamed, found %s", name)
+		}
+	}
+}
+
+func TestInspect_BalancedNilCalls(t *testing.T) {
+	file := parseFixture(t, "walk.src")
+
+	depth, maxDepth, visited := 0, 0, 0
+	ast.Inspect(file, func(n ast.Node) bool {
+		if n == nil {
+			depth--
+			return false
+		}
+		visited++
+		depth++
+		if depth > maxDepth {
+			maxDepth = depth
+		}
+		return true
+	})
+
+	if depth != 0 {
+		t.Errorf("expected every node to be followed by a nil call, final depth %d", depth)
+	}
+	if visited == 0 || maxDepth < 5 {
+		t.Errorf("expected a deep traversal, visited %d nodes with max depth %d", visited, maxDepth)
+	}
+}
+
+// identCollector overrides a single method; BaseVisitor supplies the rest.
+type identCollector struct {
+	ast.BaseVisitor
+	names []string
+}
+
+func (c *identCollector) VisitIdentifierExpr(expr *ast.IdentifierExpr) (interface{}, error) {
+	c.names = append(c.names, expr.Name)
+	return nil, nil
+}
+
+func TestBaseVisitor(t *testing.T) {
+	c := &identCollector{}
+	var v ast.Visitor = c
+
+	ident := &ast.IdentifierExpr{Name: "x"}
+	if _, err := ident.Accept(v); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := (&ast.BreakStmt{}).Accept(v); err != nil {
+		t.Fatalf("unexpected error from no-op method: %v", err)
+	}
+
+	if len(c.names) != 1 || c.names[0] != "x" {
+		t.Errorf("expected override to be called once with x, got %v", c.names)
+	}
+}
@@ -0,0 +1,45 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hassan/compiler/internal/lexer"
+)
+
+// benchmarkSource is about 1MB of small functions.
+//
+// The benchmarks report allocations too: most of the parser's time goes to
+// allocating nodes (see slab.go), so allocs/op is the number to watch.
+var benchmarkSource = func() string {
+	var sb strings.Builder
+	sb.WriteString("package main\n\n")
+	for sb.Len() < 1<<20 {
+		sb.WriteString("// compute returns a value derived from its arguments\n")
+		sb.WriteString("func compute(a int, b int) int {\n")
+		sb.WriteString("    var total int = a * 31 + b;\n")
+		sb.WriteString("    if (total >= 1024 && b != 0) {\n")
+		sb.WriteString("        total = total / b - 17;\n")
+		sb.WriteString("    }\n")
+		sb.WriteString("    return total << 2;\n")
+		sb.WriteString("}\n\n")
+	}
+	return sb.String()
+}()
+
+func BenchmarkParse(b *testing.B) {
+	b.SetBytes(int64(len(benchmarkSource)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		New(lexer.New(benchmarkSource, "bench.src")).ParseFile("bench.src")
+	}
+}
+
+func BenchmarkParseFromTokens(b *testing.B) {
+	b.SetBytes(int64(len(benchmarkSource)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		tokens, errs := lexer.Tokenize(benchmarkSource, "bench.src")
+		NewFromTokens(tokens, errs).ParseFile("bench.src")
+	}
+}
@@ -0,0 +1,101 @@
+package parser
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hassan/compiler/internal/lexer"
+	"github.com/hassan/compiler/internal/parser/ast"
+)
+
+// fuzzSeeds are the seed corpus: a valid program, and the broken ones error
+// recovery has the most trouble with.
+var fuzzSeeds = []string{
+	"package main\n\nstruct Point {\n    x int;\n    y int;\n}\n\nfunc main() int {\n    var p Point = Point{x: 1, y: 2};\n    if (p.x < p.y) {\n        return p.x;\n    }\n    return p.y;\n}\n",
+	"",
+	"package",
+	"package main\nimport",
+	"package main\nimport alias",
+
+	// Malformed struct declarations
+	"package main\nstruct {",
+	"package main\nstruct P {\n    x;\n    int y;\n}\n",
+	"package main\nstruct P { x int y int }",
+	"package main\nstruct P { x [int; }",
+	"package main\nstruct P { x int; ",
+
+	// Mismatched braces, brackets and parentheses
+	"package main\nfunc f() { { }",
+	"package main\nfunc f() } }",
+	"package main\nfunc f() { if (x { return; } }",
+	"package main\nfunc f() { var a = [1, 2; }",
+	"package main\nfunc f() { g(1, (2, 3); }",
+	"package main\n}}}}{{{{",
+
+	// Truncated function bodies and declarations
+	"package main\nfunc",
+	"package main\nfunc f(",
+	"package main\nfunc f(a int, ",
+	"package main\nfunc f() int {\n    return",
+	"package main\nfunc f() {\n    switch (x) {\n    case 1:",
+	"package main\nfunc f() {\n    for (var i = 0; i <",
+	"package main\nvar x int =",
+	"package main\ntype T =",
+	"package main\nfunc f() { x = P{a: ; }",
+	"package main\nfunc f() { return *&*; }",
+	"func A(){;}",
+
+	// A token missing where a lexical error is
+	"func A{0if 0\"",
+}
+
+// FuzzParser parses arbitrary input and checks what must hold for any input
+// at all: ParseFile doesn't panic, in TolerantMode or not, whatever the
+// errors it recovers from, and what it returns is a tree that later phases
+// and tools can walk: a file, nodes that all say where they are, and
+// errors that are all there.
+//
+// Run it for longer than the seed corpus with
+//
+//	go test ./internal/parser -fuzz=FuzzParser -fuzztime=10s
+func FuzzParser(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add([]byte(seed))
+	}
+	programs, _ := filepath.Glob("../../testdata/*/*.src")
+	for _, path := range programs {
+		if data, err := os.ReadFile(path); err == nil {
+			f.Add(data)
+		}
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, tolerant := range []bool{false, true} {
+			p := New(lexer.New(string(data), "fuzz"))
+			p.TolerantMode = tolerant
+			file, errs := p.ParseFile("fuzz")
+			if file == nil {
+				t.Fatal("ParseFile returned no file")
+			}
+			for i, err := range errs {
+				if err == nil {
+					t.Fatalf("error %d of %d is nil", i, len(errs))
+				}
+			}
+
+			ast.Inspect(file, func(node ast.Node) bool {
+				if node == nil {
+					return true
+				}
+				if _, ok := node.(*ast.File); ok {
+					return true
+				}
+				if pos := node.Pos(); pos.Line <= 0 {
+					t.Fatalf("%T has no position (%v)", node, pos)
+				}
+				return true
+			})
+		}
+	})
+}
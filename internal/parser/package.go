@@ -0,0 +1,57 @@
+package parser
+
+import (
+	"github.com/hassan/compiler/internal/parser/ast"
+)
+
+// PackageSet holds the parsed files of one package.
+//
+// DESIGN CHOICE: The files are kept separate rather than merged into one
+// *ast.File. Every node keeps the filename in its position, so diagnostics
+// already point into the right file, but tools that work per file - the
+// token and AST dumps, the formatter, comment attachment - still need each
+// file whole. PackageSet only adds the package-wide views on top.
+//
+// A package's files share one global scope: semantic analysis treats the
+// declarations of the set exactly as if they came from a single file, and
+// reports a name declared in two files at the second declaration.
+type PackageSet struct {
+	// Files are the parsed files, in the order they were given
+	Files []*ast.File
+}
+
+// NewPackageSet groups already-parsed files into a package.
+func NewPackageSet(files []*ast.File) *PackageSet {
+	return &PackageSet{Files: files}
+}
+
+// Name returns the package name declared by the first file, or "" if it has
+// none - a package clause that failed to parse included. Semantic analysis
+// checks that every file agrees with it.
+func (s *PackageSet) Name() string {
+	if len(s.Files) == 0 || s.Files[0].Package == nil || s.Files[0].Package.Name == nil {
+		return ""
+	}
+	return s.Files[0].Package.Name.Name
+}
+
+// Decls returns the top-level declarations of every file, file by file in
+// order, each file's in source order.
+func (s *PackageSet) Decls() []ast.Decl {
+	var decls []ast.Decl
+	for _, file := range s.Files {
+		decls = append(decls, file.Decls...)
+	}
+	return decls
+}
+
+// Imports returns the import declarations of every file, in the same order
+// as Decls. The same path imported by two files appears twice: imports are
+// per file, even though what they import is shared.
+func (s *PackageSet) Imports() []*ast.ImportDecl {
+	var imports []*ast.ImportDecl
+	for _, file := range s.Files {
+		imports = append(imports, file.Imports...)
+	}
+	return imports
+}
@@ -0,0 +1,87 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/hassan/compiler/internal/lexer"
+	"github.com/hassan/compiler/internal/parser/ast"
+)
+
+// parseSources parses each (filename, text) pair on its own, as the driver
+// does, and fails the test on any syntax error.
+func parseSources(t *testing.T, sources ...[2]string) []*ast.File {
+	t.Helper()
+	files := make([]*ast.File, len(sources))
+	for i, src := range sources {
+		file, errs := New(lexer.New(src[1], src[0])).ParseFile(src[0])
+		for _, err := range errs {
+			t.Errorf("%s: %v", src[0], err)
+		}
+		files[i] = file
+	}
+	return files
+}
+
+func TestPackageSet(t *testing.T) {
+	// main.src calls a function that only math.src declares
+	files := parseSources(t,
+		[2]string{"main.src", "package main\n\nimport \"units\"\n\nfunc main() int {\n    return square(limit);\n}\n"},
+		[2]string{"math.src", "package main\n\nvar limit int = 10;\n\nfunc square(n int) int {\n    return n * n;\n}\n"},
+	)
+	set := NewPackageSet(files)
+
+	if set.Name() != "main" {
+		t.Errorf("expected package main, got %q", set.Name())
+	}
+
+	// Declarations come file by file, each in source order
+	want := []struct {
+		name, file string
+	}{
+		{"main", "main.src"},
+		{"limit", "math.src"},
+		{"square", "math.src"},
+	}
+	decls := set.Decls()
+	if len(decls) != len(want) {
+		t.Fatalf("expected %d declarations, got %d", len(want), len(decls))
+	}
+	for i, decl := range decls {
+		var name string
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			name = d.Name.Name
+		case *ast.VarDecl:
+			name = d.Names[0].Name
+		}
+		if name != want[i].name || decl.Pos().Filename != want[i].file {
+			t.Errorf("decl %d: expected %s from %s, got %s from %s",
+				i, want[i].name, want[i].file, name, decl.Pos().Filename)
+		}
+	}
+
+	if imports := set.Imports(); len(imports) != 1 || imports[0].Pos().Filename != "main.src" {
+		t.Errorf("expected the one import of main.src, got %v", imports)
+	}
+}
+
+func TestPackageSet_Empty(t *testing.T) {
+	set := NewPackageSet(nil)
+	if set.Name() != "" || len(set.Decls()) != 0 || len(set.Imports()) != 0 {
+		t.Errorf("expected an empty package, got name %q, %d decls, %d imports",
+			set.Name(), len(set.Decls()), len(set.Imports()))
+	}
+}
+
+func TestPackageSet_MissingName(t *testing.T) {
+	// The files of a package that failed to parse may have no package
+	// clause, or one with no name
+	for _, file := range []*ast.File{
+		{Filename: "none.src"},
+		{Filename: "unnamed.src", Package: &ast.PackageDecl{}},
+	} {
+		if name := NewPackageSet([]*ast.File{file}).Name(); name != "" {
+			t.Errorf("%s: expected no package name, got %q", file.Filename, name)
+		}
+	}
+}
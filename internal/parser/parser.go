@@ -20,14 +20,17 @@
 // ERROR HANDLING STRATEGY:
 // - Report errors but continue parsing (find multiple errors in one pass)
 // - Use panic/recover for error recovery at statement boundaries
+// - Or, in TolerantMode, take a missing token as present and carry on
 // - Return errors to caller for fine-grained control
 package parser
 
 import (
 	"fmt"
 	"strconv"
+	"strings"
 	"unicode/utf8"
 
+	"github.com/hassan/compiler/internal/errors"
 	"github.com/hassan/compiler/internal/lexer"
 	"github.com/hassan/compiler/internal/parser/ast"
 )
@@ -39,8 +42,12 @@ import (
 // - Error recovery needs access to parser state
 // - Recursive descent naturally fits object-oriented style
 type Parser struct {
-	// lexer is the source of tokens
-	lexer *lexer.Lexer
+	// lexer is the source of tokens, unless the parser was made by
+	// NewFromTokens, which reads tokens and lexErrors from index pos instead
+	lexer     *lexer.Lexer
+	tokens    []lexer.Token
+	lexErrors []error
+	pos       int
 
 	// current is the token we're currently examining
 	current lexer.Token
@@ -58,6 +65,30 @@ type Parser struct {
 	// panicMode tracks if we're in panic mode (recovering from an error)
 	// During panic mode, we skip tokens until we find a synchronization point
 	panicMode bool
+
+	// TolerantMode makes a missing token an error the parser carries on
+	// past, as if the token had been there, instead of one that abandons
+	// the statement or declaration it is in (see ParseFileTolerant)
+	TolerantMode bool
+
+	// advanced counts the tokens consumed so far, so that a loop over
+	// declarations or statements can tell whether its last one consumed
+	// anything (see skipIfStuck)
+	advanced int
+
+	// comments collects every comment in the file, in source order, for
+	// ParseFile to return in File.Comments
+	//
+	// DESIGN CHOICE: Comments are taken out of the token stream in advance,
+	// so no grammar rule ever sees one. A comment can sit between any two
+	// tokens - between statements, struct fields or parameters, inside an
+	// expression - and a rule that had to expect one everywhere would miss
+	// some place and turn a comment into a syntax error.
+	comments []*ast.Comment
+
+	// slabs are where new identifiers, literals and other small nodes are
+	// taken from (see slab.go)
+	slabs slabs
 }
 
 // New creates a new parser for the given lexer.
@@ -71,6 +102,30 @@ func New(l *lexer.Lexer) *Parser {
 	return p
 }
 
+// NewFromTokens creates a parser that reads tokens already lexed by
+// lexer.Tokenize, along with the errors Tokenize returned beside them.
+//
+// The parser reports each lexical error when it reaches the token the error
+// came with (the one starting at the error's offset), just as it would
+// reading from a Lexer, so both constructors give the same AST and the
+// same errors for the same source. At the end of tokens the parser sees
+// the final token, EOF, again and again.
+//
+// DESIGN CHOICE: The errors come in beside the tokens rather than being
+// dropped or reported up front. A token that failed to lex is still in the
+// slice (an INVALID token, or a malformed number), and parsing it without
+// its error would either hide the reason or, reported first, put every
+// lexical error before the syntax errors it caused.
+func NewFromTokens(tokens []lexer.Token, errs []error) *Parser {
+	p := &Parser{
+		tokens:    tokens,
+		lexErrors: errs,
+		errors:    make([]error, 0),
+	}
+	p.advance()
+	return p
+}
+
 // ParseFile parses a complete source file.
 //
 // GRAMMAR:
@@ -89,18 +144,10 @@ func (p *Parser) ParseFile(filename string) (*ast.File, []error) {
 		Comments: make([]*ast.Comment, 0),
 	}
 
-	// Skip any leading comments and collect them
-	for p.match(lexer.TokenComment) {
-		file.Comments = append(file.Comments, &ast.Comment{
-			Position: p.previous.Position,
-			Text:     p.previous.Lexeme,
-			IsBlock:  p.previous.Lexeme[1] == '*', // /* vs //
-		})
-	}
-
 	// Parse package declaration (required)
 	if p.match(lexer.TokenPackage) {
 		file.Package = p.parsePackageDecl()
+		p.optionalSemicolon()
 	} else {
 		p.error("expected 'package' declaration at start of file")
 	}
@@ -108,29 +155,100 @@ func (p *Parser) ParseFile(filename string) (*ast.File, []error) {
 	// Parse imports
 	for p.match(lexer.TokenImport) {
 		file.Imports = append(file.Imports, p.parseImportDecl())
+		p.optionalSemicolon()
 	}
 
 	// Parse top-level declarations
 	for !p.isAtEnd() {
-		// Skip comments
-		if p.match(lexer.TokenComment) {
-			file.Comments = append(file.Comments, &ast.Comment{
-				Position: p.previous.Position,
-				Text:     p.previous.Lexeme,
-				IsBlock:  p.previous.Lexeme[1] == '*',
-			})
-			continue
-		}
-
+		mark := p.advanced
 		decl := p.parseDecl()
 		if decl != nil {
 			file.Decls = append(file.Decls, decl)
 		}
+		p.skipIfStuck(mark)
 	}
 
+	file.Comments = append(file.Comments, p.comments...)
 	return file, p.errors
 }
 
+// ParseFileTolerant parses a complete source file in TolerantMode, for an
+// editor that wants as much of a file as it can get while the file is
+// being typed.
+//
+// A missing token - the ')' of an unclosed parameter list, the '}' at the
+// end of a function, a ';' - is reported and then treated as present, so
+// the declaration around it is still in the AST, complete but for the
+// token. Other mistakes (a missing operand, a keyword where a name must
+// be) abandon their statement or declaration as in ParseFile; every
+// declaration parsed without one is returned however many errors the file
+// has.
+//
+// DESIGN CHOICE: After an inserted token, errors stay hidden until the
+// parser next consumes a token it expected. A token that was missing is
+// often one of several missing or out of place - "x = 1 2 3;" - and
+// reporting the first is enough; each one after it would only be the
+// insertion's guess going wrong.
+func (p *Parser) ParseFileTolerant(filename string) (*ast.File, []error) {
+	p.TolerantMode = true
+	return p.ParseFile(filename)
+}
+
+// ParseExpr parses source that is a single expression and nothing else,
+// such as "x * 7" typed at the REPL. Anything after the expression is an
+// error, a semicolon included: "x * 7;" is a statement (see ParseInput).
+// The semicolon a newline after it stands for is not: "x * 7\n" is still
+// an expression.
+//
+// GRAMMAR:
+//   input = expr EOF
+//
+// On errors the expression is nil.
+func (p *Parser) ParseExpr() (expr ast.Expr, errs []error) {
+	defer func() {
+		if r := recover(); r != nil {
+			expr = nil
+		}
+		errs = p.errors
+	}()
+
+	expr = p.parseExpression()
+	if p.check(lexer.TokenSemicolon) && p.current.Lexeme == "\n" {
+		p.advance()
+	}
+	if !p.isAtEnd() {
+		p.error(fmt.Sprintf("expected end of expression, got %s", p.current.Type))
+	}
+	if len(p.errors) > 0 {
+		expr = nil
+	}
+	return expr, p.errors
+}
+
+// ParseInput parses declarations and statements in any order, with no
+// package clause: a line typed at the REPL.
+//
+// GRAMMAR:
+//   input = (funcDecl | typeDecl | structDecl | stmt)* EOF
+//
+// A var is parsed as a statement, which is also a declaration (ast.VarDecl
+// is both); where it declares its names is up to the caller.
+func (p *Parser) ParseInput() ([]ast.Node, []error) {
+	nodes := make([]ast.Node, 0)
+	for !p.isAtEnd() {
+		mark := p.advanced
+		if p.atTopLevelDecl() {
+			if decl := p.parseDecl(); decl != nil {
+				nodes = append(nodes, decl)
+			}
+		} else if stmt := p.parseStmt(); stmt != nil {
+			nodes = append(nodes, stmt)
+		}
+		p.skipIfStuck(mark)
+	}
+	return nodes, p.errors
+}
+
 // parsePackageDecl parses a package declaration: package name
 func (p *Parser) parsePackageDecl() *ast.PackageDecl {
 	// We've already consumed the 'package' keyword
@@ -141,10 +259,7 @@ func (p *Parser) parsePackageDecl() *ast.PackageDecl {
 		return nil
 	}
 
-	name := &ast.IdentifierExpr{
-		Token: p.current,
-		Name:  p.current.Lexeme,
-	}
+	name := p.newIdentifier(p.current)
 	p.advance()
 
 	return &ast.PackageDecl{
@@ -164,10 +279,7 @@ func (p *Parser) parseImportDecl() *ast.ImportDecl {
 
 	// Check for optional alias
 	if p.check(lexer.TokenIdentifier) {
-		name = &ast.IdentifierExpr{
-			Token: p.current,
-			Name:  p.current.Lexeme,
-		}
+		name = p.newIdentifier(p.current)
 		p.advance()
 	}
 
@@ -177,10 +289,7 @@ func (p *Parser) parseImportDecl() *ast.ImportDecl {
 		return nil
 	}
 
-	path := &ast.LiteralExpr{
-		Token: p.current,
-		Value: p.parseStringLiteral(p.current.Lexeme),
-	}
+	path := p.newLiteral(p.current, p.parseStringLiteral(p.current.Lexeme))
 	p.advance()
 
 	return &ast.ImportDecl{
@@ -204,19 +313,22 @@ func (p *Parser) parseDecl() ast.Decl {
 		}
 	}()
 
+	var decl ast.Decl
 	switch {
 	case p.match(lexer.TokenVar):
 		return p.parseVarDecl()
 	case p.match(lexer.TokenFunc):
-		return p.parseFuncDecl()
+		decl = p.parseFuncDecl()
 	case p.match(lexer.TokenTypeKeyword):
 		return p.parseTypeDecl()
 	case p.match(lexer.TokenStruct):
-		return p.parseStructDecl()
+		decl = p.parseStructDecl()
 	default:
 		p.error(fmt.Sprintf("expected declaration, got %s", p.current.Type))
 		panic("invalid declaration")
 	}
+	p.optionalSemicolon()
+	return decl
 }
 
 // parseVarDecl parses a variable declaration:
@@ -229,17 +341,18 @@ func (p *Parser) parseVarDecl() *ast.VarDecl {
 	varPos := p.previous.Position
 
 	// Parse variable names (can be multiple: var x, y, z int)
-	names := make([]*ast.IdentifierExpr, 0)
+	var names []*ast.IdentifierExpr
 	for {
 		if !p.check(lexer.TokenIdentifier) {
 			p.error("expected variable name")
 			panic("invalid variable declaration")
 		}
 
-		names = append(names, &ast.IdentifierExpr{
-			Token: p.current,
-			Name:  p.current.Lexeme,
-		})
+		if names == nil {
+			names = p.newNames(p.newIdentifier(p.current))
+		} else {
+			names = append(names, p.newIdentifier(p.current))
+		}
 		p.advance()
 
 		if !p.match(lexer.TokenComma) {
@@ -289,10 +402,7 @@ func (p *Parser) parseFuncDecl() *ast.FuncDecl {
 		panic("invalid function declaration")
 	}
 
-	name := &ast.IdentifierExpr{
-		Token: p.current,
-		Name:  p.current.Lexeme,
-	}
+	name := p.newIdentifier(p.current)
 	p.advance()
 
 	// Parse parameters
@@ -325,31 +435,25 @@ func (p *Parser) parseFuncDecl() *ast.FuncDecl {
 
 // parseParameters parses function parameters: name type, name type, ...
 func (p *Parser) parseParameters() []*ast.Parameter {
-	params := make([]*ast.Parameter, 0)
-
 	if p.check(lexer.TokenRightParen) {
 		// No parameters
-		return params
+		return make([]*ast.Parameter, 0)
 	}
 
+	params := make([]*ast.Parameter, 0, 4)
+
 	for {
 		if !p.check(lexer.TokenIdentifier) {
 			p.error("expected parameter name")
 			break
 		}
 
-		name := &ast.IdentifierExpr{
-			Token: p.current,
-			Name:  p.current.Lexeme,
-		}
+		name := p.newIdentifier(p.current)
 		p.advance()
 
 		typeExpr := p.parseType()
 
-		params = append(params, &ast.Parameter{
-			Name: name,
-			Type: typeExpr,
-		})
+		params = append(params, p.newParameter(name, typeExpr))
 
 		if !p.match(lexer.TokenComma) {
 			break
@@ -359,7 +463,8 @@ func (p *Parser) parseParameters() []*ast.Parameter {
 	return params
 }
 
-// parseTypeDecl parses a type alias declaration: type Name = Type
+// parseTypeDecl parses a type declaration: an alias, type Name = Type, or
+// a named type, type Name Type
 func (p *Parser) parseTypeDecl() *ast.TypeDecl {
 	// We've already consumed 'type'
 	typePos := p.previous.Position
@@ -370,14 +475,11 @@ func (p *Parser) parseTypeDecl() *ast.TypeDecl {
 		panic("invalid type declaration")
 	}
 
-	name := &ast.IdentifierExpr{
-		Token: p.current,
-		Name:  p.current.Lexeme,
-	}
+	name := p.newIdentifier(p.current)
 	p.advance()
 
-	// Expect '='
-	p.consume(lexer.TokenAssign, "expected '=' in type declaration")
+	// An '=' makes it an alias
+	isAlias := p.match(lexer.TokenAssign)
 
 	// Parse the type
 	typeExpr := p.parseType()
@@ -388,6 +490,7 @@ func (p *Parser) parseTypeDecl() *ast.TypeDecl {
 		TypePos: typePos,
 		Name:    name,
 		Type:    typeExpr,
+		IsAlias: isAlias,
 	}
 }
 
@@ -403,10 +506,7 @@ func (p *Parser) parseStructDecl() *ast.StructDecl {
 		panic("invalid struct declaration")
 	}
 
-	name := &ast.IdentifierExpr{
-		Token: p.current,
-		Name:  p.current.Lexeme,
-	}
+	name := p.newIdentifier(p.current)
 	p.advance()
 
 	// Parse fields
@@ -421,10 +521,7 @@ func (p *Parser) parseStructDecl() *ast.StructDecl {
 			break
 		}
 
-		fieldName := &ast.IdentifierExpr{
-			Token: p.current,
-			Name:  p.current.Lexeme,
-		}
+		fieldName := p.newIdentifier(p.current)
 		p.advance()
 
 		// Parse field type
@@ -453,23 +550,33 @@ func (p *Parser) parseStructDecl() *ast.StructDecl {
 
 // parseType parses a type expression.
 //
-// For now, we just parse identifiers as types.
+// A type is a name, or a pointer type: * followed by a type ("*int",
+// "**Point"). A pointer type is a UnaryExpr of *, the same node as the
+// dereference it is spelled like, and resolveType in the analyzer turns it
+// into a PointerType. "**" lexes as the power operator, and stands for two
+// stars here.
 // Later, we can extend this to support:
 // - Array types: []int, [10]int
-// - Pointer types: *int
 // - Function types: func(int) int
 // - Map types: map[string]int
 func (p *Parser) parseType() ast.Expr {
+	if p.check(lexer.TokenStar) || p.check(lexer.TokenStarStar) {
+		operator := p.current
+		p.advance()
+		elem := p.parseType()
+		if elem == nil {
+			return nil
+		}
+		return starsOf(operator, elem)
+	}
+
 	// For now, just parse identifier types
 	if !p.check(lexer.TokenIdentifier) {
 		p.error("expected type name")
 		return nil
 	}
 
-	typeExpr := &ast.IdentifierExpr{
-		Token: p.current,
-		Name:  p.current.Lexeme,
-	}
+	typeExpr := p.newIdentifier(p.current)
 	p.advance()
 
 	return typeExpr
@@ -489,15 +596,16 @@ func (p *Parser) parseStmt() ast.Stmt {
 		}
 	}()
 
+	var stmt ast.Stmt
 	switch {
 	case p.check(lexer.TokenLeftBrace):
-		return p.parseBlockStmt()
+		stmt = p.parseBlockStmt()
 	case p.match(lexer.TokenIf):
-		return p.parseIfStmt()
+		stmt = p.parseIfStmt()
 	case p.match(lexer.TokenWhile):
-		return p.parseWhileStmt()
+		stmt = p.parseWhileStmt()
 	case p.match(lexer.TokenFor):
-		return p.parseForStmt()
+		stmt = p.parseForStmt()
 	case p.match(lexer.TokenReturn):
 		return p.parseReturnStmt()
 	case p.match(lexer.TokenBreak):
@@ -505,12 +613,14 @@ func (p *Parser) parseStmt() ast.Stmt {
 	case p.match(lexer.TokenContinue):
 		return p.parseContinueStmt()
 	case p.match(lexer.TokenSwitch):
-		return p.parseSwitchStmt()
+		stmt = p.parseSwitchStmt()
 	case p.match(lexer.TokenVar):
 		return p.parseVarDecl()
 	default:
 		return p.parseExprStmt()
 	}
+	p.optionalSemicolon()
+	return stmt
 }
 
 // parseBlockStmt parses a block statement: { stmt* }
@@ -518,9 +628,13 @@ func (p *Parser) parseBlockStmt() *ast.BlockStmt {
 	p.consume(lexer.TokenLeftBrace, "expected '{'")
 	leftBrace := p.previous
 
-	statements := make([]ast.Stmt, 0)
-	for !p.check(lexer.TokenRightBrace) && !p.isAtEnd() {
+	// Most blocks hold a few statements: starting with room for them saves
+	// growing the slice one statement at a time
+	statements := make([]ast.Stmt, 0, 4)
+	for !p.check(lexer.TokenRightBrace) && !p.isAtEnd() && !p.atTopLevelDecl() {
+		mark := p.advanced
 		statements = append(statements, p.parseStmt())
+		p.skipIfStuck(mark)
 	}
 
 	p.consume(lexer.TokenRightBrace, "expected '}'")
@@ -692,16 +806,20 @@ func (p *Parser) parseSwitchStmt() *ast.SwitchStmt {
 	p.consume(lexer.TokenLeftBrace, "expected '{' before switch body")
 
 	cases := make([]*ast.CaseClause, 0)
-	for !p.check(lexer.TokenRightBrace) && !p.isAtEnd() {
+	for !p.check(lexer.TokenRightBrace) && !p.isAtEnd() && !p.atTopLevelDecl() {
+		mark := p.advanced
 		cases = append(cases, p.parseCaseClause())
+		p.skipIfStuck(mark)
 	}
 
 	p.consume(lexer.TokenRightBrace, "expected '}' after switch body")
+	rightBrace := p.previous
 
 	return &ast.SwitchStmt{
-		SwitchPos: switchPos,
-		Value:     value,
-		Cases:     cases,
+		SwitchPos:  switchPos,
+		Value:      value,
+		Cases:      cases,
+		RightBrace: rightBrace,
 	}
 }
 
@@ -737,8 +855,10 @@ func (p *Parser) parseCaseClause() *ast.CaseClause {
 	// Parse statements until next case or end of switch
 	body := make([]ast.Stmt, 0)
 	for !p.check(lexer.TokenCase) && !p.check(lexer.TokenDefault) &&
-		!p.check(lexer.TokenRightBrace) && !p.isAtEnd() {
+		!p.check(lexer.TokenRightBrace) && !p.isAtEnd() && !p.atTopLevelDecl() {
+		mark := p.advanced
 		body = append(body, p.parseStmt())
+		p.skipIfStuck(mark)
 	}
 
 	return &ast.CaseClause{
@@ -780,8 +900,12 @@ func (p *Parser) parsePrecedence(precedence Precedence) ast.Expr {
 	// Parse prefix expression
 	left := p.parsePrefix()
 	if left == nil {
-		p.error(fmt.Sprintf("expected expression, got %s", p.current.Type))
-		return nil
+		// Unwind to the statement, as consume does: a nil operand would
+		// leave a node with nothing in it, and returning normally would
+		// leave panicMode set, hiding every error after this one.
+		message := fmt.Sprintf("expected expression, got %s", p.current.Type)
+		p.error(message)
+		panic(message)
 	}
 
 	// Parse infix expressions with sufficient precedence
@@ -798,6 +922,7 @@ func (p *Parser) parsePrecedence(precedence Precedence) ast.Expr {
 // - Literals: 42, "hello", true
 // - Identifiers: foo, bar
 // - Unary operators: -x, !flag, ++i
+// - Address-of and dereference: &x, *p
 // - Grouping: (expr)
 // - Array literals: [1, 2, 3]
 // - Struct literals: Point{x: 1, y: 2}
@@ -829,9 +954,16 @@ func (p *Parser) parsePrefix() ast.Expr {
 
 	// Unary operators
 	case lexer.TokenMinus, lexer.TokenNot, lexer.TokenBitNot,
-		lexer.TokenPlusPlus, lexer.TokenMinusMinus:
+		lexer.TokenPlusPlus, lexer.TokenMinusMinus,
+		lexer.TokenBitAnd, lexer.TokenStar:
 		return p.parseUnary()
 
+	// Double dereference: **pp, lexed as the power operator
+	case lexer.TokenStarStar:
+		operator := p.current
+		p.advance()
+		return starsOf(operator, p.parsePrecedence(PrecUnary))
+
 	default:
 		return nil
 	}
@@ -908,32 +1040,39 @@ func (p *Parser) parseNumberLiteral() ast.Expr {
 
 	// Try to parse as integer first
 	if value, err := strconv.ParseInt(token.Lexeme, 0, 64); err == nil {
-		return &ast.LiteralExpr{
-			Token: token,
-			Value: value,
-		}
-	}
-
-	// Parse as float
+		return p.newLiteral(token, value)
+	}
+
+	// Without a fraction or an exponent the literal is an integer, so
+	// failing to parse it means it is too big. Reading it as a float would
+	// change its type and round it, and the error would surface later as a
+	// baffling type mismatch.
+	//
+	// DESIGN CHOICE: Recorded without entering panic mode. The token is
+	// well formed and parsing carries on normally from it, so no
+	// synchronize would follow to clear the mode, and every later syntax
+	// error in the file would be dropped.
+	if !strings.ContainsAny(token.Lexeme, ".eE") {
+		p.literalError(token, "integer literal overflows int64")
+		return p.newLiteral(token, int64(0))
+	}
+
+	// Parse as float. The lexer only produces well-formed numbers, so the
+	// one way this fails is a literal beyond the largest float64, which
+	// would otherwise be +Inf
 	value, err := strconv.ParseFloat(token.Lexeme, 64)
 	if err != nil {
-		p.error(fmt.Sprintf("invalid number literal: %s", token.Lexeme))
-		return &ast.LiteralExpr{Token: token, Value: 0.0}
+		p.literalError(token, "float literal overflows float64")
+		return p.newLiteral(token, 0.0)
 	}
 
-	return &ast.LiteralExpr{
-		Token: token,
-		Value: value,
-	}
+	return p.newLiteral(token, value)
 }
 
 func (p *Parser) parseStringLiteralExpr() ast.Expr {
 	token := p.current
 	p.advance()
-	return &ast.LiteralExpr{
-		Token: token,
-		Value: p.parseStringLiteral(token.Lexeme),
-	}
+	return p.newLiteral(token, p.parseStringLiteral(token.Lexeme))
 }
 
 func (p *Parser) parseStringLiteral(lexeme string) string {
@@ -978,7 +1117,7 @@ func (p *Parser) parseCharLiteral() ast.Expr {
 	// Remove quotes and get the character
 	if len(token.Lexeme) < 3 {
 		p.error("invalid character literal")
-		return &ast.LiteralExpr{Token: token, Value: rune(0)}
+		return p.newLiteral(token, rune(0))
 	}
 
 	s := token.Lexeme[1 : len(token.Lexeme)-1]
@@ -986,45 +1125,39 @@ func (p *Parser) parseCharLiteral() ast.Expr {
 		// Escape sequence
 		if len(s) < 2 {
 			p.error("invalid escape sequence")
-			return &ast.LiteralExpr{Token: token, Value: rune(0)}
+			return p.newLiteral(token, rune(0))
 		}
 		switch s[1] {
 		case 'n':
-			return &ast.LiteralExpr{Token: token, Value: '\n'}
+			return p.newLiteral(token, '\n')
 		case 't':
-			return &ast.LiteralExpr{Token: token, Value: '\t'}
+			return p.newLiteral(token, '\t')
 		case 'r':
-			return &ast.LiteralExpr{Token: token, Value: '\r'}
+			return p.newLiteral(token, '\r')
 		case '\\':
-			return &ast.LiteralExpr{Token: token, Value: '\\'}
+			return p.newLiteral(token, '\\')
 		case '\'':
-			return &ast.LiteralExpr{Token: token, Value: '\''}
+			return p.newLiteral(token, '\'')
 		default:
-			return &ast.LiteralExpr{Token: token, Value: rune(s[1])}
+			return p.newLiteral(token, rune(s[1]))
 		}
 	}
 
 	// Regular character
 	ch, _ := utf8.DecodeRuneInString(s)
-	return &ast.LiteralExpr{Token: token, Value: ch}
+	return p.newLiteral(token, ch)
 }
 
 func (p *Parser) parseBoolLiteral() ast.Expr {
 	token := p.current
 	p.advance()
-	return &ast.LiteralExpr{
-		Token: token,
-		Value: token.Type == lexer.TokenTrue,
-	}
+	return p.newLiteral(token, token.Type == lexer.TokenTrue)
 }
 
 func (p *Parser) parseNilLiteral() ast.Expr {
 	token := p.current
 	p.advance()
-	return &ast.LiteralExpr{
-		Token: token,
-		Value: nil,
-	}
+	return p.newLiteral(token, nil)
 }
 
 func (p *Parser) parseIdentifier() ast.Expr {
@@ -1033,16 +1166,10 @@ func (p *Parser) parseIdentifier() ast.Expr {
 
 	// Check if this is a struct literal: TypeName{...}
 	if p.check(lexer.TokenLeftBrace) {
-		return p.parseStructLiteral(&ast.IdentifierExpr{
-			Token: token,
-			Name:  token.Lexeme,
-		})
+		return p.parseStructLiteral(p.newIdentifier(token))
 	}
 
-	return &ast.IdentifierExpr{
-		Token: token,
-		Name:  token.Lexeme,
-	}
+	return p.newIdentifier(token)
 }
 
 func (p *Parser) parseGrouping() ast.Expr {
@@ -1067,11 +1194,11 @@ func (p *Parser) parseArrayLiteral() ast.Expr {
 
 	elements := make([]ast.Expr, 0)
 
-	// Parse elements
+	// Parse elements, which may end with a comma
 	if !p.check(lexer.TokenRightBracket) {
 		for {
 			elements = append(elements, p.parseExpression())
-			if !p.match(lexer.TokenComma) {
+			if !p.match(lexer.TokenComma) || p.check(lexer.TokenRightBracket) {
 				break
 			}
 		}
@@ -1096,30 +1223,23 @@ func (p *Parser) parseStructLiteral(typeName *ast.IdentifierExpr) ast.Expr {
 
 	if !p.check(lexer.TokenRightBrace) {
 		for {
-			// Parse field name
-			if !p.check(lexer.TokenIdentifier) {
-				p.error("expected field name")
-				break
-			}
-			fieldName := &ast.IdentifierExpr{
-				Token: p.current,
-				Name:  p.current.Lexeme,
+			// A value, or the name of a field if a ':' follows. Parsing the
+			// expression first tells the two apart without lookahead; the
+			// analyzer checks that a literal doesn't use both forms.
+			field := &ast.FieldInit{Value: p.parseExpression()}
+			if p.match(lexer.TokenColon) {
+				name, ok := field.Value.(*ast.IdentifierExpr)
+				if !ok {
+					p.error("expected field name")
+					break
+				}
+				field.Name = name
+				field.Colon = p.previous
+				field.Value = p.parseExpression()
 			}
-			p.advance()
-
-			p.consume(lexer.TokenColon, "expected ':' after field name")
-			colon := p.previous
-
-			// Parse field value
-			value := p.parseExpression()
-
-			fields = append(fields, &ast.FieldInit{
-				Name:  fieldName,
-				Colon: colon,
-				Value: value,
-			})
+			fields = append(fields, field)
 
-			if !p.match(lexer.TokenComma) {
+			if !p.match(lexer.TokenComma) || p.check(lexer.TokenRightBrace) {
 				break
 			}
 		}
@@ -1138,6 +1258,27 @@ func (p *Parser) parseStructLiteral(typeName *ast.IdentifierExpr) ast.Expr {
 
 // Operator parsing
 
+// starsOf applies the stars of operator, a * or a ** token, to operand: one
+// UnaryExpr for *, and two nested ones for **, each with a * token of its
+// own so that positions still point into the source.
+func starsOf(operator lexer.Token, operand ast.Expr) ast.Expr {
+	if operator.Type == lexer.TokenStarStar {
+		inner := operator
+		inner.Position.Column++
+		inner.Position.Offset++
+		operand = &ast.UnaryExpr{Operator: star(inner), Operand: operand}
+	}
+	return &ast.UnaryExpr{Operator: star(operator), Operand: operand}
+}
+
+// star turns a token into a one-character * at the same position.
+func star(token lexer.Token) lexer.Token {
+	token.Type = lexer.TokenStar
+	token.Lexeme = "*"
+	token.Length = 1
+	return token
+}
+
 func (p *Parser) parseUnary() ast.Expr {
 	operator := p.current
 	p.advance()
@@ -1163,11 +1304,7 @@ func (p *Parser) parseBinary(left ast.Expr) ast.Expr {
 
 	right := p.parsePrecedence(precedence + 1)
 
-	return &ast.BinaryExpr{
-		Left:     left,
-		Operator: operator,
-		Right:    right,
-	}
+	return p.newBinary(left, operator, right)
 }
 
 func (p *Parser) parseLogical(left ast.Expr) ast.Expr {
@@ -1207,10 +1344,7 @@ func (p *Parser) parseMember(left ast.Expr) ast.Expr {
 		return left
 	}
 
-	member := &ast.IdentifierExpr{
-		Token: p.current,
-		Name:  p.current.Lexeme,
-	}
+	member := p.newIdentifier(p.current)
 	p.advance()
 
 	return &ast.MemberExpr{
@@ -1224,11 +1358,14 @@ func (p *Parser) parseCall(left ast.Expr) ast.Expr {
 	leftParen := p.current
 	p.advance()
 
+	// A comma may follow the last argument, so that a call split over
+	// lines can end every line with one: with a newline after the last
+	// argument there would be a ';' there instead (see lexer.NextToken)
 	args := make([]ast.Expr, 0)
 	if !p.check(lexer.TokenRightParen) {
 		for {
 			args = append(args, p.parseExpression())
-			if !p.match(lexer.TokenComma) {
+			if !p.match(lexer.TokenComma) || p.check(lexer.TokenRightParen) {
 				break
 			}
 		}
@@ -1266,15 +1403,54 @@ func (p *Parser) parseIndex(left ast.Expr) ast.Expr {
 
 func (p *Parser) advance() {
 	p.previous = p.current
-	token, err := p.lexer.NextToken()
-	if err != nil {
-		p.error(err.Error())
-		p.current = lexer.Token{Type: lexer.TokenInvalid}
-	} else {
-		p.current = token
+	p.advanced++
+	for {
+		token, err := p.next()
+		if err != nil {
+			if lexErr, ok := err.(*lexer.Error); ok {
+				p.errorAt(errors.CodeLexical, lexErr.Pos, lexErr.Message)
+			} else {
+				p.error(err.Error())
+			}
+			p.current = lexer.Token{Type: lexer.TokenInvalid, Position: token.Position}
+			return
+		}
+		if token.Type != lexer.TokenComment {
+			p.current = token
+			return
+		}
+		p.comments = append(p.comments, &ast.Comment{
+			Position: token.Position,
+			Text:     token.Lexeme,
+			IsBlock:  strings.HasPrefix(token.Lexeme, "/*"), // vs //
+		})
 	}
 }
 
+// next returns the next token and its lexical error, from the lexer or,
+// for a parser made by NewFromTokens, from its tokens.
+func (p *Parser) next() (lexer.Token, error) {
+	if p.lexer != nil {
+		return p.lexer.NextToken()
+	}
+	if len(p.tokens) == 0 {
+		return lexer.Token{Type: lexer.TokenEOF}, nil
+	}
+	if p.pos == len(p.tokens) {
+		return p.tokens[len(p.tokens)-1], nil
+	}
+	token := p.tokens[p.pos]
+	p.pos++
+	if len(p.lexErrors) > 0 {
+		if lexErr, ok := p.lexErrors[0].(*lexer.Error); !ok || lexErr.Pos.Offset == token.Position.Offset {
+			err := p.lexErrors[0]
+			p.lexErrors = p.lexErrors[1:]
+			return token, err
+		}
+	}
+	return token, nil
+}
+
 func (p *Parser) check(tokenType lexer.TokenType) bool {
 	return p.current.Type == tokenType
 }
@@ -1291,26 +1467,85 @@ func (p *Parser) match(tokenTypes ...lexer.TokenType) bool {
 
 func (p *Parser) consume(tokenType lexer.TokenType, message string) {
 	if p.check(tokenType) {
+		if p.TolerantMode {
+			p.panicMode = false
+		}
 		p.advance()
 		return
 	}
 	p.error(message)
-	panic(message)
+	if !p.TolerantMode {
+		panic(message)
+	}
+
+	// The missing token is taken to end where the current one starts. It
+	// doesn't count as advancing, so a loop that consumed nothing but it
+	// still skips the token it is stuck on
+	p.previous = lexer.Token{Type: tokenType, Position: p.current.Position}
+}
+
+// optionalSemicolon consumes the ';' after what needs none - the package
+// clause, an import, or a declaration or statement ending in '}' - if
+// there is one. There usually is: the lexer puts one at the end of each
+// line such a thing ends (see lexer.NextToken).
+func (p *Parser) optionalSemicolon() {
+	p.match(lexer.TokenSemicolon)
 }
 
 func (p *Parser) isAtEnd() bool {
 	return p.current.Type == lexer.TokenEOF
 }
 
+// atTopLevelDecl reports whether the current token can only start a
+// top-level declaration. A block that reaches one was never closed, and
+// ends there, so the declaration after it still parses.
+func (p *Parser) atTopLevelDecl() bool {
+	return p.check(lexer.TokenFunc) || p.check(lexer.TokenStruct) || p.check(lexer.TokenTypeKeyword)
+}
+
+// skipIfStuck skips the current token, and the rest of its statement, if
+// nothing has been consumed since mark, a count of p.advanced taken before
+// parsing a declaration or statement.
+//
+// A token that no rule accepts, and that synchronize stops at (a 'return'
+// at the top level, or anything after a ';'), would otherwise be parsed
+// again and again by the loop around it, without end. The rule that met
+// it has already reported the error, so the tokens after it up to the
+// next statement are skipped rather than reported one by one.
+func (p *Parser) skipIfStuck(mark int) {
+	if p.advanced == mark && !p.isAtEnd() {
+		p.advance()
+		p.synchronize()
+	}
+}
+
 func (p *Parser) error(message string) {
 	if p.panicMode {
 		return
 	}
 	p.panicMode = true
-	err := fmt.Errorf("%s: %s", p.current.Position.String(), message)
+	span := p.current.Span()
+	err := errors.New(errors.CodeSyntax, span.Start, message).WithEnd(span.End)
 	p.errors = append(p.errors, err)
 }
 
+// errorAt records an error at an explicit position (used for errors the lexer
+// reports, whose position is that of the bad token rather than p.current).
+func (p *Parser) errorAt(code string, pos lexer.Position, message string) {
+	if p.panicMode {
+		return
+	}
+	p.panicMode = true
+	p.errors = append(p.errors, errors.New(code, pos, message))
+}
+
+// literalError records an error covering the literal token, which parsing
+// has already moved past.
+func (p *Parser) literalError(token lexer.Token, message string) {
+	span := token.Span()
+	p.errors = append(p.errors, errors.New(errors.CodeLexical, span.Start, message).WithEnd(span.End))
+}
+
 // synchronize skips tokens until we reach a statement boundary.
 // This is used for error recovery.
 func (p *Parser) synchronize() {
@@ -0,0 +1,647 @@
+package parser
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hassan/compiler/internal/errors"
+	"github.com/hassan/compiler/internal/lexer"
+	"github.com/hassan/compiler/internal/parser/ast"
+)
+
+func TestParseNumberLiteral(t *testing.T) {
+	tests := []struct {
+		name    string
+		literal string
+		want    interface{}
+		err     string // the error, "" for none
+	}{
+		{"max int64", "9223372036854775807", int64(9223372036854775807), ""},
+		{"max int64 + 1", "9223372036854775808", int64(0), "test.src:3:9: integer literal overflows int64"},
+		{"far too big", "9999999999999999999999", int64(0), "test.src:3:9: integer literal overflows int64"},
+		{"float", "3.25", 3.25, ""},
+		{"float beyond int64", "9999999999999999999999.0", 9999999999999999999999.0, ""},
+		{"exponent", "1e30", 1e30, ""},
+		{"max float64", "1.7976931348623157e308", 1.7976931348623157e308, ""},
+		{"float beyond float64", "1e400", 0.0, "test.src:3:9: float literal overflows float64"},
+		{"float underflow", "1e-400", 0.0, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := "package main\n\nvar x = " + tt.literal + ";\nvar y int = ;\n"
+			file, errs := New(lexer.New(source, "test.src")).ParseFile("test.src")
+
+			decl, ok := file.Decls[0].(*ast.VarDecl)
+			if !ok {
+				t.Fatalf("expected a var declaration, got %T", file.Decls[0])
+			}
+			literal, ok := decl.Initializer.(*ast.LiteralExpr)
+			if !ok {
+				t.Fatalf("expected a literal, got %T", decl.Initializer)
+			}
+			if literal.Value != tt.want {
+				t.Errorf("expected %T %v, got %T %v", tt.want, tt.want, literal.Value, literal.Value)
+			}
+
+			// The second line's syntax error is always reported: an
+			// overflow doesn't hide the errors after it
+			want := 1
+			if tt.err != "" {
+				want = 2
+				err, ok := errs[0].(*errors.CompileError)
+				if !ok || err.Error() != tt.err || err.End.Column != 9+len(tt.literal) {
+					t.Errorf("expected %q ending at column %d, got %v", tt.err, 9+len(tt.literal), errs[0])
+				}
+			}
+			if len(errs) != want {
+				t.Errorf("expected %d errors, got %v", want, errs)
+			}
+		})
+	}
+}
+
+func TestParseMalformedFloat(t *testing.T) {
+	for _, literal := range []string{".5", "5."} {
+		t.Run(literal, func(t *testing.T) {
+			source := "package main\n\nfunc f() {\n    var x float = 0.0;\n    x = " + literal + ";\n    var y int = ;\n}\n"
+			_, errs := New(lexer.New(source, "test.src")).ParseFile("test.src")
+
+			// One error for the literal, and the statement after it is
+			// still parsed: its error is reported as well
+			want := []string{
+				"test.src:5:9: floating-point literal must have digits on both sides of '.'",
+				"test.src:6:17: expected expression, got SEMICOLON",
+			}
+			if len(errs) != len(want) {
+				t.Fatalf("expected %d errors, got %v", len(want), errs)
+			}
+			for i, err := range errs {
+				if err.Error() != want[i] {
+					t.Errorf("expected %q, got %q", want[i], err.Error())
+				}
+			}
+		})
+	}
+}
+
+func TestParseDots(t *testing.T) {
+	tests := []struct {
+		dots string
+		want string
+	}{
+		{"...", "test.src:5:18: expected ';' after variable declaration"},
+		{"..", "test.src:5:18: unexpected '..'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.dots, func(t *testing.T) {
+			source := "package main\n\nfunc f() {\n    var a int = 1;\n    var b int = a" + tt.dots + "b;\n    var c int = ;\n}\n"
+			_, errs := New(lexer.New(source, "test.src")).ParseFile("test.src")
+
+			// The dots are reported where they are, and the statement after
+			// them is still parsed, its error at its own column
+			want := []string{tt.want, "test.src:6:17: expected expression, got SEMICOLON"}
+			if len(errs) != len(want) {
+				t.Fatalf("expected %d errors, got %v", len(want), errs)
+			}
+			for i, err := range errs {
+				if err.Error() != want[i] {
+					t.Errorf("expected %q, got %q", want[i], err.Error())
+				}
+			}
+		})
+	}
+}
+
+func TestParseRecovery(t *testing.T) {
+	// Each of these once left the parser looping on a token no rule would
+	// consume. Every one must end, with one error per mistake
+	tests := []struct {
+		name   string
+		source string
+		decls  int
+		want   []string
+	}{
+		{"unclosed parameters", "package main\nfunc f( {\n}\n", 0,
+			[]string{"test.src:2:9: expected parameter name"}},
+		{"unclosed body", "package main\nfunc f() {\n    return 1;\n\nfunc g() {\n}\n", 1,
+			[]string{"test.src:5:1: expected '}'"}},
+		{"statements at the top level", "package main\nreturn 1;\nvary b int = 2;\nfunc g() {\n}\n", 1,
+			[]string{"test.src:2:1: expected declaration, got RETURN", "test.src:3:1: expected declaration, got IDENTIFIER"}},
+		{"malformed switch", "package main\nfunc f() {\n    switch x { 3 }\n}\n", 1,
+			[]string{"test.src:3:12: expected '(' after 'switch'"}},
+		// a missing operand used to hide every error after it
+		{"missing operands", "package main\nfunc f() {\n    ;\n    x = ;\n    return -;\n}\n", 1,
+			[]string{"test.src:3:5: expected expression, got SEMICOLON", "test.src:4:9: expected expression, got SEMICOLON", "test.src:5:13: expected expression, got SEMICOLON"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file, errs := New(lexer.New(tt.source, "test.src")).ParseFile("test.src")
+			if len(file.Decls) != tt.decls {
+				t.Errorf("expected %d declarations, got %d", tt.decls, len(file.Decls))
+			}
+			if len(errs) != len(tt.want) {
+				t.Fatalf("expected %d errors, got %v", len(tt.want), errs)
+			}
+			for i, err := range errs {
+				if err.Error() != tt.want[i] {
+					t.Errorf("expected %q, got %q", tt.want[i], err.Error())
+				}
+			}
+		})
+	}
+}
+
+func TestParseFileTolerant(t *testing.T) {
+	// Five functions, the second broken
+	source := func(two string) string {
+		return "package main\n" +
+			"func one() int { return 1; }\n" +
+			two + "\n" +
+			"func three() int { return 3; }\n" +
+			"func four() int { return 4; }\n" +
+			"func five() int { return 5; }\n"
+	}
+	tests := []struct {
+		name  string
+		two   string
+		names []string
+		want  []string
+	}{
+		{"unclosed parameters", "func two(a int { return a; }",
+			[]string{"one", "two", "three", "four", "five"}, []string{"test.src:3:16: expected ')' after parameters"}},
+		{"unclosed body", "func two() int { return 2;",
+			[]string{"one", "two", "three", "four", "five"}, []string{"test.src:4:1: expected '}'"}},
+		{"missing semicolons", "func two() int { var x = 1 2 3; return x }",
+			[]string{"one", "two", "three", "four", "five"}, []string{"test.src:3:28: expected ';' after variable declaration", "test.src:3:42: expected ';' after return statement"}},
+		{"unclosed condition", "func two(a int) int { if (a > 0 { return a; } return 0; }",
+			[]string{"one", "two", "three", "four", "five"}, []string{"test.src:3:33: expected ')' after condition"}},
+		{"missing operand", "func two(a int) int { return a +; }",
+			[]string{"one", "two", "three", "four", "five"}, []string{"test.src:3:33: expected expression, got SEMICOLON"}},
+		{"missing name", "func (a int) int { return a; }",
+			[]string{"one", "three", "four", "five"}, []string{"test.src:3:6: expected function name", "test.src:3:20: expected declaration, got RETURN", "test.src:3:30: expected declaration, got RBRACE"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file, errs := New(lexer.New(source(tt.two), "test.src")).ParseFileTolerant("test.src")
+			var names []string
+			for _, decl := range file.Decls {
+				names = append(names, decl.(*ast.FuncDecl).Name.Name)
+			}
+			if !reflect.DeepEqual(names, tt.names) {
+				t.Errorf("expected declarations %v, got %v", tt.names, names)
+			}
+			if len(errs) != len(tt.want) {
+				t.Fatalf("expected %d errors, got %v", len(tt.want), errs)
+			}
+			for i, err := range errs {
+				if err.Error() != tt.want[i] {
+					t.Errorf("expected %q, got %q", tt.want[i], err.Error())
+				}
+			}
+		})
+	}
+
+	t.Run("an error in every declaration", func(t *testing.T) {
+		var sb strings.Builder
+		sb.WriteString("package main\n")
+		for i := 0; i < 10; i++ {
+			fmt.Fprintf(&sb, "func f%d(a int int { return a }\n", i)
+		}
+		file, errs := New(lexer.New(sb.String(), "test.src")).ParseFileTolerant("test.src")
+		if len(file.Decls) != 10 {
+			t.Errorf("expected 10 declarations, got %d", len(file.Decls))
+		}
+		if len(errs) != 20 {
+			t.Errorf("expected 20 errors, got %d: %v", len(errs), errs)
+		}
+	})
+}
+
+func TestParseSemicolonInsertion(t *testing.T) {
+	// Every ';' that ends a line here is one the lexer would put there
+	source := `package main
+import "fmt";
+
+struct Point {
+    x int;
+    y int;
+}
+
+var origin = Point{x: 0, y: 0};
+
+func sum(p Point, n int) int {
+    var total = 0;
+    for (var i = 0; i < n; i = i + 1) {
+        if (i < p.x) {
+            continue;
+        } else if (i > p.y) {
+            break;
+        }
+        switch (i % 3) {
+        case 1, 2:
+            total += fmt.width(i);
+        default:
+            total++;
+        }
+        total = total +
+            p.x;
+    }
+    while (total > 1000) {
+        total = total / 2;
+    }
+    return total;
+}
+
+func nothing() {
+    return;
+}
+`
+	parse := func(source string) string {
+		file, errs := New(lexer.New(source, "test.src")).ParseFile("test.src")
+		if len(errs) > 0 {
+			t.Fatalf("%v in\n%s", errs, source)
+		}
+		var sb strings.Builder
+		if err := ast.Print(file, &sb); err != nil {
+			t.Fatal(err)
+		}
+		return sb.String()
+	}
+	if want, got := parse(source), parse(strings.ReplaceAll(source, ";\n", "\n")); got != want {
+		t.Errorf("without semicolons, expected\n%s\ngot\n%s", want, got)
+	}
+
+	// A line that ends where a statement could is the end of it
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{"else on the next line", "package main\nfunc f(a int) {\n    if (a > 0) {\n    }\n    else {\n    }\n}\n",
+			"test.src:5:5: expected expression, got ELSE"},
+		{"operator on the next line", "package main\nfunc f(a bool) bool {\n    return a\n        && true\n}\n",
+			"test.src:4:9: expected expression, got AND"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, errs := New(lexer.New(tt.source, "test.src")).ParseFile("test.src")
+			if len(errs) == 0 || errs[0].Error() != tt.want {
+				t.Errorf("expected %q first, got %v", tt.want, errs)
+			}
+		})
+	}
+}
+
+func TestParseAssignmentOperators(t *testing.T) {
+	tests := []struct {
+		statement string
+		want      string // the statement's expression, fully parenthesized
+	}{
+		{"x += 2;", "(x += 2)"},
+		{"obj.field *= 3;", "(obj.field *= 3)"},
+		{"arr[i] <<= 1;", "(arr[i] <<= 1)"},
+		{"x %= y >>= 2;", "(x %= (y >>= 2))"},
+		{"a = b += 1;", "(a = (b += 1))"},
+		{"a = b = c | d;", "(a = (b = (c | d)))"},
+		{"i++;", "(i++)"},
+		{"arr[i]--;", "(arr[i]--)"},
+		{"x = -i++ * 2;", "(x = ((-(i++)) * 2))"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.statement, func(t *testing.T) {
+			source := "package main\n\nfunc f() {\n    " + tt.statement + "\n}\n"
+			file, errs := New(lexer.New(source, "test.src")).ParseFile("test.src")
+			for _, err := range errs {
+				t.Errorf("unexpected error: %v", err)
+			}
+			fn := file.Decls[0].(*ast.FuncDecl)
+			if len(fn.Body.Statements) != 1 {
+				t.Fatalf("expected 1 statement, got %d", len(fn.Body.Statements))
+			}
+			stmt, ok := fn.Body.Statements[0].(*ast.ExprStmt)
+			if !ok {
+				t.Fatalf("expected an expression statement, got %T", fn.Body.Statements[0])
+			}
+			if got := parenthesize(stmt.Expression); got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+// parenthesize writes an expression with every operation in parentheses,
+// so the tree's shape can be compared as a string.
+func parenthesize(expr ast.Expr) string {
+	switch e := expr.(type) {
+	case *ast.AssignmentExpr:
+		return "(" + parenthesize(e.Target) + " " + e.Operator.Lexeme + " " + parenthesize(e.Value) + ")"
+	case *ast.BinaryExpr:
+		return "(" + parenthesize(e.Left) + " " + e.Operator.Lexeme + " " + parenthesize(e.Right) + ")"
+	case *ast.UnaryExpr:
+		if e.IsPostfix {
+			return "(" + parenthesize(e.Operand) + e.Operator.Lexeme + ")"
+		}
+		return "(" + e.Operator.Lexeme + parenthesize(e.Operand) + ")"
+	case *ast.MemberExpr:
+		return parenthesize(e.Object) + "." + e.Member.Name
+	case *ast.IndexExpr:
+		return parenthesize(e.Object) + "[" + parenthesize(e.Index) + "]"
+	case *ast.IdentifierExpr:
+		return e.Name
+	case *ast.LiteralExpr:
+		return e.Token.Lexeme
+	default:
+		return fmt.Sprintf("%T", expr)
+	}
+}
+
+func TestParseComments(t *testing.T) {
+	source := `package main
+
+// before the struct
+struct Point {
+    // before a field
+    x int; // after a field
+    /* between fields */
+    y int;
+}
+
+func f(a int, /* between parameters */ b int) int {
+    // before a statement
+    var sum int = a + b; // after a statement
+    /* between
+       statements */
+    switch (sum) {
+    // before a case
+    case 1:
+        return /* inside an expression */ 1;
+    }
+    return sum;
+    // after the last statement
+}
+`
+	file, errs := New(lexer.New(source, "test.src")).ParseFile("test.src")
+	for _, err := range errs {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if len(file.Decls) != 2 {
+		t.Fatalf("expected a struct and a function, got %d declarations", len(file.Decls))
+	}
+	if fields := file.Decls[0].(*ast.StructDecl).Fields; len(fields) != 2 {
+		t.Errorf("expected 2 fields, got %d", len(fields))
+	}
+	fn := file.Decls[1].(*ast.FuncDecl)
+	if len(fn.Params) != 2 || len(fn.Body.Statements) != 3 {
+		t.Errorf("expected 2 parameters and 3 statements, got %d and %d", len(fn.Params), len(fn.Body.Statements))
+	}
+
+	// Every comment is collected, in source order
+	want := []int{3, 5, 6, 7, 11, 12, 13, 14, 17, 19, 22}
+	if len(file.Comments) != len(want) {
+		t.Fatalf("expected %d comments, got %d", len(want), len(file.Comments))
+	}
+	for i, comment := range file.Comments {
+		if comment.Position.Line != want[i] {
+			t.Errorf("comment %d: expected line %d, got %d (%q)", i, want[i], comment.Position.Line, comment.Text)
+		}
+	}
+	if !file.Comments[3].IsBlock || file.Comments[2].IsBlock {
+		t.Error("expected /* */ comments to be block comments and // comments not")
+	}
+}
+
+func TestParsePointers(t *testing.T) {
+	source := "package main\n\nfunc f(pp **int) int {\n    var p *int = &x;\n    return **pp * *p;\n}\n"
+	file, errs := New(lexer.New(source, "test.src")).ParseFile("test.src")
+	for _, err := range errs {
+		t.Errorf("unexpected error: %v", err)
+	}
+	fn := file.Decls[0].(*ast.FuncDecl)
+
+	// **int is a pointer to a pointer, its second star one column on
+	outer, ok := fn.Params[0].Type.(*ast.UnaryExpr)
+	if !ok {
+		t.Fatalf("expected a pointer type, got %T", fn.Params[0].Type)
+	}
+	inner, ok := outer.Operand.(*ast.UnaryExpr)
+	if !ok || outer.Operator.Type != lexer.TokenStar || inner.Operator.Type != lexer.TokenStar {
+		t.Fatalf("expected two stars, got %T", outer.Operand)
+	}
+	if outer.Operator.Position.Column != 11 || inner.Operator.Position.Column != 12 {
+		t.Errorf("expected stars at columns 11 and 12, got %d and %d",
+			outer.Operator.Position.Column, inner.Operator.Position.Column)
+	}
+
+	decl := fn.Body.Statements[0].(*ast.VarDecl)
+	if addr, ok := decl.Initializer.(*ast.UnaryExpr); !ok || addr.Operator.Type != lexer.TokenBitAnd {
+		t.Errorf("expected &x, got %T", decl.Initializer)
+	}
+
+	// **pp * *p multiplies two dereferences: a prefix * is never a product
+	ret := fn.Body.Statements[1].(*ast.ReturnStmt)
+	product, ok := ret.Value.(*ast.BinaryExpr)
+	if !ok || product.Operator.Type != lexer.TokenStar {
+		t.Fatalf("expected a product, got %T", ret.Value)
+	}
+	if left, ok := product.Left.(*ast.UnaryExpr); !ok {
+		t.Errorf("expected **pp on the left, got %T", product.Left)
+	} else if _, ok := left.Operand.(*ast.UnaryExpr); !ok {
+		t.Errorf("expected **pp to dereference twice, got %T", left.Operand)
+	}
+	if _, ok := product.Right.(*ast.UnaryExpr); !ok {
+		t.Errorf("expected *p on the right, got %T", product.Right)
+	}
+}
+
+func TestParseRuneColumns(t *testing.T) {
+	source := "package main\n\nvar 名前 = \"héllo\"; var x = 1; // ünïcode\n"
+	file, errs := New(lexer.New(source, "test.src")).ParseFile("test.src")
+	for _, err := range errs {
+		t.Errorf("unexpected error: %v", err)
+	}
+	first := file.Decls[0].(*ast.VarDecl)
+	second := file.Decls[1].(*ast.VarDecl)
+
+	// Every start and end is a rune column, however many bytes precede it
+	tests := []struct {
+		name     string
+		got      lexer.Position
+		col, off int
+	}{
+		{"start of 名前", first.Names[0].Pos(), 5, 18},
+		{"end of 名前", first.Names[0].End(), 7, 24},
+		{"start of the string", first.Initializer.Pos(), 10, 27},
+		{"end of the string", first.Initializer.End(), 17, 35},
+		{"start of x", second.Names[0].Pos(), 23, 41},
+		{"end of x", second.Names[0].End(), 24, 42},
+		{"end of the comment", file.Comments[0].End(), 40, 60},
+	}
+	for _, tt := range tests {
+		if tt.got.Line != 3 || tt.got.Column != tt.col || tt.got.Offset != tt.off {
+			t.Errorf("%s: expected 3:%d (offset %d), got %d:%d (offset %d)",
+				tt.name, tt.col, tt.off, tt.got.Line, tt.got.Column, tt.got.Offset)
+		}
+	}
+}
+
+func TestNewFromTokens(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+	}{
+		{"valid", "package main\n\n// add sums\nfunc add(a int, b int) int {\n    return a + b; /* done */\n}\n"},
+		{"lexical errors", "package main\n\nvar a float = .5;\nvar b = a..b;\nvar c = \"open\nvar d = 1;\n"},
+		{"syntax errors", "package main\n\nfunc f() {\n    var = 3;\n    return +;\n}\n"},
+		{"empty", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want, wantErrs := New(lexer.New(tt.source, "test.src")).ParseFile("test.src")
+			tokens, lexErrs := lexer.Tokenize(tt.source, "test.src")
+			got, errs := NewFromTokens(tokens, lexErrs).ParseFile("test.src")
+
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("expected the same file as New, got a different one")
+			}
+			if fmt.Sprint(errs) != fmt.Sprint(wantErrs) {
+				t.Errorf("expected errors %v, got %v", wantErrs, errs)
+			}
+		})
+	}
+}
+
+func TestParseVarNames(t *testing.T) {
+	// Single names share one backing array (see newNames): a second name
+	// must not overwrite the next declaration's
+	source := "package main\n\nvar a int;\nvar b, c int;\nvar d int;\nvar e, f, g int;\nvar h int;\n"
+	file, errs := New(lexer.New(source, "test.src")).ParseFile("test.src")
+	for _, err := range errs {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	var got []string
+	for _, decl := range file.Decls {
+		var names []string
+		for _, name := range decl.(*ast.VarDecl).Names {
+			names = append(names, name.Name)
+		}
+		got = append(got, fmt.Sprint(names))
+	}
+	want := []string{"[a]", "[b c]", "[d]", "[e f g]", "[h]"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestParseExpr(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string // the expression, fully parenthesized; "" for an error
+	}{
+		{"x * 7", "(x * 7)"},
+		{"a = b + 1", "(a = (b + 1))"},
+		{"  -x[i]  ", "(-x[i])"},
+		{"x * 7;", ""},
+		{"x y", ""},
+		{"1 +", ""},
+		{"", ""},
+		{"var x = 3;", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			expr, errs := New(lexer.New(tt.source, "test.src")).ParseExpr()
+			if tt.want == "" {
+				if len(errs) == 0 || expr != nil {
+					t.Errorf("expected an error and no expression, got %v (errors %v)", expr, errs)
+				}
+				return
+			}
+			for _, err := range errs {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if got := parenthesize(expr); got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestParseTrailingCommas(t *testing.T) {
+	tests := []struct {
+		source string
+		want   int    // how many arguments, fields or elements
+		err    string // the error, "" for none
+	}{
+		{"foo(a, b,)", 2, ""},
+		{"foo(a,)", 1, ""},
+		{"foo(\n    a,\n    b,\n)", 2, ""},
+		{"Point{x: 1, y: 2,}", 2, ""},
+		{"Point{\n    x: 1,\n    y: 2,\n}", 2, ""},
+		{"[1, 2, 3,]", 3, ""},
+		{"foo(,)", 0, "test.src:1:5: expected expression, got COMMA"},
+		{"foo(a,,)", 0, "test.src:1:7: expected expression, got COMMA"},
+		{"Point{,}", 0, "test.src:1:7: expected expression, got COMMA"},
+		{"[,]", 0, "test.src:1:2: expected expression, got COMMA"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			expr, errs := New(lexer.New(tt.source, "test.src")).ParseExpr()
+			if tt.err != "" {
+				if len(errs) == 0 || errs[0].Error() != tt.err {
+					t.Errorf("expected %q, got %v", tt.err, errs)
+				}
+				return
+			}
+			for _, err := range errs {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var got int
+			switch e := expr.(type) {
+			case *ast.CallExpr:
+				got = len(e.Args)
+			case *ast.StructLiteralExpr:
+				got = len(e.Fields)
+			case *ast.ArrayLiteralExpr:
+				got = len(e.Elements)
+			}
+			if got != tt.want {
+				t.Errorf("expected %d, got %d in %T", tt.want, got, expr)
+			}
+		})
+	}
+}
+
+func TestParseInput(t *testing.T) {
+	source := "var x = 3;\nfunc twice(n int) int {\n    return n * 2;\n}\nx = twice(x);\nstruct P {\n    a int;\n}\nif (x > 1) {\n    println(x);\n}\n"
+	nodes, errs := New(lexer.New(source, "test.src")).ParseInput()
+	for _, err := range errs {
+		t.Errorf("unexpected error: %v", err)
+	}
+	var got []string
+	for _, node := range nodes {
+		got = append(got, fmt.Sprintf("%T", node))
+	}
+	want := []string{"*ast.VarDecl", "*ast.FuncDecl", "*ast.ExprStmt", "*ast.StructDecl", "*ast.IfStmt"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	// A bad statement is reported, and the ones after it still parse
+	nodes, errs = New(lexer.New("x = ;\nprintln(1);\n", "test.src")).ParseInput()
+	if len(errs) != 1 || len(nodes) == 0 {
+		t.Fatalf("expected 1 error and the statement after it, got %v and %d nodes", errs, len(nodes))
+	}
+	if stmt, ok := nodes[len(nodes)-1].(*ast.ExprStmt); !ok || parenthesize(stmt.Expression) != "*ast.CallExpr" {
+		t.Errorf("expected the println call last, got %T", nodes[len(nodes)-1])
+	}
+}
@@ -25,7 +25,7 @@ import (
 // 11. Multiplication/Division (*, /, %)
 // 12. Exponentiation (**)
 // 13. Unary (!, -, ~, ++, --)
-// 14. Member access (., [], ())
+// 14. Member access and postfix (., [], (), x++, x--)
 //
 // These match C/C++/Java conventions, which are well-understood by programmers.
 type Precedence int
@@ -45,7 +45,7 @@ const (
 	PrecFactor     // *, /, %
 	PrecExponent   // **
 	PrecUnary      // !, -, ~, ++, --
-	PrecCall       // ., [], ()
+	PrecCall       // ., [], (), postfix ++ and --
 	PrecPrimary    // literals, identifiers, grouping
 )
 
@@ -57,6 +57,12 @@ const (
 // - Compile-time checking (typos in token types are caught)
 //
 // This is used by the Pratt parser to decide when to stop parsing.
+//
+// Every token parseInfix handles needs an entry: parsePrecedence only hands
+// a token to parseInfix when its precedence is at least PrecAssignment, so
+// one missing here would end the expression just before it. Tokens with no
+// infix meaning (::, ->, ?) are PrecNone, which ends an expression where
+// they appear.
 func getPrecedence(tokenType lexer.TokenType) Precedence {
 	switch tokenType {
 	// Assignment operators (lowest precedence)
@@ -120,8 +126,10 @@ func getPrecedence(tokenType lexer.TokenType) Precedence {
 	case lexer.TokenStarStar:
 		return PrecExponent
 
-	// Member access, indexing, function calls
-	case lexer.TokenDot, lexer.TokenLeftBracket, lexer.TokenLeftParen:
+	// Member access, indexing, function calls, and postfix increment and
+	// decrement, which bind as tightly: -i++ is -(i++), a[i]++ is (a[i])++
+	case lexer.TokenDot, lexer.TokenLeftBracket, lexer.TokenLeftParen,
+		lexer.TokenPlusPlus, lexer.TokenMinusMinus:
 		return PrecCall
 
 	default:
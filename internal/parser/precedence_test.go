@@ -16,6 +16,14 @@ func TestGetPrecedence(t *testing.T) {
 		{"assign", lexer.TokenAssign, PrecAssignment},
 		{"plus equals", lexer.TokenPlusEq, PrecAssignment},
 		{"minus equals", lexer.TokenMinusEq, PrecAssignment},
+		{"star equals", lexer.TokenStarEq, PrecAssignment},
+		{"slash equals", lexer.TokenSlashEq, PrecAssignment},
+		{"percent equals", lexer.TokenPercentEq, PrecAssignment},
+		{"and equals", lexer.TokenAndEq, PrecAssignment},
+		{"or equals", lexer.TokenOrEq, PrecAssignment},
+		{"xor equals", lexer.TokenXorEq, PrecAssignment},
+		{"shift left equals", lexer.TokenShlEq, PrecAssignment},
+		{"shift right equals", lexer.TokenShrEq, PrecAssignment},
 
 		// Logical OR
 		{"logical or", lexer.TokenOr, PrecOr},
@@ -62,11 +70,16 @@ func TestGetPrecedence(t *testing.T) {
 		{"dot", lexer.TokenDot, PrecCall},
 		{"left bracket", lexer.TokenLeftBracket, PrecCall},
 		{"left paren", lexer.TokenLeftParen, PrecCall},
+		{"postfix increment", lexer.TokenPlusPlus, PrecCall},
+		{"postfix decrement", lexer.TokenMinusMinus, PrecCall},
 
 		// Non-operators
 		{"identifier", lexer.TokenIdentifier, PrecNone},
 		{"number", lexer.TokenNumber, PrecNone},
 		{"semicolon", lexer.TokenSemicolon, PrecNone},
+		{"colon colon", lexer.TokenColonColon, PrecNone},
+		{"arrow", lexer.TokenArrow, PrecNone},
+		{"question", lexer.TokenQuestion, PrecNone},
 	}
 
 	for _, tt := range tests {
@@ -0,0 +1,222 @@
+package parser
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/hassan/compiler/internal/lexer"
+	"github.com/hassan/compiler/internal/parser/ast"
+)
+
+// Incremental re-parsing.
+//
+// An editor re-parses a file after every keystroke, and nearly every
+// keystroke changes one declaration. Reparse parses only the top-level
+// declarations an edit touches and keeps the rest of the previous AST,
+// moving the declarations after the edit to their new positions.
+//
+// EXAMPLE: typing inside the body of g
+//
+//	func f() { ... }      <- kept as it was
+//	func g() { ...|... }  <- re-lexed and re-parsed
+//	func h() { ... }      <- kept, its positions shifted by the edit
+//
+// The re-parsed region runs from the start of the last declaration that
+// begins before the edit to the start of the first that begins after it,
+// so it takes in any declaration the edit changes, adds or removes, and
+// the comments between them. Declarations are bounded by where each one
+// starts rather than by their End, which stops short of a trailing ';'.
+//
+// DESIGN CHOICE: When in doubt, parse the whole file. Reparse falls back to
+// a full parse whenever the region can't be parsed on its own:
+//   - the edit reaches into the package clause, the imports, or whatever
+//     comes before the first declaration
+//   - the region doesn't end exactly where the next kept declaration
+//     starts - a '}' deleted, a comment left open, a token that now runs
+//     into the next declaration - so that declaration would parse
+//     differently too
+//
+// Either way the result is the AST and errors a full parse of the new
+// source gives, only faster when the edit is local.
+
+// Edit is one change to a source file: the bytes from Start up to (not
+// including) End replaced by Text. An insertion has Start == End, and a
+// deletion an empty Text.
+type Edit struct {
+	Start, End int
+	Text       string
+}
+
+// Apply returns source with the edit made.
+func (e Edit) Apply(source string) string {
+	return source[:e.Start] + e.Text + source[e.End:]
+}
+
+// Reparse returns the AST and errors of oldSource with edit applied, given
+// old, the AST of oldSource.
+//
+// old must have parsed without errors: Reparse keeps the declarations the
+// edit doesn't touch, and can't know which errors they had. Parse a file
+// with errors in full. old is also used up: the declarations kept from it
+// are moved to their new positions rather than copied, so old itself no
+// longer matches oldSource afterwards.
+func Reparse(old *ast.File, oldSource string, edit Edit) (*ast.File, []error) {
+	if edit.Start < 0 || edit.Start > edit.End || edit.End > len(oldSource) {
+		return nil, []error{fmt.Errorf("edit of bytes %d to %d is outside the %d-byte source", edit.Start, edit.End, len(oldSource))}
+	}
+	source := edit.Apply(oldSource)
+	if file, errs, ok := reparseDecls(old, oldSource, source, edit); ok {
+		return file, errs
+	}
+	return New(lexer.New(source, old.Filename)).ParseFile(old.Filename)
+}
+
+// reparseDecls re-parses the declarations of old that edit touches, into
+// source, the new text. It reports false, having changed nothing, where
+// only a full parse will do.
+func reparseDecls(old *ast.File, oldSource, source string, edit Edit) (*ast.File, []error, bool) {
+	decls := old.Decls
+	if old.Package == nil {
+		return nil, nil, false
+	}
+
+	// first is the last declaration starting before the edit, and next the
+	// first starting after it
+	first := -1
+	for i, decl := range decls {
+		if decl.Pos().Offset >= edit.Start {
+			break
+		}
+		first = i
+	}
+	if first < 0 {
+		return nil, nil, false
+	}
+	next := first + 1
+	for next < len(decls) && decls[next].Pos().Offset <= edit.End {
+		next++
+	}
+
+	delta := len(edit.Text) - (edit.End - edit.Start)
+	start := decls[first].Pos().Offset
+	oldEnd, end := len(oldSource), len(source)
+	if next < len(decls) {
+		oldEnd = decls[next].Pos().Offset
+		end = oldEnd + delta
+	}
+
+	p := New(lexer.NewAt(source, old.Filename, start))
+	var region []ast.Decl
+	for !p.isAtEnd() && p.current.Position.Offset < end {
+		mark := p.advanced
+		if decl := p.parseDecl(); decl != nil {
+			region = append(region, decl)
+		}
+		p.skipIfStuck(mark)
+	}
+	if next < len(decls) && (p.isAtEnd() || p.current.Position.Offset != end) {
+		return nil, nil, false
+	}
+
+	file := &ast.File{
+		Filename: old.Filename,
+		Package:  old.Package,
+		Imports:  old.Imports,
+		Decls:    make([]ast.Decl, 0, first+len(region)+len(decls)-next),
+		Comments: make([]*ast.Comment, 0, len(old.Comments)),
+	}
+	file.Decls = append(file.Decls, decls[:first]...)
+	file.Decls = append(file.Decls, region...)
+	for _, comment := range old.Comments {
+		if comment.Position.Offset < start {
+			file.Comments = append(file.Comments, comment)
+		}
+	}
+	file.Comments = append(file.Comments, p.comments...)
+	if next == len(decls) {
+		return file, p.errors, true
+	}
+
+	// Everything from the next declaration on moves by delta bytes and by
+	// the lines the edit added or removed. Only what shares a line with the
+	// start of that declaration changes column as well
+	startPos := decls[next].Pos()
+	lineStart := strings.LastIndexByte(source[:end], '\n') + 1
+	columnDelta := utf8.RuneCountInString(source[lineStart:end]) + 1 - startPos.Column
+	lineDelta := strings.Count(edit.Text, "\n") - strings.Count(oldSource[edit.Start:edit.End], "\n")
+	move := func(pos *lexer.Position) {
+		if pos.Line == 0 {
+			return // a token the node doesn't have, such as a missing else
+		}
+		if pos.Line == startPos.Line {
+			pos.Column += columnDelta
+		}
+		pos.Line += lineDelta
+		pos.Offset += delta
+	}
+
+	for _, decl := range decls[next:] {
+		movePositions(decl, move)
+		file.Decls = append(file.Decls, decl)
+	}
+	for _, comment := range old.Comments {
+		if comment.Position.Offset >= oldEnd {
+			move(&comment.Position)
+			file.Comments = append(file.Comments, comment)
+		}
+	}
+	return file, p.errors, true
+}
+
+// positionType is the type of the positions movePositions looks for.
+var positionType = reflect.TypeOf(lexer.Position{})
+
+// movePositions calls move on every position held anywhere in node.
+//
+// DESIGN CHOICE: Reflection rather than a method on every node. Positions
+// sit in the tokens and position fields of some forty node types, and a
+// hand-written walk would have to be kept in step with every field added
+// to any of them; one missed would leave a declaration pointing at the
+// wrong place after an edit. Each node is moved once, however many times
+// it is reached.
+func movePositions(node ast.Node, move func(*lexer.Position)) {
+	type visit struct {
+		pointer uintptr
+		typ     reflect.Type
+	}
+	seen := make(map[visit]bool)
+
+	var walk func(v reflect.Value)
+	walk = func(v reflect.Value) {
+		switch v.Kind() {
+		case reflect.Ptr:
+			key := visit{v.Pointer(), v.Type()}
+			if v.IsNil() || seen[key] {
+				return
+			}
+			seen[key] = true
+			walk(v.Elem())
+		case reflect.Interface:
+			if !v.IsNil() {
+				walk(v.Elem())
+			}
+		case reflect.Slice:
+			for i := 0; i < v.Len(); i++ {
+				walk(v.Index(i))
+			}
+		case reflect.Struct:
+			if v.Type() == positionType {
+				if v.CanAddr() {
+					move(v.Addr().Interface().(*lexer.Position))
+				}
+				return
+			}
+			for i := 0; i < v.NumField(); i++ {
+				walk(v.Field(i))
+			}
+		}
+	}
+	walk(reflect.ValueOf(node))
+}
@@ -0,0 +1,128 @@
+package parser
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hassan/compiler/internal/lexer"
+	"github.com/hassan/compiler/internal/parser/ast"
+)
+
+// reparseSource has three functions, with comments between and inside
+// them, and two declarations sharing a line.
+const reparseSource = `package main
+
+import "fmt"
+
+// f returns one
+func f() int {
+    return 1;
+}
+
+/* g doubles n */
+func g(n int) int {
+    var d int = n * 2; // doubled
+    return d;
+}
+
+var a int = 1; var b int = 2;
+
+func h() {
+    println(g(a) + b);
+}
+`
+
+func TestReparse(t *testing.T) {
+	tests := []struct {
+		name string
+		old  string // text in reparseSource to replace; its first occurrence
+		new  string
+		kept int // declarations reused from the old AST
+	}{
+		// Inside a function body
+		{"rename a local", "var d int = n * 2;", "var doubled int = n * 2;", 4},
+		{"add a line to a body", "    return d;\n", "    d = d + 1;\n    return d;\n", 4},
+		{"syntax error in a body", "return d;", "return d +;", 4},
+		{"delete a statement", "    return 1;\n", "", 4},
+
+		// At a declaration boundary
+		{"insert a declaration", "\nvar a int", "\nvar z int = 3;\n\nvar a int", 3},
+		{"delete a declaration", "/* g doubles n */\nfunc g(n int) int {\n    var d int = n * 2; // doubled\n    return d;\n}\n\n", "", 2},
+		{"edit the first of two on a line", "var a int = 1;", "var a int = 100;", 3},
+		{"edit the last declaration", "println(g(a) + b);", "println(g(b) + a);", 4},
+		{"delete a closing brace before a function", "    return 1;\n}\n", "    return 1;\n", 4},
+		{"misspell a declaration keyword", "var a int = 1; var", "var a int = 1; vary", 2},
+		{"append a declaration", "    println(g(a) + b);\n}\n", "    println(g(a) + b);\n}\n\nfunc k() {\n}\n", 4},
+
+		// Inside a comment
+		{"edit a comment between functions", "/* g doubles n */", "/* g doubles its argument */", 4},
+		{"edit a comment in a body", "// doubled", "// twice n", 4},
+		{"turn a statement into a comment", "    return 1;", "    // return 1;", 4},
+
+		// Falling back to a full parse
+		{"edit the imports", `import "fmt"`, `import "strings"`, 0},
+		{"edit before the first declaration", "// f returns one", "// f returns 1", 0},
+		{"open a block comment", "/* g doubles n */", "/* g doubles n", 0},
+		{"run a body into the next declarations", "    return d;\n}\n", "    return d;\n", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := strings.Index(reparseSource, tt.old)
+			if start < 0 {
+				t.Fatalf("%q not in the source", tt.old)
+			}
+			edit := Edit{Start: start, End: start + len(tt.old), Text: tt.new}
+			source := edit.Apply(reparseSource)
+
+			old, errs := New(lexer.New(reparseSource, "test.src")).ParseFile("test.src")
+			if len(errs) != 0 {
+				t.Fatalf("unexpected errors in the old source: %v", errs)
+			}
+			oldDecls := make(map[ast.Decl]bool)
+			for _, decl := range old.Decls {
+				oldDecls[decl] = true
+			}
+
+			got, gotErrs := Reparse(old, reparseSource, edit)
+			want, wantErrs := New(lexer.New(source, "test.src")).ParseFile("test.src")
+
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("expected the AST of a full parse, got a different one")
+				for i := range want.Decls {
+					if i < len(got.Decls) && !reflect.DeepEqual(got.Decls[i], want.Decls[i]) {
+						t.Errorf("declaration %d differs: at %v, want %v", i, got.Decls[i].Pos(), want.Decls[i].Pos())
+					}
+				}
+			}
+			if fmt.Sprint(gotErrs) != fmt.Sprint(wantErrs) {
+				t.Errorf("expected errors %v, got %v", wantErrs, gotErrs)
+			}
+
+			kept := 0
+			for _, decl := range got.Decls {
+				if oldDecls[decl] {
+					kept++
+				}
+			}
+			if kept != tt.kept {
+				t.Errorf("expected %d declarations kept, got %d", tt.kept, kept)
+			}
+		})
+	}
+}
+
+func TestReparse_InvalidEdit(t *testing.T) {
+	old, _ := New(lexer.New(reparseSource, "test.src")).ParseFile("test.src")
+	for _, edit := range []Edit{
+		{Start: -1, End: 0},
+		{Start: 5, End: 4},
+		{Start: 0, End: len(reparseSource) + 1},
+	} {
+		if file, errs := Reparse(old, reparseSource, edit); file != nil || len(errs) != 1 {
+			t.Errorf("%+v: expected an error, got %v and %v", edit, file, errs)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package parser
+
+import (
+	"github.com/hassan/compiler/internal/lexer"
+	"github.com/hassan/compiler/internal/parser/ast"
+)
+
+// Node allocation.
+//
+// Most of what the parser allocates is small nodes, made one at a time: an
+// identifier for every name, a literal for every constant, a binary
+// expression for every operator. Instead of allocating each on its own, the
+// parser carves them out of slabs - arrays of slabSize nodes of one type,
+// handed out in order - so that a slab costs one allocation where its nodes
+// would have cost slabSize.
+//
+// EXAMPLE: parsing "a + 1" takes the next IdentifierExpr, LiteralExpr and
+// BinaryExpr from their slabs, and allocates nothing new unless one of the
+// slabs has run out.
+//
+// DESIGN CHOICE: Slabs rather than a sync.Pool or a free list. Nodes are
+// never given back - the AST keeps every one of them - so there is nothing
+// to reuse, only allocations to batch. The cost is that a slab stays alive
+// while any node in it does, so holding on to one identifier of a file
+// keeps up to slabSize of its neighbours too. An AST is used and dropped as
+// a whole, so in practice that costs nothing.
+//
+// Names are not interned: a token's lexeme is a substring of the source,
+// not a copy, so two identifiers with the same name already cost no more
+// than two string headers. An interner would add a map lookup for every
+// identifier and save no allocation.
+
+// slabSize is the number of nodes in each slab. It is large enough that the
+// slabs are a small fraction of the allocations, and small enough that a
+// tiny file wastes little on the unused end of them.
+const slabSize = 128
+
+// slabs holds what is left of the current slab of each type.
+type slabs struct {
+	identifiers []ast.IdentifierExpr
+	literals    []ast.LiteralExpr
+	binaries    []ast.BinaryExpr
+	parameters  []ast.Parameter
+	names       []*ast.IdentifierExpr
+}
+
+// newIdentifier returns an identifier expression for token.
+func (p *Parser) newIdentifier(token lexer.Token) *ast.IdentifierExpr {
+	if len(p.slabs.identifiers) == 0 {
+		p.slabs.identifiers = make([]ast.IdentifierExpr, slabSize)
+	}
+	ident := &p.slabs.identifiers[0]
+	p.slabs.identifiers = p.slabs.identifiers[1:]
+	ident.Token = token
+	ident.Name = token.Lexeme
+	return ident
+}
+
+// newLiteral returns a literal expression for token, of value.
+func (p *Parser) newLiteral(token lexer.Token, value interface{}) *ast.LiteralExpr {
+	if len(p.slabs.literals) == 0 {
+		p.slabs.literals = make([]ast.LiteralExpr, slabSize)
+	}
+	literal := &p.slabs.literals[0]
+	p.slabs.literals = p.slabs.literals[1:]
+	literal.Token = token
+	literal.Value = value
+	return literal
+}
+
+// newBinary returns the binary expression "left operator right".
+func (p *Parser) newBinary(left ast.Expr, operator lexer.Token, right ast.Expr) *ast.BinaryExpr {
+	if len(p.slabs.binaries) == 0 {
+		p.slabs.binaries = make([]ast.BinaryExpr, slabSize)
+	}
+	binary := &p.slabs.binaries[0]
+	p.slabs.binaries = p.slabs.binaries[1:]
+	binary.Left = left
+	binary.Operator = operator
+	binary.Right = right
+	return binary
+}
+
+// newParameter returns a parameter named name, of type typeExpr.
+func (p *Parser) newParameter(name *ast.IdentifierExpr, typeExpr ast.Expr) *ast.Parameter {
+	if len(p.slabs.parameters) == 0 {
+		p.slabs.parameters = make([]ast.Parameter, slabSize)
+	}
+	param := &p.slabs.parameters[0]
+	p.slabs.parameters = p.slabs.parameters[1:]
+	param.Name = name
+	param.Type = typeExpr
+	return param
+}
+
+// newNames returns a slice holding just name, for the names of a variable
+// declaration: nearly every one declares a single variable.
+//
+// The slice's capacity is 1, so appending a second name copies it out of
+// the slab rather than writing over the next declaration's name.
+func (p *Parser) newNames(name *ast.IdentifierExpr) []*ast.IdentifierExpr {
+	if len(p.slabs.names) == 0 {
+		p.slabs.names = make([]*ast.IdentifierExpr, slabSize)
+	}
+	names := p.slabs.names[0:1:1]
+	p.slabs.names = p.slabs.names[1:]
+	names[0] = name
+	return names
+}
@@ -0,0 +1,385 @@
+// Package repl evaluates the language a line at a time.
+//
+// WHY A REPL:
+// Trying out an expression otherwise takes a file with a package clause and
+// a main function, a compile, and a run. At the REPL it takes a line:
+//
+//	> var x = 3;
+//	> x * 7
+//	21 : int
+//
+// EXECUTION MODEL:
+// Each line goes through the same pipeline as a program - parse, check,
+// build IR, interpret - and the state one line leaves behind is there for
+// the next:
+//   - Top-level declarations (var, func, struct, type) are declared in one
+//     global scope that lives as long as the session (see
+//     semantic.Analyzer.AnalyzeIncremental), and built into one module
+//   - Everything else on the line - statements, the initializers of its
+//     vars, and an expression to show the value of - becomes the body of a
+//     function of its own, which is run and then thrown away
+//   - The interpreter keeps the values of the globals from one line to the
+//     next (see interp.Interpreter.Call)
+//
+// A line that fails to parse or check changes nothing: the names it
+// declared are taken back, and nothing of it runs.
+package repl
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/hassan/compiler/internal/errors"
+	"github.com/hassan/compiler/internal/interp"
+	"github.com/hassan/compiler/internal/ir"
+	"github.com/hassan/compiler/internal/lexer"
+	"github.com/hassan/compiler/internal/parser"
+	"github.com/hassan/compiler/internal/parser/ast"
+	"github.com/hassan/compiler/internal/semantic"
+	"github.com/hassan/compiler/internal/semantic/types"
+)
+
+// Session is the state of one REPL: everything declared so far, and the
+// values of its globals.
+type Session struct {
+	// Stdout receives what the program prints, and the value of each
+	// expression evaluated.
+	Stdout io.Writer
+
+	analyzer *semantic.Analyzer
+	builder  *ir.Builder
+	module   *ir.Module
+	machine  *interp.Interpreter
+
+	// inputs counts the lines evaluated, to give each its own file name
+	// and function name
+	inputs int
+}
+
+// entry is one line, checked and ready to build: its declarations, and the
+// function that runs the rest of it.
+type entry struct {
+	decls []ast.Decl
+	run   *ast.FuncDecl
+
+	// result is the expression whose value the line shows, nil if the line
+	// is not an expression or its type is void
+	result ast.Expr
+
+	// mark is the number of global symbols before run's was declared
+	mark int
+}
+
+// NewSession creates a session with nothing declared. Output goes to
+// os.Stdout unless Stdout is changed.
+func NewSession() *Session {
+	analyzer := semantic.New()
+	builder := ir.NewBuilder(analyzer)
+	module, _ := builder.BuildIncremental(nil)
+	return &Session{
+		Stdout:   os.Stdout,
+		analyzer: analyzer,
+		builder:  builder,
+		module:   module,
+		machine:  interp.New(module),
+	}
+}
+
+// Eval evaluates one line: declares what it declares, runs what it runs,
+// and if it is an expression writes its value and type to Stdout. Returns
+// the compile errors or the runtime error, if there were any.
+//
+// A line that is an expression and nothing else has its value shown; with
+// a ';' after it, it is a statement like any other.
+func (s *Session) Eval(input string) []error {
+	symbols := len(s.analyzer.GetScope().SymbolsInOrder())
+	functions, globals := len(s.module.Functions), len(s.module.Globals)
+
+	e, errs := s.check(input)
+	if len(errs) > 0 {
+		return errs
+	}
+	if _, errs := s.builder.BuildIncremental(append(e.decls, e.run)); len(errs) > 0 {
+		s.analyzer.GetScope().Truncate(symbols)
+		s.module.Functions = s.module.Functions[:functions]
+		s.module.Globals = s.module.Globals[:globals]
+		return errs
+	}
+
+	s.machine.Stdout = s.Stdout
+	value, err := s.machine.Call(e.run.Name.Name, nil)
+	s.discard(e)
+	if err != nil {
+		return []error{err}
+	}
+	if e.result != nil {
+		fmt.Fprintf(s.Stdout, "%s : %s\n", show(value), s.analyzer.GetExprType(e.result))
+	}
+	return nil
+}
+
+// TypeOf returns the type of the expression source, without evaluating it.
+func (s *Session) TypeOf(source string) (types.Type, []error) {
+	s.inputs++
+	filename := fmt.Sprintf("<input %d>", s.inputs)
+	expr, errs := parser.New(lexer.New(source, filename)).ParseExpr()
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	e, errs := s.checkEntry(nil, []ast.Stmt{&ast.ExprStmt{Expression: expr}})
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	s.discard(e)
+	return s.analyzer.GetExprType(expr), nil
+}
+
+// DumpIR returns the IR of everything declared so far.
+func (s *Session) DumpIR() string {
+	return s.module.String()
+}
+
+// check parses and checks one line.
+func (s *Session) check(input string) (*entry, []error) {
+	s.inputs++
+	filename := fmt.Sprintf("<input %d>", s.inputs)
+
+	// An expression first: "f(x)" would parse as a statement too, were it
+	// not for the missing ';'
+	if expr, errs := parser.New(lexer.New(input, filename)).ParseExpr(); len(errs) == 0 {
+		e, errs := s.checkEntry(nil, []ast.Stmt{&ast.ExprStmt{Expression: expr}})
+		if len(errs) > 0 {
+			return nil, errs
+		}
+		if t := s.analyzer.GetExprType(expr); !t.Equals(types.Void) {
+			s.returnValue(e, expr, t)
+		}
+		return e, nil
+	}
+
+	nodes, errs := parser.New(lexer.New(input, filename)).ParseInput()
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	var decls []ast.Decl
+	var body []ast.Stmt
+	for _, node := range nodes {
+		switch n := node.(type) {
+		case *ast.VarDecl:
+			// A global, initialized where the line has it
+			decls = append(decls, n)
+			body = append(body, initialize(n)...)
+		case ast.Decl:
+			decls = append(decls, n)
+		case ast.Stmt:
+			body = append(body, n)
+		}
+	}
+	return s.checkEntry(decls, body)
+}
+
+// checkEntry checks decls, then body as the body of the function that runs
+// the line. If either has errors, neither stays declared.
+//
+// DESIGN CHOICE: Two calls to AnalyzeIncremental rather than one for both.
+// An initializer is checked as part of its var and again in the assignment
+// that runs it (see initialize): one call would report each of its errors
+// twice.
+func (s *Session) checkEntry(decls []ast.Decl, body []ast.Stmt) (*entry, []error) {
+	scope := s.analyzer.GetScope()
+	mark := len(scope.SymbolsInOrder())
+	if errs := s.analyzer.AnalyzeIncremental(decls); len(errs) > 0 {
+		return nil, errs
+	}
+
+	// The name can't be spelled in the language, so it can't clash
+	e := &entry{decls: decls, mark: len(scope.SymbolsInOrder())}
+	e.run = &ast.FuncDecl{
+		Name: &ast.IdentifierExpr{Name: fmt.Sprintf("$input%d", s.inputs)},
+		Body: &ast.BlockStmt{Statements: body},
+	}
+	if errs := s.analyzer.AnalyzeIncremental([]ast.Decl{e.run}); len(errs) > 0 {
+		scope.Truncate(mark)
+		return nil, errs
+	}
+	return e, nil
+}
+
+// returnValue makes the function that runs e return the value of expr, the
+// statement it was checked as, of type t.
+//
+// The result type of an expression is only known once it is checked, so
+// the function is checked as a void function evaluating expr and then
+// rewritten: the return that replaces the statement checks as the
+// statement did, with nothing left to check against but t itself.
+func (s *Session) returnValue(e *entry, expr ast.Expr, t types.Type) {
+	e.result = expr
+	e.run.Body.Statements = []ast.Stmt{&ast.ReturnStmt{ReturnPos: expr.Pos(), Value: expr}}
+	symbol := s.analyzer.GetScope().LookupLocal(e.run.Name.Name)
+	symbol.Type = types.NewFunction(nil, t)
+}
+
+// discard removes the function that ran e, which nothing can call again.
+func (s *Session) discard(e *entry) {
+	s.analyzer.GetScope().Truncate(e.mark)
+	for i, fn := range s.module.Functions {
+		if fn.Name == e.run.Name.Name {
+			s.module.Functions = append(s.module.Functions[:i], s.module.Functions[i+1:]...)
+			break
+		}
+	}
+}
+
+// initialize returns the assignments that give the globals of decl their
+// initial value, since a global's initializer is not run where it is
+// declared. "var a, b = f();" assigns a, then b from a, so f runs once.
+func initialize(decl *ast.VarDecl) []ast.Stmt {
+	if decl.Initializer == nil {
+		return nil
+	}
+	stmts := make([]ast.Stmt, len(decl.Names))
+	value := decl.Initializer
+	for i, name := range decl.Names {
+		stmts[i] = &ast.ExprStmt{Expression: &ast.AssignmentExpr{
+			Target:   &ast.IdentifierExpr{Token: name.Token, Name: name.Name},
+			Operator: lexer.Token{Type: lexer.TokenAssign, Lexeme: "=", Position: name.Pos()},
+			Value:    value,
+		}}
+		value = &ast.IdentifierExpr{Token: name.Token, Name: name.Name}
+	}
+	return stmts
+}
+
+// show renders a value as the REPL displays it: like the program would
+// print it, except that strings and chars are quoted, so "1" and 1 look
+// different.
+func show(v interp.Value) string {
+	switch val := v.(type) {
+	case string:
+		return strconv.Quote(val)
+	case rune:
+		return strconv.QuoteRune(val)
+	default:
+		return interp.Format(v)
+	}
+}
+
+// Prompts, for a new line and for the next line of an unfinished one
+const (
+	prompt       = "> "
+	continuation = "... "
+)
+
+const help = `Enter an expression to see its value and type, or declarations and
+statements to run them. A line with unclosed braces continues on the next.
+
+  :type expr   show the type of expr without evaluating it
+  :dump-ir     show the IR of everything declared so far
+  :help        show this help
+  :quit        leave the REPL (so does end of input)
+`
+
+// Run reads lines from in and evaluates them in a new session until :quit
+// or the end of in, writing prompts, results and errors to out.
+func Run(in io.Reader, out io.Writer) error {
+	session := NewSession()
+	session.Stdout = out
+
+	scanner := bufio.NewScanner(in)
+	var pending strings.Builder
+	fmt.Fprint(out, prompt)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if pending.Len() == 0 && strings.HasPrefix(strings.TrimSpace(line), ":") {
+			if !session.command(strings.TrimSpace(line), out) {
+				return nil
+			}
+			fmt.Fprint(out, prompt)
+			continue
+		}
+
+		pending.WriteString(line)
+		pending.WriteByte('\n')
+		if depth(pending.String()) > 0 {
+			fmt.Fprint(out, continuation)
+			continue
+		}
+		input := pending.String()
+		pending.Reset()
+		if strings.TrimSpace(input) != "" {
+			report(out, input, session.Eval(input))
+		}
+		fmt.Fprint(out, prompt)
+	}
+
+	// Input that ended inside braces is evaluated as it is, to report
+	// what is missing
+	if pending.Len() > 0 {
+		input := pending.String()
+		report(out, input, session.Eval(input))
+	}
+	fmt.Fprintln(out)
+	return scanner.Err()
+}
+
+// command runs a REPL command, reporting whether to carry on.
+func (s *Session) command(line string, out io.Writer) bool {
+	name, arg := line, ""
+	if i := strings.IndexAny(line, " \t"); i >= 0 {
+		name, arg = line[:i], strings.TrimSpace(line[i:])
+	}
+
+	switch name {
+	case ":quit":
+		return false
+	case ":help":
+		fmt.Fprint(out, help)
+	case ":type":
+		if arg == "" {
+			fmt.Fprintln(out, "usage: :type expr")
+			break
+		}
+		t, errs := s.TypeOf(arg)
+		if len(errs) > 0 {
+			report(out, arg, errs)
+			break
+		}
+		fmt.Fprintln(out, t)
+	case ":dump-ir":
+		fmt.Fprint(out, s.DumpIR())
+	default:
+		fmt.Fprintf(out, "unknown command %s (:help lists them)\n", name)
+	}
+	return true
+}
+
+// report writes errors, with the line they are in for those that have one.
+func report(out io.Writer, input string, errs []error) {
+	for _, err := range errs {
+		if compileErr, ok := err.(*errors.CompileError); ok {
+			fmt.Fprintln(out, compileErr.Render(input))
+			continue
+		}
+		fmt.Fprintln(out, err)
+	}
+}
+
+// depth returns how many more braces source opens than it closes. Braces
+// in strings, chars and comments don't count.
+func depth(source string) int {
+	tokens, _ := lexer.Tokenize(source, "")
+	n := 0
+	for _, token := range tokens {
+		switch token.Type {
+		case lexer.TokenLeftBrace:
+			n++
+		case lexer.TokenRightBrace:
+			n--
+		}
+	}
+	return n
+}
@@ -0,0 +1,185 @@
+package repl
+
+import (
+	"strings"
+	"testing"
+)
+
+// eval runs lines in one session and returns what each printed, with the
+// text of its errors after it.
+func eval(t *testing.T, lines ...string) []string {
+	t.Helper()
+	session := NewSession()
+	outputs := make([]string, len(lines))
+	for i, line := range lines {
+		var out strings.Builder
+		session.Stdout = &out
+		for _, err := range session.Eval(line) {
+			out.WriteString("error: " + err.Error() + "\n")
+		}
+		outputs[i] = out.String()
+	}
+	return outputs
+}
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+		want  []string // what each line prints
+	}{
+		{
+			"a global carries over",
+			[]string{"var x = 3;", "x * 7"},
+			[]string{"", "21 : int\n"},
+		},
+		{
+			"functions and statements",
+			[]string{"func twice(n int) int { return n * 2; }", "var x int;", "x = twice(5);", "println(x);", "x"},
+			[]string{"", "", "", "10\n", "10 : int\n"},
+		},
+		{
+			"the value is shown with its type",
+			[]string{"\"hi\"", "'c'", "1.5", "2 > 1", "struct P { a int; }", "P{a: 1}", "println(1)"},
+			[]string{"\"hi\" : string\n", "'c' : char\n", "1.5 : float\n", "true : bool\n", "", "P{a: 1} : struct P\n", "1\n"},
+		},
+		{
+			"an initializer runs once",
+			[]string{"var n int;", "func next() int { n = n + 1; return n; }", "var a, b = next();", "a + b * 10 + n * 100"},
+			[]string{"", "", "", "111 : int\n"},
+		},
+		{
+			"several lines' worth at once",
+			[]string{"var total int; var i int = 1;\nwhile (i <= 4) {\n    total = total + i;\n    i = i + 1;\n}\n", "total"},
+			[]string{"", "10 : int\n"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := eval(t, tt.lines...)
+			for i := range tt.lines {
+				if got[i] != tt.want[i] {
+					t.Errorf("%q printed %q, want %q", tt.lines[i], got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+// TestEval_Errors checks that a line with an error leaves the session as
+// the lines before it left it.
+func TestEval_Errors(t *testing.T) {
+	got := eval(t,
+		"var x = 3;",
+		"var x = \"again\";",               // a redeclaration
+		"x",                                // x is still an int
+		"func f() int { return missing; }", // an error in a body
+		"func f() int { return x + 1; }",   // so f was never declared
+		"var y = 1; var z string = 2;",     // y goes with z
+		"var y = 5;",                       // so y can be declared now
+		"x / (y - 5)",                      // a runtime error
+		"x + y + f()",                      // leaves the state alone
+		"x +",                              // a syntax error
+	)
+	want := []string{
+		"",
+		"error: <input 2>:1:5: symbol x already declared at <input 1>:1:5\n",
+		"3 : int\n",
+		"error: <input 4>:1:23: undefined: missing\nerror: <input 4>:1:23: cannot assign <invalid> to int\n",
+		"",
+		"error: <input 6>:1:27: cannot assign int to string\n",
+		"",
+		"error: runtime error: integer division by zero (in $input8, block entry: t1 = x.0 / t0)\n",
+		"12 : int\n",
+		"error: <input 10>:1:4: expected expression, got EOF\n",
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("line %d printed %q, want %q", i+1, got[i], want[i])
+		}
+	}
+}
+
+func TestTypeOf(t *testing.T) {
+	session := NewSession()
+	session.Stdout = &strings.Builder{}
+	if errs := session.Eval("var calls int; func f() string { calls = calls + 1; return \"f\"; }"); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	for source, want := range map[string]string{"f()": "string", "calls * 2": "int", "f() == \"g\"": "bool"} {
+		got, errs := session.TypeOf(source)
+		if len(errs) > 0 || got.String() != want {
+			t.Errorf("TypeOf(%q) = %v (%v), want %s", source, got, errs, want)
+		}
+	}
+	if _, errs := session.TypeOf("nothing + 1"); len(errs) == 0 {
+		t.Errorf("expected an error for an undefined name")
+	}
+
+	// Nothing was evaluated
+	var out strings.Builder
+	session.Stdout = &out
+	session.Eval("calls")
+	if out.String() != "0 : int\n" {
+		t.Errorf("expected f never to have run, got %q", out.String())
+	}
+}
+
+func TestRun(t *testing.T) {
+	in := "var x = 3;\n" +
+		"x * 7\n" +
+		"func add(a int, b int) int {\n" +
+		"    return a + b;\n" +
+		"}\n" +
+		"add(x, \"}\")\n" +
+		":type add(x, 1)\n" +
+		":dump-ir\n" +
+		":what\n" +
+		":quit\n" +
+		"x\n"
+	var out strings.Builder
+	if err := Run(strings.NewReader(in), &out); err != nil {
+		t.Fatal(err)
+	}
+	want := "> > 21 : int\n" +
+		"> ... ... > <input 4>:1:8: cannot assign string to int\n" +
+		"  1 | add(x, \"}\")\n" +
+		"    |        ^\n" +
+		"> int\n" +
+		"> ; Module: main\n\n; Globals\nglobal x.0: int = const(3)\n\n" +
+		"func add(param(a.0): int, param(b.1): int) int {\nentry:\n  t2 = param(a.0) + param(b.1)\n  return t2\n\n}\n\n" +
+		"> unknown command :what (:help lists them)\n" +
+		"> "
+	if out.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
+
+func TestRun_EndOfInput(t *testing.T) {
+	var out strings.Builder
+	if err := Run(strings.NewReader("func f() {\n"), &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "expected '}'") {
+		t.Errorf("expected the unclosed brace to be reported, got %q", out.String())
+	}
+}
+
+func TestDepth(t *testing.T) {
+	tests := []struct {
+		source string
+		want   int
+	}{
+		{"x * 7", 0},
+		{"func f() {", 1},
+		{"if (a) { if (b) {", 2},
+		{"} }", -2},
+		{"var s = \"{\"; // {\n/* { */ var c = '{';", 0},
+	}
+	for _, tt := range tests {
+		if got := depth(tt.source); got != tt.want {
+			t.Errorf("depth(%q) = %d, want %d", tt.source, got, tt.want)
+		}
+	}
+}
@@ -24,8 +24,11 @@ package semantic
 
 import (
 	"fmt"
+	"sort"
 
+	"github.com/hassan/compiler/internal/errors"
 	"github.com/hassan/compiler/internal/lexer"
+	"github.com/hassan/compiler/internal/parser"
 	"github.com/hassan/compiler/internal/parser/ast"
 	"github.com/hassan/compiler/internal/semantic/types"
 	"github.com/hassan/compiler/internal/symtab"
@@ -47,6 +50,11 @@ type Analyzer struct {
 	// errors accumulates all semantic errors
 	errors []error
 
+	// warnings accumulates diagnostics that don't stop compilation
+	// (unused names, shadowing, unreachable code). They're kept apart from
+	// errors so that Analyze's contract - "non-empty means failure" - holds.
+	warnings []error
+
 	// exprTypes maps expressions to their computed types
 	// We store this separately rather than modifying the AST because:
 	// - AST is immutable (good for concurrent access)
@@ -54,75 +62,228 @@ type Analyzer struct {
 	// - Cleaner separation of concerns
 	exprTypes map[ast.Expr]types.Type
 
+	// symbols maps each identifier naming a declared thing, where it is
+	// declared and where it is used, to that thing's symbol (see hover.go
+	// and references.go)
+	symbols map[*ast.IdentifierExpr]*symtab.Symbol
+
+	// intConstants maps integer expressions built only from literals to
+	// their value, for the overflow check (see constant.go)
+	intConstants map[ast.Expr]int64
+
+	// conversions holds the calls that are type conversions, T(x), rather
+	// than function calls (see conversion.go)
+	conversions map[*ast.CallExpr]bool
+
+	// exhaustive holds the switches that run one of their cases whatever
+	// the value (see switch.go)
+	exhaustive map[*ast.SwitchStmt]bool
+
+	// widenings maps the integer expressions assigned to a wider type to
+	// that type (see conversion.go)
+	widenings map[ast.Expr]types.Type
+
 	// currentFunction tracks the function we're currently analyzing
 	// Used for:
 	// - Checking return types
 	// - Determining if we're in a function (for return statements)
 	currentFunction *symtab.Symbol
+
+	// definitelyAssigned maps each tracked local of the current function
+	// to whether it is assigned on every path to the statement being
+	// checked (see assignment.go). Variables not in the map - globals,
+	// parameters, locals with an initializer - are never checked.
+	definitelyAssigned map[*symtab.Symbol]bool
+
+	// switchBreaks collects the definite-assignment state at each break
+	// out of a switch, keyed by the switch's scope: those paths rejoin
+	// after the switch, not at the end of a case
+	switchBreaks map[*symtab.Scope][]map[*symtab.Symbol]bool
+
+	// importer resolves import paths to analyzed packages. When nil, imports
+	// only declare a package name and nothing can be accessed through it.
+	importer Importer
+
+	// failedImports holds the import paths that could not be imported, so
+	// that importing one again - in another file, or twice in one - isn't
+	// reported again
+	failedImports map[string]bool
+
+	// unresolved holds the expressions whose type is Invalid because they
+	// use a member of a package that failed to import. That failure has
+	// been reported, so the checks on these expressions stay quiet (see
+	// checkPackageMember).
+	unresolved map[ast.Expr]bool
+
+	// leaks is the escape summary of each function analyzed: which of its
+	// parameters may let a pointer passed to them escape (see escape.go)
+	leaks map[*symtab.Symbol][]bool
+
+	// strictShadowing reports shadowed declarations as errors rather than
+	// warnings (see SetStrictShadowing)
+	strictShadowing bool
 }
 
 // New creates a new semantic analyzer.
 func New() *Analyzer {
-	globalScope := symtab.NewScope(symtab.ScopeGlobal, nil)
+	globalScope := symtab.NewScope(symtab.ScopeGlobal, newUniverse())
 	return &Analyzer{
 		currentScope: globalScope,
 		globalScope:  globalScope,
 		errors:       make([]error, 0),
 		exprTypes:    make(map[ast.Expr]types.Type),
+		symbols:      make(map[*ast.IdentifierExpr]*symtab.Symbol),
+		intConstants: make(map[ast.Expr]int64),
+		conversions:  make(map[*ast.CallExpr]bool),
+		exhaustive:   make(map[*ast.SwitchStmt]bool),
+		widenings:    make(map[ast.Expr]types.Type),
+
+		definitelyAssigned: make(map[*symtab.Symbol]bool),
+		switchBreaks:       make(map[*symtab.Scope][]map[*symtab.Symbol]bool),
+		failedImports:      make(map[string]bool),
+		unresolved:         make(map[ast.Expr]bool),
+		leaks:              make(map[*symtab.Symbol][]bool),
 	}
 }
 
+// SetStrictShadowing makes a declaration that shadows a local of an
+// enclosing scope an error instead of a W003 warning.
+//
+// DESIGN CHOICE: Its own switch rather than relying on warnings-as-errors.
+// Shadowing is the warning most often a real bug ("var x = ..." in a block,
+// meant as "x = ..."), so a project can rule it out while still treating
+// an unused variable mid-edit as just a warning.
+func (a *Analyzer) SetStrictShadowing(strict bool) {
+	a.strictShadowing = strict
+}
+
 // Analyze performs semantic analysis on a file.
 // Returns the list of errors found (empty if no errors).
 func (a *Analyzer) Analyze(file *ast.File) []error {
+	return a.AnalyzeFiles([]*ast.File{file})
+}
+
+// AnalyzeFiles performs semantic analysis on all the files of one package.
+// Returns the list of errors found (empty if no errors).
+//
+// DESIGN CHOICE: The files share a single global scope, exactly as if they
+// had been concatenated, so a function in a.src can call one declared in
+// b.src. To make that work regardless of file order, analysis runs in passes
+// over the whole set rather than file by file:
+//  1. Declare every top-level name (so any name can be referenced)
+//  2. Resolve type declarations, then function signatures (so calls to a
+//     function declared later - or in another file - know its type)
+//  3. Check global variables, then function bodies
+//
+// A name declared twice is reported at the second declaration; the message
+// names the position of the first, so both are visible even across files.
+func (a *Analyzer) AnalyzeFiles(files []*ast.File) []error {
 	// Reset state
 	a.errors = make([]error, 0)
+	a.warnings = make([]error, 0)
 	a.exprTypes = make(map[ast.Expr]types.Type)
+	a.symbols = make(map[*ast.IdentifierExpr]*symtab.Symbol)
+	a.intConstants = make(map[ast.Expr]int64)
+	a.conversions = make(map[*ast.CallExpr]bool)
+	a.exhaustive = make(map[*ast.SwitchStmt]bool)
+	a.widenings = make(map[ast.Expr]types.Type)
+	a.definitelyAssigned = make(map[*symtab.Symbol]bool)
+	a.switchBreaks = make(map[*symtab.Scope][]map[*symtab.Symbol]bool)
+	a.leaks = make(map[*symtab.Symbol][]bool)
 	a.currentScope = a.globalScope
 
-	// Process package declaration
-	if file.Package == nil {
-		a.error(lexer.Position{}, "missing package declaration")
+	// Process package declarations: every file must have one, and they must
+	// all agree.
+	var pkg *ast.PackageDecl
+	for _, file := range files {
+		if file.Package == nil || file.Package.Name == nil {
+			a.error(lexer.Position{Filename: file.Filename}, "missing package declaration")
+			continue
+		}
+		if pkg == nil {
+			pkg = file.Package
+			continue
+		}
+		if file.Package.Name.Name != pkg.Name.Name {
+			a.error(file.Package.Name.Pos(), fmt.Sprintf(
+				"package %s; expected %s (declared at %s)",
+				file.Package.Name.Name, pkg.Name.Name, pkg.Name.Pos()))
+		}
+	}
+	if len(a.errors) > 0 {
 		return a.errors
 	}
 
 	// Process imports
-	for _, imp := range file.Imports {
-		a.processImport(imp)
+	pkgSet := parser.NewPackageSet(files)
+	for _, imp := range pkgSet.Imports() {
+		a.processImport(imp, pkg.Name.Name)
 	}
 
-	// Process declarations
-	// We do this in two passes:
-	// 1. Declare all names (to allow forward references)
-	// 2. Check all bodies
-	for _, decl := range file.Decls {
+	// Pass 1: declare all names (to allow forward references)
+	decls := pkgSet.Decls()
+	for _, decl := range decls {
 		a.declareDecl(decl)
 	}
+	a.checkDecls(decls)
+	a.checkGlobalInitializers(decls)
 
-	for _, decl := range file.Decls {
-		_ = decl.Accept(a)
-	}
+	// Every body has been checked, so any import still unused is dead. The
+	// path is reported rather than the name: for an aliased import the name
+	// doesn't say which package it was
+	a.warnUnused(a.globalScope, symtab.SymbolPackage, errors.CodeUnusedImport, func(symbol *symtab.Symbol) string {
+		return fmt.Sprintf("imported and not used: %q", symbol.ImportPath)
+	})
 
 	return a.errors
 }
 
-// processImport processes an import declaration
-func (a *Analyzer) processImport(imp *ast.ImportDecl) {
-	name := imp.Path.Value.(string)
-	if imp.Name != nil {
-		name = imp.Name.Name
+// checkDecls runs the passes after the first over top-level declarations
+// whose names are all declared already.
+func (a *Analyzer) checkDecls(decls []ast.Decl) {
+	// Pass 2: resolve types before signatures, since signatures mention them
+	for _, decl := range decls {
+		switch decl.(type) {
+		case *ast.StructDecl, *ast.TypeDecl:
+			_ = decl.Accept(a)
+		}
 	}
-
-	symbol := &symtab.Symbol{
-		Name: name,
-		Kind: symtab.SymbolPackage,
-		Type: types.Invalid, // Packages don't have a type
-		Pos:  imp.Pos(),
+	for _, decl := range decls {
+		if fn, ok := decl.(*ast.FuncDecl); ok {
+			a.resolveSignature(fn)
+		}
 	}
 
-	if err := a.currentScope.Define(symbol); err != nil {
-		a.error(imp.Pos(), err.Error())
+	// Pass 3: check globals before bodies, so a body in an earlier file sees
+	// the type of a global declared in a later one
+	for _, decl := range decls {
+		if _, ok := decl.(*ast.VarDecl); ok {
+			_ = decl.Accept(a)
+		}
+	}
+	var funcs []*ast.FuncDecl
+	for _, decl := range decls {
+		if fn, ok := decl.(*ast.FuncDecl); ok {
+			_ = decl.Accept(a)
+			funcs = append(funcs, fn)
+		}
 	}
+
+	// Pass 4: escape analysis, which needs every body checked (see
+	// escape.go)
+	a.analyzeEscapes(funcs)
+}
+
+// Errors returns the errors found by the last Analyze, AnalyzeFiles or
+// AnalyzeIncremental call: the same errors that call returned.
+func (a *Analyzer) Errors() []error {
+	return a.errors
+}
+
+// Warnings returns the warnings found by the last Analyze, AnalyzeFiles or
+// AnalyzeIncremental call.
+func (a *Analyzer) Warnings() []error {
+	return a.warnings
 }
 
 // declareDecl declares a top-level declaration without checking its body
@@ -142,6 +303,7 @@ func (a *Analyzer) declareDecl(decl ast.Decl) {
 			if err := a.currentScope.Define(symbol); err != nil {
 				a.error(name.Pos(), err.Error())
 			}
+			a.symbols[name] = symbol
 		}
 
 	case *ast.FuncDecl:
@@ -155,31 +317,44 @@ func (a *Analyzer) declareDecl(decl ast.Decl) {
 		if err := a.currentScope.Define(symbol); err != nil {
 			a.error(d.Name.Pos(), err.Error())
 		}
+		a.symbols[d.Name] = symbol
 
 	case *ast.StructDecl:
 		// Declare struct type
+		// The struct type is created now, with its fields filled in later by
+		// VisitStructDecl. Handing out the same *StructType up front lets
+		// other declarations refer to the struct before its fields resolve.
 		symbol := &symtab.Symbol{
 			Name:   d.Name.Name,
 			Kind:   symtab.SymbolStruct,
-			Type:   types.Invalid, // Will be set during checking
+			Type:   types.NewStruct(d.Name.Name, nil),
 			Pos:    d.Pos(),
 			Fields: make(map[string]*symtab.Symbol),
 		}
 		if err := a.currentScope.Define(symbol); err != nil {
 			a.error(d.Name.Pos(), err.Error())
 		}
+		a.symbols[d.Name] = symbol
 
 	case *ast.TypeDecl:
-		// Declare type alias
+		// Declare type alias or named type
+		// An alias's type is only known once its right-hand side resolves,
+		// but a named type is itself a new type: like a struct, it is
+		// created now and its underlying type filled in by VisitTypeDecl.
+		var typ types.Type = types.Invalid // Will be set during checking
+		if !d.IsAlias {
+			typ = types.NewNamed(d.Name.Name, nil)
+		}
 		symbol := &symtab.Symbol{
 			Name: d.Name.Name,
 			Kind: symtab.SymbolType,
-			Type: types.Invalid, // Will be set during checking
+			Type: typ,
 			Pos:  d.Pos(),
 		}
 		if err := a.currentScope.Define(symbol); err != nil {
 			a.error(d.Name.Pos(), err.Error())
 		}
+		a.symbols[d.Name] = symbol
 	}
 }
 
@@ -202,7 +377,7 @@ func (a *Analyzer) VisitVarDecl(decl *ast.VarDecl) error {
 
 		// Check initializer type matches declared type (if both present)
 		if decl.Initializer != nil {
-			if !a.assignable(initType, varType, decl.Initializer.Pos()) {
+			if !a.assignable(decl.Initializer, initType, varType) {
 				// Error already reported by assignable
 			}
 		}
@@ -214,13 +389,17 @@ func (a *Analyzer) VisitVarDecl(decl *ast.VarDecl) error {
 		varType = types.Invalid
 	}
 
-	// Declare or update symbols
+	// Declare or update symbols. Each name is given the variable's type
+	// too, for the IR builder to type the variable with
 	for _, name := range decl.Names {
+		a.exprTypes[name] = varType
 		symbol := a.currentScope.LookupLocal(name.Name)
 		if symbol != nil {
 			// Update existing symbol (global scope)
 			symbol.Type = varType
 		} else {
+			a.checkShadowing(name.Name, name.Pos())
+
 			// Declare new symbol (local scope)
 			symbol = &symtab.Symbol{
 				Name:     name.Name,
@@ -232,13 +411,21 @@ func (a *Analyzer) VisitVarDecl(decl *ast.VarDecl) error {
 			if err := a.currentScope.Define(symbol); err != nil {
 				a.error(name.Pos(), err.Error())
 			}
+			a.symbols[name] = symbol
+			if decl.Initializer != nil {
+				a.definitelyAssigned[symbol] = true
+			} else if tracksAssignment(symbol) {
+				a.definitelyAssigned[symbol] = false
+			}
 		}
 	}
 
 	return nil
 }
 
-func (a *Analyzer) VisitFuncDecl(decl *ast.FuncDecl) error {
+// resolveSignature computes a function's type from its declaration and
+// records it on the function's symbol.
+func (a *Analyzer) resolveSignature(decl *ast.FuncDecl) *types.FunctionType {
 	// Build parameter types
 	paramTypes := make([]types.Type, len(decl.Params))
 	for i, param := range decl.Params {
@@ -258,14 +445,32 @@ func (a *Analyzer) VisitFuncDecl(decl *ast.FuncDecl) error {
 
 	// Update the function symbol
 	symbol := a.globalScope.LookupLocal(decl.Name.Name)
-	if symbol != nil {
+	if symbol != nil && symbol.Kind == symtab.SymbolFunction {
 		symbol.Type = funcType
 	}
 
+	return funcType
+}
+
+func (a *Analyzer) VisitFuncDecl(decl *ast.FuncDecl) error {
+	// The signature was normally resolved in pass 2; resolving it again
+	// would report any bad parameter types twice.
+	symbol := a.globalScope.LookupLocal(decl.Name.Name)
+	var funcType *types.FunctionType
+	if symbol != nil {
+		funcType, _ = symbol.Type.(*types.FunctionType)
+	}
+	if funcType == nil || symbol.Pos != decl.Pos() {
+		funcType = a.resolveSignature(decl)
+	}
+	paramTypes := funcType.Parameters
+
 	// Create function scope
 	a.enterScope(symtab.ScopeFunction)
 	a.currentScope.Function = symbol
 	a.currentFunction = symbol
+	a.definitelyAssigned = make(map[*symtab.Symbol]bool)
+	a.switchBreaks = make(map[*symtab.Scope][]map[*symtab.Symbol]bool)
 
 	// Add parameters to scope
 	for i, param := range decl.Params {
@@ -279,11 +484,18 @@ func (a *Analyzer) VisitFuncDecl(decl *ast.FuncDecl) error {
 		if err := a.currentScope.Define(paramSymbol); err != nil {
 			a.error(param.Pos(), err.Error())
 		}
+		a.symbols[param.Name] = paramSymbol
 	}
 
 	// Check function body
 	if decl.Body != nil {
 		_ = decl.Body.Accept(a)
+
+		// The closing brace is where a path without a return ends up
+		returnType := funcType.ReturnType
+		if !returnType.Equals(types.Void) && !returnType.Equals(types.Invalid) && !a.terminates(decl.Body) {
+			a.error(decl.Body.End(), "missing return")
+		}
 	}
 
 	a.exitScope()
@@ -313,15 +525,18 @@ func (a *Analyzer) VisitStructDecl(decl *ast.StructDecl) error {
 			Index: i,
 		}
 		fieldSymbols[field.Name.Name] = fieldSymbol
+		a.symbols[field.Name] = fieldSymbol
 	}
 
-	// Create struct type
-	structType := types.NewStruct(decl.Name.Name, structFields)
-
-	// Update the struct symbol
+	// Fill in the struct type created when the name was declared, so
+	// references resolved before now see the fields too
 	symbol := a.globalScope.LookupLocal(decl.Name.Name)
-	if symbol != nil {
-		symbol.Type = structType
+	if symbol != nil && symbol.Pos == decl.Pos() {
+		if structType, ok := symbol.Type.(*types.StructType); ok {
+			structType.Fields = structFields
+		} else {
+			symbol.Type = types.NewStruct(decl.Name.Name, structFields)
+		}
 		symbol.Fields = fieldSymbols
 	}
 
@@ -329,15 +544,37 @@ func (a *Analyzer) VisitStructDecl(decl *ast.StructDecl) error {
 }
 
 func (a *Analyzer) VisitTypeDecl(decl *ast.TypeDecl) error {
-	// Resolve the aliased type
-	aliasedType := a.resolveType(decl.Type)
+	// Resolve the aliased or underlying type
+	resolved := a.resolveType(decl.Type)
 
-	// Update the type symbol
+	// Update the type symbol, unless it belongs to an earlier declaration
+	// of the same name
 	symbol := a.globalScope.LookupLocal(decl.Name.Name)
-	if symbol != nil {
-		symbol.Type = aliasedType
+	if symbol == nil || symbol.Pos != decl.Pos() {
+		return nil
+	}
+	named, ok := symbol.Type.(*types.NamedType)
+	if !ok {
+		symbol.Type = resolved
+		return nil
 	}
 
+	// A named type declared, directly or through others, in terms of
+	// itself has no representation: type A B; type B A;
+	for t := resolved; ; {
+		next, ok := t.(*types.NamedType)
+		if !ok {
+			break
+		}
+		if next == named {
+			a.error(decl.Name.Pos(), fmt.Sprintf("invalid recursive type %s", decl.Name.Name))
+			resolved = types.Invalid
+			break
+		}
+		t = next.Underlying
+	}
+	named.Underlying = resolved
+
 	return nil
 }
 
@@ -350,40 +587,66 @@ func (a *Analyzer) VisitExprStmt(stmt *ast.ExprStmt) error {
 
 func (a *Analyzer) VisitBlockStmt(stmt *ast.BlockStmt) error {
 	a.enterScope(symtab.ScopeBlock)
-	for _, s := range stmt.Statements {
-		_ = s.Accept(a)
-	}
+	a.checkStmts(stmt.Statements)
 	a.exitScope()
 	return nil
 }
 
+// checkStmts checks a statement list, warning once about the first statement
+// that follows an unconditional jump (return, break, continue) in the list.
+func (a *Analyzer) checkStmts(stmts []ast.Stmt) {
+	jumped := false
+	for _, s := range stmts {
+		if jumped {
+			a.warn(errors.CodeUnreachable, s.Pos(), "unreachable code")
+			jumped = false // one warning per run of dead statements
+		}
+		_ = s.Accept(a)
+
+		switch s.(type) {
+		case *ast.ReturnStmt, *ast.BreakStmt, *ast.ContinueStmt:
+			jumped = true
+		}
+	}
+}
+
 func (a *Analyzer) VisitIfStmt(stmt *ast.IfStmt) error {
 	// Check condition
+	a.checkAssignedCondition(stmt.Condition, "condition")
 	condType, _ := stmt.Condition.Accept(a)
-	if !types.IsBooleanType(condType.(types.Type)) {
+	if !types.IsBooleanType(condType.(types.Type)) && !a.unresolved[stmt.Condition] {
 		a.error(stmt.Condition.Pos(), "condition must be boolean")
 	}
 
-	// Check branches
+	// Check branches, each from the state after the condition. Without an
+	// else, the false path reaches the end with that state unchanged
+	before := a.copyAssigned()
 	_ = stmt.ThenBranch.Accept(a)
+	thenAssigned := a.definitelyAssigned
+	a.restoreAssigned(before)
 	if stmt.ElseBranch != nil {
 		_ = stmt.ElseBranch.Accept(a)
 	}
+	a.joinAssigned(before, thenAssigned, a.definitelyAssigned)
 
 	return nil
 }
 
 func (a *Analyzer) VisitWhileStmt(stmt *ast.WhileStmt) error {
 	// Check condition
+	a.checkAssignedCondition(stmt.Condition, "condition")
 	condType, _ := stmt.Condition.Accept(a)
-	if !types.IsBooleanType(condType.(types.Type)) {
+	if !types.IsBooleanType(condType.(types.Type)) && !a.unresolved[stmt.Condition] {
 		a.error(stmt.Condition.Pos(), "condition must be boolean")
 	}
 
-	// Check body
+	// Check body. It may not run at all, so what it assigns doesn't count
+	// after the loop
+	before := a.copyAssigned()
 	a.enterScope(symtab.ScopeLoop)
 	_ = stmt.Body.Accept(a)
 	a.exitScope()
+	a.definitelyAssigned = before
 
 	return nil
 }
@@ -398,19 +661,21 @@ func (a *Analyzer) VisitForStmt(stmt *ast.ForStmt) error {
 
 	// Check condition
 	if stmt.Condition != nil {
+		a.checkAssignedCondition(stmt.Condition, "condition")
 		condType, _ := stmt.Condition.Accept(a)
-		if !types.IsBooleanType(condType.(types.Type)) {
+		if !types.IsBooleanType(condType.(types.Type)) && !a.unresolved[stmt.Condition] {
 			a.error(stmt.Condition.Pos(), "condition must be boolean")
 		}
 	}
 
-	// Check post
+	// Check the body before post, the order they run in. Neither need
+	// run, so only init and the condition assign for after the loop
+	before := a.copyAssigned()
+	_ = stmt.Body.Accept(a)
 	if stmt.Post != nil {
 		_ = stmt.Post.Accept(a)
 	}
-
-	// Check body
-	_ = stmt.Body.Accept(a)
+	a.definitelyAssigned = before
 
 	a.exitScope()
 	return nil
@@ -430,7 +695,7 @@ func (a *Analyzer) VisitReturnStmt(stmt *ast.ReturnStmt) error {
 	// Check return value
 	if stmt.Value != nil {
 		returnType, _ := stmt.Value.Accept(a)
-		if !a.assignable(returnType.(types.Type), expectedType, stmt.Value.Pos()) {
+		if !a.assignable(stmt.Value, returnType.(types.Type), expectedType) {
 			// Error already reported
 		}
 	} else {
@@ -440,13 +705,20 @@ func (a *Analyzer) VisitReturnStmt(stmt *ast.ReturnStmt) error {
 		}
 	}
 
+	a.markAllAssigned()
 	return nil
 }
 
 func (a *Analyzer) VisitBreakStmt(stmt *ast.BreakStmt) error {
-	if a.currentScope.FindEnclosingLoopOrSwitch() == nil {
+	target := a.currentScope.FindEnclosingLoopOrSwitch()
+	if target == nil {
 		a.error(stmt.Pos(), "break outside loop or switch")
+	} else if target.IsSwitch() {
+		// Leaving a loop needs nothing recorded: after a loop only the
+		// state before it counts anyway
+		a.switchBreaks[target] = append(a.switchBreaks[target], a.copyAssigned())
 	}
+	a.markAllAssigned()
 	return nil
 }
 
@@ -454,33 +726,64 @@ func (a *Analyzer) VisitContinueStmt(stmt *ast.ContinueStmt) error {
 	if a.currentScope.FindEnclosingLoop() == nil {
 		a.error(stmt.Pos(), "continue outside loop")
 	}
+	a.markAllAssigned()
 	return nil
 }
 
 func (a *Analyzer) VisitSwitchStmt(stmt *ast.SwitchStmt) error {
 	// Check value
+	a.checkAssignedCondition(stmt.Value, "switch value")
 	valueType, _ := stmt.Value.Accept(a)
+	if len(stmt.Cases) == 0 {
+		a.warn(errors.CodeEmptySwitch, stmt.Pos(), "switch has no cases")
+	}
 
 	a.enterScope(symtab.ScopeSwitch)
+	switchScope := a.currentScope
+
+	// Each case starts from the state after the value. The paths leaving
+	// the switch are the end of every case, every break, and - without a
+	// default - the value matching no case
+	before := a.copyAssigned()
+	var exits []map[*symtab.Symbol]bool
+	hasDefault := false
+	var defaultPos lexer.Position
+	seen := make(map[interface{}]lexer.Position)
 
 	// Check cases
 	for _, c := range stmt.Cases {
+		a.restoreAssigned(before)
+		if c.IsDefault {
+			if hasDefault {
+				a.error(c.Pos(), fmt.Sprintf("multiple defaults in switch (previous at %s)", defaultPos))
+			}
+			hasDefault = true
+			defaultPos = c.Pos()
+		}
+
 		if !c.IsDefault {
 			for _, val := range c.Values {
 				caseType, _ := val.Accept(a)
-				if !a.assignable(caseType.(types.Type), valueType.(types.Type), val.Pos()) {
+				if !a.assignable(val, caseType.(types.Type), valueType.(types.Type)) {
 					// Error already reported
 				}
+				a.checkCaseValue(val, seen)
 			}
 		}
 
 		// Check body
-		for _, s := range c.Body {
-			_ = s.Accept(a)
-		}
+		a.checkStmts(c.Body)
+		exits = append(exits, a.definitelyAssigned)
 	}
 
 	a.exitScope()
+	exits = append(exits, a.switchBreaks[switchScope]...)
+	delete(a.switchBreaks, switchScope)
+	a.exhaustive[stmt] = isExhaustive(valueType.(types.Type), hasDefault, seen)
+	if !a.exhaustive[stmt] {
+		exits = append(exits, before)
+	}
+	a.joinAssigned(before, exits...)
 	return nil
 }
 
@@ -493,8 +796,12 @@ func (a *Analyzer) enterScope(kind symtab.ScopeKind) {
 	a.currentScope = symtab.NewScope(kind, a.currentScope)
 }
 
-// exitScope returns to the parent scope
+// exitScope returns to the parent scope, first warning about any local
+// variable of the scope being left that was never read
 func (a *Analyzer) exitScope() {
+	a.warnUnused(a.currentScope, symtab.SymbolVariable, errors.CodeUnusedVariable, func(symbol *symtab.Symbol) string {
+		return fmt.Sprintf("variable %s declared but not used", symbol.Name)
+	})
 	if a.currentScope.Parent != nil {
 		a.currentScope = a.currentScope.Parent
 	}
@@ -502,31 +809,102 @@ func (a *Analyzer) exitScope() {
 
 // error records a semantic error
 func (a *Analyzer) error(pos lexer.Position, message string) {
-	if pos.IsValid() {
-		a.errors = append(a.errors, fmt.Errorf("%s: %s", pos.String(), message))
-	} else {
-		a.errors = append(a.errors, fmt.Errorf("%s", message))
+	a.errors = append(a.errors, errors.New(errors.CodeSemantic, pos, message))
+}
+
+// errorSpan records a semantic error that covers the source from pos to end
+func (a *Analyzer) errorSpan(pos, end lexer.Position, message string) {
+	a.errors = append(a.errors, errors.New(errors.CodeSemantic, pos, message).WithEnd(end))
+}
+
+// warn records a warning: something legal but probably a mistake
+func (a *Analyzer) warn(code string, pos lexer.Position, message string) {
+	a.warnings = append(a.warnings, errors.NewWarning(code, pos, message))
+}
+
+// warnUnused warns about every symbol of the given kind in scope that was
+// never looked up, with the message built for each symbol. Symbols are
+// reported in source order: the scope keeps them in declaration order, but
+// the globals of a package declared across several files are sorted by
+// file as well.
+//
+// The blank name _ is never reported: a variable named _ discards its value,
+// and a blank import is made for the package's side effects alone.
+func (a *Analyzer) warnUnused(scope *symtab.Scope, kind symtab.SymbolKind, code string, message func(*symtab.Symbol) string) {
+	var unused []*symtab.Symbol
+	for _, symbol := range scope.UnusedSymbols() {
+		if symbol.Kind == kind && symbol.Name != "_" {
+			unused = append(unused, symbol)
+		}
+	}
+	sort.Slice(unused, func(i, j int) bool {
+		if unused[i].Pos.Filename != unused[j].Pos.Filename {
+			return unused[i].Pos.Filename < unused[j].Pos.Filename
+		}
+		return unused[i].Pos.Before(unused[j].Pos)
+	})
+	for _, symbol := range unused {
+		a.warn(code, symbol.Pos, message(symbol))
+	}
+}
+
+// checkShadowing warns when a new local hides a variable or parameter of an
+// enclosing function-level scope, or reports an error with strict
+// shadowing. Globals are deliberately exempt: a local (or a parameter)
+// named like some distant global is common and usually intended.
+func (a *Analyzer) checkShadowing(name string, pos lexer.Position) {
+	if a.currentScope.IsGlobal() {
+		return
+	}
+	// LookupLocal rather than Lookup: Lookup would mark the outer symbol as
+	// used and hide a genuine unused-variable warning.
+	for scope := a.currentScope.Parent; scope != nil && !scope.IsGlobal(); scope = scope.Parent {
+		if outer := scope.LookupLocal(name); outer != nil {
+			if outer.Kind == symtab.SymbolVariable || outer.Kind == symtab.SymbolParameter {
+				message := fmt.Sprintf("declaration of %s shadows %s declared at %s", name, outer.Kind, outer.Pos)
+				if a.strictShadowing {
+					a.error(pos, message)
+				} else {
+					a.warn(errors.CodeShadowed, pos, message)
+				}
+			}
+			return
+		}
 	}
 }
 
+// checkAssignedCondition warns about an assignment written directly as the
+// condition of an if or a loop, or as a switch value: "if (done = true)"
+// type-checks when done is a bool, but is almost always a mistyped ==.
+//
+// DESIGN CHOICE: An extra pair of parentheses, "if ((line = next()))",
+// says the assignment is meant, as C compilers accept. The parentheses of
+// the statement itself don't count: they are syntax, not a GroupingExpr.
+func (a *Analyzer) checkAssignedCondition(expr ast.Expr, what string) {
+	assign, ok := expr.(*ast.AssignmentExpr)
+	if !ok || assign.Operator.Type != lexer.TokenAssign {
+		return
+	}
+	a.warn(errors.CodeAssignCond, assign.Operator.Position,
+		fmt.Sprintf("assignment used as %s; did you mean ==?", what))
+}
+
 // resolveType converts an AST type expression to a Type
 func (a *Analyzer) resolveType(typeExpr ast.Expr) types.Type {
+	// A pointer type, *T, is parsed as a dereference of T
+	if unary, ok := typeExpr.(*ast.UnaryExpr); ok && unary.Operator.Type == lexer.TokenStar {
+		elem := a.resolveType(unary.Operand)
+		if elem.Equals(types.Invalid) {
+			return types.Invalid
+		}
+		return types.NewPointer(elem)
+	}
+
 	// For now, we only support identifier types
 	if ident, ok := typeExpr.(*ast.IdentifierExpr); ok {
 		// Check built-in types
-		switch ident.Name {
-		case "int":
-			return types.Int
-		case "float":
-			return types.Float
-		case "bool":
-			return types.Bool
-		case "string":
-			return types.String
-		case "char":
-			return types.Char
-		case "void":
-			return types.Void
+		if t := primitiveType(ident.Name); t != nil {
+			return t
 		}
 
 		// Look up user-defined type
@@ -541,6 +919,7 @@ func (a *Analyzer) resolveType(typeExpr ast.Expr) types.Type {
 			return types.Invalid
 		}
 
+		a.reference(ident, symbol)
 		return symbol.Type
 	}
 
@@ -548,14 +927,62 @@ func (a *Analyzer) resolveType(typeExpr ast.Expr) types.Type {
 	return types.Invalid
 }
 
-// assignable checks if valueType can be assigned to targetType
-// Reports an error if not assignable
-func (a *Analyzer) assignable(valueType, targetType types.Type, pos lexer.Position) bool {
+// primitiveType returns the built-in type called name, or nil if there is
+// none. Built-in type names can't be shadowed. int64 is another name for
+// int, as the 64-bit signed type.
+func primitiveType(name string) types.Type {
+	switch name {
+	case "int":
+		return types.Int
+	case "float":
+		return types.Float
+	case "bool":
+		return types.Bool
+	case "string":
+		return types.String
+	case "char":
+		return types.Char
+	case "void":
+		return types.Void
+	case "int8":
+		return types.Int8
+	case "int16":
+		return types.Int16
+	case "int32":
+		return types.Int32
+	case "int64":
+		return types.Int
+	case "uint8":
+		return types.Uint8
+	case "uint16":
+		return types.Uint16
+	case "uint32":
+		return types.Uint32
+	case "uint64":
+		return types.Uint64
+	}
+	return nil
+}
+
+// assignable checks if value, of type valueType, can be assigned to
+// targetType. An int constant can also be assigned to a sized integer type
+// (see convertConstant), and an integer to a wider one of the same
+// signedness, which is recorded. Reports an error if not assignable
+func (a *Analyzer) assignable(value ast.Expr, valueType, targetType types.Type) bool {
+	if a.unresolved[value] {
+		return false
+	}
 	if valueType.AssignableTo(targetType) {
+		if !valueType.Equals(targetType) && types.IsIntegerType(valueType) {
+			a.widenings[value] = targetType
+		}
+		return true
+	}
+	if a.convertConstant(value, valueType, targetType) {
 		return true
 	}
 
-	a.error(pos, fmt.Sprintf("cannot assign %s to %s", valueType, targetType))
+	a.error(value.Pos(), fmt.Sprintf("cannot assign %s to %s", valueType, targetType))
 	return false
 }
 
@@ -567,6 +994,24 @@ func (a *Analyzer) GetExprType(expr ast.Expr) types.Type {
 	return types.Invalid
 }
 
+// IsExhaustive reports whether one of the cases of a switch runs whatever
+// its value, so no path goes from the value straight past the switch.
+func (a *Analyzer) IsExhaustive(stmt *ast.SwitchStmt) bool {
+	return a.exhaustive[stmt]
+}
+
+// Widening returns the wider integer type expr is assigned to, or nil if
+// its value is used with its own type.
+func (a *Analyzer) Widening(expr ast.Expr) types.Type {
+	return a.widenings[expr]
+}
+
+// IsConversion reports whether a call is a type conversion, T(x), whose
+// value is its argument's, rather than a call of a function.
+func (a *Analyzer) IsConversion(expr *ast.CallExpr) bool {
+	return a.conversions[expr]
+}
+
 // GetScope returns the global scope (for inspection)
 func (a *Analyzer) GetScope() *symtab.Scope {
 	return a.globalScope
@@ -0,0 +1,102 @@
+package semantic
+
+import (
+	"github.com/hassan/compiler/internal/parser/ast"
+	"github.com/hassan/compiler/internal/semantic/types"
+	"github.com/hassan/compiler/internal/symtab"
+)
+
+// Definite assignment: a local declared without an initializer must be
+// assigned on every path before it is read.
+//
+// EXAMPLE:
+//
+//	var x int;
+//	if (c) { x = 1; }
+//	return x;        // error: no assignment when c is false
+//
+// The analyzer carries the set of tracked variables assigned so far in
+// definitelyAssigned, updated as statements are checked in order:
+// - A declaration without an initializer starts tracking the variable
+// - An assignment to the variable marks it
+// - Where paths join (after an if or a switch), a variable stays marked
+//   only if every incoming path marked it
+// - A loop body may run zero times, so after the loop only what was
+//   assigned before it counts
+// - After return, break or continue nothing flows on, so every variable
+//   is marked: the state then can't veto a join
+//
+// DESIGN CHOICE: Done during the ordinary walk over statements rather than as
+// a separate data-flow pass over the CFG. The analyzer already visits
+// statements in execution order and knows each branch's extent, so copying
+// the set at a branch and merging it after is all the machinery needed, and
+// the error is reported at the very identifier being read.
+//
+// Only scalar locals are tracked. Globals have their initial value before
+// main runs, parameters are assigned by the caller, and an array or struct
+// declared without an initializer is storage that is filled in piece by
+// piece ("var p Point; p.x = 1;"), which a whole-variable check can't
+// follow.
+
+// tracksAssignment reports whether a local declared as symbol without an
+// initializer must be assigned before it is read.
+func tracksAssignment(symbol *symtab.Symbol) bool {
+	switch types.Underlying(symbol.Type).(type) {
+	case *types.ArrayType, *types.StructType:
+		return false
+	}
+	return true
+}
+
+// copyAssigned returns a copy of the current definite-assignment state, to
+// restore when checking another path from the same point.
+func (a *Analyzer) copyAssigned() map[*symtab.Symbol]bool {
+	c := make(map[*symtab.Symbol]bool, len(a.definitelyAssigned))
+	for symbol, assigned := range a.definitelyAssigned {
+		c[symbol] = assigned
+	}
+	return c
+}
+
+// restoreAssigned goes back to a state saved with copyAssigned, keeping the
+// saved copy intact for the next path.
+func (a *Analyzer) restoreAssigned(saved map[*symtab.Symbol]bool) {
+	a.definitelyAssigned = make(map[*symtab.Symbol]bool, len(saved))
+	for symbol, assigned := range saved {
+		a.definitelyAssigned[symbol] = assigned
+	}
+}
+
+// markAllAssigned records that the current point can't be reached by falling
+// through: after a jump, every variable counts as assigned.
+func (a *Analyzer) markAllAssigned() {
+	for symbol := range a.definitelyAssigned {
+		a.definitelyAssigned[symbol] = true
+	}
+}
+
+// joinAssigned sets the state to where paths meet. before is the state where
+// the paths split; each of states is the end of one path from there. Only
+// variables tracked at the split survive, since the others were declared in
+// a branch and are out of scope.
+func (a *Analyzer) joinAssigned(before map[*symtab.Symbol]bool, states ...map[*symtab.Symbol]bool) {
+	joined := make(map[*symtab.Symbol]bool, len(before))
+	for symbol := range before {
+		assigned := true
+		for _, state := range states {
+			assigned = assigned && state[symbol]
+		}
+		joined[symbol] = assigned
+	}
+	a.definitelyAssigned = joined
+}
+
+// checkAssigned reports a read of a tracked variable that isn't assigned on
+// every path to expr. Each variable is reported once per path: after the
+// error it counts as assigned, so its other reads don't repeat it.
+func (a *Analyzer) checkAssigned(symbol *symtab.Symbol, expr *ast.IdentifierExpr) {
+	if assigned, tracked := a.definitelyAssigned[symbol]; tracked && !assigned {
+		a.error(expr.Pos(), "variable "+expr.Name+" used before assignment")
+		a.definitelyAssigned[symbol] = true
+	}
+}
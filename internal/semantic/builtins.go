@@ -0,0 +1,148 @@
+package semantic
+
+import (
+	"fmt"
+
+	"github.com/hassan/compiler/internal/parser/ast"
+	"github.com/hassan/compiler/internal/semantic/types"
+	"github.com/hassan/compiler/internal/symtab"
+)
+
+// builtinChecker type-checks a call to a builtin and returns its result type.
+// The arguments have not been checked yet.
+type builtinChecker func(a *Analyzer, expr *ast.CallExpr) types.Type
+
+// builtins are the predeclared functions, by name.
+//
+// DESIGN CHOICE: Each builtin brings its own checker instead of a function
+// type. print takes an int in one call and a string in the next, which no
+// signature in the language can say, and giving builtins their own code
+// path keeps that flexibility out of ordinary call checking.
+//
+// make and append aren't here: they create and grow slices, and the
+// language can't spell a slice type yet (no array type is written out, so
+// make would have nothing to be given). They join len, cap and new once
+// the type syntax exists. There are no maps for len to accept either.
+var builtins = map[string]builtinChecker{
+	"print":   checkPrint,
+	"println": checkPrint,
+	"len":     checkLen,
+	"cap":     checkLen,
+	"new":     checkNew,
+}
+
+// newUniverse creates the scope enclosing a package's global scope, holding
+// the builtins.
+//
+// Every analyzer gets its own: Lookup marks symbols used, so a shared
+// universe would be written to by every package analyzed concurrently.
+func newUniverse() *symtab.Scope {
+	universe := symtab.NewScope(symtab.ScopeUniverse, nil)
+	for name := range builtins {
+		_ = universe.Define(&symtab.Symbol{
+			Name: name,
+			Kind: symtab.SymbolBuiltin,
+			Type: types.Invalid, // Builtins have no function type; see builtins
+		})
+	}
+	return universe
+}
+
+// builtinCallee returns the builtin a call's callee names, or nil if it is
+// not a bare builtin name (including a builtin shadowed by a declaration).
+func (a *Analyzer) builtinCallee(expr *ast.CallExpr) *symtab.Symbol {
+	ident, ok := expr.Callee.(*ast.IdentifierExpr)
+	if !ok {
+		return nil
+	}
+	symbol := a.currentScope.Lookup(ident.Name)
+	if symbol == nil || symbol.Kind != symtab.SymbolBuiltin {
+		return nil
+	}
+	a.reference(ident, symbol)
+	return symbol
+}
+
+// checkPrint checks print(x) and println(x): exactly one argument, of a
+// type with an obvious textual form.
+func checkPrint(a *Analyzer, expr *ast.CallExpr) types.Type {
+	name := expr.Callee.(*ast.IdentifierExpr).Name
+	for _, arg := range expr.Args {
+		argType, _ := arg.Accept(a)
+		if t, ok := argType.(types.Type); ok && !isPrintable(t) && !t.Equals(types.Invalid) && !a.unresolved[arg] {
+			a.error(arg.Pos(), fmt.Sprintf(
+				"cannot %s value of type %s: %s accepts int, float, bool, string or char",
+				name, t, name))
+		}
+	}
+	if len(expr.Args) != 1 {
+		a.error(expr.LeftParen.Position, fmt.Sprintf(
+			"%s expects 1 argument, got %d", name, len(expr.Args)))
+	}
+	return types.Void
+}
+
+// isPrintable reports whether print and println accept values of type t,
+// which includes the named types declared from those that print.
+func isPrintable(t types.Type) bool {
+	switch types.Underlying(t).(type) {
+	case *types.IntType, *types.FloatType, *types.BoolType, *types.StringType, *types.CharType:
+		return true
+	default:
+		return false
+	}
+}
+
+// checkLen checks len(x) and cap(x). len accepts an array or a string and
+// cap only an array, the two being the same for a fixed-size array.
+func checkLen(a *Analyzer, expr *ast.CallExpr) types.Type {
+	name := expr.Callee.(*ast.IdentifierExpr).Name
+	for _, arg := range expr.Args {
+		argType, _ := arg.Accept(a)
+		t, ok := argType.(types.Type)
+		if !ok || t.Equals(types.Invalid) {
+			continue
+		}
+		switch types.Underlying(t).(type) {
+		case *types.ArrayType:
+			continue
+		case *types.StringType:
+			if name == "len" {
+				continue
+			}
+			a.error(arg.Pos(), fmt.Sprintf("invalid argument to cap: %s, expected an array", t))
+		default:
+			expected := "an array or a string"
+			if name == "cap" {
+				expected = "an array"
+			}
+			a.error(arg.Pos(), fmt.Sprintf("invalid argument to %s: %s, expected %s", name, t, expected))
+		}
+	}
+	if len(expr.Args) != 1 {
+		a.error(expr.LeftParen.Position, fmt.Sprintf(
+			"%s expects 1 argument, got %d", name, len(expr.Args)))
+	}
+	return types.Int
+}
+
+// checkNew checks new(T), whose argument is a type rather than a value,
+// and returns *T.
+//
+// new(T) is a pointer to a zeroed T of its own, which unlike the address of
+// a local stays valid after the function that made it returns.
+func checkNew(a *Analyzer, expr *ast.CallExpr) types.Type {
+	if len(expr.Args) != 1 {
+		a.error(expr.LeftParen.Position, fmt.Sprintf("new expects 1 argument, got %d", len(expr.Args)))
+		return types.Invalid
+	}
+	elem := a.resolveType(expr.Args[0])
+	if elem.Equals(types.Invalid) {
+		return types.Invalid
+	}
+	if elem.Equals(types.Void) {
+		a.error(expr.Args[0].Pos(), "cannot allocate a value of type void")
+		return types.Invalid
+	}
+	return types.NewPointer(elem)
+}
@@ -0,0 +1,298 @@
+package semantic
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/hassan/compiler/internal/errors"
+	"github.com/hassan/compiler/internal/lexer"
+	"github.com/hassan/compiler/internal/parser/ast"
+	"github.com/hassan/compiler/internal/semantic/types"
+)
+
+// Overflow in integer constant expressions.
+//
+// int is 64-bit two's complement and wraps: 9223372036854775807 + 1 is
+// -9223372036854775808 at run time, in the interpreter and every backend,
+// and the optimizer folds it to the same value. Written out with literals,
+// though, a wrap is almost certainly a mistake, so the analyzer evaluates
+// every integer expression built only from literals and warns when one
+// doesn't fit.
+//
+// DESIGN CHOICE: A warning rather than an error, and in the analyzer rather
+// than the optimizer. The program is well defined - it wraps - so refusing
+// to compile it would make constant expressions follow different rules
+// from the same arithmetic on variables. And only the analyzer still has
+// source positions; by the time the optimizer folds "t1 = const(...)" it
+// no longer knows which line that came from, or whether folding happens at
+// all (-O0).
+//
+// Each expression is evaluated exactly with math/big and compared against
+// the range of its type: the int64 range for int, and so on for the other
+// widths. An expression that overflowed isn't recorded as a
+// constant, so the expressions around it don't warn about the same wrap
+// again. Division by zero and negative shift amounts fail at run time; they
+// aren't constants either.
+
+// Constants meeting sized integers.
+//
+// A literal is an int, but writing "var b uint8 = 200;" or "b + 1" shouldn't
+// need a conversion. An int constant expression used where another integer
+// type is expected - assigned to it, or as the other operand of a binary
+// operation - takes that type instead, if its value is in that type's range.
+// A constant that isn't is an error rather than a wrap: nothing has been
+// computed to overflow, the literal simply can't be a value of the type.
+//
+// DESIGN CHOICE: Only int constant expressions, and only toward the other
+// integer types. A variable of type int still needs int8(n), since its value
+// could be anything, and a named type (type Meters int) doesn't take a bare
+// constant either: named types are kept apart from everything by design.
+
+// convertConstant gives the int constant expression expr the integer type
+// target, reporting whether that was an int constant expression. One out of
+// target's range is reported; it still counts as converted, so a caller
+// doesn't report a type mismatch over it too.
+func (a *Analyzer) convertConstant(expr ast.Expr, from, target types.Type) bool {
+	t, ok := target.(*types.IntType)
+	if !ok || !from.Equals(types.Int) || t.Equals(types.Int) {
+		return false
+	}
+	value, ok := a.intConstants[expr]
+	if !ok {
+		return false
+	}
+	if !t.Fits(value) {
+		a.error(expr.Pos(), fmt.Sprintf("constant %d overflows %s", value, t))
+		delete(a.intConstants, expr)
+	}
+	a.exprTypes[expr] = t
+	return true
+}
+
+// foldBinaryConstant records the value of expr, of integer type t, when
+// both operands are constants, warning if the operation overflows t.
+func (a *Analyzer) foldBinaryConstant(expr *ast.BinaryExpr, t *types.IntType) {
+	left, ok := a.intConstants[expr.Left]
+	if !ok {
+		return
+	}
+	right, ok := a.intConstants[expr.Right]
+	if !ok {
+		return
+	}
+	// wrapped is what the program computes, exact the true result. The
+	// count of a shift can have another type than the result, and is only
+	// ever a small non-negative number once it's in range
+	x, y := exactValue(left, t), big.NewInt(right)
+	if expr.Operator.Type != lexer.TokenShl && expr.Operator.Type != lexer.TokenShr {
+		y = exactValue(right, t)
+	}
+	unsigned := types.IsUnsigned64(t)
+	exact := new(big.Int)
+	var wrapped int64
+
+	switch expr.Operator.Type {
+	case lexer.TokenPlus:
+		exact.Add(x, y)
+		wrapped = left + right
+	case lexer.TokenMinus:
+		exact.Sub(x, y)
+		wrapped = left - right
+	case lexer.TokenStar:
+		exact.Mul(x, y)
+		wrapped = left * right
+	case lexer.TokenSlash:
+		if right == 0 {
+			return
+		}
+		// Quo truncates toward zero, like the language
+		exact.Quo(x, y)
+		if unsigned {
+			wrapped = int64(uint64(left) / uint64(right))
+		} else {
+			wrapped = left / right
+		}
+	case lexer.TokenPercent:
+		if right == 0 {
+			return
+		}
+		// The remainder is smaller than the divisor: it can't overflow
+		exact.Rem(x, y)
+		wrapped = wrapExact(exact)
+	case lexer.TokenBitAnd:
+		wrapped = left & right
+		exact.Set(exactValue(wrapped, t))
+	case lexer.TokenBitOr:
+		wrapped = left | right
+		exact.Set(exactValue(wrapped, t))
+	case lexer.TokenBitXor:
+		wrapped = left ^ right
+		exact.Set(exactValue(wrapped, t))
+	case lexer.TokenShl:
+		if right < 0 {
+			return
+		}
+		// Anything but 0 shifted by 64 or more is out of range. The exact
+		// value isn't computed: the count could be big enough to make it
+		// enormous
+		if right >= 64 {
+			if left == 0 {
+				a.intConstants[expr] = 0
+				return
+			}
+			a.warn(errors.CodeOverflow, expr.Operator.Position,
+				fmt.Sprintf("constant %s << %d overflows %s (wraps to 0)", x, right, t))
+			return
+		}
+		exact.Lsh(x, uint(right))
+		wrapped = left << uint(right)
+	case lexer.TokenShr:
+		if right < 0 {
+			return
+		}
+		// Shifting right never overflows: exact is the result
+		exact.Rsh(x, uint(right))
+		wrapped = wrapExact(exact)
+	default:
+		return
+	}
+	a.recordConstant(expr, expr.Operator.Position, t, exact, wrapped)
+}
+
+// foldUnaryConstant records the value of expr, of integer type t, when its
+// operand is a constant, warning if the negation overflows t.
+func (a *Analyzer) foldUnaryConstant(expr *ast.UnaryExpr, t *types.IntType) {
+	operand, ok := a.intConstants[expr.Operand]
+	if !ok {
+		return
+	}
+	switch expr.Operator.Type {
+	case lexer.TokenMinus:
+		a.recordConstant(expr, expr.Operator.Position, t, new(big.Int).Neg(exactValue(operand, t)), -operand)
+	case lexer.TokenBitNot:
+		// Inverting the bits of an in-range value stays in range
+		a.intConstants[expr] = t.Wrap(^operand)
+	}
+}
+
+// recordConstant records the value of expr if exact, the true result, is in
+// the range of t, and otherwise warns at pos that it wraps. wrapped is the
+// 64-bit result, before wrapping to t's width.
+func (a *Analyzer) recordConstant(expr ast.Expr, pos lexer.Position, t *types.IntType, exact *big.Int, wrapped int64) {
+	if inRange(exact, t) {
+		a.intConstants[expr] = wrapExact(exact)
+		return
+	}
+	a.warn(errors.CodeOverflow, pos,
+		fmt.Sprintf("constant %s overflows %s (wraps to %s)", exact, t, exactValue(t.Wrap(wrapped), t)))
+}
+
+// exactValue returns the number v holds as a value of type t: the bit
+// pattern of a negative int is a large uint64.
+func exactValue(v int64, t *types.IntType) *big.Int {
+	if types.IsUnsigned64(t) {
+		return new(big.Int).SetUint64(uint64(v))
+	}
+	return big.NewInt(v)
+}
+
+// wrapExact returns the 64 bits holding n, which is in the range of int or
+// of uint64.
+func wrapExact(n *big.Int) int64 {
+	if n.IsInt64() {
+		return n.Int64()
+	}
+	return int64(n.Uint64())
+}
+
+// inRange reports whether n is a value of type t.
+func inRange(n *big.Int, t *types.IntType) bool {
+	if t.Unsigned {
+		return n.Sign() >= 0 && n.BitLen() <= t.Bits
+	}
+	if !n.IsInt64() {
+		return false
+	}
+	return t.Fits(n.Int64())
+}
+
+// Global initializers.
+//
+// A global has its value before any function runs, so its initializer is
+// not code that runs somewhere: it is the value the global starts with,
+// written where the global is declared ("static int64_t g = 42;",
+// ".quad 42", "(i64.const 42)"). It must therefore be a constant: an
+// integer constant expression, or a float, bool, string, char or nil
+// literal, possibly negated or in parentheses.
+//
+// DESIGN CHOICE: Reject any other initializer ("global initializer must be
+// constant") rather than run it in a synthesized init function before
+// main. An init function needs every backend and both interpreters to call
+// it on entry, and rules for the order initializers that read each other
+// run in, and for what they may call - all for what a program can already
+// do in the first lines of main. Constants need none of that.
+//
+// Only a package is checked (AnalyzeFiles). The REPL runs each line's
+// initializers itself, as assignments, since a line's globals have to be
+// usable as soon as they are declared; there, "var n = f();" is as valid
+// as at the top of a function.
+
+// checkGlobalInitializers reports every global among decls whose
+// initializer is not a constant. One whose type is already an error, or
+// that already has an error of its own (an integer constant out of the
+// global's range is no longer a constant), has been reported.
+func (a *Analyzer) checkGlobalInitializers(decls []ast.Decl) {
+	reported := make(map[lexer.Position]bool)
+	for _, err := range a.errors {
+		if e, ok := err.(*errors.CompileError); ok {
+			reported[e.Pos] = true
+		}
+	}
+	for _, decl := range decls {
+		v, ok := decl.(*ast.VarDecl)
+		if !ok || v.Initializer == nil || a.GetExprType(v.Initializer) == types.Invalid || reported[v.Initializer.Pos()] {
+			continue
+		}
+		if _, ok := a.ConstantValue(v.Initializer); !ok {
+			a.error(v.Initializer.Pos(), "global initializer must be constant")
+		}
+	}
+}
+
+// ConstantValue returns the value of expr and true if it is a constant (see
+// Global initializers): an int64 for an integer constant expression of any
+// integer type, a float64, bool, string or rune for a literal of one, and
+// nil for nil. Otherwise it returns nil and false.
+func (a *Analyzer) ConstantValue(expr ast.Expr) (interface{}, bool) {
+	if value, ok := a.intConstants[expr]; ok {
+		return value, true
+	}
+	switch e := expr.(type) {
+	case *ast.LiteralExpr:
+		switch e.Token.Type {
+		case lexer.TokenNumber, lexer.TokenString, lexer.TokenChar, lexer.TokenTrue, lexer.TokenFalse, lexer.TokenNil:
+			// An integer literal is in intConstants; one that isn't has
+			// overflowed
+			_, isInt := e.Value.(int64)
+			return e.Value, !isInt
+		}
+	case *ast.GroupingExpr:
+		return a.ConstantValue(e.Expression)
+	case *ast.UnaryExpr:
+		operand, ok := a.ConstantValue(e.Operand)
+		if !ok {
+			return nil, false
+		}
+		switch value := operand.(type) {
+		case float64:
+			if e.Operator.Type == lexer.TokenMinus {
+				return -value, true
+			}
+		case bool:
+			if e.Operator.Type == lexer.TokenNot {
+				return !value, true
+			}
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,101 @@
+package semantic
+
+import (
+	"fmt"
+
+	"github.com/hassan/compiler/internal/parser/ast"
+	"github.com/hassan/compiler/internal/semantic/types"
+	"github.com/hassan/compiler/internal/symtab"
+)
+
+// Type conversions: a call whose callee names a type, T(x), gives x's value
+// as a T.
+//
+// EXAMPLE:
+//
+//	type Meters int;
+//	var m Meters = Meters(5);
+//	var n int = int(m) + 1;
+//
+// A conversion is valid when both types have the same underlying type, so
+// it can move a value between a named type and what it is declared from, or
+// between two named types declared from the same type. It is also valid
+// between any two integer types, wrapping the value into the target's width
+// (uint8(300) is 44, int8(uint8(255)) is -1). Nothing else changes the
+// value's representation: int(1.5) and float(1) aren't conversions this
+// language has.
+//
+// DESIGN CHOICE: Written as a call, as in Go, rather than with a cast
+// operator. The parser already produces a CallExpr for Meters(5), so the
+// only new rule is here, in telling a type name from a function name; the
+// IR builder then lowers a conversion to its argument, asking IsConversion
+// instead of repeating that decision.
+//
+// An integer also converts without being asked to, when it is assigned to
+// a wider type of the same signedness (see types.IntType.AssignableTo):
+//
+//	func total(a int8, b int16) int {
+//	    var sum int = a;     // int8 to int
+//	    return sum + b;      // error: addition still wants identical types
+//	}
+//
+// Only assignment widens: a declaration's initializer, "=" and a compound
+// assignment's value, an argument, a returned value, an element or a field
+// of a literal. The analyzer records each widened expression with the type
+// it becomes (Widening), and the builder gives the value that type as it
+// builds the expression, so no context has to repeat the check.
+
+// conversionTarget returns the type a call converts to, or nil if the
+// callee doesn't name a type.
+func (a *Analyzer) conversionTarget(expr *ast.CallExpr) types.Type {
+	ident, ok := expr.Callee.(*ast.IdentifierExpr)
+	if !ok {
+		return nil
+	}
+	if t := primitiveType(ident.Name); t != nil {
+		return t
+	}
+	symbol := a.currentScope.Lookup(ident.Name)
+	if symbol == nil || (symbol.Kind != symtab.SymbolType && symbol.Kind != symtab.SymbolStruct) {
+		return nil
+	}
+	a.reference(ident, symbol)
+	return symbol.Type
+}
+
+// checkConversion checks the conversion expr to target and records it. The
+// result is target even when the argument can't be converted, so a misuse
+// of the converted value is still reported.
+func (a *Analyzer) checkConversion(expr *ast.CallExpr, target types.Type) types.Type {
+	a.conversions[expr] = true
+
+	argTypes := make([]types.Type, len(expr.Args))
+	for i, arg := range expr.Args {
+		argType, _ := arg.Accept(a)
+		argTypes[i] = argType.(types.Type)
+	}
+	if len(expr.Args) != 1 {
+		a.error(expr.LeftParen.Position, fmt.Sprintf(
+			"conversion to %s takes exactly one argument, got %d", target, len(expr.Args)))
+		return target
+	}
+
+	source := argTypes[0]
+	if source.Equals(types.Invalid) || target.Equals(types.Invalid) {
+		return target
+	}
+	targetInt, toInt := types.Underlying(target).(*types.IntType)
+	if !types.Underlying(source).Equals(types.Underlying(target)) && !(toInt && types.IsIntegerType(source)) {
+		a.error(expr.Args[0].Pos(), fmt.Sprintf("cannot convert %s to %s", source, target))
+		return target
+	}
+
+	// A constant converts to its value in the target's width, which only
+	// changes it if it was out of range: warned about, as for arithmetic
+	// that wraps
+	if value, ok := a.intConstants[expr.Args[0]]; ok {
+		sourceInt := types.Underlying(source).(*types.IntType)
+		a.recordConstant(expr, expr.Args[0].Pos(), targetInt, exactValue(value, sourceInt), value)
+	}
+	return target
+}
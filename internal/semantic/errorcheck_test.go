@@ -0,0 +1,146 @@
+package semantic
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/hassan/compiler/internal/errors"
+	"github.com/hassan/compiler/internal/lexer"
+	"github.com/hassan/compiler/internal/parser"
+	"github.com/hassan/compiler/internal/parser/ast"
+)
+
+// The fixtures in testdata say what analyzing them must report, in the
+// manner of Go's errorcheck tests: a comment on the line a diagnostic
+// points at holds a regexp its message must match,
+//
+//	return 1 == "1"; // ERROR "cannot compare int and string"
+//
+// and WARNING does the same for warnings. A line may expect several
+// diagnostics, one quoted regexp each, and both kinds:
+//
+//	continue; // ERROR "continue outside loop" WARNING "unreachable code"
+//
+// A regexp with backslashes reads best in backquotes, as in Go:
+// `operator \+`. Every diagnostic must be expected and every expectation
+// met, so a fixture documents everything the analyzer says about it, a
+// cascade of errors included.
+
+// expectation is one diagnostic a fixture comment calls for.
+type expectation struct {
+	line    int
+	warning bool
+	pattern *regexp.Regexp
+	met     bool
+}
+
+// expectationComment matches a comment that expects diagnostics.
+var expectationComment = regexp.MustCompile(`^//\s*(ERROR|WARNING)\s`)
+
+// parseExpectations reads the expectations out of a file's comments.
+func parseExpectations(file *ast.File) ([]*expectation, error) {
+	var expected []*expectation
+	for _, comment := range file.Comments {
+		text := strings.TrimSpace(comment.Text)
+		if !expectationComment.MatchString(text) {
+			continue
+		}
+		rest := strings.TrimSpace(strings.TrimPrefix(text, "//"))
+		warning := false
+		for rest != "" {
+			// A kind applies to the regexps after it, up to the next kind
+			if kind, after, ok := strings.Cut(rest, " "); ok && (kind == "ERROR" || kind == "WARNING") {
+				warning = kind == "WARNING"
+				rest = strings.TrimSpace(after)
+			}
+			quoted, err := strconv.QuotedPrefix(rest)
+			if err != nil {
+				return nil, fmt.Errorf("%s: expected a quoted regexp, got %s", comment.Position, rest)
+			}
+			source, _ := strconv.Unquote(quoted)
+			pattern, err := regexp.Compile(source)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", comment.Position, err)
+			}
+			expected = append(expected, &expectation{
+				line:    comment.Position.Line,
+				warning: warning,
+				pattern: pattern,
+			})
+			rest = strings.TrimSpace(rest[len(quoted):])
+		}
+	}
+	return expected, nil
+}
+
+// match marks the first unmet expectation for diag as met, and reports
+// whether there was one.
+func match(expected []*expectation, diag *errors.CompileError, warning bool) bool {
+	for _, e := range expected {
+		if !e.met && e.line == diag.Pos.Line && e.warning == warning && e.pattern.MatchString(diag.Message) {
+			e.met = true
+			return true
+		}
+	}
+	return false
+}
+
+func TestErrorCheck(t *testing.T) {
+	fixtures, err := filepath.Glob(filepath.Join("testdata", "*.src"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fixtures) == 0 {
+		t.Fatal("no fixtures in testdata")
+	}
+
+	for _, path := range fixtures {
+		t.Run(strings.TrimSuffix(filepath.Base(path), ".src"), func(t *testing.T) {
+			source, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The fixtures test the analyzer, so they must parse
+			file, errs := parser.New(lexer.New(string(source), path)).ParseFile(path)
+			if len(errs) > 0 {
+				t.Fatalf("fixture does not parse: %v", errs)
+			}
+			expected, err := parseExpectations(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			analyzer := New()
+			errs = analyzer.Analyze(file)
+			for _, list := range []struct {
+				diags   []error
+				warning bool
+			}{{errs, false}, {analyzer.Warnings(), true}} {
+				for _, err := range list.diags {
+					diag, ok := err.(*errors.CompileError)
+					if !ok {
+						t.Errorf("unlocated diagnostic: %v", err)
+						continue
+					}
+					if !match(expected, diag, list.warning) {
+						t.Errorf("unexpected diagnostic: %s", diag.Error())
+					}
+				}
+			}
+			for _, e := range expected {
+				if !e.met {
+					kind := "error"
+					if e.warning {
+						kind = "warning"
+					}
+					t.Errorf("%s:%d: missing %s matching %q", path, e.line, kind, e.pattern)
+				}
+			}
+		})
+	}
+}
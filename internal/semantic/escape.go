@@ -0,0 +1,379 @@
+package semantic
+
+import (
+	"github.com/hassan/compiler/internal/lexer"
+	"github.com/hassan/compiler/internal/parser/ast"
+	"github.com/hassan/compiler/internal/semantic/types"
+	"github.com/hassan/compiler/internal/symtab"
+)
+
+// Escape analysis: which locals are still reachable after their function
+// returns.
+//
+// A local whose address is taken normally lives in a stack slot, which is
+// gone once the call returns. A pointer to it that outlives the call would
+// then point at whatever the stack holds next. A local escapes when a
+// pointer to it may reach:
+//   - the function's result ("return &x")
+//   - a global ("g = &x")
+//   - memory reached through a pointer ("*pp = &x", "(*p).next = &x"),
+//     which may belong to a caller
+//   - a parameter of a function that does one of these with it
+//
+// and the IR builder gives an escaping local heap storage instead (see
+// Analyzer.Escapes). What is stored in an escaping local escapes in turn,
+// since it can be reached through the pointer that got out.
+//
+// EXAMPLE:
+//
+//	func leak() *int {
+//	    var x int = 42;
+//	    return &x;           // x escapes: allocated with new
+//	}
+//
+//	func inc(p *int) {
+//	    *p = *p + 1;         // p is only read through: nothing escapes
+//	}
+//
+//	func main() {
+//	    var n int = 41;
+//	    inc(&n);             // n stays on the stack
+//	}
+//
+// DESIGN CHOICE: Flow-insensitive and conservative. Each local gets the set
+// of locals whose address it may hold at any point in the function,
+// whatever the order of the statements; a store through a pointer is
+// assumed to reach a caller's memory even when it doesn't. The analysis can
+// then only err towards the heap, which costs an allocation, and never
+// towards the stack, which would be a dangling pointer. The interpreter
+// keeps every slot alive, so only the compiled targets would show the
+// difference - exactly the kind of bug a test of the interpreter misses.
+//
+// Calls are handled with a summary per function: which of its parameters
+// leak the pointer passed for them. The summaries are computed for all of
+// the package's functions together and repeated until none changes, which
+// handles recursion. A function the analysis has no summary for (one of
+// another package) is assumed to leak every argument.
+
+// escapeRoot is what a pointer may have come from: the address of a local,
+// or the value a caller passed for a parameter.
+type escapeRoot struct {
+	symbol *symtab.Symbol
+
+	// param marks the value passed for the parameter symbol, rather than
+	// the parameter's own address
+	param bool
+}
+
+// escapeSet is a set of roots.
+type escapeSet map[escapeRoot]bool
+
+// escapeAnalysis holds the state of the analysis of one function.
+type escapeAnalysis struct {
+	a *Analyzer
+
+	// fn is the function being analyzed, whose summary is leaks[fn]
+	fn *symtab.Symbol
+
+	// pointsTo is, for each local, the roots of the pointers it may hold,
+	// directly or in one of its fields or elements
+	pointsTo map[*symtab.Symbol]escapeSet
+
+	// changed records whether this round added anything to pointsTo
+	changed bool
+}
+
+// analyzeEscapes runs escape analysis over funcs, setting Symbol.Escapes on
+// the locals that escape and recording each function's summary.
+func (a *Analyzer) analyzeEscapes(funcs []*ast.FuncDecl) {
+	// Every summary starts out leaking nothing and only grows, so the
+	// result is the least one that holds. One that had started out
+	// leaking everything, as an unknown function does, never would shrink
+	for _, fn := range funcs {
+		if symbol := a.symbols[fn.Name]; symbol != nil && fn.Body != nil && a.leaks[symbol] == nil {
+			a.leaks[symbol] = make([]bool, len(fn.Params))
+		}
+	}
+	for {
+		changed := false
+		for _, fn := range funcs {
+			if a.analyzeFunctionEscapes(fn) {
+				changed = true
+			}
+		}
+		if !changed {
+			return
+		}
+	}
+}
+
+// Escapes reports whether the local variable or parameter declared by name
+// escapes its function, and so must be allocated on the heap.
+func (a *Analyzer) Escapes(name *ast.IdentifierExpr) bool {
+	symbol := a.symbols[name]
+	return symbol != nil && symbol.Escapes
+}
+
+// analyzeFunctionEscapes analyzes one function's body, and reports whether
+// its summary or any Escapes flag changed.
+func (a *Analyzer) analyzeFunctionEscapes(decl *ast.FuncDecl) bool {
+	symbol := a.symbols[decl.Name]
+	if symbol == nil || decl.Body == nil {
+		return false
+	}
+	e := &escapeAnalysis{a: a, fn: symbol, pointsTo: make(map[*symtab.Symbol]escapeSet)}
+
+	// A parameter holds whatever the caller passed
+	for _, param := range decl.Params {
+		if p := a.symbols[param.Name]; p != nil {
+			e.add(p, escapeSet{{symbol: p, param: true}: true})
+		}
+	}
+
+	// Propagate assignments between locals until nothing is added
+	for e.changed = true; e.changed; {
+		e.changed = false
+		ast.Inspect(decl.Body, func(node ast.Node) bool {
+			switch n := node.(type) {
+			case *ast.VarDecl:
+				if n.Initializer != nil {
+					roots := e.roots(n.Initializer)
+					for _, name := range n.Names {
+						if local := e.local(name); local != nil {
+							e.add(local, roots)
+						}
+					}
+				}
+			case *ast.AssignmentExpr:
+				if local := e.target(n.Target); local != nil {
+					e.add(local, e.roots(n.Value))
+				}
+			}
+			return true
+		})
+	}
+
+	// Then find where pointers leave the function
+	changed := false
+	escape := func(roots escapeSet) {
+		if e.escape(roots) {
+			changed = true
+		}
+	}
+	ast.Inspect(decl.Body, func(node ast.Node) bool {
+		switch n := node.(type) {
+		case *ast.ReturnStmt:
+			if n.Value != nil {
+				escape(e.roots(n.Value))
+			}
+		case *ast.AssignmentExpr:
+			if e.target(n.Target) == nil {
+				escape(e.roots(n.Value))
+			}
+		case *ast.CallExpr:
+			for i, leaks := range e.leakingArgs(n) {
+				if leaks {
+					escape(e.roots(n.Args[i]))
+				}
+			}
+		}
+		return true
+	})
+	return changed
+}
+
+// local returns the symbol of ident if it names a local variable or
+// parameter, or nil.
+func (e *escapeAnalysis) local(ident *ast.IdentifierExpr) *symtab.Symbol {
+	symbol := e.a.symbols[ident]
+	if symbol == nil || symbol.IsGlobal() {
+		return nil
+	}
+	if symbol.Kind != symtab.SymbolVariable && symbol.Kind != symtab.SymbolParameter {
+		return nil
+	}
+	return symbol
+}
+
+// add adds roots to what local may point to.
+func (e *escapeAnalysis) add(local *symtab.Symbol, roots escapeSet) {
+	set := e.pointsTo[local]
+	if set == nil {
+		set = make(escapeSet)
+		e.pointsTo[local] = set
+	}
+	for root := range roots {
+		if !set[root] {
+			set[root] = true
+			e.changed = true
+		}
+	}
+}
+
+// target returns the local an assignment to expr writes into (x, x.f,
+// x[i]), or nil when it writes a global or through a pointer.
+func (e *escapeAnalysis) target(expr ast.Expr) *symtab.Symbol {
+	switch t := expr.(type) {
+	case *ast.IdentifierExpr:
+		return e.local(t)
+	case *ast.GroupingExpr:
+		return e.target(t.Expression)
+	case *ast.MemberExpr:
+		return e.target(t.Object)
+	case *ast.IndexExpr:
+		return e.target(t.Object)
+	}
+	return nil
+}
+
+// roots returns the roots of the pointers the value of expr may hold. A
+// value of a type with no pointer in it holds none, whatever it was
+// computed from: in "*a = *b" with b an *int, only an int is copied.
+func (e *escapeAnalysis) roots(expr ast.Expr) escapeSet {
+	roots := make(escapeSet)
+	if t, ok := e.a.exprTypes[expr]; ok && !holdsPointer(t) {
+		return roots
+	}
+	switch x := expr.(type) {
+	case *ast.IdentifierExpr:
+		if local := e.local(x); local != nil {
+			for root := range e.pointsTo[local] {
+				roots[root] = true
+			}
+		}
+	case *ast.GroupingExpr:
+		return e.roots(x.Expression)
+	case *ast.MemberExpr:
+		return e.roots(x.Object)
+	case *ast.IndexExpr:
+		return e.roots(x.Object)
+	case *ast.AssignmentExpr:
+		return e.roots(x.Value)
+
+	case *ast.UnaryExpr:
+		switch x.Operator.Type {
+		case lexer.TokenBitAnd:
+			return e.addressRoots(x.Operand)
+		case lexer.TokenStar:
+			// What a local holds, when the pointer is to a local; what the
+			// caller's memory holds is the caller's, as far as this
+			// function can tell, so it is reached from the parameter
+			for root := range e.roots(x.Operand) {
+				if root.param {
+					roots[root] = true
+					continue
+				}
+				for held := range e.pointsTo[root.symbol] {
+					roots[held] = true
+				}
+			}
+		}
+
+	case *ast.CallExpr:
+		// A conversion is its operand's value. A call's result can only
+		// hold a pointer its callee leaked, which has escaped already
+		if e.a.conversions[x] && len(x.Args) == 1 {
+			return e.roots(x.Args[0])
+		}
+	case *ast.StructLiteralExpr:
+		for _, field := range x.Fields {
+			for root := range e.roots(field.Value) {
+				roots[root] = true
+			}
+		}
+	case *ast.ArrayLiteralExpr:
+		for _, elem := range x.Elements {
+			for root := range e.roots(elem) {
+				roots[root] = true
+			}
+		}
+	}
+	return roots
+}
+
+// addressRoots returns the roots of &expr: the local expr is part of, or
+// when expr is reached through a pointer, that pointer's roots.
+func (e *escapeAnalysis) addressRoots(expr ast.Expr) escapeSet {
+	switch x := expr.(type) {
+	case *ast.IdentifierExpr:
+		if local := e.local(x); local != nil {
+			return escapeSet{{symbol: local}: true}
+		}
+	case *ast.GroupingExpr:
+		return e.addressRoots(x.Expression)
+	case *ast.MemberExpr:
+		return e.addressRoots(x.Object)
+	case *ast.IndexExpr:
+		return e.addressRoots(x.Object)
+	case *ast.UnaryExpr:
+		if x.Operator.Type == lexer.TokenStar {
+			return e.roots(x.Operand)
+		}
+	}
+	return nil
+}
+
+// leakingArgs reports, for each argument of call, whether the callee may
+// let a pointer passed there escape.
+func (e *escapeAnalysis) leakingArgs(call *ast.CallExpr) []bool {
+	leaking := make([]bool, len(call.Args))
+	if e.a.conversions[call] {
+		return leaking
+	}
+	if ident, ok := call.Callee.(*ast.IdentifierExpr); ok {
+		if symbol := e.a.symbols[ident]; symbol != nil {
+			if symbol.Kind == symtab.SymbolBuiltin {
+				// print, len, cap and new keep nothing they are given
+				return leaking
+			}
+			if leaks, ok := e.a.leaks[symbol]; ok && len(leaks) == len(call.Args) {
+				return leaks
+			}
+		}
+	}
+	for i := range leaking {
+		leaking[i] = true
+	}
+	return leaking
+}
+
+// escape marks the locals among roots, and what they hold, as escaping, and
+// the parameters among them as leaked by the function. Reports whether
+// anything was newly marked.
+func (e *escapeAnalysis) escape(roots escapeSet) bool {
+	changed := false
+	for root := range roots {
+		if root.param {
+			if leaks := e.a.leaks[e.fn]; root.symbol.Index < len(leaks) && !leaks[root.symbol.Index] {
+				leaks[root.symbol.Index] = true
+				changed = true
+			}
+			continue
+		}
+		if root.symbol.Escapes {
+			continue
+		}
+		root.symbol.Escapes = true
+		changed = true
+		e.escape(e.pointsTo[root.symbol])
+	}
+	return changed
+}
+
+// holdsPointer reports whether a value of type t can hold a pointer, itself
+// or in a field or element.
+func holdsPointer(t types.Type) bool {
+	switch u := types.Underlying(t).(type) {
+	case *types.PointerType:
+		return true
+	case *types.StructType:
+		for _, field := range u.Fields {
+			if holdsPointer(field.Type) {
+				return true
+			}
+		}
+	case *types.ArrayType:
+		return holdsPointer(u.ElementType)
+	}
+	return false
+}
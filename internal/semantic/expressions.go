@@ -11,6 +11,45 @@ import (
 
 // Expression visitor methods for semantic analysis
 
+// isComparison reports whether op is one of the comparison operators, whose
+// result is a bool.
+//
+// The parser reads "a < b < c" as "(a < b) < c", as C does, which compares
+// a bool with c: a type error when c is an int, and when c is a bool a
+// comparison that type-checks but means nothing like what was written.
+// VisitBinaryExpr rejects a comparison with an ungrouped comparison as an
+// operand with its own message, over the whole chain. The inner comparison
+// is on the right when it binds tighter: "a == b < c" is "a == (b < c)".
+//
+// DESIGN CHOICE: Detected in the analyzer rather than made a syntax error.
+// The parser keeps one precedence table with no special cases, and the
+// analyzer sees which comparisons the user grouped: "(a < b) == flag" is a
+// GroupingExpr on the left, so it is checked like any other comparison and
+// allowed when flag is a bool.
+func isComparison(op lexer.TokenType) bool {
+	switch op {
+	case lexer.TokenEqual, lexer.TokenNotEqual, lexer.TokenLess,
+		lexer.TokenLessEqual, lexer.TokenGreater, lexer.TokenGreaterEqual:
+		return true
+	}
+	return false
+}
+
+// chainedComparison reports whether expr is a comparison with another as an
+// operand, returning their operators in source order.
+func chainedComparison(expr *ast.BinaryExpr) (first, second lexer.Token, ok bool) {
+	if !isComparison(expr.Operator.Type) {
+		return first, second, false
+	}
+	if inner, ok := expr.Left.(*ast.BinaryExpr); ok && isComparison(inner.Operator.Type) {
+		return inner.Operator, expr.Operator, true
+	}
+	if inner, ok := expr.Right.(*ast.BinaryExpr); ok && isComparison(inner.Operator.Type) {
+		return expr.Operator, inner.Operator, true
+	}
+	return first, second, false
+}
+
 func (a *Analyzer) VisitBinaryExpr(expr *ast.BinaryExpr) (interface{}, error) {
 	// Check operands
 	leftType, _ := expr.Left.Accept(a)
@@ -19,6 +58,30 @@ func (a *Analyzer) VisitBinaryExpr(expr *ast.BinaryExpr) (interface{}, error) {
 	left := leftType.(types.Type)
 	right := rightType.(types.Type)
 
+	if a.unresolved[expr.Left] || a.unresolved[expr.Right] {
+		a.unresolved[expr] = true
+		a.exprTypes[expr] = types.Invalid
+		return types.Invalid, nil
+	}
+
+	if first, second, ok := chainedComparison(expr); ok {
+		a.errorSpan(expr.Pos(), expr.End(), fmt.Sprintf(
+			"chained comparisons are not supported; use (a %s b) && (b %s c)",
+			first.Lexeme, second.Lexeme))
+		a.exprTypes[expr] = types.Bool
+		return types.Bool, nil
+	}
+
+	// An int constant operand takes the other operand's integer type, as in
+	// "b + 1" for a uint8 b. Not the count of a shift, which keeps its own
+	if expr.Operator.Type != lexer.TokenShl && expr.Operator.Type != lexer.TokenShr && !left.Equals(right) {
+		if a.convertConstant(expr.Right, right, left) {
+			right = left
+		} else if a.convertConstant(expr.Left, left, right) {
+			left = right
+		}
+	}
+
 	var resultType types.Type
 
 	switch expr.Operator.Type {
@@ -64,14 +127,27 @@ func (a *Analyzer) VisitBinaryExpr(expr *ast.BinaryExpr) (interface{}, error) {
 			resultType = types.Bool
 		}
 
-	// Bitwise operators: &, |, ^, <<, >>
-	case lexer.TokenBitAnd, lexer.TokenBitOr, lexer.TokenBitXor,
-		lexer.TokenShl, lexer.TokenShr:
+	// Bitwise operators: &, |, ^
+	case lexer.TokenBitAnd, lexer.TokenBitOr, lexer.TokenBitXor:
 		if !types.IsIntegerType(left) || !types.IsIntegerType(right) {
 			a.error(expr.Operator.Position, "bitwise operators require integer operands")
 			resultType = types.Invalid
+		} else if !left.Equals(right) {
+			a.error(expr.Operator.Position,
+				fmt.Sprintf("mismatched types: %s and %s", left, right))
+			resultType = types.Invalid
 		} else {
-			resultType = types.Int
+			resultType = left
+		}
+
+	// Shifts: <<, >>. The count is only a number of bits, so it may be of
+	// any integer type; the result has the shifted operand's type
+	case lexer.TokenShl, lexer.TokenShr:
+		if !types.IsIntegerType(left) || !types.IsIntegerType(right) {
+			a.error(expr.Operator.Position, "bitwise operators require integer operands")
+			resultType = types.Invalid
+		} else {
+			resultType = left
 		}
 
 	default:
@@ -80,11 +156,21 @@ func (a *Analyzer) VisitBinaryExpr(expr *ast.BinaryExpr) (interface{}, error) {
 		resultType = types.Invalid
 	}
 
+	if t, ok := types.Underlying(resultType).(*types.IntType); ok {
+		a.foldBinaryConstant(expr, t)
+	}
 	a.exprTypes[expr] = resultType
 	return resultType, nil
 }
 
 func (a *Analyzer) VisitUnaryExpr(expr *ast.UnaryExpr) (interface{}, error) {
+	// Address-of: & (see pointer.go). Its operand isn't necessarily read
+	if expr.Operator.Type == lexer.TokenBitAnd {
+		resultType := a.addressOf(expr)
+		a.exprTypes[expr] = resultType
+		return resultType, nil
+	}
+
 	operandType, _ := expr.Operand.Accept(a)
 	opType := operandType.(types.Type)
 
@@ -115,9 +201,13 @@ func (a *Analyzer) VisitUnaryExpr(expr *ast.UnaryExpr) (interface{}, error) {
 			a.error(expr.Operator.Position, "unary ~ requires integer operand")
 			resultType = types.Invalid
 		} else {
-			resultType = types.Int
+			resultType = opType
 		}
 
+	// Dereference: *
+	case lexer.TokenStar:
+		resultType = a.dereference(expr, opType)
+
 	// Increment/Decrement: ++, --
 	case lexer.TokenPlusPlus, lexer.TokenMinusMinus:
 		if !types.IsNumeric(opType) {
@@ -125,13 +215,33 @@ func (a *Analyzer) VisitUnaryExpr(expr *ast.UnaryExpr) (interface{}, error) {
 				fmt.Sprintf("%s requires numeric operand", expr.Operator.Lexeme))
 			resultType = types.Invalid
 		} else {
-			// Check that operand is assignable
-			if ident, ok := expr.Operand.(*ast.IdentifierExpr); ok {
-				symbol := a.currentScope.Lookup(ident.Name)
+			// The operand is assigned, as in "x += 1", so it must be
+			// something an assignment can target. Parentheses are allowed:
+			// "(*p)++", since "*p++" is "*(p++)"
+			operand := expr.Operand
+			for {
+				grouping, ok := operand.(*ast.GroupingExpr)
+				if !ok {
+					break
+				}
+				operand = grouping.Expression
+			}
+			switch operand := operand.(type) {
+			case *ast.IdentifierExpr:
+				symbol := a.currentScope.Lookup(operand.Name)
 				if symbol != nil && !symbol.CanAssign() {
 					a.error(expr.Operator.Position,
-						fmt.Sprintf("cannot modify %s", ident.Name))
+						fmt.Sprintf("cannot modify %s", operand.Name))
+				}
+			case *ast.IndexExpr, *ast.MemberExpr:
+			case *ast.UnaryExpr:
+				if operand.Operator.Type != lexer.TokenStar {
+					a.error(expr.Operator.Position,
+						fmt.Sprintf("%s requires an assignable operand", expr.Operator.Lexeme))
 				}
+			default:
+				a.error(expr.Operator.Position,
+					fmt.Sprintf("%s requires an assignable operand", expr.Operator.Lexeme))
 			}
 			resultType = opType
 		}
@@ -142,14 +252,21 @@ func (a *Analyzer) VisitUnaryExpr(expr *ast.UnaryExpr) (interface{}, error) {
 		resultType = types.Invalid
 	}
 
+	if t, ok := types.Underlying(resultType).(*types.IntType); ok {
+		a.foldUnaryConstant(expr, t)
+	}
+
 	a.exprTypes[expr] = resultType
 	return resultType, nil
 }
 
 func (a *Analyzer) VisitLogicalExpr(expr *ast.LogicalExpr) (interface{}, error) {
-	// Both operands must be boolean
+	// Both operands must be boolean. The right one is skipped when the
+	// left decides the result, so an assignment in it isn't definite
 	leftType, _ := expr.Left.Accept(a)
+	before := a.copyAssigned()
 	rightType, _ := expr.Right.Accept(a)
+	a.joinAssigned(before, before, a.definitelyAssigned)
 
 	left := leftType.(types.Type)
 	right := rightType.(types.Type)
@@ -171,9 +288,10 @@ func (a *Analyzer) VisitLiteralExpr(expr *ast.LiteralExpr) (interface{}, error)
 	switch expr.Token.Type {
 	case lexer.TokenNumber:
 		// Determine if int or float based on the value
-		switch expr.Value.(type) {
+		switch value := expr.Value.(type) {
 		case int64:
 			resultType = types.Int
+			a.intConstants[expr] = value
 		case float64:
 			resultType = types.Float
 		default:
@@ -202,29 +320,74 @@ func (a *Analyzer) VisitLiteralExpr(expr *ast.LiteralExpr) (interface{}, error)
 }
 
 func (a *Analyzer) VisitIdentifierExpr(expr *ast.IdentifierExpr) (interface{}, error) {
+	symbol, typ := a.resolveIdentifier(expr)
+	if symbol != nil {
+		// Visiting an identifier reads it; assignment targets are
+		// resolved by VisitAssignmentExpr without coming here
+		a.checkAssigned(symbol, expr)
+	}
+	return typ, nil
+}
+
+// resolveIdentifier looks up the value an identifier names and records its
+// type. The symbol is nil when the name isn't a value, which has been
+// reported.
+func (a *Analyzer) resolveIdentifier(expr *ast.IdentifierExpr) (*symtab.Symbol, types.Type) {
 	// Look up the symbol
 	symbol := a.currentScope.Lookup(expr.Name)
 	if symbol == nil {
 		a.error(expr.Pos(), fmt.Sprintf("undefined: %s", expr.Name))
 		a.exprTypes[expr] = types.Invalid
-		return types.Invalid, nil
+		return nil, types.Invalid
+	}
+	a.reference(expr, symbol)
+
+	// A builtin is only valid as the callee of a call, which VisitCallExpr
+	// handles without visiting the name
+	if symbol.Kind == symtab.SymbolBuiltin {
+		a.error(expr.Pos(), fmt.Sprintf("%s (built-in function) must be called", expr.Name))
+		a.exprTypes[expr] = types.Invalid
+		return nil, types.Invalid
 	}
 
 	// Check it's not a type being used as a value
 	if symbol.Kind == symtab.SymbolType {
 		a.error(expr.Pos(), fmt.Sprintf("%s is a type, not a value", expr.Name))
 		a.exprTypes[expr] = types.Invalid
-		return types.Invalid, nil
+		return nil, types.Invalid
 	}
 
 	a.exprTypes[expr] = symbol.Type
-	return symbol.Type, nil
+	return symbol, symbol.Type
 }
 
 func (a *Analyzer) VisitCallExpr(expr *ast.CallExpr) (interface{}, error) {
+	if target := a.conversionTarget(expr); target != nil {
+		resultType := a.checkConversion(expr, target)
+		a.exprTypes[expr] = resultType
+		return resultType, nil
+	}
+
+	if builtin := a.builtinCallee(expr); builtin != nil {
+		resultType := builtins[builtin.Name](a, expr)
+		a.exprTypes[expr] = resultType
+		return resultType, nil
+	}
+
 	// Check callee
 	calleeType, _ := expr.Callee.Accept(a)
 
+	// Calling a member of a failed import: check the arguments, but the
+	// call itself has no type to check against
+	if a.unresolved[expr.Callee] {
+		for _, arg := range expr.Args {
+			arg.Accept(a)
+		}
+		a.unresolved[expr] = true
+		a.exprTypes[expr] = types.Invalid
+		return types.Invalid, nil
+	}
+
 	funcType, ok := calleeType.(*types.FunctionType)
 	if !ok {
 		a.error(expr.Callee.Pos(), "expression is not a function")
@@ -245,7 +408,7 @@ func (a *Analyzer) VisitCallExpr(expr *ast.CallExpr) (interface{}, error) {
 	for i, arg := range expr.Args {
 		argType, _ := arg.Accept(a)
 		expectedType := funcType.Parameters[i]
-		if !a.assignable(argType.(types.Type), expectedType, arg.Pos()) {
+		if !a.assignable(arg, argType.(types.Type), expectedType) {
 			// Error already reported
 		}
 	}
@@ -258,7 +421,7 @@ func (a *Analyzer) VisitIndexExpr(expr *ast.IndexExpr) (interface{}, error) {
 	// Check object
 	objectType, _ := expr.Object.Accept(a)
 
-	arrayType, ok := objectType.(*types.ArrayType)
+	arrayType, ok := types.Underlying(objectType.(types.Type)).(*types.ArrayType)
 	if !ok {
 		a.error(expr.Object.Pos(), "expression is not an array")
 		a.exprTypes[expr] = types.Invalid
@@ -276,10 +439,21 @@ func (a *Analyzer) VisitIndexExpr(expr *ast.IndexExpr) (interface{}, error) {
 }
 
 func (a *Analyzer) VisitMemberExpr(expr *ast.MemberExpr) (interface{}, error) {
+	// A package name on the left makes this a qualified reference, not a
+	// field access
+	if ident, ok := expr.Object.(*ast.IdentifierExpr); ok {
+		if symbol := a.currentScope.Lookup(ident.Name); symbol != nil && symbol.Kind == symtab.SymbolPackage {
+			a.reference(ident, symbol)
+			memberType := a.checkPackageMember(symbol, expr)
+			a.exprTypes[expr] = memberType
+			return memberType, nil
+		}
+	}
+
 	// Check object
 	objectType, _ := expr.Object.Accept(a)
 
-	structType, ok := objectType.(*types.StructType)
+	structType, ok := types.Underlying(objectType.(types.Type)).(*types.StructType)
 	if !ok {
 		a.error(expr.Object.Pos(), "expression is not a struct")
 		a.exprTypes[expr] = types.Invalid
@@ -295,44 +469,106 @@ func (a *Analyzer) VisitMemberExpr(expr *ast.MemberExpr) (interface{}, error) {
 		return types.Invalid, nil
 	}
 
+	// The field's own symbol is kept by the struct's, when the struct is
+	// one of this package's
+	if symbol := a.globalScope.LookupLocal(structType.Name); symbol != nil && symbol.Type == structType {
+		if fieldSymbol := symbol.Fields[field.Name]; fieldSymbol != nil {
+			a.reference(expr.Member, fieldSymbol)
+		}
+	}
+
 	a.exprTypes[expr] = field.Type
 	return field.Type, nil
 }
 
 func (a *Analyzer) VisitAssignmentExpr(expr *ast.AssignmentExpr) (interface{}, error) {
-	// Check target is assignable
-	targetType, _ := expr.Target.Accept(a)
+	// The value is evaluated first: in "x = x + 1" the x on the right is
+	// read before the assignment happens
 	valueType, _ := expr.Value.Accept(a)
 
 	// Check target is a valid lvalue
+	var targetType interface{}
 	switch target := expr.Target.(type) {
 	case *ast.IdentifierExpr:
-		symbol := a.currentScope.Lookup(target.Name)
+		// Resolved rather than visited: assigning a variable isn't a read
+		symbol, typ := a.resolveIdentifier(target)
+		targetType = typ
 		if symbol != nil && !symbol.CanAssign() {
 			a.error(expr.Target.Pos(),
 				fmt.Sprintf("cannot assign to %s", target.Name))
 		}
+		if symbol != nil {
+			// "x += 1" reads x before writing it
+			if expr.Operator.Type != lexer.TokenAssign {
+				a.checkAssigned(symbol, target)
+			}
+			if _, tracked := a.definitelyAssigned[symbol]; tracked {
+				a.definitelyAssigned[symbol] = true
+			}
+		}
 
 	case *ast.IndexExpr, *ast.MemberExpr:
 		// These are valid lvalues
+		targetType, _ = expr.Target.Accept(a)
+
+	case *ast.UnaryExpr:
+		// So is a dereference, "*p = v"
+		targetType, _ = expr.Target.Accept(a)
+		if target.Operator.Type != lexer.TokenStar {
+			a.error(expr.Target.Pos(), "invalid assignment target")
+		}
 
 	default:
+		targetType, _ = expr.Target.Accept(a)
 		a.error(expr.Target.Pos(), "invalid assignment target")
 	}
 
-	// Check types match
-	if !a.assignable(valueType.(types.Type), targetType.(types.Type), expr.Value.Pos()) {
-		// Error already reported
+	// Check types match. A compound assignment applies its operator to the
+	// target and the value, which must suit it as they would in "x + v"; a
+	// shift count may be of any integer type
+	target := targetType.(types.Type)
+	value := valueType.(types.Type)
+	switch expr.Operator.Type {
+	case lexer.TokenAssign:
+		a.assignable(expr.Value, value, target)
+	case lexer.TokenShlEq, lexer.TokenShrEq:
+		if !types.IsIntegerType(target) || !types.IsIntegerType(value) {
+			a.compoundError(expr, target, value, "bitwise operators require integer operands")
+		}
+	case lexer.TokenAndEq, lexer.TokenOrEq, lexer.TokenXorEq:
+		if !types.IsIntegerType(target) {
+			a.compoundError(expr, target, value, "bitwise operators require integer operands")
+		} else {
+			a.assignable(expr.Value, value, target)
+		}
+	default:
+		if !types.IsNumeric(target) {
+			a.compoundError(expr, target, value,
+				fmt.Sprintf("operator %s requires numeric operands", expr.Operator.Lexeme))
+		} else {
+			a.assignable(expr.Value, value, target)
+		}
 	}
 
-	a.exprTypes[expr] = targetType.(types.Type)
-	return targetType, nil
+	a.exprTypes[expr] = target
+	return target, nil
+}
+
+// compoundError reports operands that don't suit a compound assignment's
+// operator, unless one of them is already invalid (and reported).
+func (a *Analyzer) compoundError(expr *ast.AssignmentExpr, target, value types.Type, message string) {
+	if !target.Equals(types.Invalid) && !value.Equals(types.Invalid) {
+		a.error(expr.Operator.Position, message)
+	}
 }
 
 func (a *Analyzer) VisitGroupingExpr(expr *ast.GroupingExpr) (interface{}, error) {
 	// Just pass through the inner expression's type
 	innerType, err := expr.Expression.Accept(a)
 	a.exprTypes[expr] = innerType.(types.Type)
+	if value, ok := a.intConstants[expr.Expression]; ok {
+		a.intConstants[expr] = value
+	}
 	return innerType, err
 }
 
@@ -354,7 +590,7 @@ func (a *Analyzer) VisitArrayLiteralExpr(expr *ast.ArrayLiteralExpr) (interface{
 	// Check all elements match
 	for _, elem := range expr.Elements {
 		elemType, _ := elem.Accept(a)
-		if !a.assignable(elemType.(types.Type), elementType, elem.Pos()) {
+		if !a.assignable(elem, elemType.(types.Type), elementType) {
 			// Error already reported
 		}
 	}
@@ -380,9 +616,46 @@ func (a *Analyzer) VisitStructLiteralExpr(expr *ast.StructLiteralExpr) (interfac
 		a.exprTypes[expr] = types.Invalid
 		return types.Invalid, nil
 	}
+	a.reference(expr.TypeName, symbol)
 
 	structType := symbol.Type.(*types.StructType)
 
+	// DESIGN CHOICE: A literal names its fields or lists them in order, but
+	// not both. With names, fields left out are zero (the builder stores the
+	// zero value of each), so a literal only mentions what matters. A
+	// positional literal has no names to show which value is which, so it
+	// must give every field: leaving one out would silently shift meaning
+	// onto the next.
+	positional := len(expr.Fields) > 0 && expr.Fields[0].Name == nil
+	for _, field := range expr.Fields {
+		if (field.Name == nil) != positional {
+			a.error(field.Pos(), "cannot mix named and positional fields in struct literal")
+			for _, field := range expr.Fields {
+				field.Value.Accept(a)
+			}
+			a.exprTypes[expr] = structType
+			return structType, nil
+		}
+	}
+
+	if positional {
+		for i, field := range expr.Fields {
+			valueType, _ := field.Value.Accept(a)
+			if i < len(structType.Fields) {
+				a.assignable(field.Value, valueType.(types.Type), structType.Fields[i].Type)
+			} else if i == len(structType.Fields) {
+				a.error(field.Pos(),
+					fmt.Sprintf("too many values in struct literal of type %s", structType.Name))
+			}
+		}
+		if len(expr.Fields) < len(structType.Fields) {
+			a.error(expr.RightBrace.Position,
+				fmt.Sprintf("too few values in struct literal of type %s", structType.Name))
+		}
+		a.exprTypes[expr] = structType
+		return structType, nil
+	}
+
 	// Check fields
 	providedFields := make(map[string]bool)
 	for _, field := range expr.Fields {
@@ -394,6 +667,9 @@ func (a *Analyzer) VisitStructLiteralExpr(expr *ast.StructLiteralExpr) (interfac
 					structType.Name, field.Name.Name))
 			continue
 		}
+		if fieldSymbol := symbol.Fields[field.Name.Name]; fieldSymbol != nil {
+			a.reference(field.Name, fieldSymbol)
+		}
 
 		// Check for duplicate fields
 		if providedFields[field.Name.Name] {
@@ -405,19 +681,11 @@ func (a *Analyzer) VisitStructLiteralExpr(expr *ast.StructLiteralExpr) (interfac
 
 		// Check field value type
 		valueType, _ := field.Value.Accept(a)
-		if !a.assignable(valueType.(types.Type), structField.Type, field.Value.Pos()) {
+		if !a.assignable(field.Value, valueType.(types.Type), structField.Type) {
 			// Error already reported
 		}
 	}
 
-	// Check all fields are provided
-	for _, structField := range structType.Fields {
-		if !providedFields[structField.Name] {
-			a.error(expr.Pos(),
-				fmt.Sprintf("missing field: %s", structField.Name))
-		}
-	}
-
 	a.exprTypes[expr] = structType
 	return structType, nil
 }
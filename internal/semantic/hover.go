@@ -0,0 +1,90 @@
+package semantic
+
+import (
+	"github.com/hassan/compiler/internal/lexer"
+	"github.com/hassan/compiler/internal/parser/ast"
+	"github.com/hassan/compiler/internal/semantic/types"
+	"github.com/hassan/compiler/internal/symtab"
+)
+
+// Hover queries.
+//
+// An editor hovering over a name wants two things: what type the thing
+// under the cursor has, and where the name was declared. The type checker
+// already works out both - every expression's type goes into exprTypes, and
+// every name is looked up to a symbol - so the analyzer keeps the lookups
+// too, in symbols, and answers from the two maps afterwards:
+//
+//	func area(p Point) int {
+//	    return p.w * p.h;     // hover p:     Point, parameter p at 1:11
+//	}                         // hover w:     int, field w of Point
+//	                          // hover *:     int
+//
+// DESIGN CHOICE: The analyzer records what it resolved rather than TypeAt
+// resolving names again. Scopes are popped as the checker leaves them, so
+// after analysis there is nothing left to look a local name up in; and a
+// second resolver would have to repeat every rule of the first - shadowing,
+// package-qualified names, builtins, conversions - to be sure of finding
+// the same symbol.
+
+// TypeAt returns the type of the innermost expression at pos in file, and
+// for a name the symbol it declares or refers to, whose Pos is where it was
+// declared. file must be one the analyzer has analyzed.
+//
+// A name in a type position ("var p Point") has the named type as its type.
+// Where pos is on nothing with a type, such as a keyword or a comment, both
+// results are nil; where pos is on an expression that isn't a name, the
+// symbol is.
+func (a *Analyzer) TypeAt(file *ast.File, pos lexer.Position) (types.Type, *symtab.Symbol) {
+	expr, ok := ast.FindNodeAt(file, pos).(ast.Expr)
+	if !ok {
+		return nil, nil
+	}
+
+	ident, isIdent := expr.(*ast.IdentifierExpr)
+	if !isIdent {
+		if t, ok := a.exprTypes[expr]; ok {
+			return t, nil
+		}
+		return nil, nil
+	}
+
+	symbol := a.symbols[ident]
+	if t, ok := a.exprTypes[ident]; ok {
+		return t, symbol
+	}
+	if symbol != nil {
+		return symbol.Type, symbol
+	}
+
+	// A field of a struct from another package has no symbol of its own
+	// here, but the member access it names has the field's type
+	if member := enclosingMember(file, ident); member != nil {
+		if t, ok := a.exprTypes[member]; ok {
+			return t, nil
+		}
+	}
+	return nil, nil
+}
+
+// enclosingMember returns the member access of which ident is the member,
+// if there is one.
+func enclosingMember(file *ast.File, ident *ast.IdentifierExpr) *ast.MemberExpr {
+	var found *ast.MemberExpr
+	ast.Inspect(file, func(node ast.Node) bool {
+		if node == nil || found != nil {
+			return false
+		}
+		if _, ok := node.(*ast.File); !ok {
+			span := lexer.Span{Start: node.Pos(), End: node.End()}
+			if !span.Contains(ident.Pos()) {
+				return false
+			}
+		}
+		if member, ok := node.(*ast.MemberExpr); ok && member.Member == ident {
+			found = member
+		}
+		return true
+	})
+	return found
+}
@@ -0,0 +1,200 @@
+package semantic
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/hassan/compiler/internal/errors"
+	"github.com/hassan/compiler/internal/parser/ast"
+	"github.com/hassan/compiler/internal/semantic/types"
+	"github.com/hassan/compiler/internal/symtab"
+)
+
+// Package is an imported package after it has been analyzed on its own.
+type Package struct {
+	// Path is the import path ("geometry", "shapes/circle")
+	Path string
+
+	// Name is the name from the package's package clause, which is also the
+	// name the importing file refers to it by unless the import is aliased
+	Name string
+
+	// Files are the package's parsed source files
+	Files []*ast.File
+
+	// Analyzer holds the package's own symbol table and expression types.
+	// Its global scope is what member expressions resolve against.
+	Analyzer *Analyzer
+}
+
+// Scope returns the package's global scope.
+func (p *Package) Scope() *symtab.Scope {
+	return p.Analyzer.GetScope()
+}
+
+// Importer resolves import paths to analyzed packages.
+//
+// DESIGN CHOICE: The analyzer only knows about ASTs and scopes; where an
+// import path's sources live (a directory tree, an in-memory map in tests, a
+// cache) is the driver's business. Keeping that behind an interface means the
+// analyzer never touches the file system, and the driver can load each
+// package once and hand the same *Package to every file that imports it.
+//
+// Implementations are responsible for cycle detection: Import is called
+// while the importing package is still being analyzed, so an importer that
+// blindly recursed on a cycle would never return.
+type Importer interface {
+	Import(path string) (*Package, error)
+}
+
+// SetImporter configures how imports are resolved.
+func (a *Analyzer) SetImporter(importer Importer) {
+	a.importer = importer
+}
+
+// IsExported reports whether a package-level name is visible to importers.
+//
+// The rule is Go's: a name is exported if it starts with an upper-case
+// letter. It needs no extra syntax, and a reader can tell from any use site
+// whether a name crosses a package boundary.
+func IsExported(name string) bool {
+	r, _ := utf8.DecodeRuneInString(name)
+	return unicode.IsUpper(r)
+}
+
+// processImport processes an import declaration.
+//
+// With an importer configured, the imported package is loaded and analyzed
+// first and the package symbol gets its global scope as Members. A failed
+// import is reported at the import path, with the imported package's own
+// diagnostics as notes, and only the first time its path is imported. The
+// symbol is still declared, without Members: uses of the package are then
+// known to be errors already reported, rather than each adding an
+// "undefined" error or a type mismatch of its own (see checkPackageMember).
+//
+// packageName is the name of the package doing the importing. Package main
+// importing "main" is reported here rather than by the importer: the
+// importer only sees cycles among the packages it loads, and the package
+// being compiled is not one of them, so to it "main" is just a directory
+// that is missing or holds some other package.
+func (a *Analyzer) processImport(imp *ast.ImportDecl, packageName string) {
+	importPath := imp.Path.Value.(string)
+	name := importPath
+
+	var pkg *Package
+	if packageName == "main" && importPath == "main" {
+		// Declaring the package symbol would collide with func main,
+		// adding a second error that only restates the first
+		a.error(imp.Path.Pos(), "import cycle not allowed: package main imports itself")
+		return
+	} else if a.importer != nil {
+		var err error
+		pkg, err = a.importer.Import(importPath)
+		if err != nil {
+			if !a.failedImports[importPath] {
+				a.failedImports[importPath] = true
+				diag := errors.New(errors.CodeSemantic, imp.Path.Pos(), err.Error())
+				if multi, ok := err.(interface{ Unwrap() []error }); ok {
+					for _, cause := range multi.Unwrap() {
+						diag.WithNote(cause.Error())
+					}
+				}
+				a.errors = append(a.errors, diag)
+			}
+
+			// Guess the name the package would have had, so its uses
+			// don't also report "undefined"
+			name = path.Base(importPath)
+		} else {
+			name = pkg.Name
+		}
+	}
+	if imp.Name != nil {
+		name = imp.Name.Name
+	}
+
+	symbol := &symtab.Symbol{
+		Name:       name,
+		Kind:       symtab.SymbolPackage,
+		Type:       types.Invalid, // Packages don't have a type
+		Pos:        imp.Pos(),
+		ImportPath: importPath,
+	}
+	if pkg != nil {
+		symbol.Members = pkg.Scope()
+	}
+
+	// Imports are gathered from every file of the package into the one
+	// global scope, so the same import appearing in two files is expected.
+	// Only a repeat within a single file is an error.
+	if existing := a.currentScope.LookupLocal(name); existing != nil &&
+		existing.Kind == symtab.SymbolPackage && existing.Pos.Filename != imp.Pos().Filename {
+		return
+	}
+
+	if err := a.currentScope.Define(symbol); err != nil {
+		a.error(imp.Pos(), err.Error())
+	}
+}
+
+// checkPackageMember resolves pkg.Name against an imported package.
+//
+// Only exported functions and variables can be referenced. When the member is
+// missing or unexported, the error lists what the package does export, which
+// is usually enough to spot a miscapitalized name.
+func (a *Analyzer) checkPackageMember(pkg *symtab.Symbol, expr *ast.MemberExpr) types.Type {
+	name := expr.Member.Name
+	if pkg.Members == nil {
+		// A failed import has already been reported where it was imported
+		if a.importer == nil {
+			a.error(expr.Member.Pos(), fmt.Sprintf(
+				"cannot use %s.%s: package %s was not resolved", pkg.Name, name, pkg.ImportPath))
+		} else {
+			a.unresolved[expr] = true
+		}
+		return types.Invalid
+	}
+
+	member := pkg.Members.LookupLocal(name)
+	if member == nil || member.Kind == symtab.SymbolPackage {
+		a.error(expr.Member.Pos(), fmt.Sprintf(
+			"package %s has no member %s%s", pkg.ImportPath, name, availableMembers(pkg.Members)))
+		return types.Invalid
+	}
+	if !IsExported(name) {
+		a.error(expr.Member.Pos(), fmt.Sprintf(
+			"%s is not exported by package %s%s", name, pkg.ImportPath, availableMembers(pkg.Members)))
+		return types.Invalid
+	}
+
+	switch member.Kind {
+	case symtab.SymbolFunction, symtab.SymbolVariable:
+		member.Used = true
+		a.reference(expr.Member, member)
+		return member.Type
+	default:
+		a.error(expr.Member.Pos(), fmt.Sprintf(
+			"%s.%s is a %s, not a value", pkg.Name, name, member.Kind))
+		return types.Invalid
+	}
+}
+
+// availableMembers formats the exported names of a package scope as a
+// trailing "; available: A, B" clause, or "; it exports nothing".
+func availableMembers(scope *symtab.Scope) string {
+	var names []string
+	for _, symbol := range scope.LocalSymbols() {
+		if symbol.Kind != symtab.SymbolPackage && IsExported(symbol.Name) {
+			names = append(names, symbol.Name)
+		}
+	}
+	if len(names) == 0 {
+		return "; it exports nothing"
+	}
+	sort.Strings(names)
+	return "; available: " + strings.Join(names, ", ")
+}
@@ -0,0 +1,53 @@
+package semantic
+
+import (
+	"github.com/hassan/compiler/internal/parser/ast"
+)
+
+// Incremental analysis.
+//
+// Analyze starts over on every call, as compiling a program should: the
+// package is all there at once. A REPL has the package a line at a time,
+// and each line has to see what the lines before it declared:
+//
+//	> var x = 3;
+//	> func twice(n int) int { return n * 2; }
+//	> twice(x)              // x and twice are still declared
+//
+// so AnalyzeIncremental checks more declarations against the global scope
+// earlier calls left behind, and keeps what they declare for the next.
+//
+// DESIGN CHOICE: A failed call takes back everything it declared. A line
+// with an error never runs, so a name it declared - a function whose body
+// didn't check, a variable of Invalid type - would only stand in the way:
+// the fixed line typed next would be a redeclaration, and other lines
+// could use a half-checked symbol. Nothing declared earlier is touched,
+// and a name that clashes with one is reported before any of the line is
+// checked, since checking it would fill in the types of the earlier
+// symbol (the checker finds declarations by name).
+
+// AnalyzeIncremental analyzes decls as further top-level declarations of
+// the package analyzed so far, rather than as a package of its own.
+// Returns the errors found in decls (empty if none); if there are any,
+// none of the names decls declare remain declared.
+//
+// The type information earlier calls recorded is kept, so GetExprType and
+// the other queries answer for the expressions of every successful call.
+func (a *Analyzer) AnalyzeIncremental(decls []ast.Decl) []error {
+	a.errors = make([]error, 0)
+	a.warnings = make([]error, 0)
+	a.currentScope = a.globalScope
+	mark := len(a.globalScope.SymbolsInOrder())
+
+	for _, decl := range decls {
+		a.declareDecl(decl)
+	}
+	if len(a.errors) == 0 {
+		a.checkDecls(decls)
+	}
+
+	if len(a.errors) > 0 {
+		a.globalScope.Truncate(mark)
+	}
+	return a.errors
+}
@@ -0,0 +1,123 @@
+package semantic
+
+import (
+	"fmt"
+
+	"github.com/hassan/compiler/internal/lexer"
+	"github.com/hassan/compiler/internal/parser/ast"
+	"github.com/hassan/compiler/internal/semantic/types"
+	"github.com/hassan/compiler/internal/symtab"
+)
+
+// Pointers: &x takes the address of x, and *p is the variable p points at.
+//
+// EXAMPLE:
+//
+//	func inc(p *int) {
+//	    *p = *p + 1;
+//	}
+//
+//	func main() {
+//	    var n int = 41;
+//	    inc(&n);             // n is now 42
+//	}
+//
+// The operand of & must be addressable: a local variable or parameter, a
+// field or element of something addressable (&p.x, &grid[i][j]), or a
+// dereference (&*p is p). A *p is addressable in turn, so it can be
+// assigned to, and (*p).x selects a field of the struct p points at.
+//
+// DESIGN CHOICE: Only locals and parameters have addresses; a global does
+// not. The builder gives a local whose address is taken a slot of its own,
+// and reads and writes it through that slot from then on, so that a store
+// through a pointer is seen by the next read of the name. A global has no
+// such slot to give: it is one variable shared by every function, each of
+// which the optimizer would have to assume any store could change.
+//
+// A pointer to a local may outlive the call it belongs to: returned, or
+// stored in a global or through another pointer. The local is then
+// allocated on the heap rather than the stack (see escape.go).
+
+// addressOf checks &operand and returns its type, a pointer to the type of
+// the operand.
+func (a *Analyzer) addressOf(expr *ast.UnaryExpr) types.Type {
+	var operandType types.Type
+	if ident, ok := expr.Operand.(*ast.IdentifierExpr); ok {
+		// Resolved rather than visited: taking a variable's address doesn't
+		// read it. Its slot starts out zeroed, so from here on it counts as
+		// assigned - a store through the pointer may be what assigns it
+		symbol, typ := a.resolveIdentifier(ident)
+		operandType = typ
+		if symbol != nil {
+			if _, tracked := a.definitelyAssigned[symbol]; tracked {
+				a.definitelyAssigned[symbol] = true
+			}
+		}
+	} else {
+		t, _ := expr.Operand.Accept(a)
+		operandType = t.(types.Type)
+	}
+
+	if operandType.Equals(types.Invalid) {
+		return types.Invalid
+	}
+	if message := a.notAddressable(expr.Operand); message != "" {
+		a.error(expr.Operand.Pos(), message)
+		return types.Invalid
+	}
+	return types.NewPointer(operandType)
+}
+
+// dereference checks *operand, whose operand has type t, and returns the
+// type pointed at.
+func (a *Analyzer) dereference(expr *ast.UnaryExpr, t types.Type) types.Type {
+	pointer, ok := types.Underlying(t).(*types.PointerType)
+	if !ok {
+		if !t.Equals(types.Invalid) {
+			a.error(expr.Operator.Position, fmt.Sprintf("unary * requires pointer operand, got %s", t))
+		}
+		return types.Invalid
+	}
+	return pointer.Elem
+}
+
+// notAddressable explains why expr has no address, or returns "" if it has
+// one. expr has already been visited.
+func (a *Analyzer) notAddressable(expr ast.Expr) string {
+	switch e := expr.(type) {
+	case *ast.GroupingExpr:
+		return a.notAddressable(e.Expression)
+
+	case *ast.IdentifierExpr:
+		symbol := a.currentScope.Lookup(e.Name)
+		switch {
+		case symbol == nil:
+			return ""
+		case symbol.Kind != symtab.SymbolVariable && symbol.Kind != symtab.SymbolParameter:
+			return fmt.Sprintf("cannot take the address of %s", e.Name)
+		case symbol.Constant:
+			return fmt.Sprintf("cannot take the address of constant %s", e.Name)
+		case symbol.Scope != nil && symbol.Scope.IsGlobal():
+			return fmt.Sprintf("cannot take the address of global variable %s", e.Name)
+		}
+		return ""
+
+	case *ast.MemberExpr:
+		// A member of a package is one of its globals
+		if ident, ok := e.Object.(*ast.IdentifierExpr); ok {
+			if symbol := a.currentScope.Lookup(ident.Name); symbol != nil && symbol.Kind == symtab.SymbolPackage {
+				return fmt.Sprintf("cannot take the address of global variable %s.%s", ident.Name, e.Member.Name)
+			}
+		}
+		return a.notAddressable(e.Object)
+
+	case *ast.IndexExpr:
+		return a.notAddressable(e.Object)
+
+	case *ast.UnaryExpr:
+		if e.Operator.Type == lexer.TokenStar {
+			return ""
+		}
+	}
+	return "cannot take the address of a value that isn't stored in a variable"
+}
@@ -0,0 +1,96 @@
+package semantic
+
+import (
+	"sort"
+
+	"github.com/hassan/compiler/internal/lexer"
+	"github.com/hassan/compiler/internal/parser/ast"
+	"github.com/hassan/compiler/internal/symtab"
+)
+
+// Definitions and references.
+//
+// Every name the analyzer resolves is recorded in symbols - declarations
+// and uses alike - and every use is appended to its symbol's References as
+// well. "Go to definition" on a name is then its symbol's Pos, and "find
+// references" its symbol's References:
+//
+//	func f(x int) int {   // x declared here: DefinitionOf any x below
+//	    var y int = x;    //   use 1
+//	    {
+//	        var x int = 2; // a second x, shadowing the parameter
+//	        y = y + x;     //   a use of the second x, not the parameter
+//	    }
+//	    return x + y;     //   use 2
+//	}
+//
+// Shadowing needs nothing special: each use is recorded with the symbol
+// the scope chain resolved it to, which is the innermost declaration.
+//
+// DESIGN CHOICE: Uses recorded by the analyzer, where it resolves them,
+// rather than by Scope.Lookup. Lookup is also how the analyzer asks
+// whether a name is taken (redeclarations, shadowing warnings, is this a
+// builtin?), and those questions aren't references; only the analyzer
+// knows which lookups are the program naming something.
+
+// reference records that ident, a use rather than a declaration, refers to
+// symbol. A name checked twice is recorded once.
+func (a *Analyzer) reference(ident *ast.IdentifierExpr, symbol *symtab.Symbol) {
+	if a.symbols[ident] == symbol {
+		return
+	}
+	a.symbols[ident] = symbol
+	if symbol.Pos.IsValid() { // A builtin is declared nowhere
+		symbol.References = append(symbol.References, ident.Pos())
+	}
+}
+
+// symbolAt returns the symbol of the name at pos, with the same rule for a
+// cursor between two names as ast.FindNodeAt: the name starting at pos
+// wins, then the one pos is at the end of.
+func (a *Analyzer) symbolAt(pos lexer.Position) *symtab.Symbol {
+	var found *ast.IdentifierExpr
+	for ident := range a.symbols {
+		span := lexer.Span{Start: ident.Pos(), End: ident.End()}
+		if span.Start.Filename != pos.Filename || !span.Contains(pos) {
+			continue
+		}
+		if found == nil || ident.Pos().Offset > found.Pos().Offset {
+			found = ident
+		}
+	}
+	if found == nil {
+		return nil
+	}
+	return a.symbols[found]
+}
+
+// DefinitionOf returns where the thing named at pos - a variable,
+// parameter, function, type, struct field or package - is declared. pos
+// may be on a use of the name or on its declaration. ok is false where pos
+// isn't on a name the analyzer resolved, or the name is a builtin.
+func (a *Analyzer) DefinitionOf(pos lexer.Position) (lexer.Position, bool) {
+	symbol := a.symbolAt(pos)
+	if symbol == nil || !symbol.Pos.IsValid() {
+		return lexer.Position{}, false
+	}
+	return symbol.Pos, true
+}
+
+// ReferencesOf returns the positions of every use of the thing named at
+// pos, ordered by file and offset, leaving out its declaration (see
+// DefinitionOf). It is nil where pos isn't on a name.
+func (a *Analyzer) ReferencesOf(pos lexer.Position) []lexer.Position {
+	symbol := a.symbolAt(pos)
+	if symbol == nil || len(symbol.References) == 0 {
+		return nil
+	}
+	refs := append([]lexer.Position(nil), symbol.References...)
+	sort.Slice(refs, func(i, j int) bool {
+		if refs[i].Filename != refs[j].Filename {
+			return refs[i].Filename < refs[j].Filename
+		}
+		return refs[i].Offset < refs[j].Offset
+	})
+	return refs
+}
@@ -0,0 +1,92 @@
+package semantic
+
+import "github.com/hassan/compiler/internal/parser/ast"
+
+// Missing returns: a function with a result type must return on every path,
+// so no path may reach the closing brace of its body.
+//
+// EXAMPLE:
+//
+//	func sign(n int) int {
+//	    if (n < 0) { return -1; }
+//	    if (n > 0) { return 1; }
+//	}                // error: missing return when n is 0
+//
+// DESIGN CHOICE: A syntactic check over the statements, in the style of
+// Go's "terminating statements", rather than asking whether the CFG has a
+// path to the end. It runs before any IR exists and needs no knowledge of
+// the values - "if (n < 0) ... if (n >= 0) ..." covers every n, but it
+// isn't accepted, which is simple to explain and what Go does too. The
+// builder checks the CFG it produces as well (ir.CheckMissingReturn), as a
+// backstop.
+//
+// A switch terminates when it is exhaustive (see switch.go) and every case
+// terminates without a break. Otherwise a value matching no case leaves it.
+// That depends on the case values, so these are methods of the analyzer,
+// run once the function body has been checked.
+
+// terminates reports whether control can't continue past stmt: every path
+// through it returns, or loops forever.
+func (a *Analyzer) terminates(stmt ast.Stmt) bool {
+	switch s := stmt.(type) {
+	case *ast.ReturnStmt:
+		return true
+
+	case *ast.BlockStmt:
+		return a.terminatesList(s.Statements)
+
+	case *ast.IfStmt:
+		// Without an else, a false condition goes straight past the if
+		return s.ElseBranch != nil && a.terminates(s.ThenBranch) && a.terminates(s.ElseBranch)
+
+	case *ast.SwitchStmt:
+		if !a.exhaustive[s] {
+			return false
+		}
+		for _, c := range s.Cases {
+			if !a.terminatesList(c.Body) || breaks(c.Body) {
+				return false
+			}
+		}
+		return true
+
+	case *ast.ForStmt:
+		// Only "for (;;)" loops forever, and then only without a break.
+		// A condition, even "true", isn't looked at
+		return s.Condition == nil && !breaks(s.Body.Statements)
+	}
+	return false
+}
+
+// terminatesList reports whether a statement list terminates. Any of its
+// statements terminating is enough, not just the last: what follows one is
+// unreachable, and already reported as such.
+func (a *Analyzer) terminatesList(stmts []ast.Stmt) bool {
+	for _, stmt := range stmts {
+		if a.terminates(stmt) {
+			return true
+		}
+	}
+	return false
+}
+
+// breaks reports whether stmts contain a break out of the loop or switch
+// they are the body of. A break inside a nested loop or switch leaves that
+// instead, so those aren't searched.
+func breaks(stmts []ast.Stmt) bool {
+	for _, stmt := range stmts {
+		switch s := stmt.(type) {
+		case *ast.BreakStmt:
+			return true
+		case *ast.BlockStmt:
+			if breaks(s.Statements) {
+				return true
+			}
+		case *ast.IfStmt:
+			if breaks(s.ThenBranch.Statements) || (s.ElseBranch != nil && breaks([]ast.Stmt{s.ElseBranch})) {
+				return true
+			}
+		}
+	}
+	return false
+}
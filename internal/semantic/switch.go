@@ -0,0 +1,114 @@
+package semantic
+
+import (
+	"fmt"
+
+	"github.com/hassan/compiler/internal/lexer"
+	"github.com/hassan/compiler/internal/parser/ast"
+	"github.com/hassan/compiler/internal/semantic/types"
+)
+
+// Switch case values.
+//
+// A case value must be a constant, as the switch statement's definition
+// promises: a literal, an integer constant expression ("1 << 4", "int8(-1)"),
+// or a negated or parenthesized constant. Knowing every value lets the
+// analyzer catch what would otherwise compile silently:
+//
+//	switch (n) {
+//	case 1: ...
+//	case 2, 1: ...   // error: duplicate case value 1, this case can't run
+//	default: ...
+//	default: ...     // error: a second default can't run either
+//	}
+//
+// DESIGN CHOICE: Rejecting non-constant cases rather than allowing them and
+// skipping the duplicate check for them. With "case x:" two cases could
+// match the same value without either being a mistake the compiler can see,
+// so the check would only hold for switches that happen to be constant. A
+// chain of ifs says what a switch over computed values means more plainly.
+//
+// A switch is exhaustive when some case runs whatever the value: it has a
+// default, or it is over a bool and has cases for both true and false. The
+// analyzer records which switches are (IsExhaustive). The missing-return
+// check and definite assignment then know the value can't skip every case,
+// and the builder emits no edge for it doing so.
+//
+// DESIGN CHOICE: Only bools are exhaustive without a default. A char or int
+// switch would need a case for every one of its values, which no real
+// switch has; such switches still need a default to cover every path.
+
+// caseConstant returns the value of a case expression that is a constant:
+// an int64 for every integer type, or a float64, string, rune or bool.
+func (a *Analyzer) caseConstant(expr ast.Expr) (interface{}, bool) {
+	if value, ok := a.intConstants[expr]; ok {
+		return value, true
+	}
+	switch e := expr.(type) {
+	case *ast.LiteralExpr:
+		switch e.Value.(type) {
+		case float64, string, rune, bool:
+			return e.Value, true
+		}
+	case *ast.GroupingExpr:
+		return a.caseConstant(e.Expression)
+	case *ast.UnaryExpr:
+		operand, ok := a.caseConstant(e.Operand)
+		if !ok {
+			return nil, false
+		}
+		switch v := operand.(type) {
+		case float64:
+			if e.Operator.Type == lexer.TokenMinus {
+				return -v, true
+			}
+		case bool:
+			if e.Operator.Type == lexer.TokenNot {
+				return !v, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// checkCaseValue reports a case value that isn't constant, or that an
+// earlier case of the same switch already has. seen maps each value so far
+// to where it appeared.
+func (a *Analyzer) checkCaseValue(expr ast.Expr, seen map[interface{}]lexer.Position) {
+	value, ok := a.caseConstant(expr)
+	if !ok {
+		a.error(expr.Pos(), "case value must be constant")
+		return
+	}
+	if previous, ok := seen[value]; ok {
+		a.error(expr.Pos(), fmt.Sprintf("duplicate case value %s (previous at %s)", formatConstant(value), previous))
+		return
+	}
+	seen[value] = expr.Pos()
+}
+
+// isExhaustive reports whether a switch over a value of type t, with the
+// case values seen, runs a case for every value.
+func isExhaustive(t types.Type, hasDefault bool, seen map[interface{}]lexer.Position) bool {
+	if hasDefault {
+		return true
+	}
+	if !types.IsBooleanType(t) {
+		return false
+	}
+	_, hasTrue := seen[true]
+	_, hasFalse := seen[false]
+	return hasTrue && hasFalse
+}
+
+// formatConstant writes a case value as it would appear in source.
+func formatConstant(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return fmt.Sprintf("%q", v)
+	case rune:
+		return fmt.Sprintf("%q", v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
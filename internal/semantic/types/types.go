@@ -69,9 +69,11 @@ const (
 	KindString
 	KindChar
 	KindArray
+	KindPointer
 	KindStruct
 	KindFunction
 	KindNil
+	KindNamed
 )
 
 // Base type implementations
@@ -98,13 +100,85 @@ func (v *VoidType) Equals(other Type) bool   { _, ok := other.(*VoidType); retur
 func (v *VoidType) AssignableTo(Type) bool   { return false }
 func (v *VoidType) kind() TypeKind            { return KindVoid }
 
-// IntType represents integer type
-type IntType struct{}
+// IntType represents an integer type of a given width: int8, int16, int32
+// and int (64 bits, also spelled int64), and their unsigned counterparts
+// uint8, uint16, uint32 and uint64.
+//
+// DESIGN CHOICE: One struct parameterized by width and signedness rather
+// than a type per width because:
+//   - The rules are the same for every width: integer operations, only
+//     between identical types, and assignment to any width that holds
+//     every value (see AssignableTo)
+//   - Code that only cares that a value is an integer keeps matching
+//     *IntType, as it did when int was the only one
+//   - The range and wrapping of a width are computed, not written out eight
+//     times
+//
+// REPRESENTATION: Whatever the width, a value is held in 64 bits, sign-
+// extended for signed types and zero-extended for unsigned ones (see Wrap).
+// Every 8, 16 and 32-bit value is then also a valid int, so the IR and the
+// backends compute with the 64-bit operation and only need to bring the
+// result back into range. uint64 is the exception: its values above the
+// int range have the bit patterns of negative ints, so its division,
+// remainder, comparison and right shift are unsigned operations.
+type IntType struct {
+	Bits     int // 8, 16, 32 or 64
+	Unsigned bool
+}
+
+func (i *IntType) String() string {
+	if i.Bits == 64 && !i.Unsigned {
+		return "int"
+	}
+	if i.Unsigned {
+		return fmt.Sprintf("uint%d", i.Bits)
+	}
+	return fmt.Sprintf("int%d", i.Bits)
+}
 
-func (i *IntType) String() string           { return "int" }
-func (i *IntType) Equals(other Type) bool   { _, ok := other.(*IntType); return ok }
-func (i *IntType) AssignableTo(other Type) bool { return i.Equals(other) }
-func (i *IntType) kind() TypeKind            { return KindInt }
+func (i *IntType) Equals(other Type) bool {
+	otherInt, ok := other.(*IntType)
+	return ok && otherInt.Bits == i.Bits && otherInt.Unsigned == i.Unsigned
+}
+
+// AssignableTo reports whether a value of this type can be assigned to
+// other: an integer type of the same signedness and at least the same
+// width, so an int8 goes to an int32 or an int, and a uint8 to a uint64.
+//
+// DESIGN CHOICE: Widening is implicit, narrowing takes a conversion
+// (int8(n)). A wider type of the same signedness holds every value, so the
+// assignment can't lose anything; a narrower one would wrap. Signedness
+// never changes implicitly, even toward a wider type: -1 would become a
+// uint16 of 65535, and a uint64 above the int range a negative int. A
+// named type takes only its own values, as for every other type.
+func (i *IntType) AssignableTo(other Type) bool {
+	_, ok := other.(*IntType)
+	return ok && IsSignedInt(other) == !i.Unsigned && WidthOf(other) >= i.Bits
+}
+
+func (i *IntType) kind() TypeKind { return KindInt }
+
+// Wrap brings a 64-bit result into this type's range, keeping its low Bits
+// bits, sign-extended or zero-extended. 64-bit types wrap by themselves.
+func (i *IntType) Wrap(n int64) int64 {
+	if i.Bits >= 64 {
+		return n
+	}
+	shift := uint(64 - i.Bits)
+	if i.Unsigned {
+		return int64(uint64(n) << shift >> shift)
+	}
+	return n << shift >> shift
+}
+
+// Fits reports whether the int n is in this type's range. For uint64, n
+// must be non-negative: the bit patterns above the int range aren't ints.
+func (i *IntType) Fits(n int64) bool {
+	if i.Unsigned && n < 0 {
+		return false
+	}
+	return i.Wrap(n) == n
+}
 
 // FloatType represents floating-point type
 type FloatType struct{}
@@ -200,6 +274,41 @@ func (a *ArrayType) kind() TypeKind {
 	return KindArray
 }
 
+// PointerType represents the type of an address: *T
+//
+// A *T is made by taking the address of a variable of type T (&x) or of
+// one of its fields or elements (&p.x, &a[i]), and dereferencing it (*p)
+// reads or writes that variable.
+//
+// DESIGN CHOICE: Pointers are only created by &, never from nil or by
+// arithmetic. Every *T then points at a live T, so a dereference needs no
+// check, and nil stays what NilType already makes it, the empty array or
+// struct. Pointers aren't comparable either: with nothing to compare a
+// pointer against but another address, == would only tell whether two
+// names alias, which no program so far has needed.
+type PointerType struct {
+	Elem Type
+}
+
+func (p *PointerType) String() string {
+	return "*" + p.Elem.String()
+}
+
+func (p *PointerType) Equals(other Type) bool {
+	if otherPointer, ok := other.(*PointerType); ok {
+		return p.Elem.Equals(otherPointer.Elem)
+	}
+	return false
+}
+
+func (p *PointerType) AssignableTo(other Type) bool {
+	return p.Equals(other)
+}
+
+func (p *PointerType) kind() TypeKind {
+	return KindPointer
+}
+
 // StructType represents a struct type
 //
 // DESIGN CHOICE: Store fields as a slice rather than a map because:
@@ -276,6 +385,17 @@ func (s *StructType) LookupField(name string) *StructField {
 	return nil
 }
 
+// FieldIndex returns the position of the named field in declaration order,
+// the index GetFieldPtr takes, or -1 if there is no such field
+func (s *StructType) FieldIndex(name string) int {
+	for i := range s.Fields {
+		if s.Fields[i].Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
 // FunctionType represents a function type
 //
 // STRUCTURAL TYPING: Functions are equal if they have the same signature.
@@ -326,24 +446,96 @@ func (f *FunctionType) kind() TypeKind {
 	return KindFunction
 }
 
+// NamedType is a type declared with "type Name Underlying": a distinct type
+// sharing Underlying's representation and operations.
+//
+// EXAMPLE:
+//
+//	type Meters int;
+//	type Seconds int;
+//
+// Meters and Seconds both add, compare and print like int, but a Meters
+// can't be assigned to a Seconds or an int, nor mixed with one in an
+// operation, without a conversion: Seconds(m), int(m).
+//
+// NOMINAL TYPING: Each declaration creates one NamedType, and a named type
+// is equal only to itself (compared by pointer, not by name or structure).
+// This goes further than StructType, where two struct types with the same
+// name are equal: a named type is meant to keep apart values that happen
+// to share a representation.
+//
+// DESIGN CHOICE: A wrapper around the underlying type rather than a flag on
+// each primitive because:
+// - int, float, ... stay singletons, compared by type switch as before
+// - Anything that only cares about representation (the arithmetic rules,
+//   the IR builder) looks through with Underlying, in one place
+// - The analyzer can create a NamedType before resolving its underlying
+//   type, so a declaration may refer to named types declared after it
+type NamedType struct {
+	Name       string
+	Underlying Type
+}
+
+func (n *NamedType) String() string { return n.Name }
+
+func (n *NamedType) Equals(other Type) bool {
+	otherNamed, ok := other.(*NamedType)
+	return ok && otherNamed == n
+}
+
+func (n *NamedType) AssignableTo(other Type) bool {
+	return n.Equals(other)
+}
+
+func (n *NamedType) kind() TypeKind {
+	return KindNamed
+}
+
 // Predefined type instances (singletons)
 // These are used throughout the compiler to avoid allocating new type instances
 var (
 	Invalid = &InvalidType{}
 	Void    = &VoidType{}
-	Int     = &IntType{}
+	Int     = &IntType{Bits: 64}
 	Float   = &FloatType{}
 	Bool    = &BoolType{}
 	String  = &StringType{}
 	Char    = &CharType{}
 	Nil     = &NilType{}
+
+	Int8   = &IntType{Bits: 8}
+	Int16  = &IntType{Bits: 16}
+	Int32  = &IntType{Bits: 32}
+	Uint8  = &IntType{Bits: 8, Unsigned: true}
+	Uint16 = &IntType{Bits: 16, Unsigned: true}
+	Uint32 = &IntType{Bits: 32, Unsigned: true}
+	Uint64 = &IntType{Bits: 64, Unsigned: true}
 )
 
 // Helper functions
 
+// Underlying returns the type t is represented as: t itself, or for a named
+// type the non-named type at the end of its chain of declarations. A named
+// type whose underlying type isn't resolved yet gives Invalid.
+func Underlying(t Type) Type {
+	for {
+		named, ok := t.(*NamedType)
+		if !ok {
+			return t
+		}
+		if named.Underlying == nil {
+			return Invalid
+		}
+		t = named.Underlying
+	}
+}
+
+// The predicates below are about the operations a type allows, which a
+// named type shares with its underlying type: they look through it.
+
 // IsNumeric returns true if the type is numeric (int or float)
 func IsNumeric(t Type) bool {
-	switch t.(type) {
+	switch Underlying(t).(type) {
 	case *IntType, *FloatType:
 		return true
 	default:
@@ -353,7 +545,7 @@ func IsNumeric(t Type) bool {
 
 // IsComparable returns true if values of this type can be compared with ==, !=
 func IsComparable(t Type) bool {
-	switch t.(type) {
+	switch Underlying(t).(type) {
 	case *IntType, *FloatType, *BoolType, *StringType, *CharType:
 		return true
 	default:
@@ -363,7 +555,7 @@ func IsComparable(t Type) bool {
 
 // IsOrdered returns true if values of this type can be compared with <, <=, >, >=
 func IsOrdered(t Type) bool {
-	switch t.(type) {
+	switch Underlying(t).(type) {
 	case *IntType, *FloatType, *StringType, *CharType:
 		return true
 	default:
@@ -373,16 +565,37 @@ func IsOrdered(t Type) bool {
 
 // IsBooleanType returns true if the type is boolean
 func IsBooleanType(t Type) bool {
-	_, ok := t.(*BoolType)
+	_, ok := Underlying(t).(*BoolType)
 	return ok
 }
 
 // IsIntegerType returns true if the type is integer
 func IsIntegerType(t Type) bool {
-	_, ok := t.(*IntType)
+	_, ok := Underlying(t).(*IntType)
 	return ok
 }
 
+// WidthOf returns the number of bits of an integer type, or 0 for any other
+func WidthOf(t Type) int {
+	if i, ok := Underlying(t).(*IntType); ok {
+		return i.Bits
+	}
+	return 0
+}
+
+// IsSignedInt returns true if the type is a signed integer type
+func IsSignedInt(t Type) bool {
+	i, ok := Underlying(t).(*IntType)
+	return ok && !i.Unsigned
+}
+
+// IsUnsigned64 returns true if the type is uint64, the one integer type
+// whose division, remainder, comparisons and right shift differ from int's
+func IsUnsigned64(t Type) bool {
+	i, ok := Underlying(t).(*IntType)
+	return ok && i.Unsigned && i.Bits == 64
+}
+
 // NewArray creates a new array type
 func NewArray(elementType Type, size int) *ArrayType {
 	return &ArrayType{
@@ -391,6 +604,11 @@ func NewArray(elementType Type, size int) *ArrayType {
 	}
 }
 
+// NewPointer creates a new pointer type
+func NewPointer(elem Type) *PointerType {
+	return &PointerType{Elem: elem}
+}
+
 // NewStruct creates a new struct type
 func NewStruct(name string, fields []StructField) *StructType {
 	return &StructType{
@@ -399,6 +617,15 @@ func NewStruct(name string, fields []StructField) *StructType {
 	}
 }
 
+// NewNamed creates a new named type. underlying may be nil, to be set once
+// it is resolved.
+func NewNamed(name string, underlying Type) *NamedType {
+	return &NamedType{
+		Name:       name,
+		Underlying: underlying,
+	}
+}
+
 // NewFunction creates a new function type
 func NewFunction(parameters []Type, returnType Type) *FunctionType {
 	return &FunctionType{
@@ -106,6 +106,31 @@ func TestFunctionType(t *testing.T) {
 	}
 }
 
+func TestPointerType(t *testing.T) {
+	pointer := NewPointer(Int)
+
+	if pointer.String() != "*int" {
+		t.Errorf("PointerType.String() = %q, want %q", pointer.String(), "*int")
+	}
+	if s := NewPointer(pointer).String(); s != "**int" {
+		t.Errorf("PointerType.String() = %q, want %q", s, "**int")
+	}
+
+	if !pointer.Equals(NewPointer(Int)) || !pointer.AssignableTo(NewPointer(Int)) {
+		t.Error("Expected pointers to the same type to be equal and assignable")
+	}
+	// Widening applies to integers, not to what a pointer points at
+	if NewPointer(Int32).AssignableTo(pointer) {
+		t.Error("Expected *int32 to not be assignable to *int")
+	}
+	if pointer.Equals(Int) || Int.AssignableTo(pointer) {
+		t.Error("Expected pointer type to not equal or accept int")
+	}
+	if IsComparable(pointer) {
+		t.Error("Expected pointers to not be comparable")
+	}
+}
+
 func TestStructType(t *testing.T) {
 	fields := []StructField{
 		{Name: "x", Type: Int},
@@ -155,6 +180,7 @@ func TestIsNumeric(t *testing.T) {
 		{"bool is not numeric", Bool, false},
 		{"string is not numeric", String, false},
 		{"void is not numeric", Void, false},
+		{"named int is numeric", NewNamed("Meters", Int), true},
 	}
 
 	for _, tt := range tests {
@@ -200,6 +226,8 @@ func TestIsIntegerType(t *testing.T) {
 		{"float is not integer", Float, false},
 		{"bool is not integer", Bool, false},
 		{"char is not integer", Char, false},
+		{"named int is integer", NewNamed("Meters", Int), true},
+		{"named float is not integer", NewNamed("Ratio", Float), false},
 	}
 
 	for _, tt := range tests {
@@ -226,3 +254,119 @@ func findSubstring(s, substr string) bool {
 	}
 	return false
 }
+
+func TestNamedType(t *testing.T) {
+	meters := NewNamed("Meters", Int)
+	seconds := NewNamed("Seconds", Int)
+
+	if meters.String() != "Meters" {
+		t.Errorf("Expected 'Meters', got '%s'", meters.String())
+	}
+	if !meters.Equals(meters) || !meters.AssignableTo(meters) {
+		t.Error("Expected a named type to equal and be assignable to itself")
+	}
+
+	// Distinct from its underlying type, in both directions, and from
+	// another named type with the same underlying type or the same name
+	others := []Type{Int, seconds, NewNamed("Meters", Int)}
+	for _, other := range others {
+		if meters.Equals(other) || other.Equals(meters) {
+			t.Errorf("Expected Meters to not equal %s", other)
+		}
+		if meters.AssignableTo(other) || other.AssignableTo(meters) {
+			t.Errorf("Expected Meters and %s to not be assignable", other)
+		}
+	}
+}
+
+func TestUnderlying(t *testing.T) {
+	meters := NewNamed("Meters", Int)
+	tests := []struct {
+		name     string
+		typ      Type
+		expected Type
+	}{
+		{"primitive is its own", Float, Float},
+		{"named", meters, Int},
+		{"chain of named types", NewNamed("Length", meters), Int},
+		{"unresolved", NewNamed("Later", nil), Invalid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Underlying(tt.typ); got != tt.expected {
+				t.Errorf("Underlying(%s) = %s, want %s", tt.typ, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestIntType(t *testing.T) {
+	names := map[Type]string{Int: "int", Int8: "int8", Int16: "int16", Int32: "int32",
+		Uint8: "uint8", Uint16: "uint16", Uint32: "uint32", Uint64: "uint64"}
+	for typ, name := range names {
+		if typ.String() != name {
+			t.Errorf("Expected '%s', got '%s'", name, typ.String())
+		}
+	}
+	if !Int32.Equals(&IntType{Bits: 32}) || Int32.Equals(Uint32) || Int8.Equals(Int16) {
+		t.Error("Expected integer types to be equal exactly when width and signedness are")
+	}
+
+	tests := []struct {
+		typ        *IntType
+		n, wrapped int64
+		fits       bool
+	}{
+		{Int8, 127, 127, true},
+		{Int8, 128, -128, false},
+		{Int8, -129, 127, false},
+		{Uint8, 300, 44, false},
+		{Uint8, -1, 255, false},
+		{Int16, 40000, -25536, false},
+		{Uint32, 1 << 32, 0, false},
+		{Uint64, -1, -1, false},
+		{Int, -1, -1, true},
+	}
+	for _, tt := range tests {
+		if got := tt.typ.Wrap(tt.n); got != tt.wrapped {
+			t.Errorf("%s.Wrap(%d) = %d, want %d", tt.typ, tt.n, got, tt.wrapped)
+		}
+		if got := tt.typ.Fits(tt.n); got != tt.fits {
+			t.Errorf("%s.Fits(%d) = %v, want %v", tt.typ, tt.n, got, tt.fits)
+		}
+	}
+}
+
+func TestIntType_Widening(t *testing.T) {
+	meters := &NamedType{Name: "Meters", Underlying: Int}
+	tests := []struct {
+		from, to   Type
+		assignable bool
+	}{
+		{Int8, Int8, true},
+		{Int8, Int16, true},
+		{Int8, Int, true},
+		{Int32, Int, true},
+		{Uint8, Uint16, true},
+		{Uint32, Uint64, true},
+		{Int16, Int8, false},
+		{Int, Int32, false},
+		{Uint8, Int, false},
+		{Int8, Uint64, false},
+		{Int8, meters, false},
+		{Int8, Float, false},
+	}
+	for _, tt := range tests {
+		if got := tt.from.AssignableTo(tt.to); got != tt.assignable {
+			t.Errorf("%s.AssignableTo(%s) = %v, want %v", tt.from, tt.to, got, tt.assignable)
+		}
+	}
+
+	if WidthOf(Int16) != 16 || WidthOf(Int) != 64 || WidthOf(meters) != 64 || WidthOf(Float) != 0 {
+		t.Error("Expected WidthOf to give the bits of integer types and 0 otherwise")
+	}
+	if !IsSignedInt(Int8) || !IsSignedInt(meters) || IsSignedInt(Uint64) || IsSignedInt(Float) {
+		t.Error("Expected IsSignedInt only for signed integer types")
+	}
+}
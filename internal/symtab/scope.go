@@ -30,6 +30,10 @@ const (
 
 	// ScopeStruct is a struct scope (for fields)
 	ScopeStruct
+
+	// ScopeUniverse encloses every package's global scope and holds the
+	// predeclared names (builtin functions), so a package can shadow them
+	ScopeUniverse
 )
 
 // String returns a human-readable representation of the scope kind.
@@ -47,6 +51,8 @@ func (sk ScopeKind) String() string {
 		return "switch"
 	case ScopeStruct:
 		return "struct"
+	case ScopeUniverse:
+		return "universe"
 	default:
 		return "unknown"
 	}
@@ -80,13 +86,20 @@ type Scope struct {
 	// Parent is the enclosing scope (nil for global scope)
 	Parent *Scope
 
-	// Symbols maps names to their symbols in this scope
+	// symbolMap maps names to their symbols in this scope
 	// We use a map for O(1) lookup
 	// DESIGN CHOICE: Don't use sync.Map because:
 	// - Symbol tables are typically built in one pass (no concurrency)
 	// - Regular maps are faster for our use case
 	// - If we add concurrency later, we can add locks
-	Symbols map[string]*Symbol
+	symbolMap map[string]*Symbol
+
+	// symbolOrder holds the same symbols in the order they were defined.
+	// Ranging over symbolMap visits them in a different order every run;
+	// anything that lists a scope's symbols (warnings, debug dumps, the
+	// members of a package) goes through symbolOrder so that the
+	// compiler's output is the same from one run to the next.
+	symbolOrder []*Symbol
 
 	// Children are the scopes nested inside this one
 	// We track these for:
@@ -100,12 +113,6 @@ type Scope struct {
 	// - Return type checking (does return value match function signature?)
 	// - Closure analysis (which variables are captured?)
 	Function *Symbol
-
-	// Depth is the nesting depth (0 for global, 1 for top-level function, etc.)
-	// Used for:
-	// - Debugging and visualization
-	// - Optimization heuristics (deeply nested code is less performance-critical)
-	Depth int
 }
 
 // NewScope creates a new scope with the given kind and parent.
@@ -115,17 +122,11 @@ type Scope struct {
 //   funcScope := NewScope(ScopeFunction, global)
 //   blockScope := NewScope(ScopeBlock, funcScope)
 func NewScope(kind ScopeKind, parent *Scope) *Scope {
-	depth := 0
-	if parent != nil {
-		depth = parent.Depth + 1
-	}
-
 	scope := &Scope{
-		Kind:     kind,
-		Parent:   parent,
-		Symbols:  make(map[string]*Symbol),
-		Children: make([]*Scope, 0),
-		Depth:    depth,
+		Kind:      kind,
+		Parent:    parent,
+		symbolMap: make(map[string]*Symbol),
+		Children:  make([]*Scope, 0),
 	}
 
 	// Link to parent
@@ -157,18 +158,35 @@ func NewScope(kind ScopeKind, parent *Scope) *Scope {
 //       var x = 2;  // This is OK - shadows outer x
 //   }
 func (s *Scope) Define(symbol *Symbol) error {
-	if existing, ok := s.Symbols[symbol.Name]; ok {
+	if existing, ok := s.symbolMap[symbol.Name]; ok {
 		return fmt.Errorf("symbol %s already declared at %s",
 			symbol.Name, existing.Pos.String())
 	}
 
-	s.Symbols[symbol.Name] = symbol
+	s.symbolMap[symbol.Name] = symbol
+	s.symbolOrder = append(s.symbolOrder, symbol)
 	symbol.Scope = s
-	symbol.Index = len(s.Symbols) - 1 // 0-based index
+	symbol.Index = len(s.symbolOrder) - 1 // 0-based index
 
 	return nil
 }
 
+// Truncate removes every symbol defined after the first n, undoing the
+// Define calls since the scope held n symbols.
+//
+// This is for taking back declarations that turned out to be in error,
+// as the REPL does with a line that fails to check: the names it declared
+// must not linger, half-checked, for the next line to find.
+func (s *Scope) Truncate(n int) {
+	if n < 0 || n >= len(s.symbolOrder) {
+		return
+	}
+	for _, symbol := range s.symbolOrder[n:] {
+		delete(s.symbolMap, symbol.Name)
+	}
+	s.symbolOrder = s.symbolOrder[:n]
+}
+
 // Lookup finds a symbol by name in this scope or any parent scope.
 //
 // RETURNS:
@@ -188,7 +206,7 @@ func (s *Scope) Define(symbol *Symbol) error {
 // - Matches how developers think ("looking up a symbol = using it")
 func (s *Scope) Lookup(name string) *Symbol {
 	// Check this scope first
-	if symbol, ok := s.Symbols[name]; ok {
+	if symbol, ok := s.symbolMap[name]; ok {
 		symbol.MarkUsed()
 		return symbol
 	}
@@ -202,6 +220,20 @@ func (s *Scope) Lookup(name string) *Symbol {
 	return nil
 }
 
+// Resolve finds a symbol by name like Lookup, but doesn't mark it used.
+//
+// It is for code that reads a finished analysis, such as the IR builder:
+// whether a symbol is used was settled by the analysis, and not writing to
+// the symbol lets several goroutines resolve names at once.
+func (s *Scope) Resolve(name string) *Symbol {
+	for scope := s; scope != nil; scope = scope.Parent {
+		if symbol, ok := scope.symbolMap[name]; ok {
+			return symbol
+		}
+	}
+	return nil
+}
+
 // LookupLocal finds a symbol by name only in this scope (not parent scopes).
 //
 // This is useful for:
@@ -209,7 +241,43 @@ func (s *Scope) Lookup(name string) *Symbol {
 // - Finding parameters in a function signature
 // - Looking up fields in a struct (without checking outer scopes)
 func (s *Scope) LookupLocal(name string) *Symbol {
-	return s.Symbols[name]
+	return s.symbolMap[name]
+}
+
+// Depth returns the nesting depth: 0 for the outermost scope, 1 for a scope
+// directly inside it, and so on.
+//
+// DESIGN CHOICE: Count the parents on each call rather than store the depth
+// when the scope is made. A stored depth is right only as long as Parent
+// never changes, and counting is cheap: scopes nest a handful deep, and the
+// depth is wanted for debug output and heuristics, not on every lookup.
+func (s *Scope) Depth() int {
+	depth := 0
+	for scope := s.Parent; scope != nil; scope = scope.Parent {
+		depth++
+	}
+	return depth
+}
+
+// Walk visits this scope and every scope nested inside it, depth first and
+// children in the order they were made. pre is called on entering a scope,
+// before any of its children, and post on leaving it, after all of them;
+// either may be nil.
+//
+// This is useful for:
+// - Passes that handle a scope before what it encloses (renaming, in pre)
+// - Passes that gather up from the innermost scopes (escape analysis, in post)
+// - Passes that need both, keeping a stack pushed in pre and popped in post
+func (s *Scope) Walk(pre, post func(*Scope)) {
+	if pre != nil {
+		pre(s)
+	}
+	for _, child := range s.Children {
+		child.Walk(pre, post)
+	}
+	if post != nil {
+		post(s)
+	}
 }
 
 // IsGlobal returns true if this is the global scope.
@@ -280,7 +348,8 @@ func (s *Scope) FindEnclosingLoopOrSwitch() *Scope {
 }
 
 // AllSymbols returns all symbols in this scope and all parent scopes.
-// The symbols are returned in order from innermost to outermost scope.
+// The symbols are returned in order from innermost to outermost scope, and
+// in declaration order within each scope.
 //
 // This is useful for:
 // - Debugging (showing all visible names)
@@ -290,9 +359,7 @@ func (s *Scope) AllSymbols() []*Symbol {
 	symbols := make([]*Symbol, 0)
 
 	// Add symbols from this scope
-	for _, symbol := range s.Symbols {
-		symbols = append(symbols, symbol)
-	}
+	symbols = append(symbols, s.symbolOrder...)
 
 	// Add symbols from parent scopes
 	if s.Parent != nil {
@@ -302,16 +369,23 @@ func (s *Scope) AllSymbols() []*Symbol {
 	return symbols
 }
 
-// LocalSymbols returns all symbols declared in this scope only.
+// LocalSymbols returns all symbols declared in this scope only, in
+// declaration order. The slice is a copy, which the caller may modify.
 func (s *Scope) LocalSymbols() []*Symbol {
-	symbols := make([]*Symbol, 0, len(s.Symbols))
-	for _, symbol := range s.Symbols {
-		symbols = append(symbols, symbol)
-	}
+	symbols := make([]*Symbol, len(s.symbolOrder))
+	copy(symbols, s.symbolOrder)
 	return symbols
 }
 
-// UnusedSymbols returns all symbols in this scope that were never used.
+// SymbolsInOrder returns the symbols declared in this scope in the order
+// they were defined. The slice belongs to the scope and must not be
+// modified; LocalSymbols returns a copy.
+func (s *Scope) SymbolsInOrder() []*Symbol {
+	return s.symbolOrder
+}
+
+// UnusedSymbols returns all symbols in this scope that were never used, in
+// declaration order.
 //
 // This is useful for:
 // - Warning about unused variables
@@ -323,7 +397,7 @@ func (s *Scope) LocalSymbols() []*Symbol {
 // - We only want to warn about variables we declared
 func (s *Scope) UnusedSymbols() []*Symbol {
 	unused := make([]*Symbol, 0)
-	for _, symbol := range s.Symbols {
+	for _, symbol := range s.symbolOrder {
 		if !symbol.Used {
 			unused = append(unused, symbol)
 		}
@@ -335,7 +409,7 @@ func (s *Scope) UnusedSymbols() []*Symbol {
 // Shows the scope kind, depth, and number of symbols.
 func (s *Scope) String() string {
 	return fmt.Sprintf("%s scope (depth %d, %d symbols)",
-		s.Kind.String(), s.Depth, len(s.Symbols))
+		s.Kind.String(), s.Depth(), len(s.symbolOrder))
 }
 
 // DebugString returns a detailed representation of the scope tree.
@@ -362,8 +436,8 @@ func (s *Scope) debugStringIndent(indent int) string {
 
 	result := prefix + s.String() + "\n"
 
-	// Print symbols
-	for _, symbol := range s.Symbols {
+	// Print symbols, in the order they were declared
+	for _, symbol := range s.symbolOrder {
 		result += prefix + "  " + symbol.String() + "\n"
 	}
 
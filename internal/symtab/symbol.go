@@ -53,6 +53,12 @@ const (
 
 	// SymbolPackage represents an imported package
 	SymbolPackage
+
+	// SymbolBuiltin represents a predeclared function (print, println)
+	// Builtins are checked by the analyzer itself rather than through a
+	// function type, because their signatures can't be written in the
+	// language (print accepts any printable type)
+	SymbolBuiltin
 )
 
 // String returns a human-readable representation of the symbol kind.
@@ -72,6 +78,8 @@ func (sk SymbolKind) String() string {
 		return "field"
 	case SymbolPackage:
 		return "package"
+	case SymbolBuiltin:
+		return "builtin"
 	default:
 		return "unknown"
 	}
@@ -112,6 +120,17 @@ type Symbol struct {
 	// Constants can't be reassigned and may be optimized differently
 	Constant bool
 
+	// Escapes marks a local variable or parameter whose address may be used
+	// after the call it belongs to returns (see semantic/escape.go). The
+	// IR builder allocates it on the heap rather than the stack.
+	Escapes bool
+
+	// References are the positions of the names referring to this symbol,
+	// in the order the analyzer met them. The declaration itself isn't one.
+	// Together with Pos this answers an editor's "go to definition" and
+	// "find references".
+	References []lexer.Position
+
 	// Used tracks if this symbol has been referenced
 	// This is useful for:
 	// - Warning about unused variables
@@ -130,6 +149,13 @@ type Symbol struct {
 	// - Symbol table is the natural place for name -> symbol mappings
 	Fields map[string]*Symbol
 
+	// Members is the global scope of an imported package (only for
+	// SymbolPackage). It is nil when the import could not be resolved.
+	// ImportPath is the path that was imported, which qualifies the
+	// package's names in the IR ("geometry.Area").
+	Members    *Scope
+	ImportPath string
+
 	// Index is the index of this symbol in its scope
 	// Used for:
 	// - Stack frame offsets (local variables)
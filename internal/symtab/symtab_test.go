@@ -1,6 +1,7 @@
 package symtab
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/hassan/compiler/internal/lexer"
@@ -147,8 +148,8 @@ func TestNewScope(t *testing.T) {
 		t.Error("Expected child scope to have correct parent")
 	}
 
-	if child.Depth != 1 {
-		t.Errorf("Expected child depth = 1, got %d", child.Depth)
+	if child.Depth() != 1 {
+		t.Errorf("Expected child depth = 1, got %d", child.Depth())
 	}
 
 	if len(parent.Children) != 1 || parent.Children[0] != child {
@@ -225,6 +226,34 @@ func TestScope_Lookup(t *testing.T) {
 	}
 }
 
+func TestScope_Resolve(t *testing.T) {
+	global := NewScope(ScopeGlobal, nil)
+	local := NewScope(ScopeBlock, global)
+
+	globalSymbol := &Symbol{Name: "x", Type: types.Int}
+	shadowed := &Symbol{Name: "y", Type: types.Int}
+	localSymbol := &Symbol{Name: "y", Type: types.Float}
+
+	global.Define(globalSymbol)
+	global.Define(shadowed)
+	local.Define(localSymbol)
+
+	if found := local.Resolve("x"); found != globalSymbol {
+		t.Errorf("Expected the global 'x', got %v", found)
+	}
+	if found := local.Resolve("y"); found != localSymbol {
+		t.Errorf("Expected the local 'y', got %v", found)
+	}
+	if found := local.Resolve("z"); found != nil {
+		t.Errorf("Expected nil for non-existent symbol 'z', got %v", found)
+	}
+
+	// Unlike Lookup, Resolve leaves symbols as it finds them
+	if globalSymbol.Used || localSymbol.Used {
+		t.Error("Expected Resolve not to mark symbols as used")
+	}
+}
+
 func TestScope_LookupLocal(t *testing.T) {
 	global := NewScope(ScopeGlobal, nil)
 	local := NewScope(ScopeBlock, global)
@@ -303,6 +332,130 @@ func TestScope_UnusedSymbols(t *testing.T) {
 	}
 }
 
+func TestScope_SymbolsInOrder(t *testing.T) {
+	scope := NewScope(ScopeFunction, nil)
+
+	// Enough names that a map would be unlikely to range over them in order
+	names := []string{"zeta", "alpha", "mu", "beta", "omega", "kappa", "delta", "epsilon", "iota", "gamma"}
+	for i, name := range names {
+		scope.Define(&Symbol{Name: name, Type: types.Int, Used: i%2 == 0})
+	}
+
+	for run := 0; run < 5; run++ {
+		for i, symbol := range scope.SymbolsInOrder() {
+			if symbol.Name != names[i] || symbol.Index != i {
+				t.Fatalf("symbol %d: expected %s, got %s (index %d)", i, names[i], symbol.Name, symbol.Index)
+			}
+		}
+		for i, symbol := range scope.LocalSymbols() {
+			if symbol.Name != names[i] {
+				t.Fatalf("local symbol %d: expected %s, got %s", i, names[i], symbol.Name)
+			}
+		}
+		for i, symbol := range scope.UnusedSymbols() {
+			if want := names[2*i+1]; symbol.Name != want {
+				t.Fatalf("unused symbol %d: expected %s, got %s", i, want, symbol.Name)
+			}
+		}
+	}
+
+	// A redeclaration changes neither the order nor the symbol
+	if err := scope.Define(&Symbol{Name: "mu", Type: types.Float}); err == nil {
+		t.Error("expected an error redeclaring mu")
+	}
+	if got := scope.SymbolsInOrder(); len(got) != len(names) || got[2].Type != types.Int {
+		t.Errorf("expected the first mu to stay, got %v", got)
+	}
+
+	// The debug dump lists the symbols as they were declared too
+	dump := scope.DebugString()
+	last := -1
+	for _, name := range names {
+		i := strings.Index(dump, " "+name+":")
+		if i <= last {
+			t.Errorf("expected %s after the symbols declared before it in:\n%s", name, dump)
+		}
+		last = i
+	}
+}
+
+func TestScope_Truncate(t *testing.T) {
+	scope := NewScope(ScopeGlobal, nil)
+	for _, name := range []string{"x", "f", "Point"} {
+		scope.Define(&Symbol{Name: name, Type: types.Int})
+	}
+
+	scope.Truncate(1)
+	if scope.LookupLocal("f") != nil || scope.LookupLocal("Point") != nil {
+		t.Errorf("expected f and Point to be removed")
+	}
+	if scope.LookupLocal("x") == nil || len(scope.SymbolsInOrder()) != 1 {
+		t.Errorf("expected x to remain, got %v", scope.SymbolsInOrder())
+	}
+
+	// A removed name can be declared again, and gets the next index
+	if err := scope.Define(&Symbol{Name: "f", Type: types.Bool}); err != nil {
+		t.Fatalf("redefining f: %v", err)
+	}
+	if f := scope.LookupLocal("f"); f.Type != types.Bool || f.Index != 1 {
+		t.Errorf("expected the new f at index 1, got %v at %d", f.Type, f.Index)
+	}
+
+	// Truncating to the current size or beyond changes nothing
+	scope.Truncate(2)
+	scope.Truncate(5)
+	if len(scope.SymbolsInOrder()) != 2 {
+		t.Errorf("expected 2 symbols, got %d", len(scope.SymbolsInOrder()))
+	}
+}
+
+func TestScope_Walk(t *testing.T) {
+	// global
+	//   f (function)
+	//     if (block)
+	//     while (loop)
+	//   g (function)
+	//     switch
+	global := NewScope(ScopeGlobal, nil)
+	f := NewScope(ScopeFunction, global)
+	ifBlock := NewScope(ScopeBlock, f)
+	loop := NewScope(ScopeLoop, f)
+	g := NewScope(ScopeFunction, global)
+	sw := NewScope(ScopeSwitch, g)
+	names := map[*Scope]string{global: "global", f: "f", ifBlock: "if", loop: "while", g: "g", sw: "switch"}
+
+	var visits []string
+	global.Walk(
+		func(s *Scope) { visits = append(visits, "pre "+names[s]) },
+		func(s *Scope) { visits = append(visits, "post "+names[s]) },
+	)
+	want := []string{
+		"pre global",
+		"pre f", "pre if", "post if", "pre while", "post while", "post f",
+		"pre g", "pre switch", "post switch", "post g",
+		"post global",
+	}
+	if strings.Join(visits, ", ") != strings.Join(want, ", ") {
+		t.Errorf("expected visits\n  %s\ngot\n  %s", strings.Join(want, ", "), strings.Join(visits, ", "))
+	}
+
+	// A walk from inside the tree stays inside it, and either function
+	// may be nil
+	visits = nil
+	f.Walk(nil, func(s *Scope) { visits = append(visits, names[s]) })
+	if got := strings.Join(visits, ", "); got != "if, while, f" {
+		t.Errorf("expected post-order visits if, while, f, got %s", got)
+	}
+	g.Walk(nil, nil)
+
+	depths := map[*Scope]int{global: 0, f: 1, ifBlock: 2, loop: 2, g: 1, sw: 2}
+	for scope, want := range depths {
+		if got := scope.Depth(); got != want {
+			t.Errorf("%s: expected depth %d, got %d", names[scope], want, got)
+		}
+	}
+}
+
 func TestSymbolKind_String(t *testing.T) {
 	tests := []struct {
 		kind     SymbolKind
@@ -315,6 +468,7 @@ func TestSymbolKind_String(t *testing.T) {
 		{SymbolStruct, "struct"},
 		{SymbolField, "field"},
 		{SymbolPackage, "package"},
+		{SymbolBuiltin, "builtin"},
 	}
 
 	for _, tt := range tests {
@@ -338,6 +492,7 @@ func TestScopeKind_String(t *testing.T) {
 		{ScopeLoop, "loop"},
 		{ScopeSwitch, "switch"},
 		{ScopeStruct, "struct"},
+		{ScopeUniverse, "universe"},
 	}
 
 	for _, tt := range tests {
@@ -0,0 +1,74 @@
+// Package testutil holds helpers shared by the compiler's tests.
+//
+// GOLDEN FILES:
+// Much of what the compiler produces - token streams, syntax trees, IR -
+// is too long to spell out in a test, and what matters is less that it is
+// right in every detail than that it doesn't change without anyone
+// noticing. A golden file holds the output as it was last accepted; the
+// test compares against it, and a change shows up as a failing test with
+// both versions side by side. When the change is wanted, rerun with
+//
+//	UPDATE_GOLDEN=1 go test ./...
+//
+// and review the rewritten files in the diff like any other change.
+//
+// DESIGN CHOICE: An environment variable rather than a -update flag, as
+// some of the older tests use. A flag must be defined by every test
+// binary that is passed it, so `go test ./... -update` fails in packages
+// without one; a variable reaches every package, and the ones that don't
+// look at it are unaffected.
+package testutil
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// UpdateEnv is the environment variable that, set to 1, makes CheckGolden
+// rewrite golden files instead of comparing with them.
+const UpdateEnv = "UPDATE_GOLDEN"
+
+// GoldenPath returns the file the golden output called name is kept in:
+// testdata/golden/name.txt, relative to the package under test (the
+// directory go test runs it in). name may contain slashes, to group the
+// files of one fixture in a directory.
+func GoldenPath(name string) string {
+	return filepath.Join("testdata", "golden", filepath.FromSlash(name)+".txt")
+}
+
+// UpdateGolden writes actual as the golden output called name, creating
+// the directories it goes in.
+func UpdateGolden(t *testing.T, name, actual string) {
+	t.Helper()
+	path := GoldenPath(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(actual), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// CheckGolden reports an error if actual differs from the golden output
+// called name. With UPDATE_GOLDEN=1 in the environment it writes actual
+// as the golden output instead, and so always passes.
+//
+// A missing golden file is an error, not an empty expectation: a test
+// that has never been accepted should fail until it is.
+func CheckGolden(t *testing.T, name, actual string) {
+	t.Helper()
+	if os.Getenv(UpdateEnv) == "1" {
+		UpdateGolden(t, name, actual)
+		return
+	}
+
+	path := GoldenPath(name)
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run with %s=1 to create it)", err, UpdateEnv)
+	}
+	if actual != string(want) {
+		t.Errorf("output differs from %s (run with %s=1 to accept):\ngot:\n%s\nwant:\n%s", path, UpdateEnv, actual, want)
+	}
+}
@@ -0,0 +1,59 @@
+package testutil
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGoldenPath(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"tokens", filepath.Join("testdata", "golden", "tokens.txt")},
+		{"fib/ir-optimized", filepath.Join("testdata", "golden", "fib", "ir-optimized.txt")},
+	}
+	for _, tt := range tests {
+		if got := GoldenPath(tt.name); got != tt.want {
+			t.Errorf("GoldenPath(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCheckGolden_Update(t *testing.T) {
+	// Golden paths are relative to the working directory, so work in an
+	// empty one rather than this package's testdata
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	t.Setenv(UpdateEnv, "1")
+	CheckGolden(t, "program/ast", "first\n")
+	got, err := os.ReadFile(GoldenPath("program/ast"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "first\n" {
+		t.Errorf("expected the update to write %q, got %q", "first\n", got)
+	}
+
+	// Once written, the same output passes without updating
+	t.Setenv(UpdateEnv, "")
+	CheckGolden(t, "program/ast", "first\n")
+
+	// and an update replaces the file rather than adding to it
+	UpdateGolden(t, "program/ast", "second\n")
+	got, err = os.ReadFile(GoldenPath("program/ast"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "second\n" {
+		t.Errorf("expected %q after UpdateGolden, got %q", "second\n", got)
+	}
+}
@@ -0,0 +1,528 @@
+package vm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/hassan/compiler/internal/ir"
+	"github.com/hassan/compiler/internal/semantic/types"
+)
+
+// maxIndex is the largest constant, slot, global or function index a 16-bit
+// operand holds.
+const maxIndex = math.MaxUint16
+
+// Compiler lowers an IR module to a Program.
+//
+// TRANSLATION:
+//   - Every IR value a function uses gets a local slot, its parameters the
+//     first ones; module globals get global slots
+//   - Blocks are laid out in function order, so a jump to the next block is
+//     left out
+//   - A phi becomes copies on the edges into its block: the incoming values
+//     are pushed and then stored, last first, so every phi reads the values
+//     from before the edge, as the IR defines
+//   - A branch is "jmpif" to its true block and a jump to its false block,
+//     each edge with its phi copies
+//   - Every function returns a value; a void function returns 0, which a
+//     call without a destination pops
+//
+// DESIGN CHOICE: Scalars and strings only. Arrays, structs and pointers live
+// in memory that the IR addresses with Alloca, Load and Store; a VM for them
+// needs a heap of cells that a stack word can point into, and until it has
+// one, any such construct is reported as "not supported by the VM" rather
+// than compiled to bytecode that does the wrong thing.
+type Compiler struct {
+	prog   *Program
+	errors []error
+
+	// constants finds a value already in the pool, so each is stored once
+	constants map[interface{}]int
+
+	// globals and functions map module-level names to their indexes
+	globals   map[*ir.Value]int
+	functions map[string]int
+
+	// Per-function state
+
+	fn    *ir.Function
+	slots map[*ir.Value]int
+
+	// starts holds the offset of each block's code; fixups the jump
+	// operands waiting for the offset of their target
+	starts map[*ir.BasicBlock]int
+	fixups []fixup
+}
+
+// fixup is a jump whose 32-bit operand, at offset in Code, is the start of
+// target.
+type fixup struct {
+	offset int
+	target *ir.BasicBlock
+}
+
+// NewCompiler creates a compiler.
+func NewCompiler() *Compiler {
+	return &Compiler{}
+}
+
+// Compile lowers module to a program.
+//
+// The returned errors name each construct that could not be compiled; the
+// program is only usable when there are none.
+func (c *Compiler) Compile(module *ir.Module) (*Program, []error) {
+	c.prog = &Program{}
+	c.errors = nil
+	c.constants = make(map[interface{}]int)
+	c.globals = make(map[*ir.Value]int)
+	c.functions = make(map[string]int)
+
+	for i, global := range module.Globals {
+		c.kind(global.Type, "global "+global.Name)
+		c.globals[global] = i
+		init := -1
+		if global.Initializer != nil {
+			init = c.constant(c.stackConstant(global.Initializer))
+		}
+		c.prog.Initializers = append(c.prog.Initializers, init)
+	}
+	c.prog.Globals = len(module.Globals)
+	if len(module.Globals) > maxIndex+1 {
+		c.errorf("more than %d globals", maxIndex+1)
+	}
+
+	// Functions are numbered before any is compiled, so that a call can
+	// name one defined later
+	for i, fn := range module.Functions {
+		c.functions[fn.Name] = i
+		c.prog.Functions = append(c.prog.Functions, Function{Name: fn.Name})
+	}
+	if len(module.Functions) > maxIndex+1 {
+		c.errorf("more than %d functions", maxIndex+1)
+	}
+	for i, fn := range module.Functions {
+		c.function(fn, &c.prog.Functions[i])
+	}
+
+	return c.prog, c.errors
+}
+
+// errorf records a construct the compiler can't translate.
+func (c *Compiler) errorf(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	if c.fn != nil {
+		msg = fmt.Sprintf("in function %s: %s", c.fn.Name, msg)
+	}
+	c.errors = append(c.errors, fmt.Errorf("%s", msg))
+}
+
+// unsupported records a construct the VM has no operations for.
+func (c *Compiler) unsupported(format string, args ...interface{}) {
+	c.errorf("%s not supported by the VM", fmt.Sprintf(format, args...))
+}
+
+// kind returns the Kind of values of type t. what describes the value for
+// the error message.
+func (c *Compiler) kind(t types.Type, what string) Kind {
+	switch typ := types.Underlying(t).(type) {
+	case *types.IntType:
+		if typ.Unsigned && typ.Bits == 64 {
+			return KindUint
+		}
+		return KindInt
+	case *types.FloatType:
+		return KindFloat
+	case *types.BoolType:
+		return KindBool
+	case *types.CharType:
+		return KindChar
+	case *types.StringType:
+		return KindString
+	case *types.VoidType:
+		return KindVoid
+	default:
+		c.unsupported("%s of type %s is", what, t)
+		return KindInt
+	}
+}
+
+// function compiles fn, filling in its entry in the function table.
+func (c *Compiler) function(fn *ir.Function, info *Function) {
+	c.fn = fn
+	c.slots = make(map[*ir.Value]int)
+	c.starts = make(map[*ir.BasicBlock]int)
+	c.fixups = nil
+	defer func() { c.fn = nil }()
+
+	info.Entry = len(c.prog.Code)
+	for _, param := range fn.Parameters {
+		info.Params = append(info.Params, c.kind(param.Type, "parameter "+param.Name))
+		c.slot(param)
+	}
+	info.Result = c.kind(fn.ReturnType, "result")
+
+	for i, block := range fn.Blocks {
+		c.starts[block] = len(c.prog.Code)
+		var next *ir.BasicBlock
+		if i+1 < len(fn.Blocks) {
+			next = fn.Blocks[i+1]
+		}
+		for _, instr := range block.Instructions {
+			c.instruction(block, instr, next)
+		}
+		if block.Terminator() == nil {
+			c.errorf("block %s has no terminator", block.Label)
+		}
+	}
+
+	for _, f := range c.fixups {
+		binary.LittleEndian.PutUint32(c.prog.Code[f.offset:], uint32(c.starts[f.target]))
+	}
+	info.Locals = len(c.slots)
+	if len(c.slots) > maxIndex+1 {
+		c.errorf("more than %d local values", maxIndex+1)
+	}
+}
+
+// emit appends an instruction without an operand.
+func (c *Compiler) emit(op Opcode) {
+	c.prog.Code = append(c.prog.Code, byte(op))
+}
+
+// emitIndex appends an instruction with a 16-bit operand.
+func (c *Compiler) emitIndex(op Opcode, index int) {
+	c.prog.Code = append(c.prog.Code, byte(op), 0, 0)
+	binary.LittleEndian.PutUint16(c.prog.Code[len(c.prog.Code)-2:], uint16(index))
+}
+
+// emitPrint appends a print of a value of the given kind.
+func (c *Compiler) emitPrint(kind Kind) {
+	c.prog.Code = append(c.prog.Code, byte(OpPrint), byte(kind))
+}
+
+// emitJump appends a jump to target, whose offset is filled in once the
+// whole function is laid out.
+func (c *Compiler) emitJump(op Opcode, target *ir.BasicBlock) {
+	c.prog.Code = append(c.prog.Code, byte(op), 0, 0, 0, 0)
+	c.fixups = append(c.fixups, fixup{offset: len(c.prog.Code) - 4, target: target})
+}
+
+// slot returns the local slot of v, giving it the next one if it has none
+// yet. A variable read before any assignment then reads its slot's zero.
+func (c *Compiler) slot(v *ir.Value) int {
+	n, ok := c.slots[v]
+	if !ok {
+		n = len(c.slots)
+		c.slots[v] = n
+	}
+	return n
+}
+
+// constant returns the pool index of a constant, adding it if it is new.
+func (c *Compiler) constant(value interface{}) int {
+	if n, ok := c.constants[value]; ok {
+		return n
+	}
+	n := len(c.prog.Constants)
+	if n > maxIndex {
+		c.errorf("more than %d constants", maxIndex+1)
+		return 0
+	}
+	c.constants[value] = n
+	c.prog.Constants = append(c.prog.Constants, value)
+	return n
+}
+
+// pushInt pushes an int constant.
+func (c *Compiler) pushInt(n int64) {
+	c.emitIndex(OpPush, c.constant(n))
+}
+
+// push emits the instruction that puts a value on the stack.
+func (c *Compiler) push(v *ir.Value) {
+	switch {
+	case v.IsConstant():
+		c.pushConstant(v)
+	case v.ID == -1 && v.Kind == ir.ValueVariable:
+		c.unsupported("function value %s is", v.Name)
+	default:
+		if n, ok := c.globals[v]; ok {
+			c.emitIndex(OpLoadGlobal, n)
+			return
+		}
+		c.emitIndex(OpLoad, c.slot(v))
+	}
+}
+
+// pushConstant pushes a constant operand, in its stack representation.
+func (c *Compiler) pushConstant(v *ir.Value) {
+	c.emitIndex(OpPush, c.constant(c.stackConstant(v)))
+}
+
+// stackConstant returns the value of the constant v as the constant pool
+// holds it: an int64, or a string.
+func (c *Compiler) stackConstant(v *ir.Value) interface{} {
+	switch k := v.Constant.(type) {
+	case int64:
+		return k
+	case float64:
+		return int64(math.Float64bits(k))
+	case bool:
+		if k {
+			return int64(1)
+		}
+		return int64(0)
+	case rune:
+		return int64(k)
+	case string:
+		return k
+	default:
+		c.unsupported("constant %v of type %s is", v.Constant, v.Type)
+		return int64(0)
+	}
+}
+
+// pop emits the instruction that stores the top of the stack in a value.
+func (c *Compiler) pop(v *ir.Value) {
+	if n, ok := c.globals[v]; ok {
+		c.emitIndex(OpStoreGlobal, n)
+		return
+	}
+	c.emitIndex(OpStore, c.slot(v))
+}
+
+// instruction compiles one instruction of block. next is the block laid
+// out after it, which needs no jump to reach.
+func (c *Compiler) instruction(block *ir.BasicBlock, instr ir.Instruction, next *ir.BasicBlock) {
+	switch i := instr.(type) {
+	case *ir.BinaryOp:
+		c.push(i.Left)
+		c.push(i.Right)
+		c.binary(i)
+		c.pop(i.Dest)
+
+	case *ir.UnaryOp:
+		c.push(i.Operand)
+		c.unary(i)
+		c.pop(i.Dest)
+
+	case *ir.Copy:
+		c.push(i.Value)
+		c.pop(i.Dest)
+
+	case *ir.Call:
+		c.call(i)
+
+	case *ir.Phi:
+		// Assigned on the edges into the block (see edge)
+
+	case *ir.Jump:
+		c.edge(block, i.Target, next)
+
+	case *ir.Branch:
+		c.push(i.Condition)
+		if !hasPhis(i.TrueBlock) {
+			c.emitJump(OpJmpIf, i.TrueBlock)
+			c.edge(block, i.FalseBlock, next)
+			return
+		}
+		// The true edge has copies to make on the way: jump past the false
+		// edge to them
+		c.prog.Code = append(c.prog.Code, byte(OpJmpIf), 0, 0, 0, 0)
+		operand := len(c.prog.Code) - 4
+		c.edge(block, i.FalseBlock, nil)
+		binary.LittleEndian.PutUint32(c.prog.Code[operand:], uint32(len(c.prog.Code)))
+		c.edge(block, i.TrueBlock, next)
+
+	case *ir.Return:
+		if i.Value != nil {
+			c.push(i.Value)
+		} else {
+			c.pushInt(0)
+		}
+		c.emit(OpReturn)
+
+	case *ir.Alloca, *ir.Load, *ir.Store, *ir.GetElementPtr, *ir.GetFieldPtr:
+		c.unsupported("memory access (%s) is", instr)
+	default:
+		c.unsupported("instruction %s is", instr)
+	}
+}
+
+// hasPhis reports whether block starts with phis, which the edges into it
+// assign.
+func hasPhis(block *ir.BasicBlock) bool {
+	if len(block.Instructions) == 0 {
+		return false
+	}
+	_, ok := block.Instructions[0].(*ir.Phi)
+	return ok
+}
+
+// edge emits the transfer of control from one block to another: the copies
+// into the target's phis, then a jump unless the target is next.
+func (c *Compiler) edge(from, to, next *ir.BasicBlock) {
+	var dests []*ir.Value
+	for _, instr := range to.Instructions {
+		phi, ok := instr.(*ir.Phi)
+		if !ok {
+			break
+		}
+		found := false
+		for _, inc := range phi.Incomig {
+			if inc.Block == from {
+				c.push(inc.Value)
+				dests = append(dests, phi.Dest)
+				found = true
+				break
+			}
+		}
+		if !found {
+			c.errorf("phi %s has no incoming value from %s", phi.Dest, from.Label)
+		}
+	}
+	for i := len(dests) - 1; i >= 0; i-- {
+		c.pop(dests[i])
+	}
+	if to != next {
+		c.emitJump(OpJmp, to)
+	}
+}
+
+// intOps, uintOps, floatOps, stringOps, charOps and boolOps are the opcodes of the
+// binary operators, by operand type. uintOps replace intOps for uint64
+// operands where the sign bit matters.
+var intOps = map[ir.BinaryOperator]Opcode{
+	ir.OpAdd: OpAdd, ir.OpSub: OpSub, ir.OpMul: OpMul, ir.OpDiv: OpDiv, ir.OpMod: OpMod,
+	ir.OpBitAnd: OpAnd, ir.OpBitOr: OpOr, ir.OpBitXor: OpXor, ir.OpShl: OpShl, ir.OpShr: OpShr,
+	ir.OpEq: OpEq, ir.OpNeq: OpNe, ir.OpLt: OpLt, ir.OpLe: OpLe, ir.OpGt: OpGt, ir.OpGe: OpGe,
+}
+
+var uintOps = map[ir.BinaryOperator]Opcode{
+	ir.OpDiv: OpDivU, ir.OpMod: OpModU, ir.OpShr: OpShrU,
+	ir.OpLt: OpLtU, ir.OpLe: OpLeU, ir.OpGt: OpGtU, ir.OpGe: OpGeU,
+}
+
+var floatOps = map[ir.BinaryOperator]Opcode{
+	ir.OpAdd: OpFAdd, ir.OpSub: OpFSub, ir.OpMul: OpFMul, ir.OpDiv: OpFDiv,
+	ir.OpEq: OpFEq, ir.OpNeq: OpFNe, ir.OpLt: OpFLt, ir.OpLe: OpFLe, ir.OpGt: OpFGt, ir.OpGe: OpFGe,
+}
+
+var stringOps = map[ir.BinaryOperator]Opcode{
+	ir.OpEq: OpSEq, ir.OpNeq: OpSNe, ir.OpLt: OpSLt, ir.OpLe: OpSLe, ir.OpGt: OpSGt, ir.OpGe: OpSGe,
+}
+
+var charOps = map[ir.BinaryOperator]Opcode{
+	ir.OpEq: OpEq, ir.OpNeq: OpNe, ir.OpLt: OpLt, ir.OpLe: OpLe, ir.OpGt: OpGt, ir.OpGe: OpGe,
+}
+
+var boolOps = map[ir.BinaryOperator]Opcode{
+	ir.OpAnd: OpAnd, ir.OpOr: OpOr, ir.OpEq: OpEq, ir.OpNeq: OpNe,
+}
+
+// binary emits the operation of a binary operator, its operands already on
+// the stack.
+func (c *Compiler) binary(b *ir.BinaryOp) {
+	var ops map[ir.BinaryOperator]Opcode
+	switch c.kind(b.Left.Type, "operand") {
+	case KindUint:
+		if op, ok := uintOps[b.Op]; ok {
+			c.emit(op)
+			return
+		}
+		ops = intOps
+	case KindInt:
+		ops = intOps
+	case KindFloat:
+		ops = floatOps
+	case KindString:
+		ops = stringOps
+	case KindBool:
+		ops = boolOps
+	case KindChar:
+		// Chars are only compared, as their code points
+		ops = charOps
+	}
+	if op, ok := ops[b.Op]; ok {
+		c.emit(op)
+		return
+	}
+	c.unsupported("operator %s on %s is", b.Op, b.Left.Type)
+}
+
+// unary emits the operation of a unary operator, its operand already on
+// the stack.
+func (c *Compiler) unary(u *ir.UnaryOp) {
+	kind := c.kind(u.Operand.Type, "operand")
+	switch {
+	case u.Op == ir.OpNeg && (kind == KindInt || kind == KindUint):
+		c.emit(OpNeg)
+	case u.Op == ir.OpNeg && kind == KindFloat:
+		c.emit(OpFNeg)
+	case u.Op == ir.OpBitNot && (kind == KindInt || kind == KindUint):
+		c.emit(OpBitNot)
+	case u.Op == ir.OpNot && kind == KindBool:
+		c.emit(OpNot)
+	default:
+		c.unsupported("operator %s on %s is", u.Op, u.Operand.Type)
+	}
+}
+
+// call compiles a call to a function of the module or to a builtin.
+func (c *Compiler) call(call *ir.Call) {
+	if name := call.Builtin(); name != "" {
+		c.builtin(call, name)
+		return
+	}
+	n, ok := c.functions[call.Function.Name]
+	if !ok {
+		c.errorf("call to undefined function %s", call.Function.Name)
+		return
+	}
+	for _, arg := range call.Args {
+		c.push(arg)
+	}
+	c.emitIndex(OpCall, n)
+	if call.Dest != nil {
+		c.pop(call.Dest)
+	} else {
+		c.emit(OpPop)
+	}
+}
+
+// builtin compiles a call to a builtin: print and println, and len of a
+// string. The rest work on arrays and pointers.
+func (c *Compiler) builtin(call *ir.Call, name string) {
+	switch name {
+	case "print", "println":
+		for _, arg := range call.Args {
+			c.push(arg)
+			c.emitPrint(c.kind(arg.Type, name+" argument"))
+		}
+		if name == "println" {
+			c.emitIndex(OpPush, c.constant("\n"))
+			c.emitPrint(KindString)
+		}
+	case "len", "cap":
+		if len(call.Args) != 1 || !isString(call.Args[0].Type) {
+			c.unsupported("builtin %s of %s is", name, call.Args)
+			return
+		}
+		c.push(call.Args[0])
+		c.emit(OpLen)
+		if call.Dest != nil {
+			c.pop(call.Dest)
+		} else {
+			c.emit(OpPop)
+		}
+	default:
+		c.unsupported("builtin %s is", name)
+	}
+}
+
+// isString reports whether t is string.
+func isString(t types.Type) bool {
+	_, ok := types.Underlying(t).(*types.StringType)
+	return ok
+}
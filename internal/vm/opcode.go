@@ -0,0 +1,165 @@
+package vm
+
+import "fmt"
+
+// Opcode is the first byte of every instruction.
+//
+// ENCODING:
+// An instruction is its opcode followed by at most one operand, whose width
+// the opcode fixes (see Opcode.OperandWidth):
+//   - a constant, slot, global or function index: 16 bits
+//   - a jump target, the absolute offset of an instruction in Code: 32 bits
+//   - the Kind of the value OpPrint prints: 8 bits
+//
+// Operands are little-endian. Every other operation takes its operands from
+// the stack and pushes its result there: "a - b" is
+//
+//	load a
+//	load b
+//	sub
+//
+// DESIGN CHOICE: One opcode per operation and operand type (OpLt, OpLtU,
+// OpFLt, OpSLt) rather than one OpLt inspecting what it is given. The IR is
+// typed, so the compiler knows which operation each instruction is, and the
+// interpreter never has to check a value's type: a stack slot holds an
+// int64 or a string and nothing says which (see word).
+type Opcode byte
+
+const (
+	// Stack and storage
+	OpPush        Opcode = iota // push Constants[idx]
+	OpPop                       // discard the top of the stack
+	OpLoad                      // push a local slot
+	OpStore                     // pop into a local slot
+	OpLoadGlobal                // push a global
+	OpStoreGlobal               // pop into a global
+
+	// Integer arithmetic; a right shift is arithmetic
+	OpAdd
+	OpSub
+	OpMul
+	OpDiv
+	OpMod
+	OpAnd
+	OpOr
+	OpXor
+	OpShl
+	OpShr
+	OpNeg
+	OpBitNot
+
+	// Integer comparisons, pushing 1 for true and 0 for false
+	OpEq
+	OpNe
+	OpLt
+	OpLe
+	OpGt
+	OpGe
+
+	// The uint64 forms of the operations that depend on the sign bit
+	OpDivU
+	OpModU
+	OpShrU
+	OpLtU
+	OpLeU
+	OpGtU
+	OpGeU
+
+	// Float arithmetic and comparisons, on the bits of a float64
+	OpFAdd
+	OpFSub
+	OpFMul
+	OpFDiv
+	OpFNeg
+	OpFEq
+	OpFNe
+	OpFLt
+	OpFLe
+	OpFGt
+	OpFGe
+
+	// Strings
+	OpLen
+	OpSEq
+	OpSNe
+	OpSLt
+	OpSLe
+	OpSGt
+	OpSGe
+
+	// OpNot is the logical not of a bool: 1 for 0, 0 for 1
+	OpNot
+
+	// Control flow
+	OpJmp    // jump to the target
+	OpJmpIf  // pop, and jump to the target if it is not 0
+	OpCall   // call Functions[idx], its arguments on the stack
+	OpReturn // pop the result and return it to the caller
+
+	// OpPrint pops a value and writes it to Stdout, formatted by its kind
+	OpPrint
+
+	numOpcodes
+)
+
+var opcodeNames = [numOpcodes]string{
+	OpPush: "push", OpPop: "pop", OpLoad: "load", OpStore: "store",
+	OpLoadGlobal: "loadglobal", OpStoreGlobal: "storeglobal",
+	OpAdd: "add", OpSub: "sub", OpMul: "mul", OpDiv: "div", OpMod: "mod",
+	OpAnd: "and", OpOr: "or", OpXor: "xor", OpShl: "shl", OpShr: "shr",
+	OpNeg: "neg", OpBitNot: "bitnot",
+	OpEq: "eq", OpNe: "ne", OpLt: "lt", OpLe: "le", OpGt: "gt", OpGe: "ge",
+	OpDivU: "divu", OpModU: "modu", OpShrU: "shru",
+	OpLtU: "ltu", OpLeU: "leu", OpGtU: "gtu", OpGeU: "geu",
+	OpFAdd: "fadd", OpFSub: "fsub", OpFMul: "fmul", OpFDiv: "fdiv", OpFNeg: "fneg",
+	OpFEq: "feq", OpFNe: "fne", OpFLt: "flt", OpFLe: "fle", OpFGt: "fgt", OpFGe: "fge",
+	OpLen: "len",
+	OpSEq: "seq", OpSNe: "sne", OpSLt: "slt", OpSLe: "sle", OpSGt: "sgt", OpSGe: "sge",
+	OpNot: "not",
+	OpJmp: "jmp", OpJmpIf: "jmpif", OpCall: "call", OpReturn: "return",
+	OpPrint: "print",
+}
+
+func (op Opcode) String() string {
+	if op < numOpcodes {
+		return opcodeNames[op]
+	}
+	return fmt.Sprintf("op(%d)", byte(op))
+}
+
+// OperandWidth returns the number of bytes of operand following op.
+func (op Opcode) OperandWidth() int {
+	switch op {
+	case OpPush, OpLoad, OpStore, OpLoadGlobal, OpStoreGlobal, OpCall:
+		return 2
+	case OpJmp, OpJmpIf:
+		return 4
+	case OpPrint:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// Kind is the type of a value as far as the VM needs to know it: to print
+// it, and to tell which values Run can pass and return.
+type Kind byte
+
+const (
+	KindVoid Kind = iota
+	KindInt
+	KindUint // uint64, printed unsigned
+	KindFloat
+	KindBool
+	KindChar
+	KindString
+)
+
+var kindNames = [...]string{"void", "int", "uint", "float", "bool", "char", "string"}
+
+func (k Kind) String() string {
+	if int(k) < len(kindNames) {
+		return kindNames[k]
+	}
+	return fmt.Sprintf("kind(%d)", byte(k))
+}
@@ -0,0 +1,131 @@
+package vm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// Program is a compiled module: the bytecode of every function, one after
+// another, and the tables its operands index.
+type Program struct {
+	// Code is the bytecode of all the functions.
+	Code []byte
+
+	// Constants is the constant pool OpPush reads: int64 and string values.
+	// A float constant is stored as the int64 with its bits, and a bool or
+	// char as its number, which is what they are on the stack.
+	Constants []interface{}
+
+	// Functions are the module's functions, in module order. OpCall names
+	// one by its index here.
+	Functions []Function
+
+	// Globals is the number of global slots.
+	Globals int
+
+	// Initializers holds, for each global slot, the index in Constants of
+	// the value the global starts with, or -1 if it starts at zero. Slots
+	// past its end start at zero too.
+	Initializers []int
+}
+
+// Function describes one function in Code.
+type Function struct {
+	Name string
+
+	// Entry is the offset of the function's first instruction in Code.
+	Entry int
+
+	// Params are the kinds of the parameters, which are the first local
+	// slots; Result is the kind of the result, KindVoid for none.
+	Params []Kind
+	Result Kind
+
+	// Locals is the number of local slots, parameters included.
+	Locals int
+}
+
+// Function returns the index of the function named name, or -1.
+func (p *Program) Function(name string) int {
+	for i := range p.Functions {
+		if p.Functions[i].Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// operand decodes the operand of the instruction at pc, whose opcode is op.
+func (p *Program) operand(op Opcode, pc int) int {
+	switch op.OperandWidth() {
+	case 1:
+		return int(p.Code[pc+1])
+	case 2:
+		return int(binary.LittleEndian.Uint16(p.Code[pc+1:]))
+	case 4:
+		return int(binary.LittleEndian.Uint32(p.Code[pc+1:]))
+	}
+	return 0
+}
+
+// Instruction returns the instruction at offset pc in assembly form, such
+// as "push #3 (10)" or "jmpif 0042", and the offset of the next one.
+func (p *Program) Instruction(pc int) (string, int) {
+	op := Opcode(p.Code[pc])
+	next := pc + 1 + op.OperandWidth()
+	if next > len(p.Code) {
+		return fmt.Sprintf("%s <truncated>", op), len(p.Code)
+	}
+	n := p.operand(op, pc)
+	switch op {
+	case OpPush:
+		if n < len(p.Constants) {
+			if s, ok := p.Constants[n].(string); ok {
+				return fmt.Sprintf("push #%d (%q)", n, s), next
+			}
+			return fmt.Sprintf("push #%d (%v)", n, p.Constants[n]), next
+		}
+		return fmt.Sprintf("push #%d", n), next
+	case OpLoad, OpStore, OpLoadGlobal, OpStoreGlobal:
+		return fmt.Sprintf("%s %d", op, n), next
+	case OpJmp, OpJmpIf:
+		return fmt.Sprintf("%s %04d", op, n), next
+	case OpCall:
+		if n < len(p.Functions) {
+			return fmt.Sprintf("call %s", p.Functions[n].Name), next
+		}
+		return fmt.Sprintf("call #%d", n), next
+	case OpPrint:
+		return fmt.Sprintf("print %s", Kind(n)), next
+	}
+	return op.String(), next
+}
+
+// String disassembles the program, one function after another:
+//
+//	func factorial(int) int, 3 slots
+//	  0000  load 0
+//	  0003  push #0 (1)
+//	  0006  le
+//	  ...
+func (p *Program) String() string {
+	var sb strings.Builder
+	for i, fn := range p.Functions {
+		end := len(p.Code)
+		if i+1 < len(p.Functions) {
+			end = p.Functions[i+1].Entry
+		}
+		params := make([]string, len(fn.Params))
+		for j, kind := range fn.Params {
+			params[j] = kind.String()
+		}
+		sb.WriteString(fmt.Sprintf("func %s(%s) %s, %d slots\n", fn.Name, strings.Join(params, ", "), fn.Result, fn.Locals))
+		for pc := fn.Entry; pc < end; {
+			text, next := p.Instruction(pc)
+			sb.WriteString(fmt.Sprintf("  %04d  %s\n", pc, text))
+			pc = next
+		}
+	}
+	return sb.String()
+}
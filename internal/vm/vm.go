@@ -0,0 +1,418 @@
+// Package vm compiles IR to bytecode for a stack machine, and runs it.
+//
+// WHY A VM:
+// The IR interpreter (package interp) walks instructions that point at
+// values in maps, which makes it easy to trust but slow; the native backends
+// need a C compiler, an assembler or a wasm runtime to run anything. A
+// bytecode VM sits between them: the program is compiled once to a compact
+// byte slice (see Opcode), and running it is a loop over that slice with no
+// tool outside this package.
+//
+// EXECUTION MODEL:
+//   - One stack of words holds every frame's local slots and, above them,
+//     the operands of the instruction being executed
+//   - A call leaves its arguments where they were pushed, as the callee's
+//     first slots, and zeroes the rest of the callee's slots above them
+//   - A return pops the result, drops the frame and pushes the result for
+//     the caller
+//   - Frames are on an explicit stack bounded by MaxCallDepth, so a deep
+//     recursion costs no Go stack and runaway recursion is a runtime error
+package vm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+)
+
+// DefaultMaxCallDepth bounds recursion unless Interpreter.MaxCallDepth is set.
+const DefaultMaxCallDepth = 10000
+
+// word is a stack slot. A string is held in s; everything else is the int64
+// in n: a float by its bits, a bool as 0 or 1, a char as its code point.
+//
+// DESIGN CHOICE: A two-field struct rather than an interface{}. The
+// opcodes already say which field an operation reads, so there is nothing
+// to switch on at run time, and an int64 in an interface{} would allocate
+// on every arithmetic result.
+type word struct {
+	n int64
+	s string
+}
+
+// Interpreter executes programs.
+type Interpreter struct {
+	// Stdout receives anything the program prints.
+	Stdout io.Writer
+
+	// MaxCallDepth is the deepest the call stack may grow.
+	MaxCallDepth int
+
+	prog      *Program
+	constants []word
+	globals   []word
+	stack     []word
+	frames    []frame
+}
+
+// frame is a caller's state, saved while its callee runs.
+type frame struct {
+	fn       int
+	returnPC int
+	base     int
+}
+
+// RuntimeError is a failure while executing the program, such as a division
+// by zero. It records where execution was when it happened.
+type RuntimeError struct {
+	Message  string
+	Function string
+
+	// Offset is the offset of the failing instruction in Code, and
+	// Instruction its assembly form.
+	Offset      int
+	Instruction string
+
+	// Stack lists the active functions, innermost first.
+	Stack []string
+}
+
+// Error implements the error interface.
+//
+// Format: "runtime error: message (in main, at 0012: div)"
+func (e *RuntimeError) Error() string {
+	if e.Function == "" {
+		return "runtime error: " + e.Message
+	}
+	return fmt.Sprintf("runtime error: %s (in %s, at %04d: %s)", e.Message, e.Function, e.Offset, e.Instruction)
+}
+
+// NewInterpreter creates an interpreter. Output goes to os.Stdout unless
+// Stdout is changed.
+func NewInterpreter() *Interpreter {
+	return &Interpreter{Stdout: os.Stdout, MaxCallDepth: DefaultMaxCallDepth}
+}
+
+// Run calls the function of prog named entry with args and returns its
+// result: 0 for a void function, and for a float, bool or char the number
+// the VM holds it as (see word). An entry point that takes or returns a
+// string can't be run this way.
+//
+// Globals start from their initial values on every Run.
+func (in *Interpreter) Run(prog *Program, entry string, args ...int64) (int64, error) {
+	index := prog.Function(entry)
+	if index < 0 {
+		return 0, &RuntimeError{Message: fmt.Sprintf("no function %s in the program", entry)}
+	}
+	fn := &prog.Functions[index]
+	if len(args) != len(fn.Params) {
+		return 0, &RuntimeError{Message: fmt.Sprintf("%s takes %d arguments, got %d", entry, len(fn.Params), len(args))}
+	}
+	for _, kind := range fn.Params {
+		if kind == KindString {
+			return 0, &RuntimeError{Message: fmt.Sprintf("%s takes a string, which Run can't pass", entry)}
+		}
+	}
+	if fn.Result == KindString {
+		return 0, &RuntimeError{Message: fmt.Sprintf("%s returns a string, which Run can't return", entry)}
+	}
+
+	in.prog = prog
+	in.constants = make([]word, len(prog.Constants))
+	for i, c := range prog.Constants {
+		switch k := c.(type) {
+		case int64:
+			in.constants[i] = word{n: k}
+		case string:
+			in.constants[i] = word{s: k}
+		default:
+			return 0, &RuntimeError{Message: fmt.Sprintf("constant #%d is a %T, not an int64 or string", i, c)}
+		}
+	}
+	in.globals = make([]word, prog.Globals)
+	for slot, c := range prog.Initializers {
+		if c >= 0 {
+			in.globals[slot] = in.constants[c]
+		}
+	}
+	in.stack = in.stack[:0]
+	in.frames = in.frames[:0]
+	for _, arg := range args {
+		in.stack = append(in.stack, word{n: arg})
+	}
+
+	result, err := in.execute(index)
+	in.prog, in.constants = nil, nil
+	return result.n, err
+}
+
+// fault creates a RuntimeError located at the instruction at pc of fn.
+func (in *Interpreter) fault(fn, pc int, format string, args ...interface{}) error {
+	err := &RuntimeError{
+		Message:  fmt.Sprintf(format, args...),
+		Function: in.prog.Functions[fn].Name,
+		Offset:   pc,
+	}
+	if pc < len(in.prog.Code) {
+		err.Instruction, _ = in.prog.Instruction(pc)
+	}
+	err.Stack = append(err.Stack, err.Function)
+	for i := len(in.frames) - 1; i >= 0; i-- {
+		err.Stack = append(err.Stack, in.prog.Functions[in.frames[i].fn].Name)
+	}
+	return err
+}
+
+// execute runs function fn, its arguments on the stack, until it returns.
+func (in *Interpreter) execute(fn int) (word, error) {
+	code := in.prog.Code
+	stack := in.stack
+	base := len(stack) - len(in.prog.Functions[fn].Params)
+	for n := in.prog.Functions[fn].Locals - len(in.prog.Functions[fn].Params); n > 0; n-- {
+		stack = append(stack, word{})
+	}
+	pc := in.prog.Functions[fn].Entry
+
+	for {
+		if pc >= len(code) {
+			return word{}, in.fault(fn, pc, "ran off the end of the code")
+		}
+		op := Opcode(code[pc])
+		if pc+1+op.OperandWidth() > len(code) {
+			return word{}, in.fault(fn, pc, "truncated instruction")
+		}
+
+		// Binary operations replace the lower operand with the result
+		top := len(stack) - 1
+		switch op {
+		case OpPush:
+			stack = append(stack, in.constants[binary.LittleEndian.Uint16(code[pc+1:])])
+		case OpPop:
+			stack = stack[:top]
+		case OpLoad:
+			stack = append(stack, stack[base+int(binary.LittleEndian.Uint16(code[pc+1:]))])
+		case OpStore:
+			stack[base+int(binary.LittleEndian.Uint16(code[pc+1:]))] = stack[top]
+			stack = stack[:top]
+		case OpLoadGlobal:
+			stack = append(stack, in.globals[binary.LittleEndian.Uint16(code[pc+1:])])
+		case OpStoreGlobal:
+			in.globals[binary.LittleEndian.Uint16(code[pc+1:])] = stack[top]
+			stack = stack[:top]
+
+		case OpAdd:
+			stack[top-1].n += stack[top].n
+			stack = stack[:top]
+		case OpSub:
+			stack[top-1].n -= stack[top].n
+			stack = stack[:top]
+		case OpMul:
+			stack[top-1].n *= stack[top].n
+			stack = stack[:top]
+		case OpDiv, OpMod, OpDivU, OpModU:
+			a, b := stack[top-1].n, stack[top].n
+			if b == 0 {
+				return word{}, in.fault(fn, pc, "integer division by zero")
+			}
+			switch op {
+			case OpDiv:
+				a /= b
+			case OpMod:
+				a %= b
+			case OpDivU:
+				a = int64(uint64(a) / uint64(b))
+			default:
+				a = int64(uint64(a) % uint64(b))
+			}
+			stack[top-1].n = a
+			stack = stack[:top]
+		case OpAnd:
+			stack[top-1].n &= stack[top].n
+			stack = stack[:top]
+		case OpOr:
+			stack[top-1].n |= stack[top].n
+			stack = stack[:top]
+		case OpXor:
+			stack[top-1].n ^= stack[top].n
+			stack = stack[:top]
+		case OpShl, OpShr, OpShrU:
+			a, b := stack[top-1].n, stack[top].n
+			if b < 0 {
+				return word{}, in.fault(fn, pc, "negative shift amount %d", b)
+			}
+			switch op {
+			case OpShl:
+				a <<= uint64(b)
+			case OpShr:
+				a >>= uint64(b)
+			default:
+				a = int64(uint64(a) >> uint64(b))
+			}
+			stack[top-1].n = a
+			stack = stack[:top]
+		case OpNeg:
+			stack[top].n = -stack[top].n
+		case OpBitNot:
+			stack[top].n = ^stack[top].n
+		case OpNot:
+			stack[top].n ^= 1
+
+		case OpEq, OpNe, OpLt, OpLe, OpGt, OpGe:
+			a, b := stack[top-1].n, stack[top].n
+			stack[top-1].n = truth(compare(op, a < b, a == b))
+			stack = stack[:top]
+		case OpLtU, OpLeU, OpGtU, OpGeU:
+			a, b := uint64(stack[top-1].n), uint64(stack[top].n)
+			stack[top-1].n = truth(compare(OpLt+(op-OpLtU), a < b, a == b))
+			stack = stack[:top]
+
+		case OpFAdd, OpFSub, OpFMul, OpFDiv:
+			a, b := math.Float64frombits(uint64(stack[top-1].n)), math.Float64frombits(uint64(stack[top].n))
+			switch op {
+			case OpFAdd:
+				a += b
+			case OpFSub:
+				a -= b
+			case OpFMul:
+				a *= b
+			default:
+				a /= b
+			}
+			stack[top-1].n = int64(math.Float64bits(a))
+			stack = stack[:top]
+		case OpFNeg:
+			stack[top].n = int64(math.Float64bits(-math.Float64frombits(uint64(stack[top].n))))
+		case OpFEq, OpFNe, OpFLt, OpFLe, OpFGt, OpFGe:
+			a, b := math.Float64frombits(uint64(stack[top-1].n)), math.Float64frombits(uint64(stack[top].n))
+			stack[top-1].n = truth(compareFloats(op, a, b))
+			stack = stack[:top]
+
+		case OpLen:
+			stack[top] = word{n: int64(len(stack[top].s))}
+		case OpSEq, OpSNe, OpSLt, OpSLe, OpSGt, OpSGe:
+			a, b := stack[top-1].s, stack[top].s
+			stack[top-1] = word{n: truth(compare(OpEq+(op-OpSEq), a < b, a == b))}
+			stack = stack[:top]
+
+		case OpJmp:
+			pc = int(binary.LittleEndian.Uint32(code[pc+1:]))
+			continue
+		case OpJmpIf:
+			cond := stack[top].n
+			stack = stack[:top]
+			if cond != 0 {
+				pc = int(binary.LittleEndian.Uint32(code[pc+1:]))
+				continue
+			}
+
+		case OpCall:
+			callee := int(binary.LittleEndian.Uint16(code[pc+1:]))
+			if callee >= len(in.prog.Functions) {
+				return word{}, in.fault(fn, pc, "call to undefined function #%d", callee)
+			}
+			if len(in.frames)+1 >= in.MaxCallDepth {
+				return word{}, in.fault(fn, pc, "stack overflow calling %s (depth %d)",
+					in.prog.Functions[callee].Name, len(in.frames)+1)
+			}
+			in.frames = append(in.frames, frame{fn: fn, returnPC: pc + 3, base: base})
+			f := &in.prog.Functions[callee]
+			fn, base, pc = callee, len(stack)-len(f.Params), f.Entry
+			for n := f.Locals - len(f.Params); n > 0; n-- {
+				stack = append(stack, word{})
+			}
+			continue
+		case OpReturn:
+			result := stack[top]
+			stack = stack[:base]
+			if len(in.frames) == 0 {
+				in.stack = stack
+				return result, nil
+			}
+			caller := in.frames[len(in.frames)-1]
+			in.frames = in.frames[:len(in.frames)-1]
+			fn, base, pc = caller.fn, caller.base, caller.returnPC
+			stack = append(stack, result)
+			continue
+
+		case OpPrint:
+			text := format(Kind(code[pc+1]), stack[top])
+			stack = stack[:top]
+			if _, err := io.WriteString(in.Stdout, text); err != nil {
+				return word{}, in.fault(fn, pc, "print: %v", err)
+			}
+
+		default:
+			return word{}, in.fault(fn, pc, "invalid opcode %d", byte(op))
+		}
+		pc += 1 + op.OperandWidth()
+	}
+}
+
+// compare implements the six integer comparisons, OpEq to OpGe, from "less"
+// and "equal". The unsigned and string comparisons map onto them.
+func compare(op Opcode, less, equal bool) bool {
+	switch op {
+	case OpEq:
+		return equal
+	case OpNe:
+		return !equal
+	case OpLt:
+		return less
+	case OpLe:
+		return less || equal
+	case OpGt:
+		return !less && !equal
+	default:
+		return !less
+	}
+}
+
+// compareFloats implements the float comparisons. They can't go through
+// compare: NaN is neither less than, equal to nor greater than anything, so
+// every comparison with it is false but !=.
+func compareFloats(op Opcode, a, b float64) bool {
+	switch op {
+	case OpFEq:
+		return a == b
+	case OpFNe:
+		return a != b
+	case OpFLt:
+		return a < b
+	case OpFLe:
+		return a <= b
+	case OpFGt:
+		return a > b
+	default:
+		return a >= b
+	}
+}
+
+// truth is the word of a bool.
+func truth(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// format renders a value as print does, which is how the IR interpreter
+// prints it (see interp.Format).
+func format(kind Kind, w word) string {
+	switch kind {
+	case KindUint:
+		return strconv.FormatUint(uint64(w.n), 10)
+	case KindFloat:
+		return fmt.Sprint(math.Float64frombits(uint64(w.n)))
+	case KindBool:
+		return strconv.FormatBool(w.n != 0)
+	case KindChar:
+		return string(rune(w.n))
+	case KindString:
+		return w.s
+	default:
+		return strconv.FormatInt(w.n, 10)
+	}
+}
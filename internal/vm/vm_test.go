@@ -0,0 +1,197 @@
+package vm
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/hassan/compiler/internal/interp"
+	"github.com/hassan/compiler/internal/ir"
+	"github.com/hassan/compiler/pkg/compiler"
+)
+
+// compileSource compiles a program to IR and then to bytecode.
+func compileSource(t *testing.T, source []byte, filename string, optLevel int) (*ir.Module, *Program) {
+	t.Helper()
+	result, err := compiler.Compile(source, filename, compiler.Options{OptLevel: optLevel})
+	if err != nil {
+		t.Fatalf("compiling %s: %v", filename, err)
+	}
+	prog, errs := NewCompiler().Compile(result.Module)
+	if len(errs) > 0 {
+		t.Fatalf("compiling %s to bytecode: %v", filename, errs)
+	}
+	return result.Module, prog
+}
+
+// compileFile compiles a testdata program at the given optimization level.
+func compileFile(t *testing.T, path string, optLevel int) (*ir.Module, *Program) {
+	t.Helper()
+	source, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return compileSource(t, source, path, optLevel)
+}
+
+// TestRun_Programs runs each testdata program at each optimization level
+// and checks main's result, and that it prints what the IR interpreter
+// prints for the same module.
+func TestRun_Programs(t *testing.T) {
+	tests := []struct {
+		file string
+		want int64
+	}{
+		{"factorial.src", 3628800},
+		{"loops.src", 4206},
+		{"logical.src", 54},
+		{"switch.src", 24},
+		{"strings.src", 12},
+		{"floats.src", 1},
+		{"unsigned.src", 3},
+		{"widths.src", 4},
+		{"wrapping.src", 0},
+		{"initializers.src", 230},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			for _, level := range []int{0, 1, 2} {
+				module, prog := compileFile(t, "testdata/"+tt.file, level)
+
+				var stdout bytes.Buffer
+				machine := NewInterpreter()
+				machine.Stdout = &stdout
+				got, err := machine.Run(prog, "main")
+				if err != nil {
+					t.Fatalf("-O%d: %v", level, err)
+				}
+				if got != tt.want {
+					t.Errorf("-O%d: main returned %d, want %d", level, got, tt.want)
+				}
+
+				var want bytes.Buffer
+				reference := interp.New(module)
+				reference.Stdout = &want
+				if _, err := reference.Run("main", nil); err != nil {
+					t.Fatalf("-O%d: the IR interpreter failed: %v", level, err)
+				}
+				if stdout.String() != want.String() {
+					t.Errorf("-O%d: output differs from the IR interpreter's:\ngot:\n%s\nwant:\n%s", level, stdout.String(), want.String())
+				}
+			}
+		})
+	}
+}
+
+func TestRun_Factorial(t *testing.T) {
+	_, prog := compileFile(t, "testdata/factorial.src", 1)
+	machine := NewInterpreter()
+	for n, want := range []int64{1, 1, 2, 6, 24, 120} {
+		got, err := machine.Run(prog, "factorial", int64(n))
+		if err != nil || got != want {
+			t.Errorf("factorial(%d) = %d (%v), want %d", n, got, err, want)
+		}
+	}
+}
+
+func TestRun_Errors(t *testing.T) {
+	divide := []byte("package main\n\nfunc divide(a int, b int) int {\n    return a / b;\n}\n\nfunc main() int {\n    println(1);\n    return divide(1, 0);\n}\n")
+	_, prog := compileSource(t, divide, "divide.src", 1)
+	var out strings.Builder
+	machine := NewInterpreter()
+	machine.Stdout = &out
+	_, err := machine.Run(prog, "main")
+	rtErr, ok := err.(*RuntimeError)
+	if !ok {
+		t.Fatalf("expected *RuntimeError, got %T (%v)", err, err)
+	}
+	if rtErr.Message != "integer division by zero" || rtErr.Function != "divide" || rtErr.Instruction != "div" {
+		t.Errorf("unexpected error: %v", rtErr)
+	}
+	if strings.Join(rtErr.Stack, " ") != "divide main" {
+		t.Errorf("expected stack [divide main], got %v", rtErr.Stack)
+	}
+	if out.String() != "1\n" {
+		t.Errorf("expected the output before the error, got %q", out.String())
+	}
+
+	shift := []byte("package main\n\nfunc shift(n int) int {\n    return 1 << n;\n}\n")
+	_, prog = compileSource(t, shift, "shift.src", 0)
+	if _, err := NewInterpreter().Run(prog, "shift", -1); err == nil || !strings.Contains(err.Error(), "negative shift amount -1") {
+		t.Errorf("expected a negative shift error, got %v", err)
+	}
+
+	forever := []byte("package main\n\nfunc forever(n int) int {\n    return forever(n + 1);\n}\n")
+	_, prog = compileSource(t, forever, "forever.src", 0)
+	machine = NewInterpreter()
+	machine.MaxCallDepth = 50
+	if _, err := machine.Run(prog, "forever", 0); err == nil || !strings.Contains(err.Error(), "stack overflow") {
+		t.Errorf("expected a stack overflow, got %v", err)
+	}
+}
+
+func TestRun_BadEntry(t *testing.T) {
+	_, prog := compileFile(t, "testdata/factorial.src", 0)
+	if _, err := NewInterpreter().Run(prog, "missing"); err == nil {
+		t.Errorf("expected an error for a missing entry point")
+	}
+	if _, err := NewInterpreter().Run(prog, "factorial"); err == nil {
+		t.Errorf("expected an error for a missing argument")
+	}
+
+	_, prog = compileFile(t, "testdata/strings.src", 0)
+	if _, err := NewInterpreter().Run(prog, "longer", 1, 2); err == nil || !strings.Contains(err.Error(), "string") {
+		t.Errorf("expected an error for string arguments, got %v", err)
+	}
+}
+
+func TestCompile_Unsupported(t *testing.T) {
+	source := []byte("package main\n\nstruct Point {\n    x int;\n}\n\nfunc main() int {\n    var p Point = Point{x: 1};\n    return p.x;\n}\n")
+	result, err := compiler.Compile(source, "point.src", compiler.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, errs := NewCompiler().Compile(result.Module)
+	if len(errs) == 0 {
+		t.Fatal("expected errors for a struct")
+	}
+	for _, err := range errs {
+		if !strings.Contains(err.Error(), "not supported by the VM") || !strings.HasPrefix(err.Error(), "in function main: ") {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+}
+
+// TestProgram_String pins down the encoding: the offsets count each
+// instruction's operand bytes, and jumps name absolute offsets.
+func TestProgram_String(t *testing.T) {
+	source := []byte("package main\n\nfunc count(n int) int {\n    var i int = 0;\n    while (i < n) {\n        i = i + 1;\n    }\n    return i;\n}\n")
+	_, prog := compileSource(t, source, "count.src", 0)
+	want := `func count(int) int, 4 slots
+  0000  push #0 (0)
+  0003  store 1
+  0006  load 1
+  0009  load 0
+  0012  lt
+  0013  store 2
+  0016  load 2
+  0019  jmpif 0029
+  0024  jmp 0050
+  0029  load 1
+  0032  push #1 (1)
+  0035  add
+  0036  store 3
+  0039  load 3
+  0042  store 1
+  0045  jmp 0006
+  0050  load 1
+  0053  return
+`
+	if got := prog.String(); got != want {
+		t.Errorf("disassembly:\n%s\nwant:\n%s", got, want)
+	}
+	if len(prog.Constants) != 2 {
+		t.Errorf("expected 2 constants, got %v", prog.Constants)
+	}
+}
@@ -0,0 +1,446 @@
+// Package compiler exposes the compilation pipeline as a library.
+//
+// Everything that does the actual work lives under internal/, which Go does
+// not allow other modules to import. This package is the one supported entry
+// point: it wires the phases together the same way the command-line driver
+// does, and hands back every intermediate product so callers (tests, REPLs,
+// language servers) can inspect whatever they need.
+//
+// PIPELINE:
+//
+//	source → lexer → parser → semantic analyzer → IR builder → optimizer
+//
+// DESIGN CHOICE: Compile never stops at the first error inside a phase - each
+// phase already collects all of its errors - but it does stop at the first
+// phase that reports any. Running the type checker over an AST with syntax
+// errors would mostly produce follow-on noise, and the IR builder assumes a
+// well-typed program.
+package compiler
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+
+	"github.com/hassan/compiler/internal/errors"
+	"github.com/hassan/compiler/internal/ir"
+	"github.com/hassan/compiler/internal/lexer"
+	"github.com/hassan/compiler/internal/optimizer"
+	"github.com/hassan/compiler/internal/parser"
+	"github.com/hassan/compiler/internal/parser/ast"
+	"github.com/hassan/compiler/internal/semantic"
+)
+
+// Phase identifies a stage of the pipeline.
+type Phase int
+
+const (
+	// PhaseAll is the zero value: run the whole pipeline.
+	PhaseAll Phase = iota
+	PhaseParse
+	PhaseSemantic
+	PhaseIR
+	PhaseOptimize
+)
+
+// String returns a human-readable phase name ("semantic analysis").
+func (p Phase) String() string {
+	switch p {
+	case PhaseAll:
+		return "compilation"
+	case PhaseParse:
+		return "parsing"
+	case PhaseSemantic:
+		return "semantic analysis"
+	case PhaseIR:
+		return "IR generation"
+	case PhaseOptimize:
+		return "optimization"
+	default:
+		return fmt.Sprintf("Phase(%d)", int(p))
+	}
+}
+
+// Options controls a compilation.
+//
+// The zero value parses, analyzes and generates IR but does not optimize.
+type Options struct {
+	// OptLevel selects how much optimization runs after IR generation, as
+	// in optimizer.SetLevel: 0 disables the optimizer, 1 runs the default
+	// passes and 2 runs all of them.
+	OptLevel int
+
+	// Passes, when not nil, is the explicit list of optimization passes to
+	// run, by name and in order, instead of OptLevel's preset. The optimizer
+	// runs even at OptLevel 0.
+	Passes []string
+
+	// DisabledPasses are left out of whichever pass list runs.
+	DisabledPasses []string
+
+	// Verbose, when not nil, receives the optimizer's log as it runs: each
+	// function, the passes run on it and what they changed, and every
+	// iteration's instruction count (see optimizer.SetVerbose).
+	Verbose io.Writer
+
+	// StopAfter ends the pipeline once the given phase has completed.
+	// PhaseAll (the zero value) runs every phase.
+	StopAfter Phase
+
+	// WarningsAsErrors makes any warning fail compilation. The warnings are
+	// then reported as the failing phase's Diagnostics, with error severity.
+	WarningsAsErrors bool
+
+	// StrictShadowing makes a declaration shadowing a local of an enclosing
+	// scope an error rather than a W003 warning, whatever WarningsAsErrors
+	// says. Like warnings, it applies to the packages being compiled, not
+	// to their imports.
+	StrictShadowing bool
+
+	// ImportRoot is the directory import paths are resolved against:
+	// import "geometry" loads ImportRoot/geometry/*.src. Empty means the
+	// directory of the first source file.
+	ImportRoot string
+
+	// BoundsChecks makes IR generation check every array index explicitly
+	// (see ir.Builder.EmitBoundsChecks).
+	BoundsChecks bool
+}
+
+// Result holds everything a compilation produced.
+//
+// Fields for phases that did not run are nil. When a phase fails, the
+// products of the phases before it are still populated - a parse that
+// succeeded but failed type checking still returns its AST.
+type Result struct {
+	// File is the parsed AST. For a multi-file package it is the first
+	// file; Files holds all of them.
+	File *ast.File
+
+	// Files are the parsed ASTs of every source, in input order.
+	Files []*ast.File
+
+	// Analyzer holds the symbol table and expression types, for queries
+	// such as Analyzer.GetExprType.
+	Analyzer *semantic.Analyzer
+
+	// Packages are the imported packages, directly or indirectly, each
+	// listed after the packages it imports.
+	Packages []*semantic.Package
+
+	// Module is the IR, including the functions and globals of every
+	// imported package under qualified names. If optimization ran it has
+	// been optimized in place.
+	Module *ir.Module
+
+	// UnoptimizedIR is the textual IR captured before optimization ran.
+	UnoptimizedIR string
+
+	// UnoptimizedCFG holds each function's control-flow graph in Graphviz
+	// dot syntax (see ir.Function.ToDOT), by function name, captured
+	// alongside UnoptimizedIR.
+	UnoptimizedCFG map[string]string
+
+	// OptStats describes what each optimization pass did, per function and
+	// in total. Nil when the optimizer did not run.
+	OptStats *optimizer.OptimizationStats
+
+	// Completed is the last phase that finished without errors.
+	Completed Phase
+
+	// Diagnostics are the errors of the failing phase, if any.
+	Diagnostics []errors.CompileError
+
+	// Warnings are non-fatal diagnostics, reported even when compilation
+	// succeeds. Empty when Options.WarningsAsErrors promoted them.
+	Warnings []errors.CompileError
+}
+
+// Error is returned by Compile when a phase reports diagnostics.
+type Error struct {
+	Phase       Phase
+	Diagnostics []errors.CompileError
+}
+
+// Error summarizes the failure with its first diagnostic.
+func (e *Error) Error() string {
+	if len(e.Diagnostics) == 0 {
+		return e.Phase.String() + " failed"
+	}
+	first := e.Diagnostics[0]
+	msg := fmt.Sprintf("%s failed: %s", e.Phase, first.Error())
+	if len(e.Diagnostics) > 1 {
+		msg += fmt.Sprintf(" (and %d more)", len(e.Diagnostics)-1)
+	}
+	return msg
+}
+
+// Source is one input file of a package.
+type Source struct {
+	Filename string
+	Text     []byte
+}
+
+// Compile runs the pipeline over a single source file.
+//
+// It always returns a non-nil Result. The error is nil on success and an
+// *Error describing the failing phase otherwise; the same diagnostics are
+// available as Result.Diagnostics.
+func Compile(source []byte, filename string, opts Options) (*Result, error) {
+	return CompilePackage([]Source{{Filename: filename, Text: source}}, opts)
+}
+
+// Edit is one change to a source file, for Reparse: the bytes from Start up
+// to End replaced by Text.
+type Edit = parser.Edit
+
+// Reparse returns the AST and syntax errors of oldSource with edit applied,
+// given previous, the AST of oldSource.
+//
+// It is meant for an editor that re-parses on every keystroke: only the
+// declarations the edit touches are parsed again, and the rest are taken
+// from previous (see parser.Reparse), which is used up in the process. The
+// result is what parsing the edited source in full would give. previous
+// must have parsed without errors; after a failed parse, parse in full once
+// more instead.
+func Reparse(previous *ast.File, oldSource []byte, edit Edit) (*ast.File, []errors.CompileError) {
+	file, errs := parser.Reparse(previous, string(oldSource), edit)
+	return file, errors.FromErrors(errs, errors.CodeSyntax)
+}
+
+// CompilePackage runs the pipeline over all the files of one package.
+//
+// The files are parsed concurrently (see parseAll), then analyzed and
+// lowered together as a single unit: declarations in any file are visible
+// in every other file. All files must declare the same package. Imported
+// packages are compiled from source first (see Options.ImportRoot) and
+// linked into the same module. Results and errors behave as for Compile.
+func CompilePackage(sources []Source, opts Options) (*Result, error) {
+	result := &Result{}
+
+	// fail records the diagnostics of the phase that just failed.
+	fail := func(phase Phase, diags []errors.CompileError) (*Result, error) {
+		result.Diagnostics = diags
+		return result, &Error{Phase: phase, Diagnostics: diags}
+	}
+
+	// Lexing and parsing
+	files, parseErrors := parseAll(sources)
+	result.Files = files
+	if len(files) > 0 {
+		result.File = files[0]
+	}
+	if len(parseErrors) > 0 {
+		return fail(PhaseParse, errors.FromErrors(parseErrors, errors.CodeSyntax))
+	}
+	result.Completed = PhaseParse
+	if opts.StopAfter == PhaseParse {
+		return result, nil
+	}
+
+	// Semantic analysis
+	root := opts.ImportRoot
+	if root == "" && len(sources) > 0 {
+		root = filepath.Dir(sources[0].Filename)
+	}
+	importer := newDirImporter(root)
+	analyzer := semantic.New()
+	analyzer.SetImporter(importer)
+	analyzer.SetStrictShadowing(opts.StrictShadowing)
+	result.Analyzer = analyzer
+	semanticErrors := analyzer.AnalyzeFiles(files)
+	result.Packages = importer.order
+	result.Warnings = errors.FromErrors(analyzer.Warnings(), errors.CodeSemantic)
+	if len(semanticErrors) > 0 {
+		return fail(PhaseSemantic, errors.FromErrors(semanticErrors, errors.CodeSemantic))
+	}
+	if opts.WarningsAsErrors && len(result.Warnings) > 0 {
+		promoted := result.Warnings
+		result.Warnings = nil
+		for i := range promoted {
+			promoted[i].Severity = errors.SeverityError
+		}
+		return fail(PhaseSemantic, promoted)
+	}
+	result.Completed = PhaseSemantic
+	if opts.StopAfter == PhaseSemantic {
+		return result, nil
+	}
+
+	// IR generation, verified before anything consumes it
+	imported, externals, irErrors := buildPackages(result.Packages, opts.BoundsChecks)
+	builder := ir.NewBuilder(analyzer)
+	builder.SetExternals(externals)
+	builder.EmitBoundsChecks = opts.BoundsChecks
+	module, mainErrors := builder.BuildFiles(files)
+	irErrors = append(irErrors, mainErrors...)
+	link(module, imported)
+	result.Module = module
+	if len(irErrors) > 0 {
+		return fail(PhaseIR, errors.FromErrors(irErrors, errors.CodeIR))
+	}
+	if verifyErrors := module.Verify(); len(verifyErrors) > 0 {
+		return fail(PhaseIR, errors.FromErrors(verifyErrors, errors.CodeVerify))
+	}
+	result.UnoptimizedIR = module.String()
+	result.UnoptimizedCFG = make(map[string]string, len(module.Functions))
+	for _, fn := range module.Functions {
+		result.UnoptimizedCFG[fn.Name] = fn.ToDOT()
+	}
+	result.Completed = PhaseIR
+	if opts.StopAfter == PhaseIR || (opts.OptLevel <= 0 && opts.Passes == nil) {
+		return result, nil
+	}
+
+	// Optimization, re-verified because a buggy pass can break the CFG
+	opt, err := newOptimizer(opts)
+	if err != nil {
+		return fail(PhaseOptimize, []errors.CompileError{errors.FromError(err, errors.CodeInternal)})
+	}
+	err = opt.Optimize(module)
+	result.OptStats = opt.Stats()
+	if err != nil {
+		return fail(PhaseOptimize, []errors.CompileError{errors.FromError(err, errors.CodeInternal)})
+	}
+	if verifyErrors := module.Verify(); len(verifyErrors) > 0 {
+		return fail(PhaseOptimize, errors.FromErrors(verifyErrors, errors.CodeVerify))
+	}
+	result.Completed = PhaseOptimize
+
+	return result, nil
+}
+
+// newOptimizer configures an optimizer from the pass options.
+func newOptimizer(opts Options) (*optimizer.Optimizer, error) {
+	opt := optimizer.NewOptimizer()
+	opt.SetLevel(opts.OptLevel)
+	opt.SetVerbose(opts.Verbose)
+	for _, name := range opts.DisabledPasses {
+		if err := opt.DisablePass(name); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Passes != nil {
+		if err := opt.SetPasses(opts.Passes); err != nil {
+			return nil, err
+		}
+	}
+	return opt, nil
+}
+
+// buildPackages builds the IR of each imported package, dependencies first.
+// The builder qualifies names with the import path, which is how other
+// packages refer to them; every package sees the globals of those built
+// before it.
+func buildPackages(packages []*semantic.Package, boundsChecks bool) ([]*ir.Module, map[string]*ir.Value, []error) {
+	var modules []*ir.Module
+	var errs []error
+	externals := make(map[string]*ir.Value)
+	for _, pkg := range packages {
+		builder := ir.NewBuilder(pkg.Analyzer)
+		builder.SetPackagePath(pkg.Path)
+		builder.SetExternals(externals)
+		builder.EmitBoundsChecks = boundsChecks
+		module, buildErrors := builder.BuildFiles(pkg.Files)
+		errs = append(errs, buildErrors...)
+		for _, global := range module.Globals {
+			externals[global.Name] = global
+		}
+		modules = append(modules, module)
+	}
+	return modules, externals, errs
+}
+
+// link appends the functions and globals of the imported modules to module,
+// so the result is a single self-contained program.
+func link(module *ir.Module, imported []*ir.Module) {
+	for _, m := range imported {
+		for _, global := range m.Globals {
+			global.ID = len(module.Globals)
+			module.Globals = append(module.Globals, global)
+		}
+		for _, fn := range m.Functions {
+			module.AddFunction(fn)
+		}
+	}
+}
+
+// parseAll parses the sources on a pool of goroutines, one for each
+// processor that GOMAXPROCS lets the program use.
+//
+// DESIGN CHOICE: Parsing is the one phase with no shared state - each file
+// gets its own lexer and parser, and the lexer's keyword table is only ever
+// read - so it parallelizes trivially. The phases after it share the symbol
+// table and stay on one goroutine. The types they share with the parser's
+// goroutines, the singletons types.Int and the rest, are never modified.
+//
+// A fixed pool rather than a goroutine per file, so a package of hundreds
+// of files doesn't hold hundreds of lexers and half-built ASTs at once for
+// no gain: only GOMAXPROCS of them can run at a time anyway.
+//
+// Results are stored by index, and the errors sorted by file and position
+// afterwards, so the output is the same whichever goroutine finishes first.
+func parseAll(sources []Source) ([]*ast.File, []error) {
+	files := make([]*ast.File, len(sources))
+	fileErrors := make([][]error, len(sources))
+
+	workers := runtime.GOMAXPROCS(0)
+	if workers > len(sources) {
+		workers = len(sources)
+	}
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				src := sources[i]
+				p := parser.New(lexer.New(string(src.Text), src.Filename))
+				files[i], fileErrors[i] = p.ParseFile(src.Filename)
+			}
+		}()
+	}
+	for i := range sources {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	var errs []error
+	for _, fe := range fileErrors {
+		errs = append(errs, fe...)
+	}
+	sortErrors(errs)
+	return files, errs
+}
+
+// sortErrors orders errs by filename, then line and column. Errors with no
+// position keep their order, after those with one.
+func sortErrors(errs []error) {
+	position := func(err error) (lexer.Position, bool) {
+		switch e := err.(type) {
+		case *errors.CompileError:
+			return e.Pos, true
+		case *lexer.Error:
+			return e.Pos, true
+		}
+		return lexer.Position{}, false
+	}
+	sort.SliceStable(errs, func(i, j int) bool {
+		a, aok := position(errs[i])
+		b, bok := position(errs[j])
+		switch {
+		case !aok || !bok:
+			return aok && !bok
+		case a.Filename != b.Filename:
+			return a.Filename < b.Filename
+		case a.Line != b.Line:
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+}
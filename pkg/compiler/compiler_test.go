@@ -0,0 +1,1797 @@
+package compiler
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hassan/compiler/internal/errors"
+	"github.com/hassan/compiler/internal/ir"
+	"github.com/hassan/compiler/internal/lexer"
+	"github.com/hassan/compiler/internal/semantic/types"
+	"github.com/hassan/compiler/internal/symtab"
+)
+
+const validSource = `package main
+
+func square(n int) int {
+    return n * n;
+}
+
+func main() {
+    var x int = square(2 + 3);
+}
+`
+
+func TestCompile_Success(t *testing.T) {
+	result, err := Compile([]byte(validSource), "test.src", Options{OptLevel: 1})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Completed != PhaseOptimize {
+		t.Errorf("expected all phases to complete, stopped after %v", result.Completed)
+	}
+	if result.File == nil || result.Analyzer == nil || result.Module == nil {
+		t.Fatalf("expected AST, analyzer and module to be populated")
+	}
+	if len(result.Module.Functions) != 2 {
+		t.Errorf("expected 2 functions, got %d", len(result.Module.Functions))
+	}
+
+	// Constant folding turns 2 + 3 into 5, so the optimized IR differs from
+	// the snapshot taken before optimization.
+	if !strings.Contains(result.UnoptimizedIR, "const(2) + const(3)") {
+		t.Errorf("expected unoptimized IR to contain the addition:\n%s", result.UnoptimizedIR)
+	}
+	if result.UnoptimizedIR == result.Module.String() {
+		t.Errorf("expected optimization to change the IR")
+	}
+}
+
+func TestCompile_StopAfter(t *testing.T) {
+	tests := []struct {
+		stop      Phase
+		hasModule bool
+	}{
+		{PhaseParse, false},
+		{PhaseSemantic, false},
+		{PhaseIR, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.stop.String(), func(t *testing.T) {
+			result, err := Compile([]byte(validSource), "test.src", Options{OptLevel: 1, StopAfter: tt.stop})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result.Completed != tt.stop {
+				t.Errorf("expected to stop after %v, got %v", tt.stop, result.Completed)
+			}
+			if (result.Module != nil) != tt.hasModule {
+				t.Errorf("module present = %v, expected %v", result.Module != nil, tt.hasModule)
+			}
+		})
+	}
+}
+
+func TestCompile_Diagnostics(t *testing.T) {
+	source := "package main\n\nfunc f() {\n    var y int = missing;\n}\n"
+	result, err := Compile([]byte(source), "test.src", Options{})
+
+	compileErr, ok := err.(*Error)
+	if !ok {
+		t.Fatalf("expected *Error, got %T (%v)", err, err)
+	}
+	if compileErr.Phase != PhaseSemantic {
+		t.Errorf("expected semantic failure, got %v", compileErr.Phase)
+	}
+	if result.Completed != PhaseParse {
+		t.Errorf("expected parsing to have completed, got %v", result.Completed)
+	}
+	if result.File == nil {
+		t.Errorf("expected the AST to be returned alongside the errors")
+	}
+	if len(result.Diagnostics) == 0 || result.Diagnostics[0].Pos.Line != 4 {
+		t.Errorf("expected a diagnostic on line 4, got %v", result.Diagnostics)
+	}
+}
+
+func TestCompile_DefiniteAssignment(t *testing.T) {
+	tests := []struct {
+		name string
+		body string // the body of func f(c bool) int
+		line int    // the line of the "used before assignment" error, 0 for none
+	}{
+		{"read before any assignment", "    var x int;\n    return x;\n", 11},
+		{"assigned before the read", "    var x int;\n    x = 1;\n    return x;\n", 0},
+		{"read on the right of its own assignment", "    var x int;\n    x = x + 1;\n    return x;\n", 11},
+		{"compound assignment", "    var x int;\n    x += 1;\n    return x;\n", 11},
+		{"only the then branch assigns", "    var x int;\n    if (c) {\n        x = 1;\n    }\n    return x;\n", 14},
+		{"both branches assign", "    var x int;\n    if (c) {\n        x = 1;\n    } else {\n        x = 2;\n    }\n    return x;\n", 0},
+		{"the other branch returns", "    var x int;\n    if (c) {\n        x = 1;\n    } else {\n        return 0;\n    }\n    return x;\n", 0},
+		{"assigned in the loop body", "    var x int;\n    while (c) {\n        x = 1;\n    }\n    return x;\n", 14},
+		{"read after assigning in the loop body", "    var x int;\n    while (c) {\n        x = 1;\n        c = x > 0;\n    }\n    return 0;\n", 0},
+		{"switch with every case assigning", "    var x int;\n    switch (1) {\n    case 1:\n        x = 1;\n    default:\n        x = 2;\n    }\n    return x;\n", 0},
+		{"switch without a default", "    var x int;\n    switch (1) {\n    case 1:\n        x = 1;\n    }\n    return x;\n", 15},
+		{"bool switch with both cases", "    var x int;\n    switch (c) {\n    case true:\n        x = 1;\n    case false:\n        x = 2;\n    }\n    return x;\n", 0},
+		{"break before the assignment", "    var x int;\n    switch (1) {\n    case 1:\n        if (c) {\n            break;\n        }\n        x = 1;\n    default:\n        x = 2;\n    }\n    return x;\n", 20},
+		{"short-circuited assignment", "    var x int;\n    if (c && (x = 1) > 0) {\n        return 1;\n    }\n    return x;\n", 14},
+		{"structs are filled field by field", "    var p Point;\n    p.x = 1;\n    return p.x;\n", 0},
+		{"globals are zero-initialized", "    return g;\n", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The body starts on line 10
+			source := "package main\n\nvar g int;\n\nstruct Point {\n    x int;\n}\n\nfunc f(c bool) int {\n" + tt.body + "}\n"
+			result, _ := Compile([]byte(source), "test.src", Options{StopAfter: PhaseSemantic})
+
+			var lines []int
+			for _, d := range result.Diagnostics {
+				if strings.HasSuffix(d.Message, "used before assignment") {
+					lines = append(lines, d.Pos.Line)
+				} else if d.IsError() {
+					t.Fatalf("unexpected error: %v", d.Error())
+				}
+			}
+			switch {
+			case tt.line == 0 && len(lines) > 0:
+				t.Errorf("expected no error, got one on line %v", lines)
+			case tt.line != 0 && (len(lines) != 1 || lines[0] != tt.line):
+				t.Errorf("expected an error on line %d, got %v", tt.line, lines)
+			}
+		})
+	}
+}
+
+func TestCompile_MissingReturn(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string // the body of func f(c bool) int
+		missing bool
+	}{
+		{"return at the end", "    return 1;\n", false},
+		{"empty body", "", true},
+		{"both branches return", "    if (c) {\n        return 1;\n    } else {\n        return 2;\n    }\n", false},
+		{"only the then branch returns", "    if (c) {\n        return 1;\n    }\n", true},
+		{"only the else branch returns", "    if (c) {\n        c = false;\n    } else {\n        return 2;\n    }\n", true},
+		{"else if chain", "    if (c) {\n        return 1;\n    } else if (!c) {\n        return 2;\n    } else {\n        return 3;\n    }\n", false},
+		{"else if without else", "    if (c) {\n        return 1;\n    } else if (!c) {\n        return 2;\n    }\n", true},
+		{"nested block", "    {\n        return 1;\n    }\n", false},
+		{"infinite loop", "    for (;;) {\n    }\n", false},
+		{"infinite loop with a break", "    for (;;) {\n        if (c) {\n            break;\n        }\n    }\n", true},
+		{"break out of an inner loop", "    for (;;) {\n        while (c) {\n            break;\n        }\n    }\n", false},
+		{"loop with a condition", "    while (true) {\n        return 1;\n    }\n", true},
+		{"switch with a default", "    switch (1) {\n    default:\n        return 1;\n    }\n", false},
+		{"switch without a default", "    switch (1) {\n    case 1:\n        return 1;\n    }\n", true},
+		{"a case falls out", "    switch (1) {\n    case 1:\n        c = true;\n    default:\n        return 1;\n    }\n", true},
+		{"bool switch with both cases", "    switch (c) {\n    case true:\n        return 1;\n    case false:\n        return 0;\n    }\n", false},
+		{"bool switch with one case", "    switch (c) {\n    case true:\n        return 1;\n    }\n", true},
+		{"break out of a case", "    switch (c) {\n    case true:\n        if (c) {\n            break;\n        }\n        return 1;\n    case false:\n        return 0;\n    }\n", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Compiled through the IR builder, which must agree
+			source := "package main\n\nfunc f(c bool) int {\n" + tt.body + "}\n"
+			closing := strings.Count(source, "\n")
+			result, err := Compile([]byte(source), "test.src", Options{})
+
+			if !tt.missing {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("expected a missing return error")
+			}
+			d := result.Diagnostics[0]
+			if result.Completed != PhaseParse || d.Message != "missing return" || d.Pos.Line != closing {
+				t.Errorf("expected a semantic missing return error on line %d, got %v", closing, d.Error())
+			}
+		})
+	}
+}
+
+func TestCompile_NamedTypes(t *testing.T) {
+	const declarations = "type Meters int;\ntype Seconds int;\ntype Count = int;\n\n"
+	tests := []struct {
+		name string
+		body string // the body of func f(m Meters, s Seconds, n int)
+		want string // the error's message, "" for none
+	}{
+		{"same named type", "    var d Meters = m + m;\n", ""},
+		{"seconds to meters", "    m = s;\n", "cannot assign Seconds to Meters"},
+		{"meters to seconds", "    s = m;\n", "cannot assign Meters to Seconds"},
+		{"int to meters", "    m = n;\n", "cannot assign int to Meters"},
+		{"meters to int", "    n = m;\n", "cannot assign Meters to int"},
+		{"mixed operands", "    var d Meters = m + n;\n", "mismatched types: Meters and int"},
+		{"mixed comparison", "    var b bool = m < s;\n", "cannot compare Meters and Seconds"},
+		{"mixed bitwise operands", "    var d Meters = m & n;\n", "mismatched types: Meters and int"},
+		{"shift by an int", "    var d Meters = m << n;\n", ""},
+		{"conversion from int", "    m = Meters(n) + Meters(5);\n", ""},
+		{"conversion between named types", "    s = Seconds(m);\n", ""},
+		{"conversion to int", "    n = int(m);\n", ""},
+		{"conversion from float", "    m = Meters(1.5);\n", "cannot convert float to Meters"},
+		{"conversion with two arguments", "    m = Meters(1, 2);\n", "conversion to Meters takes exactly one argument, got 2"},
+		{"alias is interchangeable", "    var c Count = n;\n    n = c;\n", ""},
+		{"printable", "    print(m);\n", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := "package main\n\n" + declarations + "func f(m Meters, s Seconds, n int) {\n" + tt.body + "}\n"
+			result, err := Compile([]byte(source), "test.src", Options{OptLevel: 1})
+
+			if tt.want == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("expected error %q", tt.want)
+			}
+			if d := result.Diagnostics[0]; d.Message != tt.want {
+				t.Errorf("expected error %q, got %q", tt.want, d.Message)
+			}
+		})
+	}
+}
+
+func TestCompile_IntegerWidening(t *testing.T) {
+	// The parameter is copied into a wider temporary on its way to x
+	source := "package main\n\nfunc f(a int8) int {\n    var x int = a;\n    return x;\n}\n"
+	result, err := Compile([]byte(source), "test.src", Options{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", result.Diagnostics)
+	}
+	fn := result.Module.Functions[0]
+	widened := false
+	for _, instr := range fn.Entry.Instructions {
+		if c, ok := instr.(*ir.Copy); ok && c.Value.Type.Equals(types.Int8) && c.Dest.Type.Equals(types.Int) {
+			widened = true
+		}
+	}
+	if !widened {
+		t.Errorf("expected a copy of a into an int:\n%s", fn)
+	}
+
+	tests := []struct {
+		name string
+		body string // the body of func g(a int8, u uint16, n int) int16
+		want string // the error, "" for none
+	}{
+		{"argument", "    return int16(id(a));\n", ""},
+		{"return", "    return a;\n", ""},
+		{"unsigned to wider unsigned", "    var w uint64 = u;\n    return 0;\n", ""},
+		{"narrowing", "    var x int8 = n;\n    return 0;\n", "cannot assign int to int8"},
+		{"narrowing return", "    return n;\n", "cannot assign int to int16"},
+		{"unsigned to wider signed", "    var x int32 = u;\n    return 0;\n", "cannot assign uint16 to int32"},
+		{"signed to wider unsigned", "    var w uint64 = a;\n    return 0;\n", "cannot assign int8 to uint64"},
+		{"operands stay identical", "    return int16(n + a);\n", "mismatched types: int and int8"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := "package main\n\nfunc id(n int) int {\n    return n;\n}\n\n" +
+				"func g(a int8, u uint16, n int) int16 {\n" + tt.body + "}\n"
+			result, err := Compile([]byte(source), "test.src", Options{StopAfter: PhaseSemantic})
+			if tt.want == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", result.Diagnostics)
+				}
+			} else if err == nil || result.Diagnostics[0].Message != tt.want {
+				t.Errorf("expected %q, got %v", tt.want, result.Diagnostics)
+			}
+		})
+	}
+}
+
+func TestCompile_Pointers(t *testing.T) {
+	// A pointer to an int, round trip: n lives in a slot once its address
+	// is taken, so the store through p is what the final read of n loads
+	source := "package main\n\nfunc f() int {\n    var n int = 1;\n    var p *int = &n;\n    *p = *p + 41;\n    return n;\n}\n"
+	result, err := Compile([]byte(source), "test.src", Options{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", result.Diagnostics)
+	}
+	fn := result.Module.Functions[0]
+	var kinds []string
+	for _, instr := range fn.Entry.Instructions {
+		switch instr.(type) {
+		case *ir.Alloca:
+			kinds = append(kinds, "alloca")
+		case *ir.Load:
+			kinds = append(kinds, "load")
+		case *ir.Store:
+			kinds = append(kinds, "store")
+		}
+	}
+	// n's slot and its initializer, *p read and written, then n read back
+	want := []string{"alloca", "store", "load", "store", "load"}
+	if !reflect.DeepEqual(kinds, want) {
+		t.Errorf("expected %v, got %v:\n%s", want, kinds, fn)
+	}
+
+	tests := []struct {
+		name string
+		body string // the body of func g(n int, q *int) int
+		want string // the error, "" for none
+	}{
+		{"parameter", "    var p *int = &n;\n    return *p;\n", ""},
+		{"through a parameter", "    *q = n;\n    return *q;\n", ""},
+		{"field", "    var pt Point = Point{1, 2};\n    var p *int = &pt.y;\n    return *p;\n", ""},
+		{"pointer to pointer", "    var pp **int = &q;\n    return **pp;\n", ""},
+		{"address of a dereference", "    var p *int = &*q;\n    return *p;\n", ""},
+		{"field through a pointer", "    var pt Point = Point{1, 2};\n    var p *Point = &pt;\n    (*p).x = 5;\n    return pt.x;\n", ""},
+		{"unassigned variable", "    var x int;\n    var p *int = &x;\n    *p = 3;\n    return x;\n", ""},
+		// A pointer that outlives the call is fine: its local is put on the
+		// heap (see TestCompile_Escapes)
+		{"stored in a global", "    saved = &n;\n    return *saved;\n", ""},
+		{"stored through a pointer", "    var pp **int = &q;\n    *pp = &n;\n    return *q;\n", ""},
+		{"literal", "    var p *int = &5;\n    return 0;\n", "cannot take the address of a value that isn't stored in a variable"},
+		{"call result", "    var p *int = &g(n, q);\n    return 0;\n", "cannot take the address of a value that isn't stored in a variable"},
+		{"global", "    var p *int = &total;\n    return 0;\n", "cannot take the address of global variable total"},
+		{"function", "    &g;\n    return 0;\n", "cannot take the address of g"},
+		{"dereference a non-pointer", "    return *n;\n", "unary * requires pointer operand, got int"},
+		{"element type must match", "    var b int8 = 1;\n    var p *int = &b;\n    return 0;\n", "cannot assign *int8 to *int"},
+		{"pointer is not an int", "    return q;\n", "cannot assign *int to int"},
+		{"pointers aren't comparable", "    if (q == q) {\n        return 1;\n    }\n    return 0;\n", "operands must be comparable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := "package main\n\nstruct Point {\n    x int;\n    y int;\n}\n\nvar total int = 0;\nvar saved *int;\n\n" +
+				"func g(n int, q *int) int {\n" + tt.body + "}\n"
+			result, err := Compile([]byte(source), "test.src", Options{StopAfter: PhaseSemantic})
+			if tt.want == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", result.Diagnostics)
+				}
+			} else if err == nil || result.Diagnostics[0].Message != tt.want {
+				t.Errorf("expected %q, got %v", tt.want, result.Diagnostics)
+			}
+		})
+	}
+}
+
+func TestCompile_Escapes(t *testing.T) {
+	// Each variable f takes the address of gets a slot: on the stack, or on
+	// the heap when a pointer to it may outlive the call
+	tests := []struct {
+		name  string
+		body  string // the body of func f(n int, out **int) *int
+		heap  []string
+		stack []string
+	}{
+		{
+			name: "returned",
+			body: "    var x int = 42;\n    return &x;\n",
+			heap: []string{"x"},
+		},
+		{
+			name:  "only read through",
+			body:  "    var x int = n;\n    inc(&x);\n    var p *int = &x;\n    return new(int);\n",
+			stack: []string{"x"},
+		},
+		{
+			name: "stored in a global",
+			body: "    var x int = n;\n    saved = &x;\n    return new(int);\n",
+			heap: []string{"x"},
+		},
+		{
+			name: "stored through a pointer",
+			body: "    var x int = n;\n    *out = &x;\n    return new(int);\n",
+			heap: []string{"x"},
+		},
+		{
+			name: "returned through a local",
+			body: "    var x int = n;\n    var y int = n;\n    var p *int = &y;\n    p = &x;\n    return p;\n",
+			heap: []string{"x", "y"},
+		},
+		{
+			name:  "passed to a function that returns it",
+			body:  "    var x int = n;\n    var y int = n;\n    inc(&y);\n    return id(&x);\n",
+			heap:  []string{"x"},
+			stack: []string{"y"},
+		},
+		{
+			name: "parameter",
+			body: "    return &n;\n",
+			heap: []string{"n"},
+		},
+		{
+			name: "held by an escaping variable",
+			body: "    var x int = n;\n    var p *int = &x;\n    cell = &p;\n    return new(int);\n",
+			heap: []string{"p", "x"},
+		},
+		{
+			name:  "returned through a pointer to a pointer",
+			body:  "    var x int = n;\n    var p *int = &x;\n    var pp **int = &p;\n    return *pp;\n",
+			heap:  []string{"x"},
+			stack: []string{"p"},
+		},
+		{
+			name: "in a struct that is returned",
+			body: "    var x int = n;\n    var h Holder = Holder{p: &x};\n    return h.p;\n",
+			heap: []string{"x"},
+		},
+		{
+			name:  "only its value is copied out",
+			body:  "    var x int = n;\n    var p *int = &x;\n    **out = *p;\n    return new(int);\n",
+			stack: []string{"x"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := "package main\n\nstruct Holder {\n    p *int;\n}\n\nvar saved *int;\nvar cell **int;\n\n" +
+				"func inc(p *int) {\n    *p = *p + 1;\n}\n\n" +
+				"func id(p *int) *int {\n    return p;\n}\n\n" +
+				"func f(n int, out **int) *int {\n" + tt.body + "}\n"
+			result, err := Compile([]byte(source), "test.src", Options{OptLevel: 0})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", result.Diagnostics)
+			}
+			var fn *ir.Function
+			for _, f := range result.Module.Functions {
+				if f.Name == "f" {
+					fn = f
+				}
+			}
+			slots := make(map[string]string)
+			for _, block := range fn.Blocks {
+				for _, instr := range block.Instructions {
+					switch i := instr.(type) {
+					case *ir.Alloca:
+						slots[i.Dest.Name] = "stack"
+					case *ir.Call:
+						if i.Dest != nil && i.Dest.Name != "" && i.Function.Name == ir.BuiltinPrefix+"new" {
+							slots[i.Dest.Name] = "heap"
+						}
+					}
+				}
+			}
+			for _, name := range tt.heap {
+				if slots[name] != "heap" {
+					t.Errorf("expected %s on the heap:\n%s", name, fn)
+				}
+			}
+			for _, name := range tt.stack {
+				if slots[name] != "stack" {
+					t.Errorf("expected %s on the stack:\n%s", name, fn)
+				}
+			}
+		})
+	}
+}
+
+func TestCompile_ConstantBoundaries(t *testing.T) {
+	tests := []struct {
+		typ      string
+		min, max string
+		below    string // min - 1, "" when no constant is
+		above    string // max + 1, "" when no literal is
+	}{
+		{"int8", "-128", "127", "-129", "128"},
+		{"int16", "-32768", "32767", "-32769", "32768"},
+		{"int32", "-2147483648", "2147483647", "-2147483649", "2147483648"},
+		{"int", "-9223372036854775807 - 1", "9223372036854775807", "", ""},
+		{"uint8", "0", "255", "-1", "256"},
+		{"uint16", "0", "65535", "-1", "65536"},
+		{"uint32", "0", "4294967295", "-1", "4294967296"},
+		{"uint64", "0", "9223372036854775807", "-1", ""},
+	}
+
+	compile := func(typ, value string) (*Result, error) {
+		source := "package main\n\nfunc f() " + typ + " {\n    var x " + typ + " = " + value + ";\n    return x;\n}\n"
+		return Compile([]byte(source), "test.src", Options{StopAfter: PhaseSemantic})
+	}
+	for _, tt := range tests {
+		t.Run(tt.typ, func(t *testing.T) {
+			for _, value := range []string{tt.min, tt.max} {
+				if result, err := compile(tt.typ, value); err != nil {
+					t.Errorf("%s: unexpected error: %v", value, result.Diagnostics)
+				}
+			}
+			for _, value := range []string{tt.below, tt.above} {
+				if value == "" {
+					continue
+				}
+				want := "constant " + value + " overflows " + tt.typ
+				result, err := compile(tt.typ, value)
+				if err == nil || result.Diagnostics[0].Message != want {
+					t.Errorf("%s: expected %q, got %v", value, want, result.Diagnostics)
+				}
+			}
+		})
+	}
+}
+
+func TestCompile_RecursiveNamedType(t *testing.T) {
+	source := "package main\n\ntype A B;\ntype B A;\n"
+	result, err := Compile([]byte(source), "test.src", Options{StopAfter: PhaseSemantic})
+	if err == nil {
+		t.Fatal("expected a recursive type error")
+	}
+	if d := result.Diagnostics[0]; d.Message != "invalid recursive type B" || d.Pos.Line != 4 {
+		t.Errorf("expected invalid recursive type B on line 4, got %v", d.Error())
+	}
+}
+
+func TestCompile_IntegerWidths(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string // the body of func f(a int8, b int16, u uint8, n int)
+		want    string // the error's message, "" for none
+		warning string // the W005 warning's message, "" for none
+	}{
+		{name: "constant fits", body: "    u = 200;\n    a = -128;\n"},
+		{name: "constant overflows", body: "    var x int8 = 200;\n", want: "constant 200 overflows int8"},
+		{name: "negative unsigned", body: "    u = -1;\n", want: "constant -1 overflows uint8"},
+		{name: "constant operand", body: "    a = a + 1;\n    u = 3 * u;\n"},
+		{name: "constant operand overflows", body: "    a = a + 300;\n", want: "constant 300 overflows int8"},
+		{name: "mixed widths", body: "    a = a + b;\n", want: "mismatched types: int8 and int16"},
+		{name: "widen", body: "    n = a;\n    b = a;\n"},
+		{name: "narrow", body: "    a = b;\n", want: "cannot assign int16 to int8"},
+		{name: "unsigned to signed", body: "    n = u;\n", want: "cannot assign uint8 to int"},
+		{name: "int64 is int", body: "    var x int64 = n;\n    n = x;\n"},
+		{name: "negative uint64", body: "    var x uint64 = -1;\n", want: "constant -1 overflows uint64"},
+		{name: "conversion", body: "    a = int8(n);\n    n = int(b) + int(u);\n"},
+		{name: "constant conversion wraps", body: "    u = uint8(300);\n", warning: "constant 300 overflows uint8 (wraps to 44)"},
+		{name: "constant conversion fits", body: "    a = int8(-5);\n"},
+		{name: "printable", body: "    print(a);\n    println(uint64(n));\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := "package main\n\nfunc f(a int8, b int16, u uint8, n int) {\n" + tt.body + "}\n"
+			result, err := Compile([]byte(source), "test.src", Options{OptLevel: 1})
+
+			if tt.want != "" {
+				if err == nil {
+					t.Fatalf("expected error %q", tt.want)
+				}
+				if d := result.Diagnostics[0]; d.Message != tt.want {
+					t.Errorf("expected error %q, got %q", tt.want, d.Message)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.warning == "" {
+				if len(result.Warnings) != 0 {
+					t.Errorf("expected no warnings, got %v", result.Warnings)
+				}
+				return
+			}
+			if len(result.Warnings) != 1 || result.Warnings[0].Code != "W005" || result.Warnings[0].Message != tt.warning {
+				t.Errorf("expected W005 %q, got %v", tt.warning, result.Warnings)
+			}
+		})
+	}
+}
+
+func TestCompile_StructLiterals(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string // a literal of struct Point { x int; y int; label string; }
+		want string // the error's message, "" for none
+	}{
+		{name: "every field named", expr: `Point{x: 1, y: 2, label: "a"}`},
+		{name: "fields left out", expr: "Point{y: 2}"},
+		{name: "no fields", expr: "Point{}"},
+		{name: "positional", expr: `Point{1, 2, "a"}`},
+		{name: "variable value", expr: "Point{x: n, y: n + 1}"},
+		{name: "mixed", expr: `Point{1, y: 2, label: "a"}`, want: "cannot mix named and positional fields in struct literal"},
+		{name: "mixed after a name", expr: "Point{x: 1, 2}", want: "cannot mix named and positional fields in struct literal"},
+		{name: "too few values", expr: "Point{1, 2}", want: "too few values in struct literal of type Point"},
+		{name: "too many values", expr: `Point{1, 2, "a", 4}`, want: "too many values in struct literal of type Point"},
+		{name: "positional type mismatch", expr: "Point{1, 2, 3}", want: "cannot assign int to string"},
+		{name: "duplicate field", expr: "Point{x: 1, x: 2}", want: "duplicate field: x"},
+		{name: "unknown field", expr: "Point{z: 1}", want: "struct Point has no field z"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := "package main\n\nstruct Point {\n    x int;\n    y int;\n    label string;\n}\n\n" +
+				"func f(n int) Point {\n    return " + tt.expr + ";\n}\n"
+			result, err := Compile([]byte(source), "test.src", Options{OptLevel: 1})
+			if tt.want == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("expected error %q", tt.want)
+			}
+			if d := result.Diagnostics[0]; d.Message != tt.want {
+				t.Errorf("expected error %q, got %q", tt.want, d.Message)
+			}
+		})
+	}
+}
+
+func TestCompile_SwitchCases(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  string // the switched-on value, with n int, s string, c char and b bool in scope
+		clause string // the case clauses
+		want   string // the error's message, "" for none
+	}{
+		{"distinct ints", "n", "case 1: case 2, 3: default:", ""},
+		{"duplicate int", "n", "case 1: case 2, 1:", "duplicate case value 1 (previous at test.src:5:10)"},
+		{"duplicate in one case", "n", "case 4, 4:", "duplicate case value 4 (previous at test.src:5:10)"},
+		{"constant expressions", "n", "case 1 << 2: case 4:", "duplicate case value 4 (previous at test.src:5:10)"},
+		{"negative", "n", "case -1: case 0 - 1:", "duplicate case value -1 (previous at test.src:5:10)"},
+		{"distinct strings", "s", `case "a": case "b":`, ""},
+		{"duplicate string", "s", `case "a": case "a":`, `duplicate case value "a" (previous at test.src:5:10)`},
+		{"duplicate char", "c", "case 'x': case 'y', 'x':", "duplicate case value 'x' (previous at test.src:5:10)"},
+		{"duplicate bool", "b", "case true: case !false:", "duplicate case value true (previous at test.src:5:10)"},
+		{"floats", "1.5", "case -1.5: case 1.5:", ""},
+		{"second default", "n", "default: case 1: default:", "multiple defaults in switch (previous at test.src:5:5)"},
+		{"variable case", "n", "case n:", "case value must be constant"},
+		{"call case", "s", "case f(1, s, c, b):", "case value must be constant"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := "package main\n\nfunc f(n int, s string, c char, b bool) string {\n    switch (" + tt.value + ") {\n" +
+				"    " + tt.clause + "\n    }\n    return s;\n}\n"
+			result, err := Compile([]byte(source), "test.src", Options{StopAfter: PhaseSemantic})
+			if tt.want == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("expected error %q", tt.want)
+			}
+			if d := result.Diagnostics[0]; d.Message != tt.want {
+				t.Errorf("expected error %q, got %q", tt.want, d.Message)
+			}
+		})
+	}
+}
+
+func TestCompile_FieldAccess(t *testing.T) {
+	source := "package main\n\nstruct Point {\n    x int;\n    y int;\n}\n\n" +
+		"func f(p Point) int {\n    var q Point = p;\n    p.x = 5;\n    return p.y + q.y;\n}\n"
+	result, err := Compile([]byte(source), "test.src", Options{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Each access addresses its field, then stores to it or loads from it
+	var got []string
+	instrs := result.Module.Functions[0].Blocks[0].Instructions
+	for i, instr := range instrs {
+		field, ok := instr.(*ir.GetFieldPtr)
+		if !ok {
+			continue
+		}
+		switch next := instrs[i+1].(type) {
+		case *ir.Store:
+			if next.Address == field.Dest {
+				got = append(got, fmt.Sprintf("store %s.field%d", field.Base, field.FieldIndex))
+			}
+		case *ir.Load:
+			if next.Address == field.Dest {
+				got = append(got, fmt.Sprintf("load %s.field%d", field.Base, field.FieldIndex))
+			}
+		}
+	}
+	want := []string{"store param(p.0).field0", "load param(p.0).field1", "load q.1.field1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected field accesses %v, got %v\n%s", want, got, result.Module)
+	}
+}
+
+func TestCompile_CompoundAssignment(t *testing.T) {
+	source := "package main\n\nstruct Point {\n    x int;\n    y int;\n}\n\n" +
+		"func f(n int) int {\n    var x int = n;\n    x += 1;\n    return x;\n}\n\n" +
+		"func g(p Point) int {\n    p.y -= 2;\n    return p.y;\n}\n"
+	result, err := Compile([]byte(source), "test.src", Options{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", result.Diagnostics)
+	}
+	kinds := func(instrs []ir.Instruction) []string {
+		var got []string
+		for _, instr := range instrs {
+			got = append(got, fmt.Sprintf("%T", instr))
+		}
+		return got
+	}
+
+	// x += 1 copies x out, adds, and copies the sum back
+	f := result.Module.Functions[0].Entry.Instructions
+	want := []string{"*ir.Copy", "*ir.Copy", "*ir.BinaryOp", "*ir.Copy", "*ir.Return"}
+	if got := kinds(f); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v for x += 1, got %v\n%s", want, got, result.Module.Functions[0])
+	}
+	x := f[0].(*ir.Copy).Dest
+	current, sum, store := f[1].(*ir.Copy), f[2].(*ir.BinaryOp), f[3].(*ir.Copy)
+	if current.Value != x || sum.Op != ir.OpAdd || sum.Left != current.Dest || store.Value != sum.Dest || store.Dest != x {
+		t.Errorf("expected x = x + 1 through a copy of x:\n%s", result.Module.Functions[0])
+	}
+
+	// p.y -= 2 addresses the field once, then loads and stores through it
+	g := result.Module.Functions[1].Entry.Instructions
+	want = []string{"*ir.GetFieldPtr", "*ir.Load", "*ir.BinaryOp", "*ir.Store", "*ir.GetFieldPtr", "*ir.Load", "*ir.Return"}
+	if got := kinds(g); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v for p.y -= 2, got %v\n%s", want, got, result.Module.Functions[1])
+	}
+	field, load, diff, stored := g[0].(*ir.GetFieldPtr), g[1].(*ir.Load), g[2].(*ir.BinaryOp), g[3].(*ir.Store)
+	if field.FieldIndex != 1 || load.Address != field.Dest || diff.Op != ir.OpSub || diff.Left != load.Dest || stored.Address != field.Dest || stored.Value != diff.Dest {
+		t.Errorf("expected p.y = p.y - 2 through one field address:\n%s", result.Module.Functions[1])
+	}
+
+	errorTests := []struct {
+		name string
+		stmt string
+		want string // the error, "" for none
+	}{
+		{"string", "s += \"!\";", "operator += requires numeric operands"},
+		{"bool", "b &= true;", "bitwise operators require integer operands"},
+		{"float bitwise", "f |= 1.0;", "bitwise operators require integer operands"},
+		{"mismatched value", "n *= f;", "cannot assign float to int"},
+		{"float arithmetic", "f /= 2.0;", ""},
+		{"shift by a narrow count", "n <<= u;", ""},
+		{"constant of the target's type", "u += 1;", ""},
+		{"increment", "n++;", ""},
+		{"decrement through a pointer", "var p *int = &n;\n    (*p)--;", ""},
+		{"increment a constant", "5++;", "++ requires an assignable operand"},
+		{"decrement an expression", "(n + 1)--;", "-- requires an assignable operand"},
+		{"increment a string", "s++;", "++ requires numeric operand"},
+	}
+	for _, tt := range errorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := "package main\n\nfunc f(n int, f float, s string, b bool, u uint8) {\n    " + tt.stmt + "\n}\n"
+			result, err := Compile([]byte(source), "test.src", Options{StopAfter: PhaseSemantic})
+			if tt.want == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", result.Diagnostics)
+				}
+				return
+			}
+			if err == nil || len(result.Diagnostics) != 1 || result.Diagnostics[0].Message != tt.want {
+				t.Errorf("expected %q, got %v", tt.want, result.Diagnostics)
+			}
+		})
+	}
+}
+
+func TestCompile_ShortCircuit(t *testing.T) {
+	tests := []struct {
+		name     string
+		operator string
+		left     string
+		skipped  bool // whether the known left operand skips a()
+	}{
+		{"false and", "&&", "false", true},
+		{"true and", "&&", "true", false},
+		{"true or", "||", "true", true},
+		{"false or", "||", "false", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := fmt.Sprintf("package main\n\nfunc a() bool {\n    return true;\n}\n\nfunc f() bool {\n    return %s %s a();\n}\n", tt.left, tt.operator)
+			result, err := Compile([]byte(source), "test.src", Options{})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", result.Diagnostics)
+			}
+			fn := result.Module.Functions[1]
+
+			// The entry branches on the constant, to the block calling a() or
+			// straight to the join, which merges both paths with a phi
+			branch, ok := fn.Entry.Terminator().(*ir.Branch)
+			if !ok {
+				t.Fatalf("expected the entry block to end in a branch:\n%s", fn)
+			}
+			taken := branch.FalseBlock
+			if tt.left == "true" {
+				taken = branch.TrueBlock
+			}
+			callsA := false
+			for _, instr := range taken.Instructions {
+				if _, ok := instr.(*ir.Call); ok {
+					callsA = true
+				}
+			}
+			if callsA == tt.skipped {
+				t.Errorf("expected the %s edge to skip a() = %v:\n%s", tt.left, tt.skipped, fn)
+			}
+			phi, ok := fn.Blocks[len(fn.Blocks)-1].Instructions[0].(*ir.Phi)
+			if !ok || len(phi.Incomig) != 2 || phi.Incomig[0].Block != fn.Entry {
+				t.Errorf("expected the join to start with a phi of the two paths:\n%s", fn)
+			}
+		})
+	}
+}
+
+func TestCompile_IndexExpressions(t *testing.T) {
+	// The literals address their own elements at constant indices (6 of
+	// them); each of the three accesses below indexes twice
+	source := "package main\n\nfunc f(i int, j int) int {\n    var grid = [[1, 2], [3, 4]];\n    grid[i][j] = grid[j][i];\n    return grid[i][j];\n}\n"
+
+	for _, checks := range []bool{false, true} {
+		t.Run(fmt.Sprintf("checks=%v", checks), func(t *testing.T) {
+			result, err := Compile([]byte(source), "test.src", Options{BoundsChecks: checks})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", result.Diagnostics)
+			}
+			fn := result.Module.Functions[0]
+			elementPtrs, boundsChecks, stores := 0, 0, 0
+			for _, block := range fn.Blocks {
+				for k, instr := range block.Instructions {
+					switch in := instr.(type) {
+					case *ir.GetElementPtr:
+						elementPtrs++
+					case *ir.Store:
+						stores++
+					case *ir.Call:
+						if in.Builtin() != "checkIndex" {
+							continue
+						}
+						boundsChecks++
+						// The check guards the very access that follows it
+						next, ok := block.Instructions[k+1].(*ir.GetElementPtr)
+						if !ok || !reflect.DeepEqual(in.Args, []*ir.Value{next.Base, next.Index}) {
+							t.Errorf("%s is not followed by the GetElementPtr it checks:\n%s", in, fn)
+						}
+					}
+				}
+			}
+			wantChecks := 0
+			if checks {
+				wantChecks = 6
+			}
+			if elementPtrs != 12 || boundsChecks != wantChecks || stores != 7 {
+				t.Errorf("expected 12 GetElementPtrs, %d bounds checks and 7 stores, got %d, %d and %d:\n%s",
+					wantChecks, elementPtrs, boundsChecks, stores, fn)
+			}
+		})
+	}
+}
+
+func TestCompile_ExhaustiveSwitch(t *testing.T) {
+	// Both bool values have a case, so nothing tests for false: the last
+	// test jumps straight to its case, and nothing reaches switch.end
+	source := "package main\n\nfunc f(c bool) int {\n    switch (c) {\n    case true:\n        return 1;\n    case false:\n        return 0;\n    }\n}\n"
+	result, err := Compile([]byte(source), "test.src", Options{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", result.Diagnostics)
+	}
+	fn := result.Module.Functions[0]
+	comparisons := 0
+	for _, block := range fn.Blocks {
+		if block.Label == "switch.end" {
+			t.Errorf("expected no switch.end block, got:\n%s", fn)
+		}
+		for _, instr := range block.Instructions {
+			if op, ok := instr.(*ir.BinaryOp); ok && op.Op == ir.OpEq {
+				comparisons++
+			}
+		}
+	}
+	if comparisons != 1 {
+		t.Errorf("expected 1 comparison, got %d:\n%s", comparisons, fn)
+	}
+}
+
+func TestCompile_TypeQueries(t *testing.T) {
+	result, err := Compile([]byte(validSource), "test.src", Options{StopAfter: PhaseSemantic})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	symbol := result.Analyzer.GetScope().Lookup("square")
+	if symbol == nil {
+		t.Fatalf("expected square in the global scope")
+	}
+	fn, ok := symbol.Type.(*types.FunctionType)
+	if !ok || !fn.ReturnType.Equals(types.Int) {
+		t.Errorf("expected square to have type func(int) int, got %v", symbol.Type)
+	}
+}
+
+func TestCompilePackage_CrossFileReferences(t *testing.T) {
+	sources := []Source{
+		{Filename: "main.src", Text: []byte("package main\n\nfunc main() {\n    var d int = square(limit);\n}\n")},
+		{Filename: "math.src", Text: []byte("package main\n\nvar limit int = 10;\n\nfunc square(n int) int {\n    return n * n;\n}\n")},
+	}
+
+	result, err := CompilePackage(sources, Options{OptLevel: 1})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result.Files) != 2 {
+		t.Fatalf("expected 2 files, got %d", len(result.Files))
+	}
+	if result.Module.Name != "main" {
+		t.Errorf("expected module main, got %q", result.Module.Name)
+	}
+	if len(result.Module.Functions) != 2 {
+		t.Errorf("expected functions from both files, got %d", len(result.Module.Functions))
+	}
+}
+
+func TestCompilePackage_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		sources []Source
+		phase   Phase
+		want    []string // substrings of the first diagnostic
+	}{
+		{
+			name: "package mismatch",
+			sources: []Source{
+				{Filename: "a.src", Text: []byte("package main\n")},
+				{Filename: "b.src", Text: []byte("package other\n")},
+			},
+			phase: PhaseSemantic,
+			want:  []string{"b.src:1:9", "package other; expected main", "a.src:1:9"},
+		},
+		{
+			name: "duplicate across files",
+			sources: []Source{
+				{Filename: "a.src", Text: []byte("package main\n\nfunc f() {\n}\n")},
+				{Filename: "b.src", Text: []byte("package main\n\nfunc f() {\n}\n")},
+			},
+			phase: PhaseSemantic,
+			want:  []string{"b.src:3:6", "already declared at a.src:3:1"},
+		},
+		{
+			name: "parse errors from every file",
+			sources: []Source{
+				{Filename: "a.src", Text: []byte("package main\n\nvar x int = ;\n")},
+				{Filename: "b.src", Text: []byte("package main\n\nvar y int = ;\n")},
+			},
+			phase: PhaseParse,
+			want:  []string{"a.src:3:13"},
+		},
+		{
+			// Parse errors come sorted by file, not in the order given
+			name: "parse errors sorted by file",
+			sources: []Source{
+				{Filename: "b.src", Text: []byte("package main\n\nvar y int = ;\n")},
+				{Filename: "a.src", Text: []byte("package main\n\nvar x int = ;\n")},
+			},
+			phase: PhaseParse,
+			want:  []string{"a.src:3:13"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := CompilePackage(tt.sources, Options{})
+			compileErr, ok := err.(*Error)
+			if !ok {
+				t.Fatalf("expected *Error, got %T (%v)", err, err)
+			}
+			if compileErr.Phase != tt.phase {
+				t.Errorf("expected %v failure, got %v", tt.phase, compileErr.Phase)
+			}
+			first := result.Diagnostics[0].Error()
+			for _, want := range tt.want {
+				if !strings.Contains(first, want) {
+					t.Errorf("expected %q in %q", want, first)
+				}
+			}
+		})
+	}
+}
+
+// TestCompilePackage_Concurrent compiles packages of many files from
+// several goroutines at once; run with -race, it checks that parsing in
+// parallel shares nothing it shouldn't.
+func TestCompilePackage_Concurrent(t *testing.T) {
+	const files = 20
+	sources := make([]Source, files)
+	var calls strings.Builder
+	for i := 0; i < files; i++ {
+		text := fmt.Sprintf("package main\n\nfunc f%d(n int) int {\n    var s string = \"file %d\";\n    return n * %d + len(s);\n}\n", i, i, i)
+		if i == 0 {
+			text += "\nfunc main() {\n    var total int = 0;\n" + "CALLS" + "    println(total);\n}\n"
+		}
+		sources[i] = Source{Filename: fmt.Sprintf("f%02d.src", i), Text: []byte(text)}
+		fmt.Fprintf(&calls, "    total = total + f%d(2);\n", i)
+	}
+	sources[0].Text = []byte(strings.Replace(string(sources[0].Text), "CALLS", calls.String(), 1))
+
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := CompilePackage(sources, Options{OptLevel: 2})
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+			if len(result.Files) != files || len(result.Module.Functions) != files+1 {
+				t.Errorf("expected %d files and %d functions, got %d and %d",
+					files, files+1, len(result.Files), len(result.Module.Functions))
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestReparse(t *testing.T) {
+	result, err := Compile([]byte(validSource), "test.src", Options{StopAfter: PhaseParse})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	square := result.File.Decls[0]
+
+	// Breaking main's body re-parses main alone, keeping square
+	old := "square(2 + 3);"
+	start := strings.Index(validSource, old)
+	edit := Edit{Start: start, End: start + len(old), Text: "square(2 +);"}
+	file, diags := Reparse(result.File, []byte(validSource), edit)
+	if len(diags) != 1 || diags[0].Code != errors.CodeSyntax || diags[0].Pos.Line != 8 {
+		t.Errorf("expected one syntax error on line 8, got %v", diags)
+	}
+	if len(file.Decls) != 2 || file.Decls[0] != square {
+		t.Errorf("expected square to be kept, got %v", file.Decls)
+	}
+
+	if _, diags := Reparse(file, []byte(validSource), Edit{Start: 1, End: 0}); len(diags) != 1 {
+		t.Errorf("expected an invalid edit to be reported, got %v", diags)
+	}
+}
+
+// hoverSource has a parameter, a field access and a call to hover over.
+const hoverSource = `package main
+
+struct Point {
+    x int;
+    y int;
+}
+
+func shift(p Point, by int) int {
+    return p.x + by;
+}
+
+func main() {
+    var moved int = shift(Point{x: 1, y: 2}, 3);
+    println(moved);
+}
+`
+
+func TestTypeAt(t *testing.T) {
+	result, err := Compile([]byte(hoverSource), "test.src", Options{StopAfter: PhaseSemantic})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		at       string // text in hoverSource; the cursor is on its first occurrence
+		skip     int    // bytes into at where the cursor is
+		wantType string
+		wantKind symtab.SymbolKind // of the symbol, if there is one
+		wantDecl string            // text the symbol is declared at
+	}{
+		{"a parameter use", "p.x + by", 0, "struct Point", symtab.SymbolParameter, "p Point"},
+		{"the second parameter", "+ by", 2, "int", symtab.SymbolParameter, "by int) int"},
+		{"a struct field access", "p.x + by", 2, "int", symtab.SymbolField, "x int;"},
+		{"an operator", "p.x + by", 4, "int", 0, ""},
+		{"a call expression", "Point{x: 1, y: 2}, 3", 18, "int", 0, ""},
+		{"a call's callee", "shift(Point{", 0, "func(struct Point, int) int", symtab.SymbolFunction, "func shift"},
+		{"a type name", "Point, by", 0, "struct Point", symtab.SymbolStruct, "struct Point"},
+		{"a local variable", "println(moved)", 8, "int", symtab.SymbolVariable, "moved int ="},
+		{"a keyword", "return", 0, "", 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			offset := strings.Index(hoverSource, tt.at)
+			if offset < 0 {
+				t.Fatalf("%q not in the source", tt.at)
+			}
+			typ, symbol := result.Analyzer.TypeAt(result.File, lexer.Position{Line: 1, Offset: offset + tt.skip})
+
+			gotType := ""
+			if typ != nil {
+				gotType = typ.String()
+			}
+			if gotType != tt.wantType {
+				t.Errorf("expected type %q, got %q", tt.wantType, gotType)
+			}
+			if tt.wantDecl == "" {
+				if symbol != nil {
+					t.Errorf("expected no symbol, got %s", symbol.Name)
+				}
+				return
+			}
+			if symbol == nil {
+				t.Fatalf("expected a %s symbol, got none", tt.wantKind)
+			}
+			if symbol.Kind != tt.wantKind {
+				t.Errorf("expected a %s symbol, got a %s", tt.wantKind, symbol.Kind)
+			}
+			if want := strings.Index(hoverSource, tt.wantDecl); symbol.Pos.Offset != want {
+				t.Errorf("expected the declaration at offset %d, got %v", want, symbol.Pos)
+			}
+		})
+	}
+}
+
+// referencesSource declares one of each kind of name, and a local shadowing
+// another, for TestReferences.
+const referencesSource = `package main
+
+struct Point {
+    x int;
+    y int;
+}
+
+func norm(p Point) int {
+    return p.x * p.x + p.y * p.y;
+}
+
+func main() int {
+    var n int = 1;
+    if (n > 0) {
+        var n int = norm(Point{x: 3, y: 4});
+        println(n);
+    }
+    return n + norm(Point{x: n, y: 0});
+}
+`
+
+func TestReferences(t *testing.T) {
+	result, err := Compile([]byte(referencesSource), "test.src", Options{StopAfter: PhaseSemantic})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// at returns the position of the first occurrence of text
+	at := func(text string) lexer.Position {
+		offset := strings.Index(referencesSource, text)
+		if offset < 0 {
+			t.Fatalf("%q not in the source", text)
+		}
+		return lexer.Position{Filename: "test.src", Line: 1, Offset: offset}
+	}
+
+	tests := []struct {
+		name     string
+		at       string   // the cursor is at its first occurrence
+		wantDecl string   // "" for no definition
+		wantRefs []string // each starts where a reference does
+	}{
+		{"a parameter use", "p.y;", "p Point", []string{"p.x * p.x", "p.x + p.y", "p.y * p.y", "p.y;"}},
+		{"a parameter declaration", "p Point", "p Point", []string{"p.x * p.x", "p.x + p.y", "p.y * p.y", "p.y;"}},
+		{"a struct field", "x: 3", "x int;", []string{"x * p.x", "x + p.y", "x: 3", "x: n"}},
+		{"a function", "norm(Point{x: n", "func norm", []string{"norm(Point{x: 3", "norm(Point{x: n"}},
+		{"a struct type", "Point{x: 3", "struct Point", []string{"Point) int", "Point{x: 3", "Point{x: n"}},
+		{"a shadowing local", "n);", "n int = norm", []string{"n);"}},
+		{"the local it shadows", "n + norm", "n int = 1", []string{"n > 0", "n + norm", "n, y: 0"}},
+		{"a keyword", "return", "", nil},
+		{"a builtin", "println", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decl, ok := result.Analyzer.DefinitionOf(at(tt.at))
+			switch {
+			case tt.wantDecl == "" && ok:
+				t.Errorf("expected no definition, got %v", decl)
+			case tt.wantDecl != "" && !ok:
+				t.Errorf("expected a definition at %q, got none", tt.wantDecl)
+			case ok && decl.Offset != at(tt.wantDecl).Offset:
+				t.Errorf("expected the definition at offset %d, got %v", at(tt.wantDecl).Offset, decl)
+			}
+
+			refs := result.Analyzer.ReferencesOf(at(tt.at))
+			var got, want []int
+			for _, ref := range refs {
+				got = append(got, ref.Offset)
+			}
+			for _, ref := range tt.wantRefs {
+				want = append(want, at(ref).Offset)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Errorf("expected references at offsets %v, got %v", want, got)
+			}
+		})
+	}
+}
+
+func TestCompile_Warnings(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		code   string
+		line   int
+	}{
+		{"unused variable", "package main\n\nfunc f() {\n    var x int = 1;\n}\n", "W001", 4},
+		{"unused import", "package main\n\nimport \"units\"\n\nfunc f() {\n}\n", "W002", 3},
+		{"shadowed parameter", "package main\n\nfunc f(n int) int {\n    if (n > 0) {\n        var n int = 2;\n        return n;\n    }\n    return n;\n}\n", "W003", 5},
+		{"unreachable code", "package main\n\nfunc f() int {\n    return 1;\n    f();\n}\n", "W004", 5},
+		{"constant overflow", "package main\n\nfunc f() int {\n    return 9223372036854775807 + 1;\n}\n", "W005", 4},
+		{"empty switch", "package main\n\nfunc f(n int) {\n    switch (n) {\n    }\n}\n", "W006", 4},
+		{"assignment as condition", "package main\n\nfunc f(b bool) {\n    if (b = true) {\n    }\n}\n", "W007", 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := Options{StopAfter: PhaseSemantic, ImportRoot: importRoot}
+			result, err := Compile([]byte(tt.source), "test.src", opts)
+			if err != nil {
+				t.Fatalf("warnings must not fail compilation: %v", err)
+			}
+			if len(result.Warnings) != 1 {
+				t.Fatalf("expected 1 warning, got %v", result.Warnings)
+			}
+			w := result.Warnings[0]
+			if w.Code != tt.code || w.Pos.Line != tt.line || w.IsError() {
+				t.Errorf("expected warning %s on line %d, got %s (%v)", tt.code, tt.line, w.Code, w.Error())
+			}
+		})
+	}
+
+	source := []byte("package main\n\nfunc f() {\n    var x int = 1;\n}\n")
+	result, err := Compile(source, "test.src", Options{WarningsAsErrors: true})
+	if err == nil {
+		t.Fatalf("expected WarningsAsErrors to fail compilation")
+	}
+	if len(result.Warnings) != 0 || len(result.Diagnostics) != 1 || !result.Diagnostics[0].IsError() {
+		t.Errorf("expected the warning to be promoted, got warnings %v, diagnostics %v", result.Warnings, result.Diagnostics)
+	}
+}
+
+func TestCompile_Shadowing(t *testing.T) {
+	tests := []struct {
+		name  string
+		body  string
+		line  int // of the shadowing declaration, 0 for none
+		outer string
+	}{
+		{"nested blocks", "    var x int = 1;\n    {\n        {\n            var x int = 2;\n            g = x;\n        }\n    }\n    g = x;\n", 7, "variable declared at test.src:4:9"},
+		{"block after its own declaration", "    {\n        var x int = 1;\n        g = x;\n    }\n    var x int = 2;\n    g = x;\n", 0, ""},
+		{"parameter", "    if (n > 0) {\n        var n int = 2;\n        g = n;\n    }\n", 5, "parameter declared at test.src:3:8"},
+		{"parameter named like a global", "    g = n;\n", 0, ""},
+		{"local named like a global", "    var g int = n;\n    h(g);\n", 0, ""},
+		{"loop init", "    var i int = n;\n    for (var i int = 0; i < 3; i = i + 1) {\n        g = i;\n    }\n    g = i;\n", 5, "variable declared at test.src:4:9"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := "package main\n\nfunc f(n int) {\n" + tt.body + "}\n\nvar g int;\n\nfunc h(x int) {\n}\n"
+			for _, strict := range []bool{false, true} {
+				result, err := Compile([]byte(source), "test.src", Options{StopAfter: PhaseSemantic, StrictShadowing: strict})
+				var shadowing []errors.CompileError
+				for _, d := range append(result.Warnings, result.Diagnostics...) {
+					if strings.Contains(d.Message, "shadows") {
+						shadowing = append(shadowing, d)
+					}
+				}
+				if tt.line == 0 {
+					if err != nil || len(shadowing) != 0 {
+						t.Errorf("strict=%v: expected no shadowing, got %v (%v)", strict, shadowing, err)
+					}
+					continue
+				}
+				if len(shadowing) != 1 {
+					t.Fatalf("strict=%v: expected 1 shadowing diagnostic, got %v", strict, shadowing)
+				}
+				d := shadowing[0]
+				if d.Pos.Line != tt.line || !strings.HasSuffix(d.Message, "shadows "+tt.outer) {
+					t.Errorf("strict=%v: expected shadowing of %s on line %d, got %v", strict, tt.outer, tt.line, d.Error())
+				}
+				if strict != d.IsError() || strict != (err != nil) {
+					t.Errorf("strict=%v: got error severity %v, compile error %v", strict, d.IsError(), err)
+				}
+				if !strict && d.Code != "W003" {
+					t.Errorf("expected W003, got %s", d.Code)
+				}
+			}
+		})
+	}
+}
+
+func TestCompile_AssignmentCondition(t *testing.T) {
+	tests := []struct {
+		name string
+		stmt string
+		want string // the warning, "" for none
+	}{
+		{"if", "if (b = true) {\n    }", "test.src:4:11: assignment used as condition; did you mean ==?"},
+		{"while", "while (b = false) {\n    }", "test.src:4:14: assignment used as condition; did you mean ==?"},
+		{"for", "for (; b = n > 0; ) {\n    }", "test.src:4:14: assignment used as condition; did you mean ==?"},
+		{"switch", "switch (n = 1) {\n    default:\n    }", "test.src:4:15: assignment used as switch value; did you mean ==?"},
+		{"comparison", "if (b == true) {\n    }", ""},
+		{"extra parentheses", "if ((b = true)) {\n    }", ""},
+		{"assignment inside the condition", "if (b && (b = false)) {\n    }", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := "package main\n\nfunc f(b bool, n int) {\n    " + tt.stmt + "\n}\n"
+			result, err := Compile([]byte(source), "test.src", Options{})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", result.Diagnostics)
+			}
+			var got []string
+			for _, w := range result.Warnings {
+				if w.Code == "W007" {
+					got = append(got, fmt.Sprintf("%s: %s", w.Pos, w.Message))
+				}
+			}
+			if tt.want == "" {
+				if len(got) != 0 {
+					t.Errorf("expected no warning, got %v", got)
+				}
+			} else if len(got) != 1 || got[0] != tt.want {
+				t.Errorf("expected %q, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestCompile_ChainedComparison(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+		want string // the error, "" for none
+	}{
+		{"less", "a < b < c", "test.src:4:12: chained comparisons are not supported; use (a < b) && (b < c)"},
+		{"less or equal", "a <= b <= c", "test.src:4:12: chained comparisons are not supported; use (a <= b) && (b <= c)"},
+		{"mixed", "a < b == c", "test.src:4:12: chained comparisons are not supported; use (a < b) && (b == c)"},
+		{"equality first", "a == b < c", "test.src:4:12: chained comparisons are not supported; use (a == b) && (b < c)"},
+		{"bools", "x == y == z", "test.src:4:12: chained comparisons are not supported; use (a == b) && (b == c)"},
+		{"grouped equality of bools", "(a < b) == x", ""},
+		{"grouped on the right", "x == (a < b)", ""},
+		{"grouped ordering of a bool", "(a < b) < c", "test.src:4:20: operands must be ordered"},
+		{"conjunction", "a < b && b < c", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := "package main\n\nfunc f(a int, b int, c int, x bool, y bool, z bool) bool {\n    return " + tt.expr + ";\n}\n"
+			result, err := Compile([]byte(source), "test.src", Options{})
+			if tt.want == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", result.Diagnostics)
+				}
+				return
+			}
+			if err == nil || len(result.Diagnostics) == 0 {
+				t.Fatalf("expected %q, got %v", tt.want, result.Diagnostics)
+			}
+			d := result.Diagnostics[0]
+			if got := fmt.Sprintf("%s: %s", d.Pos, d.Message); got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+
+	// The error spans the whole chain
+	source := "package main\n\nfunc f(a int, b int, c int) bool {\n    return a < b < c;\n}\n"
+	result, _ := Compile([]byte(source), "test.src", Options{})
+	if len(result.Diagnostics) != 1 {
+		t.Fatalf("expected one error, got %v", result.Diagnostics)
+	}
+	if d := result.Diagnostics[0]; d.Pos.Column != 12 || d.End.Line != 4 || d.End.Column != 21 {
+		t.Errorf("expected the span 4:12 to 4:21, got %s to %s", d.Pos, d.End)
+	}
+}
+
+func TestCompile_ConstantOverflow(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+		want string // the warning's message, "" for none
+	}{
+		{"addition", "9223372036854775807 + 1", "constant 9223372036854775808 overflows int (wraps to -9223372036854775808)"},
+		{"subtraction", "-9223372036854775807 - 2", "constant -9223372036854775809 overflows int (wraps to 9223372036854775807)"},
+		{"multiplication", "3000000000 * 4000000000", "constant 12000000000000000000 overflows int (wraps to -6446744073709551616)"},
+		{"smallest int", "-9223372036854775807 - 1", ""},
+		{"smallest int / -1", "(-9223372036854775807 - 1) / -1", "constant 9223372036854775808 overflows int (wraps to -9223372036854775808)"},
+		{"negating the smallest int", "-(-9223372036854775807 - 1)", "constant 9223372036854775808 overflows int (wraps to -9223372036854775808)"},
+		{"shift into the sign bit", "1 << 63", "constant 9223372036854775808 overflows int (wraps to -9223372036854775808)"},
+		{"shift by 64 or more", "3 << 100", "constant 3 << 100 overflows int (wraps to 0)"},
+		{"shift in range", "1 << 62", ""},
+		{"zero shifted", "0 << 100", ""},
+		{"no overflow", "9223372036854775806 + 1", ""},
+		{"one warning per wrap", "(9223372036854775807 + 1) - 1", "constant 9223372036854775808 overflows int (wraps to -9223372036854775808)"},
+		{"variable operand", "n + 9223372036854775807", ""},
+		{"division by zero", "1 / 0", ""},
+		{"negative shift", "1 << -1", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := "package main\n\nfunc f(n int) int {\n    return " + tt.expr + ";\n}\n"
+			result, err := Compile([]byte(source), "test.src", Options{StopAfter: PhaseSemantic, ImportRoot: importRoot})
+			if err != nil {
+				t.Fatalf("an overflow must not fail compilation: %v", err)
+			}
+			if tt.want == "" {
+				if len(result.Warnings) != 0 {
+					t.Errorf("expected no warnings, got %v", result.Warnings)
+				}
+				return
+			}
+			if len(result.Warnings) != 1 {
+				t.Fatalf("expected 1 warning, got %v", result.Warnings)
+			}
+			if w := result.Warnings[0]; w.Code != "W005" || w.Message != tt.want {
+				t.Errorf("expected W005 %q, got %s %q", tt.want, w.Code, w.Message)
+			}
+		})
+	}
+}
+
+func TestCompile_UnusedVariables(t *testing.T) {
+	// Only unused is reported: used is read, and parameters and _ are exempt
+	source := []byte(`package main
+
+func f(param int) int {
+    var used int = 1;
+    var unused int = 2;
+    var _ int = 3;
+    for (var i int = 0; i < 3; i = i + 1) {
+        used = used + i;
+    }
+    return used;
+}
+`)
+	result, err := Compile(source, "test.src", Options{StopAfter: PhaseSemantic, ImportRoot: importRoot})
+	if err != nil {
+		t.Fatalf("warnings must not fail compilation: %v", err)
+	}
+	if len(result.Warnings) != 1 {
+		t.Fatalf("expected 1 warning, got %v", result.Warnings)
+	}
+	w := result.Warnings[0]
+	if w.Code != "W001" || w.Pos.Line != 5 || w.Message != "variable unused declared but not used" {
+		t.Errorf("expected W001 for unused on line 5, got %s on line %d: %s", w.Code, w.Pos.Line, w.Message)
+	}
+
+	// WarningsAsErrors makes the same diagnostic, and only it, an error
+	result, err = Compile(source, "test.src", Options{StopAfter: PhaseSemantic, ImportRoot: importRoot, WarningsAsErrors: true})
+	if err == nil {
+		t.Fatal("expected WarningsAsErrors to fail compilation")
+	}
+	if len(result.Warnings) != 0 || len(result.Diagnostics) != 1 {
+		t.Fatalf("expected 1 diagnostic and no warnings, got diagnostics %v, warnings %v", result.Diagnostics, result.Warnings)
+	}
+	d := result.Diagnostics[0]
+	if !d.IsError() || d.Code != "W001" || d.Pos.Line != 5 || d.Message != "variable unused declared but not used" {
+		t.Errorf("expected W001 for unused on line 5 as an error, got %v", d.Error())
+	}
+}
+
+// importRoot holds the packages the import tests resolve against.
+const importRoot = "testdata/imports"
+
+func TestCompile_Imports(t *testing.T) {
+	source := []byte(`package main
+
+import "geometry"
+
+func main() {
+    var a int = geometry.Area(3, 4);
+    a = a + 1;
+}
+`)
+	result, err := Compile(source, "main.src", Options{ImportRoot: importRoot})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// units is imported by geometry, so it comes first
+	var paths []string
+	for _, pkg := range result.Packages {
+		paths = append(paths, pkg.Path)
+	}
+	if strings.Join(paths, " ") != "units geometry" {
+		t.Errorf("expected packages [units geometry], got %v", paths)
+	}
+
+	// Imported functions and globals are linked in under qualified names,
+	// including the unexported helper Area calls
+	var names []string
+	for _, fn := range result.Module.Functions {
+		names = append(names, fn.Name)
+	}
+	if strings.Join(names, " ") != "main geometry.Area geometry.mul" {
+		t.Errorf("expected functions [main geometry.Area geometry.mul], got %v", names)
+	}
+	if len(result.Module.Globals) != 1 || result.Module.Globals[0].Name != "units.Scale" {
+		t.Errorf("expected the global units.Scale, got %v", result.Module.Globals)
+	}
+	for _, want := range []string{"call geometry.Area", "call geometry.mul", "* units.Scale.0"} {
+		if !strings.Contains(result.UnoptimizedIR, want) {
+			t.Errorf("expected %q in IR:\n%s", want, result.UnoptimizedIR)
+		}
+	}
+}
+
+func TestCompile_UnusedImports(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string // the warning, "" for none
+	}{
+		{
+			name:   "used directly",
+			source: "import \"units\"\n\nfunc f() int {\n    return units.Scale;\n}\n",
+		},
+		{
+			name:   "used through an alias",
+			source: "import u \"units\"\n\nfunc f() int {\n    return u.Scale;\n}\n",
+		},
+		{
+			name:   "blank import",
+			source: "import _ \"units\"\n",
+		},
+		{
+			name:   "never used",
+			source: "import \"units\"\n\nfunc f() {\n}\n",
+			want:   "imported and not used: \"units\"",
+		},
+		{
+			name:   "alias never used",
+			source: "import u \"units\"\n",
+			want:   "imported and not used: \"units\"",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := []byte("package main\n\n" + tt.source)
+			result, err := Compile(source, "test.src", Options{StopAfter: PhaseSemantic, ImportRoot: importRoot})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.want == "" {
+				if len(result.Warnings) != 0 {
+					t.Errorf("expected no warnings, got %v", result.Warnings)
+				}
+				return
+			}
+			if len(result.Warnings) != 1 {
+				t.Fatalf("expected 1 warning, got %v", result.Warnings)
+			}
+			if w := result.Warnings[0]; w.Code != "W002" || w.Message != tt.want || w.Pos.Line != 3 {
+				t.Errorf("expected W002 %q on line 3, got %s %q on line %d", tt.want, w.Code, w.Message, w.Pos.Line)
+			}
+		})
+	}
+}
+
+func TestCompile_ImportErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		body  string
+		want  string
+		notes bool // whether the imported package's errors are attached
+	}{
+		{
+			name: "missing member",
+			body: "import \"geometry\"\n\nfunc f() int {\n    return geometry.Volume(1, 2);\n}\n",
+			want: "package geometry has no member Volume; available: Area",
+		},
+		{
+			name: "unexported member",
+			body: "import \"geometry\"\n\nfunc f() int {\n    return geometry.mul(1, 2);\n}\n",
+			want: "mul is not exported by package geometry; available: Area",
+		},
+		{
+			name: "aliased import",
+			body: "import g \"geometry\"\n\nfunc f() int {\n    return geometry.Area(1, 2);\n}\n",
+			want: "undefined: geometry",
+		},
+		{
+			name: "missing package",
+			body: "import \"nowhere\"\n",
+			want: "cannot find package nowhere",
+		},
+		{
+			name:  "import cycle",
+			body:  "import \"cycle/a\"\n",
+			want:  "import cycle not allowed: cycle/a -> cycle/b -> cycle/a",
+			notes: true,
+		},
+		{
+			name:  "package imports itself",
+			body:  "import \"cycle/self\"\n",
+			want:  "import cycle not allowed: cycle/self -> cycle/self",
+			notes: true,
+		},
+		{
+			name: "self import",
+			body: "import \"main\"\n",
+			want: "import cycle not allowed: package main imports itself",
+		},
+		{
+			name:  "package with errors",
+			body:  "import \"broken\"\n",
+			want:  "could not import broken: " + filepath.Join(importRoot, "broken", "broken.src") + ":4:12: undefined: missing",
+			notes: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := []byte("package main\n\n" + tt.body)
+			result, err := Compile(source, "main.src", Options{ImportRoot: importRoot})
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+			if len(result.Diagnostics) == 0 {
+				t.Fatalf("expected diagnostics")
+			}
+			diag := result.Diagnostics[0]
+			if !strings.Contains(diag.Message, tt.want) {
+				t.Errorf("expected %q in %q", tt.want, diag.Message)
+			}
+			if (len(diag.Notes) > 0) != tt.notes {
+				t.Errorf("expected notes %v, got %v", tt.notes, diag.Notes)
+			}
+		})
+	}
+}
+
+func TestCompile_SelfImport(t *testing.T) {
+	// The cycle is the only error: the import doesn't also declare a
+	// package main that collides with func main
+	source := []byte("package main\n\nimport \"main\"\n\nfunc main() {\n}\n")
+	result, err := Compile(source, "main.src", Options{ImportRoot: importRoot})
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	if len(result.Diagnostics) != 1 {
+		t.Fatalf("expected only the import cycle, got %v", result.Diagnostics)
+	}
+	if diag := result.Diagnostics[0]; diag.Message != "import cycle not allowed: package main imports itself" || diag.Pos.Line != 3 {
+		t.Errorf("expected the import cycle on line 3, got %q on line %d", diag.Message, diag.Pos.Line)
+	}
+}
+
+func TestCompile_FailedImport(t *testing.T) {
+	// A package that can't be imported is reported once, at its first
+	// import. Its uses are not errors of their own.
+	const missing = "main.src:3:8: cannot find package nowhere"
+	tests := []struct {
+		name    string
+		sources []string
+		want    []string // every diagnostic, "file:line:col: message" prefixes
+	}{
+		{
+			name: "uses",
+			sources: []string{"package main\n\nimport \"nowhere\"\n\nfunc main() {\n" +
+				"    var x int = nowhere.X;\n" +
+				"    x = nowhere.F(x) + 1;\n" +
+				"    println(nowhere.G());\n" +
+				"    if (nowhere.Ok) {\n" +
+				"        println(x);\n" +
+				"    }\n" +
+				"}\n"},
+			want: []string{missing},
+		},
+		{
+			name:    "aliased",
+			sources: []string{"package main\n\nimport n \"nowhere\"\n\nfunc main() {\n    n.F();\n}\n"},
+			want:    []string{"main.src:3:10: cannot find package nowhere"},
+		},
+		{
+			name:    "imported twice",
+			sources: []string{"package main\n\nimport \"nowhere\"\nimport \"nowhere\"\n\nfunc main() {\n}\n"},
+			want:    []string{missing, "main.src:4:1: symbol nowhere already declared at main.src:3:1"},
+		},
+		{
+			name: "imported by two files",
+			sources: []string{
+				"package main\n\nimport \"nowhere\"\n\nfunc main() {\n    nowhere.F();\n}\n",
+				"package main\n\nimport \"nowhere\"\n\nfunc g() {\n    nowhere.G();\n}\n",
+			},
+			want: []string{missing},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sources []Source
+			for i, text := range tt.sources {
+				filename := "main.src"
+				if i > 0 {
+					filename = fmt.Sprintf("other%d.src", i)
+				}
+				sources = append(sources, Source{Filename: filename, Text: []byte(text)})
+			}
+			result, err := CompilePackage(sources, Options{ImportRoot: importRoot})
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+			var got []string
+			for _, diag := range result.Diagnostics {
+				got = append(got, fmt.Sprintf("%s: %s", diag.Pos, diag.Message))
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("expected %d diagnostics, got %q", len(tt.want), got)
+			}
+			for i, want := range tt.want {
+				if !strings.HasPrefix(got[i], want) {
+					t.Errorf("diagnostic %d: expected %q, got %q", i, want, got[i])
+				}
+			}
+		})
+	}
+}
+
+func TestCompile_Builtins(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string // expected error message, or "" for success
+	}{
+		{name: "print int", body: "print(1);"},
+		{name: "println string", body: `println("hi");`},
+		{name: "print char", body: "print('c');"},
+		{name: "shadowed", body: "var print int = 1;\n    var x int = print;"},
+		{
+			name: "struct argument",
+			body: "var p Point = Point{x: 1};\n    println(p);",
+			want: "cannot println value of type struct Point: println accepts int, float, bool, string or char",
+		},
+		{
+			name: "array argument",
+			body: "print([1, 2]);",
+			want: "cannot print value of type [2]int",
+		},
+		{name: "no arguments", body: "println();", want: "println expects 1 argument, got 0"},
+		{name: "two arguments", body: "print(1, 2);", want: "print expects 1 argument, got 2"},
+		{name: "used as value", body: "var f int = print;", want: "print (built-in function) must be called"},
+
+		{name: "len of an array", body: "var n int = len([1, 2, 3]);"},
+		{name: "len of a string", body: `var n int = len("abc");`},
+		{name: "cap of an array", body: "var n int = cap([1, 2, 3]);"},
+		{name: "len is an int", body: `var n int8 = len("abc");`, want: "cannot assign int to int8"},
+		{name: "len of an int", body: "var n int = len(5);", want: "invalid argument to len: int, expected an array or a string"},
+		{name: "cap of a string", body: `var n int = cap("abc");`, want: "invalid argument to cap: string, expected an array"},
+		{name: "len without arguments", body: "var n int = len();", want: "len expects 1 argument, got 0"},
+		{name: "len of two", body: `var n int = len("a", "b");`, want: "len expects 1 argument, got 2"},
+
+		{name: "new int", body: "var p *int = new(int);\n    *p = 1;"},
+		{name: "new struct", body: "var p *Point = new(Point);\n    (*p).x = 1;"},
+		{name: "new pointer", body: "var pp **int = new(*int);"},
+		{name: "new is a pointer", body: "var p int = new(int);", want: "cannot assign *int to int"},
+		{name: "new of a value", body: "var n int = 1;\n    var p *int = new(n);", want: "n is not a type"},
+		{name: "new of a literal", body: "var p *int = new(5);", want: "invalid type expression"},
+		{name: "new of void", body: "new(void);", want: "cannot allocate a value of type void"},
+		{name: "new of two", body: "new(int, int);", want: "new expects 1 argument, got 2"},
+		{name: "no make yet", body: "make(int, 5);", want: "undefined: make"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := "package main\n\nstruct Point {\n    x int;\n}\n\nfunc main() {\n    " + tt.body + "\n}\n"
+			result, err := Compile([]byte(source), "test.src", Options{})
+			if tt.want == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("expected error %q", tt.want)
+			}
+			for _, diag := range result.Diagnostics {
+				if strings.Contains(diag.Message, tt.want) {
+					return
+				}
+			}
+			t.Errorf("expected error containing %q, got %v", tt.want, result.Diagnostics)
+		})
+	}
+}
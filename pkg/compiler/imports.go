@@ -0,0 +1,140 @@
+package compiler
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hassan/compiler/internal/semantic"
+)
+
+// ImportError reports an imported package that failed to compile.
+//
+// Unwrap exposes the package's own diagnostics, which the analyzer attaches
+// as notes to the error at the import declaration.
+type ImportError struct {
+	Path   string
+	Errors []error
+}
+
+// Error names the package and its first problem.
+func (e *ImportError) Error() string {
+	msg := fmt.Sprintf("could not import %s", e.Path)
+	if len(e.Errors) == 0 {
+		return msg
+	}
+	msg += ": " + e.Errors[0].Error()
+	if len(e.Errors) > 1 {
+		msg += fmt.Sprintf(" (and %d more)", len(e.Errors)-1)
+	}
+	return msg
+}
+
+// Unwrap returns the imported package's diagnostics.
+func (e *ImportError) Unwrap() []error {
+	return e.Errors
+}
+
+// dirImporter loads imported packages from directories under a root.
+//
+// The import path "shapes/circle" names the directory root/shapes/circle,
+// and every .src file directly inside it belongs to the package - the same
+// rule the command-line driver applies to a directory argument.
+//
+// Each package is compiled at most once per compilation however many files
+// import it. Packages are recorded in the order they finish, which puts every
+// package after the packages it imports.
+type dirImporter struct {
+	root string
+
+	// packages caches finished imports by path
+	packages map[string]*semantic.Package
+
+	// loading is the chain of imports currently being analyzed, for cycle
+	// detection: importing a path already on the chain would never finish.
+	loading []string
+
+	// order lists the finished packages, dependencies first
+	order []*semantic.Package
+}
+
+func newDirImporter(root string) *dirImporter {
+	return &dirImporter{
+		root:     root,
+		packages: make(map[string]*semantic.Package),
+	}
+}
+
+// Import implements semantic.Importer.
+func (imp *dirImporter) Import(importPath string) (*semantic.Package, error) {
+	if pkg, ok := imp.packages[importPath]; ok {
+		return pkg, nil
+	}
+	for i, p := range imp.loading {
+		if p == importPath {
+			cycle := append(append([]string{}, imp.loading[i:]...), importPath)
+			return nil, fmt.Errorf("import cycle not allowed: %s", strings.Join(cycle, " -> "))
+		}
+	}
+	if importPath == "" || path.IsAbs(importPath) || path.Clean(importPath) != importPath ||
+		strings.HasPrefix(importPath, "..") {
+		return nil, fmt.Errorf("invalid import path %q", importPath)
+	}
+
+	dir := filepath.Join(imp.root, filepath.FromSlash(importPath))
+	sources, err := readPackageDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("cannot find package %s in %s", importPath, dir)
+	}
+
+	imp.loading = append(imp.loading, importPath)
+	defer func() { imp.loading = imp.loading[:len(imp.loading)-1] }()
+
+	files, parseErrors := parseAll(sources)
+	if len(parseErrors) > 0 {
+		return nil, &ImportError{Path: importPath, Errors: parseErrors}
+	}
+
+	// The imported package resolves its own imports against the same root
+	// and the same cache
+	analyzer := semantic.New()
+	analyzer.SetImporter(imp)
+	if errs := analyzer.AnalyzeFiles(files); len(errs) > 0 {
+		return nil, &ImportError{Path: importPath, Errors: errs}
+	}
+
+	name := files[0].Package.Name.Name
+	if name == "main" {
+		return nil, fmt.Errorf("cannot import %s: it is package main", importPath)
+	}
+
+	pkg := &semantic.Package{Path: importPath, Name: name, Files: files, Analyzer: analyzer}
+	imp.packages[importPath] = pkg
+	imp.order = append(imp.order, pkg)
+	return pkg, nil
+}
+
+// readPackageDir reads the .src files directly inside dir, in name order.
+func readPackageDir(dir string) ([]Source, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, "*.src"))
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("%s: no .src files in directory", dir)
+	}
+	sort.Strings(matches)
+
+	sources := make([]Source, len(matches))
+	for i, match := range matches {
+		text, err := os.ReadFile(match)
+		if err != nil {
+			return nil, err
+		}
+		sources[i] = Source{Filename: match, Text: text}
+	}
+	return sources, nil
+}